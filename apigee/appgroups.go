@@ -0,0 +1,188 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apigee
+
+import (
+	"net/url"
+	"path"
+)
+
+const appGroupsPath = "appgroups"
+
+// AppGroupsService is an interface for interfacing with the Apigee X Admin API
+// dealing with AppGroups and their apps.
+type AppGroupsService interface {
+	Create(appGroup AppGroup) (*Response, error)
+	Get(appGroupName string) (*AppGroup, *Response, error)
+	CreateApp(appGroupName string, app AppGroupApp) (*AppGroupApp, *Response, error)
+	GetApp(appGroupName, appName string) (*AppGroupApp, *Response, error)
+	CreateKey(appGroupName, appName, consumerKey, consumerSecret string) (*AppCredential, *Response, error)
+	AddKeyProducts(appGroupName, appName, consumerKey string, apiProducts, scopes []string) (*Response, error)
+	RevokeKey(appGroupName, appName, consumerKey string) (*Response, error)
+	DeleteKey(appGroupName, appName, consumerKey string) (*Response, error)
+	Delete(appGroupName string) (*Response, error)
+	DeleteApp(appGroupName, appName string) (*Response, error)
+}
+
+// AppGroup represents an Apigee X AppGroup
+type AppGroup struct {
+	Name        string `json:"name,omitempty"`
+	DisplayName string `json:"displayName,omitempty"`
+	AppGroupID  string `json:"appGroupId,omitempty"`
+	Status      string `json:"status,omitempty"`
+}
+
+// AppGroupApp represents an app owned by an AppGroup
+type AppGroupApp struct {
+	Name         string          `json:"name,omitempty"`
+	AppID        string          `json:"appId,omitempty"`
+	AppGroup     string          `json:"appGroup,omitempty"`
+	Status       string          `json:"status,omitempty"`
+	APIProducts  []string        `json:"apiProducts,omitempty"`
+	Scopes       []string        `json:"scopes,omitempty"`
+	Credentials  []AppCredential `json:"credentials,omitempty"`
+	KeyExpiresIn int64           `json:"keyExpiresIn,string,omitempty"` // ms, of the key created with the app
+}
+
+// AppGroupsServiceOp represents AppGroup service operations
+type AppGroupsServiceOp struct {
+	client *EdgeClient
+}
+
+var _ AppGroupsService = &AppGroupsServiceOp{}
+
+func appGroupAppPath(appGroupName, appName string, elem ...string) string {
+	elems := append([]string{appGroupsPath, url.PathEscape(appGroupName), "apps", url.PathEscape(appName)}, elem...)
+	return path.Join(elems...)
+}
+
+// Create creates an AppGroup
+func (s *AppGroupsServiceOp) Create(appGroup AppGroup) (*Response, error) {
+	req, e := s.client.NewRequestNoEnv("POST", appGroupsPath, appGroup)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// Get returns an AppGroup
+func (s *AppGroupsServiceOp) Get(appGroupName string) (*AppGroup, *Response, error) {
+	req, e := s.client.NewRequestNoEnv("GET", path.Join(appGroupsPath, url.PathEscape(appGroupName)), nil)
+	if e != nil {
+		return nil, nil, e
+	}
+	appGroup := AppGroup{}
+	resp, e := s.client.Do(req, &appGroup)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &appGroup, resp, e
+}
+
+// CreateApp creates an AppGroup app, the returned app includes the generated credential
+func (s *AppGroupsServiceOp) CreateApp(appGroupName string, app AppGroupApp) (*AppGroupApp, *Response, error) {
+	req, e := s.client.NewRequestNoEnv("POST", path.Join(appGroupsPath, url.PathEscape(appGroupName), "apps"), app)
+	if e != nil {
+		return nil, nil, e
+	}
+	created := AppGroupApp{}
+	resp, e := s.client.Do(req, &created)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &created, resp, e
+}
+
+// GetApp returns an AppGroup app
+func (s *AppGroupsServiceOp) GetApp(appGroupName, appName string) (*AppGroupApp, *Response, error) {
+	req, e := s.client.NewRequestNoEnv("GET", appGroupAppPath(appGroupName, appName), nil)
+	if e != nil {
+		return nil, nil, e
+	}
+	app := AppGroupApp{}
+	resp, e := s.client.Do(req, &app)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &app, resp, e
+}
+
+// CreateKey adds a consumer key and secret to an AppGroup app, the key is
+// granted no API products until AddKeyProducts
+func (s *AppGroupsServiceOp) CreateKey(appGroupName, appName, consumerKey, consumerSecret string) (*AppCredential, *Response, error) {
+	body := AppCredential{ConsumerKey: consumerKey, ConsumerSecret: consumerSecret}
+	req, e := s.client.NewRequestNoEnv("POST", appGroupAppPath(appGroupName, appName, "keys"), body)
+	if e != nil {
+		return nil, nil, e
+	}
+	created := AppCredential{}
+	resp, e := s.client.Do(req, &created)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &created, resp, e
+}
+
+// AddKeyProducts grants API products to a consumer key of an AppGroup app, along with any OAuth scopes
+func (s *AppGroupsServiceOp) AddKeyProducts(appGroupName, appName, consumerKey string, apiProducts, scopes []string) (*Response, error) {
+	body := struct {
+		APIProducts []string `json:"apiProducts"`
+		Scopes      []string `json:"scopes,omitempty"`
+	}{apiProducts, scopes}
+	req, e := s.client.NewRequestNoEnv("POST", appGroupAppPath(appGroupName, appName, "keys", url.PathEscape(consumerKey)), body)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// RevokeKey revokes a consumer key of an AppGroup app
+func (s *AppGroupsServiceOp) RevokeKey(appGroupName, appName, consumerKey string) (*Response, error) {
+	body := struct {
+		Action string `json:"action"`
+	}{"revoke"}
+	req, e := s.client.NewRequestNoEnv("POST", appGroupAppPath(appGroupName, appName, "keys", url.PathEscape(consumerKey)), body)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// DeleteKey deletes a consumer key of an AppGroup app
+func (s *AppGroupsServiceOp) DeleteKey(appGroupName, appName, consumerKey string) (*Response, error) {
+	req, e := s.client.NewRequestNoEnv("DELETE", appGroupAppPath(appGroupName, appName, "keys", url.PathEscape(consumerKey)), nil)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// Delete deletes an AppGroup
+func (s *AppGroupsServiceOp) Delete(appGroupName string) (*Response, error) {
+	req, e := s.client.NewRequestNoEnv("DELETE", path.Join(appGroupsPath, url.PathEscape(appGroupName)), nil)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// DeleteApp deletes an AppGroup app
+func (s *AppGroupsServiceOp) DeleteApp(appGroupName, appName string) (*Response, error) {
+	req, e := s.client.NewRequestNoEnv("DELETE", appGroupAppPath(appGroupName, appName), nil)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
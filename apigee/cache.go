@@ -26,6 +26,7 @@ const cachePath = "caches"
 type CacheService interface {
 	Get(cachename string) (*Cache, *Response, error)
 	Create(cache Cache) (*Response, error)
+	Delete(cachename string) (*Response, error)
 }
 
 // Cache represents a cache definition
@@ -82,3 +83,13 @@ func (s *CacheServiceOp) Create(cache Cache) (*Response, error) {
 	resp, e := s.client.Do(req, &cache)
 	return resp, e
 }
+
+// Delete deletes a cache
+func (s *CacheServiceOp) Delete(cachename string) (*Response, error) {
+	path := path.Join(cachePath, cachename)
+	req, e := s.client.NewRequest("DELETE", path, nil)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
@@ -0,0 +1,146 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apigee
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// decodeDrift lists the keys of a response that don't match a field of the
+// value it is decoded into
+type decodeDrift struct {
+	unknown []string // match no field
+	recased []string // match a field only ignoring case, as Edge and X differ
+}
+
+// decode unmarshals data into v. Like encoding/json, keys match fields
+// ignoring case. If the client is strict, keys matching no field fail the
+// decoding, in debug they are reported to stderr along with recased keys.
+func (c *EdgeClient) decode(data []byte, v interface{}, path string) error {
+	if c.debug || c.strictDecode {
+		var raw interface{}
+		if err := json.Unmarshal(data, &raw); err == nil {
+			d := &decodeDrift{}
+			d.walk(raw, reflect.TypeOf(v), "")
+			if c.debug {
+				for _, k := range d.unknown {
+					fmt.Fprintf(os.Stderr, "warning: %s: unknown field %s\n", path, k)
+				}
+				for _, k := range d.recased {
+					fmt.Fprintf(os.Stderr, "warning: %s: field %s\n", path, k)
+				}
+			}
+			if c.strictDecode && len(d.unknown) > 0 {
+				return fmt.Errorf("decoding %s: unknown fields %s", path, strings.Join(d.unknown, ", "))
+			}
+		}
+	}
+	return json.Unmarshal(data, v)
+}
+
+// walk compares the JSON value raw with type t, keys are listed by their
+// path from the top of the response
+func (d *decodeDrift) walk(raw interface{}, t reflect.Type, at string) {
+	for t.Kind() == reflect.Ptr {
+		if t.Implements(unmarshalerType) {
+			return
+		}
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := raw.(map[string]interface{})
+		if !ok {
+			return
+		}
+		fields := jsonFields(t)
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f, ok := fields[k]
+			if !ok {
+				for name, field := range fields {
+					if strings.EqualFold(name, k) {
+						d.recased = append(d.recased, fmt.Sprintf("%s%s matched as %s", at, k, name))
+						f, ok = field, true
+						break
+					}
+				}
+			}
+			if !ok {
+				d.unknown = append(d.unknown, at+k)
+				continue
+			}
+			d.walk(obj[k], f.Type, at+k+".")
+		}
+	case reflect.Slice, reflect.Array:
+		if arr, ok := raw.([]interface{}); ok {
+			for i, e := range arr {
+				d.walk(e, t.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(at, "."), i))
+			}
+		}
+	case reflect.Map:
+		if obj, ok := raw.(map[string]interface{}); ok {
+			for k, e := range obj {
+				d.walk(e, t.Elem(), at+k+".")
+			}
+		}
+	}
+}
+
+// jsonFields returns the fields of struct t by their JSON names, including
+// those of embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == "-" || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && tag == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for name, ef := range jsonFields(ft) {
+					if _, ok := fields[name]; !ok {
+						fields[name] = ef
+					}
+				}
+				continue
+			}
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		fields[tag] = f
+	}
+	return fields
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apigee
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStrictDecode(t *testing.T) {
+	body := `{"deployments": [
+		{"environment": "test", "apiproxy": "remote-service", "revision": "3", "pods": []}
+	], "nextPageToken": ""}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer ts.Close()
+
+	get := func(strict bool) (*GCPDeployments, error) {
+		client, err := NewEdgeClient(&EdgeClientOptions{
+			MgmtURL:      ts.URL,
+			Org:          "org",
+			Env:          "test",
+			Auth:         &EdgeAuth{SkipAuth: true},
+			StrictDecode: strict,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := client.NewRequest(http.MethodGet, "deployments", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deployments := &GCPDeployments{}
+		_, err = client.Do(req, deployments)
+		return deployments, err
+	}
+
+	deployments, err := get(false)
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if len(deployments.Deployments) != 1 || deployments.Deployments[0].Name != "remote-service" {
+		t.Errorf("want deployment of remote-service decoded ignoring case, got %#v", deployments)
+	}
+
+	_, err = get(true)
+	if err == nil || !strings.Contains(err.Error(), "unknown fields deployments[0].pods, nextPageToken") {
+		t.Errorf("want unknown fields error, got %v", err)
+	}
+
+	var raw interface{}
+	if err := json.Unmarshal([]byte(body), &raw); err != nil {
+		t.Fatal(err)
+	}
+	d := &decodeDrift{}
+	d.walk(raw, reflect.TypeOf(&GCPDeployments{}), "")
+	if want := []string{"deployments[0].apiproxy matched as apiProxy"}; !reflect.DeepEqual(d.recased, want) {
+		t.Errorf("want recased %v, got %v", want, d.recased)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apigee
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+const developersPath = "developers"
+
+// DeveloperAppsService is an interface for interfacing with the Apigee Edge Admin API
+// dealing with developer apps.
+type DeveloperAppsService interface {
+	CreateDeveloper(developer Developer) (*Response, error)
+	Create(developerEmail string, app DeveloperApp) (*DeveloperApp, *Response, error)
+	Get(developerEmail, appName string) (*DeveloperApp, *Response, error)
+	CreateKey(developerEmail, appName, consumerKey, consumerSecret string) (*AppCredential, *Response, error)
+	AddKeyProducts(developerEmail, appName, consumerKey string, apiProducts, scopes []string) (*Response, error)
+	RevokeKey(developerEmail, appName, consumerKey string) (*Response, error)
+	DeleteKey(developerEmail, appName, consumerKey string) (*Response, error)
+	Delete(developerEmail, appName string) (*Response, error)
+	DeleteDeveloper(developerEmail string) (*Response, error)
+}
+
+// Developer represents an Apigee developer
+type Developer struct {
+	Email     string `json:"email,omitempty"`
+	FirstName string `json:"firstName,omitempty"`
+	LastName  string `json:"lastName,omitempty"`
+	UserName  string `json:"userName,omitempty"`
+}
+
+// DeveloperApp represents an Apigee developer app
+type DeveloperApp struct {
+	Name         string          `json:"name,omitempty"`
+	AppID        string          `json:"appId,omitempty"`
+	DeveloperID  string          `json:"developerId,omitempty"`
+	Status       string          `json:"status,omitempty"`
+	APIProducts  []string        `json:"apiProducts,omitempty"`
+	Scopes       []string        `json:"scopes,omitempty"`
+	Credentials  []AppCredential `json:"credentials,omitempty"`
+	KeyExpiresIn int64           `json:"keyExpiresIn,string,omitempty"` // ms, of the key created with the app
+}
+
+// AppCredential is a consumer key and secret of an app
+type AppCredential struct {
+	ConsumerKey    string                 `json:"consumerKey,omitempty"`
+	ConsumerSecret string                 `json:"consumerSecret,omitempty"`
+	IssuedAt       Timestamp              `json:"issuedAt,omitempty"`
+	ExpiresAt      Timestamp              `json:"expiresAt,omitempty"`
+	Status         string                 `json:"status,omitempty"`
+	Scopes         []string               `json:"scopes,omitempty"`
+	APIProducts    []AppCredentialProduct `json:"apiProducts,omitempty"`
+}
+
+// AppCredentialProduct is the status of an API product on a credential
+type AppCredentialProduct struct {
+	APIProduct string `json:"apiproduct,omitempty"`
+	Status     string `json:"status,omitempty"`
+}
+
+// DeveloperAppsServiceOp represents developer app service operations
+type DeveloperAppsServiceOp struct {
+	client *EdgeClient
+}
+
+var _ DeveloperAppsService = &DeveloperAppsServiceOp{}
+
+func appPath(developerEmail, appName string, elem ...string) string {
+	elems := append([]string{developersPath, url.PathEscape(developerEmail), "apps", url.PathEscape(appName)}, elem...)
+	return path.Join(elems...)
+}
+
+// CreateDeveloper creates a developer
+func (s *DeveloperAppsServiceOp) CreateDeveloper(developer Developer) (*Response, error) {
+	req, e := s.client.NewRequestNoEnv("POST", developersPath, developer)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// Create creates a developer app, the returned app includes the generated credential
+func (s *DeveloperAppsServiceOp) Create(developerEmail string, app DeveloperApp) (*DeveloperApp, *Response, error) {
+	req, e := s.client.NewRequestNoEnv("POST", path.Join(developersPath, url.PathEscape(developerEmail), "apps"), app)
+	if e != nil {
+		return nil, nil, e
+	}
+	created := DeveloperApp{}
+	resp, e := s.client.Do(req, &created)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &created, resp, e
+}
+
+// Get returns a developer app
+func (s *DeveloperAppsServiceOp) Get(developerEmail, appName string) (*DeveloperApp, *Response, error) {
+	req, e := s.client.NewRequestNoEnv("GET", appPath(developerEmail, appName), nil)
+	if e != nil {
+		return nil, nil, e
+	}
+	app := DeveloperApp{}
+	resp, e := s.client.Do(req, &app)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &app, resp, e
+}
+
+// CreateKey adds a consumer key and secret to a developer app, the key is
+// granted no API products until AddKeyProducts
+func (s *DeveloperAppsServiceOp) CreateKey(developerEmail, appName, consumerKey, consumerSecret string) (*AppCredential, *Response, error) {
+	body := AppCredential{ConsumerKey: consumerKey, ConsumerSecret: consumerSecret}
+	req, e := s.client.NewRequestNoEnv("POST", appPath(developerEmail, appName, "keys"), body)
+	if e != nil {
+		return nil, nil, e
+	}
+	created := AppCredential{}
+	resp, e := s.client.Do(req, &created)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &created, resp, e
+}
+
+// AddKeyProducts grants API products to a consumer key of a developer app, along with any OAuth scopes
+func (s *DeveloperAppsServiceOp) AddKeyProducts(developerEmail, appName, consumerKey string, apiProducts, scopes []string) (*Response, error) {
+	body := struct {
+		APIProducts []string `json:"apiProducts"`
+		Scopes      []string `json:"scopes,omitempty"`
+	}{apiProducts, scopes}
+	req, e := s.client.NewRequestNoEnv("POST", appPath(developerEmail, appName, "keys", url.PathEscape(consumerKey)), body)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// RevokeKey revokes a consumer key of a developer app
+func (s *DeveloperAppsServiceOp) RevokeKey(developerEmail, appName, consumerKey string) (*Response, error) {
+	u, _ := url.Parse(appPath(developerEmail, appName, "keys", url.PathEscape(consumerKey)))
+	q := u.Query()
+	q.Set("action", "revoke")
+	u.RawQuery = q.Encode()
+	req, e := s.client.NewRequestNoEnv("POST", u.String(), strings.NewReader(""))
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// DeleteKey deletes a consumer key of a developer app
+func (s *DeveloperAppsServiceOp) DeleteKey(developerEmail, appName, consumerKey string) (*Response, error) {
+	req, e := s.client.NewRequestNoEnv("DELETE", appPath(developerEmail, appName, "keys", url.PathEscape(consumerKey)), nil)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// Delete deletes a developer app
+func (s *DeveloperAppsServiceOp) Delete(developerEmail, appName string) (*Response, error) {
+	req, e := s.client.NewRequestNoEnv("DELETE", appPath(developerEmail, appName), nil)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// DeleteDeveloper deletes a developer, the developer must not own any apps
+func (s *DeveloperAppsServiceOp) DeleteDeveloper(developerEmail string) (*Response, error) {
+	req, e := s.client.NewRequestNoEnv("DELETE", path.Join(developersPath, url.PathEscape(developerEmail)), nil)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
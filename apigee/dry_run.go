@@ -0,0 +1,118 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apigee
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const dryRunSummaryLength = 120
+
+// sensitive fields, also redacted as the value of a {"name": ..., "value": ...} entry
+var sensitiveFields = map[string]bool{
+	"secret":         true,
+	"consumerSecret": true,
+	"password":       true,
+	"private_key":    true,
+	"access_token":   true,
+	"refresh_token":  true,
+	"id_token":       true,
+}
+
+// isDryRun is true if req would modify resources and the client is in dry run mode
+func (c *EdgeClient) isDryRun(req *http.Request) bool {
+	return c.dryRun != nil && req.Method != http.MethodGet && req.Method != http.MethodHead
+}
+
+// doDryRun reports req instead of sending it and returns a
+// synthetic 201 Created response without a body
+func (c *EdgeClient) doDryRun(req *http.Request) *Response {
+	summary := requestSummary(req)
+	if summary == "" {
+		c.dryRun("%s %s", req.Method, req.URL)
+	} else {
+		c.dryRun("%s %s %s", req.Method, req.URL, summary)
+	}
+	return newResponse(&http.Response{
+		Status:     "201 Created",
+		StatusCode: http.StatusCreated,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	})
+}
+
+// requestSummary returns compact JSON, truncated if long, or the size of other payloads
+func requestSummary(req *http.Request) string {
+	if req.Body == nil {
+		return ""
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return fmt.Sprintf("<unreadable payload: %v>", err)
+	}
+	if len(data) == 0 {
+		return ""
+	}
+
+	ctype := req.Header.Get("Content-Type")
+	if ctype == appJSON {
+		var v interface{}
+		if err := json.Unmarshal(data, &v); err == nil {
+			var buf bytes.Buffer
+			encoder := json.NewEncoder(&buf)
+			encoder.SetEscapeHTML(false)
+			if err := encoder.Encode(Redact(v)); err == nil {
+				s := strings.TrimSpace(buf.String())
+				if len(s) > dryRunSummaryLength {
+					s = s[:dryRunSummaryLength] + "..."
+				}
+				return s
+			}
+		}
+	}
+	if i := strings.Index(ctype, ";"); i > 0 {
+		ctype = ctype[:i]
+	}
+	return fmt.Sprintf("<%d bytes %s>", len(data), ctype)
+}
+
+// Redact replaces the values of sensitive fields in decoded JSON
+func Redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if name, ok := v["name"].(string); ok && sensitiveFields[name] && v["value"] != nil {
+			v["value"] = "<redacted>"
+		}
+		for k, e := range v {
+			if sensitiveFields[k] {
+				v[k] = "<redacted>"
+			} else {
+				v[k] = Redact(e)
+			}
+		}
+	case []interface{}:
+		for i, e := range v {
+			v[i] = Redact(e)
+		}
+	}
+	return v
+}
@@ -18,17 +18,21 @@ package apigee
 import (
 	"bytes"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"os"
 	"path"
+	"strconv"
+	"time"
 
 	"github.com/bgentry/go-netrc/netrc"
 )
@@ -39,6 +43,9 @@ const (
 	userAgent      = "go-apigee-edge/" + libraryVersion
 	appJSON        = "application/json"
 	octetStream    = "application/octet-stream"
+
+	defaultRetryBackoff = time.Second
+	maxRetryBackoff     = time.Minute
 )
 
 // EdgeClient manages communication with Apigee Edge V1 Admin API.
@@ -46,8 +53,20 @@ type EdgeClient struct {
 	// HTTP client used to communicate with the Edge API.
 	client *http.Client
 
-	auth  *EdgeAuth
-	debug bool
+	auth         *EdgeAuth
+	debug        bool
+	strictDecode bool
+	dryRun       func(format string, args ...interface{})
+
+	retries      int
+	retryBackoff time.Duration
+	sleep        func(time.Duration)
+	rateLimits   *RateLimits
+	plan         *Plan
+
+	reauth    bool
+	fromNetrc bool   // auth was read from a netrc file
+	netrcPath string // of auth, empty for the default
 
 	// Base URL for API requests.
 	BaseURL *url.URL
@@ -64,6 +83,12 @@ type EdgeClient struct {
 	KVMService KVMService
 
 	CacheService CacheService
+
+	DeveloperApps DeveloperAppsService
+
+	AppGroups AppGroupsService
+
+	ResourceFiles ResourceFilesService
 	// Account           AccountService
 	// Actions           ActionsService
 	// Domains           DomainsService
@@ -135,13 +160,48 @@ type EdgeClientOptions struct {
 	Auth *EdgeAuth
 
 	// Optional. Warning: if set to true, HTTP Basic Auth base64 blobs will appear in output.
+	// Response fields unknown to the client are reported too.
 	Debug bool
 
+	// Optional. Fail decoding responses with fields unknown to the client, see decode.
+	StrictDecode bool
+
 	// Optional. For hybrid and NG must be true.
 	GCPManaged bool
 
 	// Optional. Skip cert verification.
 	InsecureSkipVerify bool
+
+	// Optional. Proxy for all requests, HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honored otherwise.
+	ProxyURL *url.URL
+
+	// Optional. CAs trusted to verify server certs, the system's otherwise.
+	RootCAs *x509.CertPool
+
+	// Optional. Client certificates presented to servers requesting one (mTLS).
+	ClientCertificates []tls.Certificate
+
+	// Optional. Sends all requests, the options of the transport above are ignored if set.
+	Transport http.RoundTripper
+
+	// Optional. If set, requests that modify resources are reported here instead of being sent.
+	DryRun func(format string, args ...interface{})
+
+	// Optional. Number of times failed requests are retried, see retryable.
+	Retries int
+
+	// Optional. Delay before the first retry, doubled for each further one. Defaults to 1s.
+	RetryBackoff time.Duration
+
+	// Optional. If set, a request answered 401 is sent once more after authenticating
+	// again, see Reauthenticator. Credentials read from a netrc file are read again.
+	Reauthenticate bool
+
+	// Optional. Records the requests sent and the rate limit reported by the responses.
+	RateLimits *RateLimits
+
+	// Optional. Records the requests, in dry run those not sent too.
+	Plan *Plan
 }
 
 // EdgeAuth holds information about how to authenticate to the Edge Management server.
@@ -162,6 +222,20 @@ type EdgeAuth struct {
 
 	// BearerToken token for OAuth or SAML
 	BearerToken string
+
+	// Optional. Provides a fresh bearer token for each request, takes precedence over BearerToken.
+	TokenSource TokenSource
+}
+
+// TokenSource returns a valid OAuth token, refreshing it as needed
+type TokenSource interface {
+	Token() (string, error)
+}
+
+// Reauthenticator is a TokenSource that can discard its token, so the next
+// call of Token authenticates again
+type Reauthenticator interface {
+	Reauthenticate()
 }
 
 // ApplyTo applies the auth info onto a request
@@ -195,9 +269,20 @@ func retrieveAuthFromNetrc(netrcPath, host string) (*EdgeAuth, error) {
 func NewEdgeClient(o *EdgeClientOptions) (*EdgeClient, error) {
 	httpClient := http.DefaultClient
 
-	if o.InsecureSkipVerify {
+	if o.Transport != nil {
+		httpClient = &http.Client{Transport: o.Transport}
+	} else if o.InsecureSkipVerify || o.ProxyURL != nil || o.RootCAs != nil || len(o.ClientCertificates) > 0 {
 		tr := http.DefaultTransport.(*http.Transport).Clone()
-		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		if o.InsecureSkipVerify || o.RootCAs != nil || len(o.ClientCertificates) > 0 {
+			tr.TLSClientConfig = &tls.Config{
+				InsecureSkipVerify: o.InsecureSkipVerify,
+				RootCAs:            o.RootCAs,
+				Certificates:       o.ClientCertificates,
+			}
+		}
+		if o.ProxyURL != nil {
+			tr.Proxy = http.ProxyURL(o.ProxyURL)
+		}
 		httpClient = &http.Client{Transport: tr}
 	}
 
@@ -223,20 +308,36 @@ func NewEdgeClient(o *EdgeClientOptions) (*EdgeClient, error) {
 		BaseURLEnv:   baseURLEnv,
 		UserAgent:    userAgent,
 		IsGCPManaged: o.GCPManaged,
+		strictDecode: o.StrictDecode,
+		dryRun:       o.DryRun,
+		retries:      o.Retries,
+		retryBackoff: o.RetryBackoff,
+		sleep:        time.Sleep,
+		reauth:       o.Reauthenticate,
+		rateLimits:   o.RateLimits,
+		plan:         o.Plan,
+	}
+	if c.retryBackoff <= 0 {
+		c.retryBackoff = defaultRetryBackoff
 	}
 	c.Proxies = &ProxiesServiceOp{client: c}
 	c.KVMService = &KVMServiceOp{client: c}
 	c.CacheService = &CacheServiceOp{client: c}
+	c.DeveloperApps = &DeveloperAppsServiceOp{client: c}
+	c.AppGroups = &AppGroupsServiceOp{client: c}
+	c.ResourceFiles = &ResourceFilesServiceOp{client: c}
 
 	if !o.Auth.SkipAuth {
 		var e error
-		if o.Auth == nil || (o.Auth.Password == "" && o.Auth.BearerToken == "") {
+		if o.Auth == nil || (o.Auth.Password == "" && o.Auth.BearerToken == "" && o.Auth.TokenSource == nil) {
 			c.auth, e = retrieveAuthFromNetrc(o.Auth.NetrcPath, baseURL.Host)
+			c.fromNetrc, c.netrcPath = true, o.Auth.NetrcPath
 		} else {
 			c.auth = &EdgeAuth{
 				Username:    o.Auth.Username,
 				Password:    o.Auth.Password,
 				BearerToken: o.Auth.BearerToken,
+				TokenSource: o.Auth.TokenSource,
 			}
 		}
 		if e != nil {
@@ -296,6 +397,14 @@ func (c *EdgeClient) newRequest(method, urlStr string, body interface{}, include
 		case io.Reader:
 			ctype = octetStream
 			req, err = http.NewRequest(method, u.String(), body)
+			// files and such can be rewound to be sent again on retries
+			if rs, ok := body.(io.ReadSeeker); ok && err == nil && req.GetBody == nil {
+				req.Body = ioutil.NopCloser(rs)
+				req.GetBody = func() (io.ReadCloser, error) {
+					_, err := rs.Seek(0, io.SeekStart)
+					return ioutil.NopCloser(rs), err
+				}
+			}
 		}
 	} else {
 		req, err = http.NewRequest(method, u.String(), nil)
@@ -310,7 +419,13 @@ func (c *EdgeClient) newRequest(method, urlStr string, body interface{}, include
 	}
 	req.Header.Add("Accept", appJSON)
 	req.Header.Add("User-Agent", c.UserAgent)
-	if c.auth != nil {
+	if c.auth != nil && c.auth.TokenSource != nil {
+		token, err := c.auth.TokenSource.Token()
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("Authorization", "Bearer "+token)
+	} else if c.auth != nil {
 		c.auth.ApplyTo(req)
 	}
 	return req, nil
@@ -341,14 +456,24 @@ func debugDump(data []byte, err error) {
 // if an API error has occurred. If v implements the io.Writer interface, the
 // raw response will be written to v, without attempting to decode it.
 func (c *EdgeClient) Do(req *http.Request, v interface{}) (*Response, error) {
-	if c.debug {
-		debugDump(httputil.DumpRequestOut(req, true))
+	if c.plan != nil {
+		c.plan.record(req, !c.isDryRun(req))
+	}
+	if c.isDryRun(req) {
+		return c.doDryRun(req), nil
 	}
 
-	resp, err := c.client.Do(req)
+	resp, err := c.send(req)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode == http.StatusUnauthorized && c.reauthenticate(req) {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp, err = c.send(req); err != nil {
+			return nil, err
+		}
+	}
 	if c.onRequestCompleted != nil {
 		c.onRequestCompleted(req, resp)
 	}
@@ -373,16 +498,146 @@ func (c *EdgeClient) Do(req *http.Request, v interface{}) (*Response, error) {
 				return nil, err
 			}
 		} else {
-			err := json.NewDecoder(resp.Body).Decode(v)
+			data, err := ioutil.ReadAll(resp.Body)
 			if err != nil {
 				return nil, err
 			}
+			if err := c.decode(data, v, req.URL.Path); err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	return response, err
 }
 
+// send sends req, retrying transient failures up to c.retries times with
+// jittered exponential backoff or as told by a Retry-After header
+func (c *EdgeClient) send(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		if c.debug {
+			debugDump(httputil.DumpRequestOut(req, true))
+		}
+		resp, err := c.client.Do(req)
+		if c.rateLimits != nil {
+			c.rateLimits.observe(resp)
+		}
+		if attempt >= c.retries || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		wait := c.backoff(attempt, resp)
+		if resp != nil {
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		c.sleep(wait)
+	}
+}
+
+// reauthenticate authenticates again and prepares req to be sent again,
+// false if it can't be as its body can't be rewound, the credentials are
+// the same as before or req isn't sent to the management API
+func (c *EdgeClient) reauthenticate(req *http.Request) bool {
+	if !c.reauth || c.auth == nil || req.URL.Host != c.BaseURL.Host {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	switch {
+	case c.auth.TokenSource != nil:
+		r, ok := c.auth.TokenSource.(Reauthenticator)
+		if !ok {
+			return false
+		}
+		r.Reauthenticate()
+		token, err := c.auth.TokenSource.Token()
+		if err != nil {
+			return false
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	case c.fromNetrc:
+		auth, err := retrieveAuthFromNetrc(c.netrcPath, c.BaseURL.Host)
+		if err != nil || (auth.Username == c.auth.Username && auth.Password == c.auth.Password) {
+			return false
+		}
+		c.auth = auth
+		req.Header.Del("Authorization")
+		c.auth.ApplyTo(req)
+	default:
+		return false
+	}
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return false
+		}
+		req.Body = body
+	}
+	return true
+}
+
+// retryable reports whether a request may be sent again after a failure:
+// idempotent requests on network errors and 429, 502, 503 or 504 responses,
+// others only on 429 as it's the one known not to have been processed
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	idempotent := false
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		idempotent = true
+	}
+	if err != nil {
+		return idempotent
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// backoff returns the delay before retrying after attempt, Retry-After
+// being honored up to maxRetryBackoff
+func (c *EdgeClient) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if after := resp.Header.Get("Retry-After"); after != "" {
+			if secs, err := strconv.Atoi(after); err == nil && secs >= 0 {
+				if secs > int(maxRetryBackoff/time.Second) {
+					return maxRetryBackoff
+				}
+				return time.Duration(secs) * time.Second
+			}
+			if t, err := http.ParseTime(after); err == nil {
+				wait := time.Until(t)
+				if wait > maxRetryBackoff {
+					return maxRetryBackoff
+				}
+				if wait > 0 {
+					return wait
+				}
+				return 0
+			}
+		}
+	}
+	d := c.retryBackoff << uint(attempt)
+	if d <= 0 || d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	// jitter over the upper half of the delay
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
 func (r *ErrorResponse) Error() string {
 	return fmt.Sprintf("%v %v: %d %v",
 		r.Response.Request.Method, r.Response.Request.URL, r.Response.StatusCode, r.Message)
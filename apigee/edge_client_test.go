@@ -0,0 +1,236 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apigee
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetries(t *testing.T) {
+	var failures []int // statuses returned before succeeding
+	retryAfter := "7"
+	var bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if len(failures) > 0 {
+			status := failures[0]
+			failures = failures[1:]
+			if status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	client, err := NewEdgeClient(&EdgeClientOptions{
+		MgmtURL: ts.URL,
+		Org:     "org",
+		Env:     "env",
+		Auth:    &EdgeAuth{BearerToken: "token"},
+		Retries: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var waits []time.Duration
+	client.sleep = func(d time.Duration) { waits = append(waits, d) }
+
+	send := func(method string, body interface{}, fail ...int) error {
+		failures, bodies, waits = fail, nil, nil
+		req, err := client.NewRequest(method, "caches", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Do(req, nil)
+		return err
+	}
+
+	// backoff doubles with jitter
+	if err := send(http.MethodGet, nil, http.StatusBadGateway, http.StatusServiceUnavailable); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if len(waits) != 2 || waits[0] < 500*time.Millisecond || waits[0] > time.Second ||
+		waits[1] < time.Second || waits[1] > 2*time.Second {
+		t.Errorf("unexpected backoff %v", waits)
+	}
+
+	// retries exhausted
+	if err := send(http.MethodGet, nil, 503, 503, 503); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("want 503 error, got %v", err)
+	}
+	if len(bodies) != 3 {
+		t.Errorf("want 3 attempts, got %d", len(bodies))
+	}
+
+	// POST only when not processed, the body is sent again
+	if err := send(http.MethodPost, map[string]string{"name": "x"}, http.StatusTooManyRequests); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if len(waits) != 1 || waits[0] != 7*time.Second {
+		t.Errorf("want Retry-After honored, got %v", waits)
+	}
+	if len(bodies) != 2 || bodies[0] != bodies[1] || !strings.Contains(bodies[1], `"name":"x"`) {
+		t.Errorf("want body sent again, got %q", bodies)
+	}
+	for _, status := range []int{http.StatusBadGateway, http.StatusServiceUnavailable} {
+		if err := send(http.MethodPost, nil, status); err == nil {
+			t.Errorf("want POST not retried on %d", status)
+		}
+	}
+
+	// Retry-After capped
+	for _, after := range []string{"86400", "99999999999999999", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)} {
+		retryAfter = after
+		if err := send(http.MethodGet, nil, http.StatusTooManyRequests); err != nil {
+			t.Fatalf("want no error: %v", err)
+		}
+		if len(waits) != 1 || waits[0] != maxRetryBackoff {
+			t.Errorf("want Retry-After %s capped to %v, got %v", after, maxRetryBackoff, waits)
+		}
+	}
+
+	// files are rewound
+	file, err := ioutil.TempFile("", "bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err := file.WriteString("bundle"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(http.MethodPut, file, http.StatusGatewayTimeout); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if len(bodies) != 2 || bodies[1] != "bundle" {
+		t.Errorf("want file sent again, got %q", bodies)
+	}
+}
+
+type testTokenSource struct {
+	tokens []string
+}
+
+func (s *testTokenSource) Token() (string, error) { return s.tokens[0], nil }
+
+func (s *testTokenSource) Reauthenticate() { s.tokens = s.tokens[1:] }
+
+func TestReauthenticate(t *testing.T) {
+	valid := "fresh"
+	var auths, bodies []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		auths = append(auths, r.Header.Get("Authorization"))
+		bodies = append(bodies, string(body))
+		if r.Header.Get("Authorization") != "Bearer "+valid && r.Header.Get("Authorization") != basicAuth("me", valid) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	newClient := func(auth *EdgeAuth, reauth bool) *EdgeClient {
+		client, err := NewEdgeClient(&EdgeClientOptions{MgmtURL: ts.URL, Org: "org", Env: "env", Auth: auth, Reauthenticate: reauth})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	send := func(client *EdgeClient) error {
+		auths, bodies = nil, nil
+		req, err := client.NewRequest(http.MethodPost, "caches", map[string]string{"name": "x"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = client.Do(req, nil)
+		return err
+	}
+
+	// the token expired mid-run
+	if err := send(newClient(&EdgeAuth{TokenSource: &testTokenSource{tokens: []string{"expired", "fresh"}}}, true)); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if len(auths) != 2 || auths[1] != "Bearer fresh" || bodies[0] != bodies[1] {
+		t.Errorf("want request sent again with a fresh token, got %q %q", auths, bodies)
+	}
+
+	// not configured, or no new token
+	if err := send(newClient(&EdgeAuth{TokenSource: &testTokenSource{tokens: []string{"expired", "fresh"}}}, false)); err == nil || len(auths) != 1 {
+		t.Errorf("want 401 without reauthenticating, got %v after %d request(s)", err, len(auths))
+	}
+	if err := send(newClient(&EdgeAuth{BearerToken: "expired"}, true)); err == nil || len(auths) != 1 {
+		t.Errorf("want 401 for a static token, got %v after %d request(s)", err, len(auths))
+	}
+
+	// not sent to the management host
+	other := httptest.NewServer(ts.Config.Handler)
+	defer other.Close()
+	tokens := &testTokenSource{tokens: []string{"expired", "fresh"}}
+	auths = nil
+	req, err := http.NewRequest(http.MethodGet, other.URL+"/v1/other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newClient(&EdgeAuth{TokenSource: tokens}, true).Do(req, nil); err == nil || len(auths) != 1 || len(tokens.tokens) != 2 {
+		t.Errorf("want 401 without reauthenticating for another host, got %v after %d request(s)", err, len(auths))
+	}
+
+	// the password of the netrc file was changed
+	dir, err := ioutil.TempDir("", "netrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	netrc := filepath.Join(dir, ".netrc")
+	host := strings.TrimPrefix(ts.URL, "http://")
+	if err := ioutil.WriteFile(netrc, []byte("machine "+host+" login me password old\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(&EdgeAuth{NetrcPath: netrc}, true)
+	if err := ioutil.WriteFile(netrc, []byte("machine "+host+" login me password fresh\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := send(client); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if len(auths) != 2 || auths[1] != basicAuth("me", "fresh") {
+		t.Errorf("want request sent again with the new password, got %q", auths)
+	}
+	valid = "other"
+	if err := send(client); err == nil || len(auths) != 1 {
+		t.Errorf("want 401 for unchanged credentials, got %v after %d request(s)", err, len(auths))
+	}
+}
+
+func basicAuth(username, password string) string {
+	req := &http.Request{Header: http.Header{}}
+	req.SetBasicAuth(username, password)
+	return req.Header.Get("Authorization")
+}
@@ -20,6 +20,9 @@ import (
 
 const kvmPath = "keyvaluemaps"
 
+// MaskedValue is returned by legacy and OPDK in place of the values of an encrypted KVM
+const MaskedValue = "*****"
+
 // KVMService is an interface for interfacing with the Apigee Edge Admin API
 // dealing with kvm.
 type KVMService interface {
@@ -27,6 +30,7 @@ type KVMService interface {
 	Create(kvm KVM) (*Response, error)
 	UpdateEntry(kvmName string, entry Entry) (*Response, error)
 	AddEntry(kvmName string, entry Entry) (*Response, error)
+	Delete(kvmName string) (*Response, error)
 }
 
 // Entry is an entry in the KVM
@@ -52,6 +56,12 @@ func (k *KVM) GetValue(name string) (v string, ok bool) {
 	return
 }
 
+// IsMasked returns true if the value of entry name can't be read as the KVM is encrypted
+func (k *KVM) IsMasked(name string) bool {
+	v, ok := k.GetValue(name)
+	return ok && k.Encrypted && v == MaskedValue
+}
+
 // KVMServiceOp represents a KVM service operation
 type KVMServiceOp struct {
 	client *EdgeClient
@@ -88,7 +98,11 @@ func (s *KVMServiceOp) Create(kvm KVM) (*Response, error) {
 // UpdateEntry updates a KVM entry
 func (s *KVMServiceOp) UpdateEntry(kvmName string, entry Entry) (*Response, error) {
 	path := path.Join(kvmPath, kvmName, "entries", entry.Name)
-	req, e := s.client.NewRequest("POST", path, entry)
+	method := "POST"
+	if s.client.IsGCPManaged {
+		method = "PUT"
+	}
+	req, e := s.client.NewRequest(method, path, entry)
 	if e != nil {
 		return nil, e
 	}
@@ -106,3 +120,13 @@ func (s *KVMServiceOp) AddEntry(kvmName string, entry Entry) (*Response, error)
 	resp, e := s.client.Do(req, &entry)
 	return resp, e
 }
+
+// Delete deletes a KVM
+func (s *KVMServiceOp) Delete(kvmName string) (*Response, error) {
+	path := path.Join(kvmPath, kvmName)
+	req, e := s.client.NewRequest("DELETE", path, nil)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
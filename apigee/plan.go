@@ -0,0 +1,53 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apigee
+
+import (
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Plan records the requests of the clients of a command, in dry run those
+// not sent too
+type Plan struct {
+	mu    sync.Mutex
+	calls []PlannedCall
+}
+
+// PlannedCall is a request recorded by a Plan
+type PlannedCall struct {
+	Method string
+	URL    url.URL
+	Sent   bool // false if in dry run
+}
+
+// NewPlan returns a Plan having recorded nothing
+func NewPlan() *Plan {
+	return &Plan{}
+}
+
+// Calls returns the requests recorded, in order
+func (p *Plan) Calls() []PlannedCall {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]PlannedCall(nil), p.calls...)
+}
+
+func (p *Plan) record(req *http.Request, sent bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls = append(p.calls, PlannedCall{Method: req.Method, URL: *req.URL, Sent: sent})
+}
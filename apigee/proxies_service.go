@@ -38,16 +38,21 @@ const proxiesPath = "apis"
 type ProxiesService interface {
 	// List() ([]string, *Response, error)
 	Get(string) (*Proxy, *Response, error)
+	GetRevision(string, Revision) (*ProxyRevision, *Response, error)
 	Import(proxyName string, source string) (*ProxyRevision, *Response, error)
-	// Delete(string) (*DeletedProxyInfo, *Response, error)
-	// DeleteRevision(string, Revision) (*ProxyRevision, *Response, error)
+	Delete(string) (*DeletedProxyInfo, *Response, error)
+	DeleteRevision(string, Revision) (*ProxyRevision, *Response, error)
 	Deploy(string, string, Revision) (*ProxyRevisionDeployment, *Response, error)
+	DeployWithOptions(string, string, Revision, DeployOptions) (*ProxyRevisionDeployment, *Response, error)
 	Undeploy(string, string, Revision) (*ProxyRevisionDeployment, *Response, error)
-	// Export(string, Revision) (string, *Response, error)
+	Export(string, Revision, io.Writer) (*Response, error)
 	GetDeployment(proxy string) (*EnvironmentDeployment, *Response, error)
+	GetRevisionDeployment(proxy string, rev Revision) (*ProxyRevisionDeployment, *Response, error)
 	GetDeployedRevision(proxy string) (*Revision, error)
 	GetGCPDeployments(proxy string) ([]GCPDeployment, *Response, error)
 	GetGCPDeployedRevision(proxy string) (*Revision, error)
+	ListDeployments() ([]DeployedRevision, *Response, error)
+	GetProxyEndpoint(proxy string, rev Revision, endpoint string) (*ProxyEndpoint, *Response, error)
 }
 
 // ProxiesServiceOp represents operations against Apigee proxies
@@ -104,10 +109,30 @@ type ProxyRevision struct {
 	ProxyEndpoints  []string  `json:"proxyEndpoints,omitempty"`
 	Policies        []string  `json:"policies,omitempty"`
 	Type            string    `json:"type,omitempty"`
+	BasePaths       []string  `json:"basepaths,omitempty"` // of the proxy endpoints on GCP
+}
+
+// ProxyEndpoint is a proxy endpoint of an API Proxy revision (legacy and OPDK)
+type ProxyEndpoint struct {
+	Name       string                  `json:"name,omitempty"`
+	Connection ProxyEndpointConnection `json:"connection,omitempty"`
+}
+
+// ProxyEndpointConnection is where a proxy endpoint is exposed
+type ProxyEndpointConnection struct {
+	BasePath     string   `json:"basePath,omitempty"`
+	VirtualHosts []string `json:"virtualHost,omitempty"`
+}
+
+// DeployedRevision is a revision of an API Proxy deployed to the environment
+type DeployedRevision struct {
+	Name     string
+	Revision Revision
 }
 
 // ProxyRevisionDeployment holds information about the deployment state of a
-// single revision of an API Proxy.
+// single revision of an API Proxy. On GCP, State is READY, PROGRESSING or
+// ERROR and Servers is empty.
 type ProxyRevisionDeployment struct {
 	Name         string       `json:"aPIProxy,omitempty"`
 	Revision     Revision     `json:"revision,omitempty"`
@@ -117,6 +142,55 @@ type ProxyRevisionDeployment struct {
 	Servers      []EdgeServer `json:"server,omitempty"`
 }
 
+// deployment states of the revision and of its servers
+const (
+	gcpDeploymentReady = "READY"
+	gcpDeploymentError = "ERROR"
+	edgeDeployed       = "deployed"
+	edgeDeployError    = "error"
+	messageProcessor   = "message-processor"
+)
+
+// MessageProcessors returns the number of message processors the revision is
+// deployed to and their total, both 0 on GCP
+func (d *ProxyRevisionDeployment) MessageProcessors() (deployed, total int) {
+	for _, s := range d.Servers {
+		for _, t := range s.Type {
+			if t == messageProcessor {
+				total++
+				if s.Status == edgeDeployed {
+					deployed++
+				}
+				break
+			}
+		}
+	}
+	return deployed, total
+}
+
+// IsDeployed reports whether the revision is ready on GCP, or deployed on all
+// message processors otherwise
+func (d *ProxyRevisionDeployment) IsDeployed() bool {
+	if d.State == gcpDeploymentReady {
+		return true
+	}
+	deployed, total := d.MessageProcessors()
+	return d.State == edgeDeployed && deployed == total
+}
+
+// Failed reports whether the deployment of the revision failed
+func (d *ProxyRevisionDeployment) Failed() bool {
+	if d.State == gcpDeploymentError || d.State == edgeDeployError {
+		return true
+	}
+	for _, s := range d.Servers {
+		if s.Status == edgeDeployError {
+			return true
+		}
+	}
+	return false
+}
+
 // EdgeServer is the deployment status for the edge server.
 // When inquiring the deployment status of an API Proxy revision, even implicitly
 // as when performing a Deploy or Undeploy, the response includes the deployment
@@ -192,6 +266,33 @@ func (s *ProxiesServiceOp) Get(proxy string) (*Proxy, *Response, error) {
 	return &returnedProxy, resp, e
 }
 
+// GetRevision retrieves the information about a revision of an API Proxy, including
+// the description from its bundle.
+func (s *ProxiesServiceOp) GetRevision(proxy string, rev Revision) (*ProxyRevision, *Response, error) {
+	urlPath := path.Join(proxiesPath, proxy, "revisions", fmt.Sprintf("%d", rev))
+	req, e := s.client.NewRequestNoEnv("GET", urlPath, nil)
+	if e != nil {
+		return nil, nil, e
+	}
+	proxyRev := ProxyRevision{}
+	resp, e := s.client.Do(req, &proxyRev)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &proxyRev, resp, e
+}
+
+// Export writes the zipped bundle of a revision of an API Proxy to w.
+func (s *ProxiesServiceOp) Export(proxy string, rev Revision, w io.Writer) (*Response, error) {
+	urlPath := path.Join(proxiesPath, proxy, "revisions", fmt.Sprintf("%d", rev)) + "?format=bundle"
+	req, e := s.client.NewRequestNoEnv("GET", urlPath, nil)
+	if e != nil {
+		return nil, e
+	}
+	req.Header.Set("Accept", "application/zip")
+	return s.client.Do(req, w)
+}
+
 func smartFilter(urlPath string) bool {
 	if strings.HasSuffix(urlPath, "~") {
 		return false
@@ -400,21 +501,21 @@ func (s *ProxiesServiceOp) Import(proxyName string, source string) (*ProxyRevisi
 // 	return filename, resp, e
 // }
 
-// // DeleteRevision deletes a specific revision of an API Proxy from an organization.
-// // The revision must exist, and must not be currently deployed.
-// func (s *ProxiesServiceOp) DeleteRevision(proxyName string, rev Revision) (*ProxyRevision, *Response, error) {
-// 	urlPath := path.Join(proxiesPath, proxyName, "revisions", fmt.Sprintf("%d", rev))
-// 	req, e := s.client.NewRequestNoEnv("DELETE", urlPath, nil)
-// 	if e != nil {
-// 		return nil, nil, e
-// 	}
-// 	proxyRev := ProxyRevision{}
-// 	resp, e := s.client.Do(req, &proxyRev)
-// 	if e != nil {
-// 		return nil, resp, e
-// 	}
-// 	return &proxyRev, resp, e
-// }
+// DeleteRevision deletes a specific revision of an API Proxy from an organization.
+// The revision must exist, and must not be currently deployed.
+func (s *ProxiesServiceOp) DeleteRevision(proxyName string, rev Revision) (*ProxyRevision, *Response, error) {
+	urlPath := path.Join(proxiesPath, proxyName, "revisions", fmt.Sprintf("%d", rev))
+	req, e := s.client.NewRequestNoEnv("DELETE", urlPath, nil)
+	if e != nil {
+		return nil, nil, e
+	}
+	proxyRev := ProxyRevision{}
+	resp, e := s.client.Do(req, &proxyRev)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &proxyRev, resp, e
+}
 
 // Undeploy a specific revision of an API Proxy from a particular environment within an Edge organization.
 func (s *ProxiesServiceOp) Undeploy(proxyName, env string, rev Revision) (*ProxyRevisionDeployment, *Response, error) {
@@ -450,8 +551,23 @@ func (s *ProxiesServiceOp) Undeploy(proxyName, env string, rev Revision) (*Proxy
 	return &deployment, resp, err
 }
 
+// DeployOptions are the options of a revision deployment
+type DeployOptions struct {
+	Override         bool // replace the deployed revision (seamlessly on Edge)
+	Delay            int  // seconds Edge waits before undeploying the replaced revision
+	SequencedRollout bool // roll out in sequence across the runtime (GCP only)
+}
+
+// DefaultDeployOptions are the options used by Deploy
+var DefaultDeployOptions = DeployOptions{Override: true, Delay: 12}
+
 // Deploy a revision of an API proxy to a specific environment within an organization.
 func (s *ProxiesServiceOp) Deploy(proxyName, env string, rev Revision) (*ProxyRevisionDeployment, *Response, error) {
+	return s.DeployWithOptions(proxyName, env, rev, DefaultDeployOptions)
+}
+
+// DeployWithOptions deploys a revision of an API proxy to a specific environment within an organization.
+func (s *ProxiesServiceOp) DeployWithOptions(proxyName, env string, rev Revision, opts DeployOptions) (*ProxyRevisionDeployment, *Response, error) {
 	urlPath := path.Join(proxiesPath, proxyName, "revisions", fmt.Sprintf("%d", rev), "deployments")
 	// append the query params
 	origURL, err := url.Parse(urlPath)
@@ -459,10 +575,16 @@ func (s *ProxiesServiceOp) Deploy(proxyName, env string, rev Revision) (*ProxyRe
 		return nil, nil, err
 	}
 	q := origURL.Query()
-	q.Add("override", "true")
-	if !s.client.IsGCPManaged {
+	q.Add("override", strconv.FormatBool(opts.Override))
+	if s.client.IsGCPManaged {
+		if opts.SequencedRollout {
+			q.Add("sequencedRollout", "true")
+		}
+	} else {
 		q.Add("action", "deploy")
-		q.Add("delay", "12")
+		if opts.Override && opts.Delay > 0 {
+			q.Add("delay", strconv.Itoa(opts.Delay))
+		}
 		q.Add("env", env)
 	}
 	origURL.RawQuery = q.Encode()
@@ -481,22 +603,22 @@ func (s *ProxiesServiceOp) Deploy(proxyName, env string, rev Revision) (*ProxyRe
 	return &deployment, resp, e
 }
 
-// // Delete an API Proxy and all its revisions from an organization. This method
-// // will fail if any of the revisions of the named API Proxy are currently deployed
-// // in any environment.
-// func (s *ProxiesServiceOp) Delete(proxyName string) (*DeletedProxyInfo, *Response, error) {
-// 	urlPath := path.Join(proxiesPath, proxyName)
-// 	req, e := s.client.NewRequestNoEnv("DELETE", urlPath, nil)
-// 	if e != nil {
-// 		return nil, nil, e
-// 	}
-// 	proxy := DeletedProxyInfo{}
-// 	resp, e := s.client.Do(req, &proxy)
-// 	if e != nil {
-// 		return nil, resp, e
-// 	}
-// 	return &proxy, resp, e
-// }
+// Delete an API Proxy and all its revisions from an organization. This method
+// will fail if any of the revisions of the named API Proxy are currently deployed
+// in any environment.
+func (s *ProxiesServiceOp) Delete(proxyName string) (*DeletedProxyInfo, *Response, error) {
+	urlPath := path.Join(proxiesPath, proxyName)
+	req, e := s.client.NewRequestNoEnv("DELETE", urlPath, nil)
+	if e != nil {
+		return nil, nil, e
+	}
+	proxy := DeletedProxyInfo{}
+	resp, e := s.client.Do(req, &proxy)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &proxy, resp, e
+}
 
 // GetDeployment retrieves the information about the deployment of an API Proxy in an environment.
 // DOES NOT WORK WITH GCP API!
@@ -517,6 +639,21 @@ func (s *ProxiesServiceOp) GetDeployment(proxy string) (*EnvironmentDeployment,
 	return &deployment, resp, e
 }
 
+// GetRevisionDeployment retrieves the deployment state of a revision of an API Proxy in the environment.
+func (s *ProxiesServiceOp) GetRevisionDeployment(proxy string, rev Revision) (*ProxyRevisionDeployment, *Response, error) {
+	urlPath := path.Join(proxiesPath, proxy, "revisions", fmt.Sprintf("%d", rev), "deployments")
+	req, e := s.client.NewRequest("GET", urlPath, nil)
+	if e != nil {
+		return nil, nil, e
+	}
+	deployment := ProxyRevisionDeployment{}
+	resp, e := s.client.Do(req, &deployment)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &deployment, resp, e
+}
+
 // GetDeployedRevision returns the Revision that is deployed to an environment.
 func (s *ProxiesServiceOp) GetDeployedRevision(proxy string) (*Revision, error) {
 	deployment, resp, err := s.GetDeployment(proxy)
@@ -554,6 +691,61 @@ func (s *ProxiesServiceOp) GetGCPDeployments(proxy string) ([]GCPDeployment, *Re
 	return deployments.Deployments, resp, e
 }
 
+// ListDeployments returns the revisions of all API Proxies deployed to the environment.
+func (s *ProxiesServiceOp) ListDeployments() ([]DeployedRevision, *Response, error) {
+	req, e := s.client.NewRequest("GET", "deployments", nil)
+	if e != nil {
+		return nil, nil, e
+	}
+	var deployed []DeployedRevision
+	if s.client.IsGCPManaged {
+		deployments := GCPDeployments{}
+		resp, e := s.client.Do(req, &deployments)
+		if e != nil {
+			return nil, resp, e
+		}
+		for _, d := range deployments.Deployments {
+			rev, e := strconv.ParseInt(d.Revision, 10, 32)
+			if e != nil {
+				return nil, resp, e
+			}
+			deployed = append(deployed, DeployedRevision{Name: d.Name, Revision: Revision(rev)})
+		}
+		return deployed, resp, nil
+	}
+
+	deployments := struct {
+		Proxies []EnvironmentDeployment `json:"aPIProxy,omitempty"`
+	}{}
+	resp, e := s.client.Do(req, &deployments)
+	if e != nil {
+		return nil, resp, e
+	}
+	for _, p := range deployments.Proxies {
+		for _, r := range p.Revision {
+			if r.State == edgeDeployed {
+				deployed = append(deployed, DeployedRevision{Name: p.Name, Revision: r.Number})
+			}
+		}
+	}
+	return deployed, resp, nil
+}
+
+// GetProxyEndpoint retrieves a proxy endpoint of a revision of an API Proxy (legacy and OPDK).
+func (s *ProxiesServiceOp) GetProxyEndpoint(proxy string, rev Revision, endpoint string) (*ProxyEndpoint, *Response, error) {
+	urlPath := path.Join(proxiesPath, proxy, "revisions", fmt.Sprintf("%d", rev), "proxies", endpoint)
+	req, e := s.client.NewRequestNoEnv("GET", urlPath, nil)
+	if e != nil {
+		return nil, nil, e
+	}
+	proxyEndpoint := ProxyEndpoint{}
+	resp, e := s.client.Do(req, &proxyEndpoint)
+	if e != nil {
+		return nil, resp, e
+	}
+	return &proxyEndpoint, resp, e
+}
+
 // GetGCPDeployedRevision returns the Revision that is deployed to an environment in GCP.
 func (s *ProxiesServiceOp) GetGCPDeployedRevision(proxy string) (*Revision, error) {
 	deployments, resp, err := s.GetGCPDeployments(proxy)
@@ -0,0 +1,104 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apigee
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimits records the requests sent to the management API and the rate
+// limit its responses report, shared by the clients of a command
+type RateLimits struct {
+	mu        sync.Mutex
+	requests  int
+	throttled int // answered 429
+	limit     int // -1 unknown
+	remaining int // -1 unknown
+	reset     time.Time
+	now       func() time.Time
+}
+
+// NewRateLimits returns RateLimits having recorded nothing
+func NewRateLimits() *RateLimits {
+	return &RateLimits{limit: -1, remaining: -1, now: time.Now}
+}
+
+// RateLimitSummary is what RateLimits recorded
+type RateLimitSummary struct {
+	Requests  int       // sent, retries included
+	Throttled int       // answered 429
+	Limit     int       // of the last response reporting it, -1 if none did
+	Remaining int       // of the last response reporting it, -1 if none did
+	Reset     time.Time // of the window of Remaining, zero if not reported
+}
+
+// Summary returns what was recorded so far
+func (r *RateLimits) Summary() RateLimitSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return RateLimitSummary{
+		Requests:  r.requests,
+		Throttled: r.throttled,
+		Limit:     r.limit,
+		Remaining: r.remaining,
+		Reset:     r.reset,
+	}
+}
+
+// observe records a request sent and the X-RateLimit-* or RateLimit-*
+// headers (IETF draft) of its response, if any
+func (r *RateLimits) observe(resp *http.Response) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests++
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		r.throttled++
+	}
+	if limit, ok := rateLimitHeader(resp.Header, "Limit"); ok {
+		r.limit = limit
+	}
+	if remaining, ok := rateLimitHeader(resp.Header, "Remaining"); ok {
+		r.remaining = remaining
+	}
+	if reset, ok := rateLimitHeader(resp.Header, "Reset"); ok {
+		// epoch seconds, as of GitHub and others, or seconds from now
+		if reset > 1000000000 {
+			r.reset = time.Unix(int64(reset), 0)
+		} else {
+			r.reset = r.now().Add(time.Duration(reset) * time.Second)
+		}
+	}
+}
+
+// rateLimitHeader returns the leading integer of X-RateLimit-name or
+// RateLimit-name, the latter may be followed by a policy as "100, 100;w=60"
+func rateLimitHeader(h http.Header, name string) (int, bool) {
+	value := h.Get("X-RateLimit-" + name)
+	if value == "" {
+		value = h.Get("RateLimit-" + name)
+	}
+	if i := strings.IndexAny(value, ",;"); i >= 0 {
+		value = value[:i]
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	return n, err == nil && n >= 0
+}
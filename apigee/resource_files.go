@@ -0,0 +1,70 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apigee
+
+import (
+	"io"
+	"net/url"
+	"path"
+)
+
+const resourceFilesPath = "resourcefiles"
+
+// ResourceFilesService is an interface for interfacing with the Apigee Admin API
+// dealing with environment resource files, such as property sets.
+type ResourceFilesService interface {
+	Create(fileType, name string, content io.Reader) (*Response, error)
+	Update(fileType, name string, content io.Reader) (*Response, error)
+	Delete(fileType, name string) (*Response, error)
+}
+
+// ResourceFilesServiceOp represents resource file service operations
+type ResourceFilesServiceOp struct {
+	client *EdgeClient
+}
+
+var _ ResourceFilesService = &ResourceFilesServiceOp{}
+
+// Create creates an environment resource file
+func (s *ResourceFilesServiceOp) Create(fileType, name string, content io.Reader) (*Response, error) {
+	u, _ := url.Parse(resourceFilesPath)
+	q := u.Query()
+	q.Set("name", name)
+	q.Set("type", fileType)
+	u.RawQuery = q.Encode()
+	req, e := s.client.NewRequest("POST", u.String(), content)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// Update replaces the content of an environment resource file
+func (s *ResourceFilesServiceOp) Update(fileType, name string, content io.Reader) (*Response, error) {
+	req, e := s.client.NewRequest("PUT", path.Join(resourceFilesPath, fileType, name), content)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
+
+// Delete deletes an environment resource file
+func (s *ResourceFilesServiceOp) Delete(fileType, name string) (*Response, error) {
+	req, e := s.client.NewRequest("DELETE", path.Join(resourceFilesPath, fileType, name), nil)
+	if e != nil {
+		return nil, e
+	}
+	return s.client.Do(req, nil)
+}
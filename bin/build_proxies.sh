@@ -17,7 +17,7 @@
 #
 # If you change any proxies:
 # 1. update the returned version(s) in the Send-Version.xml of the affected proxies
-# 2. run this script to generate proxies.go 
+# 2. run this script to generate proxies.go and digests.go
 # 3. run `go mod tidy` to remove the go-bindata dep from your mod and sum files
 # 4. check in your changes
 #
@@ -69,4 +69,22 @@ echo "building ${RESOURCE_FILE}"
 cd "${TEMP_DIR}"
 go-bindata -nomemcopy -pkg "proxies" -prefix "proxies" -o "${RESOURCE_FILE}" proxies
 
+# pin digests, verified before import and printed by version
+DIGESTS_FILE="${ROOTDIR}/proxies/digests.go"
+echo "building ${DIGESTS_FILE}"
+{
+  echo "// Code generated by bin/build_proxies.sh. DO NOT EDIT."
+  echo
+  echo "package proxies"
+  echo
+  echo "// digests are the SHA-256 digests of the embedded proxy bundles"
+  echo "var digests = map[string]string{"
+  cd "${PROXIES_ZIP_DIR}"
+  for ZIP in *.zip; do
+    echo "	\"${ZIP}\": \"$(shasum -a 256 "${ZIP}" | cut -d ' ' -f 1)\","
+  done
+  echo "}"
+} > "${DIGESTS_FILE}"
+gofmt -w "${DIGESTS_FILE}"
+
 echo "done"
@@ -0,0 +1,257 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analytics
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-golib/product"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	productsURLFormat = "/v1/organizations/%s/apiproducts" // ManagementBase
+	timeRangeFormat   = shared.StatsTimeRangeFormat
+
+	metricRequests = "sum(message_count)"
+	metricErrors   = "sum(is_error)"
+
+	formatTable = "table"
+	formatCSV   = "csv"
+)
+
+type analytics struct {
+	*shared.RootArgs
+	since    string
+	products []string
+	format   string
+
+	now func() time.Time
+}
+
+// traffic is the traffic of a remote service target through an API product,
+// the adapter records the target as the API proxy of the analytics
+type traffic struct {
+	Product  string
+	Target   string
+	Requests int64
+	Errors   int64
+}
+
+func (t traffic) errorRate() float64 {
+	if t.Requests == 0 {
+		return 0
+	}
+	return 100 * float64(t.Errors) / float64(t.Requests)
+}
+
+// Cmd returns base command
+func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	a := &analytics{RootArgs: rootArgs, now: time.Now}
+
+	c := &cobra.Command{
+		Use:   "analytics",
+		Short: "Query Apigee analytics of Remote Service traffic",
+		Long:  "Query Apigee analytics of Remote Service traffic.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return rootArgs.Resolve(false, false)
+		},
+	}
+
+	c.PersistentFlags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.PersistentFlags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+	c.PersistentFlags().StringVarP(&rootArgs.ManagementBase, "management", "m",
+		"", "Apigee management base URL (default: hybrid and Apigee X, or the runtime for opdk)")
+	c.PersistentFlags().StringVarP(&rootArgs.Token, "token", "t", "",
+		"Apigee OAuth or SAML token (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.Username, "username", "u", "",
+		"Apigee username (legacy or OPDK only)")
+	c.PersistentFlags().StringVarP(&rootArgs.Password, "password", "p", "",
+		"Apigee password (legacy or OPDK only)")
+
+	c.AddCommand(cmdExport(a, printf))
+
+	return c
+}
+
+func cmdExport(a *analytics, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "export",
+		Short: "Print recent traffic of the API products bound to remote service targets",
+		Long: `Print the requests, errors and error rate per API product and remote service target
+recorded by Apigee analytics over the last --since, confirming that the traffic of the adapter is
+recorded. Analytics may take several minutes to show recent traffic.`,
+		Args: cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if a.Org == "" || a.Env == "" {
+				return fmt.Errorf("--organization and --environment are required")
+			}
+			window, err := shared.ParseDuration(a.since)
+			if err != nil || window <= 0 {
+				return fmt.Errorf("--since must be a positive duration, got %q", a.since)
+			}
+			if a.format != formatTable && a.format != formatCSV {
+				return fmt.Errorf("--format must be %s or %s", formatTable, formatCSV)
+			}
+			cmd.SilenceUsage = true
+			return a.export(window, printf)
+		},
+	}
+
+	c.Flags().StringVarP(&a.since, "since", "", "1h",
+		`window of traffic to report, ending now (eg. "15m", "24h" or "7d")`)
+	c.Flags().StringSliceVarP(&a.products, "product", "", nil,
+		"API products to report (default: all products bound to remote service targets)")
+	c.Flags().StringVarP(&a.format, "format", "", formatTable,
+		"output format: table or csv")
+
+	return c
+}
+
+func (a *analytics) export(window time.Duration, printf shared.FormatFn) error {
+	products, err := a.boundProducts()
+	if err != nil {
+		return err
+	}
+	if len(products) == 0 {
+		return fmt.Errorf("no API products bound to remote service targets")
+	}
+
+	end := a.now().UTC().Truncate(time.Minute)
+	start := end.Add(-window)
+	var rows []traffic
+	for _, p := range products {
+		targets, err := a.targetTraffic(p.Name, start, end)
+		if err != nil {
+			return errors.Wrapf(err, "retrieving analytics of product %s", p.Name)
+		}
+		for _, target := range p.GetBoundTargets() {
+			t := targets[target]
+			rows = append(rows, traffic{Product: p.Name, Target: target, Requests: t.Requests, Errors: t.Errors})
+		}
+	}
+	a.TraceTemplate("traffic", rows)
+
+	if a.format == formatCSV {
+		printf("product,target,requests,errors,error_rate")
+		for _, r := range rows {
+			printf("%s,%s,%d,%d,%.2f", r.Product, r.Target, r.Requests, r.Errors, r.errorRate())
+		}
+		return nil
+	}
+
+	printf("traffic of environment %s from %s to %s (UTC)", a.Env, start.Format(timeRangeFormat), end.Format(timeRangeFormat))
+	printf("%-30s %-30s %10s %8s %10s", "PRODUCT", "TARGET", "REQUESTS", "ERRORS", "ERROR RATE")
+	var total int64
+	for _, r := range rows {
+		printf("%-30s %-30s %10d %8d %9.1f%%", r.Product, r.Target, r.Requests, r.Errors, r.errorRate())
+		total += r.Requests
+	}
+	if total == 0 {
+		printf("no traffic recorded, analytics may be delayed by several minutes")
+	}
+	return nil
+}
+
+// boundProducts returns the products of --product, or all products, bound
+// to remote service targets
+func (a *analytics) boundProducts() ([]product.APIProduct, error) {
+	req, err := a.ApigeeClient.NewRequest(http.MethodGet, "", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating request")
+	}
+	req.URL.Path = fmt.Sprintf(productsURLFormat, a.Org) // hack: negate client's base URL
+	req.URL.RawQuery = "expand=true"
+
+	var res product.APIResponse
+	resp, err := a.ApigeeClient.Do(req, &res)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving products")
+	}
+	defer resp.Body.Close()
+
+	wanted := map[string]bool{}
+	for _, name := range a.products {
+		wanted[name] = true
+	}
+	found := map[string]bool{}
+	var bound []product.APIProduct
+	for _, p := range res.APIProducts {
+		if len(p.GetBoundTargets()) == 0 || (len(wanted) > 0 && !wanted[p.Name]) {
+			continue
+		}
+		found[p.Name] = true
+		bound = append(bound, p)
+	}
+	var missing []string
+	for name := range wanted {
+		if !found[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("products not found or not bound to remote service targets: %s", strings.Join(missing, ", "))
+	}
+	sort.Slice(bound, func(i, j int) bool { return bound[i].Name < bound[j].Name })
+	return bound, nil
+}
+
+// targetTraffic returns the traffic of the product per target
+func (a *analytics) targetTraffic(productName string, start, end time.Time) (map[string]traffic, error) {
+	req, err := a.ApigeeClient.NewRequest(http.MethodGet, "", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating request")
+	}
+	req.URL.Path = fmt.Sprintf(shared.StatsURLFormat, a.Org, a.Env, "apiproxy") // hack: negate client's base URL
+	req.URL.RawQuery = url.Values{
+		"select":    {metricRequests + "," + metricErrors},
+		"timeRange": {start.Format(timeRangeFormat) + "~" + end.Format(timeRangeFormat)},
+		"timeUnit":  {"hour"},
+		"filter":    {fmt.Sprintf("(api_product eq '%s')", productName)},
+	}.Encode()
+
+	var res shared.StatsResponse
+	resp, err := a.ApigeeClient.Do(req, &res)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	targets := map[string]traffic{}
+	for target, requests := range res.Totals(metricRequests) {
+		t := targets[target]
+		t.Requests = int64(requests)
+		targets[target] = t
+	}
+	for target, errs := range res.Totals(metricErrors) {
+		t := targets[target]
+		t.Errors = int64(errs)
+		targets[target] = t
+	}
+	return targets, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+const productsJSON = `{"apiProduct": [
+  {"name": "bound", "attributes": [{"name": "apigee-remote-service-targets", "value": "httpbin,idle"}]},
+  {"name": "proxies-only", "proxies": ["other"]}
+]}`
+
+// stats of a time unit: values are points of the hours of the window
+const statsJSON = `{"environments": [{"name": "test", "dimensions": [
+  {"name": "httpbin", "metrics": [
+    {"name": "sum(message_count)", "values": [{"timestamp": 1, "value": "150.0"}, {"timestamp": 2, "value": "50.0"}]},
+    {"name": "sum(is_error)", "values": [{"timestamp": 1, "value": "5.0"}]}
+  ]},
+  {"name": "other", "metrics": [{"name": "sum(message_count)", "values": ["7.0"]}]}
+]}]}`
+
+func TestAnalyticsExport(t *testing.T) {
+	m := http.NewServeMux()
+	m.HandleFunc("/v1/organizations/org/apiproducts", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(productsJSON))
+	})
+	m.HandleFunc("/v1/organizations/org/environments/test/stats/apiproxy", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("filter") != "(api_product eq 'bound')" || q.Get("select") != "sum(message_count),sum(is_error)" || q.Get("timeRange") == "" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte(statsJSON))
+	})
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestAnalyticsExport")
+		flags := append([]string{"analytics", "export", "--opdk", "--runtime", ts.URL,
+			"-o", "org", "-e", "test", "-u", "/username/", "-p", "password"}, args...)
+		rootArgs := &shared.RootArgs{}
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+		return print, rootCmd.Execute()
+	}
+
+	print, err := run("--since", "2h")
+	if err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	print.CheckPrefix(t, []string{
+		"traffic of environment test from ",
+		"PRODUCT                        TARGET                           REQUESTS   ERRORS ERROR RATE",
+		"bound                          httpbin                               200        5       2.5%",
+		"bound                          idle                                    0        0       0.0%",
+	})
+
+	print, err = run("--format", "csv", "--product", "bound")
+	if err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	print.Check(t, []string{
+		"product,target,requests,errors,error_rate",
+		"bound,httpbin,200,5,2.50",
+		"bound,idle,0,0,0.00",
+	})
+
+	_, err = run("--product", "proxies-only")
+	testutil.ErrorContains(t, err, "products not found or not bound to remote service targets: proxies-only")
+	_, err = run("--since", "soon")
+	testutil.ErrorContains(t, err, `--since must be a positive duration, got "soon"`)
+	_, err = run("--format", "xml")
+	testutil.ErrorContains(t, err, "--format must be table or csv")
+}
@@ -0,0 +1,216 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apps
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+)
+
+const (
+	revokedStatus = "revoked"
+)
+
+type apps struct {
+	*shared.RootArgs
+	developer string
+	appGroup  string
+	appName   string
+
+	now func() time.Time
+}
+
+// Cmd returns base command
+func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	a := &apps{RootArgs: rootArgs, now: time.Now}
+
+	c := &cobra.Command{
+		Use:   "apps",
+		Short: "Manage Apigee developer apps used by Remote Service",
+		Long:  "Manage Apigee developer apps used by Remote Service.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := rootArgs.Resolve(false, false); err != nil {
+				return err
+			}
+			if !rootArgs.IsGCPManaged && a.appGroup != "" {
+				return fmt.Errorf("--appgroup only valid for hybrid")
+			}
+			return nil
+		},
+	}
+
+	c.PersistentFlags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.PersistentFlags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+	c.PersistentFlags().StringVarP(&rootArgs.ManagementBase, "management", "m",
+		"", "Apigee management base URL (default: hybrid and Apigee X, or the runtime for opdk)")
+	c.PersistentFlags().StringVarP(&rootArgs.Token, "token", "t", "",
+		"Apigee OAuth or SAML token (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.Username, "username", "u", "",
+		"Apigee username (legacy or OPDK only)")
+	c.PersistentFlags().StringVarP(&rootArgs.Password, "password", "p", "",
+		"Apigee password (legacy or OPDK only)")
+
+	c.PersistentFlags().StringVarP(&a.developer, "developer", "", shared.DefaultDeveloperEmail,
+		"email of the developer owning the app")
+	c.PersistentFlags().StringVarP(&a.appGroup, "appgroup", "", "",
+		"name of the AppGroup owning the app, instead of a developer (hybrid only)")
+	c.PersistentFlags().StringVarP(&a.appName, "app", "", shared.DefaultAppName,
+		"name of the developer app")
+
+	c.AddCommand(cmdKeys(a, printf))
+
+	return c
+}
+
+func cmdKeys(a *apps, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage consumer keys of a developer app",
+		Long:  "Manage consumer keys of a developer app.",
+	}
+
+	c.AddCommand(cmdKeysRevoke(a, printf))
+
+	return c
+}
+
+func cmdKeysRevoke(a *apps, printf shared.FormatFn) *cobra.Command {
+	var olderThan string
+	var keys []string
+	var del bool
+
+	c := &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke consumer keys of a developer app",
+		Long: `Revoke (or delete) the consumer keys of a developer app that were issued
+longer ago than --older-than or are explicitly listed with --key.`,
+		Args: cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if olderThan == "" && len(keys) == 0 {
+				return fmt.Errorf("one of --older-than or --key is required")
+			}
+			var age time.Duration
+			if olderThan != "" {
+				var err error
+				if age, err = shared.ParseDuration(olderThan); err != nil {
+					return errors.Wrap(err, "--older-than")
+				}
+				if age <= 0 {
+					return fmt.Errorf("--older-than must be positive, got %s", olderThan)
+				}
+			}
+			return a.revokeKeys(age, keys, del, printf)
+		},
+	}
+
+	c.Flags().StringVarP(&olderThan, "older-than", "", "",
+		`revoke keys issued longer ago than this age (eg. "90d" or "12h")`)
+	c.Flags().StringArrayVarP(&keys, "key", "", nil,
+		"consumer key to revoke (may be repeated)")
+	c.Flags().BoolVarP(&del, "delete", "", false,
+		"delete the keys instead of revoking them")
+
+	return c
+}
+
+// revokeKeys revokes or deletes keys older than age (if non-zero) and
+// the listed keys, listed keys must all exist on the app
+func (a *apps) revokeKeys(age time.Duration, keys []string, del bool, printf shared.FormatFn) error {
+	credentials, err := a.getCredentials()
+	if err != nil {
+		return err
+	}
+
+	creds := map[string]apigee.AppCredential{}
+	for _, cred := range credentials {
+		creds[cred.ConsumerKey] = cred
+	}
+	listed := map[string]bool{}
+	for _, key := range keys {
+		if _, ok := creds[key]; !ok {
+			return fmt.Errorf("key %s not found in app %s", key, a.appName)
+		}
+		listed[key] = true
+	}
+
+	action := "revoked"
+	if del {
+		action = "deleted"
+	}
+
+	var errs error
+	var count int
+	cutoff := a.now().Add(-age)
+	for _, cred := range credentials {
+		if !listed[cred.ConsumerKey] && (age == 0 || !cred.IssuedAt.Before(cutoff)) {
+			continue
+		}
+		if !del && cred.Status == revokedStatus {
+			printf("key %s already revoked", cred.ConsumerKey)
+			continue
+		}
+
+		if err := a.revokeKey(cred.ConsumerKey, del); err != nil {
+			errs = multierr.Append(errs, errors.Wrapf(err, "key %s", cred.ConsumerKey))
+			continue
+		}
+		count++
+		printf("%s key %s (issued %s)", action, cred.ConsumerKey, cred.IssuedAt.Format(time.RFC3339))
+	}
+
+	printf("%s %d key(s) of app %s", action, count, a.appName)
+	return errs
+}
+
+func (a *apps) getCredentials() ([]apigee.AppCredential, error) {
+	if a.appGroup != "" {
+		app, _, err := a.ApigeeClient.AppGroups.GetApp(a.appGroup, a.appName)
+		if err != nil {
+			return nil, errors.Wrapf(err, "retrieving app %s of appgroup %s", a.appName, a.appGroup)
+		}
+		return app.Credentials, nil
+	}
+
+	app, _, err := a.ApigeeClient.DeveloperApps.Get(a.developer, a.appName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving app %s of developer %s", a.appName, a.developer)
+	}
+	return app.Credentials, nil
+}
+
+func (a *apps) revokeKey(key string, del bool) (err error) {
+	switch {
+	case a.appGroup != "" && del:
+		_, err = a.ApigeeClient.AppGroups.DeleteKey(a.appGroup, a.appName, key)
+	case a.appGroup != "":
+		_, err = a.ApigeeClient.AppGroups.RevokeKey(a.appGroup, a.appName, key)
+	case del:
+		_, err = a.ApigeeClient.DeveloperApps.DeleteKey(a.developer, a.appName, key)
+	default:
+		_, err = a.ApigeeClient.DeveloperApps.RevokeKey(a.developer, a.appName, key)
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apps
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestKeysRevoke(t *testing.T) {
+	now := time.Now()
+	app := apigee.DeveloperApp{
+		Name: "app",
+		Credentials: []apigee.AppCredential{
+			{ConsumerKey: "new", Status: "approved", IssuedAt: apigee.Timestamp{Time: now.Add(-time.Hour)}},
+			{ConsumerKey: "old", Status: "approved", IssuedAt: apigee.Timestamp{Time: now.Add(-100 * 24 * time.Hour)}},
+			{ConsumerKey: "oldrevoked", Status: "revoked", IssuedAt: apigee.Timestamp{Time: now.Add(-200 * 24 * time.Hour)}},
+		},
+	}
+
+	tests := []struct {
+		desc    string
+		args    []string
+		want    []string
+		wantErr string
+	}{
+		{"older than", []string{"--older-than", "90d"}, []string{"POST old"}, ""},
+		{"listed", []string{"--key", "new"}, []string{"POST new"}, ""},
+		{"listed and older", []string{"--key", "new", "--older-than", "90d"}, []string{"POST new", "POST old"}, ""},
+		{"delete", []string{"--older-than", "90d", "--delete"}, []string{"DELETE old", "DELETE oldrevoked"}, ""},
+		{"no keys", []string{"--older-than", "1000d"}, nil, ""},
+		{"unknown key", []string{"--key", "missing"}, nil, "key missing not found in app app"},
+		{"missing flags", nil, nil, "one of --older-than or --key is required"},
+		{"bad age", []string{"--older-than", "x"}, nil, "--older-than"},
+		{"zero age", []string{"--older-than", "0d"}, nil, "--older-than must be positive, got 0d"},
+		{"negative age", []string{"--older-than", "-1h"}, nil, "--older-than must be positive, got -1h"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var got []string
+			ts := appTestServer(t, app, func(method, key string) {
+				got = append(got, method+" "+key)
+			})
+			defer ts.Close()
+
+			print := testutil.Printer("TestKeysRevoke")
+			flags := append([]string{"apps", "keys", "revoke", "--opdk", "--runtime", ts.URL,
+				"-o", "org", "-e", "env", "-u", "/username/", "-p", "password",
+				"--developer", "dev@example.com", "--app", "app"}, tc.args...)
+			rootArgs := &shared.RootArgs{}
+			rootCmd := cmd.GetRootCmd(flags, print.Printf)
+			shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+			err := rootCmd.Execute()
+			if tc.wantErr != "" {
+				testutil.ErrorContains(t, err, tc.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatalf("want no error, got: %v", err)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(tc.want, got) {
+				t.Errorf("want %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func appTestServer(t *testing.T, app apigee.DeveloperApp, keyOp func(method, key string)) *httptest.Server {
+	m := http.NewServeMux()
+	m.HandleFunc("/v1/organizations/", func(w http.ResponseWriter, r *http.Request) {
+		appPath := "/v1/organizations/org/developers/dev@example.com/apps/app"
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == appPath:
+			if err := json.NewEncoder(w).Encode(app); err != nil {
+				t.Fatal(err)
+			}
+		case r.Method == http.MethodPost && r.URL.Query().Get("action") == "revoke",
+			r.Method == http.MethodDelete:
+			key := r.URL.Path[len(appPath+"/keys/"):]
+			keyOp(r.Method, key)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	return httptest.NewServer(m)
+}
+
+func TestKeysRevokeAppGroup(t *testing.T) {
+	app := apigee.AppGroupApp{
+		Name: "app",
+		Credentials: []apigee.AppCredential{
+			{ConsumerKey: "key", Status: "approved", IssuedAt: apigee.Timestamp{Time: time.Now()}},
+		},
+	}
+	appPath := "/v1/organizations/org/appgroups/group/apps/app"
+
+	var got []string
+	m := http.NewServeMux()
+	m.HandleFunc(appPath, func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewEncoder(w).Encode(app); err != nil {
+			t.Fatal(err)
+		}
+	})
+	m.HandleFunc(appPath+"/keys/key", func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]string{}
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got = append(got, r.Method+" "+body["action"])
+	})
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	for _, args := range [][]string{{"--key", "key"}, {"--key", "key", "--delete"}} {
+		print := testutil.Printer("TestKeysRevokeAppGroup")
+		flags := append([]string{"apps", "keys", "revoke", "-m", ts.URL,
+			"-o", "org", "-e", "env", "-t", "token",
+			"--appgroup", "group", "--app", "app"}, args...)
+		rootArgs := &shared.RootArgs{}
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("want no error, got: %v", err)
+		}
+	}
+
+	want := []string{"POST revoke", "DELETE "}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	print := testutil.Printer("TestKeysRevokeAppGroup")
+	flags := []string{"apps", "keys", "revoke", "--opdk", "--runtime", ts.URL,
+		"-o", "org", "-e", "env", "-u", "/username/", "-p", "password",
+		"--appgroup", "group", "--app", "app", "--key", "key"}
+	rootArgs := &shared.RootArgs{}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	testutil.ErrorContains(t, rootCmd.Execute(), "--appgroup only valid for hybrid")
+}
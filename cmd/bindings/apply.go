@@ -0,0 +1,166 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bindings
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+func cmdBindingsApply(b *bindings, printf shared.FormatFn) *cobra.Command {
+	var prune, dryRun bool
+
+	c := &cobra.Command{
+		Use:   "apply [file]",
+		Short: "Reconcile Remote Target bindings with a file",
+		Long: `Reconcile the Remote Target bindings of the Apigee Products with a YAML or JSON file
+of the bindings desired, in the format of export. The targets missing from the products
+listed are bound. With --prune, the targets not listed are unbound, from the products not
+listed too. The changes are printed as a diff, +target bound and -target unbound, and
+checked before any is applied: the products listed must exist.`,
+		Args: cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			defer b.PrintExplain(printf)
+			return b.cmdApply(args[0], prune, dryRun, b.ExplainPrintf(printf))
+		},
+	}
+	b.AddExplainFlag(c)
+
+	c.Flags().BoolVarP(&prune, "prune", "", false,
+		"unbind the targets not listed, from the products not listed too")
+	c.Flags().BoolVarP(&dryRun, "dry-run", "", false,
+		"print the changes without applying them")
+
+	return c
+}
+
+// readDesiredBindings returns the targets by product of an apply file
+func (b *bindings) readDesiredBindings(file string) (map[string][]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", file)
+	}
+	var desired bindingsFile
+	if err := yaml.Unmarshal(data, &desired); err != nil { // JSON is YAML
+		return nil, errors.Wrapf(err, "parsing %s", file)
+	}
+	if desired.Organization != "" && desired.Organization != b.Org {
+		return nil, fmt.Errorf("%s has the bindings of organization %s, not %s", file, desired.Organization, b.Org)
+	}
+	targets := map[string][]string{}
+	for _, pb := range desired.Bindings {
+		if _, ok := targets[pb.Product]; ok {
+			return nil, fmt.Errorf("%s: product %s listed twice", file, pb.Product)
+		}
+		targets[pb.Product] = []string{}
+		for _, t := range pb.Targets {
+			if err := validateTarget(t); err != nil {
+				return nil, errors.Wrapf(err, "%s: product %s", file, pb.Product)
+			}
+			if _, ok := indexOf(targets[pb.Product], t); !ok {
+				targets[pb.Product] = append(targets[pb.Product], t)
+			}
+		}
+	}
+	return targets, nil
+}
+
+func (b *bindings) cmdApply(file string, prune, dryRun bool, printf shared.FormatFn) error {
+	desired, err := b.readDesiredBindings(file)
+	if err != nil {
+		return err
+	}
+	products, err := b.getProducts()
+	if err != nil {
+		return err
+	}
+	sort.Sort(byName(products))
+
+	exists := map[string]bool{}
+	for _, p := range products {
+		exists[p.Name] = true
+	}
+	var missing []string
+	for name := range desired {
+		if !exists[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return shared.WithCode(shared.CodeNotFound, fmt.Errorf("products %s not found, nothing applied", strings.Join(missing, ", ")))
+	}
+
+	var changes []bindingChange
+	bound, unbound := 0, 0
+	for i := range products {
+		p := &products[i]
+		want, listed := desired[p.Name]
+		if !listed && !prune {
+			continue
+		}
+		current := p.GetBoundTargets()
+		var next, diff []string
+		for _, t := range current {
+			if _, ok := indexOf(want, t); ok || !prune {
+				next = append(next, t)
+			} else {
+				diff = append(diff, "-"+t)
+				unbound++
+			}
+		}
+		for _, t := range want {
+			if _, ok := indexOf(current, t); !ok {
+				next = append(next, t)
+				diff = append(diff, "+"+t)
+				bound++
+			}
+		}
+		if len(diff) == 0 {
+			continue
+		}
+		printf("product %s: %s", p.Name, strings.Join(diff, " "))
+		changes = append(changes, bindingChange{product: p, targets: next})
+	}
+
+	if len(changes) == 0 {
+		printf("bindings up to date, nothing to apply")
+		return nil
+	}
+	summary := fmt.Sprintf("%d product(s), %d target(s) bound, %d unbound", len(changes), bound, unbound)
+	if dryRun {
+		printf("dry run: %s, nothing applied", summary)
+		return nil
+	}
+
+	defer b.PrintRateLimits(printf)
+	for i, c := range changes {
+		if err := b.updateTargetBindings(c.product, c.targets); err != nil {
+			return errors.Wrapf(err, "binding %s to %s (%d of %d products applied)",
+				strings.Join(c.targets, ","), c.product.Name, i, len(changes))
+		}
+	}
+	printf("applied: %s", summary)
+	return nil
+}
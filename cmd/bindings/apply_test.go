@@ -0,0 +1,133 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bindings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/apigee/apigee-remote-service-golib/product"
+)
+
+func TestBindingsApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bindings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeFile := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	products := []product.APIProduct{
+		{Name: "a", Attributes: []product.Attribute{{Name: product.TargetsAttr, Value: "t1,t2"}}},
+		{Name: "b"},
+		{Name: "c", Attributes: []product.Attribute{{Name: product.TargetsAttr, Value: "t3"}}},
+		{Name: "d", Attributes: []product.Attribute{{Name: product.TargetsAttr, Value: "t4"}}},
+	}
+	var updated map[string]string
+	ts := transferTestServer(t, products, func(prod, targets string) {
+		updated[prod] = targets
+	})
+	defer ts.Close()
+
+	file := writeFile("bindings.yaml", `organization: org
+bindings:
+  - product: a
+    targets: [t1, t5]
+  - product: b
+    targets: [t6, t6]
+  - product: d
+    targets: [t4]
+`)
+	print := testutil.Printer("TestBindingsApply")
+
+	// missing targets bound
+	updated = map[string]string{}
+	if err := runBindings(ts.URL, print, "apply", file); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	if want := map[string]string{"a": "t1,t2,t5", "b": "t6"}; !reflect.DeepEqual(want, updated) {
+		t.Errorf("want %v, got %v", want, updated)
+	}
+	print.Check(t, []string{
+		"product a: +t5",
+		"product b: +t6",
+		"applied: 2 product(s), 2 target(s) bound, 0 unbound",
+		"management API: 3 request(s), no rate limit reported",
+	})
+
+	// pruned, the products not listed too, as JSON
+	json := writeFile("bindings.json", `{"bindings": [{"product": "a", "targets": ["t1", "t5"]}, {"product": "b", "targets": []}]}`)
+	updated = map[string]string{}
+	if err := runBindings(ts.URL, print, "apply", json, "--prune", "--dry-run"); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	if len(updated) != 0 {
+		t.Errorf("dry run must not apply, got %v", updated)
+	}
+	print.Check(t, []string{
+		"product a: -t2 +t5",
+		"product c: -t3",
+		"product d: -t4",
+		"dry run: 3 product(s), 1 target(s) bound, 3 unbound, nothing applied",
+	})
+	print = testutil.Printer("TestBindingsApply")
+	if err := runBindings(ts.URL, print, "apply", json, "--prune"); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	if want := map[string]string{"a": "t1,t5", "c": "", "d": ""}; !reflect.DeepEqual(want, updated) {
+		t.Errorf("want %v, got %v", want, updated)
+	}
+
+	// up to date
+	current := writeFile("current.yaml", "bindings:\n  - product: c\n    targets: [t3]\n")
+	print = testutil.Printer("TestBindingsApply")
+	if err := runBindings(ts.URL, print, "apply", current); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	print.Check(t, []string{"bindings up to date, nothing to apply"})
+
+	// checked before applying
+	updated = map[string]string{}
+	for _, test := range []struct {
+		content, err string
+	}{
+		{"bindings:\n  - product: x\n    targets: [t1]\n  - product: a\n    targets: [t1]\n  - product: w\n", "products w, x not found, nothing applied"},
+		{"organization: other\nbindings: []\n", "has the bindings of organization other, not org"},
+		{"bindings:\n  - product: a\n  - product: a\n", "product a listed twice"},
+		{"bindings:\n  - product: a\n    targets: [\"t1,t2\"]\n", `product a: invalid target name "t1,t2"`},
+		{"bindings: {", "parsing"},
+	} {
+		err := runBindings(ts.URL, print, "apply", writeFile("invalid.yaml", test.content))
+		testutil.ErrorContains(t, err, test.err)
+	}
+	err = runBindings(ts.URL, print, "apply", writeFile("missing.yaml", "bindings:\n  - product: x\n"))
+	if shared.ErrorCodeOf(err) != shared.CodeNotFound {
+		t.Errorf("want %s, got %v", shared.CodeNotFound, err)
+	}
+	if len(updated) != 0 {
+		t.Errorf("invalid files must not apply, got %v", updated)
+	}
+}
@@ -15,6 +15,7 @@
 package bindings
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"sort"
@@ -30,16 +31,23 @@ import (
 const (
 	productsURLFormat     = "/v1/organizations/%s/apiproducts"               // ManagementBase
 	productAttrPathFormat = "/v1/organizations/%s/apiproducts/%s/attributes" // ManagementBase, prod
+
+	outputText = "text"
+	outputJSON = "json"
 )
 
 type bindings struct {
 	*shared.RootArgs
 	products []product.APIProduct
+	window   window
+	output   string // of list
+
+	newProgress func(label string, total int) *shared.Progress
 }
 
 // Cmd returns base command
 func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
-	cfg := &bindings{RootArgs: rootArgs}
+	cfg := &bindings{RootArgs: rootArgs, newProgress: shared.NewTerminalProgress}
 
 	c := &cobra.Command{
 		Use:   "bindings",
@@ -56,6 +64,8 @@ func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
 		"Apigee opdk")
 	c.PersistentFlags().StringVarP(&rootArgs.Token, "token", "t", "",
 		"Apigee OAuth or SAML token (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
 	c.PersistentFlags().StringVarP(&rootArgs.Username, "username", "u", "",
 		"Apigee username (legacy or OPDK only)")
 	c.PersistentFlags().StringVarP(&rootArgs.Password, "password", "p", "",
@@ -64,6 +74,11 @@ func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
 	c.AddCommand(cmdBindingsList(cfg, printf))
 	c.AddCommand(cmdBindingsAdd(cfg, printf))
 	c.AddCommand(cmdBindingsRemove(cfg, printf))
+	c.AddCommand(cmdBindingsExport(cfg, printf))
+	c.AddCommand(cmdBindingsImport(cfg, printf))
+	c.AddCommand(cmdBindingsApply(cfg, printf))
+	c.AddCommand(cmdBindingsScaffold(cfg, printf))
+	c.AddCommand(cmdBindingsWizard(cfg, printf))
 
 	return c
 }
@@ -76,9 +91,17 @@ func cmdBindingsList(b *bindings, printf shared.FormatFn) *cobra.Command {
 		Args:  cobra.NoArgs,
 
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			return b.cmdList(printf)
+			if b.output != outputText && b.output != outputJSON {
+				return fmt.Errorf("--output must be %s or %s", outputText, outputJSON)
+			}
+			defer b.PrintExplain(printf)
+			return b.cmdList(b.ExplainPrintf(printf))
 		},
 	}
+	addWindowFlags(c, b)
+	b.AddExplainFlag(c)
+	c.Flags().StringVarP(&b.output, "output", "", outputText,
+		"output format: text, or json for the targets, paths, scopes and quota of each bound product")
 
 	return c
 }
@@ -93,22 +116,27 @@ func cmdBindingsAdd(b *bindings, printf shared.FormatFn) *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			targetName := args[0]
 			productName := args[1]
+			if err := validateTarget(targetName); err != nil {
+				return err
+			}
 			p, err := b.getProduct(productName)
 			if err != nil {
 				return fmt.Errorf("%v", err)
 			}
 			if p == nil {
 				cmd.SilenceUsage = true
-				return fmt.Errorf("invalid product name: %s", productName)
+				return productNotFound(productName)
 			}
 
-			err = b.bindTarget(p, targetName, printf)
+			defer b.PrintExplain(printf)
+			err = b.bindTarget(p, targetName, b.ExplainPrintf(printf))
 			if err != nil {
 				return fmt.Errorf("%v", err)
 			}
 			return nil
 		},
 	}
+	b.AddExplainFlag(c)
 
 	return c
 }
@@ -123,22 +151,40 @@ func cmdBindingsRemove(b *bindings, printf shared.FormatFn) *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			targetName := args[0]
 			productName := args[1]
+			if err := validateTarget(targetName); err != nil {
+				return err
+			}
 			p, err := b.getProduct(productName)
 			if err != nil {
 				return err
 			}
 			if p == nil {
-				printf("invalid product name: %s", productName)
-				return nil
+				cmd.SilenceUsage = true
+				return productNotFound(productName)
 			}
 
-			return b.unbindTarget(p, targetName, printf)
+			defer b.PrintExplain(printf)
+			return b.unbindTarget(p, targetName, b.ExplainPrintf(printf))
 		},
 	}
+	b.AddExplainFlag(c)
 
 	return c
 }
 
+// validateTarget rejects target names the comma separated values of the
+// targets attribute can't hold
+func validateTarget(target string) error {
+	if strings.TrimSpace(target) == "" || strings.Contains(target, ",") {
+		return shared.WithCode(shared.CodeInvalidFlags, fmt.Errorf("invalid target name %q: must not be empty or contain commas", target))
+	}
+	return nil
+}
+
+func productNotFound(name string) error {
+	return shared.WithCode(shared.CodeNotFound, fmt.Errorf("invalid product name: %s", name))
+}
+
 func (b *bindings) getProduct(name string) (*product.APIProduct, error) {
 	products, err := b.getProducts()
 	if err != nil {
@@ -156,6 +202,9 @@ func (b *bindings) getProducts() ([]product.APIProduct, error) {
 	if b.products != nil {
 		return b.products, nil
 	}
+	if b.window.enabled() {
+		return b.getProductsWindow()
+	}
 	req, err := b.ApigeeClient.NewRequest(http.MethodGet, "", nil)
 	if err != nil {
 		return nil, errors.Wrap(err, "creating request")
@@ -198,6 +247,9 @@ func (b *bindings) cmdList(printf shared.FormatFn) error {
 
 	sort.Sort(byName(bound))
 	sort.Sort(byName(unbound))
+	if b.output == outputJSON {
+		return printBindingsJSON(bound, unbound, printf)
+	}
 	data := struct {
 		Bound   []product.APIProduct
 		Unbound []product.APIProduct
@@ -205,6 +257,7 @@ func (b *bindings) cmdList(printf shared.FormatFn) error {
 		Bound:   bound,
 		Unbound: unbound,
 	}
+	b.TraceTemplate("products", data)
 	tmp := template.New("products")
 	tmp.Funcs(template.FuncMap{
 		"scopes": func(in []string) string { return strings.Join(in, ",") },
@@ -221,6 +274,49 @@ func (b *bindings) cmdList(printf shared.FormatFn) error {
 	return nil
 }
 
+// productBinding is a bound product of list --output json
+type productBinding struct {
+	Product string        `json:"product"`
+	Targets []string      `json:"targets"`
+	Paths   []string      `json:"paths,omitempty"`
+	Scopes  []string      `json:"scopes,omitempty"`
+	Quota   *bindingQuota `json:"quota,omitempty"`
+}
+
+type bindingQuota struct {
+	Limit    string `json:"limit"`
+	Interval string `json:"interval"`
+	TimeUnit string `json:"timeUnit"`
+}
+
+func printBindingsJSON(bound, unbound []product.APIProduct, printf shared.FormatFn) error {
+	out := struct {
+		Bindings []productBinding `json:"bindings"`
+		Unbound  []string         `json:"unbound"`
+	}{Bindings: []productBinding{}, Unbound: []string{}}
+	for _, p := range bound {
+		binding := productBinding{
+			Product: p.Name,
+			Targets: p.Targets,
+			Paths:   p.Resources,
+			Scopes:  p.Scopes,
+		}
+		if p.QuotaLimit != "" {
+			binding.Quota = &bindingQuota{Limit: p.QuotaLimit, Interval: p.QuotaInterval, TimeUnit: p.QuotaTimeUnit}
+		}
+		out.Bindings = append(out.Bindings, binding)
+	}
+	for _, p := range unbound {
+		out.Unbound = append(out.Unbound, p.Name)
+	}
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return err
+	}
+	printf("%s", data)
+	return nil
+}
+
 func (b *bindings) bindTarget(p *product.APIProduct, target string, printf shared.FormatFn) error {
 	boundTargets := p.GetBoundTargets()
 	if _, ok := indexOf(boundTargets, target); ok {
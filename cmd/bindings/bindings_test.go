@@ -76,6 +76,72 @@ func TestBindingListOPDK(t *testing.T) {
 	print.Check(t, wants)
 }
 
+func TestBindingListJSON(t *testing.T) {
+	res := product.APIResponse{
+		APIProducts: []product.APIProduct{
+			{Name: "unbound"},
+			{
+				Name:          "bound",
+				Attributes:    []product.Attribute{{Name: product.TargetsAttr, Value: "target1,target2"}},
+				Resources:     []string{"/v1/**"},
+				Scopes:        []string{"read"},
+				QuotaLimit:    "100",
+				QuotaInterval: "1",
+				QuotaTimeUnit: "minute",
+			},
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			t.Fatalf("want no error %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	print := testutil.Printer("TestBindingListJSON")
+	flags := []string{"bindings", "list", "--opdk", "--runtime", ts.URL, "--output", "json",
+		"-o", "/org/", "-e", "/env/", "-u", "/username/", "-p", "password"}
+	rootArgs := &shared.RootArgs{}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	print.Check(t, []string{`{
+  "bindings": [
+    {
+      "product": "bound",
+      "targets": [
+        "target1",
+        "target2"
+      ],
+      "paths": [
+        "/v1/**"
+      ],
+      "scopes": [
+        "read"
+      ],
+      "quota": {
+        "limit": "100",
+        "interval": "1",
+        "timeUnit": "minute"
+      }
+    }
+  ],
+  "unbound": [
+    "unbound"
+  ]
+}`})
+
+	flags = []string{"bindings", "list", "--opdk", "--runtime", ts.URL, "--output", "yaml",
+		"-o", "/org/", "-e", "/env/", "-u", "/username/", "-p", "password"}
+	rootArgs = &shared.RootArgs{}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	testutil.ErrorContains(t, rootCmd.Execute(), "--output must be text or json")
+}
+
 func TestBindingAddOPDK(t *testing.T) {
 
 	print := testutil.Printer("TestBindingAddOPDK")
@@ -161,11 +227,19 @@ func TestBindingRemoveOPDK(t *testing.T) {
 	rootArgs = &shared.RootArgs{}
 	rootCmd = cmd.GetRootCmd(flags, print.Printf)
 	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
-	wants = []string{"invalid product name: /product3/"}
-	if err = rootCmd.Execute(); err != nil {
-		t.Errorf("want no error, got: %v", err)
+	wantErr := "invalid product name: /product3/"
+	err = rootCmd.Execute()
+	if err == nil || err.Error() != wantErr || shared.ErrorCodeOf(err) != shared.CodeNotFound {
+		t.Errorf("remove want %s, got: %v", wantErr, err)
 	}
-	print.Check(t, wants)
+	print.Check(t, nil)
+
+	flags = []string{"bindings", "remove", "a,b", "/product2/", "--opdk", "--runtime", ts.URL,
+		"-o", "/org/", "-e", "/env/", "-u", "/username/", "-p", "password"}
+	rootArgs = &shared.RootArgs{}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	testutil.ErrorContains(t, rootCmd.Execute(), `invalid target name "a,b": must not be empty or contain commas`)
 }
 
 func productTestServer(t *testing.T) *httptest.Server {
@@ -219,8 +293,8 @@ func testBindingsParams(t *testing.T, args ...string) {
 		t.Errorf("%v want %s, got: %v", args, wantErr, err)
 	}
 
-	// hybrid requires runtime
-	wantErr = "--runtime is required for hybrid or opdk (or --organization and --environment with --legacy)"
+	// hybrid without runtime resolves it from the environment groups, requiring a token
+	wantErr = "--token or --service-account is required for hybrid"
 	flags = []string{"bindings", "-o", "/org/", "-e", "/env/"}
 	flags = append(flags, args...)
 	rootArgs = &shared.RootArgs{}
@@ -232,7 +306,7 @@ func testBindingsParams(t *testing.T, args ...string) {
 	}
 
 	// hybrid requires token
-	wantErr = "--token is required for hybrid"
+	wantErr = "--token or --service-account is required for hybrid"
 	flags = []string{"bindings", "--runtime", "/runtime/"}
 	flags = append(flags, args...)
 	rootArgs = &shared.RootArgs{}
@@ -255,3 +329,28 @@ func testBindingsParams(t *testing.T, args ...string) {
 		t.Errorf("%v want %s, got: %v", args, wantErr, err)
 	}
 }
+
+func TestBindingAddExplain(t *testing.T) {
+	print := testutil.Printer("TestBindingAddExplain")
+	ts := productTestServer(t)
+	defer ts.Close()
+
+	flags := []string{"bindings", "add", "/target/", "/product/", "--explain", "--opdk", "--runtime", ts.URL,
+		"-o", "/org/", "-e", "/env/", "-u", "/username/", "-p", "password"}
+	rootArgs := &shared.RootArgs{}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	if err := rootCmd.Execute(); err != nil {
+		t.Errorf("want no error, got: %v", err)
+	}
+	attributes := ts.URL + "/v1/organizations//org//apiproducts//product//attributes"
+	print.Check(t, []string{
+		"explain: OPDK of organization /org/",
+		"endpoints called:",
+		"  GET " + ts.URL + "/v1/organizations//org//apiproducts (management)",
+		"  POST " + attributes + " (management)",
+		"resources created or modified, not with --explain:",
+		"  update attributes of API product product: POST " + attributes,
+		"permissions: the roles of the user in the organization, IAM permissions are of hybrid and Apigee X only",
+	})
+}
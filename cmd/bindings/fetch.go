@@ -0,0 +1,183 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bindings
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/apigee/apigee-remote-service-golib/product"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+)
+
+const (
+	productPathFormat    = "/v1/organizations/%s/apiproducts/%s" // ManagementBase, prod
+	productNamesPageSize = 1000
+	defaultFetchWorkers  = 8
+)
+
+// window selects a range of products by name so that large
+// organizations may be inventoried incrementally
+type window struct {
+	limit   int
+	offset  int
+	workers int
+}
+
+func (w window) enabled() bool {
+	return w.limit > 0 || w.offset > 0
+}
+
+func addWindowFlags(c *cobra.Command, b *bindings) {
+	c.Flags().IntVarP(&b.window.limit, "limit", "", 0,
+		"only include up to n products (sorted by name), 0 for no limit")
+	c.Flags().IntVarP(&b.window.offset, "offset", "", 0,
+		"skip the first n products (sorted by name)")
+	c.Flags().IntVarP(&b.window.workers, "workers", "", defaultFetchWorkers,
+		"number of concurrent product requests when using --limit or --offset")
+}
+
+// getProductsWindow lists the product names and fetches the products
+// within the window concurrently
+func (b *bindings) getProductsWindow() ([]product.APIProduct, error) {
+	if b.window.workers < 1 {
+		return nil, fmt.Errorf("--workers must be at least 1")
+	}
+	if b.window.limit < 0 || b.window.offset < 0 {
+		return nil, fmt.Errorf("--limit and --offset must not be negative")
+	}
+
+	names, err := b.getProductNames()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	if b.window.offset >= len(names) {
+		return []product.APIProduct{}, nil
+	}
+	names = names[b.window.offset:]
+	if b.window.limit > 0 && b.window.limit < len(names) {
+		names = names[:b.window.limit]
+	}
+
+	progress := b.newProgress("fetching products", len(names))
+	defer progress.Done()
+
+	products := make([]product.APIProduct, len(names))
+	errs := make([]error, len(names))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < b.window.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				errs[j] = b.fetchProduct(names[j], &products[j])
+				progress.Increment()
+			}
+		}()
+	}
+	for i := range names {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err := multierr.Combine(errs...); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// getProductNames pages through the names of all products
+func (b *bindings) getProductNames() ([]string, error) {
+	var names []string
+	startKey := ""
+	for {
+		req, err := b.ApigeeClient.NewRequest(http.MethodGet, "", nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating request")
+		}
+		req.URL.Path = fmt.Sprintf(productsURLFormat, b.Org) // hack: negate client's base URL
+		q := url.Values{}
+		q.Set("count", strconv.Itoa(productNamesPageSize))
+		if startKey != "" {
+			q.Set("startKey", startKey)
+		}
+		req.URL.RawQuery = q.Encode()
+
+		var res json.RawMessage
+		resp, err := b.ApigeeClient.Do(req, &res)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving product names")
+		}
+		resp.Body.Close()
+
+		page, err := parseProductNames(res)
+		if err != nil {
+			return nil, errors.Wrap(err, "retrieving product names")
+		}
+		count := len(page)
+		if startKey != "" && count > 0 && page[0] == startKey { // startKey is inclusive
+			page = page[1:]
+		}
+		names = append(names, page...)
+		if count < productNamesPageSize || len(page) == 0 {
+			return names, nil
+		}
+		startKey = page[len(page)-1]
+	}
+}
+
+// parseProductNames accepts the legacy list of names and the
+// GCP list of product objects
+func parseProductNames(data json.RawMessage) ([]string, error) {
+	var names []string
+	if err := json.Unmarshal(data, &names); err == nil {
+		return names, nil
+	}
+	var res struct {
+		APIProducts []struct {
+			Name string `json:"name"`
+		} `json:"apiProduct"`
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	for _, p := range res.APIProducts {
+		names = append(names, p.Name)
+	}
+	return names, nil
+}
+
+func (b *bindings) fetchProduct(name string, p *product.APIProduct) error {
+	req, err := b.ApigeeClient.NewRequest(http.MethodGet, "", nil)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.URL.Path = fmt.Sprintf(productPathFormat, b.Org, name) // hack: negate client's base URL
+	resp, err := b.ApigeeClient.Do(req, p)
+	if err != nil {
+		return errors.Wrapf(err, "retrieving product %s", name)
+	}
+	return resp.Body.Close()
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bindings
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/apigee/apigee-remote-service-golib/product"
+)
+
+func TestBindingsExportWindow(t *testing.T) {
+	var names []string
+	for i := 0; i < 2500; i++ {
+		names = append(names, fmt.Sprintf("p%04d", i))
+	}
+	var fetched int32
+	ts := largeOrgTestServer(t, names, &fetched)
+	defer ts.Close()
+
+	print := testutil.Printer("TestBindingsExportWindow")
+	if err := runBindings(ts.URL, print, "export", "--offset", "1000", "--limit", "1200", "--workers", "4"); err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 1200 {
+		t.Errorf("want 1200 products fetched, got %d", fetched)
+	}
+	out := strings.Join(print.Prints, "")
+	if got := strings.Count(out, "product: "); got != 1200 {
+		t.Errorf("want 1200 products exported, got %d", got)
+	}
+	for _, want := range []string{"product: p1000\n", "product: p2199\n"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q in export", want)
+		}
+	}
+	for _, notWant := range []string{"product: p0999\n", "product: p2200\n"} {
+		if strings.Contains(out, notWant) {
+			t.Errorf("don't want %q in export", notWant)
+		}
+	}
+
+	print.Prints = nil
+	fetched = 0
+	if err := runBindings(ts.URL, print, "export", "--offset", "3000"); err != nil {
+		t.Fatal(err)
+	}
+	if fetched != 0 {
+		t.Errorf("want no products fetched, got %d", fetched)
+	}
+
+	err := runBindings(ts.URL, print, "list", "--limit", "1", "--workers", "0")
+	testutil.ErrorContains(t, err, "--workers must be at least 1")
+}
+
+func TestParseProductNames(t *testing.T) {
+	for _, data := range []string{
+		`["b", "a"]`,
+		`{"apiProduct": [{"name": "b"}, {"name": "a"}]}`,
+	} {
+		got, err := parseProductNames(json.RawMessage(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"b", "a"}; !reflect.DeepEqual(want, got) {
+			t.Errorf("want %v, got %v", want, got)
+		}
+	}
+	if _, err := parseProductNames(json.RawMessage(`"a"`)); err == nil {
+		t.Errorf("want error")
+	}
+}
+
+// largeOrgTestServer pages product names like the management API
+// and serves each product with a target binding
+func largeOrgTestServer(t *testing.T, names []string, fetched *int32) *httptest.Server {
+	sort.Strings(names)
+	prefix := "/v1/organizations/org/apiproducts"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		var res interface{}
+		if r.URL.Path == prefix {
+			count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+			start := sort.SearchStrings(names, r.URL.Query().Get("startKey"))
+			end := start + count
+			if end > len(names) {
+				end = len(names)
+			}
+			res = names[start:end]
+		} else {
+			atomic.AddInt32(fetched, 1)
+			name := strings.TrimPrefix(r.URL.Path, prefix+"/")
+			res = product.APIProduct{
+				Name:       name,
+				Attributes: []product.Attribute{{Name: product.TargetsAttr, Value: "target-" + name}},
+			}
+		}
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			t.Error(err)
+		}
+	}))
+}
@@ -0,0 +1,291 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bindings
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	scaffoldEnvoy = "envoy"
+	scaffoldIstio = "istio"
+)
+
+// scaffoldTarget is a bound target and the routes of the paths of its products
+type scaffoldTarget struct {
+	Name     string
+	Host     string
+	Port     int
+	Products []string
+	Routes   []scaffoldRoute
+}
+
+// scaffoldRoute matches a path exactly or by prefix
+type scaffoldRoute struct {
+	Kind string // "path" or "prefix", as named by Envoy
+	Path string
+}
+
+var nonDNSLabelRE = regexp.MustCompile(`[^a-z0-9-]+`)
+
+func cmdBindingsScaffold(b *bindings, printf shared.FormatFn) *cobra.Command {
+	var format, namespace string
+	var port int
+
+	c := &cobra.Command{
+		Use:   "scaffold [file]",
+		Short: "Generate routes to the Remote Targets bound to Apigee Products",
+		Long: `Generate a starter set of Envoy routes and clusters, or Istio VirtualServices, to
+the Remote Targets bound to Apigee Products, routing the paths of their products.
+The result is written to a file (or stdout) to be completed and merged into the
+mesh configuration.`,
+		Args: cobra.MaximumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != scaffoldEnvoy && format != scaffoldIstio {
+				return fmt.Errorf("--format must be %s or %s", scaffoldEnvoy, scaffoldIstio)
+			}
+			file := ""
+			if len(args) > 0 {
+				file = args[0]
+			}
+			return b.cmdScaffold(file, format, namespace, port, printf)
+		},
+	}
+	addWindowFlags(c, b)
+
+	c.Flags().StringVarP(&format, "format", "", scaffoldEnvoy,
+		fmt.Sprintf("generate %s routes and clusters or %s VirtualServices", scaffoldEnvoy, scaffoldIstio))
+	c.Flags().StringVarP(&namespace, "namespace", "n", "",
+		"namespace of the VirtualServices (istio only, default: none)")
+	c.Flags().IntVarP(&port, "port", "", 80,
+		"port of targets not naming one")
+
+	return c
+}
+
+func (b *bindings) cmdScaffold(file, format, namespace string, port int, printf shared.FormatFn) error {
+	products, err := b.getProducts()
+	if err != nil {
+		return err
+	}
+
+	targets := map[string]*scaffoldTarget{}
+	for _, p := range products {
+		for _, name := range p.GetBoundTargets() {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			t, ok := targets[name]
+			if !ok {
+				t = newScaffoldTarget(name, port)
+				targets[name] = t
+			}
+			t.Products = append(t.Products, p.Name)
+			resources := p.Resources
+			if len(resources) == 0 {
+				resources = []string{"/"}
+			}
+			for _, r := range resources {
+				t.addRoute(routeOf(r))
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no products have target bindings")
+	}
+
+	data := struct {
+		Org       string
+		Namespace string
+		Targets   []*scaffoldTarget
+	}{Org: b.Org, Namespace: namespace}
+	for _, t := range targets {
+		sort.Strings(t.Products)
+		t.sortRoutes()
+		data.Targets = append(data.Targets, t)
+	}
+	sort.Slice(data.Targets, func(i, j int) bool { return data.Targets[i].Name < data.Targets[j].Name })
+
+	b.TraceTemplate("scaffold", data)
+	tmpl := envoyScaffoldTemplate
+	if format == scaffoldIstio {
+		tmpl = istioScaffoldTemplate
+	}
+	tmp, err := template.New("scaffold").Funcs(template.FuncMap{
+		"quote":    strconv.Quote,
+		"join":     strings.Join,
+		"dnsLabel": dnsLabel,
+	}).Parse(tmpl)
+	if err != nil {
+		return errors.Wrap(err, "creating template")
+	}
+	var buf strings.Builder
+	if err := tmp.Execute(&buf, data); err != nil {
+		return errors.Wrap(err, "executing template")
+	}
+
+	if file == "" {
+		printf(buf.String())
+		return nil
+	}
+	if err := ioutil.WriteFile(file, []byte(buf.String()), 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", file)
+	}
+	printf("wrote routes to %d target(s) to %s", len(data.Targets), file)
+	return nil
+}
+
+// newScaffoldTarget splits the port off a target name, if any
+func newScaffoldTarget(name string, port int) *scaffoldTarget {
+	t := &scaffoldTarget{Name: name, Host: name, Port: port}
+	if host, p, err := net.SplitHostPort(name); err == nil {
+		if n, err := strconv.Atoi(p); err == nil {
+			t.Host, t.Port = host, n
+		}
+	}
+	return t
+}
+
+func (t *scaffoldTarget) addRoute(route scaffoldRoute) {
+	for _, r := range t.Routes {
+		if r == route {
+			return
+		}
+	}
+	t.Routes = append(t.Routes, route)
+}
+
+// sortRoutes orders the routes as they must be tried: exact paths first,
+// then longer prefixes before shorter ones
+func (t *scaffoldTarget) sortRoutes() {
+	sort.Slice(t.Routes, func(i, j int) bool {
+		ri, rj := t.Routes[i], t.Routes[j]
+		if ri.Kind != rj.Kind {
+			return ri.Kind == "path"
+		}
+		if len(ri.Path) != len(rj.Path) {
+			return len(ri.Path) > len(rj.Path)
+		}
+		return ri.Path < rj.Path
+	})
+}
+
+// routeOf converts an API product resource path to a route, wildcards
+// become a prefix as broad as the part before them
+func routeOf(resource string) scaffoldRoute {
+	if !strings.HasPrefix(resource, "/") {
+		resource = "/" + resource
+	}
+	if i := strings.Index(resource, "*"); i >= 0 {
+		return scaffoldRoute{Kind: "prefix", Path: resource[:i]}
+	}
+	if resource == "/" {
+		return scaffoldRoute{Kind: "prefix", Path: "/"}
+	}
+	return scaffoldRoute{Kind: "path", Path: resource}
+}
+
+// dnsLabel makes a Kubernetes resource name of a target name
+func dnsLabel(name string) string {
+	label := strings.Trim(nonDNSLabelRE.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	if len(label) > 63 {
+		label = strings.TrimRight(label[:63], "-")
+	}
+	return label
+}
+
+const envoyScaffoldTemplate = `# Envoy routes and clusters for the Remote Targets bound to Apigee Products
+# of organization: {{.Org}}, merge them into the route_config and clusters
+# of the envoy config, the ext_authz filter must stay in front of the routes.
+route_config:
+  virtual_hosts:
+{{- range .Targets}}
+  # products: {{join .Products ", "}}
+  - name: {{quote .Name}}
+    domains:
+    - {{quote .Name}}
+{{- if ne .Name .Host}}
+    - {{quote .Host}}
+{{- end}}
+    routes:
+{{- $target := .}}
+{{- range .Routes}}
+    - match:
+        {{.Kind}}: {{quote .Path}}
+      route:
+        cluster: {{quote $target.Name}}
+        host_rewrite_literal: {{quote $target.Host}}
+{{- end}}
+{{- end}}
+clusters:
+{{- range .Targets}}
+- name: {{quote .Name}}
+  connect_timeout: 2s
+  type: LOGICAL_DNS
+  dns_lookup_family: V4_ONLY
+  load_assignment:
+    cluster_name: {{quote .Name}}
+    endpoints:
+    - lb_endpoints:
+      - endpoint:
+          address:
+            socket_address:
+              address: {{quote .Host}}
+              port_value: {{.Port}}
+{{- end}}
+`
+
+const istioScaffoldTemplate = `# Istio VirtualServices for the Remote Targets bound to Apigee Products
+# of organization: {{.Org}}
+{{- $namespace := .Namespace}}
+{{- range .Targets}}
+---
+# products: {{join .Products ", "}}
+apiVersion: networking.istio.io/v1beta1
+kind: VirtualService
+metadata:
+  name: {{dnsLabel .Name}}
+{{- if $namespace}}
+  namespace: {{$namespace}}
+{{- end}}
+spec:
+  hosts:
+  - {{quote .Host}}
+  http:
+{{- $target := .}}
+{{- range .Routes}}
+  - match:
+    - uri:
+        {{if eq .Kind "path"}}exact{{else}}prefix{{end}}: {{quote .Path}}
+    route:
+    - destination:
+        host: {{quote $target.Host}}
+        port:
+          number: {{$target.Port}}
+{{- end}}
+{{- end}}
+`
@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bindings
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/apigee/apigee-remote-service-golib/product"
+	"gopkg.in/yaml.v3"
+)
+
+func TestBindingsScaffold(t *testing.T) {
+	ts := transferTestServer(t, []product.APIProduct{
+		{Name: "unbound", Resources: []string{"/unbound"}},
+		{Name: "orders", Resources: []string{"/orders/**", "/status"},
+			Attributes: []product.Attribute{{Name: product.TargetsAttr, Value: "orders.default.svc.cluster.local"}}},
+		{Name: "all", Attributes: []product.Attribute{{Name: product.TargetsAttr, Value: "orders.default.svc.cluster.local,httpbin.org:8080"}}},
+	}, nil)
+	defer ts.Close()
+
+	print := testutil.Printer("TestBindingsScaffold")
+	if err := runBindings(ts.URL, print, "scaffold"); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	var envoy struct {
+		RouteConfig struct {
+			VirtualHosts []struct {
+				Name    string   `yaml:"name"`
+				Domains []string `yaml:"domains"`
+				Routes  []struct {
+					Match map[string]string `yaml:"match"`
+				} `yaml:"routes"`
+			} `yaml:"virtual_hosts"`
+		} `yaml:"route_config"`
+		Clusters []struct {
+			Name string `yaml:"name"`
+		} `yaml:"clusters"`
+	}
+	if err := yaml.Unmarshal([]byte(strings.Join(print.Prints, "\n")), &envoy); err != nil {
+		t.Fatalf("invalid yaml: %v", err)
+	}
+	hosts := envoy.RouteConfig.VirtualHosts
+	if len(hosts) != 2 || len(envoy.Clusters) != 2 {
+		t.Fatalf("want 2 virtual hosts and clusters, got %v", envoy)
+	}
+	if hosts[0].Name != "httpbin.org:8080" || !reflect.DeepEqual(hosts[0].Domains, []string{"httpbin.org:8080", "httpbin.org"}) {
+		t.Errorf("unexpected virtual host %v", hosts[0])
+	}
+	var matches []map[string]string
+	for _, r := range hosts[1].Routes {
+		matches = append(matches, r.Match)
+	}
+	want := []map[string]string{{"path": "/status"}, {"prefix": "/orders/"}, {"prefix": "/"}}
+	if !reflect.DeepEqual(matches, want) {
+		t.Errorf("want routes %v, got %v", want, matches)
+	}
+
+	print = testutil.Printer("TestBindingsScaffold")
+	if err := runBindings(ts.URL, print, "scaffold", "--format", "istio", "-n", "apps"); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	decoder := yaml.NewDecoder(strings.NewReader(strings.Join(print.Prints, "\n")))
+	var names []string
+	for {
+		var vs struct {
+			Metadata struct {
+				Name      string `yaml:"name"`
+				Namespace string `yaml:"namespace"`
+			} `yaml:"metadata"`
+		}
+		if err := decoder.Decode(&vs); err != nil {
+			break
+		}
+		if vs.Metadata.Namespace != "apps" {
+			t.Errorf("want namespace apps, got %q", vs.Metadata.Namespace)
+		}
+		names = append(names, vs.Metadata.Name)
+	}
+	if want := []string{"httpbin-org-8080", "orders-default-svc-cluster-local"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("want VirtualServices %v, got %v", want, names)
+	}
+
+	testutil.ErrorContains(t, runBindings(ts.URL, print, "scaffold", "--format", "nginx"), "--format must be envoy or istio")
+	unbound := transferTestServer(t, []product.APIProduct{{Name: "unbound"}}, nil)
+	defer unbound.Close()
+	testutil.ErrorContains(t, runBindings(unbound.URL, print, "scaffold"), "no products have target bindings")
+}
@@ -0,0 +1,228 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bindings
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-golib/product"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// bindingsFile is the format of bindings export, import and apply
+type bindingsFile struct {
+	Organization string            `yaml:"organization,omitempty"`
+	Bindings     []productBindings `yaml:"bindings"`
+}
+
+type productBindings struct {
+	Product string   `yaml:"product"`
+	Targets []string `yaml:"targets"`
+}
+
+// FileSchema returns the schema of the file of bindings export, import and apply
+func FileSchema() shared.JSONSchema {
+	return shared.SchemaOf(bindingsFile{}, "yaml", "apigee-remote-service-cli bindings file")
+}
+
+func cmdBindingsExport(b *bindings, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "export [file]",
+		Short: "Export Remote Target bindings of all Apigee Products",
+		Long:  "Export Remote Target bindings of all Apigee Products to a file (or stdout) for import into another organization.",
+		Args:  cobra.MaximumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			file := ""
+			if len(args) > 0 {
+				file = args[0]
+			}
+			return b.cmdExport(file, printf)
+		},
+	}
+	addWindowFlags(c, b)
+
+	return c
+}
+
+func cmdBindingsImport(b *bindings, printf shared.FormatFn) *cobra.Command {
+	var substitutionsFile string
+	var dryRun, force bool
+
+	c := &cobra.Command{
+		Use:   "import [file]",
+		Short: "Import Remote Target bindings into Apigee Products",
+		Long: `Import Remote Target bindings from a file created by export. Target names may be
+mapped with a substitution file of "old: new" entries. Conflicts are reported before
+any changes are applied.`,
+		Args: cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			defer b.PrintExplain(printf)
+			return b.cmdImport(args[0], substitutionsFile, dryRun, force, b.ExplainPrintf(printf))
+		},
+	}
+	b.AddExplainFlag(c)
+
+	c.Flags().StringVarP(&substitutionsFile, "substitutions", "", "",
+		"YAML file mapping exported target names to new target names")
+	c.Flags().BoolVarP(&dryRun, "dry-run", "", false,
+		"report the changes without applying them")
+	c.Flags().BoolVarP(&force, "force", "", false,
+		"replace conflicting bindings (missing products are still skipped)")
+
+	return c
+}
+
+func (b *bindings) cmdExport(file string, printf shared.FormatFn) error {
+	products, err := b.getProducts()
+	if err != nil {
+		return err
+	}
+	export := bindingsFile{Organization: b.Org}
+	for _, p := range products {
+		if targets := p.GetBoundTargets(); len(targets) > 0 {
+			export.Bindings = append(export.Bindings, productBindings{Product: p.Name, Targets: targets})
+		}
+	}
+	sort.Slice(export.Bindings, func(i, j int) bool {
+		return export.Bindings[i].Product < export.Bindings[j].Product
+	})
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(export); err != nil {
+		return errors.Wrap(err, "encoding bindings")
+	}
+
+	if file == "" {
+		printf(buf.String())
+		return nil
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", file)
+	}
+	printf("exported bindings of %d product(s) to %s", len(export.Bindings), file)
+	return nil
+}
+
+type bindingChange struct {
+	product *product.APIProduct
+	targets []string
+}
+
+func (b *bindings) cmdImport(file, substitutionsFile string, dryRun, force bool, printf shared.FormatFn) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", file)
+	}
+	var imported bindingsFile
+	if err := yaml.Unmarshal(data, &imported); err != nil {
+		return errors.Wrapf(err, "parsing %s", file)
+	}
+
+	substitutions := map[string]string{}
+	if substitutionsFile != "" {
+		data, err := ioutil.ReadFile(substitutionsFile)
+		if err != nil {
+			return errors.Wrapf(err, "reading %s", substitutionsFile)
+		}
+		if err := yaml.Unmarshal(data, &substitutions); err != nil {
+			return errors.Wrapf(err, "parsing %s", substitutionsFile)
+		}
+	}
+
+	products, err := b.getProducts()
+	if err != nil {
+		return err
+	}
+	productsByName := map[string]*product.APIProduct{}
+	for i := range products {
+		productsByName[products[i].Name] = &products[i]
+	}
+
+	var changes []bindingChange
+	var conflicts int
+	for _, pb := range imported.Bindings {
+		targets := make([]string, len(pb.Targets))
+		for i, t := range pb.Targets {
+			if s, ok := substitutions[t]; ok {
+				t = s
+			}
+			targets[i] = t
+		}
+
+		p, ok := productsByName[pb.Product]
+		if !ok {
+			printf("conflict: product %s does not exist", pb.Product)
+			conflicts++
+			continue
+		}
+		current := p.GetBoundTargets()
+		if sameTargets(current, targets) {
+			printf("unchanged: %s", pb.Product)
+			continue
+		}
+		if len(current) > 0 {
+			printf("conflict: product %s is bound to %s, import binds %s",
+				pb.Product, strings.Join(current, ","), strings.Join(targets, ","))
+			conflicts++
+			if !force {
+				continue
+			}
+		} else {
+			printf("bind: product %s to %s", pb.Product, strings.Join(targets, ","))
+		}
+		changes = append(changes, bindingChange{product: p, targets: targets})
+	}
+
+	if dryRun {
+		printf("dry run: %d change(s), %d conflict(s), nothing applied", len(changes), conflicts)
+		return nil
+	}
+	if conflicts > 0 && !force {
+		return fmt.Errorf("%d conflict(s), nothing applied (use --force to replace conflicting bindings)", conflicts)
+	}
+
+	defer b.PrintRateLimits(printf)
+	for _, c := range changes {
+		if err := b.updateTargetBindings(c.product, c.targets); err != nil {
+			return errors.Wrapf(err, "binding %s to %s", strings.Join(c.targets, ","), c.product.Name)
+		}
+	}
+	printf("imported bindings of %d product(s)", len(changes))
+	return nil
+}
+
+func sameTargets(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, t := range b {
+		if _, ok := indexOf(a, t); !ok {
+			return false
+		}
+	}
+	return true
+}
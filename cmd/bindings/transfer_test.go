@@ -0,0 +1,172 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bindings
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/apigee/apigee-remote-service-golib/product"
+)
+
+func TestBindingsExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bindings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exportFile := filepath.Join(dir, "export.yaml")
+	subsFile := filepath.Join(dir, "subs.yaml")
+	if err := ioutil.WriteFile(subsFile, []byte("/target/: /prod-target/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := transferTestServer(t, []product.APIProduct{
+		{Name: "/product1/"},
+		{Name: "/product2/", Attributes: []product.Attribute{{Name: product.TargetsAttr, Value: "/target/,/other/"}}},
+		{Name: "/product3/", Attributes: []product.Attribute{{Name: product.TargetsAttr, Value: "/target/"}}},
+	}, nil)
+	defer src.Close()
+
+	print := testutil.Printer("TestBindingsExportImport")
+	if err := runBindings(src.URL, print, "export", exportFile); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	print.CheckPrefix(t, []string{"exported bindings of 2 product(s) to"})
+	data, err := ioutil.ReadFile(exportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `organization: org
+bindings:
+  - product: /product2/
+    targets:
+      - /target/
+      - /other/
+  - product: /product3/
+    targets:
+      - /target/
+`
+	if string(data) != want {
+		t.Errorf("want export:\n%s\ngot:\n%s", want, data)
+	}
+
+	dstProducts := []product.APIProduct{
+		{Name: "/product2/"},
+		{Name: "/product3/", Attributes: []product.Attribute{{Name: product.TargetsAttr, Value: "/prod-target/"}}},
+	}
+
+	// dry run applies nothing
+	var updated map[string]string
+	dst := transferTestServer(t, dstProducts, func(prod, targets string) {
+		updated[prod] = targets
+	})
+	defer dst.Close()
+
+	updated = map[string]string{}
+	if err := runBindings(dst.URL, print, "import", exportFile, "--substitutions", subsFile, "--dry-run"); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	if len(updated) != 0 {
+		t.Errorf("dry run must not apply, got %v", updated)
+	}
+	print.Check(t, []string{
+		"bind: product /product2/ to /prod-target/,/other/",
+		"unchanged: /product3/",
+		"dry run: 1 change(s), 0 conflict(s), nothing applied",
+	})
+
+	// apply with substitution
+	updated = map[string]string{}
+	if err := runBindings(dst.URL, print, "import", exportFile, "--substitutions", subsFile); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	if want := map[string]string{"/product2/": "/prod-target/,/other/"}; !reflect.DeepEqual(want, updated) {
+		t.Errorf("want %v, got %v", want, updated)
+	}
+
+	// conflicts without substitution
+	updated = map[string]string{}
+	err = runBindings(dst.URL, print, "import", exportFile)
+	testutil.ErrorContains(t, err, "1 conflict(s), nothing applied")
+	if len(updated) != 0 {
+		t.Errorf("conflicts must not apply, got %v", updated)
+	}
+
+	// forced
+	updated = map[string]string{}
+	if err := runBindings(dst.URL, print, "import", exportFile, "--force"); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	if want := map[string]string{"/product2/": "/target/,/other/", "/product3/": "/target/"}; !reflect.DeepEqual(want, updated) {
+		t.Errorf("want %v, got %v", want, updated)
+	}
+
+	// missing product
+	print.Prints = nil
+	missing := transferTestServer(t, nil, nil)
+	defer missing.Close()
+	err = runBindings(missing.URL, print, "import", exportFile, "--force")
+	if err != nil {
+		t.Errorf("want no error, got: %v", err)
+	}
+	if !strings.Contains(strings.Join(print.Prints, "\n"), "conflict: product /product2/ does not exist") {
+		t.Errorf("want missing product reported, got %v", print.Prints)
+	}
+}
+
+func runBindings(url string, print *testutil.TestPrint, args ...string) error {
+	flags := append([]string{"bindings", "--opdk", "--runtime", url,
+		"-o", "org", "-e", "env", "-u", "/username/", "-p", "password"}, args...)
+	rootArgs := &shared.RootArgs{}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	return rootCmd.Execute()
+}
+
+func transferTestServer(t *testing.T, products []product.APIProduct, updated func(prod, targets string)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost {
+			var attrs attrUpdate
+			if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+				t.Fatal(err)
+			}
+			prod := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/organizations/org/apiproducts/"), "/attributes")
+			for _, a := range attrs.Attributes {
+				if a.Name == product.TargetsAttr {
+					updated(prod, a.Value)
+				}
+			}
+			if err := json.NewEncoder(w).Encode(attrs); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		if err := json.NewEncoder(w).Encode(product.APIResponse{APIProducts: products}); err != nil {
+			t.Fatal(err)
+		}
+	}))
+}
@@ -0,0 +1,271 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bindings
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-golib/product"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var quotaRE = regexp.MustCompile(`^(\d+)(?: requests?)? every (\d+) (minute|hour|day|month)s?$`)
+
+// quota of a product, as the management API names it
+type quota struct {
+	limit    string
+	interval string
+	timeUnit string
+}
+
+func (q quota) String() string {
+	if q.limit == "" {
+		return "none"
+	}
+	return fmt.Sprintf("%s requests every %s %s", q.limit, q.interval, q.timeUnit)
+}
+
+// wizardChange binds the targets to a product, setting its quota if not nil
+type wizardChange struct {
+	product *product.APIProduct
+	targets []string
+	quota   *quota
+}
+
+func cmdBindingsWizard(b *bindings, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "wizard",
+		Short: "Interactively bind Remote Targets to unbound Apigee Products",
+		Long: `Interactively bind Remote Targets to unbound Apigee Products: select the products,
+enter the targets and, optionally, the quota of each, then review the changes before
+they're applied.`,
+		Args: cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+			if f, ok := cmd.InOrStdin().(*os.File); shared.NonInteractive || ok && !shared.IsTerminal(f) {
+				return fmt.Errorf("wizard needs a terminal, use bindings add or import instead")
+			}
+			return b.cmdWizard(bufio.NewScanner(cmd.InOrStdin()), printf)
+		},
+	}
+	addWindowFlags(c, b)
+
+	return c
+}
+
+func (b *bindings) cmdWizard(in *bufio.Scanner, printf shared.FormatFn) error {
+	products, err := b.getProducts()
+	if err != nil {
+		return err
+	}
+	var unbound []product.APIProduct
+	for _, p := range products {
+		if len(p.GetBoundTargets()) == 0 {
+			unbound = append(unbound, p)
+		}
+	}
+	if len(unbound) == 0 {
+		printf("all products have target bindings")
+		return nil
+	}
+	sort.Sort(byName(unbound))
+
+	printf("Unbound products:")
+	for i, p := range unbound {
+		printf("  %d) %s (quota: %s)", i+1, p.Name, quotaOf(&p))
+	}
+
+	var selected []int
+	for selected == nil {
+		answer, ok := prompt(in, printf, "select products (eg. 1,3-4 or all, empty to quit):")
+		if !ok || answer == "" {
+			printf("nothing changed")
+			return nil
+		}
+		if selected, err = parseSelection(answer, len(unbound)); err != nil {
+			printf("%v", err)
+		}
+	}
+
+	var changes []wizardChange
+	for _, i := range selected {
+		p := &unbound[i]
+		answer, ok := prompt(in, printf, "targets of %s (comma separated, empty skips the product):", p.Name)
+		if !ok {
+			return fmt.Errorf("wizard cancelled, nothing changed")
+		}
+		targets := splitTargets(answer)
+		if len(targets) == 0 {
+			continue
+		}
+		change := wizardChange{product: p, targets: targets}
+		for {
+			answer, ok := prompt(in, printf, `quota of %s as "<requests> every <n> <minute|hour|day|month>" (empty keeps %s):`,
+				p.Name, quotaOf(p))
+			if !ok {
+				return fmt.Errorf("wizard cancelled, nothing changed")
+			}
+			if answer == "" {
+				break
+			}
+			q, err := parseQuota(answer)
+			if err == nil {
+				change.quota = q
+				break
+			}
+			printf("%v", err)
+		}
+		changes = append(changes, change)
+	}
+	if len(changes) == 0 {
+		printf("nothing changed")
+		return nil
+	}
+
+	printf("Changes:")
+	for _, c := range changes {
+		printf("  %s:", c.product.Name)
+		printf("    + targets: %s", strings.Join(c.targets, ","))
+		if c.quota != nil {
+			printf("    ~ quota: %s -> %s", quotaOf(c.product), c.quota)
+		}
+	}
+	answer, _ := prompt(in, printf, "apply %d change(s)? [y/N]", len(changes))
+	if answer = strings.ToLower(answer); answer != "y" && answer != "yes" {
+		printf("nothing changed")
+		return nil
+	}
+
+	for _, c := range changes {
+		if c.quota != nil {
+			if err := b.updateQuota(c.product.Name, *c.quota); err != nil {
+				return errors.Wrapf(err, "setting quota of %s", c.product.Name)
+			}
+		}
+		if err := b.updateTargetBindings(c.product, c.targets); err != nil {
+			return errors.Wrapf(err, "binding %s to %s", strings.Join(c.targets, ","), c.product.Name)
+		}
+		printf("product %s is now bound to: %s", c.product.Name, strings.Join(c.targets, ","))
+	}
+	return nil
+}
+
+// updateQuota sets the quota of a product, the product is otherwise
+// written back as retrieved so fields unknown here are kept
+func (b *bindings) updateQuota(name string, q quota) error {
+	path := fmt.Sprintf(productPathFormat, b.Org, name)
+	req, err := b.ApigeeClient.NewRequest(http.MethodGet, "", nil)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.URL.Path = path // hack: negate client's base URL
+	var p map[string]interface{}
+	resp, err := b.ApigeeClient.Do(req, &p)
+	if err != nil {
+		return errors.Wrap(err, "retrieving product")
+	}
+	resp.Body.Close()
+
+	p["quota"] = q.limit
+	p["quotaInterval"] = q.interval
+	p["quotaTimeUnit"] = q.timeUnit
+	req, err = b.ApigeeClient.NewRequest(http.MethodPut, "", p)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.URL.Path = path // hack: negate client's base URL
+	resp, err = b.ApigeeClient.Do(req, nil)
+	if err != nil {
+		return errors.Wrap(err, "updating product")
+	}
+	return resp.Body.Close()
+}
+
+// prompt returns the trimmed answer, false if the input ended
+func prompt(in *bufio.Scanner, printf shared.FormatFn, format string, args ...interface{}) (string, bool) {
+	printf(format, args...)
+	if !in.Scan() {
+		return "", false
+	}
+	return strings.TrimSpace(in.Text()), true
+}
+
+// parseSelection returns the indexes of a selection of 1-based numbers and
+// ranges (eg. "1,3-4") or "all" of n items
+func parseSelection(s string, n int) ([]int, error) {
+	if strings.ToLower(s) == "all" {
+		selected := make([]int, n)
+		for i := range selected {
+			selected[i] = i
+		}
+		return selected, nil
+	}
+	seen := map[int]bool{}
+	selected := []int{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		from, to := part, part
+		if i := strings.Index(part, "-"); i > 0 {
+			from, to = part[:i], part[i+1:]
+		}
+		first, err1 := strconv.Atoi(strings.TrimSpace(from))
+		last, err2 := strconv.Atoi(strings.TrimSpace(to))
+		if err1 != nil || err2 != nil || first < 1 || last > n || first > last {
+			return nil, fmt.Errorf("invalid selection %q, must be numbers or ranges from 1 to %d", part, n)
+		}
+		for i := first - 1; i < last; i++ {
+			if !seen[i] {
+				seen[i] = true
+				selected = append(selected, i)
+			}
+		}
+	}
+	return selected, nil
+}
+
+func parseQuota(s string) (*quota, error) {
+	m := quotaRE.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return nil, fmt.Errorf(`invalid quota %q, eg. "100 every 1 minute"`, s)
+	}
+	return &quota{limit: m[1], interval: m[2], timeUnit: m[3]}, nil
+}
+
+func quotaOf(p *product.APIProduct) quota {
+	if p.QuotaLimit == "" || p.QuotaLimit == "null" {
+		return quota{}
+	}
+	return quota{limit: p.QuotaLimit, interval: p.QuotaInterval, timeUnit: p.QuotaTimeUnit}
+}
+
+func splitTargets(s string) []string {
+	var targets []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			targets = append(targets, t)
+		}
+	}
+	return targets
+}
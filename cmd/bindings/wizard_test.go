@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bindings
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/apigee/apigee-remote-service-golib/product"
+)
+
+func TestBindingsWizard(t *testing.T) {
+	products := []product.APIProduct{
+		{Name: "a", QuotaLimit: "10", QuotaInterval: "1", QuotaTimeUnit: "minute"},
+		{Name: "b"},
+		{Name: "c"},
+		{Name: "d", Attributes: []product.Attribute{{Name: product.TargetsAttr, Value: "bound"}}},
+	}
+	bound := map[string]string{}
+	var put map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		path := strings.TrimPrefix(r.URL.Path, "/v1/organizations/org/apiproducts")
+		switch {
+		case r.Method == http.MethodPost:
+			var attrs attrUpdate
+			if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+				t.Fatal(err)
+			}
+			for _, a := range attrs.Attributes {
+				if a.Name == product.TargetsAttr {
+					bound[strings.TrimSuffix(path, "/attributes")] = a.Value
+				}
+			}
+			_ = json.NewEncoder(w).Encode(attrs)
+		case r.Method == http.MethodPut:
+			if err := json.NewDecoder(r.Body).Decode(&put); err != nil {
+				t.Fatal(err)
+			}
+			_ = json.NewEncoder(w).Encode(put)
+		case path == "/a":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"name": "a", "quota": "10", "proxies": []string{"p"}})
+		default:
+			_ = json.NewEncoder(w).Encode(product.APIResponse{APIProducts: products})
+		}
+	}))
+	defer ts.Close()
+
+	print := testutil.Printer("TestBindingsWizard")
+	input := "5\n1-2\nt1, t2\n100 every 1 hour\n\ny\n"
+	if err := runWizard(ts.URL, print, input); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	checkContains(t, print.Prints, "  1) a (quota: 10 requests every 1 minute)")
+	checkContains(t, print.Prints, `invalid selection "5", must be numbers or ranges from 1 to 3`)
+	checkContains(t, print.Prints, "    ~ quota: 10 requests every 1 minute -> 100 requests every 1 hour")
+	checkContains(t, print.Prints, "apply 1 change(s)? [y/N]")
+	if len(bound) != 1 || bound["/a"] != "t1,t2" {
+		t.Errorf("want a bound to t1,t2, got %v", bound)
+	}
+	if put["quota"] != "100" || put["quotaTimeUnit"] != "hour" || put["proxies"] == nil {
+		t.Errorf("want quota set keeping proxies, got %v", put)
+	}
+
+	// declined
+	bound = map[string]string{}
+	print = testutil.Printer("TestBindingsWizard")
+	if err := runWizard(ts.URL, print, "all\nt\n\nt\nbad quota\n\n\nn\n"); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	checkContains(t, print.Prints, `invalid quota "bad quota", eg. "100 every 1 minute"`)
+	checkContains(t, print.Prints, "apply 2 change(s)? [y/N]")
+	if len(bound) != 0 {
+		t.Errorf("want nothing bound, got %v", bound)
+	}
+
+	// input ends
+	print = testutil.Printer("TestBindingsWizard")
+	testutil.ErrorContains(t, runWizard(ts.URL, print, "1\n"), "wizard cancelled, nothing changed")
+}
+
+func runWizard(url string, print *testutil.TestPrint, input string) error {
+	flags := []string{"bindings", "wizard", "--opdk", "--runtime", url,
+		"-o", "org", "-e", "env", "-u", "/username/", "-p", "password"}
+	rootArgs := &shared.RootArgs{}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	rootCmd.SetIn(strings.NewReader(input))
+	return rootCmd.Execute()
+}
+
+func checkContains(t *testing.T, prints []string, want string) {
+	for _, p := range prints {
+		if strings.Contains(p, want) {
+			return
+		}
+	}
+	t.Errorf("want output containing %q", want)
+}
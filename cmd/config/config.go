@@ -0,0 +1,211 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// unsetValue is rendered by text/template for values missing from the values
+const unsetValue = "<no value>"
+
+type config struct {
+	*shared.RootArgs
+	valueFiles []string
+	set        []string
+	out        string
+	validate   bool
+}
+
+// renderData is the data model config templates are rendered with, as Helm's
+type renderData struct {
+	Values    map[string]interface{}
+	Org       string
+	Env       string
+	Namespace string
+}
+
+// Cmd returns base command
+func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	cfg := &config{RootArgs: rootArgs}
+
+	c := &cobra.Command{
+		Use:   "config",
+		Short: "Manage apigee-remote-service-envoy configs",
+		Long:  "Manage apigee-remote-service-envoy configs.",
+	}
+
+	c.AddCommand(cmdRender(cfg, printf))
+
+	return c
+}
+
+func cmdRender(cfg *config, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "render [template]",
+		Short: "Render an adapter config from a template and values files",
+		Long: `Render an adapter config, or its ConfigMap, from a Go template and values
+files, eg. a config per environment from a shared template. As with Helm, the
+values files are merged in order, the later taking precedence, then --set,
+and the template refers to them as {{ .Values.key }}. The template also
+has .Org, .Env and .Namespace and the functions default, required, quote,
+indent, nindent, toYaml, lower and upper. The rendered config is validated
+unless --validate=false.`,
+		Args: cobra.ExactArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return cfg.render(args[0], printf)
+		},
+	}
+
+	c.Flags().StringVarP(&cfg.Namespace, "namespace", "n", "apigee",
+		"namespace available to the template as .Namespace")
+	c.Flags().StringArrayVarP(&cfg.valueFiles, "values", "f", nil,
+		"YAML file of values, may be repeated (later files take precedence)")
+	c.Flags().StringArrayVarP(&cfg.set, "set", "", nil,
+		`value of a dotted key taking precedence over the files, may be repeated (eg. "tenant.env=prod")`)
+	c.Flags().StringVarP(&cfg.out, "out", "", "",
+		"file to write the config to (default: stdout)")
+	c.Flags().BoolVarP(&cfg.validate, "validate", "", true,
+		"validate the rendered config as an adapter config")
+
+	return c
+}
+
+func (cfg *config) render(templateFile string, printf shared.FormatFn) error {
+	text, err := ioutil.ReadFile(templateFile)
+	if err != nil {
+		return errors.Wrap(err, "reading template")
+	}
+
+	values := map[string]interface{}{}
+	for _, file := range cfg.valueFiles {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrap(err, "reading values")
+		}
+		var fileValues map[string]interface{}
+		if err := yaml.Unmarshal(data, &fileValues); err != nil {
+			return errors.Wrapf(err, "parsing values %s", file)
+		}
+		mergeValues(values, fileValues)
+	}
+	for _, kv := range cfg.set {
+		if err := setValue(values, kv); err != nil {
+			return err
+		}
+	}
+
+	data := renderData{
+		Values:    values,
+		Org:       cfg.Org,
+		Env:       cfg.Env,
+		Namespace: cfg.Namespace,
+	}
+	cfg.TraceTemplate(templateFile, data)
+	rendered, err := shared.RenderTemplate(filepath.Base(templateFile), string(text), data)
+	if err != nil {
+		return err
+	}
+	if strings.Contains(rendered, unsetValue) {
+		return fmt.Errorf("template %s refers to values not set, rendered as %q", templateFile, unsetValue)
+	}
+	if cfg.validate {
+		if err := validateConfig(rendered); err != nil {
+			return errors.Wrapf(err, "rendered config is invalid")
+		}
+	}
+
+	if cfg.out == "" {
+		printf("%s", strings.TrimSuffix(rendered, "\n"))
+		return nil
+	}
+	if err := ioutil.WriteFile(cfg.out, []byte(rendered), 0600); err != nil {
+		return errors.Wrapf(err, "writing %s", cfg.out)
+	}
+	printf("wrote %s", cfg.out)
+	return nil
+}
+
+// mergeValues deep merges src into dst, maps are merged and other values of
+// src replace those of dst
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[k] = v
+	}
+}
+
+// setValue sets the value of a dotted key of kv, key=value, the value parsed
+// as a YAML scalar (eg. "true" is a bool, "5" a number)
+func setValue(values map[string]interface{}, kv string) error {
+	eq := strings.Index(kv, "=")
+	if eq <= 0 {
+		return fmt.Errorf("--set must be key=value, got %q", kv)
+	}
+	keys := strings.Split(kv[:eq], ".")
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(kv[eq+1:]), &value); err != nil {
+		value = kv[eq+1:]
+	}
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := values[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			values[key] = next
+		}
+		values = next
+	}
+	values[keys[len(keys)-1]] = value
+	return nil
+}
+
+// validateConfig checks the rendered config, plain or the config.yaml of a
+// ConfigMap, has only known fields and passes the adapter's validation
+func validateConfig(rendered string) error {
+	configYAML := []byte(rendered)
+	var configMap server.ConfigMapCRD
+	if err := yaml.NewDecoder(strings.NewReader(rendered)).Decode(&configMap); err == nil && configMap.Kind == "ConfigMap" {
+		data, ok := configMap.Data["config.yaml"]
+		if !ok {
+			return fmt.Errorf("ConfigMap %s has no config.yaml", configMap.Metadata.Name)
+		}
+		configYAML = []byte(data)
+	}
+
+	var c server.Config
+	decoder := yaml.NewDecoder(bytes.NewReader(configYAML))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&c); err != nil {
+		return err
+	}
+	return c.Validate()
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+const configTemplate = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: apigee-remote-service-envoy-{{ .Env }}
+  namespace: {{ .Namespace }}
+data:
+  config.yaml: |
+    tenant:
+      remote_service_api: {{ .Values.runtime }}/remote-service
+      org_name: {{ .Org }}
+      env_name: {{ .Env }}
+      allow_unverified_ssl_cert: {{ .Values.insecure | default false }}
+    products:
+      refresh_rate: {{ .Values.products.refresh | default "2m" }}
+    analytics:
+      fluentd_endpoint: {{ required "fluentd is required" .Values.fluentd }}
+{{- with .Values.extra }}
+    {{- toYaml . | nindent 4 }}
+{{- end }}
+`
+
+func TestConfigRender(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, content string) string {
+		file := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	tmpl := write("config.tmpl", configTemplate)
+	common := write("common.yaml", "runtime: https://runtime.example.com\nfluentd: fluentd:24224\nproducts:\n  refresh: 5m\n")
+	prod := write("prod.yaml", "products:\n  refresh: 1m\nextra:\n  global:\n    keep_alive_max_connection_age: 10m\n")
+
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestConfigRender")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"config", "render", tmpl, "-o", "org", "-e", "prod", "-n", "ns"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+		return print, rootCmd.Execute()
+	}
+
+	print, err := run("-f", common, "-f", prod, "--set", "insecure=true")
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	print.Check(t, []string{`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: apigee-remote-service-envoy-prod
+  namespace: ns
+data:
+  config.yaml: |
+    tenant:
+      remote_service_api: https://runtime.example.com/remote-service
+      org_name: org
+      env_name: prod
+      allow_unverified_ssl_cert: true
+    products:
+      refresh_rate: 1m
+    analytics:
+      fluentd_endpoint: fluentd:24224
+    global:
+      keep_alive_max_connection_age: 10m`})
+
+	out := filepath.Join(dir, "test.yaml")
+	print, err = run("-f", common, "--set", "products.refresh=30s", "--out", out)
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	print.Check(t, []string{"wrote " + out})
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "refresh_rate: 30s") || !strings.Contains(string(data), "allow_unverified_ssl_cert: false") {
+		t.Errorf("unexpected config:\n%s", data)
+	}
+
+	bad := write("bad.yaml", "runtime: https://runtime.example.com\nfluentd: fluentd:24224\nextra:\n  tenant_typo: x\n")
+	for args, wantErr := range map[string]string{
+		"":                        "fluentd is required",
+		"-f " + common + " --set": "--set must be key=value",
+		"--set fluentd=f":         "refers to values not set",
+		"-f " + bad:               "field tenant_typo not found",
+		"-f " + filepath.Join(dir, "missing.yaml"): "reading values",
+	} {
+		fields := strings.Fields(args)
+		if strings.HasSuffix(args, "--set") {
+			fields = append(fields, "novalue")
+		}
+		_, err := run(fields...)
+		testutil.ErrorContains(t, err, wantErr)
+	}
+
+	if _, err := run("-f", bad, "--validate=false"); err != nil {
+		t.Errorf("want no error without validation, got %v", err)
+	}
+}
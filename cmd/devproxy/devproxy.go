@@ -0,0 +1,211 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package devproxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const redacted = "<redacted>"
+
+// sensitive values of form bodies, such as OAuth token requests
+var sensitiveFormFields = map[string]bool{
+	"password":      true,
+	"client_secret": true,
+	"refresh_token": true,
+	"assertion":     true,
+}
+
+// exchange is the incoming request, kept in its context for ModifyResponse
+type exchange struct {
+	url  string // path and query as requested of the proxy
+	body []byte
+}
+
+type exchangeKey struct{}
+
+type devProxy struct {
+	*shared.RootArgs
+	listen   string
+	fixtures string
+
+	mu       sync.Mutex
+	recorded []testutil.Fixture
+}
+
+// Cmd returns base command
+func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	d := &devProxy{RootArgs: rootArgs}
+
+	c := &cobra.Command{
+		Use:   "devproxy",
+		Short: "Record management API requests as test fixtures",
+		Long: `The devproxy command forwards requests to the management API and records each exchange
+to a fixtures file until interrupted. Point another command at it with --management to capture
+realistic fixtures for bug reports and tests, see testutil.FixtureHandler. Secrets, passwords and
+tokens in bodies are redacted, headers and binary payloads such as proxy bundles are not recorded.
+Runtime requests don't go through the proxy.`,
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return rootArgs.Resolve(true, false)
+		},
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			handler, err := d.handler()
+			if err != nil {
+				return err
+			}
+			listener, err := net.Listen("tcp", d.listen)
+			if err != nil {
+				return errors.Wrapf(err, "listening on %s", d.listen)
+			}
+			srv := &http.Server{Handler: handler}
+			go func() {
+				sigs := make(chan os.Signal, 1)
+				signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+				<-sigs
+				signal.Stop(sigs)
+				_ = srv.Shutdown(context.Background())
+			}()
+
+			printf("recording requests to %s in %s, run commands with --management http://%s",
+				d.ManagementBase, d.fixtures, listener.Addr())
+			if err := srv.Serve(listener); err != http.ErrServerClosed {
+				return err
+			}
+			printf("%d exchanges recorded in %s", len(d.recorded), d.fixtures)
+			return nil
+		},
+	}
+
+	c.Flags().StringVarP(&rootArgs.ManagementBase, "management", "m",
+		shared.DefaultManagementBase, "Apigee management base URL requests are forwarded to")
+	c.Flags().StringVarP(&d.listen, "listen", "", "localhost:8091",
+		"address to listen on")
+	c.Flags().StringVarP(&d.fixtures, "fixtures", "", "fixtures.json",
+		"file the recorded exchanges are written to, rewritten after each one")
+
+	return c
+}
+
+// handler returns the proxy to the management API recording the exchanges
+func (d *devProxy) handler() (http.Handler, error) {
+	target, err := url.Parse(d.ManagementBase)
+	if err != nil || target.Host == "" {
+		return nil, fmt.Errorf("--management %q is not an absolute URL", d.ManagementBase)
+	}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = d.Transport(d.InsecureSkipVerify)
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		director(req)
+		req.Host = target.Host
+		req.Header.Del("Accept-Encoding") // bodies are recorded decompressed
+	}
+	proxy.ModifyResponse = func(resp *http.Response) error {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req := resp.Request
+		ex := req.Context().Value(exchangeKey{}).(*exchange)
+		return d.record(testutil.Fixture{
+			Method:      req.Method,
+			URL:         ex.url,
+			RequestBody: redactBody(ex.body, req.Header.Get("Content-Type")),
+			Status:      resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+			Body:        redactBody(body, resp.Header.Get("Content-Type")),
+		})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		ex := &exchange{url: r.URL.RequestURI(), body: body}
+		proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), exchangeKey{}, ex)))
+	}), nil
+}
+
+// record appends f to the fixtures and rewrites the file
+func (d *devProxy) record(f testutil.Fixture) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.recorded = append(d.recorded, f)
+	data, err := json.MarshalIndent(d.recorded, "", "  ")
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(ioutil.WriteFile(d.fixtures, data, 0600), "writing %s", d.fixtures)
+}
+
+// redactBody returns JSON and form bodies with their sensitive values
+// redacted, other payloads are described by size
+func redactBody(body []byte, contentType string) string {
+	if len(body) == 0 {
+		return ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/json":
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err == nil {
+			var buf bytes.Buffer
+			encoder := json.NewEncoder(&buf)
+			encoder.SetEscapeHTML(false)
+			if err := encoder.Encode(apigee.Redact(v)); err == nil {
+				return strings.TrimSpace(buf.String())
+			}
+		}
+	case "application/x-www-form-urlencoded":
+		if values, err := url.ParseQuery(string(body)); err == nil {
+			for k := range values {
+				if sensitiveFormFields[k] {
+					values.Set(k, redacted)
+				}
+			}
+			return values.Encode()
+		}
+	case "text/plain", "application/xml", "text/xml":
+		return string(body)
+	}
+	return fmt.Sprintf("<%d bytes %s>", len(body), mediaType)
+}
@@ -0,0 +1,118 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package devproxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestDevProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/organizations/org/developers/dev/apps/app":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"name":"app","credentials":[{"consumerKey":"key","consumerSecret":"s3cret"}]}`))
+		case "/oauth/token":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			_, _ = w.Write([]byte(`{"access_token":"t0ken","expires_in":1799}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer upstream.Close()
+
+	dir, err := ioutil.TempDir("", "devproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "fixtures.json")
+
+	d := &devProxy{RootArgs: &shared.RootArgs{ManagementBase: upstream.URL}, fixtures: file}
+	handler, err := d.handler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/v1/organizations/org/developers/dev/apps/app?expand=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "s3cret") {
+		t.Errorf("want the response forwarded unredacted, got %s", body)
+	}
+	if _, err := http.PostForm(ts.URL+"/oauth/token", url.Values{"username": {"me"}, "password": {"pw"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := http.Get(ts.URL + "/v1/organizations/org/apis/none"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"s3cret", "t0ken", "pw"} {
+		if strings.Contains(string(data), `"`+secret) || strings.Contains(string(data), "="+secret) {
+			t.Errorf("want %s redacted, got %s", secret, data)
+		}
+	}
+
+	fixtures, err := testutil.LoadFixtures(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) != 3 {
+		t.Fatalf("want 3 fixtures, got %d", len(fixtures))
+	}
+	if f := fixtures[1]; f.Method != http.MethodPost || f.URL != "/oauth/token" || f.RequestBody != "password=%3Credacted%3E&username=me" {
+		t.Errorf("want token request recorded redacted, got %#v", f)
+	}
+
+	replay := httptest.NewServer(testutil.FixtureHandler(fixtures))
+	defer replay.Close()
+	for url, want := range map[string]int{
+		"/v1/organizations/org/developers/dev/apps/app?expand=true": http.StatusOK,
+		"/v1/organizations/org/apis/none":                           http.StatusNotFound,
+		"/v1/organizations/org/apis/unrecorded":                     http.StatusNotFound,
+	} {
+		resp, err := http.Get(replay.URL + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("want %d replaying %s, got %d", want, url, resp.StatusCode)
+		}
+		if want == http.StatusOK && !strings.Contains(string(body), `"consumerSecret":"<redacted>"`) {
+			t.Errorf("want redacted fixture replayed, got %s", body)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+)
+
+const profileMetricsFlag = "profile-metrics"
+
+// profileMetrics is the value of --profile-metrics. The CPU profile starts
+// as the flag is parsed, so it covers the whole command.
+type profileMetrics struct {
+	args    []string // of the root command
+	dir     string
+	prefix  string // of the files of this run
+	started time.Time
+	cpu     *os.File
+}
+
+// profileSummary is the summary of a run written with its profiles
+type profileSummary struct {
+	Command       string  `json:"command"`
+	Version       string  `json:"version"`
+	Seconds       float64 `json:"seconds"`
+	PeakRSS       uint64  `json:"peakRSSBytes,omitempty"` // not on windows
+	TotalAlloc    uint64  `json:"totalAllocBytes"`
+	Mallocs       uint64  `json:"mallocs"`
+	NumGC         uint32  `json:"numGC"`
+	CPUProfile    string  `json:"cpuProfile"`
+	HeapProfile   string  `json:"heapProfile"`
+	AllocsProfile string  `json:"allocsProfile"`
+}
+
+func (m *profileMetrics) String() string { return m.dir }
+
+func (m *profileMetrics) Type() string { return "dir" }
+
+func (m *profileMetrics) Set(dir string) error {
+	if m.cpu != nil {
+		return fmt.Errorf("--%s given more than once", profileMetricsFlag)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrapf(err, "creating %s", dir)
+	}
+	m.dir = dir
+	m.started = time.Now()
+	m.prefix = filepath.Join(dir, fmt.Sprintf("%s-%d", m.started.UTC().Format("20060102T150405"), os.Getpid()))
+	cpu, err := os.Create(m.prefix + ".cpu.pprof")
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(cpu); err != nil {
+		cpu.Close()
+		return errors.Wrap(err, "starting CPU profile")
+	}
+	m.cpu = cpu
+	return nil
+}
+
+// stop ends the CPU profile and writes the heap and allocation profiles and
+// the summary of the run
+func (m *profileMetrics) stop(command string) (*profileSummary, error) {
+	pprof.StopCPUProfile()
+	err := m.cpu.Close()
+	cpuProfile := m.cpu.Name()
+	m.cpu = nil
+
+	summary := &profileSummary{
+		Command:       command,
+		Version:       shared.BuildInfo.Version,
+		Seconds:       time.Since(m.started).Seconds(),
+		CPUProfile:    cpuProfile,
+		HeapProfile:   m.prefix + ".heap.pprof",
+		AllocsProfile: m.prefix + ".allocs.pprof",
+	}
+	runtime.GC() // up-to-date heap profile
+	for file, profile := range map[string]string{summary.HeapProfile: "heap", summary.AllocsProfile: "allocs"} {
+		err = multierr.Append(err, writeProfile(file, profile))
+	}
+
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	summary.TotalAlloc = stats.TotalAlloc
+	summary.Mallocs = stats.Mallocs
+	summary.NumGC = stats.NumGC
+	summary.PeakRSS, _ = peakRSS()
+
+	data, e := json.MarshalIndent(summary, "", "  ")
+	if e == nil {
+		e = ioutil.WriteFile(m.prefix+".summary.json", data, 0644)
+	}
+	return summary, multierr.Append(err, e)
+}
+
+func writeProfile(file, profile string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := pprof.Lookup(profile).WriteTo(f, 0); err != nil {
+		f.Close()
+		return errors.Wrapf(err, "writing %s profile", profile)
+	}
+	return f.Close()
+}
+
+// StopProfileMetrics writes the profiles and summary of the run if
+// --profile-metrics was given, printing the summary
+func StopProfileMetrics(c *cobra.Command, printf shared.FormatFn) {
+	f := c.PersistentFlags().Lookup(profileMetricsFlag)
+	if f == nil {
+		return
+	}
+	m, ok := f.Value.(*profileMetrics)
+	if !ok || m.cpu == nil {
+		return
+	}
+	command := c.Name()
+	if sub, _, err := c.Find(m.args); err == nil {
+		command = sub.CommandPath() // not the args, they may hold credentials
+	}
+	summary, err := m.stop(command)
+	if err != nil {
+		printf("warning: writing profiles to %s: %v", m.dir, err)
+	}
+	rss := "unknown"
+	if summary.PeakRSS > 0 {
+		rss = formatBytes(summary.PeakRSS)
+	}
+	printf("profile: %.2fs, peak RSS %s, %s allocated in %d allocations, %d GCs, profiles written to %s.*",
+		summary.Seconds, rss, formatBytes(summary.TotalAlloc), summary.Mallocs, summary.NumGC, m.prefix)
+}
+
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,40 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// checkApigeeX checks the organization is Apigee X
+func (p *provision) checkApigeeX() error {
+	req, err := p.ApigeeClient.NewRequestNoEnv(http.MethodGet, "", nil)
+	if err != nil {
+		return err
+	}
+	org := struct {
+		RuntimeType string `json:"runtimeType"`
+	}{}
+	if _, err := p.ApigeeClient.Do(req, &org); err != nil {
+		return errors.Wrapf(err, "retrieving organization %s", p.Org)
+	}
+	if org.RuntimeType != runtimeTypeCloud {
+		return fmt.Errorf("organization %s is not Apigee X (runtime type %s), remove --apigee-x", p.Org, org.RuntimeType)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func serveEnvGroups(m *http.ServeMux, org, runtimeType string) {
+	m.HandleFunc("/v1/organizations/"+org, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name":"` + org + `","runtimeType":"` + runtimeType + `"}`))
+	})
+	m.HandleFunc("/v1/organizations/"+org+"/envgroups", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"environmentGroups":[
+			{"name":"empty"},
+			{"name":"public","hostnames":["api.example.com","alt.example.com"]},
+			{"name":"internal","hostnames":["internal.example.com"]}
+		]}`))
+	})
+	m.HandleFunc("/v1/organizations/"+org+"/envgroups/public/attachments", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"environmentGroupAttachments":[{"environment":"test"},{"environment":"prod"}]}`))
+	})
+	m.HandleFunc("/v1/organizations/"+org+"/envgroups/internal/attachments", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"environmentGroupAttachments":[{"environment":"test"},{"environment":"private"}]}`))
+	})
+}
+
+func TestCheckApigeeX(t *testing.T) {
+	m := http.NewServeMux()
+	serveEnvGroups(m, "x", runtimeTypeCloud)
+	serveEnvGroups(m, "hybrid", "HYBRID")
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	newProvision := func(org string) *provision {
+		rootArgs := &shared.RootArgs{
+			Org:          org,
+			Env:          "test",
+			IsGCPManaged: true,
+			ClientOpts:   &apigee.EdgeClientOptions{Org: org, Env: "test", GCPManaged: true, Auth: &apigee.EdgeAuth{SkipAuth: true}},
+		}
+		setTestUrls(rootArgs, ts.URL)
+		return &provision{RootArgs: rootArgs, apigeeX: true}
+	}
+
+	if err := newProvision("x").checkApigeeX(); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+	testutil.ErrorContains(t, newProvision("hybrid").checkApigeeX(),
+		"organization hybrid is not Apigee X (runtime type HYBRID), remove --apigee-x")
+}
+
+func TestProvisionApigeeX(t *testing.T) {
+	m := serveMux(t)
+	serveEnvGroups(m, "gcp", runtimeTypeCloud)
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	print := testutil.Printer("TestProvisionApigeeX")
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"provision", "-o", "gcp", "-e", "test", "-m", ts.URL, "-t", "token", "--apigee-x"}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	config := print.Prints[len(print.Prints)-1]
+	print.CheckPrefix(t, []string{
+		"# Configuration for apigee-remote-service-envoy (platform: GCP)",
+		"# generated by apigee-remote-service-cli provision on",
+		"# for an Apigee X adapter running outside the cluster, analytics are uploaded through the management API",
+		`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: apigee-remote-service-envoy
+  namespace: ""
+data:
+  config.yaml:`,
+	})
+	for _, unwanted := range []string{"fluentd_endpoint", defaultApigeeCAFile} {
+		if strings.Contains(config, unwanted) {
+			t.Errorf("want no %s in the config of Apigee X", unwanted)
+		}
+	}
+
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"provision", "-o", "gcp", "-e", "test", "-u", "me", "-p", "password", "--legacy", "--apigee-x"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	testutil.ErrorContains(t, rootCmd.Execute(), "--apigee-x can't be combined with --legacy or --opdk")
+}
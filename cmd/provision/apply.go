@@ -0,0 +1,269 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/k8s"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v3"
+)
+
+// applyOptions are the flags applying the Kubernetes resources to clusters
+type applyOptions struct {
+	enabled    bool
+	kubeconfig string
+	contexts   []string
+	resume     string         // apply record of a failed apply to complete
+	prune      bool           // delete the resources of previous applies
+	clusters   []*k8s.Cluster // of contexts, loaded by validate
+	record     *applyRecord   // of resume, loaded by validate
+}
+
+// applyRecord is written before applying and removed once all the resources
+// are applied to all the contexts, for --resume to complete a failed apply
+// with the same resources. The resources applied are annotated with the ID
+// in each cluster.
+type applyRecord struct {
+	ID        string   `yaml:"applyID"`
+	Set       string   `yaml:"applySet"`
+	Contexts  []string `yaml:"contexts"`
+	Namespace string   `yaml:"namespace,omitempty"`
+	Resources string   `yaml:"resources"`
+}
+
+// clusterApply is the outcome of applying the resources to a cluster
+type clusterApply struct {
+	context string
+	applied []string
+	err     error
+}
+
+// validate loads the clusters of the kubeconfig contexts to fail early
+func (a *applyOptions) validate(p *provision, changed func(string) bool) error {
+	if !a.enabled {
+		if changed("kubeconfig") || changed("context") || a.resume != "" || a.prune {
+			return fmt.Errorf("--kubeconfig, --context, --resume and --prune only valid with --apply")
+		}
+		return nil
+	}
+	if p.dryRun || p.verifyOnly || p.configOut == configOutNative {
+		return fmt.Errorf("--apply can't be combined with --dry-run, --verify-only or --config-out %s", configOutNative)
+	}
+	contexts := a.contexts
+	if a.resume != "" {
+		if len(p.envs) > 1 {
+			return fmt.Errorf("--resume completes the apply of a single environment")
+		}
+		data, err := ioutil.ReadFile(a.resume)
+		if err != nil {
+			return errors.Wrap(err, "reading --resume")
+		}
+		a.record = &applyRecord{}
+		if err := yaml.Unmarshal(data, a.record); err != nil {
+			return errors.Wrapf(err, "parsing --resume %s", a.resume)
+		}
+		if a.record.ID == "" || a.record.Set == "" || len(a.record.Contexts) == 0 {
+			return fmt.Errorf("--resume %s is not an apply record", a.resume)
+		}
+		if len(contexts) > 0 {
+			return fmt.Errorf("--resume applies to the contexts of its record, %s, not --context", strings.Join(a.record.Contexts, ", "))
+		}
+		contexts = a.record.Contexts
+	}
+	if len(contexts) == 0 {
+		contexts = []string{""} // the current context
+	}
+	a.clusters = nil
+	for _, context := range contexts {
+		if context == "" && len(contexts) > 1 {
+			return fmt.Errorf("--context must not list an empty context")
+		}
+		cluster, err := k8s.LoadCluster(a.kubeconfig, context)
+		if err != nil {
+			return errors.Wrap(err, "--apply")
+		}
+		for _, c := range a.clusters {
+			if c.Context == cluster.Context {
+				return fmt.Errorf("--context lists %s twice", c.Context)
+			}
+		}
+		a.clusters = append(a.clusters, cluster)
+	}
+	return nil
+}
+
+// applySet names the apply set of the resources of the environment, a
+// label value of at most 63 characters
+func (p *provision) applySet() string {
+	set := fmt.Sprintf("remote-service.%s.%s", p.Org, p.Env)
+	if len(set) > 63 {
+		sum := sha256.Sum256([]byte(p.Org + "/" + p.Env))
+		set = "remote-service." + hex.EncodeToString(sum[:16])
+	}
+	return set
+}
+
+// applyRecordFile is where the record of an apply of the environment is
+// kept until it completes, in the workspace if any or else in the user's
+// config directory, never in the working directory as it holds the policy
+// secret
+func (p *provision) applyRecordFile() (string, error) {
+	name := fmt.Sprintf("apply-%s-%s.yaml", p.Org, p.Env)
+	if p.Workspace != nil {
+		return filepath.Join(p.Workspace.ArtifactDir(p.Org, p.Env), name), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "apigee-remote-service-cli", "apply", name), nil
+}
+
+// applyResources creates or updates the Kubernetes resources in the clusters
+// concurrently, in the namespace of each context if provisioned without one
+// (Apigee X). The resources of a failed apply are kept in an apply record
+// for --resume, whose location is printed.
+func (p *provision) applyResources(resources string, printf shared.FormatFn) ([]clusterApply, error) {
+	record := applyRecord{
+		ID:        time.Now().UTC().Format("20060102t150405.000000000"),
+		Set:       p.applySet(),
+		Namespace: p.Namespace,
+		Resources: resources,
+	}
+	for _, cluster := range p.apply.clusters {
+		record.Contexts = append(record.Contexts, cluster.Context)
+	}
+	file, err := p.applyRecordFile()
+	if err != nil {
+		return nil, errors.Wrap(err, "locating the apply record")
+	}
+	if err := writeApplyRecord(file, record); err != nil {
+		return nil, errors.Wrap(err, "writing the apply record")
+	}
+	results, err := p.applyRecorded(record, false)
+	if err != nil {
+		if p.rollbackOnError { // the credentials of the resources are reverted
+			_ = os.Remove(file)
+			return results, err
+		}
+		printf("# apply record %s kept for --resume, it holds the policy secret: keep it safe", file)
+		return results, errors.Wrapf(err, "apply %s incomplete, complete it with --apply --resume %s", record.ID, file)
+	}
+	return results, os.Remove(file)
+}
+
+// resumeApply completes the apply of --resume, applying the resources not
+// applied yet, and prints them
+func (p *provision) resumeApply(printf shared.FormatFn) error {
+	results, err := p.applyRecorded(*p.apply.record, true)
+	printApplied(results, printf)
+	if err != nil {
+		return err
+	}
+	printf("# apply %s completed", p.apply.record.ID)
+	return os.Remove(p.apply.resume)
+}
+
+// applyRecorded applies the resources of record to each cluster concurrently,
+// skipping the ones applied by it on resume, and prunes those of previous
+// applies with --prune. It fails naming the contexts failed once all are
+// done, the others being applied.
+func (p *provision) applyRecorded(record applyRecord, resume bool) ([]clusterApply, error) {
+	opts := k8s.ApplyOptions{Namespace: record.Namespace, Set: record.Set, ID: record.ID, Resume: resume}
+	results := make([]clusterApply, len(p.apply.clusters))
+	var wg sync.WaitGroup
+	for i, cluster := range p.apply.clusters {
+		wg.Add(1)
+		go func(result *clusterApply, cluster *k8s.Cluster) {
+			defer wg.Done()
+			result.context = cluster.Context
+			result.applied, result.err = p.applyCluster(cluster, []byte(record.Resources), opts)
+		}(&results[i], cluster)
+	}
+	wg.Wait()
+
+	var errs error
+	var failed, succeeded []string
+	for _, result := range results {
+		if result.err != nil {
+			failed = append(failed, result.context)
+			errs = multierr.Append(errs, errors.Wrapf(result.err, "Kubernetes context %s", result.context))
+		} else {
+			succeeded = append(succeeded, result.context)
+		}
+	}
+	if errs != nil && len(results) > 1 {
+		applied := "none"
+		if len(succeeded) > 0 {
+			applied = strings.Join(succeeded, ", ")
+		}
+		errs = errors.Wrapf(errs, "applying to %d of %d Kubernetes contexts failed (%s), applied to %s",
+			len(failed), len(results), strings.Join(failed, ", "), applied)
+	}
+	return results, errs
+}
+
+// applyCluster applies and, with --prune, prunes the resources in a cluster
+func (p *provision) applyCluster(cluster *k8s.Cluster, resources []byte, opts k8s.ApplyOptions) ([]string, error) {
+	applied, skipped, err := cluster.Apply(resources, opts)
+	if err != nil {
+		return applied, errors.Wrap(err, "applying")
+	}
+	for _, resource := range skipped {
+		applied = append(applied, resource+" (already applied)")
+	}
+	if p.apply.prune {
+		pruned, err := cluster.Prune(resources, opts)
+		for _, resource := range pruned {
+			applied = append(applied, resource+" (pruned)")
+		}
+		if err != nil {
+			return applied, errors.Wrap(err, "pruning")
+		}
+	}
+	return applied, nil
+}
+
+// printApplied prints the resources applied to each cluster
+func printApplied(results []clusterApply, printf shared.FormatFn) {
+	for _, result := range results {
+		for _, resource := range result.applied {
+			printf("# applied %s to Kubernetes context %s", resource, result.context)
+		}
+	}
+}
+
+func writeApplyRecord(file string, record applyRecord) error {
+	data, err := yaml.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil { // the resources hold the policy secret
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0600)
+}
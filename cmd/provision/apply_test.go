@@ -0,0 +1,184 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestProvisionApply(t *testing.T) {
+	ts := httptest.NewServer(handler(t))
+	defer ts.Close()
+	var mu sync.Mutex // the clusters are applied concurrently
+	applied := map[string][]string{}
+	failSecret := map[string]bool{}
+	newCluster := func(name string) *httptest.Server {
+		return httptest.NewTLSServer(testutil.KubeDiscovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet && r.URL.Query().Get("labelSelector") != "" { // no previous apply
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{"kind":"List","apiVersion":"v1","items":[]}`))
+				return
+			}
+			if r.Method == http.MethodGet { // not applied by the resume yet
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","code":404,"reason":"NotFound"}`))
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			applied[name] = append(applied[name], r.Method+" "+r.URL.Path)
+			if failSecret[name] && strings.Contains(r.URL.Path, "/secrets/") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"apiVersion":"v1","kind":"Secret"}`))
+		})))
+	}
+	dev, prod := newCluster("dev"), newCluster("prod")
+	defer dev.Close()
+	defer prod.Close()
+
+	duration = 1
+	interval = 500
+
+	dir, err := ioutil.TempDir("", "apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeconfig, []byte(`contexts:
+- name: dev
+  context: {cluster: dev, user: dev}
+- name: prod
+  context: {cluster: prod, user: dev}
+clusters:
+- name: dev
+  cluster: {server: "`+dev.URL+`", insecure-skip-tls-verify: true}
+- name: prod
+  cluster: {server: "`+prod.URL+`", insecure-skip-tls-verify: true}
+users:
+- name: dev
+  user: {token: t0ken}
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	os.Setenv("XDG_CONFIG_HOME", dir)
+	defer os.Setenv("XDG_CONFIG_HOME", configHome)
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestProvisionApply")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-n", "ns", "-t", "token",
+			"--apply", "--kubeconfig", kubeconfig}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+
+	print, err := run("--context", "dev")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.CheckPrefix(t, []string{
+		"# Configuration for apigee-remote-service-envoy (platform: GCP)",
+		"# generated by apigee-remote-service-cli provision on",
+		"# applied ConfigMap ns/apigee-remote-service-envoy to Kubernetes context dev",
+		"# applied Secret ns/gcp-test-policy-secret to Kubernetes context dev",
+		"apiVersion: v1",
+	})
+	resources := []string{
+		"PATCH /api/v1/namespaces/ns/configmaps/apigee-remote-service-envoy",
+		"PATCH /api/v1/namespaces/ns/secrets/gcp-test-policy-secret",
+	}
+	if want := map[string][]string{"dev": resources}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("want %v, got %v", want, applied)
+	}
+
+	// applied to both contexts, prod fails, the record is kept in the config
+	// directory without a workspace
+	recordFile := filepath.Join(dir, "apigee-remote-service-cli", "apply", "apply-gcp-test.yaml")
+	applied, failSecret["prod"] = map[string][]string{}, true
+	print, err = run("--context", "dev,prod")
+	testutil.ErrorContains(t, err, "incomplete, complete it with --apply --resume "+recordFile+": "+
+		"applying to 1 of 2 Kubernetes contexts failed (prod), applied to dev: Kubernetes context prod: applying")
+	print.CheckPrefix(t, []string{
+		"# apply record " + recordFile + " kept for --resume, it holds the policy secret: keep it safe",
+	})
+	if want := map[string][]string{"dev": resources, "prod": resources}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("want %v, got %v", want, applied)
+	}
+	if info, err := os.Stat(filepath.Dir(recordFile)); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("want apply record directory accessible by owner only, got %v, %v", info, err)
+	}
+	record, err := ioutil.ReadFile(recordFile)
+	if err != nil {
+		t.Fatalf("want apply record: %v", err)
+	}
+	if !strings.Contains(string(record), "applySet: remote-service.gcp.test") || !strings.Contains(string(record), "contexts:\n    - dev\n    - prod") {
+		t.Errorf("unexpected apply record %s", record)
+	}
+
+	_, err = run("--resume", recordFile, "--context", "prod")
+	testutil.ErrorContains(t, err, "--resume applies to the contexts of its record, dev, prod, not --context")
+
+	applied, failSecret["prod"] = map[string][]string{}, false
+	print, err = run("--resume", recordFile)
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.CheckPrefix(t, []string{
+		"# applied ConfigMap ns/apigee-remote-service-envoy to Kubernetes context dev",
+		"# applied Secret ns/gcp-test-policy-secret to Kubernetes context dev",
+		"# applied ConfigMap ns/apigee-remote-service-envoy to Kubernetes context prod",
+		"# applied Secret ns/gcp-test-policy-secret to Kubernetes context prod",
+		"# apply ",
+	})
+	if _, err := os.Stat(recordFile); !os.IsNotExist(err) {
+		t.Errorf("want apply record removed once completed, got %v", err)
+	}
+
+	for _, tc := range []struct {
+		flags   []string
+		wantErr string
+	}{
+		{[]string{"--kubeconfig", kubeconfig}, "--kubeconfig, --context, --resume and --prune only valid with --apply"},
+		{[]string{"--prune"}, "--kubeconfig, --context, --resume and --prune only valid with --apply"},
+		{[]string{"--apply", "--kubeconfig", kubeconfig, "--resume", filepath.Join(dir, "none.yaml")}, "reading --resume"},
+		{[]string{"--apply", "--kubeconfig", kubeconfig, "--dry-run"}, "--apply can't be combined with --dry-run, --verify-only or --config-out native"},
+		{[]string{"--apply", "--kubeconfig", kubeconfig}, "--apply: kubeconfig " + kubeconfig + " has no current context, use --context"},
+		{[]string{"--apply", "--kubeconfig", kubeconfig, "--context", "dev,dev"}, "--context lists dev twice"},
+	} {
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-n", "ns", "-t", "token"}, tc.flags...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		testutil.ErrorContains(t, rootCmd.Execute(), tc.wantErr)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+)
+
+const authProxyBasePath = "/remote-service"
+
+// checkBasePath fails, naming them, if other proxies deployed to the environment
+// claim the base path of the remote-service proxy, on the same virtual hosts
+// for legacy or OPDK, as its deployment would fail. Proxies that can't be
+// inspected are warned about and not checked.
+func (p *provision) checkBasePath(printf shared.FormatFn) error {
+	deployed, _, err := p.ApigeeClient.Proxies.ListDeployments()
+	if err != nil {
+		printf("warning: unable to list the proxies deployed to environment %s, base path not checked: %v", p.Env, err)
+		return nil
+	}
+
+	var conflicts []string
+	for _, d := range deployed {
+		if d.Name == authProxyName {
+			continue
+		}
+		conflict, err := p.claimsBasePath(d)
+		if err != nil {
+			printf("warning: unable to inspect proxy %s revision %s, base path not checked: %v", d.Name, d.Revision, err)
+			continue
+		}
+		if conflict != "" {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("base path %s is already claimed in environment %s by: %s, undeploy them or use another environment",
+			authProxyBasePath, p.Env, strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// claimsBasePath describes the deployed revision if it claims the base path
+// of the remote-service proxy, empty if not
+func (p *provision) claimsBasePath(d apigee.DeployedRevision) (string, error) {
+	rev, _, err := p.ApigeeClient.Proxies.GetRevision(d.Name, d.Revision)
+	if err != nil {
+		return "", err
+	}
+	if p.IsGCPManaged {
+		for _, bp := range rev.BasePaths {
+			if sameBasePath(bp, authProxyBasePath) {
+				return fmt.Sprintf("proxy %s revision %s", d.Name, d.Revision), nil
+			}
+		}
+		return "", nil
+	}
+
+	claims := map[string]bool{}
+	for _, vh := range p.vhosts {
+		claims[vh] = true
+	}
+	for _, name := range rev.ProxyEndpoints {
+		ep, _, err := p.ApigeeClient.Proxies.GetProxyEndpoint(d.Name, d.Revision, name)
+		if err != nil {
+			return "", err
+		}
+		if !sameBasePath(ep.Connection.BasePath, authProxyBasePath) {
+			continue
+		}
+		var common []string
+		for _, vh := range ep.Connection.VirtualHosts {
+			if claims[vh] {
+				common = append(common, vh)
+			}
+		}
+		if len(common) > 0 {
+			return fmt.Sprintf("proxy %s revision %s (virtual hosts %s)", d.Name, d.Revision, strings.Join(common, ",")), nil
+		}
+	}
+	return "", nil
+}
+
+func sameBasePath(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
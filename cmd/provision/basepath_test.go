@@ -0,0 +1,106 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestBasePathLegacy(t *testing.T) {
+	m := http.NewServeMux()
+	m.HandleFunc("/v1/organizations/legacy/environments/test/deployments", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"aPIProxy": [
+			{"name": "remote-service", "revision": [{"name": "1", "state": "deployed"}]},
+			{"name": "other", "revision": [{"name": "2", "state": "deployed"}, {"name": "1", "state": "undeployed"}]},
+			{"name": "internal-only", "revision": [{"name": "4", "state": "deployed"}]}
+		]}`))
+	})
+	m.HandleFunc("/v1/organizations/legacy/apis/other/revisions/2", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "other", "revision": "2", "proxyEndpoints": ["default"]}`))
+	})
+	m.HandleFunc("/v1/organizations/legacy/apis/other/revisions/2/proxies/default", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "default", "connection": {"basePath": "/remote-service/", "virtualHost": ["secure", "custom-vh"]}}`))
+	})
+	m.HandleFunc("/v1/organizations/legacy/apis/internal-only/revisions/4", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "internal-only", "revision": "4", "proxyEndpoints": ["default"]}`))
+	})
+	m.HandleFunc("/v1/organizations/legacy/apis/internal-only/revisions/4/proxies/default", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "default", "connection": {"basePath": "/remote-service", "virtualHost": ["internal"]}}`))
+	})
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	rootArgs := &shared.RootArgs{
+		Env:        "test",
+		ClientOpts: &apigee.EdgeClientOptions{Org: "legacy", Env: "test", Auth: &apigee.EdgeAuth{SkipAuth: true}},
+	}
+	setTestUrls(rootArgs, ts.URL)
+	p := &provision{RootArgs: rootArgs, vhosts: []string{"default", "secure"}}
+	print := testutil.Printer("TestBasePathLegacy")
+	testutil.ErrorContains(t, p.checkBasePath(print.Printf),
+		"base path /remote-service is already claimed in environment test by: proxy other revision 2 (virtual hosts secure)")
+
+	p.vhosts = []string{"default"}
+	if err := p.checkBasePath(print.Printf); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+	print.Check(t, nil)
+}
+
+func TestBasePathGCP(t *testing.T) {
+	listed := true
+	m := http.NewServeMux()
+	m.HandleFunc("/v1/organizations/gcp/environments/test/deployments", func(w http.ResponseWriter, r *http.Request) {
+		if !listed {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"deployments": [
+			{"environment": "test", "apiProxy": "remote-service", "revision": "3"},
+			{"environment": "test", "apiProxy": "other", "revision": "1"},
+			{"environment": "test", "apiProxy": "gone", "revision": "5"}
+		]}`))
+	})
+	m.HandleFunc("/v1/organizations/gcp/apis/other/revisions/1", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"name": "other", "revision": "1", "basepaths": ["/other", "/remote-service"]}`))
+	})
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	rootArgs := &shared.RootArgs{
+		Env:          "test",
+		IsGCPManaged: true,
+		ClientOpts:   &apigee.EdgeClientOptions{Org: "gcp", Env: "test", GCPManaged: true, Auth: &apigee.EdgeAuth{SkipAuth: true}},
+	}
+	setTestUrls(rootArgs, ts.URL)
+	p := &provision{RootArgs: rootArgs}
+	print := testutil.Printer("TestBasePathGCP")
+	testutil.ErrorContains(t, p.checkBasePath(print.Printf),
+		"base path /remote-service is already claimed in environment test by: proxy other revision 1, undeploy them or use another environment")
+	print.CheckPrefix(t, []string{"warning: unable to inspect proxy gone revision 5, base path not checked"})
+
+	listed = false
+	print = testutil.Printer("TestBasePathGCP")
+	if err := p.checkBasePath(print.Printf); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+	print.CheckPrefix(t, []string{"warning: unable to list the proxies deployed to environment test, base path not checked"})
+}
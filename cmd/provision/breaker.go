@@ -0,0 +1,98 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"sort"
+)
+
+// circuitBreaker stops calling a verification endpoint once it has failed
+// maxFailures consecutive times and limits the total failures across all
+// endpoints to budget. Zero values are unlimited. A nil breaker calls through.
+type circuitBreaker struct {
+	maxFailures int
+	budget      int
+
+	failures    int
+	consecutive map[string]int
+	lastErr     map[string]error
+}
+
+func newCircuitBreaker(maxFailures, budget int) *circuitBreaker {
+	return &circuitBreaker{
+		maxFailures: maxFailures,
+		budget:      budget,
+		consecutive: map[string]int{},
+		lastErr:     map[string]error{},
+	}
+}
+
+// call calls fn unless the circuit of endpoint is open
+func (b *circuitBreaker) call(endpoint string, fn func() error) error {
+	if b == nil {
+		return fn()
+	}
+	if b.isOpen(endpoint) {
+		return fmt.Errorf("%s skipped, circuit open after %d consecutive failures", endpoint, b.consecutive[endpoint])
+	}
+	err := fn()
+	if err == nil {
+		b.consecutive[endpoint] = 0
+		delete(b.lastErr, endpoint)
+		return nil
+	}
+	b.failures++
+	b.consecutive[endpoint]++
+	b.lastErr[endpoint] = err
+	return err
+}
+
+func (b *circuitBreaker) isOpen(endpoint string) bool {
+	return b.maxFailures > 0 && b.consecutive[endpoint] >= b.maxFailures
+}
+
+// exhausted reports whether the failure budget is used up
+func (b *circuitBreaker) exhausted() bool {
+	return b != nil && b.budget > 0 && b.failures >= b.budget
+}
+
+// open returns the sorted endpoints with an open circuit
+func (b *circuitBreaker) open() []string {
+	if b == nil {
+		return nil
+	}
+	var open []string
+	for endpoint := range b.consecutive {
+		if b.isOpen(endpoint) {
+			open = append(open, endpoint)
+		}
+	}
+	sort.Strings(open)
+	return open
+}
+
+// allOpen reports whether every currently failing endpoint has an open circuit
+func (b *circuitBreaker) allOpen() bool {
+	if b == nil {
+		return false
+	}
+	for endpoint, n := range b.consecutive {
+		if n > 0 && !b.isOpen(endpoint) {
+			return false
+		}
+	}
+	return len(b.open()) > 0
+}
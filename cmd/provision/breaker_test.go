@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	b := newCircuitBreaker(2, 5)
+	fail := func() error { return errors.New("fail") }
+	ok := func() error { return nil }
+
+	_ = b.call("a", fail)
+	_ = b.call("b", fail)
+	if b.allOpen() {
+		t.Errorf("want circuits closed")
+	}
+	_ = b.call("a", fail)
+	if err := b.call("b", ok); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+	if !b.allOpen() {
+		t.Errorf("want all failing circuits open")
+	}
+	testutil.ErrorContains(t, b.call("a", ok), "a skipped, circuit open after 2 consecutive failures")
+	if want := []string{"a"}; !reflect.DeepEqual(want, b.open()) {
+		t.Errorf("want open %v, got %v", want, b.open())
+	}
+	if b.exhausted() {
+		t.Errorf("want budget left")
+	}
+	_ = b.call("b", fail)
+	_ = b.call("c", fail)
+	if !b.exhausted() {
+		t.Errorf("want budget exhausted after %d failures", b.failures)
+	}
+
+	var nilBreaker *circuitBreaker
+	testutil.ErrorContains(t, nilBreaker.call("a", fail), "fail")
+	if nilBreaker.exhausted() || nilBreaker.allOpen() || nilBreaker.open() != nil {
+		t.Errorf("nil breaker must not trip")
+	}
+}
+
+func TestVerifyWithRetryCircuitOpen(t *testing.T) {
+	var mu sync.Mutex
+	certsCalls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/certs") {
+			mu.Lock()
+			certsCalls++
+			mu.Unlock()
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]string{"platform": "1.3.0"}); err != nil {
+			t.Fatalf("want no error %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	duration = 60
+	interval = 100
+	defer func() {
+		duration = 1
+		interval = 500
+	}()
+
+	p := &provision{
+		RootArgs: &shared.RootArgs{
+			RuntimeBase: ts.URL,
+			Token:       "-",
+			Org:         "org",
+			Env:         "env",
+		},
+		verifyMaxFailures: 2,
+	}
+	if err := p.Resolve(false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	config := p.createConfig(nil)
+	kid, privateKey, _, err := p.CreateNewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Tenant.PrivateKey = privateKey
+	config.Tenant.PrivateKeyID = kid
+
+	start := time.Now()
+	err = p.verifyWithRetry(config, shared.NoPrintf)
+	testutil.ErrorContains(t, err, "503 Service Unavailable")
+	if certsCalls != 2 {
+		t.Errorf("want 2 calls to certs, got %d", certsCalls)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("want verification stopped once circuits are open")
+	}
+
+	// retry budget
+	certsCalls = 0
+	p.verifyMaxFailures = 0
+	p.verifyRetryBudget = 3
+	err = p.verifyWithRetry(config, shared.NoPrintf)
+	testutil.ErrorContains(t, err, "503 Service Unavailable")
+	if certsCalls != 3 {
+		t.Errorf("want 3 calls to certs, got %d", certsCalls)
+	}
+}
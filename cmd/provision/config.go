@@ -25,8 +25,10 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/apigee/apigee-remote-service-cli/k8s"
 	"github.com/apigee/apigee-remote-service-cli/shared"
 	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/pkg/errors"
 	"gopkg.in/yaml.v3"
 )
 
@@ -37,8 +39,6 @@ const (
 	defaultApigeeCAFile   = "/opt/apigee/tls/ca.crt"
 	defaultApigeeCertFile = "/opt/apigee/tls/tls.crt"
 	defaultApigeeKeyFile  = "/opt/apigee/tls/tls.key"
-
-	policySecretNameFormat = "%s-%s-policy-secret"
 )
 
 func (p *provision) createConfig(cred *keySecret) *server.Config {
@@ -60,7 +60,11 @@ func (p *provision) createConfig(cred *keySecret) *server.Config {
 	if p.IsGCPManaged {
 		config.Tenant.InternalAPI = "" // no internal API for GCP
 		config.Analytics.CollectionInterval = 10 * time.Second
+	}
 
+	// analytics of hybrid go to the UDCA of the cluster, an adapter of Apigee X
+	// outside the cluster uploads them through the management API
+	if p.IsGCPManaged && !p.apigeeX {
 		config.Analytics.FluentdEndpoint = fmt.Sprintf(fluentdInternalFormat, p.Org, p.Env, p.Namespace)
 
 		config.Analytics.TLS.CAFile = defaultApigeeCAFile
@@ -76,6 +80,8 @@ func (p *provision) createConfig(cred *keySecret) *server.Config {
 }
 
 func (p *provision) printConfig(config *server.Config, printf shared.FormatFn, verifyErrors error) error {
+	p.TraceTemplate("config", config)
+
 	// encode config
 	var yamlBuffer bytes.Buffer
 	yamlEncoder := yaml.NewEncoder(&yamlBuffer)
@@ -86,12 +92,24 @@ func (p *provision) printConfig(config *server.Config, printf shared.FormatFn, v
 	}
 	configYAML := yamlBuffer.String()
 
+	platform := shared.PlatformGCP
+	if p.IsLegacySaaS {
+		platform = shared.PlatformSaaS
+	}
+	if p.IsOPDK {
+		platform = shared.PlatformOPDK
+	}
+
+	if p.configOut == configOutNative {
+		return p.printNativeConfig(config, configYAML, platform, printf, verifyErrors)
+	}
+
 	data := map[string]string{"config.yaml": configYAML}
 	configCRD := server.ConfigMapCRD{
 		APIVersion: "v1",
 		Kind:       "ConfigMap",
 		Metadata: server.Metadata{
-			Name:      "apigee-remote-service-envoy",
+			Name:      p.configMapName(),
 			Namespace: p.Namespace,
 		},
 		Data: data,
@@ -106,71 +124,138 @@ func (p *provision) printConfig(config *server.Config, printf shared.FormatFn, v
 	}
 
 	// secret for IsGCPManaged
+	var secretCRD *server.SecretCRD
 	if p.IsGCPManaged {
-		privateKeyBytes := pem.EncodeToMemory(&pem.Block{Type: server.PEMKeyType,
-			Bytes: x509.MarshalPKCS1PrivateKey(config.Tenant.PrivateKey)})
+		if secretCRD, err = p.policySecretCRD(config); err != nil {
+			return err
+		}
+		if err = yamlEncoder.Encode(secretCRD); err != nil {
+			return err
+		}
+	}
 
-		// create CRD for secret
-		jwksBytes, err := json.Marshal(config.Tenant.JWKS)
+	if p.k8sVersion != "" {
+		if err := k8s.Validate(yamlBuffer.Bytes(), p.k8sVersion); err != nil {
+			return errors.Wrapf(err, "validating Kubernetes resources for %s", p.k8sVersion)
+		}
+	}
+
+	// the plain secret is validated above, the encrypted one replaces it
+	if secretCRD != nil && p.secretFormat.format != secretFormatPlain {
+		encrypted, err := p.secretFormat.encrypt(secretCRD)
 		if err != nil {
 			return err
 		}
+		yamlBuffer.Reset()
+		yamlEncoder = yaml.NewEncoder(&yamlBuffer)
+		yamlEncoder.SetIndent(2)
+		if err := yamlEncoder.Encode(configCRD); err != nil {
+			return err
+		}
+		if err := yamlEncoder.Encode(encrypted); err != nil {
+			return err
+		}
+	}
+
+	result := shared.ProvisionResult{
+		Platform:     platform,
+		Organization: p.Org,
+		Environment:  p.Env,
+		Runtime:      p.RuntimeBase,
+		Namespace:    p.Namespace,
+		ConfigMap:    p.configMapName(),
+		Verified:     verifyErrors == nil,
+		Proxies:      p.deployed,
+	}
+	result.SetConfig(config)
+	if p.IsGCPManaged {
+		result.Secret = p.policySecretName()
+	}
 
-		props := map[string]string{server.SecretPropsKIDKey: config.Tenant.PrivateKeyID}
-		propsBuf := new(bytes.Buffer)
-		if err := server.WriteProperties(propsBuf, props); err != nil {
+	// not validated, the custom resource is not a built-in kind
+	if p.emitCRD {
+		if err := yamlEncoder.Encode(result.RemoteService(p.configMapName())); err != nil {
 			return err
 		}
+	}
+	result.Resources = yamlBuffer.String()
 
-		secretData := map[string]string{
-			server.SecretJKWSKey:    base64.StdEncoding.EncodeToString(jwksBytes),
-			server.SecretPrivateKey: base64.StdEncoding.EncodeToString(privateKeyBytes),
-			server.SecretPropsKey:   base64.StdEncoding.EncodeToString(propsBuf.Bytes()),
+	var applied []clusterApply
+	if p.apply.enabled {
+		if applied, err = p.applyResources(result.Resources, printf); err != nil {
+			return err
 		}
+	}
 
-		secretCRD := server.SecretCRD{
-			APIVersion: "v1",
-			Kind:       "Secret",
-			Type:       "Opaque",
-			Metadata: server.Metadata{
-				Name:      fmt.Sprintf(policySecretNameFormat, p.Org, p.Env),
-				Namespace: p.Namespace,
-			},
-			Data: secretData,
+	overlay := ""
+	if p.kustomizeDir != "" {
+		if overlay, err = p.writeKustomization(result); err != nil {
+			return errors.Wrap(err, "writing kustomization")
 		}
+	}
 
-		err = yamlEncoder.Encode(secretCRD)
-		if err != nil {
-			return err
+	terraform := ""
+	if p.terraformDir != "" {
+		if terraform, err = p.writeTerraform(configYAML, secretCRD); err != nil {
+			return errors.Wrap(err, "writing Terraform configuration")
 		}
 	}
 
-	platform := "GCP"
-	if p.IsLegacySaaS {
-		platform = "SaaS"
+	savedDir := ""
+	if p.Workspace != nil {
+		if savedDir, err = p.Workspace.SaveProvisionResult(result); err != nil {
+			return errors.Wrap(err, "saving to workspace")
+		}
 	}
-	if p.IsOPDK {
-		platform = "OPDK"
+
+	if p.output == outputJSON && len(p.envs) > 1 {
+		p.results = append(p.results, result)
+		return nil
+	}
+	if p.output == outputJSON {
+		resultJSON, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return err
+		}
+		printf("%s", resultJSON)
+		return nil
 	}
 
 	printf("# Configuration for apigee-remote-service-envoy (platform: %s)", platform)
 	printf("# generated by apigee-remote-service-cli provision on %s", time.Now().Format("2006-01-02 15:04:05"))
+	if p.apigeeX {
+		printf("# for an Apigee X adapter running outside the cluster, analytics are uploaded through the management API")
+	}
+	if savedDir != "" {
+		printf("# saved to workspace directory %s", savedDir)
+	}
+	if overlay != "" {
+		printf("# kustomization overlay written to %s", overlay)
+		if p.IsGCPManaged && p.secretFormat.format == secretFormatPlain {
+			printf("# WARNING: the overlay holds the plain policy secret, use --secret-format to store it in Git")
+		}
+	}
+	if terraform != "" {
+		printf("# Terraform configuration written to %s", terraform)
+	}
+	printApplied(applied, printf)
 	if verifyErrors != nil {
 		printf("# WARNING: verification of provision failed. May not be valid.")
 	}
-	printf(yamlBuffer.String())
+	p.printStepFailures(printf)
+	printf(result.Resources)
 
 	return nil
 }
 
 // checkRuntimeVersion gets the version of the hybrid runtime and change the fluentd endpoint when necessary
 func (p *provision) checkRuntimeVersion(config *server.Config, client *http.Client, verbosef shared.FormatFn) (string, error) {
-	targetURL := fmt.Sprintf("%s/version", p.RemoteServiceProxyURL)
+	targetURL := fmt.Sprintf(versionURLFormat, p.RemoteServiceProxyURL)
 	req, err := http.NewRequest(http.MethodGet, targetURL, nil)
 	if err != nil {
 		return "", err
 	}
-	res, err := client.Do(req)
+	res, err := tracedDo(client, req)
 	if err != nil {
 		return "", err
 	}
@@ -197,6 +282,55 @@ func (p *provision) encodeUDCAEndpoint(config *server.Config, verbosef shared.Fo
 	verbosef("UDCA endpoint encoded")
 }
 
+// configMapName is suffixed by the environment if multiple environments are provisioned
+func (p *provision) configMapName() string {
+	if len(p.envs) > 1 {
+		return shared.ConfigMapName + "-" + p.Env
+	}
+	return shared.ConfigMapName
+}
+
+// policySecretCRD returns the policy secret holding the config's keys
+func (p *provision) policySecretCRD(config *server.Config) (*server.SecretCRD, error) {
+	privateKeyBytes := pem.EncodeToMemory(&pem.Block{Type: server.PEMKeyType,
+		Bytes: x509.MarshalPKCS1PrivateKey(config.Tenant.PrivateKey)})
+
+	jwksBytes, err := json.Marshal(config.Tenant.JWKS)
+	if err != nil {
+		return nil, err
+	}
+
+	props := map[string]string{server.SecretPropsKIDKey: config.Tenant.PrivateKeyID}
+	for _, e := range p.claimProperties() {
+		props[e.Name] = e.Value
+	}
+	propsBuf := new(bytes.Buffer)
+	if err := server.WriteProperties(propsBuf, props); err != nil {
+		return nil, err
+	}
+
+	secretData := map[string]string{
+		server.SecretJKWSKey:    base64.StdEncoding.EncodeToString(jwksBytes),
+		server.SecretPrivateKey: base64.StdEncoding.EncodeToString(privateKeyBytes),
+		server.SecretPropsKey:   base64.StdEncoding.EncodeToString(propsBuf.Bytes()),
+	}
+
+	return &server.SecretCRD{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Type:       "Opaque",
+		Metadata: server.Metadata{
+			Name:      p.policySecretName(),
+			Namespace: p.Namespace,
+		},
+		Data: secretData,
+	}, nil
+}
+
+func (p *provision) policySecretName() string {
+	return fmt.Sprintf(shared.PolicySecretNameFormat, p.Org, p.Env)
+}
+
 // shortName returns a substring with up to the first 15 characters of the input string
 func shortName(s string) string {
 	if len(s) < 16 {
@@ -0,0 +1,57 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+)
+
+// tolerate returns err of a non-critical step, one the config doesn't
+// depend on, unless --continue-on-error records it to be reported once
+// the config is generated. A step repeated per proxy, such as the cache
+// they share, is recorded once.
+func (p *provision) tolerate(step string, err error, verbosef shared.FormatFn) error {
+	if err == nil || !p.continueOnError {
+		return err
+	}
+	failure := fmt.Sprintf("%s: %v", step, err)
+	for _, f := range p.stepFailures {
+		if f == failure {
+			return nil
+		}
+	}
+	p.stepFailures = append(p.stepFailures, failure)
+	verbosef("%s failed, continuing (--continue-on-error): %v", step, err)
+	return nil
+}
+
+// printStepFailures adds the tolerated failures to the header of the config
+func (p *provision) printStepFailures(printf shared.FormatFn) {
+	for _, f := range p.stepFailures {
+		printf("# WARNING: failed (--continue-on-error): %s", f)
+	}
+}
+
+// stepsError reports the tolerated failures, nil if none
+func (p *provision) stepsError() error {
+	if len(p.stepFailures) == 0 {
+		return nil
+	}
+	return shared.WithCode(shared.CodeStepsFailed, fmt.Errorf("%d step(s) failed (--continue-on-error): %s",
+		len(p.stepFailures), strings.Join(p.stepFailures, "; ")))
+}
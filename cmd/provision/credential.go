@@ -0,0 +1,264 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+)
+
+const revokedStatus = "revoked"
+
+var scopeRE = regexp.MustCompile(`^[^\s,]+$`)
+
+// credentialOptions name the developer and app holding the credential, so
+// adapters of an organization can each have their own, and the OAuth scopes
+// granted to it (hybrid). With expiresIn, the credential created with the app
+// expires, eg. for demos.
+type credentialOptions struct {
+	developer string
+	app       string
+	scopes    []string
+	expiresIn time.Duration
+}
+
+func (o credentialOptions) validate(p *provision) error {
+	for _, s := range o.scopes {
+		if !scopeRE.MatchString(s) {
+			return fmt.Errorf("--credential-scopes must not be empty or contain spaces, got %q", s)
+		}
+	}
+	if len(o.scopes) > 0 && !p.IsGCPManaged {
+		return fmt.Errorf("--credential-scopes only valid for hybrid")
+	}
+	if o.expiresIn < 0 {
+		return fmt.Errorf("--expires-in must not be negative")
+	}
+	if o.expiresIn > 0 && !p.IsGCPManaged {
+		return fmt.Errorf("--expires-in only valid for hybrid")
+	}
+	if o.expiresIn > 0 && (p.rotation.enabled || p.skip.credential) {
+		return fmt.Errorf("--expires-in can't be combined with --rotate-key or --skip-credential")
+	}
+	if o.developer == "" && o.app == "" {
+		return nil
+	}
+	if !p.IsGCPManaged {
+		return fmt.Errorf("--developer and --app only valid for hybrid")
+	}
+	if o.developer != "" && p.useAppGroup {
+		return fmt.Errorf("--developer can't be combined with --use-appgroup")
+	}
+	if o.developer != "" && !strings.Contains(o.developer, "@") {
+		return fmt.Errorf("--developer must be an email")
+	}
+	if o.app != "" && !productNameRE.MatchString(o.app) {
+		return fmt.Errorf("--app must only contain letters, numbers, '.', '_' and '-'")
+	}
+	return nil
+}
+
+func (o credentialOptions) developerEmail() string {
+	if o.developer == "" {
+		return shared.DefaultDeveloperEmail
+	}
+	return o.developer
+}
+
+func (o credentialOptions) appName() string {
+	if o.app == "" {
+		return shared.DefaultAppName
+	}
+	return o.app
+}
+
+// keyExpiresIn is the lifetime of the key created with the app in ms, 0 for none
+func (o credentialOptions) keyExpiresIn() int64 {
+	return int64(o.expiresIn / time.Millisecond)
+}
+
+// createGCPCredential creates the remote-service app, owned by a developer or
+// by an AppGroup, and returns its credential. An existing app is reused.
+func (p *provision) createGCPCredential(verbosef shared.FormatFn) (*keySecret, error) {
+	verbosef("creating credential...")
+
+	var creds []apigee.AppCredential
+	var err error
+	if p.useAppGroup {
+		creds, err = p.createAppGroupApp(verbosef)
+	} else {
+		creds, err = p.createDeveloperApp(verbosef)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.dryRun { // the app was not created
+		return &keySecret{}, nil
+	}
+
+	for _, c := range creds {
+		if c.ConsumerKey == "" || c.Status == revokedStatus {
+			continue
+		}
+		expiresAt, expires := credentialExpiry(c)
+		if expires && !expiresAt.After(time.Now()) {
+			continue
+		}
+		if len(p.credential.scopes) > 0 && !sameScopes(c.Scopes, p.credential.scopes) {
+			verbosef("warning: credential of app %s has scopes %s, not --credential-scopes %s (delete the app to recreate it)",
+				p.credential.appName(), strings.Join(c.Scopes, ","), strings.Join(p.credential.scopes, ","))
+		}
+		if expires {
+			verbosef("credential of app %s expires at %s", p.credential.appName(), expiresAt.Format(time.RFC3339))
+		} else if p.credential.expiresIn > 0 {
+			verbosef("warning: credential of app %s doesn't expire, not --expires-in %s (delete the app to recreate it)",
+				p.credential.appName(), p.credential.expiresIn)
+		}
+		return &keySecret{
+			Key:    c.ConsumerKey,
+			Secret: c.ConsumerSecret,
+		}, nil
+	}
+	return nil, fmt.Errorf("app %s has no valid credential, use --rotate-key to create one", p.credential.appName())
+}
+
+func (p *provision) createDeveloperApp(verbosef shared.FormatFn) ([]apigee.AppCredential, error) {
+	email := p.credential.developerEmail()
+	dev := apigee.Developer{
+		Email:     email,
+		FirstName: "remote-service",
+		LastName:  "remote-service",
+		UserName:  "remote-service",
+	}
+	resp, err := p.ApigeeClient.DeveloperApps.CreateDeveloper(dev)
+	if err != nil {
+		if resp == nil || resp.StatusCode != http.StatusConflict {
+			return nil, errors.Wrapf(err, "creating developer %s", email)
+		}
+		verbosef("developer %s already exists", email)
+	} else {
+		p.onRollback("developer "+email, func(printf shared.FormatFn) error {
+			resp, err := p.ApigeeClient.DeveloperApps.DeleteDeveloper(email)
+			return deleted("developer", email, resp, err, printf)
+		})
+	}
+
+	app := apigee.DeveloperApp{
+		Name:         p.credential.appName(),
+		APIProducts:  []string{p.product.productName()},
+		Scopes:       p.credential.scopes,
+		KeyExpiresIn: p.credential.keyExpiresIn(),
+	}
+	created, resp, err := p.ApigeeClient.DeveloperApps.Create(email, app)
+	if err == nil {
+		verbosef("app %s created", app.Name)
+		p.notifyCredential(app.Name, firstKey(created.Credentials))
+		p.onRollback("app "+app.Name, func(printf shared.FormatFn) error {
+			resp, err := p.ApigeeClient.DeveloperApps.Delete(email, app.Name)
+			return deleted("app", app.Name, resp, err, printf)
+		})
+		return created.Credentials, nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusConflict {
+		return nil, errors.Wrapf(err, "creating app %s", app.Name)
+	}
+
+	verbosef("app %s already exists", app.Name)
+	existing, _, err := p.ApigeeClient.DeveloperApps.Get(email, app.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving app %s", app.Name)
+	}
+	return existing.Credentials, nil
+}
+
+func (p *provision) createAppGroupApp(verbosef shared.FormatFn) ([]apigee.AppCredential, error) {
+	appGroup := apigee.AppGroup{
+		Name:        shared.DefaultAppGroupName,
+		DisplayName: shared.DefaultAppGroupName,
+		Status:      "active",
+	}
+	resp, err := p.ApigeeClient.AppGroups.Create(appGroup)
+	if err != nil {
+		if resp == nil || resp.StatusCode != http.StatusConflict {
+			return nil, errors.Wrapf(err, "creating appgroup %s", appGroup.Name)
+		}
+		verbosef("appgroup %s already exists", appGroup.Name)
+	} else {
+		p.onRollback("appgroup "+appGroup.Name, func(printf shared.FormatFn) error {
+			resp, err := p.ApigeeClient.AppGroups.Delete(appGroup.Name)
+			return deleted("appgroup", appGroup.Name, resp, err, printf)
+		})
+	}
+
+	app := apigee.AppGroupApp{
+		Name:         p.credential.appName(),
+		APIProducts:  []string{p.product.productName()},
+		Scopes:       p.credential.scopes,
+		KeyExpiresIn: p.credential.keyExpiresIn(),
+	}
+	created, resp, err := p.ApigeeClient.AppGroups.CreateApp(appGroup.Name, app)
+	if err == nil {
+		verbosef("app %s created in appgroup %s", app.Name, appGroup.Name)
+		p.notifyCredential(app.Name, firstKey(created.Credentials))
+		p.onRollback("app "+app.Name, func(printf shared.FormatFn) error {
+			resp, err := p.ApigeeClient.AppGroups.DeleteApp(appGroup.Name, app.Name)
+			return deleted("app", app.Name, resp, err, printf)
+		})
+		return created.Credentials, nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusConflict {
+		return nil, errors.Wrapf(err, "creating app %s", app.Name)
+	}
+
+	verbosef("app %s already exists in appgroup %s", app.Name, appGroup.Name)
+	existing, _, err := p.ApigeeClient.AppGroups.GetApp(appGroup.Name, app.Name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving app %s", app.Name)
+	}
+	return existing.Credentials, nil
+}
+
+// sameScopes returns true if both have the same scopes, in any order
+func sameScopes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	has := map[string]bool{}
+	for _, s := range a {
+		has[s] = true
+	}
+	for _, s := range b {
+		if !has[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// credentialExpiry returns when a credential expires, false if it doesn't
+func credentialExpiry(c apigee.AppCredential) (time.Time, bool) {
+	// keys that don't expire have an expiresAt of -1
+	if c.ExpiresAt.Unix() <= 0 {
+		return time.Time{}, false
+	}
+	return c.ExpiresAt.Time, true
+}
@@ -0,0 +1,234 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+)
+
+const (
+	nativeConfigFile    = "config.yaml"
+	nativeSecretDir     = "policy-secret"
+	nativeChecksumsFile = "SHA256SUMS"
+)
+
+// deliverPath is the characters of the paths of --deliver and
+// --reload-trigger, scp passing them to the remote shell or not depending
+// on its version
+var deliverPath = regexp.MustCompile(`^[A-Za-z0-9._/~+-]+$`)
+
+// deliverHost and deliverUser are the host and user of --deliver, which
+// must not start with "-" to be taken as options of ssh and scp
+var (
+	deliverHost = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9.-]*[A-Za-z0-9])?$`)
+	deliverUser = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+)
+
+// sshCommand and scpCommand are the OpenSSH binaries of --deliver
+var (
+	sshCommand = "ssh"
+	scpCommand = "scp"
+)
+
+// nativeOutOptions are the flags writing the files of --config-out native
+// for an adapter on a VM, delivered to its host and signalled to reload
+type nativeOutOptions struct {
+	dir     string
+	deliver string
+	trigger string
+	target  *deliverTarget // of deliver, parsed by validate
+}
+
+// deliverTarget is the host and directory of --deliver ssh://[user@]host[:port]/path
+type deliverTarget struct {
+	host string // user@host
+	port string
+	dir  string
+}
+
+func (n *nativeOutOptions) validate(p *provision) error {
+	if n.dir == "" {
+		if n.deliver != "" || n.trigger != "" {
+			return fmt.Errorf("--deliver and --reload-trigger only valid with --native-out")
+		}
+		return nil
+	}
+	if p.configOut != configOutNative {
+		return fmt.Errorf("--native-out only valid with --config-out %s", configOutNative)
+	}
+	if p.policySecretDir != "" {
+		return fmt.Errorf("--native-out can't be combined with --policy-secret-dir, the policy secret is written to its %s directory", nativeSecretDir)
+	}
+	if n.deliver == "" {
+		return nil
+	}
+	if n.trigger != "" && !deliverPath.MatchString(n.trigger) {
+		return fmt.Errorf("--reload-trigger %q must consist of letters, digits and ._/~+-", n.trigger)
+	}
+	target, err := parseDeliver(n.deliver)
+	if err != nil {
+		return err
+	}
+	for _, command := range []string{sshCommand, scpCommand} {
+		if _, err := exec.LookPath(command); err != nil {
+			return errors.Wrap(err, "--deliver")
+		}
+	}
+	n.target = target
+	return nil
+}
+
+func parseDeliver(deliver string) (*deliverTarget, error) {
+	u, err := url.Parse(deliver)
+	if err != nil || u.Scheme != "ssh" || u.Hostname() == "" || path.Clean(u.Path) == "/" || u.RawQuery != "" {
+		return nil, fmt.Errorf("--deliver must be ssh://[user@]host[:port]/path, got %q", deliver)
+	}
+	if !deliverPath.MatchString(u.Path) {
+		return nil, fmt.Errorf("--deliver path %q must consist of letters, digits and ._/~+-", u.Path)
+	}
+	if !deliverHost.MatchString(u.Hostname()) {
+		return nil, fmt.Errorf("--deliver host %q must be a hostname or IPv4 address", u.Hostname())
+	}
+	t := &deliverTarget{host: u.Hostname(), port: u.Port(), dir: path.Clean(u.Path)}
+	if u.User != nil {
+		if !deliverUser.MatchString(u.User.Username()) {
+			return nil, fmt.Errorf("--deliver user %q must consist of letters, digits and ._- and not start with . or -", u.User.Username())
+		}
+		t.host = u.User.Username() + "@" + t.host
+	}
+	return t, nil
+}
+
+// writeNativeOut writes config.yaml, the policy secret files and the
+// SHA256SUMS of them to the --native-out directory. SHA256SUMS is written
+// last, a watcher may reload the adapter once it changes.
+func (p *provision) writeNativeOut(configYAML string, secretFiles []string) ([]string, error) {
+	dir := p.nativeOut.dir
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "creating %s", dir)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, nativeConfigFile), []byte(configYAML), 0600); err != nil {
+		return nil, errors.Wrapf(err, "writing %s", nativeConfigFile)
+	}
+	files := []string{nativeConfigFile}
+	for _, name := range secretFiles {
+		files = append(files, path.Join(nativeSecretDir, name))
+	}
+
+	var sums bytes.Buffer
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&sums, "%x  %s\n", sha256.Sum256(data), file)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, nativeChecksumsFile), sums.Bytes(), 0644); err != nil {
+		return nil, errors.Wrapf(err, "writing %s", nativeChecksumsFile)
+	}
+	files = append(files, nativeChecksumsFile)
+
+	if p.nativeOut.trigger != "" && p.nativeOut.target == nil {
+		trigger := p.nativeOut.trigger
+		if !filepath.IsAbs(trigger) {
+			trigger = filepath.Join(dir, trigger)
+		}
+		if err := touch(trigger); err != nil {
+			return nil, errors.Wrap(err, "touching --reload-trigger")
+		}
+	}
+	return files, nil
+}
+
+// touch creates file or updates its modification time
+func touch(file string) error {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	now := time.Now()
+	return os.Chtimes(file, now, now)
+}
+
+// deliverNativeOut copies the files of --native-out to the --deliver host,
+// SHA256SUMS last, then touches the --reload-trigger there
+func (p *provision) deliverNativeOut(files []string) error {
+	t := p.nativeOut.target
+	if err := t.ssh("mkdir", "-p", path.Join(t.dir, nativeSecretDir)); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := t.scp(filepath.Join(p.nativeOut.dir, filepath.FromSlash(file)), path.Join(t.dir, file)); err != nil {
+			return err
+		}
+	}
+	if p.nativeOut.trigger == "" {
+		return nil
+	}
+	trigger := p.nativeOut.trigger
+	if !path.IsAbs(trigger) {
+		trigger = path.Join(t.dir, trigger)
+	}
+	return t.ssh("touch", trigger)
+}
+
+func (t *deliverTarget) ssh(args ...string) error {
+	var quoted []string
+	for _, arg := range args {
+		quoted = append(quoted, shared.ShellQuote(arg))
+	}
+	sshArgs := []string{"-o", "BatchMode=yes"}
+	if t.port != "" {
+		sshArgs = append(sshArgs, "-p", t.port)
+	}
+	sshArgs = append(sshArgs, "--", t.host, strings.Join(quoted, " "))
+	return runRemote(sshCommand, sshArgs...)
+}
+
+func (t *deliverTarget) scp(file, remote string) error {
+	scpArgs := []string{"-o", "BatchMode=yes", "-p", "-q"}
+	if t.port != "" {
+		scpArgs = append(scpArgs, "-P", t.port)
+	}
+	scpArgs = append(scpArgs, "--", file, t.host+":"+remote)
+	return runRemote(scpCommand, scpArgs...)
+}
+
+func runRemote(command string, args ...string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(command, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return errors.Wrapf(err, "--deliver: %s %s: %s", command, strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestWaitForDeployment(t *testing.T) {
+	defer func(d time.Duration) { deployPollInterval = d }(deployPollInterval)
+	deployPollInterval = time.Millisecond
+
+	mp := []string{"message-processor"}
+	polls := 0
+	failed := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/apis/remote-service/revisions/2/deployments") {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		polls++
+		deployment := apigee.ProxyRevisionDeployment{State: "deployed", Servers: []apigee.EdgeServer{
+			{Status: "deployed", Type: mp},
+			{Status: "undeployed", Type: mp},
+			{Status: "undeployed", Type: []string{"router"}},
+		}}
+		if polls > 2 {
+			deployment.Servers[1].Status = "deployed"
+		}
+		if failed {
+			deployment.Servers[1].Status = "error"
+		}
+		_ = json.NewEncoder(w).Encode(deployment)
+	}))
+	defer ts.Close()
+
+	rootArgs := &shared.RootArgs{
+		Env:          "test",
+		IsLegacySaaS: true,
+		ClientOpts:   &apigee.EdgeClientOptions{Org: "org", Env: "test", Auth: &apigee.EdgeAuth{SkipAuth: true}},
+	}
+	setTestUrls(rootArgs, ts.URL)
+	p := &provision{RootArgs: rootArgs, timeout: time.Minute}
+
+	print := testutil.Printer("TestWaitForDeployment")
+	if err := p.waitForDeployment(authProxyName, 2, print.Printf); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{
+		"waiting for proxy remote-service revision 2: 1 of 2 message processors",
+		"proxy remote-service revision 2 deployed to env test",
+	})
+
+	polls = 0
+	p.timeout = time.Millisecond
+	err := p.waitForDeployment(authProxyName, 2, testutil.Printer("TestWaitForDeployment").Printf)
+	testutil.ErrorContains(t, err, "not deployed to env test after 1ms (1 of 2 message processors)")
+
+	failed = true
+	p.timeout = time.Minute
+	err = p.waitForDeployment(authProxyName, 2, testutil.Printer("TestWaitForDeployment").Printf)
+	testutil.ErrorContains(t, err, "deployment of proxy remote-service revision 2 to env test failed")
+}
@@ -0,0 +1,207 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+)
+
+// DeprovisionCmd returns the deprovision command
+func DeprovisionCmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	p := &provision{RootArgs: rootArgs}
+
+	c := &cobra.Command{
+		Use:   "deprovision",
+		Short: "Remove the remote service artifacts from your Apigee environment",
+		Long: `The deprovision command removes what provision created: it undeploys and deletes the
+remote-service proxies and deletes the API product, the remote-service kvm, the remote-service cache
+(legacy and OPDK) or property set (hybrid) and the remote-service developer and app or AppGroup
+(hybrid). Artifacts already removed are skipped.`,
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := rootArgs.Resolve(false, false); err != nil {
+				return err
+			}
+			if !p.IsGCPManaged && p.useAppGroup {
+				return fmt.Errorf("--use-appgroup only valid for hybrid")
+			}
+			if err := p.credential.validate(p); err != nil {
+				return err
+			}
+			if err := p.resources.validate(); err != nil {
+				return err
+			}
+			if p.dryRun {
+				return p.enableDryRun(printf)
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+			return p.deprovision(printf)
+		},
+	}
+
+	c.Flags().StringVarP(&rootArgs.ManagementBase, "management", "m",
+		shared.DefaultManagementBase, "Apigee management base URL")
+	c.Flags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.Flags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+
+	c.Flags().StringVarP(&rootArgs.Token, "token", "t", "",
+		"Apigee OAuth or SAML token (hybrid only)")
+	c.Flags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
+	c.Flags().StringVarP(&rootArgs.Username, "username", "u", "",
+		"Apigee username (legacy or OPDK only)")
+	c.Flags().StringVarP(&rootArgs.Password, "password", "p", "",
+		"Apigee password (legacy or OPDK only)")
+
+	c.Flags().BoolVarP(&p.useAppGroup, "use-appgroup", "", false,
+		"delete the remote-service app from the AppGroup rather than from the developer (hybrid only)")
+	c.Flags().StringVarP(&p.product.name, "product-name", "", "",
+		"name of the API product given to provision (default: remote-service)")
+	c.Flags().StringVarP(&p.credential.developer, "developer", "", "",
+		"email of the developer given to provision (default: "+shared.DefaultDeveloperEmail+") (hybrid only)")
+	c.Flags().StringVarP(&p.credential.app, "app", "", "",
+		"name of the app given to provision (default: "+shared.DefaultAppName+") (hybrid only)")
+	c.Flags().StringVarP(&p.resources.kvm, "kvm-name", "", "",
+		"name of the kvm given to provision (default: "+defaultKVMName+")")
+	c.Flags().StringVarP(&p.resources.cache, "cache-name", "", "",
+		"name of the cache given to provision (default: "+defaultCacheName+")")
+	c.Flags().BoolVarP(&p.dryRun, "dry-run", "", false,
+		"print the management API calls that would modify your organization without making them")
+
+	return c
+}
+
+// deprovision removes all provisioned artifacts, continuing past failures
+func (p *provision) deprovision(printf shared.FormatFn) error {
+	verbosef := p.Stepf()
+
+	proxies := []string{authProxyName}
+	if p.IsOPDK {
+		proxies = append(proxies, internalProxyName)
+	}
+
+	var errs error
+	for _, name := range proxies {
+		errs = multierr.Append(errs, p.undeployProxy(name, printf))
+	}
+
+	if p.IsGCPManaged {
+		app := p.credential.appName()
+		if p.useAppGroup {
+			resp, err := p.ApigeeClient.AppGroups.DeleteApp(shared.DefaultAppGroupName, app)
+			errs = multierr.Append(errs, deleted("app", app, resp, err, printf))
+			resp, err = p.ApigeeClient.AppGroups.Delete(shared.DefaultAppGroupName)
+			errs = multierr.Append(errs, deleted("appgroup", shared.DefaultAppGroupName, resp, err, printf))
+		} else {
+			email := p.credential.developerEmail()
+			resp, err := p.ApigeeClient.DeveloperApps.Delete(email, app)
+			errs = multierr.Append(errs, deleted("app", app, resp, err, printf))
+			resp, err = p.ApigeeClient.DeveloperApps.DeleteDeveloper(email)
+			errs = multierr.Append(errs, deleted("developer", email, resp, err, printf))
+		}
+	}
+
+	errs = multierr.Append(errs, p.deleteAPIProduct(printf))
+
+	errs = multierr.Append(errs, p.deleteKVM(printf))
+	if p.IsGCPManaged {
+		resp, err := p.ApigeeClient.ResourceFiles.Delete(propertySetFileType, propertySetName)
+		errs = multierr.Append(errs, deleted("property set", propertySetName, resp, err, printf))
+	} else {
+		resp, err := p.ApigeeClient.CacheService.Delete(p.resources.cacheName())
+		errs = multierr.Append(errs, deleted("cache", p.resources.cacheName(), resp, err, printf))
+	}
+
+	for _, name := range proxies {
+		_, resp, err := p.ApigeeClient.Proxies.Delete(name)
+		errs = multierr.Append(errs, deleted("proxy", name, resp, err, printf))
+	}
+
+	if p.dryRun {
+		printf("dry run: %d call(s) not made", p.dryRunCalls)
+		return errs
+	}
+	if errs == nil {
+		verbosef("deprovisioning complete")
+	}
+	return errs
+}
+
+// undeployProxy undeploys the revision of a proxy deployed to the environment, if any
+func (p *provision) undeployProxy(name string, printf shared.FormatFn) error {
+	var rev *apigee.Revision
+	var err error
+	if p.IsGCPManaged {
+		rev, err = p.ApigeeClient.Proxies.GetGCPDeployedRevision(name)
+	} else {
+		rev, err = p.ApigeeClient.Proxies.GetDeployedRevision(name)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "checking deployment of proxy %s", name)
+	}
+	if rev == nil {
+		printf("proxy %s is not deployed to %s", name, p.Env)
+		return nil
+	}
+
+	_, _, err = p.ApigeeClient.Proxies.Undeploy(name, p.Env, *rev)
+	if err != nil {
+		return errors.Wrapf(err, "undeploying proxy %s", name)
+	}
+	printf("proxy %s revision %s undeployed from %s", name, rev, p.Env)
+	return nil
+}
+
+func (p *provision) deleteAPIProduct(printf shared.FormatFn) error {
+	name := p.product.productName()
+	req, err := p.ApigeeClient.NewRequestNoEnv(http.MethodDelete, path.Join(apiProductsPath, name), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.ApigeeClient.Do(req, nil)
+	return deleted("product", name, resp, err, printf)
+}
+
+func (p *provision) deleteKVM(printf shared.FormatFn) error {
+	resp, err := p.ApigeeClient.KVMService.Delete(p.resources.kvmName())
+	return deleted("kvm", p.resources.kvmName(), resp, err, printf)
+}
+
+// deleted reports the result of a delete call, a missing resource is not an error
+func deleted(kind, name string, resp *apigee.Response, err error, printf shared.FormatFn) error {
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			printf("%s %s not found", kind, name)
+			return nil
+		}
+		return errors.Wrapf(err, "deleting %s %s", kind, name)
+	}
+	printf("%s %s deleted", kind, name)
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/spf13/cobra"
+)
+
+func TestDeprovision(t *testing.T) {
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			calls = append(calls, r.Method+" "+r.URL.String())
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/apis/edgemicro-internal/deployments"):
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasSuffix(r.URL.Path, "/environments/test/apis/remote-service/deployments") && r.Method == http.MethodGet:
+			if strings.Contains(r.URL.Path, "/gcp/") {
+				_ = json.NewEncoder(w).Encode(apigee.GCPDeployments{
+					Deployments: []apigee.GCPDeployment{{Environment: "test", Name: "remote-service", Revision: "3"}},
+				})
+			} else {
+				_ = json.NewEncoder(w).Encode(apigee.EnvironmentDeployment{
+					Name:     "test",
+					Revision: []apigee.RevisionDeployment{{Number: 3, State: "deployed"}},
+				})
+			}
+		case strings.HasSuffix(r.URL.Path, "/caches/remote-service"):
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasSuffix(r.URL.Path, "/apiproducts/remote-service") && strings.Contains(r.URL.Path, "/fail/"):
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			_, _ = w.Write([]byte("{}"))
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		desc    string
+		flags   []string
+		calls   []string
+		wantErr string
+	}{
+		{"legacy", []string{"-o", "legacy", "-e", "test", "-u", "me", "-p", "password", "--legacy"}, []string{
+			"POST /v1/organizations/legacy/apis/remote-service/revisions/3/deployments?action=undeploy&env=test",
+			"DELETE /v1/organizations/legacy/apiproducts/remote-service",
+			"DELETE /v1/organizations/legacy/environments/test/keyvaluemaps/remote-service",
+			"DELETE /v1/organizations/legacy/environments/test/caches/remote-service",
+			"DELETE /v1/organizations/legacy/apis/remote-service",
+		}, ""},
+		{"opdk", []string{"-o", "opdk", "-e", "test", "-u", "me", "-p", "password", "-r", ts.URL, "--opdk"}, []string{
+			"POST /v1/organizations/opdk/apis/remote-service/revisions/3/deployments?action=undeploy&env=test",
+			"DELETE /v1/organizations/opdk/apiproducts/remote-service",
+			"DELETE /v1/organizations/opdk/environments/test/keyvaluemaps/remote-service",
+			"DELETE /v1/organizations/opdk/environments/test/caches/remote-service",
+			"DELETE /v1/organizations/opdk/apis/remote-service",
+			"DELETE /v1/organizations/opdk/apis/edgemicro-internal",
+		}, ""},
+		{"hybrid", []string{"-o", "gcp", "-e", "test", "-t", "token"}, []string{
+			"DELETE /v1/organizations/gcp/environments/test/apis/remote-service/revisions/3/deployments",
+			"DELETE /v1/organizations/gcp/developers/remote-service@apigee.com/apps/remote-service",
+			"DELETE /v1/organizations/gcp/developers/remote-service@apigee.com",
+			"DELETE /v1/organizations/gcp/apiproducts/remote-service",
+			"DELETE /v1/organizations/gcp/environments/test/keyvaluemaps/remote-service",
+			"DELETE /v1/organizations/gcp/environments/test/resourcefiles/properties/remote-service",
+			"DELETE /v1/organizations/gcp/apis/remote-service",
+		}, ""},
+		{"appgroup", []string{"-o", "gcp", "-e", "test", "-t", "token", "--use-appgroup"}, []string{
+			"DELETE /v1/organizations/gcp/environments/test/apis/remote-service/revisions/3/deployments",
+			"DELETE /v1/organizations/gcp/appgroups/remote-service/apps/remote-service",
+			"DELETE /v1/organizations/gcp/appgroups/remote-service",
+			"DELETE /v1/organizations/gcp/apiproducts/remote-service",
+			"DELETE /v1/organizations/gcp/environments/test/keyvaluemaps/remote-service",
+			"DELETE /v1/organizations/gcp/environments/test/resourcefiles/properties/remote-service",
+			"DELETE /v1/organizations/gcp/apis/remote-service",
+		}, ""},
+		{"continues on failure", []string{"-o", "fail", "-e", "test", "-t", "token"}, []string{
+			"DELETE /v1/organizations/fail/developers/remote-service@apigee.com/apps/remote-service",
+			"DELETE /v1/organizations/fail/developers/remote-service@apigee.com",
+			"DELETE /v1/organizations/fail/apiproducts/remote-service",
+			"DELETE /v1/organizations/fail/environments/test/keyvaluemaps/remote-service",
+			"DELETE /v1/organizations/fail/environments/test/resourcefiles/properties/remote-service",
+			"DELETE /v1/organizations/fail/apis/remote-service",
+		}, "deleting product remote-service"},
+		{"dry run", []string{"-o", "gcp", "-e", "test", "-t", "token", "--dry-run"}, nil, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			calls = nil
+			print := testutil.Printer(test.desc)
+			rootArgs := &shared.RootArgs{}
+			flags := append([]string{"deprovision"}, test.flags...)
+			rootCmd := cmd.GetRootCmd(flags, print.Printf)
+			shared.AddCommandWithFlags(rootCmd, rootArgs, testDeprovisionCmd(rootArgs, print.Printf, ts.URL))
+
+			err := rootCmd.Execute()
+			if test.wantErr != "" {
+				testutil.ErrorContains(t, err, test.wantErr)
+			} else if err != nil {
+				t.Fatalf("want no error: %v", err)
+			}
+			if !reflect.DeepEqual(test.calls, calls) {
+				t.Errorf("want calls:\n%s\ngot:\n%s", strings.Join(test.calls, "\n"), strings.Join(calls, "\n"))
+			}
+		})
+	}
+}
+
+func TestDeprovisionReportsMissing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer ts.Close()
+
+	print := testutil.Printer("TestDeprovisionReportsMissing")
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"deprovision", "-o", "gcp", "-e", "test", "-t", "token"}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testDeprovisionCmd(rootArgs, print.Printf, ts.URL))
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{
+		"proxy remote-service is not deployed to test",
+		"app remote-service not found",
+		"developer remote-service@apigee.com not found",
+		"product remote-service not found",
+		"kvm remote-service not found",
+		"property set remote-service not found",
+		"proxy remote-service not found",
+	})
+}
+
+func testDeprovisionCmd(rootArgs *shared.RootArgs, printf shared.FormatFn, url string) *cobra.Command {
+	c := DeprovisionCmd(rootArgs, printf)
+
+	defaultPersistentPreRun := c.PersistentPreRunE
+	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := defaultPersistentPreRun(cmd, args); err != nil {
+			return err
+		}
+		setTestUrls(rootArgs, url)
+		return nil
+	}
+
+	return c
+}
@@ -0,0 +1,495 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v3"
+)
+
+// credentialExpiryWarning is how long before its expiry a credential is reported
+const credentialExpiryWarning = 24 * time.Hour
+
+type doctor struct {
+	*provision
+	fix bool
+	yes bool
+	in  *bufio.Scanner
+}
+
+// problem is a detected issue, fix is nil if it can't be repaired automatically
+type problem struct {
+	desc    string
+	fixDesc string
+	hint    string
+	fix     func(printf shared.FormatFn) error
+}
+
+// DoctorCmd returns the doctor command
+func DoctorCmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	d := &doctor{provision: &provision{RootArgs: rootArgs}}
+
+	c := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose and repair the remote service artifacts in your Apigee environment",
+		Long: `The doctor command first checks the management API and the runtime can be reached (DNS, TLS)
+and accept the credentials given, assuming legacy SaaS or OPDK if --management and the credentials
+only fit them. It then checks what provision created for problems it can detect: undeployed
+remote-service proxies, a missing remote-service cache (legacy and OPDK), a remote-service kvm
+missing its jwks entry (legacy and OPDK) and, given a hybrid config file (--config), a key ID
+not served by the remote-service proxy and a credential expired or expiring within a day
+(see provision --expires-in). With --fix, each repair is offered for confirmation
+before it's made (--yes confirms all).`,
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if d.yes && !d.fix {
+				return fmt.Errorf("--yes only valid with --fix")
+			}
+			if err := d.resources.validate(); err != nil {
+				return err
+			}
+			d.detectPlatform(printf)
+			if err := rootArgs.Resolve(false, false); err != nil {
+				return err
+			}
+			if !d.IsGCPManaged && d.useAppGroup {
+				return fmt.Errorf("--use-appgroup only valid for hybrid")
+			}
+			return d.credential.validate(d.provision)
+		},
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+			d.in = bufio.NewScanner(cmd.InOrStdin())
+			return d.run(printf)
+		},
+	}
+
+	c.Flags().StringVarP(&rootArgs.ManagementBase, "management", "m",
+		shared.DefaultManagementBase, "Apigee management base URL")
+	c.Flags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.Flags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+
+	c.Flags().StringVarP(&rootArgs.Token, "token", "t", "",
+		"Apigee OAuth or SAML token (hybrid only)")
+	c.Flags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
+	c.Flags().StringVarP(&rootArgs.Username, "username", "u", "",
+		"Apigee username (legacy or OPDK only)")
+	c.Flags().StringVarP(&rootArgs.Password, "password", "p", "",
+		"Apigee password (legacy or OPDK only)")
+
+	c.Flags().StringVarP(&d.resources.kvm, "kvm-name", "", "",
+		"name of the kvm given to provision (default: "+defaultKVMName+")")
+	c.Flags().StringVarP(&d.resources.cache, "cache-name", "", "",
+		"name of the cache given to provision (default: "+defaultCacheName+")")
+	c.Flags().BoolVarP(&d.useAppGroup, "use-appgroup", "", false,
+		"the remote-service app was created in an AppGroup (hybrid only)")
+	c.Flags().StringVarP(&d.credential.developer, "developer", "", "",
+		"email of the developer given to provision (default: "+shared.DefaultDeveloperEmail+") (hybrid only)")
+	c.Flags().StringVarP(&d.credential.app, "app", "", "",
+		"name of the app given to provision (default: "+shared.DefaultAppName+") (hybrid only)")
+	c.Flags().BoolVarP(&d.fix, "fix", "", false,
+		"offer to repair the problems found")
+	c.Flags().BoolVarP(&d.yes, "yes", "y", false,
+		"make all repairs without asking for confirmation")
+
+	return c
+}
+
+// run reports the problems found and, with --fix, repairs those confirmed
+func (d *doctor) run(printf shared.FormatFn) error {
+	problems, err := d.diagnose()
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		printf("no problems found")
+		return nil
+	}
+
+	var errs error
+	remaining := 0
+	for _, prob := range problems {
+		printf("problem: %s", prob.desc)
+		if prob.fix == nil {
+			printf("  %s", prob.hint)
+			remaining++
+			continue
+		}
+		if !d.fix {
+			printf("  fix: %s (use --fix)", prob.fixDesc)
+			remaining++
+			continue
+		}
+		if !d.yes && !d.confirm(prob.fixDesc, printf) {
+			printf("  skipped")
+			remaining++
+			continue
+		}
+		if err := prob.fix(printf); err != nil {
+			errs = multierr.Append(errs, errors.Wrap(err, prob.fixDesc))
+			remaining++
+			continue
+		}
+		printf("  fixed")
+	}
+
+	if remaining > 0 {
+		if d.fix {
+			errs = multierr.Append(errs, fmt.Errorf("%d of %d problem(s) not fixed", remaining, len(problems)))
+		} else {
+			errs = multierr.Append(errs, fmt.Errorf("%d problem(s) found", len(problems)))
+		}
+	}
+	return errs
+}
+
+// confirm asks whether to make a fix, anything but yes declines
+func (d *doctor) confirm(fixDesc string, printf shared.FormatFn) bool {
+	if shared.NonInteractive {
+		printf("  %s? declined, --ci doesn't prompt (use --yes)", fixDesc)
+		return false
+	}
+	printf("  %s? [y/N]", fixDesc)
+	if !d.in.Scan() {
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(d.in.Text()))
+	return answer == "y" || answer == "yes"
+}
+
+func (d *doctor) diagnose() ([]problem, error) {
+	if prob := d.checkManagement(); prob != nil {
+		return []problem{*prob}, nil
+	}
+
+	var problems []problem
+	proxies := []string{authProxyName}
+	if d.IsOPDK {
+		proxies = append(proxies, internalProxyName)
+	}
+	for _, name := range proxies {
+		prob, err := d.checkProxy(name)
+		if err != nil {
+			return nil, err
+		}
+		problems = appendProblem(problems, prob)
+	}
+
+	// the runtime can only serve deployed proxies
+	runtimeOK := false
+	if u, err := url.Parse(d.RemoteServiceProxyURL); err == nil && u.Host != "" && len(problems) == 0 {
+		prob := d.checkRuntime()
+		problems = appendProblem(problems, prob)
+		runtimeOK = prob == nil
+	}
+
+	if !d.IsGCPManaged {
+		prob, err := d.checkCache()
+		if err != nil {
+			return nil, err
+		}
+		problems = appendProblem(problems, prob)
+
+		if prob, err = d.checkKVM(); err != nil {
+			return nil, err
+		}
+		problems = appendProblem(problems, prob)
+	}
+
+	if runtimeOK && d.ServerConfig != nil && d.ServerConfig.Tenant.PrivateKey != nil {
+		prob, err := d.checkKeyID()
+		if err != nil {
+			return nil, err
+		}
+		problems = appendProblem(problems, prob)
+	}
+
+	if d.IsGCPManaged && d.ServerConfig != nil && d.ServerConfig.Tenant.Key != "" {
+		prob, err := d.checkCredential(time.Now())
+		if err != nil {
+			return nil, err
+		}
+		problems = appendProblem(problems, prob)
+	}
+
+	return problems, nil
+}
+
+func appendProblem(problems []problem, prob *problem) []problem {
+	if prob == nil {
+		return problems
+	}
+	return append(problems, *prob)
+}
+
+// checkProxy offers to deploy the highest revision of a proxy that isn't deployed
+func (d *doctor) checkProxy(name string) (*problem, error) {
+	var rev *apigee.Revision
+	var err error
+	if d.IsGCPManaged {
+		rev, err = d.ApigeeClient.Proxies.GetGCPDeployedRevision(name)
+	} else {
+		rev, err = d.ApigeeClient.Proxies.GetDeployedRevision(name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "checking deployment of proxy %s", name)
+	}
+	if rev != nil {
+		return nil, nil
+	}
+
+	proxy, resp, err := d.ApigeeClient.Proxies.Get(name)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return nil, errors.Wrapf(err, "retrieving proxy %s", name)
+	}
+	if proxy == nil || len(proxy.Revisions) == 0 {
+		return &problem{
+			desc: fmt.Sprintf("proxy %s not found", name),
+			hint: "run provision to import it",
+		}, nil
+	}
+
+	sort.Sort(apigee.RevisionSlice(proxy.Revisions))
+	latest := proxy.Revisions[len(proxy.Revisions)-1]
+	return &problem{
+		desc:    fmt.Sprintf("proxy %s is not deployed to %s", name, d.Env),
+		fixDesc: fmt.Sprintf("deploy proxy %s revision %d to %s", name, latest, d.Env),
+		fix: func(printf shared.FormatFn) error {
+			_, _, err := d.ApigeeClient.Proxies.Deploy(name, d.Env, latest)
+			return err
+		},
+	}, nil
+}
+
+// checkCache offers to create a missing remote-service cache
+func (d *doctor) checkCache() (*problem, error) {
+	name := d.resources.cacheName()
+	_, resp, err := d.ApigeeClient.CacheService.Get(name)
+	if err == nil {
+		return nil, nil
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		return nil, errors.Wrapf(err, "retrieving cache %s", name)
+	}
+
+	return &problem{
+		desc:    fmt.Sprintf("cache %s not found", name),
+		fixDesc: fmt.Sprintf("create cache %s", name),
+		fix: func(printf shared.FormatFn) error {
+			_, err := d.ApigeeClient.CacheService.Create(apigee.Cache{Name: name})
+			return err
+		},
+	}, nil
+}
+
+// checkKVM offers to restore the jwks entry of the remote-service kvm from its private key
+func (d *doctor) checkKVM() (*problem, error) {
+	name := d.resources.kvmName()
+	kvm, resp, err := d.ApigeeClient.KVMService.Get(name)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return nil, errors.Wrapf(err, "retrieving kvm %s", name)
+	}
+	if kvm == nil {
+		return &problem{
+			desc: fmt.Sprintf("kvm %s not found", name),
+			hint: "run provision to create it",
+		}, nil
+	}
+	if _, ok := kvm.GetValue("jwks"); ok {
+		return nil, nil
+	}
+
+	desc := fmt.Sprintf("kvm %s has no jwks entry", name)
+	keyPEM, ok := kvm.GetValue("private_key")
+	if !ok {
+		return &problem{
+			desc: desc,
+			hint: "run provision to create new keys",
+		}, nil
+	}
+	if kvm.IsMasked("private_key") {
+		return &problem{
+			desc: desc,
+			hint: "the private key of an encrypted kvm can't be read, run 'token rotate-cert' to create new keys",
+		}, nil
+	}
+
+	return &problem{
+		desc:    desc,
+		fixDesc: fmt.Sprintf("add jwks entry to kvm %s from its private key", name),
+		fix: func(printf shared.FormatFn) error {
+			privateKey, err := parsePrivateKey(keyPEM)
+			if err != nil {
+				return err
+			}
+			kid, hasKID := kvm.GetValue("kid")
+			if !hasKID {
+				kid = time.Now().Format(time.RFC3339)
+			}
+			key, err := publicJWK(privateKey, kid)
+			if err != nil {
+				return err
+			}
+			jwksBytes, err := json.Marshal(&jwk.Set{Keys: []jwk.Key{key}})
+			if err != nil {
+				return err
+			}
+
+			entries := []apigee.Entry{{Name: "jwks", Value: string(jwksBytes)}}
+			if !hasKID {
+				entries = append(entries, apigee.Entry{Name: "kid", Value: kid})
+			}
+			for _, entry := range entries {
+				if _, err := d.ApigeeClient.KVMService.AddEntry(name, entry); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}, nil
+}
+
+// checkKeyID offers a corrected policy secret if the remote-service proxy doesn't
+// serve the key ID of the config's private key
+func (d *doctor) checkKeyID() (*problem, error) {
+	certsURL := d.RemoteServiceProxyURL + "/certs"
+	served, err := jwk.FetchHTTP(certsURL, jwk.WithHTTPClient(d.RuntimeClient()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving JWKs from: %s", certsURL)
+	}
+
+	kid := d.ServerConfig.Tenant.PrivateKeyID
+	if len(served.LookupKeyID(kid)) > 0 {
+		return nil, nil
+	}
+
+	return &problem{
+		desc:    fmt.Sprintf("key ID %s of %s is not served by %s", kid, d.ConfigPath, certsURL),
+		fixDesc: fmt.Sprintf("print policy secret %s with the key of %s", d.policySecretName(), d.ConfigPath),
+		fix: func(printf shared.FormatFn) error {
+			key, err := publicJWK(d.ServerConfig.Tenant.PrivateKey, kid)
+			if err != nil {
+				return err
+			}
+			config := *d.ServerConfig
+			config.Tenant.JWKS = &jwk.Set{Keys: append([]jwk.Key{key}, served.Keys...)}
+
+			secretCRD, err := d.policySecretCRD(&config)
+			if err != nil {
+				return err
+			}
+			var yamlBuffer bytes.Buffer
+			yamlEncoder := yaml.NewEncoder(&yamlBuffer)
+			yamlEncoder.SetIndent(2)
+			if err := yamlEncoder.Encode(secretCRD); err != nil {
+				return err
+			}
+			printf("# apply to the Apigee runtime namespace and restart the runtime to serve the key")
+			printf(yamlBuffer.String())
+			return nil
+		},
+	}, nil
+}
+
+func parsePrivateKey(keyPEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// publicJWK returns the public key as a JWK, as in the jwks created by provision
+func publicJWK(privateKey *rsa.PrivateKey, kid string) (jwk.Key, error) {
+	key, err := jwk.New(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.KeyIDKey, kid); err != nil {
+		return nil, err
+	}
+	if err := key.Set(jwk.AlgorithmKey, jwa.RS256); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// checkCredential reports if the credential of the config has expired or expires
+// soon. It's not checked if the app doesn't hold it.
+func (d *doctor) checkCredential(now time.Time) (*problem, error) {
+	var creds []apigee.AppCredential
+	var resp *apigee.Response
+	var err error
+	appName := d.credential.appName()
+	if d.useAppGroup {
+		var app *apigee.AppGroupApp
+		if app, resp, err = d.ApigeeClient.AppGroups.GetApp(shared.DefaultAppGroupName, appName); err == nil {
+			creds = app.Credentials
+		}
+	} else {
+		var app *apigee.DeveloperApp
+		if app, resp, err = d.ApigeeClient.DeveloperApps.Get(d.credential.developerEmail(), appName); err == nil {
+			creds = app.Credentials
+		}
+	}
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "retrieving app %s", appName)
+	}
+
+	for _, c := range creds {
+		if c.ConsumerKey != d.ServerConfig.Tenant.Key {
+			continue
+		}
+		expiresAt, ok := credentialExpiry(c)
+		if !ok || expiresAt.Sub(now) > credentialExpiryWarning {
+			return nil, nil
+		}
+		desc := fmt.Sprintf("credential of %s (app %s) expires at %s", d.ConfigPath, appName, expiresAt.Format(time.RFC3339))
+		if !expiresAt.After(now) {
+			desc = fmt.Sprintf("credential of %s (app %s) expired at %s", d.ConfigPath, appName, expiresAt.Format(time.RFC3339))
+		}
+		return &problem{
+			desc: desc,
+			hint: "run provision --rotate-key with the config to replace it",
+		}, nil
+	}
+	return nil, nil
+}
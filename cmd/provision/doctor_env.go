@@ -0,0 +1,174 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+)
+
+const doctorRuntimeTimeout = 30 * time.Second
+
+// detectPlatform picks legacy SaaS or OPDK if neither is given but the
+// management URL or credentials only fit them, hybrid stays the default
+func (d *doctor) detectPlatform(printf shared.FormatFn) {
+	if d.IsLegacySaaS || d.IsOPDK {
+		return
+	}
+	hasToken := d.Token != "" || d.ServiceAccount != "" || os.Getenv(shared.CredentialsEnv) != ""
+	basicAuth := d.Username != "" && !hasToken
+	switch {
+	case d.ManagementBase == shared.LegacySaaSManagementBase:
+		d.IsLegacySaaS = true
+	case basicAuth && d.ManagementBase != shared.GCPExperienceBase:
+		d.IsOPDK = true
+	case basicAuth:
+		d.IsLegacySaaS = true
+	default:
+		return
+	}
+	platform := "legacy SaaS"
+	if d.IsOPDK {
+		platform = "OPDK"
+	}
+	printf("assuming %s from --management and the credentials given (pass --legacy or --opdk to override)", platform)
+}
+
+// checkManagement verifies the management API is reachable and accepts the
+// credentials for the organization, nothing else can be checked otherwise
+func (d *doctor) checkManagement() *problem {
+	if prob := lookupProblem("management", d.ManagementBase, d.RootArgs); prob != nil {
+		return prob
+	}
+
+	req, err := d.ApigeeClient.NewRequestNoEnv(http.MethodGet, "", nil)
+	if err != nil {
+		return &problem{desc: fmt.Sprintf("management API request: %v", err), hint: "check --management is a URL"}
+	}
+	resp, err := d.ApigeeClient.Do(req, nil)
+	if err == nil {
+		return nil
+	}
+	if resp == nil {
+		return &problem{
+			desc: fmt.Sprintf("management API %s not reachable: %v", d.ManagementBase, err),
+			hint: connectionHint(err),
+		}
+	}
+
+	code := shared.ErrorCodeOf(err)
+	hint := shared.ErrorCatalog[code].Remediation
+	switch code {
+	case shared.CodeNotFound:
+		hint = fmt.Sprintf("check --organization %s exists at --management %s, hybrid is assumed unless --legacy or --opdk is given", d.Org, d.ManagementBase)
+	case shared.CodeUnauthorized:
+		if !d.IsGCPManaged {
+			hint = "check --username and --password, or the entry of the management host in ~/.netrc"
+		}
+	case "":
+		hint = "check --management is the management API of the organization"
+	}
+	return &problem{
+		desc: fmt.Sprintf("management API refused organization %s: %s", d.Org, resp.Status),
+		hint: hint,
+	}
+}
+
+// checkRuntime verifies the remote-service proxy answers at the runtime
+func (d *doctor) checkRuntime() *problem {
+	if prob := lookupProblem("runtime", d.RemoteServiceProxyURL, d.RootArgs); prob != nil {
+		return prob
+	}
+
+	client := &http.Client{
+		Transport: d.RuntimeTransport(d.InsecureSkipVerify),
+		Timeout:   doctorRuntimeTimeout,
+	}
+	certsURL := fmt.Sprintf(certsURLFormat, d.RemoteServiceProxyURL)
+	resp, err := client.Get(certsURL)
+	if err != nil {
+		return &problem{
+			desc: fmt.Sprintf("runtime %s not reachable: %v", d.RemoteServiceProxyURL, err),
+			hint: connectionHint(err),
+		}
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	hint := "check --runtime is the base URL of the environment's runtime and its virtual host"
+	if resp.StatusCode >= 500 {
+		hint = "the runtime may still be starting or the proxy still deploying, try again later"
+	}
+	return &problem{
+		desc: fmt.Sprintf("runtime %s answered %s", certsURL, resp.Status),
+		hint: hint,
+	}
+}
+
+// lookupProblem reports a host of rawURL that can't be resolved, unless
+// requests go through a proxy resolving it instead
+func lookupProblem(name, rawURL string, r *shared.RootArgs) *problem {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return &problem{
+			desc: fmt.Sprintf("%s URL %q is not absolute", name, rawURL),
+			hint: fmt.Sprintf("pass the %s base URL, including its scheme", name),
+		}
+	}
+	if r.RoundTripper != nil || r.ProxyURL != "" {
+		return nil
+	}
+	if proxy, err := http.ProxyFromEnvironment(&http.Request{URL: u}); err == nil && proxy != nil {
+		return nil
+	}
+	if _, err := net.LookupHost(u.Hostname()); err != nil {
+		return &problem{
+			desc: fmt.Sprintf("%s host %s not found: %v", name, u.Hostname(), err),
+			hint: "check the host name of the URL and the DNS of your network, or pass --proxy-url",
+		}
+	}
+	return nil
+}
+
+// connectionHint suggests how to fix a request that failed before a response
+func connectionHint(err error) string {
+	var unknownAuthority x509.UnknownAuthorityError
+	var hostname x509.HostnameError
+	var invalid x509.CertificateInvalidError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &unknownAuthority):
+		return "the server's certificate is issued by an unknown CA, trust it with --ca-cert (or skip verification with --insecure)"
+	case errors.As(err, &hostname):
+		return "the server's certificate is not valid for the host name, check the URL"
+	case errors.As(err, &invalid):
+		return "the server's certificate is expired or not valid yet, check it and the system clock"
+	case strings.Contains(err.Error(), "certificate required") || strings.Contains(err.Error(), "bad certificate"):
+		return "the server requires a client certificate, pass --client-cert and --client-key"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "the connection timed out, check a firewall or proxy (--proxy-url) doesn't block it"
+	}
+	return "check the URL and that the network allows the connection, or pass --proxy-url"
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestDoctorEnvironment(t *testing.T) {
+	runtimeStatus := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/v1/organizations/unauthorized"):
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.HasPrefix(r.URL.Path, "/v1/organizations/missing"):
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service/deployments"):
+			_ = json.NewEncoder(w).Encode(apigee.GCPDeployments{Deployments: []apigee.GCPDeployment{
+				{Environment: "test", Name: "remote-service", Revision: "1"},
+			}})
+		case r.URL.Path == "/remote-service/certs":
+			w.WriteHeader(runtimeStatus)
+			_, _ = w.Write([]byte(`{"keys":[]}`))
+		default:
+			_, _ = w.Write([]byte("{}"))
+		}
+	}))
+	defer ts.Close()
+
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestDoctorEnvironment")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"doctor", "-e", "test"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testDoctorCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+
+	if _, err := run("-o", "hybrid", "-t", "token"); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+
+	print, err := run("-o", "unauthorized", "-t", "token")
+	testutil.ErrorContains(t, err, "1 problem(s) found")
+	print.Check(t, []string{
+		"problem: management API refused organization unauthorized: 401 Unauthorized",
+		"  " + shared.ErrorCatalog[shared.CodeUnauthorized].Remediation,
+	})
+
+	print, err = run("-o", "unauthorized", "-u", "me", "-p", "password", "--legacy")
+	testutil.ErrorContains(t, err, "1 problem(s) found")
+	checkContains(t, print.Prints, "check --username and --password")
+
+	print, err = run("-o", "missing", "-t", "token")
+	testutil.ErrorContains(t, err, "1 problem(s) found")
+	checkContains(t, print.Prints, "hybrid is assumed unless --legacy or --opdk is given")
+
+	runtimeStatus = http.StatusNotFound
+	print, err = run("-o", "hybrid", "-t", "token")
+	testutil.ErrorContains(t, err, "1 problem(s) found")
+	print.Check(t, []string{
+		"problem: runtime " + ts.URL + "/remote-service/certs answered 404 Not Found",
+		"  check --runtime is the base URL of the environment's runtime and its virtual host",
+	})
+}
+
+func TestDoctorConnectionHints(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	d := &doctor{provision: &provision{RootArgs: &shared.RootArgs{RemoteServiceProxyURL: ts.URL + "/remote-service"}}}
+	prob := d.checkRuntime()
+	if prob == nil || !strings.Contains(prob.hint, "--ca-cert") {
+		t.Errorf("want --ca-cert hint for an unknown CA, got %v", prob)
+	}
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	d.RemoteServiceProxyURL = closed.URL + "/remote-service"
+	if prob := d.checkRuntime(); prob == nil || !strings.Contains(prob.desc, "not reachable") {
+		t.Errorf("want runtime not reachable, got %v", prob)
+	}
+
+	if prob := lookupProblem("runtime", "https://remote-service.invalid", d.RootArgs); prob == nil || !strings.Contains(prob.desc, "not found") {
+		t.Errorf("want host not found, got %v", prob)
+	}
+	if prob := lookupProblem("runtime", "/remote-service", d.RootArgs); prob == nil || !strings.Contains(prob.desc, "not absolute") {
+		t.Errorf("want URL not absolute, got %v", prob)
+	}
+}
+
+func TestDoctorDetectPlatform(t *testing.T) {
+	for _, test := range []struct {
+		args         shared.RootArgs
+		legacy, opdk bool
+	}{
+		{shared.RootArgs{ManagementBase: shared.GCPExperienceBase, Token: "token"}, false, false},
+		{shared.RootArgs{ManagementBase: shared.LegacySaaSManagementBase, Token: "token"}, true, false},
+		{shared.RootArgs{ManagementBase: shared.GCPExperienceBase, Username: "me"}, true, false},
+		{shared.RootArgs{ManagementBase: "https://opdk.example.com", Username: "me"}, false, true},
+		{shared.RootArgs{ManagementBase: "https://opdk.example.com", Username: "me", IsLegacySaaS: true}, true, false},
+	} {
+		args := test.args
+		d := &doctor{provision: &provision{RootArgs: &args}}
+		print := testutil.Printer("TestDoctorDetectPlatform")
+		d.detectPlatform(print.Printf)
+		if d.IsLegacySaaS != test.legacy || d.IsOPDK != test.opdk {
+			t.Errorf("%v: want legacy %t and opdk %t, got %t and %t", test.args, test.legacy, test.opdk, d.IsLegacySaaS, d.IsOPDK)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/spf13/cobra"
+)
+
+func TestDoctor(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: server.PEMKeyType, Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	var calls []string
+	var entries []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			calls = append(calls, r.Method+" "+r.URL.String())
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service/deployments"):
+			_ = json.NewEncoder(w).Encode(apigee.EnvironmentDeployment{Name: "test"})
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service") && strings.Contains(r.URL.Path, "/healthy/"):
+			_ = json.NewEncoder(w).Encode(apigee.Proxy{Name: "remote-service"})
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service") && r.Method == http.MethodGet:
+			_ = json.NewEncoder(w).Encode(apigee.Proxy{Name: "remote-service", Revisions: []apigee.Revision{2, 1}})
+		case strings.HasSuffix(r.URL.Path, "/caches/remote-service"):
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasSuffix(r.URL.Path, "/keyvaluemaps/remote-service/entries"):
+			body, _ := ioutil.ReadAll(r.Body)
+			entries = append(entries, string(body))
+			_, _ = w.Write(body)
+		case strings.HasSuffix(r.URL.Path, "/keyvaluemaps/remote-service"):
+			_ = json.NewEncoder(w).Encode(apigee.KVM{Name: "remote-service", Entries: []apigee.Entry{
+				{Name: "private_key", Value: string(keyPEM)},
+				{Name: "kid", Value: "mykid"},
+			}})
+		default:
+			_, _ = w.Write([]byte("{}"))
+		}
+	}))
+	defer ts.Close()
+
+	run := func(in string, args ...string) (*testutil.TestPrint, error) {
+		calls = nil
+		print := testutil.Printer("TestDoctor")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"doctor", "-e", "test", "-u", "me", "-p", "password", "--legacy"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		rootCmd.SetIn(strings.NewReader(in))
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testDoctorCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+
+	// report only
+	print, err := run("", "-o", "legacy")
+	testutil.ErrorContains(t, err, "3 problem(s) found")
+	print.Check(t, []string{
+		"problem: proxy remote-service is not deployed to test",
+		"  fix: deploy proxy remote-service revision 2 to test (use --fix)",
+		"problem: cache remote-service not found",
+		"  fix: create cache remote-service (use --fix)",
+		"problem: kvm remote-service has no jwks entry",
+		"  fix: add jwks entry to kvm remote-service from its private key (use --fix)",
+	})
+	if len(calls) != 0 {
+		t.Errorf("want no calls without --fix, got %v", calls)
+	}
+
+	// confirm the first, decline the second, no answer for the third
+	_, err = run("y\nn\n", "-o", "legacy", "--fix")
+	testutil.ErrorContains(t, err, "2 of 3 problem(s) not fixed")
+	want := []string{
+		"POST /v1/organizations/legacy/environments/test/apis/remote-service/revisions/2/deployments?action=deploy&delay=12&env=test&override=true",
+	}
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("want calls %v, got %v", want, calls)
+	}
+
+	// fix all
+	entries = nil
+	if _, err = run("", "-o", "legacy", "--fix", "--yes"); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	want = append(want,
+		"POST /v1/organizations/legacy/environments/test/caches?name=remote-service",
+		"POST /v1/organizations/legacy/environments/test/keyvaluemaps/remote-service/entries",
+	)
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("want calls %v, got %v", want, calls)
+	}
+	if len(entries) != 1 || !strings.Contains(entries[0], `"name":"jwks"`) || !strings.Contains(entries[0], `\"kid\":\"mykid\"`) {
+		t.Errorf("want jwks entry with kid mykid, got %v", entries)
+	}
+
+	// proxy without revisions can't be fixed
+	print, err = run("", "-o", "healthy", "--fix", "--yes")
+	testutil.ErrorContains(t, err, "problem(s) not fixed")
+	if print.Prints[0] != "problem: proxy remote-service not found" || print.Prints[1] != "  run provision to import it" {
+		t.Errorf("want proxy not found, got %v", print.Prints)
+	}
+
+	_, err = run("", "-o", "legacy", "--yes")
+	testutil.ErrorContains(t, err, "--yes only valid with --fix")
+}
+
+func TestDoctorKeyID(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servedKey, err := publicJWK(privateKey, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&jwk.Set{Keys: []jwk.Key{servedKey}})
+	}))
+	defer ts.Close()
+
+	d := &doctor{provision: &provision{RootArgs: &shared.RootArgs{
+		Org:                   "org",
+		Env:                   "test",
+		Namespace:             "apigee",
+		ConfigPath:            "config.yaml",
+		RemoteServiceProxyURL: ts.URL,
+		ServerConfig: &server.Config{Tenant: server.TenantConfig{
+			PrivateKey:   privateKey,
+			PrivateKeyID: "old",
+		}},
+	}}}
+
+	prob, err := d.checkKeyID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prob != nil {
+		t.Errorf("want no problem for served key ID, got %s", prob.desc)
+	}
+
+	d.ServerConfig.Tenant.PrivateKeyID = "new"
+	if prob, err = d.checkKeyID(); err != nil {
+		t.Fatal(err)
+	}
+	if prob == nil || prob.fix == nil {
+		t.Fatal("want fixable problem for key ID not served")
+	}
+	print := testutil.Printer("TestDoctorKeyID")
+	if err := prob.fix(print.Printf); err != nil {
+		t.Fatal(err)
+	}
+	secret := strings.Join(print.Prints, "\n")
+	for _, want := range []string{"kind: Secret", "name: org-test-policy-secret", "namespace: apigee"} {
+		if !strings.Contains(secret, want) {
+			t.Errorf("want %q in:\n%s", want, secret)
+		}
+	}
+	if d.ServerConfig.Tenant.JWKS != nil {
+		t.Errorf("want config unchanged")
+	}
+}
+
+func TestDoctorCredentialExpiry(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	var expiresAt time.Time
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/developers/remote-service@apigee.com/apps/remote-service") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(apigee.DeveloperApp{Credentials: []apigee.AppCredential{
+			{ConsumerKey: "other"},
+			{ConsumerKey: "key", ExpiresAt: apigee.Timestamp{Time: expiresAt}},
+		}})
+	}))
+	defer ts.Close()
+
+	rootArgs := &shared.RootArgs{
+		Env:          "test",
+		IsGCPManaged: true,
+		ConfigPath:   "config.yaml",
+		ClientOpts:   &apigee.EdgeClientOptions{Org: "gcp", Env: "test", Auth: &apigee.EdgeAuth{SkipAuth: true}},
+		ServerConfig: &server.Config{Tenant: server.TenantConfig{Key: "key"}},
+	}
+	setTestUrls(rootArgs, ts.URL)
+	d := &doctor{provision: &provision{RootArgs: rootArgs}}
+
+	for _, tc := range []struct {
+		expiresAt time.Time
+		want      string
+	}{
+		{time.Unix(0, -int64(time.Millisecond)), ""},
+		{now.Add(48 * time.Hour), ""},
+		{now.Add(time.Hour), "credential of config.yaml (app remote-service) expires at " + now.Add(time.Hour).Format(time.RFC3339)},
+		{now.Add(-time.Hour), "credential of config.yaml (app remote-service) expired at " + now.Add(-time.Hour).Format(time.RFC3339)},
+	} {
+		expiresAt = tc.expiresAt
+		prob, err := d.checkCredential(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.want == "" {
+			if prob != nil {
+				t.Errorf("want no problem for expiry %s, got %s", tc.expiresAt, prob.desc)
+			}
+			continue
+		}
+		if prob == nil || prob.desc != tc.want || prob.fix != nil {
+			t.Errorf("want unfixable problem %q, got %v", tc.want, prob)
+		}
+	}
+
+	// another app holds the credential
+	d.credential.app = "other"
+	prob, err := d.checkCredential(now)
+	if err != nil || prob != nil {
+		t.Errorf("want no problem or error for missing app, got %v, %v", prob, err)
+	}
+}
+
+func testDoctorCmd(rootArgs *shared.RootArgs, printf shared.FormatFn, url string) *cobra.Command {
+	c := DoctorCmd(rootArgs, printf)
+
+	defaultPersistentPreRun := c.PersistentPreRunE
+	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := defaultPersistentPreRun(cmd, args); err != nil {
+			return err
+		}
+		setTestUrls(rootArgs, url)
+		return nil
+	}
+
+	return c
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestProvisionDryRun(t *testing.T) {
+	mux := serveMux(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("dry run made call: %s %s", r.Method, r.URL)
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		desc  string
+		flags []string
+		want  []string
+	}{
+		{"legacy", []string{"-o", "hi", "-e", "test", "-u", "me", "-p", "password", "--legacy"}, []string{
+			"dry run: POST " + ts.URL + "/v1/organizations/hi/apis?action=import&name=remote-service <",
+			`dry run: POST ` + ts.URL + `/v1/organizations/hi/apiproducts {"apiResources":["/verifyApiKey","/token"],`,
+			`dry run: POST ` + ts.URL + `/credential/organization/hi/environment/test {"key":"`,
+			`dry run: POST ` + ts.URL + `/v1/organizations/hi/environments/test/keyvaluemaps {"encrypted":true,"entry":[{"name":"private_key","value":"<redacted>"}`,
+		}},
+		{"opdk", []string{"-o", "opdk", "-e", "test", "-u", "me", "-p", "password", "-r", ts.URL, "--opdk"}, []string{
+			"dry run: POST " + ts.URL + "/v1/organizations/opdk/apis?action=import&name=edgemicro-internal <",
+			"dry run: POST " + ts.URL + "/v1/organizations/opdk/environments/test/caches?name=remote-service {",
+		}},
+		{"hybrid", []string{"-o", "gcp", "-e", "test", "-r", ts.URL, "-t", "token"}, []string{
+			"dry run: POST " + ts.URL + "/v1/organizations/gcp/apis?action=import&name=remote-service <",
+			"dry run: POST " + ts.URL + "/v1/organizations/gcp/developers {",
+			"dry run: POST " + ts.URL + "/v1/organizations/gcp/developers/remote-service@apigee.com/apps {",
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.desc, func(t *testing.T) {
+			print := testutil.Printer(test.desc)
+			rootArgs := &shared.RootArgs{}
+			flags := append([]string{"provision", "--dry-run", "-f"}, test.flags...)
+			rootCmd := cmd.GetRootCmd(flags, print.Printf)
+			shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+
+			if err := rootCmd.Execute(); err != nil {
+				t.Fatalf("want no error: %v", err)
+			}
+
+			for _, want := range test.want {
+				found := false
+				for _, p := range print.Prints {
+					if strings.HasPrefix(p, want) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					t.Errorf("want print starting with %q in %v", want, print.Prints)
+				}
+			}
+			for _, p := range print.Prints {
+				if strings.Contains(p, "PRIVATE KEY") || (strings.Contains(p, `"secret":`) && !strings.Contains(p, `"secret":"<redacted>"`)) {
+					t.Errorf("want sensitive values redacted: %s", p)
+				}
+			}
+			last := print.Prints[len(print.Prints)-1]
+			if !strings.HasPrefix(last, "dry run: ") || !strings.HasSuffix(last, "call(s) not made, verification and configuration skipped") {
+				t.Errorf("want summary, got %q", last)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+const defaultEnvWorkers = 4
+
+// envRun is the outcome of provisioning one of multiple environments,
+// output is buffered to be printed in the order of the environments
+type envRun struct {
+	prints  []string
+	results []shared.ProvisionResult
+	err     error
+}
+
+func (r *envRun) printf(format string, args ...interface{}) {
+	r.prints = append(r.prints, fmt.Sprintf(format, args...))
+}
+
+// runEnvs provisions the environments concurrently with up to p.workers
+func (p *provision) runEnvs(printf shared.FormatFn) error {
+	runs := make([]envRun, len(p.envs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < p.workers && i < len(p.envs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				runs[j].err = p.runEnvCopy(p.envs[j], &runs[j])
+			}
+		}()
+	}
+	for i := range p.envs {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var errs error
+	var results []shared.ProvisionResult
+	for i, run := range runs {
+		if i > 0 && p.output == outputYAML && !p.dryRun {
+			printf("---")
+		}
+		for _, line := range run.prints {
+			printf("%s", line)
+		}
+		results = append(results, run.results...)
+		errs = multierr.Append(errs, errors.Wrapf(run.err, "environment %s", p.envs[i]))
+	}
+
+	if p.output == outputJSON && len(results) > 0 {
+		resultsJSON, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		printf("%s", resultsJSON)
+	}
+	// to stderr, not to corrupt the output
+	p.PrintRateLimits(shared.Errorf)
+	return errs
+}
+
+// runEnvCopy provisions env with a copy of p so environments don't share state
+func (p *provision) runEnvCopy(env string, run *envRun) error {
+	rootArgs := *p.RootArgs
+	q := *p
+	q.RootArgs = &rootArgs
+	q.results = nil
+	q.dryRunCalls = 0
+	q.stepFailures = nil
+	if err := q.SwitchEnv(env); err != nil {
+		return err
+	}
+	if q.dryRun {
+		if err := q.enableDryRun(run.printf); err != nil {
+			return err
+		}
+	}
+	q.Stepf()("provisioning environment %s...", env)
+	err := q.runEnv(run.printf)
+	run.results = q.results
+	return err
+}
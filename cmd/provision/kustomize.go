@@ -0,0 +1,218 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+)
+
+// kustomizeData is the data model the kustomization files are rendered with
+type kustomizeData struct {
+	Env       string
+	Namespace string
+	ConfigMap string
+	Secret    string // policy secret, hybrid and Apigee X only
+}
+
+// kustomizeFiles maps the files of --kustomize-out to their templates. The
+// adapter of an environment is a nested kustomization suffixing the base by
+// the environment, so environments can share a namespace, while the
+// ConfigMap and Secret keep the names provisioned (a SealedSecret is bound
+// to its name).
+var kustomizeFiles = map[string]string{
+	"base/kustomization.yaml":                         kustomizeBase,
+	"base/deployment.yaml":                            kustomizeDeployment,
+	"base/service.yaml":                               kustomizeService,
+	"overlays/{{.Env}}/kustomization.yaml":            kustomizeOverlay,
+	"overlays/{{.Env}}/adapter/kustomization.yaml":    kustomizeAdapter,
+	"overlays/{{.Env}}/adapter/deployment-patch.yaml": kustomizePatch,
+}
+
+// writeKustomization writes the base and the overlay of the environment
+// to --kustomize-out, the overlay holding the resources as printed
+func (p *provision) writeKustomization(result shared.ProvisionResult) (string, error) {
+	data := kustomizeData{
+		Env:       p.Env,
+		Namespace: p.Namespace,
+		ConfigMap: result.ConfigMap,
+		Secret:    result.Secret,
+	}
+	overlay := filepath.Join(p.kustomizeDir, "overlays", p.Env)
+	files := map[string]string{filepath.Join(overlay, "resources.yaml"): result.Resources}
+	for name, text := range kustomizeFiles {
+		file, err := shared.RenderTemplate(name, name, data)
+		if err != nil {
+			return "", err
+		}
+		content, err := shared.RenderTemplate(name, text, data)
+		if err != nil {
+			return "", err
+		}
+		files[filepath.Join(p.kustomizeDir, file)] = content
+	}
+
+	for file, content := range files {
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return "", errors.Wrapf(err, "creating %s", filepath.Dir(file))
+		}
+		mode := os.FileMode(0644)
+		if strings.HasSuffix(file, "resources.yaml") {
+			mode = 0600 // may hold the plain policy secret
+		}
+		if err := ioutil.WriteFile(file, []byte(content), mode); err != nil {
+			return "", errors.Wrapf(err, "writing %s", file)
+		}
+	}
+	return overlay, nil
+}
+
+func (p *provision) validateKustomize() error {
+	if p.kustomizeDir == "" {
+		return nil
+	}
+	if p.configOut == configOutNative || p.dryRun || p.verifyOnly {
+		return fmt.Errorf("--kustomize-out can't be combined with --config-out %s, --dry-run or --verify-only", configOutNative)
+	}
+	return nil
+}
+
+const kustomizeBase = `# apigee-remote-service-envoy, shared by the overlays of the environments
+# set the image tag with: kustomize edit set image google/apigee-envoy-adapter:<tag>
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+- deployment.yaml
+- service.yaml
+`
+
+const kustomizeDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: apigee-remote-service-envoy
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: apigee-remote-service-envoy
+  template:
+    metadata:
+      labels:
+        app: apigee-remote-service-envoy
+      annotations:
+        sidecar.istio.io/inject: "false"
+    spec:
+      containers:
+      - name: apigee-remote-service-envoy
+        image: google/apigee-envoy-adapter:latest
+        imagePullPolicy: IfNotPresent
+        args:
+        - --config=/config/config.yaml
+{{- if .Secret}}
+        - --policy-secret=/policy-secret
+{{- end}}
+        ports:
+        - containerPort: 5000
+        resources:
+          limits:
+            cpu: 100m
+            memory: 100Mi
+          requests:
+            cpu: 10m
+            memory: 100Mi
+        volumeMounts:
+        - mountPath: /config
+          name: apigee-remote-service-envoy
+          readOnly: true
+{{- if .Secret}}
+        - mountPath: /policy-secret
+          name: policy-secret
+          readOnly: true
+{{- end}}
+      volumes:
+      - name: apigee-remote-service-envoy
+        configMap:
+          name: apigee-remote-service-envoy
+{{- if .Secret}}
+      - name: policy-secret
+        secret:
+          defaultMode: 420
+          secretName: policy-secret
+{{- end}}
+`
+
+const kustomizeService = `apiVersion: v1
+kind: Service
+metadata:
+  name: apigee-remote-service-envoy
+  labels:
+    app: apigee-remote-service-envoy
+spec:
+  ports:
+  - port: 5000
+    name: grpc
+  selector:
+    app: apigee-remote-service-envoy
+`
+
+const kustomizeOverlay = `# apigee-remote-service-envoy for environment {{.Env}}
+# resources.yaml is the output of apigee-remote-service-cli provision, regenerate it to update
+apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+{{- if .Namespace}}
+namespace: {{.Namespace}}
+{{- end}}
+resources:
+- adapter
+- resources.yaml
+`
+
+const kustomizeAdapter = `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+nameSuffix: -{{.Env}}
+labels:
+- pairs:
+    apigee-env: {{.Env}}
+  includeSelectors: true
+resources:
+- ../../../base
+patches:
+- path: deployment-patch.yaml
+`
+
+const kustomizePatch = `# mounts the ConfigMap{{if .Secret}} and Secret{{end}} of resources.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: apigee-remote-service-envoy
+spec:
+  template:
+    spec:
+      volumes:
+      - name: apigee-remote-service-envoy
+        configMap:
+          name: {{.ConfigMap}}
+{{- if .Secret}}
+      - name: policy-secret
+        secret:
+          secretName: {{.Secret}}
+{{- end}}
+`
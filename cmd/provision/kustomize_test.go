@@ -0,0 +1,89 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestProvisionKustomize(t *testing.T) {
+	ts := httptest.NewServer(handler(t))
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	dir, err := ioutil.TempDir("", "kustomize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	print := testutil.Printer("TestProvisionKustomize")
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-n", "ns", "-t", "token",
+		"--kustomize-out", dir}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	overlay := filepath.Join(dir, "overlays", "test")
+	print.CheckPrefix(t, []string{
+		"# Configuration for apigee-remote-service-envoy (platform: GCP)",
+		"# generated by apigee-remote-service-cli provision on",
+		"# kustomization overlay written to " + overlay,
+		"# WARNING: the overlay holds the plain policy secret, use --secret-format to store it in Git",
+		"apiVersion: v1",
+	})
+
+	read := func(file string) string {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+	for file, wants := range map[string][]string{
+		"base/kustomization.yaml":                     {"- deployment.yaml", "- service.yaml"},
+		"base/deployment.yaml":                        {"--policy-secret=/policy-secret", "secretName: policy-secret"},
+		"overlays/test/kustomization.yaml":            {"namespace: ns", "- adapter", "- resources.yaml"},
+		"overlays/test/adapter/kustomization.yaml":    {"nameSuffix: -test", "- ../../../base"},
+		"overlays/test/adapter/deployment-patch.yaml": {"name: apigee-remote-service-envoy\n", "secretName: gcp-test-policy-secret"},
+		"overlays/test/resources.yaml":                {"kind: ConfigMap", "kind: Secret", "name: gcp-test-policy-secret"},
+	} {
+		content := read(file)
+		for _, want := range wants {
+			if !strings.Contains(content, want) {
+				t.Errorf("want %q in %s:\n%s", want, file, content)
+			}
+		}
+	}
+
+	rootArgs = &shared.RootArgs{}
+	flags = append(flags, "--dry-run")
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	testutil.ErrorContains(t, rootCmd.Execute(), "--kustomize-out can't be combined with --config-out native, --dry-run or --verify-only")
+}
@@ -27,6 +27,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -53,7 +54,7 @@ const (
 
 func (p *provision) deployInternalProxy(replaceVirtualHosts func(proxyDir string) error, tempDir string, verbosef shared.FormatFn) error {
 
-	customizedZip, err := getCustomizedProxy(tempDir, internalProxyZip, func(proxyDir string) error {
+	customizedZip, digest, err := getCustomizedProxy(tempDir, internalProxyZip, p.internalProxyDir, func(proxyDir string) error {
 
 		// change server locations
 		calloutFile := filepath.Join(proxyDir, "policies", "Callout.xml")
@@ -107,7 +108,7 @@ func (p *provision) deployInternalProxy(replaceVirtualHosts func(proxyDir string
 		return err
 	}
 
-	return p.checkAndDeployProxy(internalProxyName, customizedZip, verbosef)
+	return p.checkAndDeployProxy(internalProxyName, digest, customizedZip, verbosef)
 }
 
 //check if the KVM exists, if it doesn't, create a new one and sets certs for JWT
@@ -118,9 +119,10 @@ func (p *provision) getOrCreateKVM(cred *keySecret, printf shared.FormatFn) erro
 		return err
 	}
 
+	name := p.resources.kvmName()
 	kvm := apigee.KVM{
-		Name:      kvmName,
-		Encrypted: encryptKVM,
+		Name:      name,
+		Encrypted: p.encryptKVM,
 		Entries: []apigee.Entry{
 			{
 				Name:  "private_key",
@@ -142,13 +144,22 @@ func (p *provision) getOrCreateKVM(cred *keySecret, printf shared.FormatFn) erro
 		return err
 	}
 	if resp.StatusCode == http.StatusConflict {
-		printf("kvm %s already exists", kvmName)
+		printf("kvm %s already exists", name)
+		// encryption can't be changed, the map must be deleted and created again
+		if existing, _, err := p.ApigeeClient.KVMService.Get(name); err == nil && existing.Encrypted != p.encryptKVM {
+			state := "unencrypted"
+			if existing.Encrypted {
+				state = "encrypted"
+			}
+			printf("warning: kvm %s is %s, contrary to --encrypt-kvm=%t, deprovision to create it again", name, state, p.encryptKVM)
+		}
 		return nil
 	}
 	if resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("creating kvm %s, status code: %v", kvmName, resp.StatusCode)
+		return fmt.Errorf("creating kvm %s, status code: %v", name, resp.StatusCode)
 	}
-	printf("kvm %s created", kvmName)
+	printf("kvm %s created", name)
+	p.onRollback("kvm "+name, p.deleteKVM)
 
 	printf("new private key:\n%s", string(keyBytes))
 	printf("new jwks:\n%s", string(jwksBytes))
@@ -167,7 +178,7 @@ func newHash() (string, error) {
 
 	t := time.Now()
 	h := sha256.New()
-	if _, err := h.Write([]byte(t.String() + string(rnd.Int()))); err != nil {
+	if _, err := h.Write([]byte(t.String() + strconv.Itoa(rnd.Int()))); err != nil {
 		return "", err
 	}
 	str := hex.EncodeToString(h.Sum(nil))
@@ -189,24 +200,29 @@ func (p *provision) createLegacyCredential(printf shared.FormatFn) (*keySecret,
 		Key:    key,
 		Secret: secret,
 	}
+	if err := p.registerLegacyCredential(cred); err != nil {
+		return nil, err
+	}
+	printf("credential created")
+	p.notifyCredential("", key)
+	return cred, nil
+}
 
+// registerLegacyCredential creates the credential cred through the internal proxy
+func (p *provision) registerLegacyCredential(cred *keySecret) error {
 	credentialURL := fmt.Sprintf(legacyCredentialURLFormat, p.InternalProxyURL, p.Org, p.Env)
 
 	req, err := p.ApigeeClient.NewRequest(http.MethodPost, credentialURL, cred)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	req.URL, err = url.Parse(credentialURL) // override client's munged URL
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	_, err = p.ApigeeClient.Do(req, nil)
-	if err != nil {
-		return nil, err
-	}
-	printf("credential created")
-	return cred, nil
+	return err
 }
 
 // verify POST internalProxyURL/analytics/organization/%s/environment/%s
@@ -231,7 +247,7 @@ func (p *provision) verifyInternalProxy(client *http.Client, printf shared.Forma
 		req.URL.RawQuery = q.Encode()
 	}
 	if err == nil {
-		res, err = client.Do(req)
+		res, err = tracedDo(client, req)
 		if res != nil {
 			defer res.Body.Close()
 		}
@@ -0,0 +1,473 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+)
+
+const (
+	targetMock = "mock"
+	mockToken  = "mock-token" // management API token if none is given
+
+	mockRuntimeVersion = "1.4.0"
+
+	// runtime requests failing after each deployment until it's served
+	mockPropagationRequests = 2
+
+	mgmtPathPrefix    = "/v1/organizations/"
+	runtimePathPrefix = "/remote-service/"
+)
+
+// mockTarget is an in-process hybrid organization for --target mock. It
+// serves the management API and the runtime of the remote-service proxy,
+// whatever their URLs, like the real ones in the ways that matter to
+// provision: creating an existing resource conflicts, deployments complete
+// asynchronously and the runtime serves a deployment with a delay.
+type mockTarget struct {
+	mu          sync.Mutex
+	resources   map[string][]byte         // JSON of the created resources by path, without /v1/organizations/
+	revisions   map[string][]*string      // descriptions of the imported revisions by proxy, nil once deleted
+	deployments map[string]map[string]int // deployed revisions by environment and proxy
+	propagating int                       // runtime requests failing until the last deployment is served
+}
+
+func newMockTarget() *mockTarget {
+	return &mockTarget{
+		resources:   map[string][]byte{},
+		revisions:   map[string][]*string{},
+		deployments: map[string]map[string]int{},
+	}
+}
+
+// RoundTrip serves req in process
+func (m *mockTarget) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, req)
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	res := rec.Result()
+	res.Request = req
+	return res, nil
+}
+
+func (m *mockTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i := strings.Index(r.URL.Path, mgmtPathPrefix); i >= 0 {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			mockError(w, http.StatusUnauthorized, "UNAUTHENTICATED", "request is missing a bearer token")
+			return
+		}
+		m.serveManagement(w, r, strings.Trim(r.URL.Path[i+len(mgmtPathPrefix):], "/"))
+		return
+	}
+	if i := strings.Index(r.URL.Path, runtimePathPrefix); i >= 0 {
+		m.serveRuntime(w, r, r.URL.Path[i+len(runtimePathPrefix):])
+		return
+	}
+	mockError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("no route for %s", r.URL.Path))
+}
+
+func (m *mockTarget) serveManagement(w http.ResponseWriter, r *http.Request, p string) {
+	segs := strings.Split(p, "/")
+	switch {
+	case len(segs) == 1: // organization
+		if r.Method != http.MethodGet {
+			mockError(w, http.StatusMethodNotAllowed, "INVALID_ARGUMENT", r.Method+" not allowed")
+			return
+		}
+		mockJSON(w, http.StatusOK, map[string]string{"name": segs[0], "runtimeType": "HYBRID"})
+	case segs[1] == "apis":
+		m.serveProxies(w, r, segs[2:])
+	case len(segs) == 4 && segs[1] == "environments" && segs[3] == "deployments" && r.Method == http.MethodGet:
+		m.serveEnvDeployments(w, segs[2])
+	case len(segs) >= 6 && segs[1] == "environments" && segs[3] == "apis":
+		m.serveDeployments(w, r, segs[2], segs[4], segs[5:])
+	default:
+		m.serveResource(w, r, p)
+	}
+}
+
+// serveProxies serves the import and the revisions of proxies
+func (m *mockTarget) serveProxies(w http.ResponseWriter, r *http.Request, segs []string) {
+	if len(segs) == 0 {
+		if r.Method != http.MethodPost || r.URL.Query().Get("action") != "import" {
+			mockError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "only import is supported")
+			return
+		}
+		name := r.URL.Query().Get("name")
+		description, err := importedDescription(r)
+		if err != nil {
+			mockError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+			return
+		}
+		m.revisions[name] = append(m.revisions[name], &description)
+		mockJSON(w, http.StatusOK, apigee.ProxyRevision{
+			Name:        name,
+			Revision:    apigee.Revision(len(m.revisions[name])),
+			Description: description,
+		})
+		return
+	}
+
+	name := segs[0]
+	revs, ok := m.revisions[name]
+	if !ok {
+		mockError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("api proxy %s does not exist", name))
+		return
+	}
+	switch {
+	case len(segs) == 1 && r.Method == http.MethodGet:
+		proxy := apigee.Proxy{Name: name}
+		for i := range revs {
+			if revs[i] != nil {
+				proxy.Revisions = append(proxy.Revisions, apigee.Revision(i+1))
+			}
+		}
+		mockJSON(w, http.StatusOK, proxy)
+	case len(segs) == 1 && r.Method == http.MethodDelete:
+		if env := m.deployedEnv(name, 0); env != "" {
+			mockError(w, http.StatusBadRequest, "FAILED_PRECONDITION", fmt.Sprintf("api proxy %s is deployed to %s", name, env))
+			return
+		}
+		delete(m.revisions, name)
+		mockJSON(w, http.StatusOK, apigee.DeletedProxyInfo{Name: name})
+	case len(segs) == 3 && segs[1] == "revisions":
+		rev, err := strconv.Atoi(segs[2])
+		if err != nil || rev < 1 || rev > len(revs) || revs[rev-1] == nil {
+			mockError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("revision %s of api proxy %s does not exist", segs[2], name))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+			mockJSON(w, http.StatusOK, apigee.ProxyRevision{Name: name, Revision: apigee.Revision(rev), Description: *revs[rev-1]})
+		case http.MethodDelete:
+			if env := m.deployedEnv(name, rev); env != "" {
+				mockError(w, http.StatusBadRequest, "FAILED_PRECONDITION", fmt.Sprintf("revision %d of api proxy %s is deployed to %s", rev, name, env))
+				return
+			}
+			// revisions keep their numbers
+			m.revisions[name][rev-1] = nil
+			mockJSON(w, http.StatusOK, apigee.ProxyRevision{Name: name, Revision: apigee.Revision(rev)})
+		default:
+			mockError(w, http.StatusMethodNotAllowed, "INVALID_ARGUMENT", r.Method+" not allowed")
+		}
+	default:
+		mockError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("no route for %s", r.URL.Path))
+	}
+}
+
+// serveDeployments deploys and undeploys revisions, deployments are reported
+// in progress and served by the runtime after a delay
+func (m *mockTarget) serveDeployments(w http.ResponseWriter, r *http.Request, env, name string, segs []string) {
+	if len(segs) == 1 && segs[0] == "deployments" && r.Method == http.MethodGet {
+		res := apigee.GCPDeployments{}
+		if rev, ok := m.deployments[env][name]; ok {
+			res.Deployments = append(res.Deployments, apigee.GCPDeployment{
+				Environment: env,
+				Name:        name,
+				Revision:    strconv.Itoa(rev),
+			})
+		}
+		mockJSON(w, http.StatusOK, res)
+		return
+	}
+	if len(segs) != 3 || segs[0] != "revisions" || segs[2] != "deployments" {
+		mockError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("no route for %s", r.URL.Path))
+		return
+	}
+	rev, err := strconv.Atoi(segs[1])
+	revs := m.revisions[name]
+	if err != nil || rev < 1 || rev > len(revs) || revs[rev-1] == nil {
+		mockError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("revision %s of api proxy %s does not exist", segs[1], name))
+		return
+	}
+	deployment := apigee.ProxyRevisionDeployment{Name: name, Revision: apigee.Revision(rev), Environment: env}
+
+	switch r.Method {
+	case http.MethodGet:
+		if current, deployed := m.deployments[env][name]; !deployed || current != rev {
+			mockError(w, http.StatusNotFound, "NOT_FOUND",
+				fmt.Sprintf("revision %d of api proxy %s is not deployed to %s", rev, name, env))
+			return
+		}
+		deployment.State = "READY"
+		if m.propagating > 0 {
+			m.propagating--
+			deployment.State = "PROGRESSING"
+		}
+		mockJSON(w, http.StatusOK, deployment)
+	case http.MethodPost:
+		current, deployed := m.deployments[env][name]
+		if deployed && current != rev && r.URL.Query().Get("override") != "true" {
+			mockError(w, http.StatusBadRequest, "FAILED_PRECONDITION",
+				fmt.Sprintf("revision %d of api proxy %s is deployed to %s, override to replace it", current, name, env))
+			return
+		}
+		if m.deployments[env] == nil {
+			m.deployments[env] = map[string]int{}
+		}
+		m.deployments[env][name] = rev
+		m.propagating = mockPropagationRequests
+		deployment.State = "PROGRESSING"
+		mockJSON(w, http.StatusOK, deployment)
+	case http.MethodDelete:
+		if current, deployed := m.deployments[env][name]; !deployed || current != rev {
+			mockError(w, http.StatusBadRequest, "FAILED_PRECONDITION",
+				fmt.Sprintf("revision %d of api proxy %s is not deployed to %s", rev, name, env))
+			return
+		}
+		delete(m.deployments[env], name)
+		mockJSON(w, http.StatusOK, deployment)
+	default:
+		mockError(w, http.StatusMethodNotAllowed, "INVALID_ARGUMENT", r.Method+" not allowed")
+	}
+}
+
+// serveEnvDeployments serves the revisions deployed to an environment
+func (m *mockTarget) serveEnvDeployments(w http.ResponseWriter, env string) {
+	var deployments apigee.GCPDeployments
+	for name, rev := range m.deployments[env] {
+		deployments.Deployments = append(deployments.Deployments, apigee.GCPDeployment{
+			Environment: env,
+			Name:        name,
+			Revision:    strconv.Itoa(rev),
+		})
+	}
+	mockJSON(w, http.StatusOK, deployments)
+}
+
+// deployedEnv returns an environment a revision of the proxy is deployed to,
+// any revision if rev is 0
+func (m *mockTarget) deployedEnv(name string, rev int) string {
+	for env, proxies := range m.deployments {
+		if current, ok := proxies[name]; ok && (rev == 0 || current == rev) {
+			return env
+		}
+	}
+	return ""
+}
+
+// serveResource serves the other resources, created by POST to the
+// collection and named by their name or email
+func (m *mockTarget) serveResource(w http.ResponseWriter, r *http.Request, p string) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = ioutil.ReadAll(r.Body); err != nil {
+			mockError(w, http.StatusBadRequest, "INVALID_ARGUMENT", err.Error())
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		data, ok := m.resources[p]
+		if !ok {
+			mockError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("%s does not exist", p))
+			return
+		}
+		mockRaw(w, http.StatusOK, data)
+	case http.MethodPut:
+		if _, ok := m.resources[p]; !ok {
+			mockError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("%s does not exist", p))
+			return
+		}
+		m.resources[p] = body
+		mockRaw(w, http.StatusOK, body)
+	case http.MethodDelete:
+		data, ok := m.resources[p]
+		if !ok {
+			mockError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("%s does not exist", p))
+			return
+		}
+		for key := range m.resources {
+			if key == p || strings.HasPrefix(key, p+"/") {
+				delete(m.resources, key)
+			}
+		}
+		mockRaw(w, http.StatusOK, data)
+	case http.MethodPost:
+		m.createResource(w, r, p, body)
+	default:
+		mockError(w, http.StatusMethodNotAllowed, "INVALID_ARGUMENT", r.Method+" not allowed")
+	}
+}
+
+func (m *mockTarget) createResource(w http.ResponseWriter, r *http.Request, collection string, body []byte) {
+	if !m.exists(path.Dir(collection)) {
+		mockError(w, http.StatusNotFound, "NOT_FOUND", fmt.Sprintf("%s does not exist", path.Dir(collection)))
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if fileType := r.URL.Query().Get("type"); fileType != "" { // resource files
+		name = fileType + "/" + name
+	}
+	var resource map[string]interface{}
+	if name == "" {
+		if err := json.Unmarshal(body, &resource); err != nil {
+			mockError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "body is not a JSON object")
+			return
+		}
+		name, _ = resource["name"].(string)
+		if email, ok := resource["email"].(string); ok && name == "" {
+			name = email
+		}
+	}
+	if name == "" {
+		mockError(w, http.StatusBadRequest, "INVALID_ARGUMENT", "resource has no name")
+		return
+	}
+	p := collection + "/" + name
+	if _, ok := m.resources[p]; ok {
+		mockError(w, http.StatusConflict, "ALREADY_EXISTS", fmt.Sprintf("%s already exists", p))
+		return
+	}
+
+	if path.Base(collection) == "apps" && resource != nil {
+		key, secret := mockSecret(), mockSecret()
+		resource["credentials"] = []apigee.AppCredential{{ConsumerKey: key, ConsumerSecret: secret, Status: "approved"}}
+		var err error
+		if body, err = json.Marshal(resource); err != nil {
+			mockError(w, http.StatusInternalServerError, "INTERNAL", err.Error())
+			return
+		}
+	}
+	m.resources[p] = body
+	mockRaw(w, http.StatusCreated, body)
+}
+
+// exists reports whether the resource at p exists, organizations and
+// environments always do
+func (m *mockTarget) exists(p string) bool {
+	segs := strings.Split(p, "/")
+	if len(segs) == 1 || len(segs) == 3 && segs[1] == "environments" {
+		return true
+	}
+	_, ok := m.resources[p]
+	return ok
+}
+
+// serveRuntime serves the remote-service proxy once deployed, failing the
+// first requests after each deployment
+func (m *mockTarget) serveRuntime(w http.ResponseWriter, r *http.Request, endpoint string) {
+	if m.deployedEnv(authProxyName, 0) == "" {
+		mockRaw(w, http.StatusNotFound, []byte(`{"fault":{"faultstring":"Unable to identify proxy for host and url"}}`))
+		return
+	}
+	if m.propagating > 0 {
+		m.propagating--
+		mockRaw(w, http.StatusServiceUnavailable, []byte(`{"fault":{"faultstring":"The Service is temporarily unavailable"}}`))
+		return
+	}
+	if endpoint != "certs" && r.Header.Get("Authorization") == "" {
+		mockRaw(w, http.StatusUnauthorized, []byte(`{"fault":{"faultstring":"missing authorization"}}`))
+		return
+	}
+
+	switch endpoint {
+	case "certs":
+		mockRaw(w, http.StatusOK, []byte(`{"keys":[]}`))
+	case "products":
+		mockRaw(w, http.StatusOK, []byte(`{"apiProducts":[]}`))
+	case "verifyApiKey":
+		mockRaw(w, http.StatusUnauthorized, []byte(`{"fault":{"faultstring":"Invalid ApiKey"}}`))
+	case "quotas":
+		mockRaw(w, http.StatusOK, []byte(`{}`))
+	case "version":
+		mockJSON(w, http.StatusOK, map[string]string{"platform": mockRuntimeVersion})
+	default:
+		mockRaw(w, http.StatusNotFound, []byte(`{"fault":{"faultstring":"no such endpoint"}}`))
+	}
+}
+
+// importedDescription returns the description of the bundle uploaded to import a proxy
+func importedDescription(r *http.Request) (string, error) {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return "", fmt.Errorf("no bundle: %v", err)
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return "", err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("bundle is not a zip: %v", err)
+	}
+	for _, f := range zr.File {
+		if path.Dir(f.Name) != "apiproxy" || path.Ext(f.Name) != ".xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		xml, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+		description := descriptionRE.Find(xml)
+		return strings.TrimSuffix(strings.TrimPrefix(string(description), "<Description>"), "</Description>"), nil
+	}
+	return "", fmt.Errorf("bundle has no apiproxy descriptor")
+}
+
+func mockSecret() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func mockJSON(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		status, data = http.StatusInternalServerError, []byte(err.Error())
+	}
+	mockRaw(w, status, data)
+}
+
+func mockRaw(w http.ResponseWriter, status int, data []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
+
+// mockError writes an error as the Google APIs do
+func mockError(w http.ResponseWriter, code int, status, message string) {
+	mockJSON(w, code, map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message, "status": status},
+	})
+}
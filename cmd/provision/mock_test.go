@@ -0,0 +1,152 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestProvisionMockTarget(t *testing.T) {
+	duration = 60
+	interval = 100
+	defer func() {
+		duration = 1
+		interval = 500
+	}()
+
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestProvisionMockTarget")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"provision", "--target", "mock", "-o", "org", "-r", "https://runtime.example.com"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+		return print, rootCmd.Execute()
+	}
+
+	// the second environment conflicts on the shared developer, app and product
+	print, err := run("-e", "test,prod", "--storage", "kvm")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	output := strings.Join(print.Prints, "\n")
+	for _, want := range []string{"https://runtime.example.com/remote-service", "name: apigee-remote-service-envoy-test", "name: apigee-remote-service-envoy-prod"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("want %q in output", want)
+		}
+	}
+	if strings.Contains(output, "WARNING") {
+		t.Errorf("want verified config, got %s", output)
+	}
+
+	print, err = run("-e", "test", "--emit-crd")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "emit-crd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(file, []byte(strings.Join(print.Prints, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	result, err := shared.ReadProvisionResult(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Verified || len(result.Proxies) == 0 || result.Secret != "org-test-policy-secret" {
+		t.Errorf("want verified result with proxies and secret from %s, got %#v", shared.RemoteServiceKind, result)
+	}
+
+	defer func(d time.Duration) { deployPollInterval = d }(deployPollInterval)
+	deployPollInterval = time.Millisecond
+	if _, err = run("-e", "test", "--wait", "--timeout", "1m"); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	_, err = run("-e", "test", "--timeout", "1m", "--dry-run")
+	testutil.ErrorContains(t, err, "--timeout only valid with --wait")
+
+	_, err = run("-e", "test", "--legacy")
+	testutil.ErrorContains(t, err, "--target only valid for hybrid or Apigee X")
+	_, err = run("-e", "test", "--target", "staging")
+	testutil.ErrorContains(t, err, "--target must be mock")
+}
+
+func TestMockTarget(t *testing.T) {
+	m := newMockTarget()
+	client := &http.Client{Transport: m}
+	send := func(method, url, body string) int {
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+mockToken)
+		res, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	developers := "https://mgmt/v1/organizations/org/developers"
+	for _, want := range []int{http.StatusCreated, http.StatusConflict} {
+		if got := send(http.MethodPost, developers, `{"email":"dev@example.com"}`); got != want {
+			t.Errorf("want %d creating developer, got %d", want, got)
+		}
+	}
+	if got := send(http.MethodPost, developers+"/missing@example.com/apps", `{"name":"app"}`); got != http.StatusNotFound {
+		t.Errorf("want 404 creating app of missing developer, got %d", got)
+	}
+	if got := send(http.MethodDelete, developers+"/dev@example.com", ""); got != http.StatusOK {
+		t.Errorf("want 200 deleting developer, got %d", got)
+	}
+	if got := send(http.MethodGet, developers+"/dev@example.com", ""); got != http.StatusNotFound {
+		t.Errorf("want 404 getting deleted developer, got %d", got)
+	}
+
+	// the runtime serves a deployment after a delay
+	runtime := "https://runtime/remote-service/certs"
+	if got := send(http.MethodGet, runtime, ""); got != http.StatusNotFound {
+		t.Errorf("want 404 before deployment, got %d", got)
+	}
+	m.revisions[authProxyName] = []*string{new(string)}
+	deploy := "https://mgmt/v1/organizations/org/environments/test/apis/remote-service/revisions/1/deployments"
+	if got := send(http.MethodPost, deploy, ""); got != http.StatusOK {
+		t.Errorf("want 200 deploying, got %d", got)
+	}
+	for i := 0; i < mockPropagationRequests; i++ {
+		if got := send(http.MethodGet, runtime, ""); got != http.StatusServiceUnavailable {
+			t.Errorf("want 503 while propagating, got %d", got)
+		}
+	}
+	if got := send(http.MethodGet, runtime, ""); got != http.StatusOK {
+		t.Errorf("want 200 once deployed, got %d", got)
+	}
+	if got := send(http.MethodDelete, "https://mgmt/v1/organizations/org/apis/remote-service", ""); got != http.StatusBadRequest {
+		t.Errorf("want 400 deleting deployed proxy, got %d", got)
+	}
+}
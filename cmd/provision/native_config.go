@@ -0,0 +1,147 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/pkg/errors"
+)
+
+const (
+	configOutKubernetes = "kubernetes"
+	configOutNative     = "native"
+)
+
+func (p *provision) validateConfigOut() error {
+	if p.configOut != configOutKubernetes && p.configOut != configOutNative {
+		return fmt.Errorf("--config-out must be %s or %s", configOutKubernetes, configOutNative)
+	}
+	if p.configOut != configOutNative {
+		if p.policySecretDir != "" {
+			return fmt.Errorf("--policy-secret-dir only valid with --config-out %s", configOutNative)
+		}
+		return nil
+	}
+	if p.output == outputJSON || p.emitCRD || p.k8sVersion != "" {
+		return fmt.Errorf("--config-out %s can't be combined with --output %s, --emit-crd or --k8s-version", configOutNative, outputJSON)
+	}
+	if len(p.envs) > 1 {
+		return fmt.Errorf("--config-out %s supports a single environment", configOutNative)
+	}
+	if p.IsGCPManaged && p.policySecretDir == "" && p.nativeOut.dir == "" {
+		return fmt.Errorf("--config-out %s requires --policy-secret-dir or --native-out for hybrid and Apigee X", configOutNative)
+	}
+	if !p.IsGCPManaged && p.policySecretDir != "" {
+		return fmt.Errorf("--policy-secret-dir only valid for hybrid or Apigee X")
+	}
+	return nil
+}
+
+// printNativeConfig prints the plain config.yaml of an adapter running
+// outside Kubernetes, the policy secret of hybrid and Apigee X is written
+// to files as the adapter reads it from its --policy-secret directory.
+// With --native-out, all are written to files and possibly delivered.
+// Nothing is saved to the workspace, it holds Kubernetes resources.
+func (p *provision) printNativeConfig(config *server.Config, configYAML, platform string, printf shared.FormatFn, verifyErrors error) error {
+	secretDir := p.policySecretDir
+	if p.nativeOut.dir != "" {
+		secretDir = filepath.Join(p.nativeOut.dir, nativeSecretDir)
+	}
+	var files []string
+	if p.IsGCPManaged {
+		secret, err := p.policySecretCRD(config)
+		if err != nil {
+			return err
+		}
+		if files, err = writePolicySecret(secretDir, secret); err != nil {
+			return err
+		}
+	}
+	var written []string
+	if p.nativeOut.dir != "" {
+		var err error
+		if written, err = p.writeNativeOut(configYAML, files); err != nil {
+			return err
+		}
+		if p.nativeOut.target != nil {
+			if err := p.deliverNativeOut(written); err != nil {
+				return err
+			}
+		}
+	}
+
+	printf("# Configuration for apigee-remote-service-envoy (platform: %s)", platform)
+	printf("# generated by apigee-remote-service-cli provision on %s", time.Now().Format("2006-01-02 15:04:05"))
+	if p.apigeeX {
+		printf("# for an Apigee X adapter running outside the cluster, analytics are uploaded through the management API")
+	}
+	if t := p.nativeOut.target; t != nil {
+		printf("# delivered to %s:%s: %s", t.host, t.dir, written)
+	} else if len(written) > 0 {
+		printf("# written to %s: %s", p.nativeOut.dir, written)
+	}
+	if p.nativeOut.trigger != "" {
+		printf("# reload triggered by touching %s", p.nativeOut.trigger)
+	}
+	if p.nativeOut.dir != "" && len(files) > 0 {
+		printf("# run: apigee-remote-service-envoy -c %s -p %s", nativeConfigFile, nativeSecretDir)
+	} else if len(files) > 0 {
+		printf("# policy secret written to %s: %s", secretDir, files)
+		printf("# run: apigee-remote-service-envoy -c config.yaml -p %s", secretDir)
+	} else {
+		printf("# run: apigee-remote-service-envoy -c config.yaml")
+	}
+	if config.Analytics.FluentdEndpoint != "" {
+		printf("# analytics are sent to %s, it must be reachable from the adapter", config.Analytics.FluentdEndpoint)
+	}
+	if verifyErrors != nil {
+		printf("# WARNING: verification of provision failed. May not be valid.")
+	}
+	p.printStepFailures(printf)
+	printf("%s", configYAML)
+	return nil
+}
+
+// writePolicySecret writes the data of secret to files of dir, returning
+// their names
+func writePolicySecret(dir string, secret *server.SecretCRD) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "creating %s", dir)
+	}
+	var names []string
+	for name := range secret.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		data, err := base64.StdEncoding.DecodeString(secret.Data[name])
+		if err != nil {
+			return nil, errors.Wrapf(err, "decoding %s", name)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			return nil, errors.Wrapf(err, "writing %s", name)
+		}
+	}
+	return names, nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+)
+
+func TestProvisionNativeConfig(t *testing.T) {
+	ts := httptest.NewServer(handler(t))
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	dir, err := ioutil.TempDir("", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	secretDir := filepath.Join(dir, "policy-secret")
+
+	print := testutil.Printer("TestProvisionNativeConfig")
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-t", "token",
+		"--config-out", "native", "--policy-secret-dir", secretDir}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	configYAML := print.Prints[len(print.Prints)-1]
+	print.CheckPrefix(t, []string{
+		"# Configuration for apigee-remote-service-envoy (platform: GCP)",
+		"# generated by apigee-remote-service-cli provision on",
+		"# policy secret written to " + secretDir + ": [remote-service.crt remote-service.key remote-service.properties]",
+		"# run: apigee-remote-service-envoy -c config.yaml -p " + secretDir,
+		"# analytics are sent to apigee-udca-gcp-test-",
+		"tenant:\n",
+	})
+
+	// loads as the adapter does
+	configFile := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(configFile, []byte(configYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config := server.DefaultConfig()
+	if err := config.Load(configFile, secretDir); err != nil {
+		t.Fatalf("want config and policy secret loaded, got %v", err)
+	}
+	if config.Tenant.Key != "gcpkey" || config.Tenant.PrivateKey == nil || config.Tenant.PrivateKeyID == "" {
+		t.Errorf("want credential and policy key loaded, got key %q, kid %q", config.Tenant.Key, config.Tenant.PrivateKeyID)
+	}
+
+	for _, tc := range []struct {
+		flags   []string
+		wantErr string
+	}{
+		{[]string{"-o", "gcp", "-e", "test", "-r", ts.URL, "-t", "token", "--config-out", "native"},
+			"--config-out native requires --policy-secret-dir or --native-out for hybrid and Apigee X"},
+		{[]string{"-o", "gcp", "-e", "test", "-r", ts.URL, "-t", "token", "--config-out", "native", "--policy-secret-dir", secretDir, "--emit-crd"},
+			"--config-out native can't be combined with --output json, --emit-crd or --k8s-version"},
+		{[]string{"-o", "gcp", "-e", "test", "-r", ts.URL, "-t", "token", "--policy-secret-dir", secretDir},
+			"--policy-secret-dir only valid with --config-out native"},
+		{[]string{"-o", "gcp", "-e", "test", "-r", ts.URL, "-t", "token", "--config-out", "helm"},
+			"--config-out must be kubernetes or native"},
+	} {
+		rootArgs := &shared.RootArgs{}
+		rootCmd := cmd.GetRootCmd(append([]string{"provision"}, tc.flags...), print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		testutil.ErrorContains(t, rootCmd.Execute(), tc.wantErr)
+	}
+}
+
+func TestProvisionNativeOut(t *testing.T) {
+	ts := httptest.NewServer(handler(t))
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	dir, err := ioutil.TempDir("", "native")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	readFile := func(file string) string {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	// fake ssh and scp recording their arguments
+	log := filepath.Join(dir, "log")
+	sshCommand, scpCommand = filepath.Join(dir, "ssh"), filepath.Join(dir, "scp")
+	defer func() { sshCommand, scpCommand = "ssh", "scp" }()
+	for _, command := range []string{sshCommand, scpCommand} {
+		script := "#!/bin/sh\necho " + filepath.Base(command) + ` "$@" >> ` + log + "\n"
+		if err := ioutil.WriteFile(command, []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	print := testutil.Printer("TestProvisionNativeOut")
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-t", "token",
+		"--config-out", "native", "--native-out", out, "--deliver", "ssh://admin@vm:2222/etc/apigee",
+		"--reload-trigger", "reload"}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	files := "[config.yaml policy-secret/remote-service.crt policy-secret/remote-service.key policy-secret/remote-service.properties SHA256SUMS]"
+	print.CheckPrefix(t, []string{
+		"# Configuration for apigee-remote-service-envoy (platform: GCP)",
+		"# generated by apigee-remote-service-cli provision on",
+		"# delivered to admin@vm:/etc/apigee: " + files,
+		"# reload triggered by touching reload",
+		"# run: apigee-remote-service-envoy -c config.yaml -p policy-secret",
+		"# analytics are sent to apigee-udca-gcp-test-",
+		"tenant:\n",
+	})
+
+	config := server.DefaultConfig()
+	if err := config.Load(filepath.Join(out, "config.yaml"), filepath.Join(out, "policy-secret")); err != nil {
+		t.Fatalf("want config and policy secret loaded, got %v", err)
+	}
+	sums := readFile(filepath.Join(out, "SHA256SUMS"))
+	if lines := strings.Split(strings.TrimSpace(sums), "\n"); len(lines) != 4 || !strings.HasSuffix(lines[0], "  config.yaml") {
+		t.Errorf("want a checksum of each file, got:\n%s", sums)
+	}
+
+	want := "ssh -o BatchMode=yes -p 2222 -- admin@vm 'mkdir' '-p' '/etc/apigee/policy-secret'\n" +
+		"scp -o BatchMode=yes -p -q -P 2222 -- " + filepath.Join(out, "config.yaml") + " admin@vm:/etc/apigee/config.yaml\n"
+	if got := readFile(log); !strings.HasPrefix(got, want) ||
+		!strings.HasSuffix(got, " admin@vm:/etc/apigee/SHA256SUMS\nssh -o BatchMode=yes -p 2222 -- admin@vm 'touch' '/etc/apigee/reload'\n") {
+		t.Errorf("want files copied and reload triggered, got:\n%s", got)
+	}
+
+	// written locally, trigger touched in --native-out
+	print = testutil.Printer("TestProvisionNativeOut")
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"provision", "--legacy", "-o", "legacyorg", "-e", "test", "-u", "user", "-p", "pass",
+		"--config-out", "native", "--native-out", out, "--reload-trigger", "reload"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(out, "reload")); err != nil {
+		t.Errorf("want --reload-trigger touched: %v", err)
+	}
+
+	for _, tc := range []struct {
+		flags   []string
+		wantErr string
+	}{
+		{[]string{"--config-out", "native", "--native-out", out, "--deliver", "scp://vm/etc"},
+			`--deliver must be ssh://[user@]host[:port]/path, got "scp://vm/etc"`},
+		{[]string{"--config-out", "native", "--native-out", out, "--deliver", "ssh://vm/etc/a b"},
+			`--deliver path "/etc/a b" must consist of letters, digits and ._/~+-`},
+		{[]string{"--config-out", "native", "--native-out", out, "--deliver", "ssh://-oProxyCommand=x/etc"},
+			`--deliver host "-oProxyCommand=x" must be a hostname or IPv4 address`},
+		{[]string{"--config-out", "native", "--native-out", out, "--deliver", "ssh://-oProxyCommand=x@vm/etc"},
+			`--deliver user "-oProxyCommand=x" must consist of letters, digits and ._- and not start with . or -`},
+		{[]string{"--config-out", "native", "--native-out", out, "--policy-secret-dir", dir},
+			"--native-out can't be combined with --policy-secret-dir"},
+		{[]string{"--native-out", out},
+			"--native-out only valid with --config-out native"},
+		{[]string{"--config-out", "native", "--policy-secret-dir", dir, "--deliver", "ssh://vm/etc"},
+			"--deliver and --reload-trigger only valid with --native-out"},
+	} {
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-t", "token"}, tc.flags...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		testutil.ErrorContains(t, rootCmd.Execute(), tc.wantErr)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+)
+
+// notifyCredential notifies the hooks of the key created for app (none on
+// legacy and opdk), nothing is created on a dry run
+func (p *provision) notifyCredential(app, key string) {
+	if p.dryRun {
+		return
+	}
+	details := map[string]string{"command": "provision", "key": key}
+	if app != "" {
+		details["app"] = app
+	}
+	p.Notify(shared.EventCredentialCreated, details, shared.Errorf)
+}
+
+// notifyCertRotated notifies the hooks of the JWKS rotated by --rotate
+func (p *provision) notifyCertRotated(keyID string) {
+	p.Notify(shared.EventCertRotated, map[string]string{"command": "provision", "kid": keyID}, shared.Errorf)
+}
+
+// notifyFailure notifies the hooks of a failed provisioning
+func (p *provision) notifyFailure(err error) {
+	if err == nil || p.dryRun {
+		return
+	}
+	details := map[string]string{"command": "provision", "error": err.Error()}
+	if code := shared.ErrorCodeOf(err); code != "" {
+		details["code"] = string(code)
+	}
+	p.Notify(shared.EventProvisionFailed, details, shared.Errorf)
+}
+
+// firstKey returns the consumer key of the first credential, if any
+func firstKey(creds []apigee.AppCredential) string {
+	for _, c := range creds {
+		if c.ConsumerKey != "" {
+			return c.ConsumerKey
+		}
+	}
+	return ""
+}
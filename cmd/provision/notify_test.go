@@ -0,0 +1,95 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestProvisionNotify(t *testing.T) {
+	ts := httptest.NewServer(handler(t))
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	var got []shared.Notification
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n shared.Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Error(err)
+		}
+		got = append(got, n)
+	}))
+	defer hook.Close()
+
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	notifyConfig := filepath.Join(dir, "notify.yaml")
+	if err := ioutil.WriteFile(notifyConfig, []byte("webhooks:\n- "+hook.URL+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(flags ...string) error {
+		print := testutil.Printer("TestProvisionNotify")
+		rootArgs := &shared.RootArgs{}
+		rootCmd := cmd.GetRootCmd(append(flags, "--notify-config", notifyConfig), print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return rootCmd.Execute()
+	}
+	events := func() []string {
+		var events []string
+		for _, n := range got {
+			events = append(events, n.Event)
+		}
+		got = nil
+		return events
+	}
+
+	if err := run("provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-n", "ns", "-t", "token", "--rotate", "1"); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if want, got := []string{shared.EventCredentialCreated, shared.EventCertRotated}, events(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want events %v, got %v", want, got)
+	}
+
+	if err := run("provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-n", "ns", "-t", "token", "--use-appgroup"); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if want, got := []string{shared.EventCredentialCreated}, events(); !reflect.DeepEqual(want, got) {
+		t.Errorf("want events %v, got %v", want, got)
+	}
+
+	err = run("provision", "-o", "nocred", "-e", "test", "-u", "me", "-p", "password", "-r", ts.URL, "-n", "ns", "-m", ts.URL, "--opdk")
+	testutil.ErrorContains(t, err, "generating credential")
+	if len(got) != 1 || got[0].Event != shared.EventProvisionFailed || got[0].Organization != "nocred" ||
+		got[0].Details["code"] != string(shared.CodeForbidden) {
+		t.Errorf("want provision.failed of nocred, got %+v", got)
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+)
+
+// resourceManagerBase is the Cloud Resource Manager API testing the
+// permissions of the caller on the project of the organization
+var resourceManagerBase = "https://cloudresourcemanager.googleapis.com"
+
+const orgAdminRole = "Apigee Organization Admin (roles/apigee.admin)"
+
+// stepPermissions are the permissions a step of provision needs
+type stepPermissions struct {
+	step        string
+	permissions []string
+}
+
+// requiredPermissions returns the permissions provisioning hybrid or
+// Apigee X needs with the flags given, by step
+func (p *provision) requiredPermissions() []stepPermissions {
+	steps := []stepPermissions{
+		{"reading the organization", []string{"apigee.organizations.get", "apigee.environments.get"}},
+	}
+	if p.RuntimeBase == "" || p.apigeeX {
+		steps = append(steps, stepPermissions{"resolving the runtime", []string{"apigee.envgroups.list", "apigee.envgroupattachments.list"}})
+	}
+	if !p.skip.proxy {
+		steps = append(steps, stepPermissions{"deploying the proxy", []string{"apigee.proxies.create", "apigee.proxies.get",
+			"apigee.proxyrevisions.get", "apigee.proxyrevisions.deploy", "apigee.deployments.list"}})
+		switch p.storage {
+		case storageKVM:
+			steps = append(steps, stepPermissions{"storing the keys in a kvm", []string{"apigee.keyvaluemaps.create", "apigee.keyvaluemapentries.create"}})
+		case storagePropertySet:
+			steps = append(steps, stepPermissions{"storing the keys in a property set", []string{"apigee.resourcefiles.create", "apigee.resourcefiles.update"}})
+		}
+	}
+	if !p.skip.product {
+		steps = append(steps, stepPermissions{"creating the API product", []string{"apigee.apiproducts.create", "apigee.apiproducts.get"}})
+	}
+	if !p.skip.credential && p.useAppGroup {
+		steps = append(steps, stepPermissions{"creating the credential", []string{"apigee.appgroups.create", "apigee.appgroups.get", "apigee.appgroups.update"}})
+	} else if !p.skip.credential {
+		steps = append(steps, stepPermissions{"creating the credential", []string{"apigee.developers.create", "apigee.developerapps.create",
+			"apigee.developerapps.get", "apigee.developerappkeys.create"}})
+	}
+	return steps
+}
+
+// checkOrgAdmin verifies, before changing anything, that the organization
+// is provisioned and attached to a project and that the caller holds the
+// permissions provision needs on it, naming the missing ones
+func (p *provision) checkOrgAdmin(verbosef shared.FormatFn) error {
+	req, err := p.ApigeeClient.NewRequestNoEnv(http.MethodGet, "", nil)
+	if err != nil {
+		return err
+	}
+	org := struct {
+		ProjectID string `json:"projectId"`
+		State     string `json:"state"`
+	}{}
+	if resp, err := p.ApigeeClient.Do(req, &org); err != nil {
+		status := 0
+		if resp != nil {
+			status = resp.StatusCode
+		}
+		switch status {
+		case http.StatusForbidden:
+			return shared.WithCode(shared.CodeForbidden, fmt.Errorf("--org-admin-check: the caller may not read organization %s, "+
+				"missing permission apigee.organizations.get: grant %s or a custom role with it", p.Org, orgAdminRole))
+		case http.StatusNotFound:
+			return shared.WithCode(shared.CodeNotFound, fmt.Errorf("--org-admin-check: organization %s not found, "+
+				"provision Apigee in its Google Cloud project first (the organization is named after the project)", p.Org))
+		}
+		return errors.Wrapf(err, "--org-admin-check: retrieving organization %s", p.Org)
+	}
+	if org.ProjectID == "" {
+		return fmt.Errorf("--org-admin-check: organization %s is not attached to a Google Cloud project", p.Org)
+	}
+	if org.State != "" && org.State != "ACTIVE" {
+		return fmt.Errorf("--org-admin-check: organization %s of project %s is %s, wait for its provisioning to complete",
+			p.Org, org.ProjectID, org.State)
+	}
+
+	steps := p.requiredPermissions()
+	var wanted []string
+	for _, s := range steps {
+		wanted = append(wanted, s.permissions...)
+	}
+	granted, err := p.testIamPermissions(req.Header.Get("Authorization"), org.ProjectID, wanted)
+	if err != nil {
+		return errors.Wrapf(err, "--org-admin-check: testing permissions on project %s", org.ProjectID)
+	}
+
+	var missing []string
+	for _, s := range steps {
+		var lacking []string
+		for _, perm := range s.permissions {
+			if !granted[perm] {
+				lacking = append(lacking, perm)
+			}
+		}
+		if len(lacking) > 0 {
+			missing = append(missing, fmt.Sprintf("%s (%s)", strings.Join(lacking, ", "), s.step))
+		}
+	}
+	if len(missing) > 0 {
+		return shared.WithCode(shared.CodeForbidden, fmt.Errorf("--org-admin-check: the caller lacks permissions on project %s: %s; "+
+			"grant %s or a custom role with them", org.ProjectID, strings.Join(missing, "; "), orgAdminRole))
+	}
+	verbosef("organization %s of project %s is active, the caller holds the %d permissions needed", p.Org, org.ProjectID, len(wanted))
+	return nil
+}
+
+// testIamPermissions returns the permissions the caller authorized by
+// authorization holds on the project
+func (p *provision) testIamPermissions(authorization, project string, permissions []string) (map[string]bool, error) {
+	body, err := json.Marshal(map[string][]string{"permissions": permissions})
+	if err != nil {
+		return nil, err
+	}
+	// sent aside of the management API client, it's not a change of --dry-run
+	req, err := http.NewRequest(http.MethodPost,
+		fmt.Sprintf("%s/v1/projects/%s:testIamPermissions", resourceManagerBase, url.PathEscape(project)), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", authorization)
+	client := &http.Client{Transport: p.Transport(p.InsecureSkipVerify)}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var result struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	granted := map[string]bool{}
+	for _, perm := range result.Permissions {
+		granted[perm] = true
+	}
+	return granted, nil
+}
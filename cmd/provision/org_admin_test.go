@@ -0,0 +1,112 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestProvisionOrgAdminCheck(t *testing.T) {
+	org := `{"projectId": "my-project", "state": "ACTIVE"}`
+	orgStatus := http.StatusOK
+	var denied map[string]bool
+	provisioned := handler(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/organizations/gcp":
+			w.WriteHeader(orgStatus)
+			_, _ = w.Write([]byte(org))
+		case "/v1/projects/my-project:testIamPermissions":
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var req struct {
+				Permissions []string `json:"permissions"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatal(err)
+			}
+			var held []string
+			for _, perm := range req.Permissions {
+				if !denied[perm] {
+					held = append(held, perm)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string][]string{"permissions": held})
+		default:
+			provisioned.ServeHTTP(w, r)
+		}
+	}))
+	defer ts.Close()
+	resourceManagerBase = ts.URL
+	defer func() { resourceManagerBase = "https://cloudresourcemanager.googleapis.com" }()
+
+	duration = 1
+	interval = 500
+
+	run := func(flags ...string) error {
+		print := testutil.Printer("TestProvisionOrgAdminCheck")
+		rootArgs := &shared.RootArgs{}
+		flags = append([]string{"provision", "-e", "test", "-r", ts.URL, "-t", "token", "--org-admin-check"}, flags...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return rootCmd.Execute()
+	}
+
+	if err := run("-o", "gcp"); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+
+	denied = map[string]bool{"apigee.proxyrevisions.deploy": true, "apigee.developerapps.create": true, "apigee.developers.create": true, "apigee.apiproducts.create": true}
+	err := run("-o", "gcp")
+	testutil.ErrorContains(t, err, "--org-admin-check: the caller lacks permissions on project my-project: "+
+		"apigee.proxyrevisions.deploy (deploying the proxy); apigee.apiproducts.create (creating the API product); apigee.developers.create, apigee.developerapps.create (creating the credential); "+
+		"grant Apigee Organization Admin (roles/apigee.admin) or a custom role with them")
+	if code := shared.ErrorCodeOf(err); code != shared.CodeForbidden {
+		t.Errorf("want %s, got %q", shared.CodeForbidden, code)
+	}
+
+	// permissions of skipped steps and of developers with --use-appgroup aren't needed
+	if err := run("-o", "gcp", "--skip-proxy", "--skip-product", "--use-appgroup"); err != nil {
+		t.Errorf("want no error: %v", err)
+	}
+	denied = nil
+
+	org = `{"projectId": "my-project", "state": "CREATING"}`
+	testutil.ErrorContains(t, run("-o", "gcp"), "organization gcp of project my-project is CREATING, wait for its provisioning to complete")
+
+	org = `{"state": "ACTIVE"}`
+	testutil.ErrorContains(t, run("-o", "gcp"), "organization gcp is not attached to a Google Cloud project")
+
+	org, orgStatus = `{"error": {"code": 403}}`, http.StatusForbidden
+	testutil.ErrorContains(t, run("-o", "gcp"), "the caller may not read organization gcp, missing permission apigee.organizations.get")
+
+	org, orgStatus = `{"error": {"code": 404}}`, http.StatusNotFound
+	testutil.ErrorContains(t, run("-o", "gcp"), "organization gcp not found, provision Apigee in its Google Cloud project first")
+
+	print := testutil.Printer("TestProvisionOrgAdminCheck")
+	rootArgs := &shared.RootArgs{}
+	rootCmd := cmd.GetRootCmd([]string{"provision", "--legacy", "-o", "saas", "-e", "test", "-u", "me", "-p", "password", "--org-admin-check"}, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	testutil.ErrorContains(t, rootCmd.Execute(), "--org-admin-check only valid for hybrid or Apigee X")
+}
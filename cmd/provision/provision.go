@@ -15,16 +15,16 @@
 package provision
 
 import (
-	"crypto/tls"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/k8s"
 	"github.com/apigee/apigee-remote-service-cli/shared"
 	"github.com/apigee/apigee-remote-service-envoy/server"
 	"github.com/pkg/errors"
@@ -33,10 +33,10 @@ import (
 )
 
 const (
-	kvmName       = "remote-service"
-	cacheName     = "remote-service"
-	encryptKVM    = true
-	authProxyName = "remote-service"
+	defaultKVMName   = "remote-service"
+	defaultCacheName = "remote-service"
+	authProxyName    = "remote-service"
+	apiProductName   = "remote-service"
 
 	remoteServiceProxyZip = "remote-service-gcp.zip"
 
@@ -46,6 +46,10 @@ const (
 	productsURLFormat     = "%s/products"     // RemoteServiceProxyURL
 	verifyAPIKeyURLFormat = "%s/verifyApiKey" // RemoteServiceProxyURL
 	quotasURLFormat       = "%s/quotas"       // RemoteServiceProxyURL
+	versionURLFormat      = "%s/version"      // RemoteServiceProxyURL
+
+	outputYAML = "yaml"
+	outputJSON = "json"
 )
 
 // default durations for the proxy verification retry
@@ -56,9 +60,54 @@ var (
 
 type provision struct {
 	*shared.RootArgs
-	forceProxyInstall bool
-	virtualHosts      string
-	rotate            int
+	forceProxyUpdate bool
+	proxyDir         string // local remote-service bundle
+	internalProxyDir string // local edgemicro-internal bundle
+	deployOptions    apigee.DeployOptions
+	wait             bool          // poll deployments until the revision is deployed
+	timeout          time.Duration // of --wait for each proxy
+	virtualHosts     string
+	vhosts           []string // of virtualHosts
+	rotate           int
+	useAppGroup      bool
+	product          productOptions
+	credential       credentialOptions
+	rotation         keyRotation // --rotate-key
+	skip             skipSteps
+	encryptKVM       bool
+	resources        resourceNames // --kvm-name and --cache-name
+	k8sVersion       string
+	output           string
+	emitCRD          bool
+	configOut        string // kubernetes resources or a native config.yaml
+	policySecretDir  string // where --config-out native writes the policy secret
+	nativeOut        nativeOutOptions
+	apply            applyOptions
+	secretFormat     secretFormatOptions
+	kustomizeDir     string
+	terraformDir     string
+	storage          string
+	jwtIssuer        string   // iss of the tokens issued by the remote-service proxy
+	jwtAudiences     []string // aud of the tokens issued by the remote-service proxy
+	dryRun           bool
+	verifyOnly       bool
+	orgAdminCheck    bool                     // verify the organization and the caller's permissions first
+	apigeeX          bool                     // runtime from the environment groups, adapter outside the cluster
+	target           string                   // mock serves all requests in process
+	envs             []string                 // all environments to provision
+	workers          int                      // environments provisioned concurrently
+	results          []shared.ProvisionResult // --output json of multiple environments
+	dryRunCalls      int
+	deployed         []shared.DeployedProxy // proxy revisions deployed to the environment
+	authProxyBundle  string                 // remote-service bundle deployed, in the temp dir of the run
+	rollbackOnError  bool
+	continueOnError  bool
+	stepFailures     []string   // of non-critical steps tolerated by --continue-on-error
+	undo             []undoStep // changes made by the run, reverted in reverse order on failure
+
+	verifyMaxFailures int
+	verifyRetryBudget int
+	breaker           *circuitBreaker // set while verifying with retry
 }
 
 // Cmd returns base command
@@ -70,22 +119,161 @@ func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
 		Short: "Provision your Apigee environment for remote services",
 		Long: `The provision command will set up your Apigee environment for remote services. This includes creating
 and installing a remote-service kvm with certificates, creating credentials, and deploying a remote-service proxy
-to your organization and environment.`,
+to your organization and environment. Multiple environments may be given as a comma separated list or by
+repeating --environment, the configuration of each is emitted in turn.`,
 		Args: cobra.NoArgs,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if p.Explain {
+				if p.verifyOnly {
+					return fmt.Errorf("--explain can't be combined with --verify-only")
+				}
+				p.dryRun = true // explains the calls of the dry run
+			}
+			p.envs = splitEnvs(p.Env)
+			if len(p.envs) > 1 && p.ConfigPath != "" {
+				return fmt.Errorf("multiple environments can't be provisioned with --config")
+			}
+			if len(p.envs) > 0 {
+				p.Env = p.envs[0]
+			}
+			if p.workers < 1 {
+				return fmt.Errorf("--workers must be at least 1")
+			}
+			if p.target != "" {
+				if err := p.useTarget(); err != nil {
+					return err
+				}
+			}
+			if p.apigeeX && (p.IsLegacySaaS || p.IsOPDK) {
+				return fmt.Errorf("--apigee-x can't be combined with --legacy or --opdk")
+			}
 			if err := rootArgs.Resolve(false, true); err != nil {
 				return err
 			}
+			if p.apigeeX {
+				p.Namespace = "" // the adapter doesn't run in the Apigee cluster
+				if err := p.checkApigeeX(); err != nil {
+					return err
+				}
+			}
+			for _, env := range p.envs {
+				if _, ok := p.Runtimes[env]; len(p.Runtimes) > 0 && !ok {
+					return fmt.Errorf("--runtime has no URL for environment %s", env)
+				}
+			}
 			if !p.IsGCPManaged && p.rotate > 0 {
 				return fmt.Errorf(`--rotate only valid for hybrid, use 'token rotate-cert' for others`)
 			}
+			if !p.IsGCPManaged && p.useAppGroup {
+				return fmt.Errorf("--use-appgroup only valid for hybrid")
+			}
+			if err := p.credential.validate(p); err != nil {
+				return err
+			}
+			if err := p.rotation.validate(p, cmd.Flags().Changed); err != nil {
+				return err
+			}
+			if p.storage != "" && p.storage != storageKVM && p.storage != storagePropertySet {
+				return fmt.Errorf("--storage must be %s or %s", storageKVM, storagePropertySet)
+			}
+			if p.IsGCPManaged && !p.encryptKVM {
+				return fmt.Errorf("--encrypt-kvm=false only valid for legacy or OPDK, kvms of hybrid and Apigee X are always encrypted")
+			}
+			if !p.IsGCPManaged && p.storage != "" {
+				return fmt.Errorf("--storage only valid for hybrid or Apigee X")
+			}
+			if !p.IsGCPManaged && (p.jwtIssuer != "" || len(p.jwtAudiences) > 0) {
+				return fmt.Errorf("--jwt-issuer and --jwt-audience only valid for hybrid or Apigee X")
+			}
+			if p.deployOptions.Delay < 0 {
+				return fmt.Errorf("--deploy-delay must not be negative")
+			}
+			if p.IsGCPManaged && cmd.Flags().Changed("deploy-delay") {
+				return fmt.Errorf("--deploy-delay only valid for legacy or OPDK")
+			}
+			if cmd.Flags().Changed("timeout") && !p.wait {
+				return fmt.Errorf("--timeout only valid with --wait")
+			}
+			if p.timeout <= 0 {
+				return fmt.Errorf("--timeout must be positive")
+			}
+			if !p.IsGCPManaged && p.deployOptions.SequencedRollout {
+				return fmt.Errorf("--sequenced-rollout only valid for hybrid or Apigee X")
+			}
+			if err := p.product.validate(cmd.Flags().Changed("product-quota-interval")); err != nil {
+				return err
+			}
+			if err := p.resources.validate(); err != nil {
+				return err
+			}
+			if err := p.skip.validate(p); err != nil {
+				return err
+			}
+			if !p.IsGCPManaged && p.orgAdminCheck {
+				return fmt.Errorf("--org-admin-check only valid for hybrid or Apigee X")
+			}
+			if p.IsGCPManaged && cmd.Flags().Changed("virtual-hosts") {
+				return fmt.Errorf("--virtual-hosts only valid for legacy or OPDK")
+			}
+			vhosts, err := parseVirtualHosts(p.virtualHosts)
+			if err != nil {
+				return err
+			}
+			p.vhosts = vhosts
+			if !p.IsOPDK && p.internalProxyDir != "" {
+				return fmt.Errorf("--internal-proxy-dir only valid for OPDK")
+			}
+			if err := checkProxyDir("proxy-dir", p.proxyDir); err != nil {
+				return err
+			}
+			if err := checkProxyDir("internal-proxy-dir", p.internalProxyDir); err != nil {
+				return err
+			}
+			if p.output != outputYAML && p.output != outputJSON {
+				return fmt.Errorf("--output must be %s or %s", outputYAML, outputJSON)
+			}
+			if p.k8sVersion != "" {
+				if _, err := k8s.ParseVersion(p.k8sVersion); err != nil {
+					return err
+				}
+			}
+			if err := p.validateConfigOut(); err != nil {
+				return err
+			}
+			if err := p.nativeOut.validate(p); err != nil {
+				return err
+			}
+			if err := p.apply.validate(p, cmd.Flags().Changed); err != nil {
+				return err
+			}
+			if err := p.secretFormat.validate(p); err != nil {
+				return err
+			}
+			if err := p.validateKustomize(); err != nil {
+				return err
+			}
+			if err := p.validateTerraform(); err != nil {
+				return err
+			}
+			if p.verifyOnly && (p.dryRun || p.rotate > 0 || p.rotation.enabled) {
+				return fmt.Errorf("--verify-only can't be combined with --dry-run, --rotate or --rotate-key")
+			}
+			if p.dryRun {
+				return p.enableDryRun(printf)
+			}
 			return nil
 		},
 
 		RunE: func(cmd *cobra.Command, _ []string) error {
+			defer p.PrintExplain(printf)
+			if p.apply.record != nil {
+				return p.resumeApply(printf)
+			}
 			return p.run(printf)
 		},
 	}
+	rootArgs.AddExplainFlag(c)
+	rootArgs.AddKeyPolicyFlags(c)
 
 	c.Flags().StringVarP(&rootArgs.ManagementBase, "management", "m",
 		shared.DefaultManagementBase, "Apigee management base URL")
@@ -96,31 +284,180 @@ to your organization and environment.`,
 
 	c.Flags().StringVarP(&rootArgs.Token, "token", "t", "",
 		"Apigee OAuth or SAML token (hybrid only)")
+	c.Flags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
 	c.Flags().StringVarP(&rootArgs.Username, "username", "u", "",
 		"Apigee username (legacy or OPDK only)")
 	c.Flags().StringVarP(&rootArgs.Password, "password", "p", "",
 		"Apigee password (legacy or OPDK only)")
 
-	c.Flags().BoolVarP(&p.forceProxyInstall, "force-proxy-install", "f", false,
+	c.Flags().BoolVarP(&p.forceProxyUpdate, "force-proxy-update", "f", false,
+		"deploy a new proxy revision even if the deployed one is from the same bundle")
+	c.Flags().BoolVarP(&p.forceProxyUpdate, "force-proxy-install", "", false,
 		"force new proxy install (upgrades proxy)")
+	_ = c.Flags().MarkDeprecated("force-proxy-install", "use --force-proxy-update")
+	c.Flags().StringVarP(&p.proxyDir, "proxy-dir", "", "",
+		"deploy the remote-service proxy bundle in this directory (containing apiproxy) instead of the embedded one")
+	c.Flags().StringVarP(&p.internalProxyDir, "internal-proxy-dir", "", "",
+		"deploy the edgemicro-internal proxy bundle in this directory (containing apiproxy) instead of the embedded one (OPDK only)")
+	c.Flags().BoolVarP(&p.deployOptions.Override, "deploy-override", "", apigee.DefaultDeployOptions.Override,
+		"replace the deployed proxy revision in place, on legacy and OPDK without undeploying it first")
+	c.Flags().IntVarP(&p.deployOptions.Delay, "deploy-delay", "", apigee.DefaultDeployOptions.Delay,
+		"seconds to keep serving the replaced proxy revision with --deploy-override (legacy or OPDK only)")
+	c.Flags().BoolVarP(&p.deployOptions.SequencedRollout, "sequenced-rollout", "", false,
+		"roll the proxy deployment out in sequence across the runtime (hybrid or Apigee X only)")
 	c.Flags().StringVarP(&p.virtualHosts, "virtual-hosts", "", "default,secure",
-		"override proxy virtualHosts")
+		"virtual hosts the proxies are bound to, they must exist in each environment (legacy or OPDK only)")
 	c.Flags().StringVarP(&p.Namespace, "namespace", "n", "apigee",
-		"emit configuration in the specified namespace")
+		"emit configuration in the specified namespace (ignored with --apigee-x)")
+	c.Flags().BoolVarP(&p.orgAdminCheck, "org-admin-check", "", false,
+		"verify first that the organization is provisioned and attached to a project and that the caller holds the permissions provisioning needs, naming the missing ones (hybrid or Apigee X only)")
+	c.Flags().BoolVarP(&p.apigeeX, "apigee-x", "", false,
+		"provision Apigee X: the config has no namespace or in-cluster analytics, for an adapter running outside the cluster")
 
 	c.Flags().IntVarP(&p.rotate, "rotate", "", 0, "if n > 0, generate new private key and keep n public keys (hybrid only)")
+	c.Flags().BoolVarP(&p.rotation.enabled, "rotate-key", "", false,
+		"create a new credential for the adapter and emit it in the config, even if --config has one")
+	c.Flags().BoolVarP(&p.rotation.revokeOld, "revoke-old-keys", "", false,
+		"with --rotate-key, revoke the app's other keys once the new config is emitted and verified (hybrid only)")
+	c.Flags().DurationVarP(&p.rotation.gracePeriod, "grace-period", "", 0,
+		"how long --revoke-old-keys waits after emitting the config for the adapters to pick it up")
+	c.Flags().BoolVarP(&p.wait, "wait", "", false,
+		"after deploying a proxy, wait until its revision is deployed to all message processors (ready on hybrid)")
+	c.Flags().DurationVarP(&p.timeout, "timeout", "", 5*time.Minute,
+		"how long --wait waits for each proxy deployment")
+	c.Flags().BoolVarP(&p.useAppGroup, "use-appgroup", "", false,
+		"create the remote-service app in an AppGroup rather than for a developer (hybrid only)")
+	c.Flags().StringVarP(&p.credential.developer, "developer", "", "",
+		"email of the developer owning the remote-service app (default: "+shared.DefaultDeveloperEmail+") (hybrid only)")
+	c.Flags().StringVarP(&p.credential.app, "app", "", "",
+		"name of the app holding the credential, eg. one per cluster (default: "+shared.DefaultAppName+") (hybrid only)")
+	c.Flags().BoolVarP(&p.skip.proxy, "skip-proxy", "", false,
+		"don't deploy the proxies, eg. to regenerate the config after a manual proxy upgrade")
+	c.Flags().BoolVarP(&p.skip.kvm, "skip-kvm", "", false,
+		"don't create the kvm (legacy or OPDK only)")
+	c.Flags().BoolVarP(&p.skip.product, "skip-product", "", false,
+		"don't create the API product")
+	c.Flags().BoolVarP(&p.skip.credential, "skip-credential", "", false,
+		"don't create a credential, use the one of --config")
+	c.Flags().StringSliceVarP(&p.credential.scopes, "credential-scopes", "", nil,
+		"OAuth scopes granted to the credential, also allowed by the product it's granted (hybrid only)")
+	c.Flags().DurationVarP(&p.credential.expiresIn, "expires-in", "", 0,
+		"lifetime of the credential created with the app, eg. 24h for a demo, 0 for none (hybrid only)")
+	c.Flags().StringVarP(&p.product.name, "product-name", "", "",
+		"name of the API product the remote-service app is granted (default: remote-service)")
+	c.Flags().StringVarP(&p.product.displayName, "product-display-name", "", "",
+		"display name of the API product when it's created (default: its name)")
+	c.Flags().IntVarP(&p.product.quota, "product-quota", "", 0,
+		"requests allowed per --product-quota-interval by the API product when it's created, 0 for no quota")
+	c.Flags().StringVarP(&p.product.quotaInterval, "product-quota-interval", "", defaultQuotaInterval,
+		"interval of --product-quota: a number followed by minute, hour, day or month")
+	c.Flags().IntVarP(&p.verifyMaxFailures, "verify-max-failures", "", 5,
+		"stop verifying an endpoint after n consecutive failures, 0 for no limit (hybrid only)")
+	c.Flags().IntVarP(&p.verifyRetryBudget, "verify-retry-budget", "", 30,
+		"stop verifying after n failed requests in total, 0 for no limit (hybrid only)")
+	c.Flags().IntVarP(&p.workers, "workers", "", defaultEnvWorkers,
+		"number of environments provisioned concurrently")
+	c.Flags().StringVarP(&p.jwtIssuer, "jwt-issuer", "", "",
+		"issuer of the tokens of the remote-service proxy (default: the URL of its token endpoint) (hybrid or Apigee X only)")
+	c.Flags().StringSliceVarP(&p.jwtAudiences, "jwt-audience", "", nil,
+		"audience of the tokens of the remote-service proxy, may be repeated (default: remote-service-client) (hybrid or Apigee X only)")
+	c.Flags().BoolVarP(&p.encryptKVM, "encrypt-kvm", "", true,
+		"create the remote-service kvm encrypted, its values can't be read back (false only valid for legacy or OPDK)")
+	c.Flags().StringVarP(&p.resources.kvm, "kvm-name", "", "",
+		"name of the kvm holding the proxy's keys, eg. one per installation in the environment, a changed name is only deployed with --force-proxy-update (default: "+defaultKVMName+")")
+	c.Flags().StringVarP(&p.resources.cache, "cache-name", "", "",
+		"name of the cache of the proxy's product lookups, a changed name is only deployed with --force-proxy-update (default: "+defaultCacheName+")")
+	c.Flags().StringVarP(&p.storage, "storage", "", "",
+		"store the proxy keys in a kvm or propertyset (default: propertyset for Apigee X, the policy secret for hybrid)")
+	c.Flags().BoolVarP(&p.dryRun, "dry-run", "", false,
+		"print the management API calls that would modify your organization without making them")
+	c.Flags().BoolVarP(&p.verifyOnly, "verify-only", "", false,
+		"check the proxies, kvm, product, app, credentials, endpoints and certs of the environment without changing anything, reporting each check")
+	c.Flags().StringVarP(&p.target, "target", "", "",
+		"mock: provision an in-process mock of a hybrid organization instead of the given one, to validate flags safely (hybrid only)")
+	c.Flags().BoolVarP(&p.rollbackOnError, "rollback-on-error", "", false,
+		"if provisioning fails, revert the changes made by this run (verification failures excepted)")
+	c.Flags().BoolVarP(&p.continueOnError, "continue-on-error", "", false,
+		"continue past failures of non-critical steps (cache, API product, --wait, revoking old keys), reporting them at the end with exit status 2")
+	c.Flags().StringVarP(&p.output, "output", "", outputYAML,
+		"output format: yaml for the Kubernetes resources, json for a provision result including them (or for the --verify-only report)")
+	c.Flags().BoolVarP(&p.emitCRD, "emit-crd", "", false,
+		"add an "+shared.RemoteServiceKind+" custom resource describing the provisioned environment to the Kubernetes resources")
+	c.Flags().StringVarP(&p.k8sVersion, "k8s-version", "", "",
+		`validate the emitted Kubernetes resources against this cluster version (eg. "1.21")`)
+	c.Flags().StringVarP(&p.configOut, "config-out", "", configOutKubernetes,
+		"config format: kubernetes for the ConfigMap and Secret, native for a config.yaml of an adapter running as a binary or container outside Kubernetes")
+	c.Flags().StringVarP(&p.policySecretDir, "policy-secret-dir", "", "",
+		"directory --config-out native writes the policy secret files to, given to the adapter by --policy-secret (required for hybrid and Apigee X)")
+	c.Flags().StringVarP(&p.nativeOut.dir, "native-out", "", "",
+		"directory --config-out native writes config.yaml, the policy secret files and a SHA256SUMS of them to, for an adapter on a VM")
+	c.Flags().StringVarP(&p.nativeOut.deliver, "deliver", "", "",
+		"ssh://[user@]host[:port]/path to copy the files of --native-out to with scp, SHA256SUMS last")
+	c.Flags().StringVarP(&p.nativeOut.trigger, "reload-trigger", "", "",
+		"file touched once the files of --native-out are written or delivered, for a watcher to reload the adapter (relative to --native-out or the --deliver path)")
+	c.Flags().BoolVarP(&p.apply.enabled, "apply", "", false,
+		"create or update the ConfigMap and Secret in the Kubernetes cluster (server-side apply) besides printing them, labelled as an apply set of the environment for --prune")
+	c.Flags().StringVarP(&p.apply.kubeconfig, "kubeconfig", "", "",
+		"kubeconfig of the cluster of --apply (default: $KUBECONFIG or ~/.kube/config)")
+	c.Flags().StringSliceVarP(&p.apply.contexts, "context", "", nil,
+		"kubeconfig contexts of the clusters of --apply, applied concurrently (default: the current context)")
+	c.Flags().StringVarP(&p.apply.resume, "resume", "", "",
+		"apply record of a failed --apply to complete, applying only the resources it didn't apply, without provisioning again")
+	c.Flags().BoolVarP(&p.apply.prune, "prune", "", false,
+		"delete the resources of previous --apply runs of the environment not applied by this one")
+	c.Flags().StringVarP(&p.secretFormat.format, "secret-format", "", secretFormatPlain,
+		"policy secret format: plain for a Secret, sealed for a Bitnami SealedSecret, sops for a Secret with its data encrypted by sops, to store it in Git (hybrid and Apigee X)")
+	c.Flags().StringVarP(&p.secretFormat.sealedCert, "sealed-secrets-cert", "", "",
+		"certificate of the Sealed Secrets controller of --secret-format sealed, as printed by kubeseal --fetch-cert")
+	c.Flags().StringVarP(&p.secretFormat.sopsAge, "sops-age", "", "",
+		"age recipients of --secret-format sops, comma separated")
+	c.Flags().StringVarP(&p.secretFormat.sopsKMS, "sops-kms", "", "",
+		"AWS KMS key ARNs of --secret-format sops, comma separated")
+	c.Flags().StringVarP(&p.secretFormat.sopsGCPKMS, "sops-gcp-kms", "", "",
+		"GCP KMS key resource IDs of --secret-format sops, comma separated")
+	c.Flags().StringVarP(&p.kustomizeDir, "kustomize-out", "", "",
+		"directory to write a kustomization to: a base of the adapter deployment and an overlay per environment with its ConfigMap and Secret")
+	c.Flags().StringVarP(&p.terraformDir, "terraform-out", "", "",
+		"directory to write a Terraform configuration to, describing the proxy, product and developer (google provider) and the ConfigMap and Secret (kubernetes provider) with import blocks to manage them (hybrid and Apigee X)")
 
 	return c
 }
 
-func (p *provision) run(printf shared.FormatFn) error {
+func (p *provision) run(printf shared.FormatFn) (err error) {
+	if p.verifyOnly {
+		return p.runVerifyOnly(printf)
+	}
+	defer func() { p.notifyFailure(err) }()
+	if p.orgAdminCheck {
+		if err := p.checkOrgAdmin(p.Stepf()); err != nil {
+			return err
+		}
+	}
+	if len(p.envs) <= 1 {
+		return p.runEnv(printf)
+	}
+	return p.runEnvs(printf)
+}
+
+// runEnv provisions the current environment
+func (p *provision) runEnv(printf shared.FormatFn) (err error) {
 
 	var cred *keySecret
 
-	var verbosef = shared.NoPrintf
-	if p.Verbose {
-		verbosef = shared.Errorf
-	}
+	// with --rollback-on-error, a failure to create the artifacts reverts the
+	// changes made so far, verification failures don't
+	verifying := false
+	defer func() {
+		if err != nil && !verifying {
+			err = p.rollback(err, printf)
+			if shared.ErrorCodeOf(err) == "" {
+				err = shared.WithCode(shared.CodeProvisionPartial, err)
+			}
+		}
+	}()
+
+	verbosef := p.Stepf()
 
 	tempDir, err := ioutil.TempDir("", "apigee")
 	if err != nil {
@@ -128,6 +465,117 @@ func (p *provision) run(printf shared.FormatFn) error {
 	}
 	defer os.RemoveAll(tempDir)
 
+	if p.IsGCPManaged {
+		if err := p.resolveStorage(verbosef); err != nil {
+			return err
+		}
+	}
+	if p.skip.proxy {
+		verbosef("proxies not deployed (--skip-proxy)")
+	} else if err := p.deployProxies(tempDir, printf, verbosef); err != nil {
+		return err
+	}
+
+	// create API product
+	if p.skip.product {
+		verbosef("product %s not created (--skip-product)", p.product.productName())
+	} else if err := p.tolerate("creating API product "+p.product.productName(), p.createAPIProduct(verbosef), verbosef); err != nil {
+		return errors.Wrapf(err, "creating %s API product", p.product.productName())
+	}
+
+	if p.skip.credential {
+		verbosef("credential of --config used (--skip-credential)")
+		cred = p.configCredential()
+	} else if p.IsGCPManaged {
+		if p.rotation.enabled {
+			cred, err = p.rotateGCPCredential(verbosef)
+		} else {
+			cred, err = p.createGCPCredential(verbosef)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "generating credential")
+		}
+	} else {
+		cred, err = p.createLegacyCredential(verbosef) // TODO: on missing or force new cred
+		if err != nil {
+			return errors.Wrapf(err, "generating credential")
+		}
+	}
+
+	if !p.IsGCPManaged {
+		if p.skip.kvm {
+			verbosef("kvm %s not created (--skip-kvm)", p.resources.kvmName())
+		} else if err := p.getOrCreateKVM(cred, verbosef); err != nil {
+			return errors.Wrapf(err, "retrieving or creating kvm")
+		}
+	}
+
+	if p.dryRun {
+		printf("dry run: %d call(s) not made, verification and configuration skipped", p.dryRunCalls)
+		return p.stepsError()
+	}
+
+	config := p.ServerConfig
+	if config == nil {
+		config = p.createConfig(cred)
+	} else if p.rotation.enabled {
+		config.Tenant.Key = cred.Key
+		config.Tenant.Secret = cred.Secret
+	}
+
+	if p.IsGCPManaged && (config.Tenant.PrivateKey == nil || p.rotate > 0) {
+		keyID, privateKey, jwks, err := p.CreateNewKey()
+		if err != nil {
+			return err
+		}
+		config.Tenant.PrivateKey = privateKey
+		config.Tenant.PrivateKeyID = keyID
+
+		if jwks, err = p.RotateJKWS(jwks, p.rotate); err != nil {
+			return err
+		}
+
+		config.Tenant.JWKS = jwks
+	}
+
+	if p.storage != "" {
+		if err := p.storeKeys(config, verbosef); err != nil {
+			return err
+		}
+	}
+
+	verifying = true
+	var verifyErrors error
+	if p.IsGCPManaged {
+		verifyErrors = p.verifyWithRetry(config, verbosef)
+	} else {
+		verifyErrors = p.verifyWithoutRetry(config, verbosef)
+	}
+
+	if err := p.printConfig(config, printf, verifyErrors); err != nil {
+		return errors.Wrapf(err, "generating config")
+	}
+	if p.IsGCPManaged && p.rotate > 0 {
+		p.notifyCertRotated(config.Tenant.PrivateKeyID)
+	}
+
+	if verifyErrors == nil {
+		verbosef("provisioning verified OK")
+		if p.rotation.revokeOld {
+			if err := p.tolerate("revoking old keys", p.revokeOldKeys(verbosef), verbosef); err != nil {
+				return err
+			}
+		}
+	} else if p.rotation.revokeOld {
+		verbosef("old keys not revoked as verification failed")
+	}
+
+	return multierr.Append(shared.WithCode(shared.CodeVerifyFailed, verifyErrors), p.stepsError())
+}
+
+// deployProxies deploys the proxies customized for the environment and flags,
+// a proxy is only updated if its bundle changed (or --force-proxy-update)
+func (p *provision) deployProxies(tempDir string, printf, verbosef shared.FormatFn) error {
 	replaceVH := func(proxyDir string) error {
 		proxiesFile := filepath.Join(proxyDir, "proxies", "default.xml")
 		bytes, err := ioutil.ReadFile(proxiesFile)
@@ -135,10 +583,8 @@ func (p *provision) run(printf shared.FormatFn) error {
 			return errors.Wrapf(err, "reading file %s", proxiesFile)
 		}
 		newVH := ""
-		for _, vh := range strings.Split(p.virtualHosts, ",") {
-			if strings.TrimSpace(vh) != "" {
-				newVH = newVH + fmt.Sprintf(virtualHostReplacementFmt, vh)
-			}
+		for _, vh := range p.vhosts {
+			newVH = newVH + fmt.Sprintf(virtualHostReplacementFmt, vh)
 		}
 		// remove all "secure" virtualhost
 		bytes = []byte(strings.ReplaceAll(string(bytes), virtualHostDeleteText, ""))
@@ -188,6 +634,16 @@ func (p *provision) run(printf shared.FormatFn) error {
 		return nil
 	}
 
+	if !p.IsGCPManaged {
+		if err := p.checkVirtualHosts(printf); err != nil {
+			return err
+		}
+	}
+
+	if err := p.checkBasePath(printf); err != nil {
+		return err
+	}
+
 	if p.IsOPDK {
 		if err := p.deployInternalProxy(replaceVH, tempDir, verbosef); err != nil {
 			return errors.Wrap(err, "deploying internal proxy")
@@ -195,95 +651,116 @@ func (p *provision) run(printf shared.FormatFn) error {
 	}
 
 	// input remote-service proxy
-	var customizedProxy string
+	var customizedProxy, digest string
+	var err error
+	authProxyBundle := legacyAuthProxyZip
 	if p.IsGCPManaged {
-		customizedProxy, err = getCustomizedProxy(tempDir, remoteServiceProxyZip, nil)
+		authProxyBundle = remoteServiceProxyZip
+		modFunc := func(proxyDir string) error {
+			if err := p.rewriteClaimReferences(proxyDir); err != nil {
+				return err
+			}
+			if p.storage != "" {
+				if err := p.rewriteKeyReferences(proxyDir); err != nil {
+					return err
+				}
+			}
+			return p.rewriteResourceNames(proxyDir)
+		}
+		customizedProxy, digest, err = getCustomizedProxy(tempDir, authProxyBundle, p.proxyDir, modFunc)
 	} else {
-		customizedProxy, err = getCustomizedProxy(tempDir, legacyAuthProxyZip, replaceVHAndAuthTarget)
+		modFunc := func(proxyDir string) error {
+			if err := replaceVHAndAuthTarget(proxyDir); err != nil {
+				return err
+			}
+			return p.rewriteResourceNames(proxyDir)
+		}
+		customizedProxy, digest, err = getCustomizedProxy(tempDir, authProxyBundle, p.proxyDir, modFunc)
 	}
 	if err != nil {
 		return err
 	}
 
-	if err := p.checkAndDeployProxy(authProxyName, customizedProxy, verbosef); err != nil {
+	if err := p.checkAndDeployProxy(authProxyName, digest, customizedProxy, verbosef); err != nil {
 		return errors.Wrapf(err, "deploying proxy %s", authProxyName)
 	}
+	p.authProxyBundle = customizedProxy
+	return nil
+}
 
-	// create API product
-	if err := p.createAPIProduct(verbosef); err != nil {
-		return errors.Wrapf(err, "creating remote-service API product")
+// platform names the Apigee platform as in support bundles and state files
+func (p *provision) platform() string {
+	switch {
+	case p.IsOPDK:
+		return "opdk"
+	case p.IsLegacySaaS:
+		return "legacy"
 	}
+	return "hybrid"
+}
 
-	if !p.IsGCPManaged {
-		cred, err = p.createLegacyCredential(verbosef) // TODO: on missing or force new cred
-		if err != nil {
-			return errors.Wrapf(err, "generating credential")
-		}
-
-		if err := p.getOrCreateKVM(cred, verbosef); err != nil {
-			return errors.Wrapf(err, "retrieving or creating kvm")
+// splitEnvs returns the environments of a comma separated list
+func splitEnvs(list string) []string {
+	var envs []string
+	for _, env := range strings.Split(list, ",") {
+		if env = strings.TrimSpace(env); env != "" {
+			envs = append(envs, env)
 		}
 	}
+	return envs
+}
 
-	config := p.ServerConfig
-	if config == nil {
-		config = p.createConfig(cred)
+// checkProxyDir verifies a local proxy bundle directory, if given, contains an apiproxy directory
+func checkProxyDir(flag, dir string) error {
+	if dir == "" {
+		return nil
 	}
-
-	if p.IsGCPManaged && (config.Tenant.PrivateKey == nil || p.rotate > 0) {
-		keyID, privateKey, jwks, err := p.CreateNewKey()
-		if err != nil {
-			return err
-		}
-		config.Tenant.PrivateKey = privateKey
-		config.Tenant.PrivateKeyID = keyID
-
-		if jwks, err = p.RotateJKWS(jwks, p.rotate); err != nil {
-			return err
-		}
-
-		config.Tenant.JWKS = jwks
+	if info, err := os.Stat(filepath.Join(dir, "apiproxy")); err != nil || !info.IsDir() {
+		return fmt.Errorf("--%s %s: no apiproxy directory", flag, dir)
 	}
+	return nil
+}
 
-	var verifyErrors error
-	if p.IsGCPManaged {
-		verifyErrors = p.verifyWithRetry(config, verbosef)
-	} else {
-		verifyErrors = p.verifyWithoutRetry(config, verbosef)
+// useTarget points all management API and runtime requests at --target
+func (p *provision) useTarget() error {
+	if p.target != targetMock {
+		return fmt.Errorf("--target must be %s", targetMock)
 	}
-
-	if err := p.printConfig(config, printf, verifyErrors); err != nil {
-		return errors.Wrapf(err, "generating config")
+	if p.IsLegacySaaS || p.IsOPDK {
+		return fmt.Errorf("--target only valid for hybrid or Apigee X")
 	}
-
-	if verifyErrors == nil {
-		verbosef("provisioning verified OK")
+	if p.Token == "" && p.ServiceAccount == "" {
+		p.Token = mockToken
 	}
+	p.RoundTripper = newMockTarget()
+	return nil
+}
 
-	return verifyErrors
+// enableDryRun replaces the client by one reporting the calls that modify resources
+func (p *provision) enableDryRun(printf shared.FormatFn) error {
+	p.ClientOpts.DryRun = func(format string, args ...interface{}) {
+		p.dryRunCalls++
+		printf("dry run: "+format, args...)
+	}
+	var err error
+	p.ApigeeClient, err = apigee.NewEdgeClient(p.ClientOpts)
+	return err
 }
 
 func (p *provision) createAuthorizedClient(config *server.Config) (*http.Client, error) {
 
+	// the JWT of the auth manager expires and is re-signed immediately without a
+	// duration and a refresh, which configs created by provision don't set
+	if config.Tenant.InternalJWTDuration == 0 || config.Tenant.InternalJWTRefresh == 0 {
+		defaults := server.DefaultConfig().Tenant
+		withDefaults := *config
+		withDefaults.Tenant.InternalJWTDuration = defaults.InternalJWTDuration
+		withDefaults.Tenant.InternalJWTRefresh = defaults.InternalJWTRefresh
+		config = &withDefaults
+	}
+
 	// add authorization to transport
-	tr := http.DefaultTransport
-	if config.Tenant.AllowUnverifiedSSLCert {
-		tr = &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   30 * time.Second,
-				KeepAlive: 30 * time.Second,
-				DualStack: true,
-			}).DialContext,
-			MaxIdleConns:          100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
-		}
-	}
-
-	tr, err := server.AuthorizationRoundTripper(config, tr)
+	tr, err := server.AuthorizationRoundTripper(config, p.RuntimeTransport(config.Tenant.AllowUnverifiedSSLCert))
 	if err != nil {
 		return nil, err
 	}
@@ -295,34 +772,56 @@ func (p *provision) createAuthorizedClient(config *server.Config) (*http.Client,
 }
 
 func (p *provision) verifyWithRetry(config *server.Config, verbosef shared.FormatFn) error {
+	p.breaker = newCircuitBreaker(p.verifyMaxFailures, p.verifyRetryBudget)
+	defer func() { p.breaker = nil }()
+
 	var verifyErrors error
 	timeout := time.After(duration * time.Second)
 	tick := time.Tick(interval * time.Millisecond)
 	for {
 		select {
 		case <-timeout:
-			if verifyErrors != nil {
-				shared.Errorf("\nWARNING: Apigee may not be provisioned properly.")
-				shared.Errorf("Unable to verify proxy endpoint(s). Errors:\n")
-				for _, err := range multierr.Errors(verifyErrors) {
-					if strings.Contains(err.Error(), "Unable to get the runtime version") {
-						p.encodeUDCAEndpoint(config, verbosef)
-					}
-					shared.Errorf("  %s", err)
-				}
-				shared.Errorf("\n")
-			}
-			return verifyErrors
+			return p.reportVerifyErrors(config, verifyErrors, verbosef)
 		case <-tick:
 			verifyErrors = p.verify(config, verbosef)
 			if verifyErrors == nil {
 				return nil
 			}
+			if p.breaker.exhausted() {
+				verbosef("retry budget of %d failed requests exhausted", p.verifyRetryBudget)
+				return p.reportVerifyErrors(config, verifyErrors, verbosef)
+			}
+			if p.breaker.allOpen() {
+				verbosef("circuits of all failing endpoints are open")
+				return p.reportVerifyErrors(config, verifyErrors, verbosef)
+			}
 			verbosef("verifying proxies failed, trying again...")
 		}
 	}
 }
 
+func (p *provision) reportVerifyErrors(config *server.Config, verifyErrors error, verbosef shared.FormatFn) error {
+	if verifyErrors == nil {
+		return nil
+	}
+	shared.Errorf("\nWARNING: Apigee may not be provisioned properly.")
+	shared.Errorf("Unable to verify proxy endpoint(s). Errors:\n")
+	for _, err := range multierr.Errors(verifyErrors) {
+		if strings.Contains(err.Error(), "Unable to get the runtime version") {
+			p.encodeUDCAEndpoint(config, verbosef)
+		}
+		shared.Errorf("  %s", err)
+	}
+	if open := p.breaker.open(); len(open) > 0 {
+		shared.Errorf("\nEndpoint(s) skipped after %d consecutive failures:\n", p.verifyMaxFailures)
+		for _, endpoint := range open {
+			shared.Errorf("  %s (last error: %v)", endpoint, p.breaker.lastErr[endpoint])
+		}
+	}
+	shared.Errorf("\n")
+	return verifyErrors
+}
+
 func (p *provision) verifyWithoutRetry(config *server.Config, verbosef shared.FormatFn) error {
 	verifyErrors := p.verify(config, verbosef)
 	if verifyErrors != nil {
@@ -352,8 +851,14 @@ func (p *provision) verify(config *server.Config, verbosef shared.FormatFn) erro
 	verbosef("verifying remote-service proxy...")
 	verifyErrors = multierr.Combine(verifyErrors, p.verifyRemoteServiceProxy(client, verbosef))
 
-	if p.IsGCPManaged {
-		if version, err := p.checkRuntimeVersion(config, client, verbosef); err != nil {
+	if p.IsGCPManaged && !p.apigeeX { // no UDCA on Apigee X
+		var version string
+		versionURL := fmt.Sprintf(versionURLFormat, p.RemoteServiceProxyURL)
+		err := p.breaker.call(versionURL, func() (err error) {
+			version, err = p.checkRuntimeVersion(config, client, verbosef)
+			return err
+		})
+		if err != nil {
 			err = errors.Wrapf(err, "Unable to get the runtime version")
 			verifyErrors = multierr.Combine(verifyErrors, err)
 		} else if version >= "1.3.0" || version == "unknown" {
@@ -370,53 +875,61 @@ func (p *provision) verify(config *server.Config, verbosef shared.FormatFn) erro
 // verify POST RemoteServiceProxyURL/quotas
 func (p *provision) verifyRemoteServiceProxy(client *http.Client, printf shared.FormatFn) error {
 
+	// server errors count as failures so a flapping runtime trips the breaker
+	do := func(req *http.Request) (*http.Response, error) {
+		res, err := tracedDo(client, req)
+		if res != nil {
+			res.Body.Close()
+			if err == nil && res.StatusCode >= 500 {
+				err = fmt.Errorf("%s %s: %s", req.Method, req.URL, res.Status)
+			}
+		}
+		return res, err
+	}
+
 	verifyGET := func(targetURL string) error {
 		req, err := http.NewRequest(http.MethodGet, targetURL, nil)
 		if err != nil {
 			return errors.Wrapf(err, "creating request")
 		}
-		res, err := client.Do(req)
-		if res != nil {
-			defer res.Body.Close()
-		}
+		_, err = do(req)
 		return err
 	}
 
-	var res *http.Response
+	verifyPOST := func(targetURL, body string) (*http.Response, error) {
+		req, err := http.NewRequest(http.MethodPost, targetURL, strings.NewReader(body))
+		if err != nil {
+			return nil, errors.Wrapf(err, "creating request")
+		}
+		req.Header.Add("Content-Type", "application/json")
+		return do(req)
+	}
+
 	var verifyErrors error
 	certsURL := fmt.Sprintf(certsURLFormat, p.RemoteServiceProxyURL)
-	err := verifyGET(certsURL)
-	verifyErrors = multierr.Append(verifyErrors, err)
+	verifyErrors = multierr.Append(verifyErrors, p.breaker.call(certsURL, func() error {
+		return verifyGET(certsURL)
+	}))
 
 	productsURL := fmt.Sprintf(productsURLFormat, p.RemoteServiceProxyURL)
-	err = verifyGET(productsURL)
-	verifyErrors = multierr.Append(verifyErrors, err)
+	verifyErrors = multierr.Append(verifyErrors, p.breaker.call(productsURL, func() error {
+		return verifyGET(productsURL)
+	}))
 
 	verifyAPIKeyURL := fmt.Sprintf(verifyAPIKeyURLFormat, p.RemoteServiceProxyURL)
-	req, err := http.NewRequest(http.MethodPost, verifyAPIKeyURL, strings.NewReader(`{ "apiKey": "x" }`))
-	if err == nil {
-		req.Header.Add("Content-Type", "application/json")
-		res, err = client.Do(req)
-		if res != nil {
-			defer res.Body.Close()
+	verifyErrors = multierr.Append(verifyErrors, p.breaker.call(verifyAPIKeyURL, func() error {
+		res, err := verifyPOST(verifyAPIKeyURL, `{ "apiKey": "x" }`)
+		if err != nil && (res == nil || res.StatusCode != 401) { // 401 is ok, we didn't use a valid api key
+			return err
 		}
-	}
-	if err != nil && (res == nil || res.StatusCode != 401) { // 401 is ok, we didn't use a valid api key
-		verifyErrors = multierr.Append(verifyErrors, err)
-	}
+		return nil
+	}))
 
 	quotasURL := fmt.Sprintf(quotasURLFormat, p.RemoteServiceProxyURL)
-	req, err = http.NewRequest(http.MethodPost, quotasURL, strings.NewReader("{}"))
-	if err == nil {
-		req.Header.Add("Content-Type", "application/json")
-		res, err = client.Do(req)
-		if res != nil {
-			defer res.Body.Close()
-		}
-	}
-	if err != nil {
-		verifyErrors = multierr.Append(verifyErrors, err)
-	}
+	verifyErrors = multierr.Append(verifyErrors, p.breaker.call(quotasURL, func() error {
+		_, err := verifyPOST(quotasURL, "{}")
+		return err
+	}))
 
 	return verifyErrors
 }
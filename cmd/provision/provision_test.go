@@ -15,15 +15,23 @@
 package provision
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/apigee/apigee-remote-service-cli/apigee"
 	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/proxies"
 	"github.com/apigee/apigee-remote-service-cli/shared"
 	"github.com/apigee/apigee-remote-service-cli/testutil"
 	"github.com/spf13/cobra"
@@ -203,6 +211,12 @@ func serveMux(t *testing.T) *http.ServeMux {
 			}
 		}
 	})
+	m.HandleFunc("/v1/organizations/gcp/developers/remote-service@apigee.com/apps", func(w http.ResponseWriter, r *http.Request) {
+		gcpAppHandler(t, w, r, "gcpkey")
+	})
+	m.HandleFunc("/v1/organizations/gcp/appgroups/remote-service/apps", func(w http.ResponseWriter, r *http.Request) {
+		gcpAppHandler(t, w, r, "appgroupkey")
+	})
 	m.HandleFunc("/credential/organization/", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		default:
@@ -260,6 +274,10 @@ func serveMux(t *testing.T) *http.ServeMux {
 			t.Fatalf("%s to %s not allowed", r.Method, r.URL.Path)
 		case http.MethodGet:
 			w.WriteHeader(http.StatusOK)
+			if strings.HasSuffix(r.URL.Path, "/virtualhosts") {
+				_, _ = w.Write([]byte(`["default","secure"]`))
+				return
+			}
 			_, _ = w.Write([]byte("{}"))
 		case http.MethodPost:
 			if strings.Contains(r.URL.Path, "apiproducts") {
@@ -311,6 +329,27 @@ func serveMux(t *testing.T) *http.ServeMux {
 	return m
 }
 
+func gcpAppHandler(t *testing.T, w http.ResponseWriter, r *http.Request, key string) {
+	switch r.Method {
+	default:
+		t.Fatalf("%s to %s not allowed", r.Method, r.URL.Path)
+	case http.MethodPost:
+		app := apigee.DeveloperApp{}
+		if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+			t.Fatalf("incorrect app %v", err)
+		}
+		if app.Name != "remote-service" || len(app.APIProducts) != 1 || app.APIProducts[0] != apiProductName {
+			t.Errorf("unexpected app %v", app)
+		}
+		app.Credentials = []apigee.AppCredential{{ConsumerKey: key, ConsumerSecret: "secret", Status: "approved"}}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if err := json.NewEncoder(w).Encode(app); err != nil {
+			t.Fatalf("want no error %v", err)
+		}
+	}
+}
+
 func handler(t *testing.T) http.Handler {
 	return serveMux(t)
 }
@@ -381,6 +420,66 @@ func TestProvisionOPDK(t *testing.T) {
 	if err := rootCmd.Execute(); err != nil {
 		t.Fatalf("want no error: %v", err)
 	}
+
+	print.Prints = nil
+	rootArgs = &shared.RootArgs{}
+	rootCmd = cmd.GetRootCmd(append(flags, "--output", "json"), print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if len(print.Prints) != 1 {
+		t.Fatalf("want 1 print, got %d: %v", len(print.Prints), print.Prints)
+	}
+	result := shared.ProvisionResult{}
+	if err := json.Unmarshal([]byte(print.Prints[0]), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Platform != shared.PlatformOPDK || result.Organization != "opdk" || result.Environment != "test" ||
+		result.Runtime != ts.URL || result.Namespace != "ns" || result.ConfigMap != shared.ConfigMapName ||
+		result.Secret != "" || !result.Verified || !strings.Contains(result.Resources, "kind: ConfigMap") {
+		t.Errorf("unexpected result: %#v", result)
+	}
+	if result.Credential == nil || result.Credential.Key == "" || result.Credential.Secret == "" {
+		t.Errorf("want credential, got %#v", result.Credential)
+	}
+	if result.Endpoints.RemoteServiceAPI != ts.URL+"/remote-service" || result.Endpoints.InternalAPI != ts.URL {
+		t.Errorf("unexpected endpoints: %#v", result.Endpoints)
+	}
+	wantProxies := []shared.DeployedProxy{{Name: "edgemicro-internal", Revision: 1}, {Name: "remote-service", Revision: 1}}
+	if !reflect.DeepEqual(wantProxies, result.Proxies) {
+		t.Errorf("want proxies %v, got %v", wantProxies, result.Proxies)
+	}
+
+	rootArgs = &shared.RootArgs{}
+	rootCmd = cmd.GetRootCmd(append(flags, "--output", "xml"), print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	testutil.ErrorContains(t, rootCmd.Execute(), "--output must be yaml or json")
+
+	// save to workspace
+	dir, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := (&shared.Workspace{Dir: dir}).Save(); err != nil {
+		t.Fatal(err)
+	}
+	print.Prints = nil
+	rootArgs = &shared.RootArgs{}
+	rootCmd = cmd.GetRootCmd(append(flags, "--workspace", dir), print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	checkContains(t, print.Prints, "# saved to workspace directory "+filepath.Join(dir, "opdk", "test"))
+	saved, err := shared.ReadProvisionResult(filepath.Join(dir, "opdk", "test", "provision.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Platform != shared.PlatformOPDK || saved.Runtime != ts.URL {
+		t.Errorf("unexpected saved result: %#v", saved)
+	}
 }
 
 func TestProvisionHybrid(t *testing.T) {
@@ -413,8 +512,19 @@ data:
   config.yaml:`,
 	}
 
+	checkContains(t, print.Prints, "key: gcpkey")
 	print.CheckPrefix(t, want)
 
+	// credential in an AppGroup, validated output
+	flags = []string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-n", "ns", "-t", "token", "--use-appgroup", "--k8s-version", "1.21"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	checkContains(t, print.Prints, "key: appgroupkey")
+
 	// force replacing existing proxies
 	flags = []string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-n", "ns", "-t", "token", "-f", "-v"}
 	rootCmd = cmd.GetRootCmd(flags, print.Printf)
@@ -430,7 +540,24 @@ data:
 	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
 
 	err := rootCmd.Execute()
-	testutil.ErrorContains(t, err, "--token is required for hybrid")
+	testutil.ErrorContains(t, err, "--token or --service-account is required for hybrid")
+
+	// error on bad Kubernetes version
+	flags = []string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-n", "ns", "-t", "token", "--k8s-version", "1.x"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+
+	err = rootCmd.Execute()
+	testutil.ErrorContains(t, err, `invalid Kubernetes version "1.x"`)
+}
+
+func checkContains(t *testing.T, prints []string, want string) {
+	for _, p := range prints {
+		if strings.Contains(p, want) {
+			return
+		}
+	}
+	t.Errorf("want output containing %q", want)
 }
 
 func TestInvalidRuntimeVersion(t *testing.T) {
@@ -611,6 +738,66 @@ data:
 	print.CheckPrefix(t, want)
 }
 
+func TestEncryptKVM(t *testing.T) {
+	var created []apigee.KVM
+	existing := apigee.KVM{Name: defaultKVMName, Encrypted: true}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			_ = json.NewEncoder(w).Encode(existing)
+			return
+		}
+		var kvm apigee.KVM
+		if err := json.NewDecoder(r.Body).Decode(&kvm); err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, kvm)
+		if len(created) > 1 {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	rootArgs := &shared.RootArgs{
+		Env:        "test",
+		IsOPDK:     true,
+		ClientOpts: &apigee.EdgeClientOptions{Org: "opdk", Env: "test", Auth: &apigee.EdgeAuth{SkipAuth: true}},
+	}
+	setTestUrls(rootArgs, ts.URL)
+	p := &provision{RootArgs: rootArgs}
+	print := testutil.Printer("TestEncryptKVM")
+	if err := p.getOrCreateKVM(nil, print.Printf); err != nil {
+		t.Fatal(err)
+	}
+	if created[0].Encrypted {
+		t.Errorf("want kvm created unencrypted with --encrypt-kvm=false")
+	}
+
+	print = testutil.Printer("TestEncryptKVM")
+	if err := p.getOrCreateKVM(nil, print.Printf); err != nil {
+		t.Fatal(err)
+	}
+	checkContains(t, print.Prints, "warning: kvm remote-service is encrypted, contrary to --encrypt-kvm=false, deprovision to create it again")
+
+	existing = apigee.KVM{Name: defaultKVMName, Encrypted: true, Entries: []apigee.Entry{{Name: "private_key", Value: apigee.MaskedValue}}}
+	d := &doctor{provision: p}
+	prob, err := d.checkKVM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prob == nil || prob.fix != nil || !strings.Contains(prob.hint, "can't be read") {
+		t.Errorf("want no fix of a masked private key, got %v", prob)
+	}
+
+	flags := []string{"provision", "-o", "hybrid", "-e", "test", "-t", "token", "-r", ts.URL, "--encrypt-kvm=false"}
+	rootArgs = &shared.RootArgs{}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	testutil.ErrorContains(t, rootCmd.Execute(), "--encrypt-kvm=false only valid for legacy or OPDK")
+}
+
 func TestCacheCreation(t *testing.T) {
 	ts := httptest.NewServer(handler(t))
 	defer ts.Close()
@@ -642,6 +829,24 @@ func TestCacheCreation(t *testing.T) {
 	if err := rootCmd.Execute(); err != nil {
 		t.Fatalf("want no error: %v", err)
 	}
+
+	// failing cache tolerated with --continue-on-error
+	print = testutil.Printer("TestCacheCreation")
+	flags = []string{"provision", "-o", "badcache", "-e", "test", "-u", "me", "-p", "password", "-r", ts.URL, "-n", "ns", "-m", ts.URL, "--opdk", "--continue-on-error"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+
+	err = rootCmd.Execute()
+	testutil.ErrorContains(t, err, "1 step(s) failed (--continue-on-error): creating cache remote-service")
+	if status := shared.ExitStatus(err); status != 2 {
+		t.Errorf("want exit status 2, got %d", status)
+	}
+	print.CheckPrefix(t, []string{
+		"# Configuration for apigee-remote-service-envoy (platform: OPDK)",
+		"# generated by apigee-remote-service-cli provision on",
+		"# WARNING: failed (--continue-on-error): creating cache remote-service",
+		"apiVersion: v1",
+	})
 }
 
 func TestCredentialsCreation(t *testing.T) {
@@ -658,6 +863,15 @@ func TestCredentialsCreation(t *testing.T) {
 
 	err := rootCmd.Execute()
 	testutil.ErrorContains(t, err, "generating credential")
+
+	// AppGroups are hybrid only
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"provision", "-o", "org", "-e", "test", "-u", "me", "-p", "password", "-r", ts.URL, "--opdk", "--use-appgroup"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+
+	err = rootCmd.Execute()
+	testutil.ErrorContains(t, err, "--use-appgroup only valid for hybrid")
 }
 
 func TestInternalProxyVerification(t *testing.T) {
@@ -685,3 +899,578 @@ func TestInternalProxyVerification(t *testing.T) {
 		t.Fatalf("want no error: %v", err)
 	}
 }
+
+func TestProvisionMultipleEnvironments(t *testing.T) {
+	ts := httptest.NewServer(handler(t))
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	print := testutil.Printer("TestProvisionMultipleEnvironments")
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"provision", "-o", "gcp", "-e", "test,prod", "-r", ts.URL, "-n", "ns", "-t", "token"}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	for _, want := range []string{
+		"name: apigee-remote-service-envoy-test",
+		"name: gcp-test-policy-secret",
+		"name: apigee-remote-service-envoy-prod",
+		"name: gcp-prod-policy-secret",
+		"env_name: prod",
+	} {
+		checkContains(t, print.Prints, want)
+	}
+	checkContains(t, print.Prints, "---")
+
+	// repeated flags, combined json
+	print = testutil.Printer("TestProvisionMultipleEnvironments")
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"provision", "-o", "gcp", "-e", "test", "-e", "prod", "-r", ts.URL, "-t", "token", "--output", "json"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	var results []shared.ProvisionResult
+	if err := json.Unmarshal([]byte(print.Prints[len(print.Prints)-1]), &results); err != nil {
+		t.Fatalf("want json results: %v", err)
+	}
+	if len(results) != 2 || results[0].Environment != "test" || results[1].Environment != "prod" {
+		t.Errorf("unexpected results: %v", results)
+	}
+
+	// runtime per environment
+	print = testutil.Printer("TestProvisionMultipleEnvironments")
+	rootArgs = &shared.RootArgs{}
+	prodURL := strings.Replace(ts.URL, "127.0.0.1", "localhost", 1)
+	flags = []string{"provision", "-o", "gcp", "-e", "test,prod", "-r", "test=" + ts.URL + ",prod=" + prodURL, "-t", "token", "--output", "json"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	results = nil
+	if err := json.Unmarshal([]byte(print.Prints[len(print.Prints)-1]), &results); err != nil {
+		t.Fatalf("want json results: %v", err)
+	}
+	if len(results) != 2 || results[0].Runtime != ts.URL || results[1].Runtime != prodURL ||
+		results[1].Endpoints.RemoteServiceAPI != prodURL+"/remote-service" {
+		t.Errorf("want runtime per environment, got: %v", results)
+	}
+
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"provision", "-o", "gcp", "-e", "test,prod", "-r", "test=" + ts.URL, "-t", "token"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	err := rootCmd.Execute()
+	testutil.ErrorContains(t, err, "--runtime has no URL for environment prod")
+
+	// dry run output is kept per environment
+	print = testutil.Printer("TestProvisionMultipleEnvironments")
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"provision", "-o", "gcp", "-e", "prod,dev,qa", "-r", ts.URL, "-t", "token", "--dry-run", "--workers", "2"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	var envOrder []string
+	for _, p := range print.Prints {
+		for _, env := range []string{"prod", "dev", "qa"} {
+			if strings.HasPrefix(p, "dry run: POST "+ts.URL+"/v1/organizations/gcp/environments/"+env+"/apis/remote-service/revisions") {
+				envOrder = append(envOrder, env)
+			}
+		}
+	}
+	if strings.Join(envOrder, ",") != "prod,dev,qa" {
+		t.Errorf("want deployments in order of environments, got %v", envOrder)
+	}
+
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"provision", "-o", "gcp", "-e", "test,prod", "-r", ts.URL, "-t", "token", "--workers", "0"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	err = rootCmd.Execute()
+	testutil.ErrorContains(t, err, "--workers must be at least 1")
+
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"provision", "-c", "config.yaml", "-e", "test,prod"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	err = rootCmd.Execute()
+	testutil.ErrorContains(t, err, "multiple environments can't be provisioned with --config")
+}
+
+func TestProxyUpdate(t *testing.T) {
+	digest, _ := proxies.Digest(remoteServiceProxyZip)
+	description := ""
+	var calls []string
+	mux := serveMux(t)
+	mux.HandleFunc("/v1/organizations/gcp/apis/remote-service/revisions/3", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(apigee.ProxyRevision{Name: "remote-service", Description: description})
+	})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/apis") {
+			calls = append(calls, r.URL.Path)
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	run := func(args ...string) {
+		calls = nil
+		print := testutil.Printer("TestProxyUpdate")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-t", "token"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("want no error: %v", err)
+		}
+	}
+	deployed := []string{
+		"/v1/organizations/gcp/apis",
+		"/v1/organizations/gcp/environments/test/apis/remote-service/revisions/4/deployments",
+	}
+
+	// deployed from the same bundle
+	description = "remote-service (bundle sha256:" + digest + ")"
+	run()
+	if len(calls) != 0 {
+		t.Errorf("want proxy left as is, got %v", calls)
+	}
+
+	for _, flag := range []string{"--force-proxy-update", "--force-proxy-install"} {
+		run(flag)
+		if !reflect.DeepEqual(deployed, calls) {
+			t.Errorf("%s: want %v, got %v", flag, deployed, calls)
+		}
+	}
+
+	// deployed from another or an unstamped bundle
+	for _, description = range []string{"remote-service (bundle sha256:" + strings.Repeat("0", 64) + ")", "remote-service"} {
+		run()
+		if !reflect.DeepEqual(deployed, calls) {
+			t.Errorf("%q: want %v, got %v", description, deployed, calls)
+		}
+	}
+}
+
+func TestStampBundleDigest(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "stamp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	zipFile, digest, err := getCustomizedProxy(tempDir, remoteServiceProxyZip, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned, _ := proxies.Digest(remoteServiceProxyZip); digest != pinned {
+		t.Errorf("want embedded digest %s, got %s", pinned, digest)
+	}
+	extractDir := filepath.Join(tempDir, "extracted")
+	if err := unzipFile(zipFile, extractDir); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(extractDir, "apiproxy", "remote-service.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "<Description>remote-service (bundle sha256:" + digest + ")</Description>"
+	if !strings.Contains(string(data), want) {
+		t.Errorf("want %s in:\n%s", want, data)
+	}
+	if got := bundleDigest(want); got != digest {
+		t.Errorf("want digest %s, got %s", digest, got)
+	}
+	if got := bundleDigest("remote-service"); got != "" {
+		t.Errorf("want no digest, got %s", got)
+	}
+}
+
+func TestProvisionProxyDir(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "proxydir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// a local bundle with an added policy
+	bundleDir := filepath.Join(tempDir, "custom")
+	if err := proxies.RestoreAsset(tempDir, remoteServiceProxyZip); err != nil {
+		t.Fatal(err)
+	}
+	if err := unzipFile(filepath.Join(tempDir, remoteServiceProxyZip), bundleDir); err != nil {
+		t.Fatal(err)
+	}
+	policy := filepath.Join(bundleDir, "apiproxy", "policies", "SSO.xml")
+	if err := ioutil.WriteFile(policy, []byte("<AssignMessage name=\"SSO\"/>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := dirDigest(filepath.Join(bundleDir, "apiproxy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var imported []byte
+	mux := serveMux(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/v1/organizations/gcp/apis" {
+			file, _, err := r.FormFile("file")
+			if err != nil {
+				t.Fatal(err)
+			}
+			imported, _ = ioutil.ReadAll(file)
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	run := func(args ...string) error {
+		print := testutil.Printer("TestProvisionProxyDir")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"provision", "-e", "test", "-r", ts.URL, "-t", "token"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return rootCmd.Execute()
+	}
+
+	if err := run("-o", "gcp", "--proxy-dir", bundleDir); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	zipReader, err := zip.NewReader(bytes.NewReader(imported), int64(len(imported)))
+	if err != nil {
+		t.Fatalf("want imported zip: %v", err)
+	}
+	files := map[string]string{}
+	for _, f := range zipReader.File {
+		rc, _ := f.Open()
+		data, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		files[filepath.ToSlash(f.Name)] = string(data)
+	}
+	if _, ok := files["apiproxy/policies/SSO.xml"]; !ok {
+		t.Errorf("want custom policy imported")
+	}
+	if !strings.Contains(files["apiproxy/remote-service.xml"], "bundle sha256:"+digest) {
+		t.Errorf("want local bundle digest %s stamped", digest)
+	}
+
+	err = run("-o", "gcp", "--proxy-dir", tempDir)
+	testutil.ErrorContains(t, err, "--proxy-dir "+tempDir+": no apiproxy directory")
+	err = run("-o", "gcp", "--internal-proxy-dir", bundleDir)
+	testutil.ErrorContains(t, err, "--internal-proxy-dir only valid for OPDK")
+}
+
+func TestDeployOptions(t *testing.T) {
+	var calls []string
+	mux := serveMux(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/apis/") {
+			calls = append(calls, r.URL.String())
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	run := func(args ...string) error {
+		calls = nil
+		print := testutil.Printer("TestDeployOptions")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"provision", "-e", "test", "-r", ts.URL}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return rootCmd.Execute()
+	}
+	legacy := []string{"-o", "saas", "-u", "me", "-p", "password", "--legacy"}
+
+	tests := []struct {
+		desc  string
+		flags []string
+		calls []string
+	}{
+		{"override", legacy, []string{
+			"/v1/organizations/saas/environments/test/apis/remote-service/revisions/4/deployments?action=deploy&delay=12&env=test&override=true",
+		}},
+		{"delay", append(legacy, "--deploy-delay", "30"), []string{
+			"/v1/organizations/saas/environments/test/apis/remote-service/revisions/4/deployments?action=deploy&delay=30&env=test&override=true",
+		}},
+		{"no override", append(legacy, "--deploy-override=false"), []string{
+			"/v1/organizations/saas/apis/remote-service/revisions/3/deployments?action=undeploy&env=test",
+			"/v1/organizations/saas/environments/test/apis/remote-service/revisions/4/deployments?action=deploy&env=test&override=false",
+		}},
+		{"sequenced rollout", []string{"-o", "gcp", "-t", "token", "--sequenced-rollout"}, []string{
+			"/v1/organizations/gcp/environments/test/apis/remote-service/revisions/4/deployments?override=true&sequencedRollout=true",
+		}},
+	}
+	for _, test := range tests {
+		if err := run(test.flags...); err != nil {
+			t.Fatalf("%s: want no error: %v", test.desc, err)
+		}
+		if !reflect.DeepEqual(test.calls, calls) {
+			t.Errorf("%s: want calls:\n%s\ngot:\n%s", test.desc, strings.Join(test.calls, "\n"), strings.Join(calls, "\n"))
+		}
+	}
+
+	err := run(append(legacy, "--deploy-delay", "-1")...)
+	testutil.ErrorContains(t, err, "--deploy-delay must not be negative")
+	err = run("-o", "gcp", "-t", "token", "--deploy-delay", "5")
+	testutil.ErrorContains(t, err, "--deploy-delay only valid for legacy or OPDK")
+	err = run(append(legacy, "--sequenced-rollout")...)
+	testutil.ErrorContains(t, err, "--sequenced-rollout only valid for hybrid or Apigee X")
+}
+
+func TestAPIProductOptions(t *testing.T) {
+	var products []apiProduct
+	var appProducts []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/apiproducts"):
+			var product apiProduct
+			if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+				t.Fatal(err)
+			}
+			products = append(products, product)
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/apps"):
+			var app apigee.DeveloperApp
+			if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+				t.Fatal(err)
+			}
+			appProducts = append(appProducts, app.APIProducts...)
+			_ = json.NewEncoder(w).Encode(app)
+		}
+	}))
+	defer ts.Close()
+
+	newProvision := func(product productOptions) *provision {
+		rootArgs := &shared.RootArgs{
+			Env:          "test",
+			IsGCPManaged: true,
+			ClientOpts:   &apigee.EdgeClientOptions{Org: "gcp", Env: "test", Auth: &apigee.EdgeAuth{SkipAuth: true}},
+		}
+		setTestUrls(rootArgs, ts.URL)
+		return &provision{RootArgs: rootArgs, product: product}
+	}
+	printf := testutil.Printer("TestAPIProductOptions").Printf
+
+	if err := newProvision(productOptions{quotaInterval: defaultQuotaInterval}).createAPIProduct(printf); err != nil {
+		t.Fatal(err)
+	}
+	p := newProvision(productOptions{name: "acme-envoy", displayName: "Acme Envoy", quota: 100, quotaInterval: "5minute"})
+	if err := p.createAPIProduct(printf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.createDeveloperApp(printf); err != nil {
+		t.Fatal(err)
+	}
+	want := []apiProduct{
+		{Name: "remote-service", DisplayName: "remote-service", Proxies: []string{"remote-service"}},
+		{Name: "acme-envoy", DisplayName: "Acme Envoy", Proxies: []string{"remote-service"}, Quota: "100", QuotaInterval: "5", QuotaTimeUnit: "minute"},
+	}
+	if len(products) != len(want) {
+		t.Fatalf("want %d products created, got %d", len(want), len(products))
+	}
+	for i, product := range products {
+		if product.Name != want[i].Name || product.DisplayName != want[i].DisplayName || !reflect.DeepEqual(product.Proxies, want[i].Proxies) ||
+			product.Quota != want[i].Quota || product.QuotaInterval != want[i].QuotaInterval || product.QuotaTimeUnit != want[i].QuotaTimeUnit {
+			t.Errorf("want product %#v, got %#v", want[i], product)
+		}
+	}
+	if !reflect.DeepEqual(appProducts, []string{"acme-envoy"}) {
+		t.Errorf("want app granted acme-envoy, got %v", appProducts)
+	}
+
+	for _, test := range []struct {
+		product         productOptions
+		intervalChanged bool
+		err             string
+	}{
+		{productOptions{name: "acme envoy", quotaInterval: defaultQuotaInterval}, false, "--product-name must only contain"},
+		{productOptions{quota: -1, quotaInterval: defaultQuotaInterval}, false, "--product-quota must not be negative"},
+		{productOptions{quotaInterval: "1minute"}, true, "--product-quota-interval only valid with --product-quota"},
+		{productOptions{quota: 10, quotaInterval: "1week"}, true, "--product-quota-interval must be a number followed by"},
+	} {
+		testutil.ErrorContains(t, test.product.validate(test.intervalChanged), test.err)
+	}
+}
+
+func TestCredentialOptions(t *testing.T) {
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/apps") {
+			_ = json.NewEncoder(w).Encode(apigee.DeveloperApp{Credentials: []apigee.AppCredential{{ConsumerKey: "key", ConsumerSecret: "secret"}}})
+		}
+	}))
+	defer ts.Close()
+
+	rootArgs := &shared.RootArgs{
+		Env:          "test",
+		IsGCPManaged: true,
+		ClientOpts:   &apigee.EdgeClientOptions{Org: "gcp", Env: "test", Auth: &apigee.EdgeAuth{SkipAuth: true}},
+	}
+	setTestUrls(rootArgs, ts.URL)
+	p := &provision{RootArgs: rootArgs, credential: credentialOptions{developer: "cluster-a@example.com", app: "envoy-cluster-a"}}
+	cred, err := p.createGCPCredential(testutil.Printer("TestCredentialOptions").Printf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Key != "key" {
+		t.Errorf("want credential of the app, got %v", cred)
+	}
+	want := []string{
+		"POST /v1/organizations/gcp/developers",
+		"POST /v1/organizations/gcp/developers/cluster-a@example.com/apps",
+	}
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("want calls %v, got %v", want, calls)
+	}
+
+	for _, test := range []struct {
+		p   *provision
+		err string
+	}{
+		{&provision{RootArgs: &shared.RootArgs{}, credential: credentialOptions{app: "a"}}, "--developer and --app only valid for hybrid"},
+		{&provision{RootArgs: rootArgs, useAppGroup: true, credential: credentialOptions{developer: "a@example.com"}}, "--developer can't be combined with --use-appgroup"},
+		{&provision{RootArgs: rootArgs, credential: credentialOptions{developer: "cluster-a"}}, "--developer must be an email"},
+		{&provision{RootArgs: rootArgs, credential: credentialOptions{app: "cluster a"}}, "--app must only contain"},
+	} {
+		testutil.ErrorContains(t, test.p.credential.validate(test.p), test.err)
+	}
+}
+
+func TestCredentialScopes(t *testing.T) {
+	var appScopes, productScopes, credScopes []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/apps"):
+			var app apigee.DeveloperApp
+			if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+				t.Fatal(err)
+			}
+			appScopes = app.Scopes
+			_ = json.NewEncoder(w).Encode(apigee.DeveloperApp{Credentials: []apigee.AppCredential{
+				{ConsumerKey: "key", ConsumerSecret: "secret", Scopes: credScopes},
+			}})
+		case strings.HasSuffix(r.URL.Path, "/apiproducts"):
+			var product apiProduct
+			if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
+				t.Fatal(err)
+			}
+			productScopes = product.Scopes
+		}
+	}))
+	defer ts.Close()
+
+	rootArgs := &shared.RootArgs{
+		Env:          "test",
+		IsGCPManaged: true,
+		ClientOpts:   &apigee.EdgeClientOptions{Org: "gcp", Env: "test", Auth: &apigee.EdgeAuth{SkipAuth: true}},
+	}
+	setTestUrls(rootArgs, ts.URL)
+	scopes := []string{"read", "write"}
+	p := &provision{RootArgs: rootArgs, credential: credentialOptions{scopes: scopes}}
+	if err := p.createAPIProduct(testutil.Printer("TestCredentialScopes").Printf); err != nil {
+		t.Fatal(err)
+	}
+	credScopes = []string{"write", "read"}
+	print := testutil.Printer("TestCredentialScopes")
+	if _, err := p.createGCPCredential(print.Printf); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(scopes, productScopes) || !reflect.DeepEqual(scopes, appScopes) {
+		t.Errorf("want product and app scopes %v, got %v and %v", scopes, productScopes, appScopes)
+	}
+	print.Check(t, []string{"creating credential...", "app remote-service created"})
+
+	// existing credential of other scopes
+	credScopes = []string{"read"}
+	print = testutil.Printer("TestCredentialScopes")
+	if _, err := p.createGCPCredential(print.Printf); err != nil {
+		t.Fatal(err)
+	}
+	checkContains(t, print.Prints, "warning: credential of app remote-service has scopes read, not --credential-scopes read,write")
+
+	testutil.ErrorContains(t, credentialOptions{scopes: []string{"a b"}}.validate(p), "--credential-scopes must not be empty or contain spaces")
+	testutil.ErrorContains(t, credentialOptions{scopes: scopes}.validate(&provision{RootArgs: &shared.RootArgs{}}),
+		"--credential-scopes only valid for hybrid")
+}
+
+func TestCredentialExpiresIn(t *testing.T) {
+	var keyExpiresIn int64
+	var creds []apigee.AppCredential
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/apps") {
+			var app apigee.DeveloperApp
+			if err := json.NewDecoder(r.Body).Decode(&app); err != nil {
+				t.Fatal(err)
+			}
+			keyExpiresIn = app.KeyExpiresIn
+			_ = json.NewEncoder(w).Encode(apigee.DeveloperApp{Credentials: creds})
+		}
+	}))
+	defer ts.Close()
+
+	rootArgs := &shared.RootArgs{
+		Env:          "test",
+		IsGCPManaged: true,
+		ClientOpts:   &apigee.EdgeClientOptions{Org: "gcp", Env: "test", Auth: &apigee.EdgeAuth{SkipAuth: true}},
+	}
+	setTestUrls(rootArgs, ts.URL)
+	p := &provision{RootArgs: rootArgs, credential: credentialOptions{expiresIn: 2 * time.Hour}}
+	expiresAt := time.Now().Add(2 * time.Hour).Truncate(time.Second)
+	creds = []apigee.AppCredential{
+		{ConsumerKey: "expired", ConsumerSecret: "secret", ExpiresAt: apigee.Timestamp{Time: time.Now().Add(-time.Hour)}},
+		{ConsumerKey: "key", ConsumerSecret: "secret", ExpiresAt: apigee.Timestamp{Time: expiresAt}},
+	}
+	print := testutil.Printer("TestCredentialExpiresIn")
+	cred, err := p.createGCPCredential(print.Printf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyExpiresIn != 7200000 {
+		t.Errorf("want keyExpiresIn 7200000, got %d", keyExpiresIn)
+	}
+	if cred.Key != "key" {
+		t.Errorf("want unexpired credential key, got %s", cred.Key)
+	}
+	checkContains(t, print.Prints, "credential of app remote-service expires at "+expiresAt.Format(time.RFC3339))
+
+	// keys without expiry have an expiresAt of -1
+	creds = []apigee.AppCredential{{ConsumerKey: "key", ConsumerSecret: "secret", ExpiresAt: apigee.Timestamp{Time: time.Unix(0, -int64(time.Millisecond))}}}
+	print = testutil.Printer("TestCredentialExpiresIn")
+	if _, err := p.createGCPCredential(print.Printf); err != nil {
+		t.Fatal(err)
+	}
+	checkContains(t, print.Prints, "warning: credential of app remote-service doesn't expire, not --expires-in 2h0m0s")
+
+	creds = creds[:0]
+	_, err = p.createGCPCredential(testutil.Printer("TestCredentialExpiresIn").Printf)
+	testutil.ErrorContains(t, err, "app remote-service has no valid credential, use --rotate-key to create one")
+
+	testutil.ErrorContains(t, credentialOptions{expiresIn: -time.Hour}.validate(p), "--expires-in must not be negative")
+	testutil.ErrorContains(t, credentialOptions{expiresIn: time.Hour}.validate(&provision{RootArgs: &shared.RootArgs{}}),
+		"--expires-in only valid for hybrid")
+	testutil.ErrorContains(t, credentialOptions{expiresIn: time.Hour}.validate(&provision{RootArgs: rootArgs, rotation: keyRotation{enabled: true}}),
+		"--expires-in can't be combined with --rotate-key or --skip-credential")
+}
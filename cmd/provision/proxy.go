@@ -16,13 +16,19 @@ package provision
 
 import (
 	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/apigee/apigee-remote-service-cli/apigee"
 	"github.com/apigee/apigee-remote-service-cli/proxies"
@@ -32,38 +38,125 @@ import (
 
 type proxyModFunc func(name string) error
 
-// returns filename of zipped proxy
-func getCustomizedProxy(tempDir, name string, modFunc proxyModFunc) (string, error) {
-	if err := proxies.RestoreAsset(tempDir, name); err != nil {
-		return "", errors.Wrapf(err, "restoring asset %s", name)
-	}
+// bundleDigestRE matches the bundle digest stamped in a proxy's description
+var bundleDigestRE = regexp.MustCompile(`bundle sha256:([0-9a-f]{64})`)
+
+var descriptionRE = regexp.MustCompile(`<Description>[^<]*</Description>`)
+
+// deployPollInterval is the time between polls of a deployment with --wait, var for tests
+var deployPollInterval = 5 * time.Second
+
+// returns filename of zipped proxy and the digest of its bundle: the embedded bundle name
+// or, if set, the local bundle in sourceDir. The proxy's description is stamped with the digest.
+func getCustomizedProxy(tempDir, name, sourceDir string, modFunc proxyModFunc) (string, string, error) {
 	zipFile := filepath.Join(tempDir, name)
-	if modFunc == nil {
-		return zipFile, nil
+	var digest string
+	if sourceDir != "" {
+		var err error
+		if digest, err = dirDigest(filepath.Join(sourceDir, "apiproxy")); err != nil {
+			return "", "", errors.Wrapf(err, "reading proxy bundle %s", sourceDir)
+		}
+		if err := zipDir(sourceDir, zipFile); err != nil {
+			return "", "", errors.Wrapf(err, "zipping dir %s to file %s", sourceDir, zipFile)
+		}
+	} else {
+		if err := proxies.Verify(name); err != nil {
+			return "", "", errors.Wrap(err, "verifying embedded proxy")
+		}
+		if err := proxies.RestoreAsset(tempDir, name); err != nil {
+			return "", "", errors.Wrapf(err, "restoring asset %s", name)
+		}
+		digest, _ = proxies.Digest(name)
 	}
 
 	extractDir, err := ioutil.TempDir(tempDir, "proxy")
 	if err != nil {
-		return "", errors.Wrap(err, "creating temp dir")
+		return "", "", errors.Wrap(err, "creating temp dir")
 	}
 	if err := unzipFile(zipFile, extractDir); err != nil {
-		return "", errors.Wrapf(err, "extracting %s to %s", zipFile, extractDir)
+		return "", "", errors.Wrapf(err, "extracting %s to %s", zipFile, extractDir)
 	}
 
-	if err := modFunc(filepath.Join(extractDir, "apiproxy")); err != nil {
-		return "", err
+	proxyDir := filepath.Join(extractDir, "apiproxy")
+	if modFunc != nil {
+		if err := modFunc(proxyDir); err != nil {
+			return "", "", err
+		}
+	}
+	if err := stampBundleDigest(proxyDir, digest); err != nil {
+		return "", "", err
 	}
 
 	// write zip
 	customizedZip := filepath.Join(tempDir, "customized.zip")
 	if err := zipDir(extractDir, customizedZip); err != nil {
-		return "", errors.Wrapf(err, "zipping dir %s to file %s", extractDir, customizedZip)
+		return "", "", errors.Wrapf(err, "zipping dir %s to file %s", extractDir, customizedZip)
 	}
 
-	return customizedZip, nil
+	return customizedZip, digest, nil
+}
+
+// dirDigest returns the SHA-256 digest (hex) of the paths and contents of the files in dir
+func dirDigest(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), len(data))
+		_, err = h.Write(data)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
-func (p *provision) checkAndDeployProxy(name, file string, printf shared.FormatFn) error {
+// stampBundleDigest records the digest of the bundle in the description of the proxy
+func stampBundleDigest(proxyDir, digest string) error {
+	files, err := filepath.Glob(filepath.Join(proxyDir, "*.xml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "reading file %s", file)
+		}
+		loc := descriptionRE.FindIndex(data)
+		if loc == nil {
+			continue
+		}
+		description := fmt.Sprintf("<Description>%s (bundle sha256:%s)</Description>",
+			strings.TrimSuffix(strings.TrimPrefix(string(data[loc[0]:loc[1]]), "<Description>"), "</Description>"), digest)
+		content := string(data[:loc[0]]) + description + string(data[loc[1]:])
+		if err := ioutil.WriteFile(file, []byte(content), 0); err != nil {
+			return errors.Wrapf(err, "writing file %s", file)
+		}
+	}
+	return nil
+}
+
+// bundleDigest returns the bundle digest stamped in a proxy description, if any
+func bundleDigest(description string) string {
+	if m := bundleDigestRE.FindStringSubmatch(description); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// checkAndDeployProxy deploys a new revision of the proxy from file unless the deployed
+// revision was imported from a bundle with the same digest, --force-proxy-update always deploys
+func (p *provision) checkAndDeployProxy(name, digest, file string, printf shared.FormatFn) error {
 	printf("checking if proxy %s deployment exists...", name)
 	var oldRev *apigee.Revision
 	var err error
@@ -76,11 +169,24 @@ func (p *provision) checkAndDeployProxy(name, file string, printf shared.FormatF
 		return err
 	}
 	if oldRev != nil {
-		if p.forceProxyInstall {
+		if p.forceProxyUpdate {
 			printf("replacing proxy %s revision %s in %s", name, oldRev, p.Env)
 		} else {
-			printf("proxy %s revision %s already deployed to %s", name, oldRev, p.Env)
-			return nil
+			deployed, _, err := p.ApigeeClient.Proxies.GetRevision(name, *oldRev)
+			if err != nil {
+				return errors.Wrapf(err, "retrieving proxy %s revision %s", name, oldRev)
+			}
+			got := bundleDigest(deployed.Description)
+			if got == digest {
+				printf("proxy %s revision %s already deployed to %s (bundle sha256:%s)", name, oldRev, p.Env, got)
+				p.deployed = append(p.deployed, shared.DeployedProxy{Name: name, Revision: int(*oldRev)})
+				return nil
+			}
+			if got == "" {
+				got = "unknown"
+			}
+			printf("proxy %s revision %s in %s is from bundle sha256:%s, updating to sha256:%s",
+				name, oldRev, p.Env, got, digest)
 		}
 	}
 
@@ -104,7 +210,7 @@ func (p *provision) importAndDeployProxy(name string, proxy *apigee.Proxy, oldRe
 
 	// create a new client to avoid dumping the proxy binary to stdout during Import
 	noDebugClient := p.ApigeeClient
-	if p.Verbose {
+	if p.TraceHTTP {
 		opts := *p.ClientOpts
 		opts.Debug = false
 		var err error
@@ -122,8 +228,14 @@ func (p *provision) importAndDeployProxy(name string, proxy *apigee.Proxy, oldRe
 	if err != nil {
 		return errors.Wrapf(err, "importing proxy %s", name)
 	}
-
-	if oldRev != nil && !p.IsGCPManaged { // it's not necessary to undeploy first with GCP
+	change := &proxyChange{}
+	p.onRollbackProxy(name, proxy, oldRev, newRev, change)
+
+	// it's not necessary to undeploy first with GCP, Edge replaces the deployed revision
+	// after the delay when overriding
+	if oldRev != nil && !p.IsGCPManaged && p.deployOptions.Override {
+		printf("proxy %s revision %d on env %s will be replaced after %ds", name, oldRev, p.Env, p.deployOptions.Delay)
+	} else if oldRev != nil && !p.IsGCPManaged {
 		printf("undeploying proxy %s revision %d on env %s...",
 			name, oldRev, p.Env)
 		_, res, err = p.ApigeeClient.Proxies.Undeploy(name, p.Env, *oldRev)
@@ -133,55 +245,98 @@ func (p *provision) importAndDeployProxy(name string, proxy *apigee.Proxy, oldRe
 		if err != nil {
 			return errors.Wrapf(err, "undeploying proxy %s", name)
 		}
+		change.undeployedOld = true
 	}
 
 	if !p.IsGCPManaged {
-		cache := apigee.Cache{
-			Name: cacheName,
-		}
-		res, err = p.ApigeeClient.CacheService.Create(cache)
-		if err != nil && (res == nil || res.StatusCode != http.StatusConflict) { // http.StatusConflict == already exists
+		cacheName := p.resources.cacheName()
+		if err := p.tolerate("creating cache "+cacheName, p.createCache(cacheName, printf), printf); err != nil {
 			return err
 		}
-		if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusConflict {
-			return fmt.Errorf("creating cache %s, status code: %v", cacheName, res.StatusCode)
-		}
-		if res.StatusCode == http.StatusConflict {
-			printf("cache %s already exists", cacheName)
-		} else {
-			printf("cache %s created", cacheName)
-		}
 	}
 
 	printf("deploying proxy %s revision %d to env %s...", name, newRev, p.Env)
-	_, res, err = p.ApigeeClient.Proxies.Deploy(name, p.Env, newRev)
+	_, res, err = p.ApigeeClient.Proxies.DeployWithOptions(name, p.Env, newRev, p.deployOptions)
 	if res != nil {
 		defer res.Body.Close()
 	}
 	if err != nil {
 		return errors.Wrapf(err, "deploying proxy %s", name)
 	}
+	change.deployedNew = true
+	p.deployed = append(p.deployed, shared.DeployedProxy{Name: name, Revision: int(newRev)})
 
+	if p.wait && !p.dryRun {
+		return p.tolerate("waiting for proxy "+name, p.waitForDeployment(name, newRev, printf), printf)
+	}
 	return nil
 }
 
+// createCache creates the cache of the proxy if it doesn't exist
+func (p *provision) createCache(cacheName string, printf shared.FormatFn) error {
+	cache := apigee.Cache{
+		Name: cacheName,
+	}
+	res, err := p.ApigeeClient.CacheService.Create(cache)
+	if err != nil && (res == nil || res.StatusCode != http.StatusConflict) { // http.StatusConflict == already exists
+		return err
+	}
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusConflict {
+		return fmt.Errorf("creating cache %s, status code: %v", cacheName, res.StatusCode)
+	}
+	if res.StatusCode == http.StatusConflict {
+		printf("cache %s already exists", cacheName)
+	} else {
+		printf("cache %s created", cacheName)
+		p.onRollback("cache "+cacheName, func(printf shared.FormatFn) error {
+			resp, err := p.ApigeeClient.CacheService.Delete(cacheName)
+			return deleted("cache", cacheName, resp, err, printf)
+		})
+	}
+	return nil
+}
+
+// waitForDeployment polls the deployment of the revision until it's deployed,
+// failed or --timeout passed
+func (p *provision) waitForDeployment(name string, rev apigee.Revision, printf shared.FormatFn) error {
+	deadline := time.Now().Add(p.timeout)
+	progress := ""
+	for {
+		deployment, res, err := p.ApigeeClient.Proxies.GetRevisionDeployment(name, rev)
+		if res != nil {
+			res.Body.Close()
+		}
+		if err != nil {
+			return errors.Wrapf(err, "retrieving deployment of proxy %s revision %d", name, rev)
+		}
+		if deployment.Failed() {
+			return fmt.Errorf("deployment of proxy %s revision %d to env %s failed: state %s", name, rev, p.Env, deployment.State)
+		}
+		if deployment.IsDeployed() {
+			printf("proxy %s revision %d deployed to env %s", name, rev, p.Env)
+			return nil
+		}
+
+		status := deployment.State
+		if deployed, total := deployment.MessageProcessors(); total > 0 {
+			status = fmt.Sprintf("%d of %d message processors", deployed, total)
+		}
+		if status != progress {
+			printf("waiting for proxy %s revision %d: %s", name, rev, status)
+			progress = status
+		}
+		if time.Now().Add(deployPollInterval).After(deadline) {
+			return fmt.Errorf("proxy %s revision %d not deployed to env %s after %s (%s)", name, rev, p.Env, p.timeout, status)
+		}
+		time.Sleep(deployPollInterval)
+	}
+}
+
 // ensures that there's a remote-proxy API product
 func (p *provision) createAPIProduct(verbosef shared.FormatFn) error {
-	const removeServiceName = "remote-service"
-
 	// create product
-	product := apiProduct{
-		Name:         removeServiceName,
-		DisplayName:  removeServiceName,
-		ApprovalType: "auto",
-		Attributes: []attribute{
-			{Name: "access", Value: "private"},
-		},
-		Description:  removeServiceName + " access",
-		APIResources: []string{"/verifyApiKey", "/token"},
-		Environments: []string{p.Env},
-		Proxies:      []string{removeServiceName},
-	}
+	name := p.product.productName()
+	product := p.apiProductSpec()
 
 	req, err := p.ApigeeClient.NewRequestNoEnv(http.MethodPost, apiProductsPath, product)
 	if err != nil {
@@ -192,13 +347,51 @@ func (p *provision) createAPIProduct(verbosef shared.FormatFn) error {
 		if res.StatusCode != http.StatusConflict { // exists
 			return err
 		}
-		verbosef("product %s already exists", removeServiceName)
+		verbosef("product %s already exists", name)
+		return nil
+	}
+	if len(p.envs) <= 1 { // shared by the environments of a multi-environment run
+		p.onRollback("product "+name, p.deleteAPIProduct)
 	}
 
 	return nil
 
 }
 
+// apiProductSpec is the remote-service API product created by provision
+func (p *provision) apiProductSpec() apiProduct {
+	name := p.product.productName()
+	product := apiProduct{
+		Name:         name,
+		DisplayName:  p.product.displayName,
+		ApprovalType: "auto",
+		Attributes: []attribute{
+			{Name: "access", Value: "private"},
+		},
+		Description:  name + " access",
+		APIResources: []string{"/verifyApiKey", "/token"},
+		Environments: p.productEnvs(),
+		Proxies:      []string{authProxyName},
+		Scopes:       p.credential.scopes, // a credential may only have scopes of its products
+	}
+	if product.DisplayName == "" {
+		product.DisplayName = name
+	}
+	if p.product.quota > 0 {
+		product.Quota = strconv.Itoa(p.product.quota)
+		product.QuotaInterval, product.QuotaTimeUnit = p.product.interval()
+	}
+	return product
+}
+
+// productEnvs are the environments of the API product, all provisioned environments
+func (p *provision) productEnvs() []string {
+	if len(p.envs) > 1 {
+		return p.envs
+	}
+	return []string{p.Env}
+}
+
 func unzipFile(src, dest string) error {
 	r, err := zip.OpenReader(src)
 	if err != nil {
@@ -302,14 +495,65 @@ func zipDir(source, file string) error {
 }
 
 type apiProduct struct {
-	Name         string      `json:"name,omitempty"`
-	DisplayName  string      `json:"displayName,omitempty"`
-	ApprovalType string      `json:"approvalType,omitempty"`
-	Attributes   []attribute `json:"attributes,omitempty"`
-	Description  string      `json:"description,omitempty"`
-	APIResources []string    `json:"apiResources,omitempty"`
-	Environments []string    `json:"environments,omitempty"`
-	Proxies      []string    `json:"proxies,omitempty"`
+	Name          string      `json:"name,omitempty"`
+	DisplayName   string      `json:"displayName,omitempty"`
+	ApprovalType  string      `json:"approvalType,omitempty"`
+	Attributes    []attribute `json:"attributes,omitempty"`
+	Description   string      `json:"description,omitempty"`
+	APIResources  []string    `json:"apiResources,omitempty"`
+	Environments  []string    `json:"environments,omitempty"`
+	Proxies       []string    `json:"proxies,omitempty"`
+	Scopes        []string    `json:"scopes,omitempty"`
+	Quota         string      `json:"quota,omitempty"`
+	QuotaInterval string      `json:"quotaInterval,omitempty"`
+	QuotaTimeUnit string      `json:"quotaTimeUnit,omitempty"`
+}
+
+// productOptions customize the remote-service API product
+type productOptions struct {
+	name          string
+	displayName   string
+	quota         int
+	quotaInterval string // eg. 1hour
+}
+
+const defaultQuotaInterval = "1hour"
+
+var (
+	productNameRE   = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+	quotaIntervalRE = regexp.MustCompile(`^([1-9][0-9]*)(minute|hour|day|month)$`)
+)
+
+func (o productOptions) validate(intervalChanged bool) error {
+	if o.name != "" && !productNameRE.MatchString(o.name) {
+		return fmt.Errorf("--product-name must only contain letters, numbers, '.', '_' and '-'")
+	}
+	if o.quota < 0 {
+		return fmt.Errorf("--product-quota must not be negative")
+	}
+	if intervalChanged && o.quota == 0 {
+		return fmt.Errorf("--product-quota-interval only valid with --product-quota")
+	}
+	if !quotaIntervalRE.MatchString(o.quotaInterval) {
+		return fmt.Errorf("--product-quota-interval must be a number followed by minute, hour, day or month (eg. %s)", defaultQuotaInterval)
+	}
+	return nil
+}
+
+func (o productOptions) productName() string {
+	if o.name == "" {
+		return apiProductName
+	}
+	return o.name
+}
+
+// interval splits the quota interval into its number and time unit
+func (o productOptions) interval() (string, string) {
+	m := quotaIntervalRE.FindStringSubmatch(o.quotaInterval)
+	if m == nil {
+		return "", ""
+	}
+	return m[1], m[2]
 }
 
 type attribute struct {
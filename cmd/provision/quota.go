@@ -0,0 +1,250 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-golib/product"
+	"github.com/apigee/apigee-remote-service-golib/quota"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+var quotaPollInterval = time.Second
+
+type quotaExercise struct {
+	*provision
+	url     string
+	apiKey  string
+	jwt     string
+	app     string
+	product string
+	calls   int
+	wait    time.Duration
+}
+
+// QuotaCmd returns the quota command
+func QuotaCmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "quota",
+		Short: "Work with the quotas enforced by Apigee Remote Service",
+		Args:  cobra.NoArgs,
+	}
+
+	c.AddCommand(cmdQuotaExercise(rootArgs, printf))
+
+	return c
+}
+
+func cmdQuotaExercise(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	q := &quotaExercise{provision: &provision{RootArgs: rootArgs}}
+
+	c := &cobra.Command{
+		Use:   "exercise",
+		Short: "Call an Envoy endpoint and check that the quota counter advances",
+		Long: `The exercise command makes authenticated calls to an endpoint protected by Envoy and the
+Apigee Remote Service adapter, then checks through the remote-service proxy's /quotas API that the
+quota counter of the app and API product advanced by the number of calls let through. Requires the
+adapter config file (--config).`,
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if rootArgs.ConfigPath == "" {
+				return fmt.Errorf("--config is required")
+			}
+			if (q.apiKey == "") == (q.jwt == "") {
+				return fmt.Errorf("exactly one of --api-key or --jwt is required")
+			}
+			if q.calls < 1 {
+				return fmt.Errorf("--calls must be at least 1")
+			}
+			return rootArgs.Resolve(true, false)
+		},
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+			return q.run(printf)
+		},
+	}
+
+	c.Flags().StringVarP(&q.url, "url", "", "", "URL of the endpoint protected by Envoy")
+	c.Flags().StringVarP(&q.apiKey, "api-key", "", "", "API key of the app, sent as the x-api-key header")
+	c.Flags().StringVarP(&q.jwt, "jwt", "", "", "JWT of the app, sent as a bearer token")
+	c.Flags().StringVarP(&q.app, "app", "", "", "name of the app the credential belongs to")
+	c.Flags().StringVarP(&q.product, "product", "", "", "name of the API product with the quota")
+	c.Flags().IntVarP(&q.calls, "calls", "", 10, "number of calls to make")
+	c.Flags().DurationVarP(&q.wait, "wait", "", 30*time.Second,
+		"how long to wait for the adapter to sync the quota counter")
+
+	_ = c.MarkFlagRequired("url")
+	_ = c.MarkFlagRequired("app")
+	_ = c.MarkFlagRequired("product")
+
+	return c
+}
+
+func (q *quotaExercise) run(printf shared.FormatFn) error {
+	client, err := q.createAuthorizedClient(q.ServerConfig)
+	if err != nil {
+		return errors.Wrap(err, "creating client")
+	}
+
+	req, err := q.quotaRequest(client)
+	if err != nil {
+		return err
+	}
+	before, err := q.getQuota(client, req)
+	if err != nil {
+		return err
+	}
+	printf("quota %s: %d of %d used", req.Identifier, before.Used, before.Allowed)
+
+	admitted, limited, rejected := q.exercise()
+	printf("%d call(s) to %s: %d let through, %d over quota, %d rejected", q.calls, q.url, admitted, limited, rejected)
+	if admitted+limited == 0 {
+		return fmt.Errorf("no calls were authorized, check --api-key or --jwt")
+	}
+
+	want := before.Used + int64(admitted)
+	if want > before.Allowed {
+		want = before.Allowed
+	}
+	var after *quota.Result
+	deadline := time.Now().Add(q.wait)
+	for {
+		if after, err = q.getQuota(client, req); err != nil {
+			return err
+		}
+		if after.ExpiryTime != before.ExpiryTime {
+			return fmt.Errorf("quota %s interval ended during the exercise, run it again", req.Identifier)
+		}
+		if after.Used >= want || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(quotaPollInterval)
+	}
+
+	printf("quota %s: %d of %d used", req.Identifier, after.Used, after.Allowed)
+	if after.Used < want {
+		return fmt.Errorf("quota %s advanced by %d, want %d", req.Identifier, after.Used-before.Used, want-before.Used)
+	}
+	printf("quota counter advanced as expected")
+	if limited > 0 {
+		printf("quota enforced: %d call(s) were rejected over quota", limited)
+	}
+	return nil
+}
+
+// quotaRequest returns a request for the quota of the app and product that
+// doesn't consume any of it
+func (q *quotaExercise) quotaRequest(client *http.Client) (quota.Request, error) {
+	productsURL := fmt.Sprintf(productsURLFormat, q.RemoteServiceProxyURL)
+	res, err := client.Get(productsURL)
+	if err != nil {
+		return quota.Request{}, errors.Wrapf(err, "retrieving products from %s", productsURL)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return quota.Request{}, fmt.Errorf("retrieving products from %s: %s", productsURL, res.Status)
+	}
+	var products product.APIResponse
+	if err := json.NewDecoder(res.Body).Decode(&products); err != nil {
+		return quota.Request{}, errors.Wrapf(err, "decoding products from %s", productsURL)
+	}
+
+	for _, p := range products.APIProducts {
+		if p.Name != q.product {
+			continue
+		}
+		allow, err := strconv.ParseInt(p.QuotaLimit, 10, 64)
+		if err != nil {
+			return quota.Request{}, fmt.Errorf("product %s has no quota", q.product)
+		}
+		interval, err := strconv.ParseInt(p.QuotaInterval, 10, 64)
+		if err != nil {
+			return quota.Request{}, fmt.Errorf("product %s has no quota interval", q.product)
+		}
+		return quota.Request{
+			Identifier: fmt.Sprintf("%s-%s", q.app, q.product), // as the adapter identifies it
+			Interval:   interval,
+			Allow:      allow,
+			TimeUnit:   p.QuotaTimeUnit,
+		}, nil
+	}
+	return quota.Request{}, fmt.Errorf("product %s not found", q.product)
+}
+
+func (q *quotaExercise) getQuota(client *http.Client, req quota.Request) (*quota.Result, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	quotasURL := fmt.Sprintf(quotasURLFormat, q.RemoteServiceProxyURL)
+	res, err := client.Post(quotasURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving quota from %s", quotasURL)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("retrieving quota from %s: %s", quotasURL, res.Status)
+	}
+	result := &quota.Result{}
+	if err := json.NewDecoder(res.Body).Decode(result); err != nil {
+		return nil, errors.Wrapf(err, "decoding quota from %s", quotasURL)
+	}
+	return result, nil
+}
+
+// exercise calls the endpoint, counting the calls let through, over quota (429)
+// and rejected otherwise (401, 403 or failed)
+func (q *quotaExercise) exercise() (admitted, limited, rejected int) {
+	client := &http.Client{Timeout: 10 * time.Second, Transport: q.RuntimeTransport(false)}
+	for i := 0; i < q.calls; i++ {
+		req, err := http.NewRequest(http.MethodGet, q.url, nil)
+		if err != nil {
+			rejected++
+			continue
+		}
+		if q.apiKey != "" {
+			req.Header.Set("x-api-key", q.apiKey)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+q.jwt)
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			rejected++
+			continue
+		}
+		_, _ = io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		switch res.StatusCode {
+		case http.StatusTooManyRequests:
+			limited++
+		case http.StatusUnauthorized, http.StatusForbidden:
+			rejected++
+		default:
+			admitted++
+		}
+	}
+	return
+}
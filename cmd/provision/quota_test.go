@@ -0,0 +1,142 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/apigee/apigee-remote-service-golib/product"
+	"github.com/apigee/apigee-remote-service-golib/quota"
+)
+
+func TestQuotaExercise(t *testing.T) {
+	var mu sync.Mutex
+	used, counting := int64(0), true
+	m := http.NewServeMux()
+	m.HandleFunc("/remote-service/products", func(w http.ResponseWriter, r *http.Request) {
+		if key, secret, ok := r.BasicAuth(); !ok || key != "fake-key" || secret != "fake-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(product.APIResponse{APIProducts: []product.APIProduct{
+			{Name: "limited", QuotaLimit: "5", QuotaInterval: "1", QuotaTimeUnit: "minute"},
+			{Name: "unlimited"},
+		}})
+	})
+	m.HandleFunc("/remote-service/quotas", func(w http.ResponseWriter, r *http.Request) {
+		var req quota.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Identifier != "app-limited" || req.Weight != 0 {
+			t.Errorf("unexpected quota request %v: %v", req, err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		_ = json.NewEncoder(w).Encode(quota.Result{Allowed: req.Allow, Used: used, ExpiryTime: 100})
+	})
+	m.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if used >= 5 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if counting {
+			used++
+		}
+	})
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	config := []byte(`tenant:
+  internal_api: https://istioservices.apigee.net/edgemicro
+  remote_service_api: ` + ts.URL + `/remote-service
+  org_name: org
+  env_name: test
+  key: fake-key
+  secret: fake-secret`)
+	tmpFile, err := ioutil.TempFile("", "config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(config); err != nil {
+		t.Fatal(err)
+	}
+
+	quotaPollInterval = 1
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestQuotaExercise")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"quota", "exercise"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, QuotaCmd(rootArgs, print.Printf))
+		return print, rootCmd.Execute()
+	}
+	flags := []string{"-c", tmpFile.Name(), "--url", ts.URL + "/api", "--app", "app", "--product", "limited"}
+
+	print, err := run(append(flags, "--api-key", "key", "--calls", "3")...)
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{
+		"quota app-limited: 0 of 5 used",
+		"3 call(s) to " + ts.URL + "/api: 3 let through, 0 over quota, 0 rejected",
+		"quota app-limited: 3 of 5 used",
+		"quota counter advanced as expected",
+	})
+
+	// the quota is exhausted
+	print, err = run(append(flags, "--api-key", "key", "--calls", "4")...)
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{
+		"quota app-limited: 3 of 5 used",
+		"4 call(s) to " + ts.URL + "/api: 2 let through, 2 over quota, 0 rejected",
+		"quota app-limited: 5 of 5 used",
+		"quota counter advanced as expected",
+		"quota enforced: 2 call(s) were rejected over quota",
+	})
+
+	// the counter doesn't advance
+	used, counting = 0, false
+	_, err = run(append(flags, "--api-key", "key", "--calls", "2", "--wait", "10ms")...)
+	testutil.ErrorContains(t, err, "quota app-limited advanced by 0, want 2")
+
+	_, err = run(append(flags, "--api-key", "bad")...)
+	testutil.ErrorContains(t, err, "no calls were authorized, check --api-key or --jwt")
+
+	_, err = run("-c", tmpFile.Name(), "--url", ts.URL+"/api", "--app", "app", "--product", "unlimited", "--api-key", "key")
+	testutil.ErrorContains(t, err, "product unlimited has no quota")
+	_, err = run("-c", tmpFile.Name(), "--url", ts.URL+"/api", "--app", "app", "--product", "missing", "--api-key", "key")
+	testutil.ErrorContains(t, err, "product missing not found")
+
+	_, err = run(append(flags, "--api-key", "key", "--jwt", "jwt")...)
+	testutil.ErrorContains(t, err, "exactly one of --api-key or --jwt is required")
+	_, err = run("--url", ts.URL+"/api", "--app", "app", "--product", "limited", "--api-key", "key")
+	testutil.ErrorContains(t, err, "--config is required")
+}
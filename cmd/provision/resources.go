@@ -0,0 +1,81 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// resourceNames override the names of the kvm and cache of the remote-service
+// proxy, so installations in the same environment don't share them
+type resourceNames struct {
+	kvm   string
+	cache string
+}
+
+func (r resourceNames) validate() error {
+	if r.kvm != "" && !productNameRE.MatchString(r.kvm) {
+		return fmt.Errorf("--kvm-name must only contain letters, numbers, '.', '_' and '-'")
+	}
+	if r.cache != "" && !productNameRE.MatchString(r.cache) {
+		return fmt.Errorf("--cache-name must only contain letters, numbers, '.', '_' and '-'")
+	}
+	return nil
+}
+
+func (r resourceNames) kvmName() string {
+	if r.kvm == "" {
+		return defaultKVMName
+	}
+	return r.kvm
+}
+
+func (r resourceNames) cacheName() string {
+	if r.cache == "" {
+		return defaultCacheName
+	}
+	return r.cache
+}
+
+// rewriteResourceNames makes the policies of the proxy use the kvm and cache
+// of the given names
+func (p *provision) rewriteResourceNames(proxyDir string) error {
+	if p.resources.kvm == "" && p.resources.cache == "" {
+		return nil
+	}
+	replacer := strings.NewReplacer(
+		`mapIdentifier="`+defaultKVMName+`"`, `mapIdentifier="`+p.resources.kvmName()+`"`,
+		"<CacheResource>"+defaultCacheName+"</CacheResource>", "<CacheResource>"+p.resources.cacheName()+"</CacheResource>",
+	)
+	files, err := filepath.Glob(filepath.Join(proxyDir, "policies", "*.xml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "reading file %s", file)
+		}
+		if err := ioutil.WriteFile(file, []byte(replacer.Replace(string(data))), 0); err != nil {
+			return errors.Wrapf(err, "writing file %s", file)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestResourceNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resources")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var names []string
+	p := &provision{resources: resourceNames{kvm: "envoy-a", cache: "envoy-a-cache"}}
+	_, _, err = getCustomizedProxy(dir, legacyAuthProxyZip, "", func(proxyDir string) error {
+		if err := p.rewriteResourceNames(proxyDir); err != nil {
+			return err
+		}
+		files, err := filepath.Glob(filepath.Join(proxyDir, "policies", "*.xml"))
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			content := string(data)
+			if strings.Contains(content, `mapIdentifier="remote-service"`) || strings.Contains(content, "<CacheResource>remote-service<") {
+				t.Errorf("want names replaced in %s", filepath.Base(file))
+			}
+			if strings.Contains(content, `mapIdentifier="envoy-a"`) || strings.Contains(content, "<CacheResource>envoy-a-cache<") {
+				names = append(names, filepath.Base(file))
+			}
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 5 {
+		t.Errorf("want the kvm and cache policies rewritten, got %v", names)
+	}
+
+	if got := (resourceNames{}).kvmName(); got != defaultKVMName {
+		t.Errorf("want default kvm name, got %s", got)
+	}
+	testutil.ErrorContains(t, resourceNames{kvm: "a b"}.validate(), "--kvm-name must only contain")
+	testutil.ErrorContains(t, resourceNames{cache: "a/b"}.validate(), "--cache-name must only contain")
+}
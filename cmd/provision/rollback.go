@@ -0,0 +1,94 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// undoStep reverts a change made by the current run
+type undoStep struct {
+	desc string
+	fn   func(printf shared.FormatFn) error
+}
+
+// onRollback records how to revert a change just made, with --rollback-on-error
+func (p *provision) onRollback(desc string, fn func(printf shared.FormatFn) error) {
+	if p.rollbackOnError && !p.dryRun {
+		p.undo = append(p.undo, undoStep{desc: desc, fn: fn})
+	}
+}
+
+// rollback reverts the recorded changes in reverse order and returns cause along
+// with any error reverting them
+func (p *provision) rollback(cause error, printf shared.FormatFn) error {
+	if len(p.undo) == 0 {
+		return cause
+	}
+	printf("provisioning failed, rolling back %d change(s)...", len(p.undo))
+	var errs error
+	for i := len(p.undo) - 1; i >= 0; i-- {
+		step := p.undo[i]
+		printf("reverting %s...", step.desc)
+		if err := step.fn(printf); err != nil {
+			errs = multierr.Append(errs, errors.Wrapf(err, "reverting %s", step.desc))
+		}
+	}
+	p.undo = nil
+	if errs != nil {
+		printf("rollback incomplete, run deprovision to clean up")
+		return multierr.Append(cause, errs)
+	}
+	printf("rollback complete")
+	return cause
+}
+
+// proxyChange tracks the deployments changed after importing a proxy revision
+type proxyChange struct {
+	undeployedOld bool // the previously deployed revision was undeployed
+	deployedNew   bool // the new revision was deployed
+}
+
+// onRollbackProxy records how to revert the import of a new proxy revision and
+// the deployment changes tracked in change at the time of rollback: the new
+// revision is undeployed, the previously deployed one redeployed and the new
+// revision, or the proxy if it didn't exist before, deleted.
+func (p *provision) onRollbackProxy(name string, proxy *apigee.Proxy, oldRev *apigee.Revision, newRev apigee.Revision, change *proxyChange) {
+	p.onRollback("proxy "+name+" revision "+newRev.String(), func(printf shared.FormatFn) error {
+		// redeploying with override replaces the new revision
+		if change.deployedNew && (oldRev == nil || !p.deployOptions.Override) {
+			if _, _, err := p.ApigeeClient.Proxies.Undeploy(name, p.Env, newRev); err != nil {
+				return errors.Wrapf(err, "undeploying proxy %s revision %s", name, newRev)
+			}
+			printf("proxy %s revision %s undeployed from %s", name, newRev, p.Env)
+		}
+		if oldRev != nil && (change.deployedNew || change.undeployedOld) {
+			if _, _, err := p.ApigeeClient.Proxies.DeployWithOptions(name, p.Env, *oldRev, p.deployOptions); err != nil {
+				return errors.Wrapf(err, "redeploying proxy %s revision %s", name, oldRev)
+			}
+			printf("proxy %s revision %s redeployed to %s", name, oldRev, p.Env)
+		}
+
+		if proxy == nil || len(proxy.Revisions) == 0 {
+			_, resp, err := p.ApigeeClient.Proxies.Delete(name)
+			return deleted("proxy", name, resp, err, printf)
+		}
+		_, resp, err := p.ApigeeClient.Proxies.DeleteRevision(name, newRev)
+		return deleted("proxy "+name+" revision", newRev.String(), resp, err, printf)
+	})
+}
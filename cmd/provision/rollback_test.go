@@ -0,0 +1,101 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestRollbackOnError(t *testing.T) {
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			calls = append(calls, r.Method+" "+r.URL.String())
+		}
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/apis/remote-service/deployments"),
+			r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/apis/remote-service"):
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasSuffix(r.URL.Path, "/caches"), strings.HasSuffix(r.URL.Path, "/apiproducts"):
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("{}"))
+		case strings.HasSuffix(r.URL.Path, "/keyvaluemaps"):
+			w.WriteHeader(http.StatusInternalServerError)
+		case strings.HasSuffix(r.URL.Path, "/virtualhosts"):
+			_, _ = w.Write([]byte(`["default","secure"]`))
+		default:
+			_ = json.NewEncoder(w).Encode(apigee.ProxyRevision{})
+		}
+	}))
+	defer ts.Close()
+
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		calls = nil
+		print := testutil.Printer("TestRollbackOnError")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"provision", "-o", "org", "-e", "test", "-u", "me", "-p", "password", "-r", ts.URL, "--legacy"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+	created := []string{
+		"POST /v1/organizations/org/apis?action=import&name=remote-service",
+		"POST /v1/organizations/org/environments/test/caches?name=remote-service",
+		"POST /v1/organizations/org/environments/test/apis/remote-service/revisions/1/deployments?action=deploy&delay=12&env=test&override=true",
+		"POST /v1/organizations/org/apiproducts",
+		"POST /credential/organization/org/environment/test",
+		"POST /v1/organizations/org/environments/test/keyvaluemaps",
+	}
+
+	// changes are kept by default
+	_, err := run()
+	testutil.ErrorContains(t, err, "retrieving or creating kvm")
+	if !reflect.DeepEqual(created, calls) {
+		t.Errorf("want calls:\n%s\ngot:\n%s", strings.Join(created, "\n"), strings.Join(calls, "\n"))
+	}
+
+	print, err := run("--rollback-on-error")
+	testutil.ErrorContains(t, err, "retrieving or creating kvm")
+	want := append(created,
+		"DELETE /v1/organizations/org/apiproducts/remote-service",
+		"DELETE /v1/organizations/org/environments/test/caches/remote-service",
+		"POST /v1/organizations/org/apis/remote-service/revisions/1/deployments?action=undeploy&env=test",
+		"DELETE /v1/organizations/org/apis/remote-service",
+	)
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("want calls:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(calls, "\n"))
+	}
+	print.Check(t, []string{
+		"provisioning failed, rolling back 3 change(s)...",
+		"reverting product remote-service...",
+		"product remote-service deleted",
+		"reverting cache remote-service...",
+		"cache remote-service deleted",
+		"reverting proxy remote-service revision 1...",
+		"proxy remote-service revision 1 undeployed from test",
+		"proxy remote-service deleted",
+		"rollback complete",
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// keyRotation replaces the credential of the adapter by a new one,
+// optionally revoking the app's other keys once the new config is emitted
+type keyRotation struct {
+	enabled     bool
+	revokeOld   bool
+	gracePeriod time.Duration
+	oldKeys     []string // valid keys of the app before the rotation
+}
+
+// sleep waits out the grace period, var for tests
+var sleep = time.Sleep
+
+func (r keyRotation) validate(p *provision, changed func(string) bool) error {
+	if !r.enabled && (r.revokeOld || changed("grace-period")) {
+		return fmt.Errorf("--revoke-old-keys and --grace-period only valid with --rotate-key")
+	}
+	if changed("grace-period") && !r.revokeOld {
+		return fmt.Errorf("--grace-period only valid with --revoke-old-keys")
+	}
+	if r.gracePeriod < 0 {
+		return fmt.Errorf("--grace-period must not be negative")
+	}
+	if r.revokeOld && !p.IsGCPManaged {
+		return fmt.Errorf("--revoke-old-keys only valid for hybrid, legacy credentials can't be revoked")
+	}
+	if r.enabled && len(p.envs) > 1 {
+		return fmt.Errorf("--rotate-key can't be combined with multiple environments")
+	}
+	return nil
+}
+
+// rotateGCPCredential adds a new key to the remote-service app, created if
+// missing, and grants it the API product
+func (p *provision) rotateGCPCredential(verbosef shared.FormatFn) (*keySecret, error) {
+	verbosef("rotating credential...")
+
+	var creds []apigee.AppCredential
+	var err error
+	if p.useAppGroup {
+		creds, err = p.createAppGroupApp(verbosef)
+	} else {
+		creds, err = p.createDeveloperApp(verbosef)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range creds {
+		if c.ConsumerKey != "" && c.Status != revokedStatus {
+			p.rotation.oldKeys = append(p.rotation.oldKeys, c.ConsumerKey)
+		}
+	}
+
+	key, err := newHash()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := newHash()
+	if err != nil {
+		return nil, err
+	}
+
+	app := p.credential.appName()
+	owner, products := p.credential.developerEmail(), []string{p.product.productName()}
+	if p.useAppGroup {
+		owner = shared.DefaultAppGroupName
+		_, _, err = p.ApigeeClient.AppGroups.CreateKey(owner, app, key, secret)
+	} else {
+		_, _, err = p.ApigeeClient.DeveloperApps.CreateKey(owner, app, key, secret)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating key of app %s", app)
+	}
+	p.onRollback("key "+key, func(printf shared.FormatFn) error {
+		var resp *apigee.Response
+		var err error
+		if p.useAppGroup {
+			resp, err = p.ApigeeClient.AppGroups.DeleteKey(owner, app, key)
+		} else {
+			resp, err = p.ApigeeClient.DeveloperApps.DeleteKey(owner, app, key)
+		}
+		return deleted("key", key, resp, err, printf)
+	})
+
+	if p.useAppGroup {
+		_, err = p.ApigeeClient.AppGroups.AddKeyProducts(owner, app, key, products, p.credential.scopes)
+	} else {
+		_, err = p.ApigeeClient.DeveloperApps.AddKeyProducts(owner, app, key, products, p.credential.scopes)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "granting %s to key %s", products[0], key)
+	}
+	verbosef("key %s created, %d old key(s) remain valid", key, len(p.rotation.oldKeys))
+	p.notifyCredential(app, key)
+
+	if p.dryRun {
+		return &keySecret{}, nil
+	}
+	return &keySecret{Key: key, Secret: secret}, nil
+}
+
+// revokeOldKeys revokes the keys of the app the rotation replaced, after
+// waiting out the grace period for the adapters to pick up the new config
+func (p *provision) revokeOldKeys(verbosef shared.FormatFn) error {
+	if len(p.rotation.oldKeys) == 0 {
+		return nil
+	}
+	if p.rotation.gracePeriod > 0 {
+		verbosef("waiting %s before revoking %d old key(s)...", p.rotation.gracePeriod, len(p.rotation.oldKeys))
+		sleep(p.rotation.gracePeriod)
+	}
+
+	app := p.credential.appName()
+	var errs error
+	for _, key := range p.rotation.oldKeys {
+		var err error
+		if p.useAppGroup {
+			_, err = p.ApigeeClient.AppGroups.RevokeKey(shared.DefaultAppGroupName, app, key)
+		} else {
+			_, err = p.ApigeeClient.DeveloperApps.RevokeKey(p.credential.developerEmail(), app, key)
+		}
+		if err != nil {
+			errs = multierr.Append(errs, errors.Wrapf(err, "revoking key %s of app %s", key, app))
+			continue
+		}
+		verbosef("key %s revoked", key)
+	}
+	return errs
+}
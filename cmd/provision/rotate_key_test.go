@@ -0,0 +1,112 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestRotateGCPCredential(t *testing.T) {
+	var calls []string
+	var newKey string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/apps/remote-service/keys"):
+			var cred apigee.AppCredential
+			if err := json.NewDecoder(r.Body).Decode(&cred); err != nil || cred.ConsumerKey == "" || cred.ConsumerSecret == "" {
+				t.Errorf("want key and secret, got %v (%v)", cred, err)
+			}
+			newKey = cred.ConsumerKey
+			_ = json.NewEncoder(w).Encode(cred)
+		case strings.HasSuffix(r.URL.Path, "/apps"):
+			w.WriteHeader(http.StatusConflict)
+		case strings.HasSuffix(r.URL.Path, "/apps/remote-service"):
+			_ = json.NewEncoder(w).Encode(apigee.DeveloperApp{Credentials: []apigee.AppCredential{
+				{ConsumerKey: "old", Status: "approved"},
+				{ConsumerKey: "revoked", Status: revokedStatus},
+			}})
+		}
+	}))
+	defer ts.Close()
+
+	defer func(s func(time.Duration)) { sleep = s }(sleep)
+	var slept time.Duration
+	sleep = func(d time.Duration) { slept = d }
+
+	rootArgs := &shared.RootArgs{
+		Env:          "test",
+		IsGCPManaged: true,
+		ClientOpts:   &apigee.EdgeClientOptions{Org: "gcp", Env: "test", Auth: &apigee.EdgeAuth{SkipAuth: true}},
+	}
+	setTestUrls(rootArgs, ts.URL)
+	p := &provision{RootArgs: rootArgs, rotation: keyRotation{enabled: true, revokeOld: true, gracePeriod: time.Hour}}
+	print := testutil.Printer("TestRotateGCPCredential")
+	cred, err := p.rotateGCPCredential(print.Printf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.Key == "" || cred.Key != newKey || cred.Secret == "" {
+		t.Errorf("want new credential %s, got %v", newKey, cred)
+	}
+	if !reflect.DeepEqual(p.rotation.oldKeys, []string{"old"}) {
+		t.Errorf("want old key to revoke, got %v", p.rotation.oldKeys)
+	}
+	if err := p.revokeOldKeys(print.Printf); err != nil {
+		t.Fatal(err)
+	}
+	if slept != time.Hour {
+		t.Errorf("want grace period waited, got %s", slept)
+	}
+
+	base := "/v1/organizations/gcp/developers/" + shared.DefaultDeveloperEmail + "/apps/remote-service"
+	want := []string{
+		"POST /v1/organizations/gcp/developers",
+		"POST /v1/organizations/gcp/developers/" + shared.DefaultDeveloperEmail + "/apps",
+		"GET " + base,
+		"POST " + base + "/keys",
+		"POST " + base + "/keys/" + newKey,
+		"POST " + base + "/keys/old",
+	}
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("want calls %v, got %v", want, calls)
+	}
+
+	changed := func(flag string) bool { return flag == "grace-period" }
+	none := func(string) bool { return false }
+	for _, test := range []struct {
+		p       *provision
+		changed func(string) bool
+		err     string
+	}{
+		{&provision{RootArgs: rootArgs, rotation: keyRotation{revokeOld: true}}, none, "only valid with --rotate-key"},
+		{&provision{RootArgs: rootArgs, rotation: keyRotation{enabled: true}}, changed, "--grace-period only valid with --revoke-old-keys"},
+		{&provision{RootArgs: rootArgs, rotation: keyRotation{enabled: true, revokeOld: true, gracePeriod: -time.Second}}, changed, "must not be negative"},
+		{&provision{RootArgs: &shared.RootArgs{}, rotation: keyRotation{enabled: true, revokeOld: true}}, none, "--revoke-old-keys only valid for hybrid"},
+		{&provision{RootArgs: rootArgs, envs: []string{"test", "prod"}, rotation: keyRotation{enabled: true}}, none, "multiple environments"},
+	} {
+		testutil.ErrorContains(t, test.p.rotation.validate(test.p, test.changed), test.err)
+	}
+}
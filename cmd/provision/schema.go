@@ -0,0 +1,52 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+)
+
+// ConfigSchema returns the schema of the adapter config emitted by provision
+// (the config.yaml of its ConfigMap)
+func ConfigSchema() shared.JSONSchema {
+	return shared.SchemaOf(server.Config{}, "yaml", "apigee-remote-service-envoy config")
+}
+
+// SecretSchema returns the schema of the policy secret emitted by provision
+func SecretSchema() shared.JSONSchema {
+	s := shared.SchemaOf(server.SecretCRD{}, "yaml", "apigee-remote-service-envoy policy secret")
+	props := s["properties"].(shared.JSONSchema)
+	props["apiVersion"] = shared.JSONSchema{"const": "v1"}
+	props["kind"] = shared.JSONSchema{"const": "Secret"}
+	props["metadata"].(shared.JSONSchema)["additionalProperties"] = true // labels, annotations
+	entry := shared.JSONSchema{"type": "string", "contentEncoding": "base64"}
+	props["data"] = shared.JSONSchema{
+		"type": "object",
+		"properties": shared.JSONSchema{
+			server.SecretJKWSKey:    entry,
+			server.SecretPrivateKey: entry,
+			server.SecretPropsKey:   entry,
+		},
+		"required":             []string{server.SecretJKWSKey, server.SecretPrivateKey, server.SecretPropsKey},
+		"additionalProperties": false,
+	}
+	return s
+}
+
+// ManifestSchema returns the schema of the manifest.json of a support bundle
+func ManifestSchema() shared.JSONSchema {
+	return shared.SchemaOf(bundleManifest{}, "json", "apigee-remote-service-cli support bundle manifest")
+}
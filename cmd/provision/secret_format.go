@@ -0,0 +1,146 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/k8s"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	secretFormatPlain  = "plain"
+	secretFormatSealed = "sealed"
+	secretFormatSOPS   = "sops"
+)
+
+// sopsCommand is the sops binary encrypting --secret-format sops
+var sopsCommand = "sops"
+
+// secretFormatOptions are the flags encrypting the policy secret so it can be
+// stored in Git
+type secretFormatOptions struct {
+	format     string
+	sealedCert string
+	sopsAge    string
+	sopsKMS    string
+	sopsGCPKMS string
+	certPEM    []byte // read by validate
+}
+
+// validate reads the certificate of --secret-format sealed to fail early
+func (s *secretFormatOptions) validate(p *provision) error {
+	sopsKeys := s.sopsAge != "" || s.sopsKMS != "" || s.sopsGCPKMS != ""
+	if s.format != secretFormatPlain && (!p.IsGCPManaged || p.configOut == configOutNative) {
+		return fmt.Errorf("--secret-format only valid for hybrid or Apigee X with --config-out %s", configOutKubernetes)
+	}
+	switch s.format {
+	case secretFormatPlain:
+	case secretFormatSealed:
+		if s.sealedCert == "" {
+			return fmt.Errorf("--secret-format %s requires --sealed-secrets-cert", secretFormatSealed)
+		}
+		if p.Namespace == "" {
+			return fmt.Errorf("--secret-format %s requires --namespace", secretFormatSealed)
+		}
+		cert, err := ioutil.ReadFile(s.sealedCert)
+		if err != nil {
+			return errors.Wrap(err, "reading --sealed-secrets-cert")
+		}
+		s.certPEM = cert
+	case secretFormatSOPS:
+		if !sopsKeys {
+			return fmt.Errorf("--secret-format %s requires --sops-age, --sops-kms or --sops-gcp-kms", secretFormatSOPS)
+		}
+		if p.apply.enabled {
+			return fmt.Errorf("--apply can't be combined with --secret-format %s", secretFormatSOPS)
+		}
+		if _, err := exec.LookPath(sopsCommand); err != nil {
+			return errors.Wrapf(err, "--secret-format %s", secretFormatSOPS)
+		}
+	default:
+		return fmt.Errorf("--secret-format must be %s, %s or %s", secretFormatPlain, secretFormatSealed, secretFormatSOPS)
+	}
+	if s.format != secretFormatSealed && s.sealedCert != "" {
+		return fmt.Errorf("--sealed-secrets-cert only valid with --secret-format %s", secretFormatSealed)
+	}
+	if s.format != secretFormatSOPS && sopsKeys {
+		return fmt.Errorf("--sops-age, --sops-kms and --sops-gcp-kms only valid with --secret-format %s", secretFormatSOPS)
+	}
+	return nil
+}
+
+// encrypt returns the YAML document of the policy secret in the format
+func (s *secretFormatOptions) encrypt(secret *server.SecretCRD) (interface{}, error) {
+	switch s.format {
+	case secretFormatSealed:
+		data := map[string][]byte{}
+		for k, v := range secret.Data {
+			value, err := base64.StdEncoding.DecodeString(v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "decoding %s", k)
+			}
+			data[k] = value
+		}
+		return k8s.Seal(s.certPEM, secret.Metadata.Namespace, secret.Metadata.Name, secret.Type, data)
+	case secretFormatSOPS:
+		return s.sops(secret)
+	}
+	return secret, nil
+}
+
+// sops encrypts the data of secret with the sops binary, keeping the rest of
+// the Secret readable
+func (s *secretFormatOptions) sops(secret *server.SecretCRD) (*yaml.Node, error) {
+	plain, err := yaml.Marshal(secret)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--encrypt", "--input-type", "yaml", "--output-type", "yaml",
+		"--encrypted-regex", "^(data|stringData)$"}
+	if s.sopsAge != "" {
+		args = append(args, "--age", s.sopsAge)
+	}
+	if s.sopsKMS != "" {
+		args = append(args, "--kms", s.sopsKMS)
+	}
+	if s.sopsGCPKMS != "" {
+		args = append(args, "--gcp-kms", s.sopsGCPKMS)
+	}
+	args = append(args, "/dev/stdin")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(sopsCommand, args...)
+	cmd.Stdin = bytes.NewReader(plain)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errors.Wrapf(err, "encrypting %s with sops: %s", secret.Metadata.Name, strings.TrimSpace(stderr.String()))
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(stdout.Bytes(), &doc); err != nil {
+		return nil, errors.Wrap(err, "parsing the output of sops")
+	}
+	return &doc, nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestProvisionSecretFormat(t *testing.T) {
+	ts := httptest.NewServer(handler(t))
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	dir, err := ioutil.TempDir("", "secret-format")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := filepath.Join(dir, "cert.pem")
+	if err := ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// fake sops recording its arguments
+	sopsArgs := filepath.Join(dir, "args")
+	sopsCommand = filepath.Join(dir, "sops")
+	defer func() { sopsCommand = "sops" }()
+	if err := ioutil.WriteFile(sopsCommand, []byte(`#!/bin/sh
+echo "$@" > `+sopsArgs+`
+cat > /dev/null
+cat <<EOF
+apiVersion: v1
+kind: Secret
+metadata:
+  name: gcp-test-policy-secret
+data:
+  remote-service.key: ENC[AES256_GCM,data:c2VhbGVk,type:str]
+sops:
+  version: 3.7.1
+EOF
+`), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(flags ...string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestProvisionSecretFormat")
+		rootArgs := &shared.RootArgs{}
+		flags = append([]string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-n", "ns", "-t", "token"}, flags...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+	resources := func(print *testutil.TestPrint) string {
+		return print.Prints[len(print.Prints)-1]
+	}
+
+	print, err := run("--secret-format", "sealed", "--sealed-secrets-cert", cert, "--k8s-version", "1.21")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	out := resources(print)
+	for _, want := range []string{"kind: ConfigMap", "kind: SealedSecret", "namespace: ns", "encryptedData:", "remote-service.key: "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %q in resources, got:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "kind: Secret\n") {
+		t.Errorf("want no plain secret, got:\n%s", out)
+	}
+
+	print, err = run("--secret-format", "sops", "--sops-age", "age1xyz")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	out = resources(print)
+	if !strings.Contains(out, "remote-service.key: ENC[AES256_GCM,data:c2VhbGVk,type:str]") || !strings.Contains(out, "sops:") {
+		t.Errorf("want the output of sops, got:\n%s", out)
+	}
+	args, err := ioutil.ReadFile(sopsArgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "--encrypt --input-type yaml --output-type yaml --encrypted-regex ^(data|stringData)$ --age age1xyz /dev/stdin\n"; string(args) != want {
+		t.Errorf("want sops args %q, got %q", want, args)
+	}
+
+	for _, tc := range []struct {
+		flags   []string
+		wantErr string
+	}{
+		{[]string{"--secret-format", "vault"}, "--secret-format must be plain, sealed or sops"},
+		{[]string{"--secret-format", "sealed"}, "--secret-format sealed requires --sealed-secrets-cert"},
+		{[]string{"--secret-format", "sops"}, "--secret-format sops requires --sops-age, --sops-kms or --sops-gcp-kms"},
+		{[]string{"--sops-age", "age1xyz"}, "--sops-age, --sops-kms and --sops-gcp-kms only valid with --secret-format sops"},
+		{[]string{"--secret-format", "sealed", "--sealed-secrets-cert", filepath.Join(dir, "missing")}, "reading --sealed-secrets-cert"},
+		{[]string{"--secret-format", "sealed", "--sealed-secrets-cert", cert, "--config-out", "native", "--policy-secret-dir", dir},
+			"--secret-format only valid for hybrid or Apigee X with --config-out kubernetes"},
+	} {
+		_, err := run(tc.flags...)
+		testutil.ErrorContains(t, err, tc.wantErr)
+	}
+}
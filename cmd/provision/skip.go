@@ -0,0 +1,49 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+)
+
+// skipSteps leave parts of a provisioning as they are, so a run may only
+// redo the others (eg. regenerate the config after a manual proxy upgrade)
+type skipSteps struct {
+	proxy      bool
+	kvm        bool // legacy or OPDK
+	product    bool
+	credential bool // the credential of --config is used
+}
+
+func (s skipSteps) validate(p *provision) error {
+	if s.kvm && p.IsGCPManaged {
+		return fmt.Errorf("--skip-kvm only valid for legacy or OPDK")
+	}
+	if s.credential && p.rotation.enabled {
+		return fmt.Errorf("--skip-credential can't be combined with --rotate-key")
+	}
+	if s.credential && (p.ServerConfig == nil || p.ServerConfig.Tenant.Key == "" || p.ServerConfig.Tenant.Secret == "") {
+		return fmt.Errorf("--skip-credential requires --config with the credential to use")
+	}
+	return nil
+}
+
+// configCredential is the credential of --config
+func (p *provision) configCredential() *keySecret {
+	return &keySecret{
+		Key:    p.ServerConfig.Tenant.Key,
+		Secret: p.ServerConfig.Tenant.Secret,
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestSkipSteps(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	m := serveMux(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && !strings.HasPrefix(r.URL.Path, "/remote-service/") { // not verification
+			mu.Lock()
+			calls = append(calls, r.Method+" "+r.URL.Path)
+			mu.Unlock()
+		}
+		m.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	config := []byte(`tenant:
+  internal_api: https://istioservices.apigee.net/edgemicro
+  remote_service_api: ` + ts.URL + `/remote-service
+  org_name: legacy
+  env_name: test
+  key: fake-key
+  secret: fake-secret`)
+	tmpFile, err := ioutil.TempFile("", "config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(config); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		calls = nil
+		print := testutil.Printer("TestSkipSteps")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"provision", "-o", "legacy", "-e", "test", "-u", "me", "-p", "password", "--legacy"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+
+	print, err := run("-c", tmpFile.Name(), "--skip-proxy", "--skip-kvm", "--skip-product", "--skip-credential")
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	for _, c := range calls {
+		if strings.Contains(c, "/v1/organizations/") || strings.Contains(c, "/credential/") {
+			t.Errorf("want no changes to the organization, got %s", c)
+		}
+	}
+	checkContains(t, print.Prints, "key: fake-key")
+
+	// only the product is created
+	if _, err := run("-c", tmpFile.Name(), "--skip-proxy", "--skip-kvm", "--skip-credential"); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if len(calls) != 1 || calls[0] != "POST /v1/organizations/legacy/apiproducts" {
+		t.Errorf("want product created, got %v", calls)
+	}
+
+	_, err = run("--skip-credential")
+	testutil.ErrorContains(t, err, "--skip-credential requires --config with the credential to use")
+
+	hybrid := &provision{RootArgs: &shared.RootArgs{IsGCPManaged: true}, rotation: keyRotation{enabled: true}}
+	testutil.ErrorContains(t, skipSteps{kvm: true}.validate(hybrid), "--skip-kvm only valid for legacy or OPDK")
+	testutil.ErrorContains(t, skipSteps{credential: true}.validate(hybrid), "--skip-credential can't be combined with --rotate-key")
+}
@@ -0,0 +1,242 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const oidcDiscoveryPath = "/.well-known/openid-configuration"
+
+// idpConfig is the --jwt-from file of an external identity provider
+// issuing tokens with the client credentials grant, such as Okta
+type idpConfig struct {
+	Issuer       string   `yaml:"issuer"`
+	TokenURL     string   `yaml:"token_url"` // discovered from the issuer if empty
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+	Audience     string   `yaml:"audience"`
+}
+
+type smokeJWT struct {
+	*shared.RootArgs
+	url     string
+	jwtFrom string
+	idp     idpConfig
+}
+
+// SmokeCmd returns the test command
+func SmokeCmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "test",
+		Short: "Smoke test the runtime of Apigee Remote Service",
+		Args:  cobra.NoArgs,
+	}
+
+	c.AddCommand(cmdSmokeJWT(rootArgs, printf))
+
+	return c
+}
+
+func cmdSmokeJWT(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	s := &smokeJWT{RootArgs: rootArgs}
+
+	c := &cobra.Command{
+		Use:   "jwt",
+		Short: "Call an Envoy endpoint with a JWT of an external identity provider",
+		Long: `The jwt command obtains a token from the external identity provider described by --jwt-from
+with the client credentials grant, calls an endpoint protected by Envoy and the Apigee Remote
+Service adapter with it and reports whether the JWT provider of the adapter accepted it.
+The --jwt-from file has the issuer, client_id, client_secret and optionally token_url, scopes
+and audience of the provider.`,
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := s.loadIdP(); err != nil {
+				return err
+			}
+			return rootArgs.Resolve(true, false)
+		},
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+			return s.run(printf)
+		},
+	}
+
+	c.Flags().StringVarP(&s.url, "url", "", "", "URL of the endpoint protected by Envoy")
+	c.Flags().StringVarP(&s.jwtFrom, "jwt-from", "", "", "YAML file of the identity provider issuing the JWT")
+
+	_ = c.MarkFlagRequired("url")
+	_ = c.MarkFlagRequired("jwt-from")
+
+	return c
+}
+
+func (s *smokeJWT) loadIdP() error {
+	data, err := ioutil.ReadFile(s.jwtFrom)
+	if err != nil {
+		return errors.Wrap(err, "reading --jwt-from")
+	}
+	if err := yaml.Unmarshal(data, &s.idp); err != nil {
+		return errors.Wrapf(err, "parsing %s", s.jwtFrom)
+	}
+	if s.idp.Issuer == "" && s.idp.TokenURL == "" {
+		return fmt.Errorf("%s: issuer or token_url is required", s.jwtFrom)
+	}
+	if s.idp.ClientID == "" || s.idp.ClientSecret == "" {
+		return fmt.Errorf("%s: client_id and client_secret are required", s.jwtFrom)
+	}
+	return nil
+}
+
+func (s *smokeJWT) run(printf shared.FormatFn) error {
+	client := &http.Client{Timeout: 10 * time.Second, Transport: s.RuntimeTransport(s.InsecureSkipVerify)}
+
+	token, err := s.fetchToken(client)
+	if err != nil {
+		return err
+	}
+	claims := jwtClaims(token)
+	printf("token of %s: iss %v, aud %v, sub %v, expires %s", s.idp.ClientID,
+		claims["iss"], claims["aud"], claims["sub"], claimTime(claims["exp"]))
+
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "calling %s", s.url)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	reason := strings.TrimSpace(string(body))
+	if len(reason) > 120 {
+		reason = reason[:120] + "..."
+	}
+
+	switch res.StatusCode {
+	case http.StatusUnauthorized:
+		return fmt.Errorf("the JWT was rejected (%s: %s), check that the JWT provider of the adapter has issuer %v, audience %v and the JWKS of the identity provider",
+			res.Status, reason, claims["iss"], claims["aud"])
+	case http.StatusForbidden:
+		return fmt.Errorf("the JWT was accepted but the call is forbidden (%s: %s), check that the API key claim of the token identifies an app of an API product of the target",
+			res.Status, reason)
+	}
+	printf("%s: %s, the JWT of %v was accepted", s.url, res.Status, claims["iss"])
+	return nil
+}
+
+// fetchToken obtains a token with the client credentials grant, the access
+// token or, if none, the ID token
+func (s *smokeJWT) fetchToken(client *http.Client) (string, error) {
+	tokenURL := s.idp.TokenURL
+	if tokenURL == "" {
+		discoveryURL := strings.TrimSuffix(s.idp.Issuer, "/") + oidcDiscoveryPath
+		var discovery struct {
+			TokenEndpoint string `json:"token_endpoint"`
+		}
+		if err := getJSON(client, discoveryURL, &discovery); err != nil {
+			return "", errors.Wrap(err, "discovering the token endpoint of the issuer")
+		}
+		if tokenURL = discovery.TokenEndpoint; tokenURL == "" {
+			return "", fmt.Errorf("%s has no token_endpoint", discoveryURL)
+		}
+	}
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(s.idp.Scopes) > 0 {
+		form.Set("scope", strings.Join(s.idp.Scopes, " "))
+	}
+	if s.idp.Audience != "" {
+		form.Set("audience", s.idp.Audience)
+	}
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.idp.ClientID), url.QueryEscape(s.idp.ClientSecret))
+	res, err := client.Do(req)
+	if err != nil {
+		return "", errors.Wrapf(err, "requesting a token from %s", tokenURL)
+	}
+	defer res.Body.Close()
+	var tokens struct {
+		AccessToken      string `json:"access_token"`
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	_ = json.NewDecoder(res.Body).Decode(&tokens)
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("requesting a token from %s: %s %s %s", tokenURL, res.Status, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.AccessToken != "" {
+		return tokens.AccessToken, nil
+	}
+	if tokens.IDToken != "" {
+		return tokens.IDToken, nil
+	}
+	return "", fmt.Errorf("no token from %s", tokenURL)
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	res, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// jwtClaims returns the claims of a JWT without verifying it, none if the
+// token is opaque
+func jwtClaims(token string) map[string]interface{} {
+	claims := map[string]interface{}{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err == nil {
+		_ = json.Unmarshal(payload, &claims)
+	}
+	return claims
+}
+
+func claimTime(v interface{}) string {
+	if secs, ok := v.(float64); ok {
+		return time.Unix(int64(secs), 0).UTC().Format(time.RFC3339)
+	}
+	return "never"
+}
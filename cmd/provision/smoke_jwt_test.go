@@ -0,0 +1,110 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestSmokeJWT(t *testing.T) {
+	var ts *httptest.Server
+	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"iss":"https://idp.example.com","aud":"api","sub":"client","exp":1600000000}`))
+	jwt := "e30." + payload + ".sig"
+	status := http.StatusOK
+	m := http.NewServeMux()
+	m.HandleFunc(oidcDiscoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{"token_endpoint": ts.URL + "/token"})
+	})
+	m.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client","error_description":"bad client"}`))
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": jwt, "token_type": "Bearer"})
+	})
+	m.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+jwt {
+			t.Errorf("want the JWT of the identity provider, got %q", r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(status)
+		if status == http.StatusUnauthorized {
+			_, _ = w.Write([]byte("Jwt issuer is not configured"))
+		}
+	})
+	ts = httptest.NewServer(m)
+	defer ts.Close()
+
+	writeIdP := func(config string) string {
+		f, err := ioutil.TempFile("", "idp.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(config); err != nil {
+			t.Fatal(err)
+		}
+		return f.Name()
+	}
+	idp := writeIdP("issuer: " + ts.URL + "\nclient_id: client\nclient_secret: s3cret\nscopes: [read, write]\n")
+	defer os.Remove(idp)
+	badClient := writeIdP("token_url: " + ts.URL + "/token\nclient_id: client\nclient_secret: wrong\n")
+	defer os.Remove(badClient)
+
+	run := func(idp string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestSmokeJWT")
+		rootArgs := &shared.RootArgs{}
+		flags := []string{"test", "jwt", "--url", ts.URL + "/api", "--jwt-from", idp}
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, SmokeCmd(rootArgs, print.Printf))
+		return print, rootCmd.Execute()
+	}
+
+	print, err := run(idp)
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{
+		"token of client: iss https://idp.example.com, aud api, sub client, expires 2020-09-13T12:26:40Z",
+		ts.URL + "/api: 200 OK, the JWT of https://idp.example.com was accepted",
+	})
+
+	status = http.StatusUnauthorized
+	_, err = run(idp)
+	testutil.ErrorContains(t, err, "the JWT was rejected (401 Unauthorized: Jwt issuer is not configured), check that the JWT provider of the adapter has issuer https://idp.example.com, audience api")
+
+	status = http.StatusForbidden
+	_, err = run(idp)
+	testutil.ErrorContains(t, err, "the JWT was accepted but the call is forbidden (403 Forbidden: )")
+
+	_, err = run(badClient)
+	testutil.ErrorContains(t, err, "requesting a token from "+ts.URL+"/token: 401 Unauthorized invalid_client bad client")
+
+	missing := writeIdP("issuer: " + ts.URL + "\n")
+	defer os.Remove(missing)
+	_, err = run(missing)
+	testutil.ErrorContains(t, err, "client_id and client_secret are required")
+}
@@ -0,0 +1,553 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// provisionedState is what provision created in an environment, as written by
+// state export and re-created by state import
+type provisionedState struct {
+	Created      time.Time          `json:"created"`
+	Platform     string             `json:"platform"`
+	Organization string             `json:"organization"`
+	Environment  string             `json:"environment"`
+	Proxies      []exportedProxy    `json:"proxies,omitempty"`
+	Cache        string             `json:"cache,omitempty"` // legacy and OPDK
+	KVM          *apigee.KVM        `json:"kvm,omitempty"`   // legacy and OPDK
+	Product      *apiProduct        `json:"product,omitempty"`
+	App          *exportedApp       `json:"app,omitempty"`        // hybrid
+	Credential   *shared.Credential `json:"credential,omitempty"` // legacy and OPDK, of --config
+}
+
+// exportedProxy is a deployed proxy revision and its bundle
+type exportedProxy struct {
+	Name     string          `json:"name"`
+	Revision apigee.Revision `json:"revision"`
+	Digest   string          `json:"digest"`
+	Bundle   []byte          `json:"bundle"` // zip
+}
+
+// exportedApp is the remote-service app and its valid keys
+type exportedApp struct {
+	Name        string        `json:"name"`
+	Developer   string        `json:"developer,omitempty"`
+	AppGroup    string        `json:"appGroup,omitempty"`
+	Credentials []exportedKey `json:"credentials"`
+}
+
+// exportedKey is a key of the app and what it's granted
+type exportedKey struct {
+	Key      string   `json:"key"`
+	Secret   string   `json:"secret"`
+	Products []string `json:"products,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// StateCmd returns the state command
+func StateCmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	p := &provision{RootArgs: rootArgs, deployOptions: apigee.DefaultDeployOptions}
+
+	c := &cobra.Command{
+		Use:   "state",
+		Short: "Export or import what provision created in an environment",
+		Long: `The state commands write what provision created in an environment (the deployed proxy
+revisions, the product, the app and its keys on hybrid, the cache, the kvm and the credential
+of --config on legacy and OPDK) to a file and re-create it in another organization or
+environment of the same platform, eg. for disaster recovery or a migration.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return rootArgs.Resolve(false, false)
+		},
+	}
+
+	c.PersistentFlags().StringVarP(&rootArgs.ManagementBase, "management", "m",
+		shared.DefaultManagementBase, "Apigee management base URL")
+	c.PersistentFlags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.PersistentFlags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+	c.PersistentFlags().StringVarP(&rootArgs.Token, "token", "t", "",
+		"Apigee OAuth or SAML token (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.Username, "username", "u", "",
+		"Apigee username (legacy or OPDK only)")
+	c.PersistentFlags().StringVarP(&rootArgs.Password, "password", "p", "",
+		"Apigee password (legacy or OPDK only)")
+
+	c.AddCommand(cmdStateExport(p, printf))
+	c.AddCommand(cmdStateImport(p, printf))
+
+	return c
+}
+
+func cmdStateExport(p *provision, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "export [file]",
+		Short: "Write what provision created in an environment to a file",
+		Long: `Write what provision created in an environment to a file. The file holds the
+credentials of the adapter, keep it safe.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := p.resources.validate(); err != nil {
+				return err
+			}
+			if !p.IsGCPManaged && p.useAppGroup {
+				return fmt.Errorf("--use-appgroup only valid for hybrid")
+			}
+			if err := p.credential.validate(p); err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			return p.exportState(args[0], printf)
+		},
+	}
+
+	c.Flags().StringVarP(&p.resources.kvm, "kvm-name", "", "",
+		"name of the kvm given to provision (default: "+defaultKVMName+")")
+	c.Flags().StringVarP(&p.resources.cache, "cache-name", "", "",
+		"name of the cache given to provision (default: "+defaultCacheName+")")
+	c.Flags().StringVarP(&p.product.name, "product-name", "", "",
+		"name of the API product given to provision (default: remote-service)")
+	c.Flags().BoolVarP(&p.useAppGroup, "use-appgroup", "", false,
+		"the remote-service app was created in an AppGroup (hybrid only)")
+	c.Flags().StringVarP(&p.credential.developer, "developer", "", "",
+		"email of the developer given to provision (default: "+shared.DefaultDeveloperEmail+") (hybrid only)")
+	c.Flags().StringVarP(&p.credential.app, "app", "", "",
+		"name of the app given to provision (default: "+shared.DefaultAppName+") (hybrid only)")
+
+	return c
+}
+
+func cmdStateImport(p *provision, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "import [file]",
+		Short: "Re-create in an environment what a state file describes",
+		Long: `Re-create in an environment what a state file of state export describes, keeping
+the names and credentials it holds. Existing artifacts are kept, a deployed proxy is only
+replaced if it's from another bundle.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return p.importState(args[0], printf)
+		},
+	}
+
+	return c
+}
+
+// exportState writes the state of the environment to file
+func (p *provision) exportState(file string, printf shared.FormatFn) error {
+	verbosef := p.Stepf()
+	state := &provisionedState{
+		Created:      time.Now().UTC(),
+		Platform:     p.platform(),
+		Organization: p.Org,
+		Environment:  p.Env,
+	}
+
+	names := []string{authProxyName}
+	if p.IsOPDK {
+		names = []string{internalProxyName, authProxyName}
+	}
+	for _, name := range names {
+		proxy, err := p.exportProxy(name, verbosef)
+		if err != nil {
+			return err
+		}
+		if proxy == nil {
+			printf("warning: proxy %s is not deployed to %s, not exported", name, p.Env)
+			continue
+		}
+		state.Proxies = append(state.Proxies, *proxy)
+	}
+
+	if !p.IsGCPManaged {
+		name := p.resources.cacheName()
+		if _, resp, err := p.ApigeeClient.CacheService.Get(name); err == nil {
+			state.Cache = name
+		} else if resp != nil && resp.StatusCode == http.StatusNotFound {
+			printf("warning: cache %s not found, not exported", name)
+		} else {
+			return errors.Wrapf(err, "retrieving cache %s", name)
+		}
+
+		kvm, err := p.exportKVM(printf)
+		if err != nil {
+			return err
+		}
+		state.KVM = kvm
+	}
+
+	product, err := p.exportProduct()
+	if err != nil {
+		return err
+	}
+	if product == nil {
+		printf("warning: product %s not found, not exported", p.product.productName())
+	}
+	state.Product = product
+
+	if p.IsGCPManaged {
+		app, err := p.exportApp()
+		if err != nil {
+			return err
+		}
+		if app == nil {
+			printf("warning: app %s not found, not exported", p.credential.appName())
+		}
+		state.App = app
+	} else if p.ServerConfig != nil && p.ServerConfig.Tenant.Key != "" {
+		state.Credential = &shared.Credential{
+			Key:    p.ServerConfig.Tenant.Key,
+			Secret: p.ServerConfig.Tenant.Secret,
+		}
+	} else {
+		printf("warning: no --config, the credential is not exported")
+	}
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		return errors.Wrapf(err, "writing %s", file)
+	}
+	printf("state of %s/%s written to %s, it holds credentials: keep it safe", p.Org, p.Env, file)
+	return nil
+}
+
+// exportProxy returns the deployed revision of a proxy, nil if none
+func (p *provision) exportProxy(name string, verbosef shared.FormatFn) (*exportedProxy, error) {
+	var rev *apigee.Revision
+	var err error
+	if p.IsGCPManaged {
+		rev, err = p.ApigeeClient.Proxies.GetGCPDeployedRevision(name)
+	} else {
+		rev, err = p.ApigeeClient.Proxies.GetDeployedRevision(name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "checking deployment of proxy %s", name)
+	}
+	if rev == nil {
+		return nil, nil
+	}
+
+	verbosef("exporting proxy %s revision %s...", name, rev)
+	deployed, _, err := p.ApigeeClient.Proxies.GetRevision(name, *rev)
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving proxy %s revision %s", name, rev)
+	}
+	var bundle bytes.Buffer
+	if _, err := p.ApigeeClient.Proxies.Export(name, *rev, &bundle); err != nil {
+		return nil, errors.Wrapf(err, "exporting proxy %s revision %s", name, rev)
+	}
+	digest := bundleDigest(deployed.Description)
+	if digest == "" { // not deployed by provision, tell import it's another bundle
+		digest = fmt.Sprintf("%x", sha256.Sum256(bundle.Bytes()))
+	}
+	return &exportedProxy{
+		Name:     name,
+		Revision: *rev,
+		Digest:   digest,
+		Bundle:   bundle.Bytes(),
+	}, nil
+}
+
+// exportKVM returns the kvm, nil if not found. The values of an encrypted kvm
+// can't be read and are left out.
+func (p *provision) exportKVM(printf shared.FormatFn) (*apigee.KVM, error) {
+	name := p.resources.kvmName()
+	kvm, resp, err := p.ApigeeClient.KVMService.Get(name)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			printf("warning: kvm %s not found, not exported", name)
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "retrieving kvm %s", name)
+	}
+	var entries []apigee.Entry
+	for _, e := range kvm.Entries {
+		if kvm.IsMasked(e.Name) {
+			printf("warning: entry %s of encrypted kvm %s can't be read, not exported", e.Name, name)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	kvm.Entries = entries
+	return kvm, nil
+}
+
+// exportProduct returns the API product, nil if not found
+func (p *provision) exportProduct() (*apiProduct, error) {
+	name := p.product.productName()
+	req, err := p.ApigeeClient.NewRequestNoEnv(http.MethodGet, path.Join(apiProductsPath, name), nil)
+	if err != nil {
+		return nil, err
+	}
+	product := &apiProduct{}
+	if resp, err := p.ApigeeClient.Do(req, product); err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "retrieving product %s", name)
+	}
+	return product, nil
+}
+
+// exportApp returns the remote-service app with its valid keys, nil if not found
+func (p *provision) exportApp() (*exportedApp, error) {
+	app := &exportedApp{Name: p.credential.appName()}
+	var creds []apigee.AppCredential
+	var resp *apigee.Response
+	var err error
+	if p.useAppGroup {
+		app.AppGroup = shared.DefaultAppGroupName
+		var existing *apigee.AppGroupApp
+		if existing, resp, err = p.ApigeeClient.AppGroups.GetApp(app.AppGroup, app.Name); err == nil {
+			creds = existing.Credentials
+		}
+	} else {
+		app.Developer = p.credential.developerEmail()
+		var existing *apigee.DeveloperApp
+		if existing, resp, err = p.ApigeeClient.DeveloperApps.Get(app.Developer, app.Name); err == nil {
+			creds = existing.Credentials
+		}
+	}
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "retrieving app %s", app.Name)
+	}
+
+	for _, c := range creds {
+		if c.ConsumerKey == "" || c.Status == revokedStatus {
+			continue
+		}
+		if expiresAt, ok := credentialExpiry(c); ok && !expiresAt.After(time.Now()) {
+			continue
+		}
+		key := exportedKey{Key: c.ConsumerKey, Secret: c.ConsumerSecret, Scopes: c.Scopes}
+		for _, product := range c.APIProducts {
+			if product.Status != revokedStatus {
+				key.Products = append(key.Products, product.APIProduct)
+			}
+		}
+		app.Credentials = append(app.Credentials, key)
+	}
+	return app, nil
+}
+
+// importState re-creates the state of file in the environment
+func (p *provision) importState(file string, printf shared.FormatFn) error {
+	verbosef := p.Stepf()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	state := &provisionedState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return errors.Wrapf(err, "reading state %s", file)
+	}
+	if state.Platform != p.platform() {
+		return fmt.Errorf("state of %s is for %s, can't be imported into %s", file, state.Platform, p.platform())
+	}
+	if state.Credential != nil && p.InternalProxyURL == "" {
+		return fmt.Errorf("--runtime is required to import the credential")
+	}
+	for _, proxy := range state.Proxies {
+		if proxy.Name != authProxyName && proxy.Name != internalProxyName {
+			return fmt.Errorf("state of %s has proxy %q, only %s and %s can be imported", file, proxy.Name, authProxyName, internalProxyName)
+		}
+	}
+
+	tempDir, err := ioutil.TempDir("", "apigee")
+	if err != nil {
+		return errors.Wrap(err, "creating temp dir")
+	}
+	defer os.RemoveAll(tempDir)
+
+	p.resources.cache = state.Cache
+	for _, proxy := range state.Proxies {
+		bundle := filepath.Join(tempDir, proxy.Name+".zip")
+		if err := ioutil.WriteFile(bundle, proxy.Bundle, 0600); err != nil {
+			return errors.Wrapf(err, "writing %s", bundle)
+		}
+		if err := p.checkAndDeployProxy(proxy.Name, proxy.Digest, bundle, verbosef); err != nil {
+			return errors.Wrapf(err, "importing proxy %s", proxy.Name)
+		}
+	}
+
+	if state.Product != nil {
+		p.product.name = state.Product.Name
+		if err := p.importProduct(*state.Product, state.Environment, verbosef); err != nil {
+			return errors.Wrapf(err, "creating %s API product", state.Product.Name)
+		}
+	}
+
+	if state.App != nil {
+		if err := p.importApp(state.App, verbosef); err != nil {
+			return errors.Wrapf(err, "creating app %s", state.App.Name)
+		}
+	}
+	if state.Credential != nil {
+		verbosef("creating credential...")
+		cred := &keySecret{Key: state.Credential.Key, Secret: state.Credential.Secret}
+		if err := p.registerLegacyCredential(cred); err != nil {
+			return errors.Wrap(err, "creating credential")
+		}
+	}
+
+	if state.KVM != nil {
+		resp, err := p.ApigeeClient.KVMService.Create(*state.KVM)
+		if err != nil {
+			if resp == nil || resp.StatusCode != http.StatusConflict {
+				return errors.Wrapf(err, "creating kvm %s", state.KVM.Name)
+			}
+			printf("warning: kvm %s already exists, its entries are not changed", state.KVM.Name)
+		} else {
+			verbosef("kvm %s created", state.KVM.Name)
+		}
+		if _, ok := state.KVM.GetValue("private_key"); !ok {
+			printf("warning: kvm %s has no private key, use 'token rotate-cert' to create new keys", state.KVM.Name)
+		}
+	}
+
+	printf("state of %s/%s imported into %s/%s", state.Organization, state.Environment, p.Org, p.Env)
+	return nil
+}
+
+// importProduct creates the product, available in the environment in place
+// of the exported one
+func (p *provision) importProduct(product apiProduct, exportedEnv string, verbosef shared.FormatFn) error {
+	for i, env := range product.Environments {
+		if env == exportedEnv {
+			product.Environments[i] = p.Env
+		}
+	}
+	req, err := p.ApigeeClient.NewRequestNoEnv(http.MethodPost, apiProductsPath, product)
+	if err != nil {
+		return err
+	}
+	if resp, err := p.ApigeeClient.Do(req, nil); err != nil {
+		if resp == nil || resp.StatusCode != http.StatusConflict {
+			return err
+		}
+		verbosef("product %s already exists", product.Name)
+		return nil
+	}
+	verbosef("product %s created", product.Name)
+	return nil
+}
+
+// importApp creates the app and adds the exported keys it lacks. The key
+// generated with a new app is deleted.
+func (p *provision) importApp(app *exportedApp, verbosef shared.FormatFn) error {
+	p.useAppGroup = app.AppGroup != ""
+	p.credential.developer = app.Developer
+	p.credential.app = app.Name
+	owner := app.Developer
+	if p.useAppGroup {
+		owner = app.AppGroup
+	}
+
+	var resp *apigee.Response
+	var err error
+	if p.useAppGroup {
+		_, resp, err = p.ApigeeClient.AppGroups.GetApp(owner, app.Name)
+	} else {
+		_, resp, err = p.ApigeeClient.DeveloperApps.Get(owner, app.Name)
+	}
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return errors.Wrapf(err, "retrieving app %s", app.Name)
+	}
+	existed := err == nil
+
+	var creds []apigee.AppCredential
+	if p.useAppGroup {
+		creds, err = p.createAppGroupApp(verbosef)
+	} else {
+		creds, err = p.createDeveloperApp(verbosef)
+	}
+	if err != nil {
+		return err
+	}
+
+	has := map[string]bool{}
+	for _, c := range creds {
+		has[c.ConsumerKey] = true
+	}
+	exported := map[string]bool{}
+	for _, k := range app.Credentials {
+		exported[k.Key] = true
+		if has[k.Key] {
+			verbosef("key %s already exists", k.Key)
+			continue
+		}
+		if p.useAppGroup {
+			_, _, err = p.ApigeeClient.AppGroups.CreateKey(owner, app.Name, k.Key, k.Secret)
+		} else {
+			_, _, err = p.ApigeeClient.DeveloperApps.CreateKey(owner, app.Name, k.Key, k.Secret)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "creating key %s", k.Key)
+		}
+		if len(k.Products) > 0 {
+			if p.useAppGroup {
+				_, err = p.ApigeeClient.AppGroups.AddKeyProducts(owner, app.Name, k.Key, k.Products, k.Scopes)
+			} else {
+				_, err = p.ApigeeClient.DeveloperApps.AddKeyProducts(owner, app.Name, k.Key, k.Products, k.Scopes)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "granting products to key %s", k.Key)
+			}
+		}
+		verbosef("key %s created", k.Key)
+	}
+
+	if existed {
+		return nil
+	}
+	for _, c := range creds {
+		if c.ConsumerKey == "" || exported[c.ConsumerKey] {
+			continue
+		}
+		if p.useAppGroup {
+			_, err = p.ApigeeClient.AppGroups.DeleteKey(owner, app.Name, c.ConsumerKey)
+		} else {
+			_, err = p.ApigeeClient.DeveloperApps.DeleteKey(owner, app.Name, c.ConsumerKey)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "deleting generated key %s", c.ConsumerKey)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/spf13/cobra"
+)
+
+func stateTestCmd(rootArgs *shared.RootArgs, printf shared.FormatFn, url string) *cobra.Command {
+	c := StateCmd(rootArgs, printf)
+
+	defaultPersistentPreRun := c.PersistentPreRunE
+	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := defaultPersistentPreRun(cmd, args); err != nil {
+			return err
+		}
+		setTestUrls(rootArgs, url)
+		return nil
+	}
+
+	return c
+}
+
+func TestStateExportImport(t *testing.T) {
+	digest := strings.Repeat("a", 64)
+	bundle := []byte("PK zipped bundle")
+	var calls []string
+	var importedBundle []byte
+	var importedProduct apiProduct
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			calls = append(calls, r.Method+" "+r.URL.Path)
+		}
+		src := "/v1/organizations/src"
+		dst := "/v1/organizations/dst"
+		app := "/developers/remote-service@apigee.com/apps/remote-service"
+		switch {
+		case r.URL.Path == src+"/environments/test/apis/remote-service/deployments":
+			_ = json.NewEncoder(w).Encode(apigee.GCPDeployments{Deployments: []apigee.GCPDeployment{
+				{Environment: "test", Name: authProxyName, Revision: "2"}}})
+		case r.URL.Path == src+"/apis/remote-service/revisions/2" && r.URL.Query().Get("format") == "bundle":
+			_, _ = w.Write(bundle)
+		case r.URL.Path == src+"/apis/remote-service/revisions/2":
+			_ = json.NewEncoder(w).Encode(apigee.ProxyRevision{Name: authProxyName, Description: "(bundle sha256:" + digest + ")"})
+		case r.URL.Path == src+"/apiproducts/remote-service":
+			_ = json.NewEncoder(w).Encode(apiProduct{Name: "remote-service", Environments: []string{"test", "other"}, Proxies: []string{authProxyName}})
+		case r.URL.Path == src+app:
+			_ = json.NewEncoder(w).Encode(apigee.DeveloperApp{Name: "remote-service", Credentials: []apigee.AppCredential{
+				{ConsumerKey: "key", ConsumerSecret: "secret", Status: "approved", Scopes: []string{"read"},
+					APIProducts: []apigee.AppCredentialProduct{{APIProduct: "remote-service", Status: "approved"}, {APIProduct: "gone", Status: revokedStatus}}},
+				{ConsumerKey: "revoked", ConsumerSecret: "secret", Status: revokedStatus},
+			}})
+
+		case r.URL.Path == dst+"/apis" && r.Method == http.MethodPost:
+			var err error
+			if importedBundle, err = ioutil.ReadAll(r.Body); err != nil {
+				t.Fatal(err)
+			}
+			_ = json.NewEncoder(w).Encode(apigee.ProxyRevision{Name: authProxyName, Revision: 1})
+		case r.URL.Path == dst+"/apiproducts" && r.Method == http.MethodPost:
+			if err := json.NewDecoder(r.Body).Decode(&importedProduct); err != nil {
+				t.Fatal(err)
+			}
+			w.WriteHeader(http.StatusCreated)
+		case r.URL.Path == dst+"/developers/remote-service@apigee.com/apps" && r.Method == http.MethodPost:
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(apigee.DeveloperApp{Name: "remote-service", Credentials: []apigee.AppCredential{
+				{ConsumerKey: "generated", ConsumerSecret: "secret"}}})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, dst):
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte("{}"))
+		}
+	}))
+	defer ts.Close()
+
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		calls = nil
+		print := testutil.Printer("TestStateExportImport")
+		rootArgs := &shared.RootArgs{}
+		rootCmd := cmd.GetRootCmd(append([]string{"state"}, args...), print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, stateTestCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.json")
+
+	print, err := run("export", file, "-o", "src", "-e", "test", "-t", "token")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{"state of src/test written to " + file + ", it holds credentials: keep it safe"})
+	if len(calls) != 0 {
+		t.Errorf("want no changes by export, got %v", calls)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("want file readable by owner only, got %v, %v", info, err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state provisionedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Platform != "hybrid" || len(state.Proxies) != 1 || state.Proxies[0].Digest != digest || !bytes.Equal(state.Proxies[0].Bundle, bundle) {
+		t.Errorf("unexpected exported proxies %#v", state)
+	}
+	wantKeys := []exportedKey{{Key: "key", Secret: "secret", Products: []string{"remote-service"}, Scopes: []string{"read"}}}
+	if state.App == nil || !reflect.DeepEqual(wantKeys, state.App.Credentials) {
+		t.Errorf("want keys %v, got %v", wantKeys, state.App)
+	}
+
+	print, err = run("import", file, "-o", "dst", "-e", "prod", "-t", "token")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{"state of src/test imported into dst/prod"})
+	if !bytes.Contains(importedBundle, bundle) {
+		t.Errorf("want exported bundle imported, got %q", importedBundle)
+	}
+	if want := []string{"prod", "other"}; !reflect.DeepEqual(want, importedProduct.Environments) {
+		t.Errorf("want product environments %v, got %v", want, importedProduct.Environments)
+	}
+	want := []string{
+		"POST /v1/organizations/dst/apis",
+		"POST /v1/organizations/dst/environments/prod/apis/remote-service/revisions/1/deployments",
+		"POST /v1/organizations/dst/apiproducts",
+		"POST /v1/organizations/dst/developers",
+		"POST /v1/organizations/dst/developers/remote-service@apigee.com/apps",
+		"POST /v1/organizations/dst/developers/remote-service@apigee.com/apps/remote-service/keys",
+		"POST /v1/organizations/dst/developers/remote-service@apigee.com/apps/remote-service/keys/key",
+		"DELETE /v1/organizations/dst/developers/remote-service@apigee.com/apps/remote-service/keys/generated",
+	}
+	if !reflect.DeepEqual(want, calls) {
+		t.Errorf("want calls:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(calls, "\n"))
+	}
+
+	_, err = run("import", file, "-o", "dst", "-e", "prod", "-u", "me", "-p", "password", "--legacy")
+	testutil.ErrorContains(t, err, "is for hybrid, can't be imported into legacy")
+
+	state.Proxies[0].Name = "../remote-service"
+	if data, err = json.Marshal(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	calls = nil
+	_, err = run("import", file, "-o", "dst", "-e", "prod", "-t", "token")
+	testutil.ErrorContains(t, err, `has proxy "../remote-service", only remote-service and edgemicro-internal can be imported`)
+	if len(calls) != 0 {
+		t.Errorf("want no changes by a rejected import, got %v", calls)
+	}
+}
+
+func TestStateExportLegacy(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/keyvaluemaps/remote-service"):
+			_ = json.NewEncoder(w).Encode(apigee.KVM{Name: "remote-service", Encrypted: true, Entries: []apigee.Entry{
+				{Name: "private_key", Value: apigee.MaskedValue},
+				{Name: "kid", Value: "1"},
+			}})
+		case strings.HasSuffix(r.URL.Path, "/caches/remote-service"):
+			_, _ = w.Write([]byte(`{"name":"remote-service"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.json")
+
+	print := testutil.Printer("TestStateExportLegacy")
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"state", "export", file, "-o", "org", "-e", "test", "-u", "me", "-p", "password", "--legacy"}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, stateTestCmd(rootArgs, print.Printf, ts.URL))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{
+		"warning: proxy remote-service is not deployed to test, not exported",
+		"warning: entry private_key of encrypted kvm remote-service can't be read, not exported",
+		"warning: product remote-service not found, not exported",
+		"warning: no --config, the credential is not exported",
+		"state of org/test written to " + file + ", it holds credentials: keep it safe",
+	})
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state provisionedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	wantKVM := &apigee.KVM{Name: "remote-service", Encrypted: true, Entries: []apigee.Entry{{Name: "kid", Value: "1"}}}
+	if state.Platform != "legacy" || state.Cache != "remote-service" || !reflect.DeepEqual(wantKVM, state.KVM) {
+		t.Errorf("unexpected state %#v", state)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/pkg/errors"
+)
+
+// storage of the proxy's keys on hybrid and Apigee X, by default hybrid
+// reads them from the policy secret
+const (
+	storageKVM         = "kvm"
+	storagePropertySet = "propertyset"
+
+	propertySetName     = "remote-service"
+	propertySetFileType = "properties"
+
+	runtimeTypeCloud = "CLOUD"
+
+	secretVariablePrefix = "private.secret.remote-service."
+	secretPropsPrefix    = secretVariablePrefix + "properties."
+	kvmVariablePrefix    = "private.remote-service."
+	kvmPolicyName        = "Get-Remote-Service-Keys"
+)
+
+const kvmPolicy = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<KeyValueMapOperations mapIdentifier="remote-service" async="false" continueOnError="false" enabled="true" name="Get-Remote-Service-Keys">
+    <DisplayName>Get Remote Service Keys</DisplayName>
+    <ExpiryTimeInSecs>300</ExpiryTimeInSecs>
+    <Get assignTo="private.remote-service.private_key">
+        <Key>
+            <Parameter>private_key</Parameter>
+        </Key>
+    </Get>
+    <Get assignTo="private.remote-service.jwks">
+        <Key>
+            <Parameter>jwks</Parameter>
+        </Key>
+    </Get>
+    <Get assignTo="private.remote-service.kid">
+        <Key>
+            <Parameter>kid</Parameter>
+        </Key>
+    </Get>
+    <Scope>environment</Scope>
+</KeyValueMapOperations>`
+
+const kvmGetFormat = `    <Get assignTo="private.remote-service.%[1]s">
+        <Key>
+            <Parameter>%[1]s</Parameter>
+        </Key>
+    </Get>
+`
+
+// properties stored with the keys to override the claims of the tokens
+// issued by the remote-service proxy
+const (
+	issuerProperty   = "iss"
+	audienceProperty = "aud"
+)
+
+// claimProperties returns the properties overriding token claims, if any
+func (p *provision) claimProperties() []apigee.Entry {
+	var entries []apigee.Entry
+	if p.jwtIssuer != "" {
+		entries = append(entries, apigee.Entry{Name: issuerProperty, Value: p.jwtIssuer})
+	}
+	if len(p.jwtAudiences) > 0 {
+		entries = append(entries, apigee.Entry{Name: audienceProperty, Value: strings.Join(p.jwtAudiences, ",")})
+	}
+	return entries
+}
+
+// rewriteClaimReferences makes the token policies of the remote-service proxy
+// read the overridden claims from the policy secret's properties
+func (p *provision) rewriteClaimReferences(proxyDir string) error {
+	var replacements []string
+	for _, e := range p.claimProperties() {
+		switch e.Name {
+		case issuerProperty:
+			replacements = append(replacements, `<Issuer ref="iss"/>`,
+				`<Issuer ref="`+secretPropsPrefix+issuerProperty+`"/>`)
+		case audienceProperty:
+			replacements = append(replacements, `<Audience>remote-service-client</Audience>`,
+				`<Audience ref="`+secretPropsPrefix+audienceProperty+`"/>`)
+		}
+	}
+	if len(replacements) == 0 {
+		return nil
+	}
+	replacer := strings.NewReplacer(replacements...)
+	for _, name := range []string{"Generate-Access-Token.xml", "Generate-VerifyKey-Token.xml"} {
+		file := filepath.Join(proxyDir, "policies", name)
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "reading file %s", file)
+		}
+		if err := ioutil.WriteFile(file, []byte(replacer.Replace(string(data))), 0); err != nil {
+			return errors.Wrapf(err, "writing file %s", file)
+		}
+	}
+	return nil
+}
+
+// resolveStorage selects the property set for Apigee X unless --storage is given
+func (p *provision) resolveStorage(verbosef shared.FormatFn) error {
+	if !p.IsGCPManaged || p.storage != "" {
+		return nil
+	}
+	req, err := p.ApigeeClient.NewRequestNoEnv(http.MethodGet, "", nil)
+	if err != nil {
+		return err
+	}
+	org := struct {
+		RuntimeType string `json:"runtimeType"`
+	}{}
+	if _, err := p.ApigeeClient.Do(req, &org); err != nil {
+		return errors.Wrapf(err, "retrieving organization %s", p.Org)
+	}
+	if org.RuntimeType == runtimeTypeCloud {
+		p.storage = storagePropertySet
+		verbosef("Apigee X organization, storing keys in property set %s", propertySetName)
+	}
+	return nil
+}
+
+// rewriteKeyReferences makes the remote-service proxy read its keys from the
+// selected storage instead of the policy secret
+func (p *provision) rewriteKeyReferences(proxyDir string) error {
+	prefix := "propertyset." + propertySetName + "."
+	if p.storage == storageKVM {
+		prefix = kvmVariablePrefix
+		policy := kvmPolicy
+		for _, e := range p.claimProperties() {
+			policy = strings.Replace(policy, "    <Scope>", fmt.Sprintf(kvmGetFormat, e.Name)+"    <Scope>", 1)
+		}
+		policyFile := filepath.Join(proxyDir, "policies", kvmPolicyName+".xml")
+		if err := ioutil.WriteFile(policyFile, []byte(policy), 0644); err != nil {
+			return errors.Wrapf(err, "writing file %s", policyFile)
+		}
+	}
+
+	replacer := strings.NewReplacer(
+		secretVariablePrefix+"key", prefix+"private_key",
+		secretVariablePrefix+"crt", prefix+"jwks",
+		secretPropsPrefix+"kid", prefix+"kid",
+		secretPropsPrefix+issuerProperty, prefix+issuerProperty,
+		secretPropsPrefix+audienceProperty, prefix+audienceProperty,
+	)
+	files, err := filepath.Glob(filepath.Join(proxyDir, "*", "*.xml"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "reading file %s", file)
+		}
+		content := replacer.Replace(string(data))
+		if p.storage == storageKVM && filepath.Base(file) == "default.xml" {
+			content = strings.Replace(content, `<PreFlow name="PreFlow">
+        <Request>`, `<PreFlow name="PreFlow">
+        <Request>
+            <Step>
+                <Name>`+kvmPolicyName+`</Name>
+            </Step>`, 1)
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0); err != nil {
+			return errors.Wrapf(err, "writing file %s", file)
+		}
+	}
+	return nil
+}
+
+// storeKeys writes the proxy's keys to the selected storage, named as in the legacy kvm
+func (p *provision) storeKeys(config *server.Config, verbosef shared.FormatFn) error {
+	jwks, err := json.Marshal(config.Tenant.JWKS)
+	if err != nil {
+		return err
+	}
+	entries := []apigee.Entry{
+		{Name: "private_key", Value: string(pem.EncodeToMemory(&pem.Block{Type: server.PEMKeyType,
+			Bytes: x509.MarshalPKCS1PrivateKey(config.Tenant.PrivateKey)}))},
+		{Name: "jwks", Value: string(jwks)},
+		{Name: "kid", Value: config.Tenant.PrivateKeyID},
+	}
+	entries = append(entries, p.claimProperties()...)
+
+	if p.storage == storageKVM {
+		return p.storeKeysInKVM(entries, verbosef)
+	}
+	return p.storeKeysInPropertySet(entries, verbosef)
+}
+
+func (p *provision) storeKeysInKVM(entries []apigee.Entry, verbosef shared.FormatFn) error {
+	name := p.resources.kvmName()
+	resp, err := p.ApigeeClient.KVMService.Create(apigee.KVM{Name: name, Encrypted: p.encryptKVM})
+	if err != nil {
+		if resp == nil || resp.StatusCode != http.StatusConflict {
+			return errors.Wrapf(err, "creating kvm %s", name)
+		}
+		verbosef("kvm %s already exists", name)
+	} else {
+		p.onRollback("kvm "+name, p.deleteKVM)
+	}
+	for _, entry := range entries {
+		resp, err := p.ApigeeClient.KVMService.AddEntry(name, entry)
+		if err != nil && resp != nil && resp.StatusCode == http.StatusConflict {
+			_, err = p.ApigeeClient.KVMService.UpdateEntry(name, entry)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "storing %s in kvm %s", entry.Name, name)
+		}
+	}
+	verbosef("keys stored in kvm %s", name)
+	return nil
+}
+
+func (p *provision) storeKeysInPropertySet(entries []apigee.Entry, verbosef shared.FormatFn) error {
+	content := propertiesFile(entries)
+	resp, err := p.ApigeeClient.ResourceFiles.Create(propertySetFileType, propertySetName, strings.NewReader(content))
+	if err != nil && resp != nil && resp.StatusCode == http.StatusConflict {
+		verbosef("property set %s already exists", propertySetName)
+		_, err = p.ApigeeClient.ResourceFiles.Update(propertySetFileType, propertySetName, strings.NewReader(content))
+	} else if err == nil {
+		p.onRollback("property set "+propertySetName, func(printf shared.FormatFn) error {
+			resp, err := p.ApigeeClient.ResourceFiles.Delete(propertySetFileType, propertySetName)
+			return deleted("property set", propertySetName, resp, err, printf)
+		})
+	}
+	if err != nil {
+		return errors.Wrapf(err, "storing property set %s", propertySetName)
+	}
+	verbosef("keys stored in property set %s", propertySetName)
+	return nil
+}
+
+// propertiesFile formats entries as a properties file, escaping line breaks
+func propertiesFile(entries []apigee.Entry) string {
+	sorted := append([]apigee.Entry{}, entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	escaper := strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	var b strings.Builder
+	for _, e := range sorted {
+		fmt.Fprintf(&b, "%s=%s\n", e.Name, escaper.Replace(e.Value))
+	}
+	return b.String()
+}
@@ -0,0 +1,299 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	supportBundleManifest = "manifest.json"
+	redacted              = "REDACTED"
+	adapterSelector       = "app=apigee-remote-service-envoy"
+)
+
+// adapterLogs returns the recent logs of the adapter pods, var for tests
+var adapterLogs = func(namespace string, lines int) ([]byte, error) {
+	if _, err := exec.LookPath("kubectl"); err != nil {
+		return nil, fmt.Errorf("kubectl not found")
+	}
+	if os.Getenv("KUBECONFIG") == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(filepath.Join(home, ".kube", "config")); err != nil {
+			return nil, fmt.Errorf("no kubeconfig")
+		}
+	}
+	return exec.Command("kubectl", "logs", "-n", namespace, "-l", adapterSelector,
+		"--all-containers", "--prefix", "--tail", strconv.Itoa(lines)).CombinedOutput()
+}
+
+type supportBundle struct {
+	*doctor
+	logLines int
+	files    []bundleFile
+	now      func() time.Time
+}
+
+// bundleFile is an entry of the bundle, Error tells why it has no content
+type bundleFile struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Error       string `json:"error,omitempty"`
+	content     []byte
+}
+
+// bundleManifest describes the bundle and the environment it was collected from
+type bundleManifest struct {
+	Created      time.Time            `json:"created"`
+	CLI          shared.BuildInfoType `json:"cli"`
+	Organization string               `json:"organization,omitempty"`
+	Environment  string               `json:"environment,omitempty"`
+	Platform     string               `json:"platform"`
+	Files        []bundleFile         `json:"files"`
+}
+
+// SupportBundleCmd returns the support-bundle command
+func SupportBundleCmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	b := &supportBundle{
+		doctor: &doctor{provision: &provision{RootArgs: rootArgs}},
+		now:    time.Now,
+	}
+
+	c := &cobra.Command{
+		Use:   "support-bundle [file]",
+		Short: "Collect diagnostics of your remote service setup into a file to attach to issues",
+		Long: `The support-bundle command collects the CLI version, the config given by --config with its
+credentials redacted, the results of doctor, the JWKS served by the remote-service proxy, the
+deployment status of the proxy and, if kubectl and a kubeconfig are available, the recent logs
+of the adapter into a tar.gz file (default: apigee-remote-service-support-<time>.tar.gz) with a
+manifest. Nothing is changed and items that can't be collected are noted in the manifest.`,
+		Args: cobra.MaximumNArgs(1),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if b.logLines < 0 {
+				return fmt.Errorf("--log-lines must not be negative")
+			}
+			b.detectPlatform(shared.Errorf)
+			return rootArgs.Resolve(false, false)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			file := ""
+			if len(args) > 0 {
+				file = args[0]
+			}
+			return b.run(file, printf)
+		},
+	}
+
+	c.Flags().StringVarP(&rootArgs.ManagementBase, "management", "m",
+		shared.DefaultManagementBase, "Apigee management base URL")
+	c.Flags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.Flags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+
+	c.Flags().StringVarP(&rootArgs.Token, "token", "t", "",
+		"Apigee OAuth or SAML token (hybrid only)")
+	c.Flags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
+	c.Flags().StringVarP(&rootArgs.Username, "username", "u", "",
+		"Apigee username (legacy or OPDK only)")
+	c.Flags().StringVarP(&rootArgs.Password, "password", "p", "",
+		"Apigee password (legacy or OPDK only)")
+	c.Flags().StringVarP(&rootArgs.Namespace, "namespace", "n", "apigee",
+		"namespace of the adapter pods")
+
+	c.Flags().IntVarP(&b.logLines, "log-lines", "", 500,
+		"lines of the log of each adapter container to collect, 0 for none")
+
+	return c
+}
+
+func (b *supportBundle) run(file string, printf shared.FormatFn) error {
+	now := b.now().UTC()
+	if file == "" {
+		file = fmt.Sprintf("apigee-remote-service-support-%s.tar.gz", now.Format("20060102T150405Z"))
+	}
+
+	b.collect("config.yaml", "the config given by --config, credentials redacted", b.redactedConfig)
+	b.collect("doctor.txt", "the problems found by doctor", b.doctorResults)
+	b.collect("jwks.json", "the JWKS served by the remote-service proxy", b.jwks)
+	b.collect("deployments.json", "the deployments of the remote-service proxy", b.deployments)
+	if b.logLines > 0 {
+		b.collect("adapter.log", "the recent logs of the adapter pods", func() ([]byte, error) {
+			return adapterLogs(b.Namespace, b.logLines)
+		})
+	}
+
+	manifest := bundleManifest{
+		Created:      now,
+		CLI:          shared.BuildInfo,
+		Organization: b.Org,
+		Environment:  b.Env,
+		Platform:     b.platform(),
+		Files:        b.files,
+	}
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := b.write(file, now, manifestJSON); err != nil {
+		return err
+	}
+	missing := 0
+	for _, f := range b.files {
+		if f.Error != "" {
+			missing++
+		}
+	}
+	printf("wrote support bundle to %s (%d of %d item(s) collected, see %s)", file, len(b.files)-missing, len(b.files), supportBundleManifest)
+	return nil
+}
+
+// collect adds a file of the bundle, noting why it's missing if fn fails
+func (b *supportBundle) collect(name, description string, fn func() ([]byte, error)) {
+	f := bundleFile{Name: name, Description: description}
+	content, err := fn()
+	if err != nil {
+		f.Error = err.Error()
+	} else {
+		f.content = content
+	}
+	b.files = append(b.files, f)
+}
+
+// write creates the tar.gz of the manifest and all files collected
+func (b *supportBundle) write(file string, now time.Time, manifest []byte) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	add := func(name string, content []byte) error {
+		hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+
+	errs := add(supportBundleManifest, manifest)
+	for _, f := range b.files {
+		if f.Error == "" {
+			errs = multierr.Append(errs, add(f.Name, f.content))
+		}
+	}
+	errs = multierr.Combine(errs, tw.Close(), gz.Close())
+	if errs != nil {
+		return errors.Wrap(errs, "creating support bundle")
+	}
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0600); err != nil {
+		return errors.Wrapf(err, "writing %s", file)
+	}
+	return nil
+}
+
+// redactedConfig returns the loaded config without its credentials, the
+// private key and JWKS of the policy secret are never marshaled
+func (b *supportBundle) redactedConfig() ([]byte, error) {
+	if b.ServerConfig == nil {
+		return nil, fmt.Errorf("no --config given")
+	}
+	config := *b.ServerConfig
+	if config.Tenant.Key != "" {
+		config.Tenant.Key = redacted
+	}
+	if config.Tenant.Secret != "" {
+		config.Tenant.Secret = redacted
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&config); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// doctorResults runs doctor without fixing anything and returns its output
+func (b *supportBundle) doctorResults() ([]byte, error) {
+	var lines []string
+	printf := func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+	d := *b.doctor
+	d.fix = false
+	if err := d.run(printf); err != nil {
+		lines = append(lines, err.Error())
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+func (b *supportBundle) jwks() ([]byte, error) {
+	certsURL := fmt.Sprintf(certsURLFormat, b.RemoteServiceProxyURL)
+	client := &http.Client{
+		Transport: b.RuntimeTransport(b.InsecureSkipVerify),
+		Timeout:   doctorRuntimeTimeout,
+	}
+	resp, err := client.Get(certsURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", certsURL)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", certsURL, resp.Status)
+	}
+	return body, nil
+}
+
+func (b *supportBundle) deployments() ([]byte, error) {
+	var deployments interface{}
+	var err error
+	if b.IsGCPManaged {
+		deployments, _, err = b.ApigeeClient.Proxies.GetGCPDeployments(authProxyName)
+	} else {
+		deployments, _, err = b.ApigeeClient.Proxies.GetDeployment(authProxyName)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "retrieving deployments of proxy %s", authProxyName)
+	}
+	return json.MarshalIndent(deployments, "", "  ")
+}
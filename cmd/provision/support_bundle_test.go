@@ -0,0 +1,168 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/spf13/cobra"
+)
+
+func TestSupportBundle(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method != http.MethodGet:
+			t.Errorf("want no changes, got %s %s", r.Method, r.URL.Path)
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service/deployments"):
+			_ = json.NewEncoder(w).Encode(apigee.GCPDeployments{Deployments: []apigee.GCPDeployment{
+				{Environment: "test", Name: "remote-service", Revision: "2"},
+			}})
+		case r.URL.Path == "/remote-service/certs":
+			_, _ = w.Write([]byte(`{"keys":[]}`))
+		default:
+			_, _ = w.Write([]byte("{}"))
+		}
+	}))
+	defer ts.Close()
+
+	defer func(logs func(string, int) ([]byte, error)) { adapterLogs = logs }(adapterLogs)
+	adapterLogs = func(namespace string, lines int) ([]byte, error) {
+		return []byte(fmt.Sprintf("%d lines of %s", lines, namespace)), nil
+	}
+
+	dir, err := ioutil.TempDir("", "support-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "bundle.tar.gz")
+
+	print := testutil.Printer("TestSupportBundle")
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"support-bundle", file, "-o", "hybrid", "-e", "test", "-t", "token", "-n", "ns", "--log-lines", "10"}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testSupportBundleCmd(rootArgs, print.Printf, ts.URL))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{fmt.Sprintf("wrote support bundle to %s (4 of 5 item(s) collected, see manifest.json)", file)})
+
+	files := readBundle(t, file)
+	var manifest bundleManifest
+	if err := json.Unmarshal(files[supportBundleManifest], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Organization != "hybrid" || manifest.Platform != "hybrid" || len(manifest.Files) != 5 {
+		t.Errorf("unexpected manifest %s", files[supportBundleManifest])
+	}
+	if manifest.Files[0].Name != "config.yaml" || manifest.Files[0].Error != "no --config given" {
+		t.Errorf("want config.yaml noted missing, got %v", manifest.Files[0])
+	}
+	for name, want := range map[string]string{
+		"doctor.txt":       "no problems found",
+		"jwks.json":        `{"keys":[]}`,
+		"deployments.json": `"revision": "2"`,
+		"adapter.log":      "10 lines of ns",
+	} {
+		if !strings.Contains(string(files[name]), want) {
+			t.Errorf("want %q in %s, got %q", want, name, files[name])
+		}
+	}
+
+	flags = []string{"support-bundle", "-o", "hybrid", "-t", "token", "--log-lines", "-1"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testSupportBundleCmd(rootArgs, print.Printf, ts.URL))
+	testutil.ErrorContains(t, rootCmd.Execute(), "--log-lines must not be negative")
+}
+
+func TestRedactedConfig(t *testing.T) {
+	b := &supportBundle{doctor: &doctor{provision: &provision{RootArgs: &shared.RootArgs{}}}}
+	if _, err := b.redactedConfig(); err == nil {
+		t.Errorf("want error without config")
+	}
+	b.ServerConfig = &server.Config{Tenant: server.TenantConfig{
+		OrgName: "org",
+		Key:     "mykey",
+		Secret:  "mysecret",
+	}}
+	config, err := b.redactedConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), "mykey") || strings.Contains(string(config), "mysecret") ||
+		!strings.Contains(string(config), "key: "+redacted) || !strings.Contains(string(config), "org_name: org") {
+		t.Errorf("want credentials redacted, got:\n%s", config)
+	}
+	if b.ServerConfig.Tenant.Key != "mykey" {
+		t.Errorf("want config unchanged")
+	}
+}
+
+func readBundle(t *testing.T, file string) map[string][]byte {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[hdr.Name], err = ioutil.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testSupportBundleCmd(rootArgs *shared.RootArgs, printf shared.FormatFn, url string) *cobra.Command {
+	c := SupportBundleCmd(rootArgs, printf)
+
+	defaultPersistentPreRun := c.PersistentPreRunE
+	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := defaultPersistentPreRun(cmd, args); err != nil {
+			return err
+		}
+		setTestUrls(rootArgs, url)
+		return nil
+	}
+
+	return c
+}
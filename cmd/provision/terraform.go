@@ -0,0 +1,261 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/pkg/errors"
+)
+
+const terraformFile = "remote-service.tf"
+
+// terraformData is the provisioned state described by the Terraform
+// configuration of --terraform-out
+type terraformData struct {
+	Org       string
+	Env       string
+	Created   string
+	Proxy     *shared.DeployedProxy // nil with --skip-proxy
+	Bundle    string                // file of the proxy bundle
+	Product   *apiProduct           // nil with --skip-product
+	Developer string                // owning the app, if no AppGroup
+	AppGroup  string
+	App       string // empty with --skip-credential
+	Namespace string
+	ConfigMap string
+	Secret    *server.SecretCRD
+	Files     []string // of the policy secret, in policy-secret/
+}
+
+func (p *provision) validateTerraform() error {
+	if p.terraformDir == "" {
+		return nil
+	}
+	if !p.IsGCPManaged {
+		return fmt.Errorf("--terraform-out only valid for hybrid or Apigee X")
+	}
+	if p.configOut == configOutNative || p.dryRun || p.verifyOnly {
+		return fmt.Errorf("--terraform-out can't be combined with --config-out %s, --dry-run or --verify-only", configOutNative)
+	}
+	if len(p.envs) > 1 {
+		return fmt.Errorf("--terraform-out supports a single environment")
+	}
+	return nil
+}
+
+// writeTerraform writes the Terraform configuration of what provision
+// created to --terraform-out, with the proxy bundle, config.yaml and the
+// policy secret files it refers to. Returns the configuration file.
+func (p *provision) writeTerraform(configYAML string, secret *server.SecretCRD) (string, error) {
+	dir := p.terraformDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "creating %s", dir)
+	}
+	data := terraformData{
+		Org:       p.Org,
+		Env:       p.Env,
+		Created:   time.Now().Format("2006-01-02 15:04:05"),
+		Namespace: p.Namespace,
+		ConfigMap: p.configMapName(),
+		Secret:    secret,
+	}
+	if data.Namespace == "" {
+		data.Namespace = "default"
+	}
+
+	files := map[string][]byte{nativeConfigFile: []byte(configYAML)}
+	for _, proxy := range p.deployed {
+		if proxy.Name != authProxyName || p.authProxyBundle == "" {
+			continue
+		}
+		bundle, err := ioutil.ReadFile(p.authProxyBundle)
+		if err != nil {
+			return "", errors.Wrap(err, "reading proxy bundle")
+		}
+		proxy := proxy
+		data.Proxy = &proxy
+		data.Bundle = authProxyName + ".zip"
+		files[data.Bundle] = bundle
+	}
+	if !p.skip.product {
+		product := p.apiProductSpec()
+		data.Product = &product
+	}
+	if !p.skip.credential {
+		data.App = p.credential.appName()
+		if p.useAppGroup {
+			data.AppGroup = shared.DefaultAppGroupName
+		} else {
+			data.Developer = p.credential.developerEmail()
+		}
+	}
+	secretDir := filepath.Join(dir, nativeSecretDir)
+	names, err := writePolicySecret(secretDir, secret)
+	if err != nil {
+		return "", err
+	}
+	data.Files = names
+
+	tf, err := shared.RenderTemplate(terraformFile, terraformTemplate, data)
+	if err != nil {
+		return "", err
+	}
+	files[terraformFile] = []byte(tf)
+	for name, content := range files {
+		mode := os.FileMode(0644)
+		if name == nativeConfigFile {
+			mode = 0600 // holds the credential
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, mode); err != nil {
+			return "", errors.Wrapf(err, "writing %s", name)
+		}
+	}
+	return filepath.Join(dir, terraformFile), nil
+}
+
+const terraformTemplate = `# Remote-service artifacts of {{.Org}}/{{.Env}} provisioned by apigee-remote-service-cli on {{.Created}}
+# the import blocks (Terraform 1.5+) take the existing artifacts into the state on the
+# next apply, remove them once imported. Check the arguments against the version of the
+# providers used. config.yaml and policy-secret/ hold credentials, keep them out of Git.
+
+terraform {
+  required_providers {
+    google = {
+      source = "hashicorp/google"
+    }
+    kubernetes = {
+      source = "hashicorp/kubernetes"
+    }
+  }
+}
+{{- with .Proxy}}
+
+# revision {{.Revision}} is deployed to {{$.Env}}, the deployment is left to provision
+import {
+  to = google_apigee_api.remote_service
+  id = "organizations/{{$.Org}}/apis/{{.Name}}"
+}
+
+resource "google_apigee_api" "remote_service" {
+  org_id        = {{quote $.Org}}
+  name          = {{quote .Name}}
+  config_bundle = "${path.module}/{{$.Bundle}}"
+}
+{{- end}}
+{{- with .Product}}
+
+import {
+  to = google_apigee_api_product.remote_service
+  id = "organizations/{{$.Org}}/apiproducts/{{.Name}}"
+}
+
+resource "google_apigee_api_product" "remote_service" {
+  org_id        = {{quote $.Org}}
+  name          = {{quote .Name}}
+  display_name  = {{quote .DisplayName}}
+  description   = {{quote .Description}}
+  approval_type = {{quote .ApprovalType}}
+  api_resources = {{template "list" .APIResources}}
+  environments  = {{template "list" .Environments}}
+  proxies       = {{template "list" .Proxies}}
+{{- if .Scopes}}
+  scopes        = {{template "list" .Scopes}}
+{{- end}}
+{{- if .Quota}}
+
+  quota           = {{quote .Quota}}
+  quota_interval  = {{quote .QuotaInterval}}
+  quota_time_unit = {{quote .QuotaTimeUnit}}
+{{- end}}
+{{- range .Attributes}}
+
+  attributes {
+    name  = {{quote .Name}}
+    value = {{quote .Value}}
+  }
+{{- end}}
+}
+{{- end}}
+{{- if .Developer}}
+
+import {
+  to = google_apigee_developer.remote_service
+  id = "organizations/{{$.Org}}/developers/{{.Developer}}"
+}
+
+# owns the app {{.App}} holding the credential of the adapter, left to provision
+resource "google_apigee_developer" "remote_service" {
+  org_id     = "organizations/{{$.Org}}"
+  email      = {{quote .Developer}}
+  first_name = "remote-service"
+  last_name  = "remote-service"
+  user_name  = "remote-service"
+}
+{{- end}}
+{{- if .AppGroup}}
+
+import {
+  to = google_apigee_app_group.remote_service
+  id = "organizations/{{$.Org}}/appgroups/{{.AppGroup}}"
+}
+
+# owns the app {{.App}} holding the credential of the adapter, left to provision
+resource "google_apigee_app_group" "remote_service" {
+  org_id = "organizations/{{$.Org}}"
+  name   = {{quote .AppGroup}}
+}
+{{- end}}
+
+import {
+  to = kubernetes_config_map.remote_service
+  id = "{{.Namespace}}/{{.ConfigMap}}"
+}
+
+resource "kubernetes_config_map" "remote_service" {
+  metadata {
+    name      = {{quote .ConfigMap}}
+    namespace = {{quote .Namespace}}
+  }
+  data = {
+    "config.yaml" = file("${path.module}/config.yaml")
+  }
+}
+
+import {
+  to = kubernetes_secret.remote_service
+  id = "{{.Namespace}}/{{.Secret.Metadata.Name}}"
+}
+
+resource "kubernetes_secret" "remote_service" {
+  metadata {
+    name      = {{quote .Secret.Metadata.Name}}
+    namespace = {{quote .Namespace}}
+  }
+  type = {{quote .Secret.Type}}
+  data = {
+{{- range .Files}}
+    {{quote .}} = file("${path.module}/policy-secret/{{.}}")
+{{- end}}
+  }
+}
+{{- define "list"}}[{{range $i, $e := .}}{{if $i}}, {{end}}{{quote $e}}{{end}}]{{end}}
+`
@@ -0,0 +1,104 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestProvisionTerraform(t *testing.T) {
+	ts := httptest.NewServer(handler(t))
+	defer ts.Close()
+
+	duration = 1
+	interval = 500
+
+	dir, err := ioutil.TempDir("", "terraform")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	print := testutil.Printer("TestProvisionTerraform")
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"provision", "-o", "gcp", "-e", "test", "-r", ts.URL, "-n", "ns", "-t", "token",
+		"--terraform-out", dir, "--product-quota", "100"}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.CheckPrefix(t, []string{
+		"# Configuration for apigee-remote-service-envoy (platform: GCP)",
+		"# generated by apigee-remote-service-cli provision on",
+		"# Terraform configuration written to " + filepath.Join(dir, "remote-service.tf"),
+		"apiVersion: v1",
+	})
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "remote-service.tf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tf := string(data)
+	for _, want := range []string{
+		"# Remote-service artifacts of gcp/test provisioned by apigee-remote-service-cli on",
+		"id = \"organizations/gcp/apis/remote-service\"",
+		"config_bundle = \"${path.module}/remote-service.zip\"",
+		"id = \"organizations/gcp/apiproducts/remote-service\"",
+		"api_resources = [\"/verifyApiKey\", \"/token\"]",
+		"environments  = [\"test\"]",
+		"quota           = \"100\"",
+		"attributes {\n    name  = \"access\"\n    value = \"private\"\n  }",
+		"id = \"organizations/gcp/developers/remote-service@apigee.com\"",
+		"id = \"ns/apigee-remote-service-envoy\"",
+		"id = \"ns/gcp-test-policy-secret\"",
+		"\"remote-service.key\" = file(\"${path.module}/policy-secret/remote-service.key\")",
+	} {
+		if !strings.Contains(tf, want) {
+			t.Errorf("want %q in:\n%s", want, tf)
+		}
+	}
+	for _, file := range []string{"remote-service.zip", "config.yaml", "policy-secret/remote-service.key"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("want %s written: %v", file, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		flags   []string
+		wantErr string
+	}{
+		{[]string{"--legacy", "-o", "saas", "-e", "test", "-u", "user", "-p", "pass", "--terraform-out", dir},
+			"--terraform-out only valid for hybrid or Apigee X"},
+		{[]string{"-o", "gcp", "-e", "test,prod", "-r", ts.URL, "-t", "token", "--terraform-out", dir},
+			"--terraform-out supports a single environment"},
+		{[]string{"-o", "gcp", "-e", "test", "-r", ts.URL, "-t", "token", "--terraform-out", dir, "--dry-run"},
+			"--terraform-out can't be combined with --config-out native, --dry-run or --verify-only"},
+	} {
+		rootArgs := &shared.RootArgs{}
+		rootCmd := cmd.GetRootCmd(append([]string{"provision"}, tc.flags...), print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		testutil.ErrorContains(t, rootCmd.Execute(), tc.wantErr)
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// phases of a request in the order they happen
+const (
+	phaseDNS      = "DNS lookup"
+	phaseConnect  = "TCP connect"
+	phaseTLS      = "TLS handshake"
+	phaseResponse = "response"
+)
+
+// requestTrace records when each phase of a request started and ended
+type requestTrace struct {
+	mu      sync.Mutex
+	start   time.Time
+	started map[string]time.Time
+	done    map[string]time.Duration
+	failed  string // phase that ended with an error
+	wrote   bool
+	now     func() time.Time
+}
+
+func newRequestTrace() *requestTrace {
+	return &requestTrace{
+		started: map[string]time.Time{},
+		done:    map[string]time.Duration{},
+		now:     time.Now,
+	}
+}
+
+func (t *requestTrace) begin(phase string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.started[phase]; !ok {
+		t.started[phase] = t.now()
+	}
+}
+
+func (t *requestTrace) end(phase string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err != nil {
+		t.failed = phase
+		return
+	}
+	if start, ok := t.started[phase]; ok {
+		t.done[phase] = t.now().Sub(start)
+	}
+}
+
+func (t *requestTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { t.begin(phaseDNS) },
+		DNSDone:           func(info httptrace.DNSDoneInfo) { t.end(phaseDNS, info.Err) },
+		ConnectStart:      func(string, string) { t.begin(phaseConnect) },
+		ConnectDone:       func(_, _ string, err error) { t.end(phaseConnect, err) },
+		TLSHandshakeStart: func() { t.begin(phaseTLS) },
+		TLSHandshakeDone:  func(_ tls.ConnectionState, err error) { t.end(phaseTLS, err) },
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			t.wrote = info.Err == nil
+			t.mu.Unlock()
+			t.begin(phaseResponse)
+		},
+		GotFirstResponseByte: func() { t.end(phaseResponse, nil) },
+	}
+}
+
+// failedPhase returns the phase a request failed in: the one that ended with
+// an error, else the last one started but not ended
+func (t *requestTrace) failedPhase() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.failed != "" {
+		return t.failed
+	}
+	for _, phase := range []string{phaseDNS, phaseConnect, phaseTLS} {
+		if _, ok := t.started[phase]; ok {
+			if _, ok := t.done[phase]; !ok {
+				return phase
+			}
+		}
+	}
+	if t.wrote {
+		return phaseResponse
+	}
+	return ""
+}
+
+// timings lists the elapsed time of each phase started, eg. "DNS lookup 2ms, TCP connect 30ms"
+func (t *requestTrace) timings() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var timings []string
+	for _, phase := range []string{phaseDNS, phaseConnect, phaseTLS, phaseResponse} {
+		start, ok := t.started[phase]
+		if !ok {
+			continue
+		}
+		elapsed, ok := t.done[phase]
+		if !ok {
+			elapsed = t.now().Sub(start)
+		}
+		timings = append(timings, fmt.Sprintf("%s %s", phase, elapsed.Round(time.Millisecond)))
+	}
+	return strings.Join(timings, ", ")
+}
+
+// tracedDo sends the request with client, an error tells the phase it failed
+// or timed out in and the elapsed time of each phase
+func tracedDo(client *http.Client, req *http.Request) (*http.Response, error) {
+	t := newRequestTrace()
+	t.start = t.now()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), t.clientTrace()))
+	res, err := client.Do(req)
+	if err == nil {
+		return res, nil
+	}
+
+	outcome := "failed"
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		outcome = "timed out"
+	}
+	msg := fmt.Sprintf("request %s after %s", outcome, t.now().Sub(t.start).Round(time.Millisecond))
+	if phase := t.failedPhase(); phase != "" {
+		msg = fmt.Sprintf("%s %s after %s", phase, outcome, t.now().Sub(t.start).Round(time.Millisecond))
+	}
+	if timings := t.timings(); timings != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, timings)
+	}
+	return res, errors.Wrap(err, msg)
+}
@@ -0,0 +1,62 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestTracedDo(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	untrusted := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer untrusted.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	get := func(url string, timeout time.Duration) error {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := tracedDo(&http.Client{Timeout: timeout}, req)
+		if res != nil {
+			res.Body.Close()
+		}
+		return err
+	}
+
+	if err := get(untrusted.URL, time.Minute); err == nil || !strings.HasPrefix(err.Error(), "TLS handshake failed after") ||
+		!strings.Contains(err.Error(), "(TCP connect ") {
+		t.Errorf("want TLS handshake failed with timings, got %v", err)
+	}
+	testutil.ErrorContains(t, get(closed.URL, time.Minute), "TCP connect failed after")
+
+	err := get(slow.URL, 50*time.Millisecond)
+	testutil.ErrorContains(t, err, "response timed out after")
+	if err == nil || !strings.Contains(err.Error(), "TCP connect ") || !strings.Contains(err.Error(), ", response ") {
+		t.Errorf("want timings of connect and response, got %v", err)
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/proxies"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const versionPolicyFile = "apiproxy/policies/Send-Version.xml"
+
+var proxyVersionRE = regexp.MustCompile(`"version"\s*:\s*"([^"]+)"`)
+
+// proxyState is a deployed remote-service proxy, as summarized by upgrade
+type proxyState struct {
+	revision *apigee.Revision
+	version  string
+	digest   string // of the bundle it was imported from
+}
+
+func (s proxyState) String() string {
+	rev := "none"
+	if s.revision != nil {
+		rev = s.revision.String()
+	}
+	return fmt.Sprintf("revision %s, version %s, bundle sha256:%s", rev, orUnknown(s.version), orUnknown(s.digest))
+}
+
+// UpgradeCmd returns the upgrade command
+func UpgradeCmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	p := &provision{RootArgs: rootArgs, deployOptions: apigee.DefaultDeployOptions}
+
+	c := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrade the deployed remote-service proxy to the version of this CLI",
+		Long: `The upgrade command compares the deployed remote-service proxy (and internal proxy for OPDK)
+with the one embedded in this CLI and deploys a new revision if they differ, printing a
+summary before and after. The kvm, cache, product and credential are left as they are, so
+the existing configuration remains valid. Give the flags given to provision that customize
+the proxy (eg. --virtual-hosts, --kvm-name, --storage), other customizations are not kept.`,
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := rootArgs.Resolve(false, true); err != nil {
+				return err
+			}
+			if err := p.resources.validate(); err != nil {
+				return err
+			}
+			if p.IsGCPManaged && cmd.Flags().Changed("virtual-hosts") {
+				return fmt.Errorf("--virtual-hosts only valid for legacy or OPDK")
+			}
+			vhosts, err := parseVirtualHosts(p.virtualHosts)
+			if err != nil {
+				return err
+			}
+			p.vhosts = vhosts
+			if p.storage != "" && p.storage != storageKVM && p.storage != storagePropertySet {
+				return fmt.Errorf("--storage must be %s or %s", storageKVM, storagePropertySet)
+			}
+			if !p.IsGCPManaged && (p.storage != "" || p.jwtIssuer != "" || len(p.jwtAudiences) > 0) {
+				return fmt.Errorf("--storage, --jwt-issuer and --jwt-audience only valid for hybrid or Apigee X")
+			}
+			if p.dryRun {
+				return p.enableDryRun(printf)
+			}
+			return nil
+		},
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+			return p.upgrade(printf)
+		},
+	}
+
+	c.Flags().StringVarP(&rootArgs.ManagementBase, "management", "m",
+		shared.DefaultManagementBase, "Apigee management base URL")
+	c.Flags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.Flags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+
+	c.Flags().StringVarP(&rootArgs.Token, "token", "t", "",
+		"Apigee OAuth or SAML token (hybrid only)")
+	c.Flags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
+	c.Flags().StringVarP(&rootArgs.Username, "username", "u", "",
+		"Apigee username (legacy or OPDK only)")
+	c.Flags().StringVarP(&rootArgs.Password, "password", "p", "",
+		"Apigee password (legacy or OPDK only)")
+
+	c.Flags().BoolVarP(&p.forceProxyUpdate, "force", "f", false,
+		"deploy a new revision even if the deployed proxy is up to date")
+	c.Flags().StringVarP(&p.virtualHosts, "virtual-hosts", "", "default,secure",
+		"virtual hosts given to provision (legacy or OPDK only)")
+	c.Flags().StringVarP(&p.resources.kvm, "kvm-name", "", "",
+		"name of the kvm given to provision (default: "+defaultKVMName+")")
+	c.Flags().StringVarP(&p.resources.cache, "cache-name", "", "",
+		"name of the cache given to provision (default: "+defaultCacheName+")")
+	c.Flags().StringVarP(&p.storage, "storage", "", "",
+		"storage of the proxy keys given to provision (hybrid or Apigee X only)")
+	c.Flags().StringVarP(&p.jwtIssuer, "jwt-issuer", "", "",
+		"issuer of the tokens given to provision (hybrid or Apigee X only)")
+	c.Flags().StringSliceVarP(&p.jwtAudiences, "jwt-audience", "", nil,
+		"audience of the tokens given to provision, may be repeated (hybrid or Apigee X only)")
+	c.Flags().BoolVarP(&p.wait, "wait", "", false,
+		"wait for the new revision to be deployed")
+	c.Flags().DurationVarP(&p.timeout, "timeout", "", 5*time.Minute,
+		"how long --wait waits for each proxy deployment")
+	c.Flags().BoolVarP(&p.dryRun, "dry-run", "", false,
+		"print the management API calls that would modify your organization without making them")
+
+	return c
+}
+
+// upgrade deploys the embedded proxies if the deployed ones differ
+func (p *provision) upgrade(printf shared.FormatFn) error {
+	bundle := legacyAuthProxyZip
+	if p.IsGCPManaged {
+		bundle = remoteServiceProxyZip
+	}
+	embedded, err := embeddedProxyVersion(bundle)
+	if err != nil {
+		return err
+	}
+
+	before, err := p.proxyState()
+	if err != nil {
+		return err
+	}
+	if before.revision == nil {
+		return fmt.Errorf("proxy %s is not deployed to %s, use provision", authProxyName, p.Env)
+	}
+	printf("before: proxy %s %s", authProxyName, before)
+	printf("embedded: proxy %s version %s", authProxyName, embedded)
+
+	tempDir, err := ioutil.TempDir("", "apigee")
+	if err != nil {
+		return errors.Wrap(err, "creating temp dir")
+	}
+	defer os.RemoveAll(tempDir)
+
+	if err := p.resolveStorage(p.Stepf()); err != nil {
+		return err
+	}
+	if err := p.deployProxies(tempDir, printf, p.Stepf()); err != nil {
+		return err
+	}
+	if p.dryRun {
+		printf("dry run: %d call(s) not made", p.dryRunCalls)
+		return nil
+	}
+
+	var after proxyState
+	for _, d := range p.deployed {
+		if d.Name == authProxyName {
+			rev := apigee.Revision(d.Revision)
+			after.revision = &rev
+		}
+	}
+	if after.revision == nil || *after.revision == *before.revision {
+		printf("proxy %s is up to date", authProxyName)
+		return nil
+	}
+	// the deployed revision may not be serving yet, the bundle is as imported
+	after.version = embedded
+	imported, _, err := p.ApigeeClient.Proxies.GetRevision(authProxyName, *after.revision)
+	if err != nil {
+		return errors.Wrapf(err, "retrieving proxy %s revision %s", authProxyName, after.revision)
+	}
+	after.digest = bundleDigest(imported.Description)
+	printf("after: proxy %s %s", authProxyName, after)
+	return nil
+}
+
+// proxyState returns the deployed remote-service proxy
+func (p *provision) proxyState() (proxyState, error) {
+	var state proxyState
+	var err error
+	if p.IsGCPManaged {
+		state.revision, err = p.ApigeeClient.Proxies.GetGCPDeployedRevision(authProxyName)
+	} else {
+		state.revision, err = p.ApigeeClient.Proxies.GetDeployedRevision(authProxyName)
+	}
+	if err != nil || state.revision == nil {
+		return state, err
+	}
+	deployed, _, err := p.ApigeeClient.Proxies.GetRevision(authProxyName, *state.revision)
+	if err != nil {
+		return state, errors.Wrapf(err, "retrieving proxy %s revision %s", authProxyName, state.revision)
+	}
+	state.digest = bundleDigest(deployed.Description)
+	state.version = p.deployedProxyVersion()
+	return state, nil
+}
+
+// deployedProxyVersion returns the version reported by the remote-service
+// proxy, empty if it can't be retrieved
+func (p *provision) deployedProxyVersion() string {
+	resp, err := p.RuntimeClient().Get(fmt.Sprintf(versionURLFormat, p.RemoteServiceProxyURL))
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	var version struct {
+		Version string `json:"version"`
+	}
+	if resp.StatusCode != http.StatusOK || json.NewDecoder(resp.Body).Decode(&version) != nil {
+		return ""
+	}
+	return version.Version
+}
+
+// embeddedProxyVersion returns the version an embedded proxy bundle reports
+func embeddedProxyVersion(bundle string) (string, error) {
+	data, err := proxies.Asset(bundle)
+	if err != nil {
+		return "", err
+	}
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", errors.Wrapf(err, "reading proxy bundle %s", bundle)
+	}
+	for _, f := range r.File {
+		if f.Name != versionPolicyFile {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", err
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return "", err
+		}
+		if m := proxyVersionRE.FindSubmatch(content); m != nil {
+			return string(m[1]), nil
+		}
+	}
+	return "", fmt.Errorf("proxy bundle %s has no version", bundle)
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "unknown"
+	}
+	return s
+}
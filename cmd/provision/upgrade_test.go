@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/proxies"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/spf13/cobra"
+)
+
+func upgradeTestCmd(rootArgs *shared.RootArgs, printf shared.FormatFn, url string) *cobra.Command {
+	c := UpgradeCmd(rootArgs, printf)
+
+	defaultPersistentPreRun := c.PersistentPreRunE
+	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := defaultPersistentPreRun(cmd, args); err != nil {
+			return err
+		}
+		setTestUrls(rootArgs, url)
+		return nil
+	}
+
+	return c
+}
+
+func TestUpgrade(t *testing.T) {
+	embeddedDigest, ok := proxies.Digest(legacyAuthProxyZip)
+	if !ok {
+		t.Fatalf("want digest of %s", legacyAuthProxyZip)
+	}
+	oldDigest := strings.Repeat("0", 64)
+
+	var calls []string
+	deployed := true
+	description := "remote-service (bundle sha256:" + oldDigest + ")"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			calls = append(calls, r.Method+" "+r.URL.String())
+		}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service/deployments"):
+			if !deployed {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(apigee.EnvironmentDeployment{
+				Name:     authProxyName,
+				Revision: []apigee.RevisionDeployment{{Number: 1, State: "deployed"}},
+			})
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service/revisions/1"):
+			_ = json.NewEncoder(w).Encode(apigee.ProxyRevision{Name: authProxyName, Revision: 1, Description: description})
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service/revisions/2"):
+			_ = json.NewEncoder(w).Encode(apigee.ProxyRevision{Name: authProxyName, Revision: 2,
+				Description: "remote-service (bundle sha256:" + embeddedDigest + ")"})
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service"):
+			_ = json.NewEncoder(w).Encode(apigee.Proxy{Name: authProxyName, Revisions: []apigee.Revision{1}})
+		case r.URL.Path == "/remote-service/version":
+			_, _ = w.Write([]byte(`{"version":"0.9.0"}`))
+		case strings.HasSuffix(r.URL.Path, "/virtualhosts"):
+			_, _ = w.Write([]byte(`["default","secure"]`))
+		case strings.HasSuffix(r.URL.Path, "/caches"):
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("{}"))
+		default:
+			_ = json.NewEncoder(w).Encode(apigee.ProxyRevision{Name: authProxyName, Revision: 2})
+		}
+	}))
+	defer ts.Close()
+
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		calls = nil
+		print := testutil.Printer("TestUpgrade")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"upgrade", "-o", "org", "-e", "test", "-u", "me", "-p", "password", "-r", ts.URL, "--legacy"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, upgradeTestCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+
+	print, err := run()
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{
+		"before: proxy remote-service revision 1, version 0.9.0, bundle sha256:" + oldDigest,
+		"embedded: proxy remote-service version 1.0.0-pre.2",
+		"after: proxy remote-service revision 2, version 1.0.0-pre.2, bundle sha256:" + embeddedDigest,
+	})
+	for _, c := range calls {
+		if strings.Contains(c, "keyvaluemaps") || strings.Contains(c, "apiproducts") || strings.Contains(c, "/credential/") {
+			t.Errorf("want kvm, product and credential untouched, got %s", c)
+		}
+	}
+
+	// the deployed revision is from the embedded bundle
+	description = "remote-service (bundle sha256:" + embeddedDigest + ")"
+	print, err = run()
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{
+		"before: proxy remote-service revision 1, version 0.9.0, bundle sha256:" + embeddedDigest,
+		"embedded: proxy remote-service version 1.0.0-pre.2",
+		"proxy remote-service is up to date",
+	})
+
+	deployed = false
+	_, err = run()
+	testutil.ErrorContains(t, err, "proxy remote-service is not deployed to test, use provision")
+
+	_, err = run("--storage", "kvm")
+	testutil.ErrorContains(t, err, "--storage, --jwt-issuer and --jwt-audience only valid for hybrid or Apigee X")
+}
@@ -0,0 +1,238 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// VerifyCmd returns the verify command
+func VerifyCmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	p := &provision{RootArgs: rootArgs}
+
+	c := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify what provision created in an environment",
+		Long:  "Verify what provision created in an environment.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return rootArgs.Resolve(false, false)
+		},
+	}
+
+	c.PersistentFlags().StringVarP(&rootArgs.ManagementBase, "management", "m",
+		shared.DefaultManagementBase, "Apigee management base URL")
+	c.PersistentFlags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.PersistentFlags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+	c.PersistentFlags().StringVarP(&rootArgs.Token, "token", "t", "",
+		"Apigee OAuth or SAML token (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.Username, "username", "u", "",
+		"Apigee username (legacy or OPDK only)")
+	c.PersistentFlags().StringVarP(&rootArgs.Password, "password", "p", "",
+		"Apigee password (legacy or OPDK only)")
+
+	c.AddCommand(cmdVerifyDrift(p, printf))
+
+	return c
+}
+
+func cmdVerifyDrift(p *provision, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "drift [state file]",
+		Short: "Report changes made to an environment since a state export",
+		Long: `Compare the environment with a state file written by state export right after provisioning
+and report the changes made since: proxies deployed from another bundle, kvm entries added,
+changed or removed (values aren't printed) and product attributes and settings changed.
+Exits with an error if anything changed, eg. for a compliance audit.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return p.verifyDrift(args[0], printf)
+		},
+	}
+
+	return c
+}
+
+// verifyDrift prints the changes in the environment since the state of file
+// and fails if there are any
+func (p *provision) verifyDrift(file string, printf shared.FormatFn) error {
+	verbosef := p.Stepf()
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	state := &provisionedState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return errors.Wrapf(err, "reading state %s", file)
+	}
+	if state.Platform != p.platform() || state.Organization != p.Org || state.Environment != p.Env {
+		return fmt.Errorf("state of %s is for %s %s/%s, not %s %s/%s", file,
+			state.Platform, state.Organization, state.Environment, p.platform(), p.Org, p.Env)
+	}
+
+	var changes []string
+	for _, want := range state.Proxies {
+		got, err := p.exportProxy(want.Name, verbosef)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, proxyDrift(want, got)...)
+	}
+
+	if state.KVM != nil {
+		verbosef("checking kvm %s...", state.KVM.Name)
+		p.resources.kvm = state.KVM.Name
+		got, err := p.exportKVM(verbosef)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, kvmDrift(state.KVM, got)...)
+	}
+
+	if state.Product != nil {
+		verbosef("checking product %s...", state.Product.Name)
+		p.product.name = state.Product.Name
+		got, err := p.exportProduct()
+		if err != nil {
+			return err
+		}
+		changes = append(changes, productDrift(state.Product, got)...)
+	}
+
+	for _, c := range changes {
+		printf("drift: %s", c)
+	}
+	since := state.Created.Format(time.RFC3339)
+	if len(changes) > 0 {
+		return fmt.Errorf("%d change(s) in %s/%s since %s", len(changes), p.Org, p.Env, since)
+	}
+	printf("no drift in %s/%s since %s", p.Org, p.Env, since)
+	return nil
+}
+
+// proxyDrift describes how the deployed proxy differs from the exported one
+func proxyDrift(want exportedProxy, got *exportedProxy) []string {
+	if got == nil {
+		return []string{fmt.Sprintf("proxy %s is no longer deployed (was revision %s)", want.Name, want.Revision)}
+	}
+	if got.Digest != want.Digest {
+		return []string{fmt.Sprintf("proxy %s revision %s is from another bundle than revision %s (sha256:%s, was sha256:%s)",
+			want.Name, got.Revision, want.Revision, got.Digest, want.Digest)}
+	}
+	if got.Revision != want.Revision {
+		return []string{fmt.Sprintf("proxy %s is deployed as revision %s (was revision %s) from the same bundle",
+			want.Name, got.Revision, want.Revision)}
+	}
+	return nil
+}
+
+// kvmDrift describes how the entries of the kvm differ from the exported ones,
+// without their values
+func kvmDrift(want, got *apigee.KVM) []string {
+	if got == nil {
+		return []string{fmt.Sprintf("kvm %s no longer exists", want.Name)}
+	}
+	was := map[string]string{}
+	for _, e := range want.Entries {
+		was[e.Name] = e.Value
+	}
+	var changes []string
+	for _, e := range got.Entries {
+		value, ok := was[e.Name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("kvm %s entry %s added", want.Name, e.Name))
+		case value != e.Value:
+			changes = append(changes, fmt.Sprintf("kvm %s entry %s changed", want.Name, e.Name))
+		}
+		delete(was, e.Name)
+	}
+	for name := range was {
+		changes = append(changes, fmt.Sprintf("kvm %s entry %s removed", want.Name, name))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// productDrift describes how the attributes and settings of the product
+// differ from the exported ones
+func productDrift(want, got *apiProduct) []string {
+	if got == nil {
+		return []string{fmt.Sprintf("product %s no longer exists", want.Name)}
+	}
+	var changes []string
+	settings := []struct {
+		name      string
+		want, got string
+	}{
+		{"display name", want.DisplayName, got.DisplayName},
+		{"approval type", want.ApprovalType, got.ApprovalType},
+		{"description", want.Description, got.Description},
+		{"resources", sortedList(want.APIResources), sortedList(got.APIResources)},
+		{"environments", sortedList(want.Environments), sortedList(got.Environments)},
+		{"proxies", sortedList(want.Proxies), sortedList(got.Proxies)},
+		{"scopes", sortedList(want.Scopes), sortedList(got.Scopes)},
+		{"quota", want.Quota, got.Quota},
+		{"quota interval", want.QuotaInterval, got.QuotaInterval},
+		{"quota time unit", want.QuotaTimeUnit, got.QuotaTimeUnit},
+	}
+	for _, s := range settings {
+		if s.want != s.got {
+			changes = append(changes, fmt.Sprintf("product %s %s changed from %q to %q", want.Name, s.name, s.want, s.got))
+		}
+	}
+
+	was := map[string]string{}
+	for _, a := range want.Attributes {
+		was[a.Name] = a.Value
+	}
+	var attrs []string
+	for _, a := range got.Attributes {
+		value, ok := was[a.Name]
+		switch {
+		case !ok:
+			attrs = append(attrs, fmt.Sprintf("product %s attribute %s added as %q", want.Name, a.Name, a.Value))
+		case value != a.Value:
+			attrs = append(attrs, fmt.Sprintf("product %s attribute %s changed from %q to %q", want.Name, a.Name, value, a.Value))
+		}
+		delete(was, a.Name)
+	}
+	for name, value := range was {
+		attrs = append(attrs, fmt.Sprintf("product %s attribute %s removed (was %q)", want.Name, name, value))
+	}
+	sort.Strings(attrs)
+	return append(changes, attrs...)
+}
+
+// sortedList joins a sorted copy of the list, as the order isn't significant
+func sortedList(l []string) string {
+	sorted := append([]string(nil), l...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
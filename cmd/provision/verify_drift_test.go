@@ -0,0 +1,137 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/spf13/cobra"
+)
+
+func verifyTestCmd(rootArgs *shared.RootArgs, printf shared.FormatFn, url string) *cobra.Command {
+	c := VerifyCmd(rootArgs, printf)
+
+	defaultPersistentPreRun := c.PersistentPreRunE
+	c.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := defaultPersistentPreRun(cmd, args); err != nil {
+			return err
+		}
+		setTestUrls(rootArgs, url)
+		return nil
+	}
+
+	return c
+}
+
+func TestVerifyDrift(t *testing.T) {
+	digest := strings.Repeat("a", 64)
+	state := provisionedState{
+		Created:      time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC),
+		Platform:     "legacy",
+		Organization: "org",
+		Environment:  "test",
+		Proxies:      []exportedProxy{{Name: authProxyName, Revision: 2, Digest: digest}},
+		KVM: &apigee.KVM{Name: "remote-service", Entries: []apigee.Entry{
+			{Name: "kid", Value: "1"},
+			{Name: "certs", Value: "cert"},
+		}},
+		Product: &apiProduct{
+			Name:       "remote-service",
+			Attributes: []attribute{{Name: "access", Value: "public"}},
+			Proxies:    []string{authProxyName},
+		},
+	}
+	live := state
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/environments/test/apis/remote-service/deployments"):
+			_, _ = w.Write([]byte(`{"name":"remote-service","revision":[{"name":"` + live.Proxies[0].Revision.String() + `","state":"deployed"}]}`))
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service/revisions/"+live.Proxies[0].Revision.String()):
+			_ = json.NewEncoder(w).Encode(apigee.ProxyRevision{Name: authProxyName, Description: "(bundle sha256:" + live.Proxies[0].Digest + ")"})
+		case strings.HasSuffix(r.URL.Path, "/keyvaluemaps/remote-service"):
+			_ = json.NewEncoder(w).Encode(live.KVM)
+		case strings.HasSuffix(r.URL.Path, "/apiproducts/remote-service"):
+			_ = json.NewEncoder(w).Encode(live.Product)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "drift")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.json")
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(org string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestVerifyDrift")
+		rootArgs := &shared.RootArgs{}
+		flags := []string{"verify", "drift", file, "-o", org, "-e", "test", "-u", "me", "-p", "password", "--legacy"}
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, verifyTestCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+
+	print, err := run("org")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{"no drift in org/test since 2020-06-01T00:00:00Z"})
+
+	live.Proxies = []exportedProxy{{Name: authProxyName, Revision: 3, Digest: strings.Repeat("b", 64)}}
+	live.KVM = &apigee.KVM{Name: "remote-service", Entries: []apigee.Entry{
+		{Name: "kid", Value: "2"},
+		{Name: "extra", Value: "x"},
+	}}
+	live.Product = &apiProduct{
+		Name:       "remote-service",
+		Attributes: []attribute{{Name: "access", Value: "private"}},
+		Proxies:    []string{"extra", authProxyName},
+	}
+	print, err = run("org")
+	testutil.ErrorContains(t, err, "6 change(s) in org/test since 2020-06-01T00:00:00Z")
+	print.Check(t, []string{
+		"drift: proxy remote-service revision 3 is from another bundle than revision 2 (sha256:" + strings.Repeat("b", 64) + ", was sha256:" + digest + ")",
+		"drift: kvm remote-service entry certs removed",
+		"drift: kvm remote-service entry extra added",
+		"drift: kvm remote-service entry kid changed",
+		`drift: product remote-service proxies changed from "remote-service" to "extra,remote-service"`,
+		`drift: product remote-service attribute access changed from "public" to "private"`,
+	})
+
+	_, err = run("other")
+	testutil.ErrorContains(t, err, "is for legacy org/test, not legacy other/test")
+}
@@ -0,0 +1,320 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/pkg/errors"
+)
+
+// status of a --verify-only check
+const (
+	checkPass = "pass"
+	checkFail = "fail"
+	checkSkip = "skip"
+)
+
+// verifyCheck is the outcome of one check of --verify-only
+type verifyCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// verifyReport holds the checks of an environment
+type verifyReport struct {
+	Organization string        `json:"organization"`
+	Environment  string        `json:"environment"`
+	Checks       []verifyCheck `json:"checks"`
+}
+
+// check records a pass with detail, or a failure if err is not nil
+func (r *verifyReport) check(name, detail string, err error) {
+	if err != nil {
+		r.Checks = append(r.Checks, verifyCheck{Name: name, Status: checkFail, Detail: err.Error()})
+		return
+	}
+	r.Checks = append(r.Checks, verifyCheck{Name: name, Status: checkPass, Detail: detail})
+}
+
+func (r *verifyReport) skip(name, reason string) {
+	r.Checks = append(r.Checks, verifyCheck{Name: name, Status: checkSkip, Detail: reason})
+}
+
+func (r *verifyReport) failed() int {
+	failed := 0
+	for _, c := range r.Checks {
+		if c.Status == checkFail {
+			failed++
+		}
+	}
+	return failed
+}
+
+// runVerifyOnly checks what provision created in each environment without
+// changing anything and reports every check, failing if any did
+func (p *provision) runVerifyOnly(printf shared.FormatFn) error {
+	var reports []verifyReport
+	for _, env := range p.envs {
+		if err := p.SwitchEnv(env); err != nil {
+			return err
+		}
+		p.Stepf()("verifying environment %s...", env)
+		reports = append(reports, p.verifyEnv())
+	}
+
+	checks, failed := 0, 0
+	for _, r := range reports {
+		checks += len(r.Checks)
+		failed += r.failed()
+	}
+
+	if p.output == outputJSON {
+		reportsJSON, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return err
+		}
+		printf("%s", reportsJSON)
+	} else {
+		for _, r := range reports {
+			printf("organization %s, environment %s:", r.Organization, r.Environment)
+			for _, c := range r.Checks {
+				printf("  %-4s %s: %s", strings.ToUpper(c.Status), c.Name, c.Detail)
+			}
+		}
+	}
+
+	if failed > 0 {
+		return shared.WithCode(shared.CodeVerifyFailed, fmt.Errorf("%d of %d check(s) failed", failed, checks))
+	}
+	return nil
+}
+
+// verifyEnv runs the checks of the current environment
+func (p *provision) verifyEnv() verifyReport {
+	report := verifyReport{Organization: p.Org, Environment: p.Env}
+
+	proxies := []string{authProxyName}
+	if p.IsOPDK {
+		proxies = append(proxies, internalProxyName)
+	}
+	for _, name := range proxies {
+		rev, err := p.checkDeployedRevision(name)
+		report.check("proxy "+name, fmt.Sprintf("revision %d deployed to %s", rev, p.Env), err)
+	}
+
+	kvmCheck := "kvm " + p.resources.kvmName()
+	if err := p.resolveStorage(p.Stepf()); err != nil {
+		report.check(kvmCheck, "", err)
+	} else if !p.IsGCPManaged || p.storage == storageKVM {
+		report.check(kvmCheck, "has private_key, jwks and kid entries", p.checkKVMEntries())
+	} else if p.storage == storagePropertySet {
+		report.skip(kvmCheck, "keys are stored in property set "+propertySetName)
+	} else {
+		report.skip(kvmCheck, "keys are stored in the policy secret")
+	}
+
+	report.check("product "+p.product.productName(), fmt.Sprintf("available in %s", p.Env), p.checkAPIProduct())
+
+	if p.IsGCPManaged {
+		n, err := p.checkAppCredentials()
+		report.check("app "+p.credential.appName(), fmt.Sprintf("%d valid credential(s)", n), err)
+	} else {
+		report.skip("app "+p.credential.appName(), "legacy and OPDK credentials are kept by the internal proxy")
+	}
+
+	var client *http.Client
+	if p.ServerConfig != nil {
+		var err error
+		if client, err = p.createAuthorizedClient(p.ServerConfig); err != nil {
+			report.check("endpoints", "", err)
+			return report
+		}
+	} else {
+		client = &http.Client{Transport: p.RuntimeTransport(false)}
+	}
+	endpoints := []struct{ method, format string }{
+		{http.MethodGet, certsURLFormat},
+		{http.MethodGet, productsURLFormat},
+		{http.MethodPost, verifyAPIKeyURLFormat},
+		{http.MethodPost, quotasURLFormat},
+	}
+	for _, e := range endpoints {
+		endpointURL := fmt.Sprintf(e.format, p.RemoteServiceProxyURL)
+		status, err := checkReachable(client, e.method, endpointURL)
+		report.check("endpoint "+endpointURL, status, err)
+	}
+
+	n, err := p.checkCerts(client)
+	report.check("certs", fmt.Sprintf("%d RSA key(s) served", n), err)
+
+	return report
+}
+
+// checkDeployedRevision returns the revision of a proxy deployed to the environment
+func (p *provision) checkDeployedRevision(name string) (apigee.Revision, error) {
+	var rev *apigee.Revision
+	var err error
+	if p.IsGCPManaged {
+		rev, err = p.ApigeeClient.Proxies.GetGCPDeployedRevision(name)
+	} else {
+		rev, err = p.ApigeeClient.Proxies.GetDeployedRevision(name)
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "checking deployment of proxy %s", name)
+	}
+	if rev == nil {
+		return 0, fmt.Errorf("not deployed to %s", p.Env)
+	}
+	return *rev, nil
+}
+
+// checkKVMEntries verifies the remote-service kvm holds the proxy's keys
+func (p *provision) checkKVMEntries() error {
+	kvm, resp, err := p.ApigeeClient.KVMService.Get(p.resources.kvmName())
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("not found")
+		}
+		return errors.Wrapf(err, "retrieving kvm %s", p.resources.kvmName())
+	}
+	var missing []string
+	for _, name := range []string{"private_key", "jwks", "kid"} {
+		if _, ok := kvm.GetValue(name); !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("has no %s entry", strings.Join(missing, " or "))
+	}
+	return nil
+}
+
+// checkAPIProduct verifies the remote-service product is available in the environment
+func (p *provision) checkAPIProduct() error {
+	name := p.product.productName()
+	req, err := p.ApigeeClient.NewRequestNoEnv(http.MethodGet, apiProductsPath+"/"+name, nil)
+	if err != nil {
+		return err
+	}
+	var product apiProduct
+	if resp, err := p.ApigeeClient.Do(req, &product); err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("not found")
+		}
+		return errors.Wrapf(err, "retrieving product %s", name)
+	}
+	for _, env := range product.Environments {
+		if env == p.Env {
+			return nil
+		}
+	}
+	return fmt.Errorf("not available in %s", p.Env)
+}
+
+// checkAppCredentials returns the number of valid credentials of the
+// remote-service app, owned by the developer or the AppGroup of provision
+func (p *provision) checkAppCredentials() (int, error) {
+	var creds []apigee.AppCredential
+	var resp *apigee.Response
+	var err error
+	if p.useAppGroup {
+		var app *apigee.AppGroupApp
+		if app, resp, err = p.ApigeeClient.AppGroups.GetApp(shared.DefaultAppGroupName, p.credential.appName()); err == nil {
+			creds = app.Credentials
+		}
+	} else {
+		var app *apigee.DeveloperApp
+		if app, resp, err = p.ApigeeClient.DeveloperApps.Get(p.credential.developerEmail(), p.credential.appName()); err == nil {
+			creds = app.Credentials
+		}
+	}
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return 0, fmt.Errorf("not found")
+		}
+		return 0, errors.Wrapf(err, "retrieving app %s", p.credential.appName())
+	}
+
+	valid := 0
+	for _, c := range creds {
+		if c.ConsumerKey != "" && c.Status != revokedStatus {
+			valid++
+		}
+	}
+	if valid == 0 {
+		return 0, fmt.Errorf("no valid credential")
+	}
+	return valid, nil
+}
+
+// checkReachable reports the status of an endpoint of the remote-service
+// proxy, any answer of the proxy but a server error counts. Without the
+// keys of --config the proxy may only answer 401.
+func checkReachable(client *http.Client, method, endpointURL string) (string, error) {
+	body := ""
+	if method == http.MethodPost {
+		body = "{}"
+	}
+	req, err := http.NewRequest(method, endpointURL, strings.NewReader(body))
+	if err != nil {
+		return "", errors.Wrapf(err, "creating request")
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotFound || res.StatusCode >= 500 {
+		return "", fmt.Errorf("%s %s: %s", method, endpointURL, res.Status)
+	}
+	return res.Status, nil
+}
+
+// checkCerts verifies the JWKS served by the remote-service proxy holds RSA
+// keys, including the key of --config if given, and returns their number
+func (p *provision) checkCerts(client *http.Client) (int, error) {
+	certsURL := fmt.Sprintf(certsURLFormat, p.RemoteServiceProxyURL)
+	served, err := jwk.FetchHTTP(certsURL, jwk.WithHTTPClient(client))
+	if err != nil {
+		return 0, errors.Wrapf(err, "retrieving JWKs from: %s", certsURL)
+	}
+	if len(served.Keys) == 0 {
+		return 0, fmt.Errorf("no keys served by %s", certsURL)
+	}
+	for _, key := range served.Keys {
+		if key.KeyType() != jwa.RSA {
+			return 0, fmt.Errorf("key %s served by %s is not an RSA key", key.KeyID(), certsURL)
+		}
+	}
+	if p.ServerConfig != nil && p.ServerConfig.Tenant.PrivateKey != nil {
+		kid := p.ServerConfig.Tenant.PrivateKeyID
+		if len(served.LookupKeyID(kid)) == 0 {
+			return 0, fmt.Errorf("key ID %s of %s not served by %s", kid, p.ConfigPath, certsURL)
+		}
+	}
+	return len(served.Keys), nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func TestVerifyOnly(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := publicJWK(privateKey, "mykid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := json.Marshal(&jwk.Set{Keys: []jwk.Key{key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// organization healthy serves everything provision creates, broken nothing
+	var calls []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && !strings.HasPrefix(r.URL.Path, "/remote-service/") {
+			calls = append(calls, r.Method+" "+r.URL.Path)
+		}
+		if strings.HasPrefix(r.URL.Path, "/v1/organizations/broken") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch {
+		case r.URL.Path == "/v1/organizations/healthy":
+			_, _ = w.Write([]byte(`{"runtimeType":"HYBRID"}`))
+		case strings.HasSuffix(r.URL.Path, "/apis/remote-service/deployments"):
+			_ = json.NewEncoder(w).Encode(apigee.GCPDeployments{Deployments: []apigee.GCPDeployment{
+				{Environment: "test", Name: "remote-service", Revision: "3"},
+			}})
+		case strings.HasSuffix(r.URL.Path, "/apiproducts/remote-service"):
+			_ = json.NewEncoder(w).Encode(apiProduct{Name: apiProductName, Environments: []string{"test"}})
+		case strings.HasSuffix(r.URL.Path, "/apps/remote-service"):
+			_ = json.NewEncoder(w).Encode(apigee.DeveloperApp{Name: "remote-service", Credentials: []apigee.AppCredential{
+				{ConsumerKey: "key", Status: "approved"},
+				{ConsumerKey: "old", Status: revokedStatus},
+			}})
+		case r.URL.Path == "/remote-service/certs":
+			_, _ = w.Write(jwks)
+		case strings.HasPrefix(r.URL.Path, "/remote-service/"):
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	run := func(org string, args ...string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestVerifyOnly")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"provision", "--verify-only", "-o", org, "-e", "test", "-r", ts.URL, "-t", "token"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+
+	print, err := run("healthy")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{
+		"organization healthy, environment test:",
+		"  PASS proxy remote-service: revision 3 deployed to test",
+		"  SKIP kvm remote-service: keys are stored in the policy secret",
+		"  PASS product remote-service: available in test",
+		"  PASS app remote-service: 1 valid credential(s)",
+		"  PASS endpoint " + ts.URL + "/remote-service/certs: 200 OK",
+		"  PASS endpoint " + ts.URL + "/remote-service/products: 401 Unauthorized",
+		"  PASS endpoint " + ts.URL + "/remote-service/verifyApiKey: 401 Unauthorized",
+		"  PASS endpoint " + ts.URL + "/remote-service/quotas: 401 Unauthorized",
+		"  PASS certs: 1 RSA key(s) served",
+	})
+	if len(calls) != 0 {
+		t.Errorf("want no changes, got %v", calls)
+	}
+
+	print, err = run("broken", "--output", "json")
+	testutil.ErrorContains(t, err, "4 of 9 check(s) failed")
+	if shared.ErrorCodeOf(err) != shared.CodeVerifyFailed {
+		t.Errorf("want %s, got %s", shared.CodeVerifyFailed, shared.ErrorCodeOf(err))
+	}
+	var reports []verifyReport
+	if err := json.Unmarshal([]byte(print.Prints[0]), &reports); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 1 || reports[0].Organization != "broken" {
+		t.Fatalf("want report of broken, got %v", reports)
+	}
+	for _, c := range reports[0].Checks[:4] {
+		if c.Status != checkFail {
+			t.Errorf("want %s to fail, got %s", c.Name, c.Status)
+		}
+	}
+
+	_, err = run("healthy", "--dry-run")
+	testutil.ErrorContains(t, err, "--verify-only can't be combined with --dry-run, --rotate or --rotate-key")
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+)
+
+const virtualHostsPath = "virtualhosts"
+
+// parseVirtualHosts returns the distinct names of --virtual-hosts
+func parseVirtualHosts(s string) ([]string, error) {
+	var vhosts []string
+	seen := map[string]bool{}
+	for _, vh := range strings.Split(s, ",") {
+		vh = strings.TrimSpace(vh)
+		if vh == "" || seen[vh] {
+			continue
+		}
+		if !productNameRE.MatchString(vh) {
+			return nil, fmt.Errorf("--virtual-hosts must only contain letters, numbers, '.', '_' and '-', got %q", vh)
+		}
+		seen[vh] = true
+		vhosts = append(vhosts, vh)
+	}
+	if len(vhosts) == 0 {
+		return nil, fmt.Errorf("--virtual-hosts must name at least one virtual host")
+	}
+	return vhosts, nil
+}
+
+// checkVirtualHosts fails if the environment lacks a virtual host the proxies
+// would be bound to, as the proxies wouldn't be reachable. If the virtual
+// hosts can't be listed, it warns and doesn't check.
+func (p *provision) checkVirtualHosts(printf shared.FormatFn) error {
+	req, err := p.ApigeeClient.NewRequest(http.MethodGet, virtualHostsPath, nil)
+	if err != nil {
+		return err
+	}
+	var existing []string
+	if _, err := p.ApigeeClient.Do(req, &existing); err != nil {
+		printf("warning: unable to list the virtual hosts of environment %s, not checked: %v", p.Env, err)
+		return nil
+	}
+	has := map[string]bool{}
+	for _, vh := range existing {
+		has[vh] = true
+	}
+	var missing []string
+	for _, vh := range p.vhosts {
+		if !has[vh] {
+			missing = append(missing, vh)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("virtual host(s) %s not in environment %s (has %s), set --virtual-hosts",
+			strings.Join(missing, ","), p.Env, strings.Join(existing, ","))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package provision
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	vhosts, err := parseVirtualHosts(" secure, custom-vh,secure,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"secure", "custom-vh"}; !reflect.DeepEqual(want, vhosts) {
+		t.Errorf("want %v, got %v", want, vhosts)
+	}
+	_, err = parseVirtualHosts(" , ")
+	testutil.ErrorContains(t, err, "--virtual-hosts must name at least one virtual host")
+	_, err = parseVirtualHosts("a<b")
+	testutil.ErrorContains(t, err, "--virtual-hosts must only contain")
+
+	list := `["secure","custom-vh"]`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/organizations/legacy/environments/test/virtualhosts" {
+			t.Errorf("unexpected %s", r.URL.Path)
+		}
+		if list == "" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(list))
+	}))
+	defer ts.Close()
+
+	rootArgs := &shared.RootArgs{
+		Env:        "test",
+		ClientOpts: &apigee.EdgeClientOptions{Org: "legacy", Env: "test", Auth: &apigee.EdgeAuth{SkipAuth: true}},
+	}
+	setTestUrls(rootArgs, ts.URL)
+	p := &provision{RootArgs: rootArgs, vhosts: vhosts}
+	print := testutil.Printer("TestVirtualHosts")
+	if err := p.checkVirtualHosts(print.Printf); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+
+	p.vhosts = []string{"default", "secure"}
+	testutil.ErrorContains(t, p.checkVirtualHosts(print.Printf),
+		"virtual host(s) default not in environment test (has secure,custom-vh), set --virtual-hosts")
+
+	list = ""
+	if err := p.checkVirtualHosts(print.Printf); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+	print.CheckPrefix(t, []string{"warning: unable to list the virtual hosts of environment test, not checked"})
+
+	rootArgs = &shared.RootArgs{}
+	flags := []string{"provision", "-o", "hybrid", "-e", "test", "-r", ts.URL, "-t", "token", "--virtual-hosts", "secure"}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+	testutil.ErrorContains(t, rootCmd.Execute(), "--virtual-hosts only valid for legacy or OPDK")
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxies
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	embedded "github.com/apigee/apigee-remote-service-cli/proxies"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type proxies struct {
+	*shared.RootArgs
+	outDir    string
+	extract   bool
+	overwrite bool
+}
+
+// Cmd returns base command
+func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	p := &proxies{RootArgs: rootArgs}
+
+	c := &cobra.Command{
+		Use:   "proxies",
+		Short: "Work with the proxy bundles embedded in this CLI",
+		Long:  "Work with the proxy bundles embedded in this CLI.",
+	}
+
+	c.AddCommand(cmdExport(p, printf))
+
+	return c
+}
+
+func cmdExport(p *proxies, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "export",
+		Short: "Write the embedded proxy bundles to a directory",
+		Long: `Write the embedded remote-service and internal proxy bundles to a directory for review
+before provisioning. Each bundle is verified against its pinned SHA-256 digest first.`,
+		Args:        cobra.NoArgs,
+		Annotations: map[string]string{shared.SkipWorkspaceAnnotation: "true"},
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			cmd.SilenceUsage = true
+			return p.export(printf)
+		},
+	}
+
+	c.Flags().StringVarP(&p.outDir, "out", "", "./proxies",
+		"directory to write the proxy bundles to")
+	c.Flags().BoolVarP(&p.extract, "extract", "x", false,
+		"write the contents of each bundle to a directory rather than the zip file")
+	c.Flags().BoolVarP(&p.overwrite, "force", "f", false,
+		"overwrite existing files")
+
+	return c
+}
+
+func (p *proxies) export(printf shared.FormatFn) error {
+	if err := os.MkdirAll(p.outDir, 0755); err != nil {
+		return err
+	}
+
+	names := embedded.AssetNames()
+	sort.Strings(names)
+	for _, name := range names {
+		if err := embedded.Verify(name); err != nil {
+			return err
+		}
+		digest, _ := embedded.Digest(name)
+		data, err := embedded.Asset(name)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(p.outDir, name)
+		if p.extract {
+			target = strings.TrimSuffix(target, filepath.Ext(target))
+		}
+		if _, err := os.Stat(target); err == nil && !p.overwrite {
+			return fmt.Errorf("%s already exists, use --force to overwrite", target)
+		}
+
+		if p.extract {
+			if err := os.RemoveAll(target); err != nil {
+				return err
+			}
+			err = extractZip(data, target)
+		} else {
+			err = ioutil.WriteFile(target, data, 0644)
+		}
+		if err != nil {
+			return errors.Wrapf(err, "writing %s", target)
+		}
+		printf("wrote %s (sha256:%s)", target, digest)
+	}
+	return nil
+}
+
+// extractZip writes the files of a zip archive below dest
+func extractZip(data []byte, dest string) error {
+	r, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	extract := func(f *zip.File) error {
+		path := filepath.Join(dest, f.Name)
+		if !strings.HasPrefix(path, filepath.Clean(dest)+string(os.PathSeparator)) {
+			return fmt.Errorf("illegal file path in bundle: %s", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			return os.MkdirAll(path, 0755)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		_, err = io.Copy(out, rc)
+		return err
+	}
+
+	for _, f := range r.File {
+		if err := extract(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxies
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	embedded "github.com/apigee/apigee-remote-service-cli/proxies"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "proxies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	print := testutil.Printer("TestExport")
+	if err := runExport(print, "--out", dir); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{}
+	for _, name := range []string{"internal.zip", "remote-service-gcp.zip", "remote-service-legacy.zip"} {
+		digest, _ := embedded.Digest(name)
+		file := filepath.Join(dir, name)
+		want = append(want, "wrote "+file+" (sha256:"+digest+")")
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != digest {
+			t.Errorf("%s: want digest %s", name, digest)
+		}
+	}
+	print.Check(t, want)
+
+	err = runExport(testutil.Printer("TestExport"), "--out", dir)
+	testutil.ErrorContains(t, err, "already exists, use --force to overwrite")
+	if err := runExport(testutil.Printer("TestExport"), "--out", dir, "-f"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runExport(testutil.Printer("TestExport"), "--out", dir, "--extract"); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{
+		"internal/apiproxy/EdgeMicro.xml",
+		"remote-service-gcp/apiproxy/remote-service.xml",
+		"remote-service-legacy/apiproxy/remote-service.xml",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			t.Errorf("want %s extracted: %v", file, err)
+		}
+	}
+}
+
+func runExport(print *testutil.TestPrint, args ...string) error {
+	flags := append([]string{"proxies", "export"}, args...)
+	rootArgs := &shared.RootArgs{}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	return rootCmd.Execute()
+}
@@ -20,8 +20,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 
+	"github.com/apigee/apigee-remote-service-cli/proxies"
 	"github.com/apigee/apigee-remote-service-cli/shared"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
@@ -43,6 +45,8 @@ func GetRootCmd(args []string, printf shared.FormatFn) *cobra.Command {
 	}
 	c.SetArgs(args)
 	c.PersistentFlags().AddGoFlagSet(flag.CommandLine)
+	c.PersistentFlags().Var(&profileMetrics{args: args}, profileMetricsFlag,
+		"directory to write CPU, heap and allocation profiles and a summary of peak RSS and allocations of the run to")
 
 	rootArgs := &shared.RootArgs{}
 	c.AddCommand(version(rootArgs, printf))
@@ -62,6 +66,12 @@ func version(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
 		RunE: func(cmd *cobra.Command, args []string) error {
 			printf("apigee-remote-service-cli version %s %s [%s]",
 				shared.BuildInfo.Version, shared.BuildInfo.Date, shared.BuildInfo.Commit)
+			names := proxies.AssetNames()
+			sort.Strings(names)
+			for _, name := range names {
+				digest, _ := proxies.Digest(name)
+				printf("embedded proxy %s sha256:%s", name, digest)
+			}
 
 			if rootArgs.RuntimeBase == "" {
 				printf("proxy version unknown (specify --hybrid-config OR --runtime to check)")
@@ -16,9 +16,11 @@ package cmd
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -64,6 +66,9 @@ func TestVersion(t *testing.T) {
 
 	want := []string{
 		"apigee-remote-service-cli version /version/ /date/ [/commit/]",
+		"embedded proxy internal.zip sha256:da6f969fc72e086be06c07585afe715a1c5b04e08c3f0f9c2df7a50236fc0bc1",
+		"embedded proxy remote-service-gcp.zip sha256:99f0bf79ebb68180c8e22ff6455cfca189ca35eeb75f7e8739dfbf1e700d3175",
+		"embedded proxy remote-service-legacy.zip sha256:ada1adb38dd70815cb39c8b69704c70ea64cd875397d22150afd67b796a969de",
 		"proxy version unknown (specify --hybrid-config OR --runtime to check)",
 	}
 
@@ -82,6 +87,9 @@ func TestVersion(t *testing.T) {
 
 	want = []string{
 		"apigee-remote-service-cli version /version/ /date/ [/commit/]",
+		"embedded proxy internal.zip sha256:da6f969fc72e086be06c07585afe715a1c5b04e08c3f0f9c2df7a50236fc0bc1",
+		"embedded proxy remote-service-gcp.zip sha256:99f0bf79ebb68180c8e22ff6455cfca189ca35eeb75f7e8739dfbf1e700d3175",
+		"embedded proxy remote-service-legacy.zip sha256:ada1adb38dd70815cb39c8b69704c70ea64cd875397d22150afd67b796a969de",
 		"remote-service proxy version: 1.2.42",
 	}
 
@@ -101,7 +109,58 @@ func TestVersion(t *testing.T) {
 
 	wantPrint := []string{
 		"apigee-remote-service-cli version /version/ /date/ [/commit/]",
+		"embedded proxy internal.zip sha256:da6f969fc72e086be06c07585afe715a1c5b04e08c3f0f9c2df7a50236fc0bc1",
+		"embedded proxy remote-service-gcp.zip sha256:99f0bf79ebb68180c8e22ff6455cfca189ca35eeb75f7e8739dfbf1e700d3175",
+		"embedded proxy remote-service-legacy.zip sha256:ada1adb38dd70815cb39c8b69704c70ea64cd875397d22150afd67b796a969de",
 	}
 
 	print.Check(t, wantPrint)
 }
+
+func TestProfileMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	print := testutil.Printer("TestProfileMetrics")
+	rootCmd := GetRootCmd([]string{"version", "--profile-metrics", dir}, print.Printf)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print = testutil.Printer("TestProfileMetrics:summary")
+	StopProfileMetrics(rootCmd, print.Printf)
+	print.CheckPrefix(t, []string{"profile: "})
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.summary.json"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("want a summary, got %v, %v", files, err)
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary profileSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatal(err)
+	}
+	if summary.Command != "apigee-remote-service-cli version" || summary.TotalAlloc == 0 {
+		t.Errorf("unexpected summary %#v", summary)
+	}
+	for _, f := range []string{summary.CPUProfile, summary.HeapProfile, summary.AllocsProfile} {
+		if info, err := os.Stat(f); err != nil || info.Size() == 0 {
+			t.Errorf("want profile %s written, got %v, %v", f, info, err)
+		}
+	}
+
+	// a run without the flag writes nothing
+	print = testutil.Printer("TestProfileMetrics:none")
+	rootCmd = GetRootCmd([]string{"version"}, print.Printf)
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print = testutil.Printer("TestProfileMetrics:none")
+	StopProfileMetrics(rootCmd, print.Printf)
+	print.Check(t, nil)
+}
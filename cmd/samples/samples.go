@@ -0,0 +1,579 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package samples
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+type samples struct {
+	*shared.RootArgs
+	template      string
+	templateDir   string
+	outDir        string
+	overwrite     bool
+	updateFiles   bool
+	fromProvision string
+	adapterHost   string
+	targets       []string
+	targetPort    int
+	adapterPort   int
+	listenPort    int
+	tag           string
+	workloads     []string
+	workloadNS    string
+	revision      string
+	replicas      int
+	cpu           string // request[:limit]
+	memory        string // request[:limit]
+
+	provisioned *shared.ProvisionResult // set by --from-provision
+}
+
+// templateData is the data model the sample templates are rendered with
+type templateData struct {
+	Platform    string
+	Org         string
+	Env         string
+	Runtime     string
+	Namespace   string
+	ConfigMap   string
+	Secret      string
+	AdapterHost string
+	Target      string // host of the first of Targets
+	TargetPort  int
+	TargetTLS   bool // TargetPort is 443
+	Targets     []sampleTarget
+	AdapterPort int
+	ListenPort  int
+	Tag         string
+
+	RemoteServiceURL  string        // remote-service proxy of Runtime
+	Filters           []istioFilter // one per workload, or one for all workloads
+	WorkloadNamespace string
+	Revision          string // control plane revision injecting the sidecar
+
+	Replicas  int // of the adapter deployment
+	Resources adapterResources
+
+	// of --from-provision only, for the templates of --template-dir
+	Credential *shared.Credential // key and secret of the remote-service app
+	Endpoints  shared.Endpoints
+	Cert       *certData // policy keys of the Secret (hybrid and Apigee X)
+}
+
+// sampleTarget is a target service routed to and authorized, by its host
+// if there are several
+type sampleTarget struct {
+	Name    string // of the cluster or backend
+	Host    string
+	Port    int
+	TLS     bool     // Port is 443
+	Domains []string // of its virtual host
+}
+
+// adapterResources are the compute resources of the adapter container
+type adapterResources struct {
+	CPURequest    string
+	CPULimit      string
+	MemoryRequest string
+	MemoryLimit   string
+}
+
+// quantity is a Kubernetes resource quantity of --cpu and --memory
+var quantity = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?(m|k|M|G|T|Ki|Mi|Gi|Ti)?$`)
+
+// parseResource returns the request and limit of a flag of request[:limit],
+// the limit defaulting to the request
+func parseResource(flag, value string) (string, string, error) {
+	split := strings.SplitN(value, ":", 2)
+	request, limit := split[0], split[0]
+	if len(split) == 2 {
+		limit = split[1]
+	}
+	if !quantity.MatchString(request) || !quantity.MatchString(limit) {
+		return "", "", fmt.Errorf("--%s must be request[:limit] of Kubernetes quantities (eg. 100m or 128Mi), got %q", flag, value)
+	}
+	return request, limit, nil
+}
+
+// istioFilter applies the filter and JWT authentication to the workload
+// of the app label, all workloads if empty
+type istioFilter struct {
+	Name     string
+	Workload string
+}
+
+// certData are the policy keys the adapter signs and verifies tokens with
+type certData struct {
+	KID        string // key ID
+	JWKS       string // JSON of the public keys
+	PrivateKey string // PEM
+}
+
+// Cmd returns base command
+func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	s := &samples{RootArgs: rootArgs}
+
+	c := &cobra.Command{
+		Use:   "samples",
+		Short: "Create sample configurations for apigee-remote-service-envoy",
+		Long:  "Create sample configurations for apigee-remote-service-envoy.",
+	}
+
+	c.PersistentFlags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.PersistentFlags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+
+	c.AddCommand(cmdCreate(s, printf))
+
+	return c
+}
+
+func cmdCreate(s *samples, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "create",
+		Short: "Create sample configuration files",
+		Long: `Create sample configuration files. Values from a prior provision run may be
+injected with --from-provision, flags given on the command line take precedence.
+
+With --template-dir, the files of the directory are rendered instead as Go templates,
+their paths too, to --out (a .tmpl suffix removed). Besides the values of the
+samples (eg. {{.Org}}, {{.Env}}, {{.Runtime}}, {{.Namespace}}, {{.ConfigMap}},
+{{.Secret}}) and the functions of config render, the templates have from
+--from-provision {{.Credential.Key}} and {{.Credential.Secret}}, {{.Endpoints}} and,
+from the Secret of the emitted resources, {{.Cert.KID}}, {{.Cert.JWKS}} and
+{{.Cert.PrivateKey}}. The files are written readable by the owner only.
+
+With --update, existing files are regenerated keeping their local edits: the files
+as last generated are kept in .generated of --out, a file edited locally is merged
+with the changes of the samples, the lines both changed marked as a conflict
+between <<<<<<< local and >>>>>>> generated. A summary of the changes is printed.`,
+		Args: cobra.NoArgs,
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if ws := s.Workspace; ws != nil && s.Org != "" && s.Env != "" {
+				if !cmd.Flags().Changed("out") {
+					s.outDir = ws.SamplesDir(s.Org, s.Env)
+				}
+				if s.fromProvision == "" {
+					if file := ws.ProvisionFile(s.Org, s.Env); fileExists(file) {
+						s.fromProvision = file
+					}
+				}
+			}
+			if s.templateDir != "" && cmd.Flags().Changed("template") {
+				return fmt.Errorf("--template and --template-dir are exclusive")
+			}
+			if s.updateFiles && s.overwrite {
+				return fmt.Errorf("--update and --force are exclusive")
+			}
+			if s.fromProvision != "" {
+				if err := s.injectProvisionResult(cmd, s.fromProvision); err != nil {
+					return err
+				}
+			}
+			return s.create(printf)
+		},
+	}
+
+	c.Flags().StringVarP(&s.template, "template", "", "native",
+		fmt.Sprintf("template to use (%s)", strings.Join(templateNames(), ", ")))
+	c.Flags().StringVarP(&s.templateDir, "template-dir", "", "",
+		"directory of custom templates to render instead of --template")
+	c.Flags().StringVarP(&s.outDir, "out", "", "./samples",
+		"directory to write the sample files to (default in a workspace: <org>/<env>/samples)")
+	c.Flags().BoolVarP(&s.overwrite, "force", "f", false,
+		"overwrite existing files")
+	c.Flags().BoolVarP(&s.updateFiles, "update", "", false,
+		"regenerate existing files, keeping their local edits and marking conflicting ones")
+	c.Flags().StringVarP(&s.fromProvision, "from-provision", "", "",
+		"output file of a prior provision run to take organization, environment, runtime and resource names from")
+	c.Flags().StringVarP(&s.Namespace, "namespace", "n", "apigee",
+		"emit configuration in the specified namespace")
+	c.Flags().StringVarP(&s.adapterHost, "adapter-host", "", "localhost",
+		"host of apigee-remote-service-envoy (native and envoy-bootstrap only)")
+	c.Flags().IntVarP(&s.adapterPort, "adapter-port", "", 5000,
+		"port of apigee-remote-service-envoy (envoy-bootstrap only)")
+	c.Flags().StringArrayVarP(&s.targets, "target", "", []string{"httpbin.org"},
+		"host[:port] of a target service, may be repeated to route to each by the host requested (native, envoy-bootstrap and gateway-api only)")
+	c.Flags().IntVarP(&s.targetPort, "target-port", "", 80,
+		"port of the targets without one, 443 uses TLS (native, envoy-bootstrap and gateway-api only)")
+	c.Flags().IntVarP(&s.listenPort, "listen-port", "", 8080,
+		"port Envoy listens on (envoy-bootstrap only)")
+	c.Flags().StringVarP(&s.tag, "tag", "", "latest",
+		"image tag of apigee-remote-service-envoy (istio, gateway-api and helm only)")
+	c.Flags().StringArrayVarP(&s.workloads, "workload", "", nil,
+		"app label of a workload to apply the filter and JWT authentication to, may be repeated (default: all workloads of --workload-namespace) (istio only)")
+	c.Flags().StringVarP(&s.workloadNS, "workload-namespace", "", "",
+		"namespace of the workloads (default: --namespace) (istio only)")
+	c.Flags().StringVarP(&s.revision, "revision", "", "",
+		`Istio or ASM control plane revision labelling the workload namespace for injection (eg. "asm-1234-2") instead of istio-injection (istio only)`)
+	c.Flags().IntVarP(&s.replicas, "replicas", "", 1,
+		"replicas of the adapter deployment, kept available by its PodDisruptionBudget (istio, gateway-api and helm only)")
+	c.Flags().StringVarP(&s.cpu, "cpu", "", "10m:100m",
+		"CPU request[:limit] of the adapter container (istio, gateway-api and helm only)")
+	c.Flags().StringVarP(&s.memory, "memory", "", "100Mi",
+		"memory request[:limit] of the adapter container (istio, gateway-api and helm only)")
+
+	return c
+}
+
+// injectProvisionResult sets the values found in a provision result
+// unless the corresponding flag was set explicitly
+func (s *samples) injectProvisionResult(cmd *cobra.Command, file string) error {
+	result, err := shared.ReadProvisionResult(file)
+	if err != nil {
+		return errors.Wrap(err, "reading provision result")
+	}
+
+	inject := func(flag string, target *string, value string) {
+		if value != "" && !cmd.Flags().Changed(flag) {
+			*target = value
+		}
+	}
+	inject("organization", &s.Org, result.Organization)
+	inject("environment", &s.Env, result.Environment)
+	inject("runtime", &s.RuntimeBase, result.Runtime)
+	inject("namespace", &s.Namespace, result.Namespace)
+
+	if !cmd.Flags().Changed("legacy") && !cmd.Flags().Changed("opdk") {
+		s.IsLegacySaaS = result.Platform == shared.PlatformSaaS
+		s.IsOPDK = result.Platform == shared.PlatformOPDK
+	}
+
+	s.provisioned = result
+	return nil
+}
+
+func (s *samples) data() (*templateData, error) {
+	if s.IsLegacySaaS && s.IsOPDK {
+		return nil, fmt.Errorf("--legacy and --opdk options are exclusive")
+	}
+	if s.IsLegacySaaS && s.RuntimeBase == "" && s.Org != "" && s.Env != "" {
+		s.RuntimeBase = fmt.Sprintf(shared.RuntimeBaseFormat, s.Org, s.Env)
+	}
+
+	var missing []string
+	if s.Org == "" {
+		missing = append(missing, "organization")
+	}
+	if s.Env == "" {
+		missing = append(missing, "environment")
+	}
+	if s.RuntimeBase == "" {
+		missing = append(missing, "runtime")
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf(`required flag(s) "%s" not set (or use --from-provision)`, strings.Join(missing, `", "`))
+	}
+	for _, p := range []struct {
+		flag string
+		port int
+	}{{"target-port", s.targetPort}, {"adapter-port", s.adapterPort}, {"listen-port", s.listenPort}} {
+		if p.port < 1 || p.port > 65535 {
+			return nil, fmt.Errorf("--%s must be between 1 and 65535, got %d", p.flag, p.port)
+		}
+	}
+
+	if s.replicas < 1 {
+		return nil, fmt.Errorf("--replicas must be at least 1, got %d", s.replicas)
+	}
+	var res adapterResources
+	var err error
+	if res.CPURequest, res.CPULimit, err = parseResource("cpu", s.cpu); err != nil {
+		return nil, err
+	}
+	if res.MemoryRequest, res.MemoryLimit, err = parseResource("memory", s.memory); err != nil {
+		return nil, err
+	}
+
+	targets, err := s.sampleTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &templateData{
+		Platform:    shared.PlatformGCP,
+		Org:         s.Org,
+		Env:         s.Env,
+		Runtime:     s.RuntimeBase,
+		Namespace:   s.Namespace,
+		ConfigMap:   shared.ConfigMapName,
+		AdapterHost: s.adapterHost,
+		Target:      targets[0].Host,
+		TargetPort:  targets[0].Port,
+		TargetTLS:   targets[0].TLS,
+		Targets:     targets,
+		AdapterPort: s.adapterPort,
+		ListenPort:  s.listenPort,
+		Tag:         s.tag,
+
+		RemoteServiceURL:  strings.TrimSuffix(s.RuntimeBase, "/") + "/remote-service",
+		Filters:           istioFilters(s.workloads),
+		WorkloadNamespace: s.workloadNS,
+		Revision:          s.revision,
+
+		Replicas:  s.replicas,
+		Resources: res,
+	}
+	if data.WorkloadNamespace == "" {
+		data.WorkloadNamespace = s.Namespace
+	}
+	switch {
+	case s.IsLegacySaaS:
+		data.Platform = shared.PlatformSaaS
+	case s.IsOPDK:
+		data.Platform = shared.PlatformOPDK
+	default:
+		data.Secret = fmt.Sprintf(shared.PolicySecretNameFormat, s.Org, s.Env)
+	}
+
+	if p := s.provisioned; p != nil {
+		if p.ConfigMap != "" {
+			data.ConfigMap = p.ConfigMap
+		}
+		if p.Secret != "" && data.Platform == shared.PlatformGCP {
+			data.Secret = p.Secret
+		}
+		data.Credential = p.Credential
+		data.Endpoints = p.Endpoints
+		if len(p.SecretData) > 0 {
+			cert, err := policyCert(p.SecretData)
+			if err != nil {
+				return nil, err
+			}
+			data.Cert = cert
+		}
+	}
+
+	return data, nil
+}
+
+// sampleTargets returns the targets of --target, a single target is routed
+// all requests
+func (s *samples) sampleTargets() ([]sampleTarget, error) {
+	if len(s.targets) == 0 {
+		return nil, fmt.Errorf("--target is required")
+	}
+	var targets []sampleTarget
+	seen := map[string]bool{}
+	for i, target := range s.targets {
+		t := sampleTarget{Name: "target", Host: target, Port: s.targetPort, Domains: []string{"*"}}
+		if strings.Contains(target, ":") {
+			host, port, err := net.SplitHostPort(target)
+			if err != nil {
+				return nil, fmt.Errorf("--target must be host or host:port, got %q", target)
+			}
+			if t.Port, err = strconv.Atoi(port); err != nil || t.Port < 1 || t.Port > 65535 {
+				return nil, fmt.Errorf("port of --target %s must be between 1 and 65535", target)
+			}
+			t.Host = host
+		}
+		if t.Host == "" {
+			return nil, fmt.Errorf("--target must be host or host:port, got %q", target)
+		}
+		if seen[t.Host] {
+			return nil, fmt.Errorf("--target %s given twice, targets are routed by host", t.Host)
+		}
+		seen[t.Host] = true
+		if len(s.targets) > 1 {
+			t.Name = fmt.Sprintf("target-%d", i+1)
+			t.Domains = []string{t.Host, t.Host + ":*"}
+		}
+		t.TLS = t.Port == 443
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// istioFilters returns a filter per workload, suffixed by the workload if
+// there are several, or a filter for all workloads
+func istioFilters(workloads []string) []istioFilter {
+	const name = "apigee-remote-service-envoy"
+	if len(workloads) == 0 {
+		return []istioFilter{{Name: name}}
+	}
+	var filters []istioFilter
+	for _, w := range workloads {
+		f := istioFilter{Name: name, Workload: w}
+		if len(workloads) > 1 {
+			f.Name += "-" + w
+		}
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+// policyCert returns the policy keys of the data of the Secret
+func policyCert(secretData map[string][]byte) (*certData, error) {
+	props, err := server.ReadProperties(bytes.NewReader(secretData[server.SecretPropsKey]))
+	if err != nil {
+		return nil, errors.Wrap(err, "reading policy secret properties")
+	}
+	return &certData{
+		KID:        props[server.SecretPropsKIDKey],
+		JWKS:       string(secretData[server.SecretJKWSKey]),
+		PrivateKey: string(secretData[server.SecretPrivateKey]),
+	}, nil
+}
+
+func (s *samples) create(printf shared.FormatFn) error {
+	name, mode := s.template, os.FileMode(0644)
+	files, ok := sampleTemplates[s.template]
+	if s.templateDir != "" {
+		var err error
+		if files, err = readTemplateDir(s.templateDir); err != nil {
+			return err
+		}
+		// may hold the credential and private key
+		name, mode, ok = s.templateDir, 0600, true
+	}
+	if !ok {
+		return fmt.Errorf("unknown template %s, must be one of: %s", s.template, strings.Join(templateNames(), ", "))
+	}
+
+	data, err := s.data()
+	if err != nil {
+		return err
+	}
+	s.TraceTemplate(name, data)
+
+	if s.templateDir != "" {
+		rendered := make(map[string]string, len(files))
+		for path, content := range files {
+			if path, err = shared.RenderTemplate(path, path, data); err != nil {
+				return err
+			}
+			rendered[path] = content
+		}
+		files = rendered
+	}
+
+	if err := os.MkdirAll(s.outDir, 0755); err != nil {
+		return errors.Wrapf(err, "creating directory %s", s.outDir)
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rendered := make(map[string]string, len(names))
+	for _, name := range names {
+		content := files[name]
+		if s.templateDir != "" || !strings.HasPrefix(name, helmTemplatesDir) {
+			if content, err = shared.RenderTemplate(name, content, data); err != nil {
+				return err
+			}
+		}
+		rendered[name] = content
+	}
+	if s.updateFiles {
+		return s.update(names, rendered, mode, printf)
+	}
+
+	if !s.overwrite {
+		for _, name := range names {
+			file := filepath.Join(s.outDir, name)
+			if _, err := os.Stat(file); err == nil {
+				return fmt.Errorf("%s exists, use --force to overwrite or --update to merge", file)
+			}
+		}
+	}
+
+	for _, name := range names {
+		file := filepath.Join(s.outDir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return errors.Wrapf(err, "creating directory %s", filepath.Dir(file))
+		}
+		if err := ioutil.WriteFile(file, []byte(rendered[name]), mode); err != nil {
+			return errors.Wrapf(err, "writing %s", file)
+		}
+		if err := s.writeGenerated(name, rendered[name]); err != nil {
+			return errors.Wrapf(err, "writing the generated %s", name)
+		}
+		printf("wrote %s", file)
+	}
+
+	return nil
+}
+
+// readTemplateDir returns the files of dir by path relative to it, a .tmpl
+// suffix removed, skipping hidden files
+func readTemplateDir(dir string) (map[string]string, error) {
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") && path != dir {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[strings.TrimSuffix(filepath.ToSlash(rel), ".tmpl")] = string(content)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "reading --template-dir")
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("--template-dir %s has no templates", dir)
+	}
+	return files, nil
+}
+
+func templateNames() []string {
+	names := make([]string, 0, len(sampleTemplates))
+	for name := range sampleTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func fileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
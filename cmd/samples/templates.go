@@ -0,0 +1,715 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package samples
+
+// sampleTemplates maps template names to the files they create
+var sampleTemplates = map[string]map[string]string{
+	"native": {
+		"envoy-config.yaml": nativeEnvoyConfig,
+	},
+	"envoy-bootstrap": {
+		"envoy-bootstrap.yaml": envoyBootstrap,
+	},
+	"istio": {
+		"apigee-envoy-adapter.yaml":  istioAdapter,
+		"envoyfilter.yaml":           istioEnvoyFilter,
+		"requestauthentication.yaml": istioRequestAuthentication,
+		"namespace.yaml":             istioNamespace,
+	},
+	"gateway-api": {
+		"apigee-envoy-adapter.yaml": istioAdapter,
+		"gateway.yaml":              gatewayAPIGateway,
+		"envoypatchpolicy.yaml":     gatewayAPIPatchPolicy,
+	},
+	"helm": {
+		"values.yaml": helmValues,
+	},
+	"helm-chart": {
+		"Chart.yaml":                helmChart,
+		"values.yaml":               helmValues,
+		"templates/deployment.yaml": helmDeployment,
+		"templates/service.yaml":    helmService,
+		"templates/pdb.yaml":        helmPDB,
+	},
+}
+
+// helmTemplatesDir holds the templates of a chart, they are written as is to
+// be rendered by helm with the values
+const helmTemplatesDir = "templates/"
+
+const nativeEnvoyConfig = `# Envoy configuration using apigee-remote-service-envoy
+# organization: {{.Org}}, environment: {{.Env}}, runtime: {{.Runtime}}
+static_resources:
+  listeners:
+  - address:
+      socket_address:
+        address: 0.0.0.0
+        port_value: 8080
+    filter_chains:
+    - filters:
+      - name: envoy.filters.network.http_connection_manager
+        typed_config:
+          "@type": type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager
+          stat_prefix: ingress_http
+          route_config:
+            virtual_hosts:
+{{- range .Targets}}
+            - name: {{.Name}}
+              domains:
+{{- range .Domains}}
+              - "{{.}}"
+{{- end}}
+              routes:
+              - match:
+                  prefix: /
+                route:
+                  cluster: {{.Name}}
+                  host_rewrite_literal: {{.Host}}
+{{- end}}
+          http_filters:
+          - name: envoy.filters.http.ext_authz
+            typed_config:
+              "@type": type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthz
+              transport_api_version: V3
+              grpc_service:
+                envoy_grpc:
+                  cluster_name: apigee-remote-service-envoy
+                timeout: 1s
+          - name: envoy.filters.http.router
+          access_log:
+          - name: envoy.access_loggers.http_grpc
+            typed_config:
+              "@type": type.googleapis.com/envoy.extensions.access_loggers.grpc.v3.HttpGrpcAccessLogConfig
+              common_config:
+                transport_api_version: V3
+                log_name: apigee-remote-service-envoy
+                grpc_service:
+                  envoy_grpc:
+                    cluster_name: apigee-remote-service-envoy
+  clusters:
+{{- range .Targets}}
+  - name: {{.Name}}
+    connect_timeout: 2s
+    type: LOGICAL_DNS
+    dns_lookup_family: V4_ONLY
+    load_assignment:
+      cluster_name: {{.Name}}
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address:
+                address: {{.Host}}
+                port_value: {{.Port}}
+{{- if .TLS}}
+    transport_socket:
+      name: envoy.transport_sockets.tls
+      typed_config:
+        "@type": type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext
+        sni: {{.Host}}
+{{- end}}
+{{- end}}
+  - name: apigee-remote-service-envoy
+    connect_timeout: 2s
+    type: LOGICAL_DNS
+    http2_protocol_options: {}
+    load_assignment:
+      cluster_name: apigee-remote-service-envoy
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address:
+                address: {{.AdapterHost}}
+                port_value: 5000
+`
+
+const envoyBootstrap = `# Standalone Envoy bootstrap using apigee-remote-service-envoy
+# organization: {{.Org}}, environment: {{.Env}}, runtime: {{.Runtime}}
+# run with: envoy -c envoy-bootstrap.yaml
+# requests to http://localhost:{{.ListenPort}} are authorized by the adapter at {{.AdapterHost}}:{{.AdapterPort}}
+# and proxied to {{range $i, $t := .Targets}}{{if $i}}, {{end}}{{$t.Host}}:{{$t.Port}}{{end}}
+{{- if gt (len .Targets) 1}} by the host requested
+{{- end}}
+node:
+  id: apigee-remote-service-envoy-{{.Env}}
+  cluster: apigee-remote-service-envoy
+admin:
+  address:
+    socket_address:
+      address: 127.0.0.1
+      port_value: 9901
+static_resources:
+  listeners:
+  - name: ingress
+    address:
+      socket_address:
+        address: 0.0.0.0
+        port_value: {{.ListenPort}}
+    filter_chains:
+    - filters:
+      - name: envoy.filters.network.http_connection_manager
+        typed_config:
+          "@type": type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager
+          stat_prefix: ingress_http
+          route_config:
+            name: local_route
+            virtual_hosts:
+{{- range .Targets}}
+            - name: {{.Name}}
+              domains:
+{{- range .Domains}}
+              - "{{.}}"
+{{- end}}
+              routes:
+              - match:
+                  prefix: /
+                route:
+                  cluster: {{.Name}}
+                  host_rewrite_literal: {{.Host}}
+                typed_per_filter_config:
+                  envoy.filters.http.ext_authz:
+                    "@type": type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthzPerRoute
+                    check_settings:
+                      context_extensions:
+                        apigee_environment: {{$.Env}}
+{{- end}}
+          http_filters:
+          - name: envoy.filters.http.ext_authz
+            typed_config:
+              "@type": type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthz
+              transport_api_version: V3
+              failure_mode_allow: false
+              grpc_service:
+                envoy_grpc:
+                  cluster_name: apigee-remote-service-envoy
+                timeout: 1s
+          - name: envoy.filters.http.router
+            typed_config:
+              "@type": type.googleapis.com/envoy.extensions.filters.http.router.v3.Router
+          access_log:
+          - name: envoy.access_loggers.http_grpc
+            typed_config:
+              "@type": type.googleapis.com/envoy.extensions.access_loggers.grpc.v3.HttpGrpcAccessLogConfig
+              common_config:
+                transport_api_version: V3
+                log_name: apigee-remote-service-envoy
+                grpc_service:
+                  envoy_grpc:
+                    cluster_name: apigee-remote-service-envoy
+              additional_request_headers_to_log:
+              - :authority
+              - x-apigee-accesstoken
+              - x-apigee-api
+              - x-apigee-apiproducts
+              - x-apigee-application
+              - x-apigee-clientid
+              - x-apigee-developeremail
+  clusters:
+{{- range .Targets}}
+  - name: {{.Name}}
+    connect_timeout: 2s
+    type: LOGICAL_DNS
+    dns_lookup_family: V4_ONLY
+    load_assignment:
+      cluster_name: {{.Name}}
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address:
+                address: {{.Host}}
+                port_value: {{.Port}}
+{{- if .TLS}}
+    transport_socket:
+      name: envoy.transport_sockets.tls
+      typed_config:
+        "@type": type.googleapis.com/envoy.extensions.transport_sockets.tls.v3.UpstreamTlsContext
+        sni: {{.Host}}
+{{- end}}
+{{- end}}
+  - name: apigee-remote-service-envoy
+    connect_timeout: 2s
+    type: LOGICAL_DNS
+    dns_lookup_family: V4_ONLY
+    typed_extension_protocol_options:
+      envoy.extensions.upstreams.http.v3.HttpProtocolOptions:
+        "@type": type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions
+        explicit_http_config:
+          http2_protocol_options: {}
+    load_assignment:
+      cluster_name: apigee-remote-service-envoy
+      endpoints:
+      - lb_endpoints:
+        - endpoint:
+            address:
+              socket_address:
+                address: {{.AdapterHost}}
+                port_value: {{.AdapterPort}}
+`
+
+const istioAdapter = `# apigee-remote-service-envoy for organization: {{.Org}}, environment: {{.Env}}
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: apigee-remote-service-envoy
+  namespace: {{.Namespace}}
+spec:
+  replicas: {{.Replicas}}
+  selector:
+    matchLabels:
+      app: apigee-remote-service-envoy
+  template:
+    metadata:
+      labels:
+        app: apigee-remote-service-envoy
+      annotations:
+        sidecar.istio.io/inject: "false"
+    spec:
+      containers:
+      - name: apigee-remote-service-envoy
+        image: "google/apigee-envoy-adapter:{{.Tag}}"
+        imagePullPolicy: IfNotPresent
+        args:
+        - --config=/config/config.yaml
+{{- if .Secret}}
+        - --policy-secret=/policy-secret
+{{- end}}
+        ports:
+        - containerPort: 5000
+          name: grpc
+        # the adapter serves the gRPC health service (Kubernetes 1.24+)
+        livenessProbe:
+          grpc:
+            port: 5000
+          periodSeconds: 10
+          failureThreshold: 3
+        readinessProbe:
+          grpc:
+            port: 5000
+          periodSeconds: 5
+          failureThreshold: 3
+        resources:
+          limits:
+            cpu: {{.Resources.CPULimit}}
+            memory: {{.Resources.MemoryLimit}}
+          requests:
+            cpu: {{.Resources.CPURequest}}
+            memory: {{.Resources.MemoryRequest}}
+        volumeMounts:
+        - mountPath: /config
+          name: apigee-remote-service-envoy
+          readOnly: true
+{{- if .Secret}}
+        - mountPath: /policy-secret
+          name: policy-secret
+          readOnly: true
+{{- end}}
+      volumes:
+      - name: apigee-remote-service-envoy
+        configMap:
+          name: {{.ConfigMap}}
+{{- if .Secret}}
+      - name: policy-secret
+        secret:
+          defaultMode: 420
+          secretName: {{.Secret}}
+{{- end}}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: apigee-remote-service-envoy
+  namespace: {{.Namespace}}
+  labels:
+    app: apigee-remote-service-envoy
+spec:
+  ports:
+  - port: 5000
+    name: grpc
+  selector:
+    app: apigee-remote-service-envoy
+---
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  name: apigee-remote-service-envoy
+  namespace: {{.Namespace}}
+spec:
+  maxUnavailable: 1
+  selector:
+    matchLabels:
+      app: apigee-remote-service-envoy
+`
+
+const istioEnvoyFilter = `{{range $i, $f := .Filters}}{{if $i}}---
+{{end -}}
+# ext_authz filter and access log calling apigee-remote-service-envoy in namespace {{$.Namespace}}
+# applied to {{if .Workload}}workload {{.Workload}}{{else}}all workloads{{end}} in namespace {{$.WorkloadNamespace}}
+apiVersion: networking.istio.io/v1alpha3
+kind: EnvoyFilter
+metadata:
+  name: {{.Name}}
+  namespace: {{$.WorkloadNamespace}}
+spec:
+{{- if .Workload}}
+  workloadSelector:
+    labels:
+      app: {{.Workload}}
+{{- end}}
+  configPatches:
+  - applyTo: HTTP_FILTER
+    match:
+      context: SIDECAR_INBOUND
+      listener:
+        filterChain:
+          filter:
+            name: envoy.filters.network.http_connection_manager
+            subFilter:
+              name: envoy.filters.http.router
+    patch:
+      operation: INSERT_BEFORE
+      value:
+        name: envoy.filters.http.ext_authz
+        typed_config:
+          "@type": type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthz
+          transport_api_version: V3
+          grpc_service:
+            envoy_grpc:
+              cluster_name: outbound|5000||apigee-remote-service-envoy.{{$.Namespace}}.svc.cluster.local
+            timeout: 1s
+          metadata_context_namespaces:
+          - envoy.filters.http.jwt_authn
+  - applyTo: NETWORK_FILTER
+    match:
+      context: SIDECAR_INBOUND
+      listener:
+        filterChain:
+          filter:
+            name: envoy.filters.network.http_connection_manager
+    patch:
+      operation: MERGE
+      value:
+        typed_config:
+          "@type": type.googleapis.com/envoy.extensions.filters.network.http_connection_manager.v3.HttpConnectionManager
+          access_log:
+          - name: envoy.access_loggers.http_grpc
+            typed_config:
+              "@type": type.googleapis.com/envoy.extensions.access_loggers.grpc.v3.HttpGrpcAccessLogConfig
+              common_config:
+                transport_api_version: V3
+                log_name: apigee-remote-service-envoy
+                grpc_service:
+                  envoy_grpc:
+                    cluster_name: outbound|5000||apigee-remote-service-envoy.{{$.Namespace}}.svc.cluster.local
+{{end}}`
+
+const istioRequestAuthentication = `{{range $i, $f := .Filters}}{{if $i}}---
+{{end -}}
+# validates JWTs issued by the remote-service proxy of organization: {{$.Org}}, environment: {{$.Env}}
+# for {{if .Workload}}workload {{.Workload}}{{else}}all workloads{{end}} in namespace {{$.WorkloadNamespace}},
+# the ext_authz filter reads the claims of the token from the metadata of jwt_authn
+apiVersion: security.istio.io/v1beta1
+kind: RequestAuthentication
+metadata:
+  name: {{.Name}}
+  namespace: {{$.WorkloadNamespace}}
+spec:
+{{- if .Workload}}
+  selector:
+    matchLabels:
+      app: {{.Workload}}
+{{- end}}
+  jwtRules:
+  - issuer: {{$.RemoteServiceURL}}/token
+    jwksUri: {{$.RemoteServiceURL}}/certs
+    audiences:
+    - remote-service-client
+    forwardOriginalToken: true
+{{end}}`
+
+const istioNamespace = `# namespace of the workloads, injected with the sidecar{{if .Revision}} of control plane revision {{.Revision}}{{end}}
+{{- if .Revision}}
+# the istio-injection label takes precedence over istio.io/rev, remove it from an existing namespace:
+#   kubectl label namespace {{.WorkloadNamespace}} istio-injection-
+{{- end}}
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{.WorkloadNamespace}}
+  labels:
+{{- if .Revision}}
+    istio.io/rev: {{.Revision}}
+{{- else}}
+    istio-injection: enabled
+{{- end}}
+`
+
+const gatewayAPIGateway = `# Envoy Gateway routing {{range $i, $t := .Targets}}{{if $i}}, {{end}}{{$t.Host}}{{end}} for organization: {{.Org}}, environment: {{.Env}}
+apiVersion: gateway.networking.k8s.io/v1
+kind: GatewayClass
+metadata:
+  name: apigee-remote-service-envoy
+spec:
+  controllerName: gateway.envoyproxy.io/gatewayclass-controller
+---
+apiVersion: gateway.networking.k8s.io/v1
+kind: Gateway
+metadata:
+  name: apigee-remote-service-envoy
+  namespace: {{.Namespace}}
+spec:
+  gatewayClassName: apigee-remote-service-envoy
+  listeners:
+  - name: http
+    protocol: HTTP
+    port: 80
+{{- range .Targets}}
+---
+apiVersion: gateway.envoyproxy.io/v1alpha1
+kind: Backend
+metadata:
+  name: {{.Name}}
+  namespace: {{$.Namespace}}
+spec:
+  endpoints:
+  - fqdn:
+      hostname: {{.Host}}
+      port: {{.Port}}
+---
+apiVersion: gateway.networking.k8s.io/v1
+kind: HTTPRoute
+metadata:
+  name: {{.Name}}
+  namespace: {{$.Namespace}}
+spec:
+  parentRefs:
+  - name: apigee-remote-service-envoy
+{{- if gt (len $.Targets) 1}}
+  hostnames:
+  - {{.Host}}
+{{- end}}
+  rules:
+  - matches:
+    - path:
+        type: PathPrefix
+        value: /
+    filters:
+    - type: URLRewrite
+      urlRewrite:
+        hostname: {{.Host}}
+    backendRefs:
+    - group: gateway.envoyproxy.io
+      kind: Backend
+      name: {{.Name}}
+{{- end}}
+`
+
+const gatewayAPIPatchPolicy = `# ext_authz filter calling apigee-remote-service-envoy in namespace {{.Namespace}}
+# requires extensionApis.enableEnvoyPatchPolicy in the Envoy Gateway configuration
+apiVersion: gateway.envoyproxy.io/v1alpha1
+kind: EnvoyPatchPolicy
+metadata:
+  name: apigee-remote-service-envoy
+  namespace: {{.Namespace}}
+spec:
+  targetRef:
+    group: gateway.networking.k8s.io
+    kind: Gateway
+    name: apigee-remote-service-envoy
+  type: JSONPatch
+  jsonPatches:
+  - type: type.googleapis.com/envoy.config.listener.v3.Listener
+    name: {{.Namespace}}/apigee-remote-service-envoy/http
+    operation:
+      op: add
+      path: /default_filter_chain/filters/0/typed_config/http_filters/0
+      value:
+        name: envoy.filters.http.ext_authz
+        typed_config:
+          "@type": type.googleapis.com/envoy.extensions.filters.http.ext_authz.v3.ExtAuthz
+          transport_api_version: V3
+          grpc_service:
+            envoy_grpc:
+              cluster_name: apigee-remote-service-envoy
+            timeout: 1s
+  - type: type.googleapis.com/envoy.config.cluster.v3.Cluster
+    name: apigee-remote-service-envoy
+    operation:
+      op: add
+      path: ""
+      value:
+        name: apigee-remote-service-envoy
+        connect_timeout: 2s
+        type: STRICT_DNS
+        typed_extension_protocol_options:
+          envoy.extensions.upstreams.http.v3.HttpProtocolOptions:
+            "@type": type.googleapis.com/envoy.extensions.upstreams.http.v3.HttpProtocolOptions
+            explicit_http_config:
+              http2_protocol_options: {}
+        load_assignment:
+          cluster_name: apigee-remote-service-envoy
+          endpoints:
+          - lb_endpoints:
+            - endpoint:
+                address:
+                  socket_address:
+                    address: apigee-remote-service-envoy.{{.Namespace}}.svc.cluster.local
+                    port_value: 5000
+`
+
+const helmValues = `# Helm values for apigee-remote-service-envoy
+# organization: {{.Org}}, environment: {{.Env}}, platform: {{.Platform}}
+# install: helm install apigee-remote-service-envoy <chart> --namespace {{.Namespace}} -f values.yaml
+image:
+  repository: google/apigee-envoy-adapter
+  tag: "{{.Tag}}"
+  pullPolicy: IfNotPresent
+replicaCount: {{.Replicas}}
+apigee:
+  platform: {{.Platform}}
+  organization: {{.Org}}
+  environment: {{.Env}}
+  runtime: {{.Runtime}}
+# ConfigMap and Secret created by apigee-remote-service-cli provision
+configMap: {{.ConfigMap}}
+policySecret: "{{.Secret}}"
+service:
+  port: 5000
+resources:
+  limits:
+    cpu: {{.Resources.CPULimit}}
+    memory: {{.Resources.MemoryLimit}}
+  requests:
+    cpu: {{.Resources.CPURequest}}
+    memory: {{.Resources.MemoryRequest}}
+# the probes call the gRPC health service of the adapter (Kubernetes 1.24+)
+probes:
+  liveness:
+    periodSeconds: 10
+    failureThreshold: 3
+  readiness:
+    periodSeconds: 5
+    failureThreshold: 3
+podDisruptionBudget:
+  maxUnavailable: 1
+podAnnotations:
+  sidecar.istio.io/inject: "false"
+`
+
+const helmChart = `apiVersion: v2
+name: apigee-remote-service-envoy
+description: Apigee Remote Service adapter for Envoy, organization {{.Org}}, environment {{.Env}}
+type: application
+version: 0.1.0
+appVersion: "{{.Tag}}"
+`
+
+const helmDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{ .Release.Name }}
+  labels:
+    app: {{ .Release.Name }}
+spec:
+  replicas: {{ .Values.replicaCount }}
+  selector:
+    matchLabels:
+      app: {{ .Release.Name }}
+  template:
+    metadata:
+      labels:
+        app: {{ .Release.Name }}
+      {{- with .Values.podAnnotations }}
+      annotations:
+        {{- toYaml . | nindent 8 }}
+      {{- end }}
+    spec:
+      containers:
+      - name: apigee-remote-service-envoy
+        image: "{{ .Values.image.repository }}:{{ .Values.image.tag }}"
+        imagePullPolicy: {{ .Values.image.pullPolicy }}
+        args:
+        - --config=/config/config.yaml
+        {{- if .Values.policySecret }}
+        - --policy-secret=/policy-secret
+        {{- end }}
+        ports:
+        - containerPort: 5000
+          name: grpc
+        {{- with .Values.probes.liveness }}
+        livenessProbe:
+          grpc:
+            port: 5000
+          {{- toYaml . | nindent 10 }}
+        {{- end }}
+        {{- with .Values.probes.readiness }}
+        readinessProbe:
+          grpc:
+            port: 5000
+          {{- toYaml . | nindent 10 }}
+        {{- end }}
+        resources:
+          {{- toYaml .Values.resources | nindent 10 }}
+        volumeMounts:
+        - mountPath: /config
+          name: config
+          readOnly: true
+        {{- if .Values.policySecret }}
+        - mountPath: /policy-secret
+          name: policy-secret
+          readOnly: true
+        {{- end }}
+      volumes:
+      - name: config
+        configMap:
+          name: {{ .Values.configMap }}
+      {{- if .Values.policySecret }}
+      - name: policy-secret
+        secret:
+          defaultMode: 420
+          secretName: {{ .Values.policySecret }}
+      {{- end }}
+`
+
+const helmService = `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ .Release.Name }}
+  labels:
+    app: {{ .Release.Name }}
+spec:
+  ports:
+  - port: {{ .Values.service.port }}
+    targetPort: 5000
+    name: grpc
+  selector:
+    app: {{ .Release.Name }}
+`
+
+const helmPDB = `{{- with .Values.podDisruptionBudget }}
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  name: {{ $.Release.Name }}
+  labels:
+    app: {{ $.Release.Name }}
+spec:
+  {{- toYaml . | nindent 2 }}
+  selector:
+    matchLabels:
+      app: {{ $.Release.Name }}
+{{- end }}
+`
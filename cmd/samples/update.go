@@ -0,0 +1,283 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package samples
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+)
+
+// generatedDir of --out keeps the files as last generated, the base of
+// merging local edits with regenerated files on --update
+const generatedDir = ".generated"
+
+const (
+	conflictLocal     = "<<<<<<< local"
+	conflictSeparator = "======="
+	conflictGenerated = ">>>>>>> generated"
+)
+
+// writeGenerated keeps content as the base of the next --update of name
+func (s *samples) writeGenerated(name, content string) error {
+	file := filepath.Join(s.outDir, generatedDir, name)
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return errors.Wrapf(err, "creating directory %s", filepath.Dir(file))
+	}
+	return ioutil.WriteFile(file, []byte(content), 0600)
+}
+
+// update writes the rendered files changed since last generated, merging
+// in the local edits of each, and prints a summary of the changes
+func (s *samples) update(names []string, rendered map[string]string, mode os.FileMode, printf shared.FormatFn) error {
+	counts := map[string]int{}
+	var conflicts []string
+	for _, name := range names {
+		file := filepath.Join(s.outDir, name)
+		content := rendered[name]
+		local, localErr := ioutil.ReadFile(file)
+		base, baseErr := ioutil.ReadFile(filepath.Join(s.outDir, generatedDir, name))
+
+		action, merged := "", content
+		switch {
+		case os.IsNotExist(localErr):
+			action = "added"
+		case localErr != nil:
+			return errors.Wrapf(localErr, "reading %s", file)
+		case string(local) == content:
+			action = "unchanged"
+		case baseErr == nil && string(local) == string(base):
+			action = "updated"
+		case baseErr == nil && string(base) == content:
+			action = "kept" // only edited locally
+		default:
+			// generated before bases were kept, the lines of both are the base
+			baseLines := commonLines(splitLines(string(local)), splitLines(content))
+			if baseErr == nil {
+				baseLines = splitLines(string(base))
+			}
+			var conflict bool
+			merged, conflict = merge3(baseLines, splitLines(string(local)), splitLines(content))
+			action = "merged"
+			if conflict {
+				action = "conflict"
+				conflicts = append(conflicts, file)
+			}
+		}
+		counts[action]++
+
+		if action != "unchanged" && action != "kept" {
+			if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+				return errors.Wrapf(err, "creating directory %s", filepath.Dir(file))
+			}
+			if err := ioutil.WriteFile(file, []byte(merged), mode); err != nil {
+				return errors.Wrapf(err, "writing %s", file)
+			}
+		}
+		if err := s.writeGenerated(name, content); err != nil {
+			return errors.Wrapf(err, "writing the generated %s", name)
+		}
+		switch action {
+		case "kept":
+			printf("kept %s, edited locally and unchanged by the samples", file)
+		case "merged":
+			printf("merged %s, keeping the local edits", file)
+		case "conflict":
+			printf("conflict %s, local edits and the samples changed the same lines", file)
+		case "unchanged": // counted in the summary only
+		default:
+			printf("%s %s", action, file)
+		}
+	}
+
+	removed, err := s.removedFiles(rendered)
+	if err != nil {
+		return err
+	}
+	for _, name := range removed {
+		printf("no longer generated %s, kept", filepath.Join(s.outDir, name))
+	}
+
+	var summary []string
+	for _, action := range []string{"added", "updated", "merged", "conflict", "kept", "unchanged"} {
+		if counts[action] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[action], action))
+		}
+	}
+	printf("%s", strings.Join(summary, ", "))
+	if len(conflicts) > 0 {
+		return fmt.Errorf("resolve the conflicts marked by %q and %q in %s", conflictLocal, conflictGenerated, strings.Join(conflicts, ", "))
+	}
+	return nil
+}
+
+// removedFiles returns the files last generated but not rendered anymore
+func (s *samples) removedFiles(rendered map[string]string) ([]string, error) {
+	dir := filepath.Join(s.outDir, generatedDir)
+	var removed []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := rendered[filepath.ToSlash(name)]; !ok {
+			removed = append(removed, name)
+			return os.Remove(path)
+		}
+		return nil
+	})
+	sort.Strings(removed)
+	return removed, err
+}
+
+// splitLines splits s into lines keeping their newlines
+func splitLines(s string) []string {
+	var lines []string
+	for len(s) > 0 {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			return append(lines, s)
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+// matchLines returns the index in b of each line of a in a longest common
+// subsequence of them, -1 if not in it
+func matchLines(a, b []string) []int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	match := make([]int, len(a))
+	i, j := 0, 0
+	for i < len(a) {
+		switch {
+		case j < len(b) && a[i] == b[j]:
+			match[i] = j
+			i, j = i+1, j+1
+		case j == len(b) || lcs[i+1][j] >= lcs[i][j+1]:
+			match[i] = -1
+			i++
+		default:
+			j++
+		}
+	}
+	return match
+}
+
+// commonLines returns a longest common subsequence of the lines of a and b
+func commonLines(a, b []string) []string {
+	var common []string
+	for i, j := range matchLines(a, b) {
+		if j >= 0 {
+			common = append(common, a[i])
+		}
+	}
+	return common
+}
+
+// merge3 merges the changes of local and generated since base, marking
+// the lines both changed differently as a conflict
+func merge3(base, local, generated []string) (string, bool) {
+	toLocal, toGenerated := matchLines(base, local), matchLines(base, generated)
+	var out strings.Builder
+	conflict := false
+	b, l, g := 0, 0, 0
+	for b <= len(base) {
+		// next base line kept by both
+		next := b
+		for next < len(base) && (toLocal[next] < 0 || toGenerated[next] < 0) {
+			next++
+		}
+		endLocal, endGenerated := len(local), len(generated)
+		if next < len(base) {
+			endLocal, endGenerated = toLocal[next], toGenerated[next]
+		}
+		baseChunk, localChunk, generatedChunk := base[b:next], local[l:endLocal], generated[g:endGenerated]
+		switch {
+		case equalLines(localChunk, baseChunk):
+			writeLines(&out, generatedChunk)
+		case equalLines(generatedChunk, baseChunk), equalLines(localChunk, generatedChunk):
+			writeLines(&out, localChunk)
+		default:
+			conflict = true
+			out.WriteString(conflictLocal + "\n")
+			writeLines(&out, terminated(localChunk))
+			out.WriteString(conflictSeparator + "\n")
+			writeLines(&out, terminated(generatedChunk))
+			out.WriteString(conflictGenerated + "\n")
+		}
+		if next == len(base) {
+			break
+		}
+		out.WriteString(base[next])
+		b, l, g = next+1, endLocal+1, endGenerated+1
+	}
+	return out.String(), conflict
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
+
+// terminated returns lines, the last ending with a newline to be followed
+// by a conflict marker
+func terminated(lines []string) []string {
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines = append(lines[:n-1:n-1], lines[n-1]+"\n")
+	}
+	return lines
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package samples
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestSamplesUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "samples")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	templates, out := filepath.Join(dir, "templates"), filepath.Join(dir, "out")
+
+	writeFiles := func(dir string, files map[string]string) {
+		for name, content := range files {
+			file := filepath.Join(dir, name)
+			if content == "" {
+				if err := os.Remove(file); err != nil {
+					t.Fatal(err)
+				}
+				continue
+			}
+			if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := os.Mkdir(templates, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(templates, map[string]string{
+		"merged.yaml":    "org: {{.Org}}\none\ntwo\nthree\nfour\n",
+		"updated.yaml":   "old\n",
+		"kept.yaml":      "generated\n",
+		"same.yaml":      "same\n",
+		"conflict.yaml":  "a\nline\nb",
+		"obsolete.yaml":  "obsolete\n",
+		"untracked.yaml": "old\n",
+	})
+
+	print := testutil.Printer("TestSamplesUpdate")
+	if err := runSamples(print, "--template-dir", templates, "--out", out, "-o", "myorg", "-e", "test", "-r", "https://runtime.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(out, generatedDir, "merged.yaml")); got != "org: myorg\none\ntwo\nthree\nfour\n" {
+		t.Errorf("want the generated file kept, got:\n%s", got)
+	}
+	// generated before the files generated were kept
+	if err := os.Remove(filepath.Join(out, generatedDir, "untracked.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	writeFiles(out, map[string]string{
+		"merged.yaml":    "org: myorg\none\nTWO\nthree\nfour\n",
+		"kept.yaml":      "edited\n",
+		"conflict.yaml":  "a\nlocal\nb",
+		"untracked.yaml": "old\nlocal\n",
+	})
+	writeFiles(templates, map[string]string{
+		"merged.yaml":    "org: {{.Org}}\none\ntwo\nthree\nFOUR\n",
+		"updated.yaml":   "new\n",
+		"conflict.yaml":  "a\ngenerated\nb",
+		"added.yaml":     "added\n",
+		"obsolete.yaml":  "",
+		"untracked.yaml": "new\n",
+	})
+
+	print = testutil.Printer("TestSamplesUpdate")
+	err = runSamples(print, "--template-dir", templates, "--out", out, "-o", "myorg", "-e", "test", "-r", "https://runtime.example.com", "--update")
+	testutil.ErrorContains(t, err, `resolve the conflicts marked by "<<<<<<< local" and ">>>>>>> generated" in `+
+		filepath.Join(out, "conflict.yaml")+", "+filepath.Join(out, "untracked.yaml"))
+	print.Check(t, []string{
+		"added " + filepath.Join(out, "added.yaml"),
+		"conflict " + filepath.Join(out, "conflict.yaml") + ", local edits and the samples changed the same lines",
+		"kept " + filepath.Join(out, "kept.yaml") + ", edited locally and unchanged by the samples",
+		"merged " + filepath.Join(out, "merged.yaml") + ", keeping the local edits",
+		"conflict " + filepath.Join(out, "untracked.yaml") + ", local edits and the samples changed the same lines",
+		"updated " + filepath.Join(out, "updated.yaml"),
+		"no longer generated " + filepath.Join(out, "obsolete.yaml") + ", kept",
+		"1 added, 1 updated, 1 merged, 2 conflict, 1 kept, 1 unchanged",
+	})
+
+	for name, want := range map[string]string{
+		"merged.yaml":    "org: myorg\none\nTWO\nthree\nFOUR\n",
+		"updated.yaml":   "new\n",
+		"kept.yaml":      "edited\n",
+		"conflict.yaml":  "a\n<<<<<<< local\nlocal\n=======\ngenerated\n>>>>>>> generated\nb",
+		"untracked.yaml": "<<<<<<< local\nold\nlocal\n=======\nnew\n>>>>>>> generated\n",
+		"obsolete.yaml":  "obsolete\n",
+		"added.yaml":     "added\n",
+	} {
+		if got := readFile(t, filepath.Join(out, name)); got != want {
+			t.Errorf("%s: want:\n%s\ngot:\n%s", name, want, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, generatedDir, "obsolete.yaml")); !os.IsNotExist(err) {
+		t.Errorf("want the generated obsolete.yaml removed, got %v", err)
+	}
+
+	// the conflicts resolved, nothing changes
+	writeFiles(out, map[string]string{
+		"conflict.yaml":  "a\ngenerated\nb",
+		"untracked.yaml": "new\n",
+	})
+	print = testutil.Printer("TestSamplesUpdate")
+	if err := runSamples(print, "--template-dir", templates, "--out", out, "-o", "myorg", "-e", "test", "-r", "https://runtime.example.com", "--update"); err != nil {
+		t.Fatal(err)
+	}
+	print.Check(t, []string{
+		"kept " + filepath.Join(out, "kept.yaml") + ", edited locally and unchanged by the samples",
+		"kept " + filepath.Join(out, "merged.yaml") + ", edited locally and unchanged by the samples",
+		"2 kept, 5 unchanged",
+	})
+
+	err = runSamples(print, "--template-dir", templates, "--out", out, "--update", "-f")
+	testutil.ErrorContains(t, err, "--update and --force are exclusive")
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd/bindings"
+	"github.com/apigee/apigee-remote-service-cli/cmd/provision"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/spf13/cobra"
+)
+
+// schemas of the files the CLI writes and reads, by name
+var schemas = map[string]func() shared.JSONSchema{
+	"config":   provision.ConfigSchema,
+	"secret":   provision.SecretSchema,
+	"manifest": provision.ManifestSchema,
+	"bindings": bindings.FileSchema,
+}
+
+// Cmd returns base command, it takes no Apigee flags
+func Cmd(printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "schema",
+		Short: "JSON Schemas of the files the CLI generates",
+		Long: `JSON Schemas of the files the CLI generates, to validate hand-edited files in
+editors and CI before passing them to the CLI.`,
+	}
+
+	c.AddCommand(cmdPrint(printf))
+
+	return c
+}
+
+func cmdPrint(printf shared.FormatFn) *cobra.Command {
+	names := schemaNames()
+	return &cobra.Command{
+		Use:   fmt.Sprintf("print [%s]", strings.Join(names, "|")),
+		Short: "Print the JSON Schema of a file format",
+		Long: `Print the JSON Schema of a file format:
+  config    the adapter config (config.yaml of the ConfigMap) emitted by provision
+  secret    the policy secret emitted by provision
+  manifest  the manifest.json of a support bundle
+  bindings  the file of bindings export, import and apply`,
+		Args:      cobra.ExactValidArgs(1),
+		ValidArgs: names,
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return printSchema(args[0], printf)
+		},
+	}
+}
+
+func printSchema(name string, printf shared.FormatFn) error {
+	schema, ok := schemas[name]
+	if !ok {
+		return fmt.Errorf("no schema %q, must be one of %s", name, strings.Join(schemaNames(), ", "))
+	}
+	out, err := json.MarshalIndent(schema(), "", "  ")
+	if err != nil {
+		return err
+	}
+	printf("%s", out)
+	return nil
+}
+
+func schemaNames() []string {
+	var names []string
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
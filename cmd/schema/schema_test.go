@@ -0,0 +1,56 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestPrint(t *testing.T) {
+	for name, want := range map[string]string{
+		"config":   "tenant",
+		"secret":   "data",
+		"manifest": "files",
+		"bindings": "bindings",
+	} {
+		print := testutil.Printer("TestPrint")
+		rootCmd := cmd.GetRootCmd([]string{"schema", "print", name}, print.Printf)
+		rootCmd.AddCommand(Cmd(print.Printf))
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("%s: want no error: %v", name, err)
+		}
+		var schema struct {
+			Schema     string                     `json:"$schema"`
+			Properties map[string]json.RawMessage `json:"properties"`
+		}
+		if err := json.Unmarshal([]byte(print.Prints[0]), &schema); err != nil {
+			t.Fatal(err)
+		}
+		if schema.Schema != shared.JSONSchemaDraft {
+			t.Errorf("%s: want $schema %s, got %q", name, shared.JSONSchemaDraft, schema.Schema)
+		}
+		if _, ok := schema.Properties[want]; !ok {
+			t.Errorf("%s: want property %s, got %v", name, want, schema.Properties)
+		}
+	}
+
+	testutil.ErrorContains(t, printSchema("crd", testutil.Printer("TestPrint").Printf),
+		`no schema "crd", must be one of bindings, config, manifest, secret`)
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serve
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+)
+
+const (
+	logFormatText = "text"
+	logFormatGCP  = "gcp"
+
+	severityInfo   = "INFO"
+	severityNotice = "NOTICE"
+	severityError  = "ERROR"
+
+	auditSuccess = "success"
+	auditFailure = "failure"
+)
+
+// logger writes the entries of serve as text or, with --log-format gcp, as
+// the structured JSON the logging agent of GKE forwards to Cloud Logging
+type logger struct {
+	format    string
+	project   string // traces are only linked given the project
+	labels    map[string]string
+	printf    shared.FormatFn
+	errPrintf shared.FormatFn // text only
+	now       func() time.Time
+	trace     string // ID of the current rotation
+}
+
+// logEntry is a structured entry, see
+// https://cloud.google.com/logging/docs/structured-logging#special-payload-fields
+type logEntry struct {
+	Severity string            `json:"severity"`
+	Message  string            `json:"message"`
+	Time     string            `json:"time"`
+	Trace    string            `json:"logging.googleapis.com/trace,omitempty"`
+	Labels   map[string]string `json:"logging.googleapis.com/labels,omitempty"`
+	Audit    *auditEntry       `json:"audit,omitempty"`
+}
+
+// auditEntry records a change made to the organization
+type auditEntry struct {
+	Action   string `json:"action"`
+	Resource string `json:"resource"`
+	Outcome  string `json:"outcome"`
+	KeyID    string `json:"keyId,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// logger returns the logger of --log-format, text writing to printf if unset
+func (s *serve) logger(printf shared.FormatFn) *logger {
+	if s.log != nil {
+		return s.log
+	}
+	return &logger{format: logFormatText, printf: printf, errPrintf: shared.Errorf, now: s.now}
+}
+
+// newLogger returns the logger of the flags, labeling entries with the
+// organization and environment
+func (s *serve) newLogger(printf shared.FormatFn) (*logger, error) {
+	if s.logFormat != logFormatText && s.logFormat != logFormatGCP {
+		return nil, fmt.Errorf("--log-format must be %s or %s", logFormatText, logFormatGCP)
+	}
+	labels := map[string]string{
+		"organization": s.Org,
+		"environment":  s.Env,
+	}
+	for k, v := range s.logLabels {
+		labels[k] = v
+	}
+	return &logger{
+		format:    s.logFormat,
+		project:   s.gcpProject,
+		labels:    labels,
+		printf:    printf,
+		errPrintf: shared.Errorf,
+		now:       s.now,
+	}, nil
+}
+
+func (l *logger) infof(format string, args ...interface{}) {
+	if l.format == logFormatText {
+		l.printf(format, args...)
+		return
+	}
+	l.write(logEntry{Severity: severityInfo, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *logger) errorf(format string, args ...interface{}) {
+	if l.format == logFormatText {
+		l.errPrintf(format, args...)
+		return
+	}
+	l.write(logEntry{Severity: severityError, Message: fmt.Sprintf(format, args...)})
+}
+
+// audit records a change attempted, text output has its result already
+func (l *logger) audit(entry auditEntry, err error) {
+	if l.format == logFormatText {
+		return
+	}
+	severity := severityNotice
+	entry.Outcome = auditSuccess
+	if err != nil {
+		severity, entry.Outcome, entry.Error = severityError, auditFailure, err.Error()
+	}
+	l.write(logEntry{
+		Severity: severity,
+		Message:  fmt.Sprintf("audit: %s %s: %s", entry.Action, entry.Resource, entry.Outcome),
+		Audit:    &entry,
+	})
+}
+
+// startTrace links the following entries until the next call
+func (l *logger) startTrace() {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		l.trace = ""
+		return
+	}
+	l.trace = hex.EncodeToString(id)
+}
+
+func (l *logger) write(e logEntry) {
+	e.Time = l.now().UTC().Format(time.RFC3339Nano)
+	e.Labels = l.labels
+	if l.project != "" && l.trace != "" {
+		e.Trace = fmt.Sprintf("projects/%s/traces/%s", l.project, l.trace)
+	}
+	data, err := json.Marshal(e)
+	if err != nil { // not expected of strings
+		data = []byte(fmt.Sprintf(`{"severity":%q,"message":%q}`, severityError, err.Error()))
+	}
+	l.printf("%s", data)
+}
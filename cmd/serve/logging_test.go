@@ -0,0 +1,101 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serve
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestServeLogGCP(t *testing.T) {
+	now := time.Now()
+	ts := httptest.NewServer(rotateHandler(t, []string{now.Add(-time.Minute).Format(time.RFC3339)}, func([]string) {}))
+	defer ts.Close()
+
+	s := &serve{
+		RootArgs: &shared.RootArgs{
+			RuntimeBase:  ts.URL,
+			IsLegacySaaS: true,
+			Org:          "org",
+			Env:          "env",
+		},
+		clientID:     "key",
+		clientSecret: "secret",
+		gracePeriod:  time.Hour,
+		logFormat:    logFormatGCP,
+		gcpProject:   "proj",
+		logLabels:    map[string]string{"cluster": "prod"},
+		now:          func() time.Time { return now },
+	}
+	if err := s.Resolve(true, false); err != nil {
+		t.Fatal(err)
+	}
+	s.RemoteServiceProxyURL = ts.URL + "/remote-service"
+
+	print := testutil.Printer("TestServeLogGCP")
+	var err error
+	if s.log, err = s.newLogger(print.Printf); err != nil {
+		t.Fatal(err)
+	}
+	s.log.startTrace()
+	if err := s.rotate(s.log.infof); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	s.clientSecret = "bad"
+	if err := s.rotate(s.log.infof); err == nil {
+		t.Errorf("want error")
+	}
+
+	var entries []logEntry
+	for _, p := range print.Prints {
+		var e logEntry
+		if err := json.Unmarshal([]byte(p), &e); err != nil {
+			t.Fatalf("want JSON entry, got %s: %v", p, err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("want audit, info, audit entries, got %v", print.Prints)
+	}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Trace, "projects/proj/traces/") {
+			t.Errorf("want trace of proj, got %q", e.Trace)
+		}
+		if e.Labels["organization"] != "org" || e.Labels["environment"] != "env" || e.Labels["cluster"] != "prod" {
+			t.Errorf("want labels, got %v", e.Labels)
+		}
+	}
+	if e := entries[0]; e.Severity != severityNotice || e.Audit == nil || e.Audit.Outcome != auditSuccess ||
+		e.Audit.Resource != "organizations/org/environments/env/remote-service" || e.Audit.KeyID == "" {
+		t.Errorf("want successful audit entry, got %#v", e)
+	}
+	if e := entries[1]; e.Severity != severityInfo || !strings.HasPrefix(e.Message, "keys rotated") {
+		t.Errorf("want info entry, got %#v", e)
+	}
+	if e := entries[2]; e.Severity != severityError || e.Audit == nil || e.Audit.Outcome != auditFailure ||
+		!strings.Contains(e.Audit.Error, "authentication failed") {
+		t.Errorf("want failed audit entry, got %#v", e)
+	}
+
+	s.logFormat = "xml"
+	_, err = s.newLogger(print.Printf)
+	testutil.ErrorContains(t, err, "--log-format must be text or gcp")
+}
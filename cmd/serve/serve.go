@@ -0,0 +1,288 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serve
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/k8s"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	certsURLFormat = "%s/certs" // RemoteServiceProxyURL
+)
+
+type serve struct {
+	*shared.RootArgs
+	clientID       string
+	clientSecret   string
+	rotateSchedule string
+	gracePeriod    time.Duration
+	logFormat      string
+	gcpProject     string
+	logLabels      map[string]string
+	kubeconfig     string
+	kubeContext    string
+
+	cluster *k8s.Cluster // of the policy Secret, hybrid and Apigee X
+	log     *logger
+	now     func() time.Time
+}
+
+// Cmd returns base command
+func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	s := &serve{RootArgs: rootArgs, now: time.Now}
+
+	c := &cobra.Command{
+		Use:   "serve",
+		Short: "Run scheduled maintenance of Apigee Remote Service",
+		Long: `The serve command runs until interrupted, performing scheduled maintenance tasks such as
+rotating the remote-service signing keys. With legacy or opdk the keys are posted to the
+remote-service proxy. With hybrid or Apigee X the policy Secret (<org>-<env>-policy-secret
+of --namespace) is updated in the cluster of --kubeconfig and --context, the adapter signs
+with the new key once its pods restart, superseded keys are kept for --grace-period.
+In GKE, --log-format gcp writes the structured entries of Cloud Logging, with an audit
+entry for each rotation.`,
+		Args: cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return rootArgs.Resolve(true, true)
+		},
+
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if s.rotateSchedule == "" {
+				return fmt.Errorf("nothing to do, specify --rotate-schedule")
+			}
+			if s.IsGCPManaged {
+				var err error
+				if s.cluster, err = k8s.LoadCluster(s.kubeconfig, s.kubeContext); err != nil {
+					return err
+				}
+			} else if err := s.validateLegacy(cmd); err != nil {
+				return err
+			}
+
+			sched, err := parseSchedule(s.rotateSchedule)
+			if err != nil {
+				return err
+			}
+			if s.log, err = s.newLogger(printf); err != nil {
+				return err
+			}
+
+			stop := make(chan struct{})
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+			defer signal.Stop(sigs)
+			go func() {
+				<-sigs
+				close(stop)
+			}()
+
+			s.run(sched, stop, printf)
+			return nil
+		},
+	}
+
+	rootArgs.AddKeyPolicyFlags(c)
+	c.Flags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.Flags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+
+	c.Flags().StringVarP(&s.clientID, "key", "k", "", "provision key")
+	c.Flags().StringVarP(&s.clientSecret, "secret", "s", "", "provision secret")
+
+	c.Flags().StringVarP(&s.rotateSchedule, "rotate-schedule", "", "",
+		`key rotation schedule as a cron expression, descriptor (eg. "@weekly") or "@every <duration>"`)
+	c.Flags().DurationVarP(&s.gracePeriod, "grace-period", "", 24*time.Hour,
+		"time to retain superseded public keys after rotation")
+	c.Flags().StringVarP(&s.logFormat, "log-format", "", logFormatText,
+		`format of the log, "text" or "gcp" for the structured JSON of Cloud Logging (eg. in GKE)`)
+	c.Flags().StringVarP(&s.gcpProject, "gcp-project", "", os.Getenv("GOOGLE_CLOUD_PROJECT"),
+		"GCP project of Cloud Logging traces, with --log-format gcp (default $GOOGLE_CLOUD_PROJECT)")
+	c.Flags().StringToStringVarP(&s.logLabels, "log-label", "", nil,
+		"label added to log entries with --log-format gcp (eg. cluster=prod), may be repeated")
+	c.Flags().StringVarP(&rootArgs.Namespace, "namespace", "n", "apigee",
+		"namespace of the policy Secret (hybrid or Apigee X)")
+	c.Flags().StringVarP(&s.kubeconfig, "kubeconfig", "", "",
+		"kubeconfig of the cluster of the policy Secret, hybrid or Apigee X (default: the one of kubectl)")
+	c.Flags().StringVarP(&s.kubeContext, "context", "", "",
+		"kubeconfig context of the cluster of the policy Secret, hybrid or Apigee X (default: the current context)")
+
+	return c
+}
+
+// validateLegacy checks the flags of rotating the keys of legacy or opdk
+func (s *serve) validateLegacy(cmd *cobra.Command) error {
+	if cmd.Flags().Changed("kubeconfig") || cmd.Flags().Changed("context") {
+		return fmt.Errorf("--kubeconfig and --context only valid for hybrid or Apigee X")
+	}
+	if s.ServerConfig != nil {
+		s.clientID = s.ServerConfig.Tenant.Key
+		s.clientSecret = s.ServerConfig.Tenant.Secret
+	}
+	missingFlagNames := []string{}
+	if s.clientID == "" {
+		missingFlagNames = append(missingFlagNames, "key")
+	}
+	if s.clientSecret == "" {
+		missingFlagNames = append(missingFlagNames, "secret")
+	}
+	return s.PrintMissingFlags(missingFlagNames)
+}
+
+// run rotates keys per the schedule until stop is closed,
+// failed rotations are reported and retried at the next activation
+func (s *serve) run(sched schedule, stop <-chan struct{}, printf shared.FormatFn) {
+	log := s.logger(printf)
+	for {
+		next := sched.Next(s.now())
+		log.infof("next key rotation at %s", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(next.Sub(s.now()))
+		select {
+		case <-stop:
+			timer.Stop()
+			log.infof("stopped")
+			return
+		case <-timer.C:
+			log.startTrace()
+			if err := s.rotate(log.infof); err != nil {
+				log.errorf("key rotation failed: %v", err)
+			}
+		}
+	}
+}
+
+// rotate creates a new key and posts it, or updates the policy Secret with
+// it, along with the existing keys that are still within the grace period
+func (s *serve) rotate(printf shared.FormatFn) error {
+	verbosef := s.Stepf()
+
+	kid, privateKey, jwks, err := s.CreateNewKey()
+	if err != nil {
+		return errors.Wrap(err, "generating key")
+	}
+
+	var oldJWKS *jwk.Set
+	var secretData map[string][]byte
+	if s.IsGCPManaged {
+		if secretData, err = s.cluster.SecretData(s.Namespace, s.policySecretName()); err != nil {
+			return err
+		}
+		if oldJWKS, err = jwk.ParseBytes(secretData[server.SecretJKWSKey]); err != nil {
+			return errors.Wrapf(err, "parsing %s of Secret %s", server.SecretJKWSKey, s.policySecretName())
+		}
+	} else {
+		certsURL := fmt.Sprintf(certsURLFormat, s.RemoteServiceProxyURL)
+		if oldJWKS, err = jwk.FetchHTTP(certsURL, jwk.WithHTTPClient(s.RuntimeClient())); err != nil {
+			return errors.Wrapf(err, "retrieving JWKs from: %s", certsURL)
+		}
+	}
+	jwks.Keys = append(jwks.Keys, oldJWKS.Keys...)
+	jwks = shared.RetainJWKS(jwks, s.gracePeriod, s.now())
+	if err := s.KeyPolicy.CheckJWKS(jwks, s.now()); err != nil {
+		return err
+	}
+
+	jwksBytes, err := json.Marshal(jwks)
+	if err != nil {
+		return err
+	}
+	verbosef("new jwks:\n%s", string(jwksBytes))
+
+	keyBytes := pem.EncodeToMemory(&pem.Block{Type: server.PEMKeyType,
+		Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	resource := fmt.Sprintf("organizations/%s/environments/%s/remote-service", s.Org, s.Env)
+	if s.IsGCPManaged {
+		resource = fmt.Sprintf("namespaces/%s/secrets/%s", s.Namespace, s.policySecretName())
+		err = s.applyPolicySecret(secretData, kid, keyBytes, jwksBytes)
+	} else {
+		err = s.PostRotate(s.clientID, s.clientSecret, shared.RotateRequest{
+			PrivateKey: string(keyBytes),
+			JWKS:       string(jwksBytes),
+			KeyID:      kid,
+		})
+	}
+	s.logger(printf).audit(auditEntry{
+		Action:   "keys.rotate",
+		Resource: resource,
+		KeyID:    kid,
+	}, err)
+	if err != nil {
+		return err
+	}
+
+	printf("keys rotated, new key id: %s, retained %d public key(s)", kid, len(jwks.Keys))
+	return nil
+}
+
+// applyPolicySecret updates the policy Secret with the new key and JWKS,
+// keeping the other properties of its data, eg. the claims of provision
+func (s *serve) applyPolicySecret(data map[string][]byte, kid string, keyBytes, jwksBytes []byte) error {
+	props := map[string]string{}
+	if propsBytes, ok := data[server.SecretPropsKey]; ok {
+		var err error
+		if props, err = server.ReadProperties(bytes.NewReader(propsBytes)); err != nil {
+			return errors.Wrapf(err, "parsing %s of Secret %s", server.SecretPropsKey, s.policySecretName())
+		}
+	}
+	props[server.SecretPropsKIDKey] = kid
+	propsBuf := new(bytes.Buffer)
+	if err := server.WriteProperties(propsBuf, props); err != nil {
+		return err
+	}
+
+	secret := &server.SecretCRD{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Type:       "Opaque",
+		Metadata: server.Metadata{
+			Name:      s.policySecretName(),
+			Namespace: s.Namespace,
+		},
+		Data: map[string]string{
+			server.SecretJKWSKey:    base64.StdEncoding.EncodeToString(jwksBytes),
+			server.SecretPrivateKey: base64.StdEncoding.EncodeToString(keyBytes),
+			server.SecretPropsKey:   base64.StdEncoding.EncodeToString(propsBuf.Bytes()),
+		},
+	}
+	secretYAML, err := yaml.Marshal(secret)
+	if err != nil {
+		return err
+	}
+	_, _, err = s.cluster.Apply(secretYAML, k8s.ApplyOptions{Namespace: s.Namespace})
+	return err
+}
+
+func (s *serve) policySecretName() string {
+	return fmt.Sprintf(shared.PolicySecretNameFormat, s.Org, s.Env)
+}
@@ -0,0 +1,408 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package serve
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/k8s"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseSchedule(t *testing.T) {
+	base := time.Date(2020, 7, 31, 10, 15, 30, 0, time.UTC) // a Friday
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"@every 90m", base.Add(90 * time.Minute)},
+		{"@hourly", time.Date(2020, 7, 31, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2020, 8, 2, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2020, 7, 31, 10, 20, 0, 0, time.UTC)},
+		{"0 3 * * 1-5", time.Date(2020, 8, 3, 3, 0, 0, 0, time.UTC)},
+		{"0 3 * * 7", time.Date(2020, 8, 2, 3, 0, 0, 0, time.UTC)},
+		{"15,45 10 31 * *", time.Date(2020, 7, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 5", time.Date(2020, 8, 1, 0, 0, 0, 0, time.UTC)}, // day of month OR day of week
+	}
+	for _, tc := range tests {
+		s, err := parseSchedule(tc.spec)
+		if err != nil {
+			t.Errorf("%s: want no error, got %v", tc.spec, err)
+			continue
+		}
+		if got := s.Next(base); !got.Equal(tc.want) {
+			t.Errorf("%s: want %s, got %s", tc.spec, tc.want, got)
+		}
+	}
+
+	errs := map[string]string{
+		"@every":        "want 5 fields",
+		"@every x":      "invalid duration",
+		"@every -1h":    "duration must be positive",
+		"* * * *":       "want 5 fields, got 4",
+		"60 * * * *":    "minute",
+		"* 24 * * *":    "hour",
+		"* * 0 * *":     "day of month",
+		"* * * 13 *":    "month",
+		"* * * * 8":     "day of week",
+		"*/0 * * * *":   "bad step",
+		"a * * * *":     "bad value",
+		"5-1 * * * *":   "out of range",
+		"0 0 30 2 *":    "never activates",
+		"@fortnightly":  "want 5 fields",
+		"1-x * * * *":   "bad value",
+		"0 0 31 4,6 * ": "never activates",
+	}
+	for spec, want := range errs {
+		_, err := parseSchedule(spec)
+		testutil.ErrorContains(t, err, want)
+	}
+}
+
+func TestServeRotate(t *testing.T) {
+	now := time.Now()
+	grace := time.Hour
+
+	// newest first: within grace, superseded within grace, superseded beyond grace
+	kids := []string{
+		now.Add(-30 * time.Minute).Format(time.RFC3339),
+		now.Add(-2 * time.Hour).Format(time.RFC3339),
+		now.Add(-4 * time.Hour).Format(time.RFC3339),
+	}
+
+	var mu sync.Mutex
+	var rotated []string
+	ts := httptest.NewServer(rotateHandler(t, kids, func(got []string) {
+		mu.Lock()
+		defer mu.Unlock()
+		rotated = got
+	}))
+	defer ts.Close()
+
+	s := &serve{
+		RootArgs: &shared.RootArgs{
+			RuntimeBase:  ts.URL,
+			IsLegacySaaS: true,
+			Org:          "org",
+			Env:          "env",
+		},
+		clientID:     "key",
+		clientSecret: "secret",
+		gracePeriod:  grace,
+		now:          func() time.Time { return now },
+	}
+	if err := s.Resolve(true, false); err != nil {
+		t.Fatal(err)
+	}
+	s.RemoteServiceProxyURL = ts.URL + "/remote-service"
+
+	print := testutil.Printer("TestServeRotate")
+	if err := s.rotate(print.Printf); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+
+	// the new key (kid is current time) plus two keys within grace
+	if len(rotated) != 3 {
+		t.Fatalf("want 3 keys, got %v", rotated)
+	}
+	if rotated[1] != kids[0] || rotated[2] != kids[1] {
+		t.Errorf("want retained keys %v, got %v", kids[:2], rotated[1:])
+	}
+	print.CheckPrefix(t, []string{"keys rotated, new key id:"})
+
+	// bad credentials
+	s.clientSecret = "bad"
+	err := s.rotate(print.Printf)
+	testutil.ErrorContains(t, err, "authentication failed, check your key and secret")
+
+	// continues after failure until stopped
+	s.clientSecret = "secret"
+	s.RemoteServiceProxyURL = ts.URL + "/bad"
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		s.run(everySchedule(10*time.Millisecond), stop, print.Printf)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(stop)
+	<-done
+
+	if got := print.Prints[len(print.Prints)-1]; got != "stopped" {
+		t.Errorf("want stopped, got %s", got)
+	}
+}
+
+func TestServeCmd(t *testing.T) {
+	print := testutil.Printer("TestServeCmd")
+
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"serve", "-o", "org", "-e", "env", "--legacy"}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	testutil.ErrorContains(t, rootCmd.Execute(), "nothing to do, specify --rotate-schedule")
+
+	dir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeconfig, []byte("contexts: []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"serve", "-r", "https://runtime", "--rotate-schedule", "@daily", "--kubeconfig", kubeconfig}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	testutil.ErrorContains(t, rootCmd.Execute(), "kubeconfig "+kubeconfig+" has no current context, use --context")
+
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"serve", "-o", "org", "-e", "env", "--legacy", "--rotate-schedule", "@daily", "--context", "dev"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	testutil.ErrorContains(t, rootCmd.Execute(), "--kubeconfig and --context only valid for hybrid or Apigee X")
+
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"serve", "-o", "org", "-e", "env", "--legacy", "--rotate-schedule", "@daily"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	testutil.ErrorContains(t, rootCmd.Execute(), `required flag(s) "key", "secret" not set`)
+
+	rootArgs = &shared.RootArgs{}
+	flags = []string{"serve", "-o", "org", "-e", "env", "--legacy", "--rotate-schedule", "@sometimes", "-k", "k", "-s", "s"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	testutil.ErrorContains(t, rootCmd.Execute(), `invalid schedule "@sometimes"`)
+}
+
+func TestServeRotateSecret(t *testing.T) {
+	now := time.Now()
+	kids := []string{
+		now.Add(-30 * time.Minute).Format(time.RFC3339),
+		now.Add(-2 * time.Hour).Format(time.RFC3339),
+		now.Add(-4 * time.Hour).Format(time.RFC3339),
+	}
+	var keys []interface{}
+	for _, kid := range kids {
+		keys = append(keys, generateJWK(t, kid))
+	}
+	jwksBytes, err := json.Marshal(map[string]interface{}{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const secretPath = "/api/v1/namespaces/ns/secrets/org-env-policy-secret"
+	var mu sync.Mutex
+	var applied map[string]interface{}
+	ts := httptest.NewTLSServer(testutil.KubeDiscovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != secretPath {
+			t.Errorf("Unknown route %s %s hit", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"kind":       "Secret",
+				"apiVersion": "v1",
+				"metadata":   map[string]interface{}{"name": "org-env-policy-secret", "namespace": "ns"},
+				"data": map[string][]byte{
+					server.SecretJKWSKey:    jwksBytes,
+					server.SecretPrivateKey: []byte("old"),
+					server.SecretPropsKey:   []byte(server.SecretPropsKIDKey + "=" + kids[0] + "\nclaim=value\n"),
+				},
+			})
+		case http.MethodPatch:
+			body, _ := ioutil.ReadAll(r.Body)
+			doc := map[string]interface{}{}
+			if err := yaml.Unmarshal(body, &doc); err != nil {
+				t.Errorf("bad apply body: %v", err)
+			}
+			mu.Lock()
+			applied = doc
+			mu.Unlock()
+			_ = json.NewEncoder(w).Encode(doc)
+		}
+	})))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeconfig, []byte(`current-context: dev
+contexts:
+- name: dev
+  context: {cluster: dev, user: dev}
+clusters:
+- name: dev
+  cluster: {server: "`+ts.URL+`", insecure-skip-tls-verify: true}
+users:
+- name: dev
+  user: {token: t0ken}
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &serve{
+		RootArgs: &shared.RootArgs{
+			RuntimeBase: "https://runtime",
+			Org:         "org",
+			Env:         "env",
+			Namespace:   "ns",
+		},
+		gracePeriod: time.Hour,
+		now:         func() time.Time { return now },
+	}
+	if err := s.Resolve(true, false); err != nil {
+		t.Fatal(err)
+	}
+	if s.cluster, err = k8s.LoadCluster(kubeconfig, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	print := testutil.Printer("TestServeRotateSecret")
+	if err := s.rotate(print.Printf); err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	print.CheckPrefix(t, []string{"keys rotated, new key id:"})
+
+	data, _ := applied["data"].(map[string]interface{})
+	decode := func(key string) string {
+		value, _ := data[key].(string)
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			t.Errorf("bad %s: %v", key, err)
+		}
+		return string(b)
+	}
+	jwks, err := jwk.ParseString(decode(server.SecretJKWSKey))
+	if err != nil {
+		t.Fatalf("bad jwks: %v", err)
+	}
+	var got []string
+	for _, k := range jwks.Keys {
+		got = append(got, k.KeyID())
+	}
+	if len(got) != 3 || got[1] != kids[0] || got[2] != kids[1] {
+		t.Errorf("want the new key and %v, got %v", kids[:2], got)
+	}
+	if key := decode(server.SecretPrivateKey); !strings.Contains(key, "PRIVATE KEY") {
+		t.Errorf("want private key, got %s", key)
+	}
+	props, err := server.ReadProperties(strings.NewReader(decode(server.SecretPropsKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if props[server.SecretPropsKIDKey] != got[0] || props["claim"] != "value" {
+		t.Errorf("want kid %s and the claim retained, got %v", got[0], props)
+	}
+}
+
+func rotateHandler(t *testing.T, kids []string, rotated func([]string)) http.Handler {
+	m := http.NewServeMux()
+	m.HandleFunc("/remote-service/certs", func(w http.ResponseWriter, r *http.Request) {
+		var keys []interface{}
+		for _, kid := range kids {
+			keys = append(keys, generateJWK(t, kid))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys}); err != nil {
+			t.Fatal(err)
+		}
+	})
+	m.HandleFunc("/remote-service/rotate", func(w http.ResponseWriter, r *http.Request) {
+		if _, secret, _ := r.BasicAuth(); secret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req shared.RotateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Fatalf("error in rotate request %v", err)
+		}
+		if !strings.Contains(req.PrivateKey, "PRIVATE KEY") {
+			t.Errorf("rotate request has no private key")
+		}
+		jwks := &jwk.Set{}
+		if err := json.Unmarshal([]byte(req.JWKS), jwks); err != nil {
+			t.Fatalf("bad jwks in rotate request %v", err)
+		}
+		var got []string
+		for _, k := range jwks.Keys {
+			got = append(got, k.KeyID())
+		}
+		if got[0] != req.KeyID {
+			t.Errorf("want new key %s first, got %v", req.KeyID, got)
+		}
+		if !sort.IsSorted(sort.Reverse(sort.StringSlice(got))) {
+			t.Errorf("want keys sorted by kid, got %v", got)
+		}
+		rotated(got)
+		w.WriteHeader(http.StatusOK)
+	})
+	m.HandleFunc("/bad/", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	m.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Unknown route %s hit", r.URL.Path)
+	})
+	return m
+}
+
+func generateJWK(t *testing.T, kid string) jwk.Key {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.New(&privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := key.Set(jwk.KeyIDKey, kid); err != nil {
+		t.Fatal(err)
+	}
+	if err := key.Set(jwk.AlgorithmKey, jwa.RS256); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/lestrrat-go/jwx/jwt"
+	"github.com/pkg/errors"
+)
+
+// statuses of a token inspected in batch
+const (
+	statusValid         = "valid"
+	statusExpired       = "expired"
+	statusBadSignature  = "bad-signature"
+	statusInvalidClaims = "invalid-claims"
+	statusMalformed     = "malformed"
+)
+
+// maxBatchTokenSize is the longest line read as a token
+const maxBatchTokenSize = 1024 * 1024
+
+// batchResult is the verification result of a line of a batch
+type batchResult struct {
+	Line   int                    `json:"line"`
+	Status string                 `json:"status"`
+	Error  string                 `json:"error,omitempty"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// inspectBatch verifies the newline-delimited tokens of in and prints the
+// result of each as a line of JSON, the certs are fetched once
+func (t *token) inspectBatch(in io.Reader, printf shared.FormatFn) error {
+	file, err := t.tokenInput(in)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	url := fmt.Sprintf(certsURLFormat, t.RemoteServiceProxyURL)
+	jwkSet, err := jwk.FetchHTTP(url, jwk.WithHTTPClient(t.RuntimeClient()))
+	if err != nil {
+		return errors.Wrap(err, "fetching certs")
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchTokenSize)
+	line := 0
+	for scanner.Scan() {
+		line++
+		tokenString := strings.TrimSpace(scanner.Text())
+		tokenString = strings.TrimSpace(strings.TrimPrefix(tokenString, "Bearer "))
+		if tokenString == "" {
+			continue
+		}
+		result := t.verifyBatchToken([]byte(tokenString), jwkSet)
+		result.Line = line
+		buf, err := json.Marshal(result)
+		if err != nil {
+			return errors.Wrapf(err, "printing result of line %d", line)
+		}
+		printf("%s", buf)
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "reading tokens after line %d", line)
+	}
+	return nil
+}
+
+// verifyBatchToken decrypts, parses and verifies a token, the claims are
+// returned unless the token can't be parsed
+func (t *token) verifyBatchToken(data []byte, jwkSet *jwk.Set) batchResult {
+	failed := func(status string, err error) batchResult {
+		return batchResult{Status: status, Error: err.Error()}
+	}
+	signed := true
+	if isJWE(data) {
+		if t.decryptionKey == "" && t.decryptionJWKS == "" {
+			return failed(statusMalformed, fmt.Errorf("token is encrypted (JWE), use --decryption-key or --decryption-jwks"))
+		}
+		var err error
+		if data, err = t.decryptToken(data, func(string, ...interface{}) {}); err != nil {
+			return failed(statusMalformed, err)
+		}
+		signed = isJWS(data)
+	}
+
+	token := jwt.New()
+	if signed {
+		var err error
+		if token, err = jwt.ParseBytes(data); err != nil {
+			return failed(statusMalformed, errors.Wrap(err, "parsing jwt token"))
+		}
+	} else if err := json.Unmarshal(data, token); err != nil {
+		return failed(statusMalformed, errors.Wrap(err, "parsing jwt claims"))
+	}
+	claims, err := token.AsMap(context.Background())
+	if err != nil {
+		return failed(statusMalformed, errors.Wrap(err, "reading jwt claims"))
+	}
+
+	result := batchResult{Status: statusValid, Claims: claims}
+	if signed {
+		if _, err := jws.VerifyWithJWKSet(data, jwkSet, nil); err != nil {
+			result.Status, result.Error = statusBadSignature, err.Error()
+			return result
+		}
+	}
+	if err := jwt.Verify(token, jwt.WithAcceptableSkew(time.Minute)); err != nil {
+		result.Status, result.Error = statusInvalidClaims, err.Error()
+		if strings.HasPrefix(err.Error(), "exp ") {
+			result.Status = statusExpired
+		}
+	}
+	return result
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/lestrrat-go/jwx/jwt"
+)
+
+func TestTokenInspectBatch(t *testing.T) {
+	privateKey, key := generateJWK(t)
+	otherKey, _ := generateJWK(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&jwk.Set{Keys: []jwk.Key{key}})
+	}))
+	defer ts.Close()
+
+	valid, err := generateJWT(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badSignature, err := generateJWT(otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredToken := jwt.New()
+	if err := expiredToken.Set(jwt.ExpirationKey, time.Now().Add(-time.Hour).Unix()); err != nil {
+		t.Fatal(err)
+	}
+	expired, err := jwt.Sign(expiredToken, jwa.RS256, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := strings.Join([]string{valid, "", "Bearer " + badSignature, string(expired), "not a token"}, "\n")
+
+	print := testutil.Printer("TestTokenInspectBatch")
+	rootArgs := &shared.RootArgs{}
+	flags := []string{"token", "inspect", "--batch", "--runtime", ts.URL}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	rootCmd.SetIn(strings.NewReader(in))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+
+	want := []batchResult{
+		{Line: 1, Status: statusValid},
+		{Line: 3, Status: statusBadSignature},
+		{Line: 4, Status: statusExpired},
+		{Line: 5, Status: statusMalformed},
+	}
+	if len(print.Prints) != len(want) {
+		t.Fatalf("want %d results, got %v", len(want), print.Prints)
+	}
+	for i, line := range print.Prints {
+		var got batchResult
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("want a JSON result per line, got %q: %v", line, err)
+		}
+		if got.Line != want[i].Line || got.Status != want[i].Status {
+			t.Errorf("want line %d %s, got %s", want[i].Line, want[i].Status, line)
+		}
+		if got.Status != statusMalformed && got.Claims == nil {
+			t.Errorf("want claims of line %d, got %s", got.Line, line)
+		}
+	}
+	if !strings.Contains(print.Prints[0], `"client_id":"/clientid/"`) {
+		t.Errorf("want claims of the valid token, got %s", print.Prints[0])
+	}
+
+	flags = []string{"token", "inspect", "--batch", "--runtime", "dummy"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	rootCmd.SetIn(strings.NewReader(valid))
+	testutil.ErrorContains(t, rootCmd.Execute(), "inspecting token: fetching certs")
+}
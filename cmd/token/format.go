@@ -0,0 +1,85 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+)
+
+// formats of create --format
+const (
+	formatRaw    = "raw"
+	formatHeader = "header"
+	formatCurl   = "curl"
+	formatK6     = "k6"
+	formatVegeta = "vegeta"
+)
+
+var tokenFormats = []string{formatRaw, formatHeader, formatCurl, formatK6, formatVegeta}
+
+// defaultTargetURL is the Envoy of the envoy-bootstrap sample, routing to
+// httpbin.org echoing the headers
+const defaultTargetURL = "http://localhost:8080/headers"
+
+func validateFormat(format, target string) error {
+	known := false
+	for _, f := range tokenFormats {
+		known = known || f == format
+	}
+	if !known {
+		return fmt.Errorf("--format must be one of: %s", strings.Join(tokenFormats, ", "))
+	}
+	if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("--url %q must be an http or https URL", target)
+	}
+	return nil
+}
+
+// formatToken returns token as --format names it, calling target if a
+// command or load test
+func formatToken(format, token, target string) string {
+	header := "Authorization: Bearer " + token
+	switch format {
+	case formatHeader:
+		return header
+	case formatCurl:
+		return fmt.Sprintf("curl -i -H %s %s", shared.ShellQuote(header), shared.ShellQuote(target))
+	case formatK6:
+		return fmt.Sprintf(k6Script, jsString(target), jsString("Bearer "+token))
+	case formatVegeta:
+		return fmt.Sprintf("GET %s\n%s\n", target, header)
+	}
+	return token
+}
+
+// k6Script is a k6 load test of a URL with an authorization header
+const k6Script = `import http from 'k6/http';
+import { check } from 'k6';
+
+export default function () {
+  const res = http.get(%s, { headers: { Authorization: %s } });
+  check(res, { 'authorized': (r) => r.status !== 401 && r.status !== 403 });
+}`
+
+// jsString returns s as a JavaScript string literal
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
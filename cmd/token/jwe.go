@@ -0,0 +1,140 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/lestrrat-go/jwx/jwe"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+// isJWE reports whether a token is encrypted: JWE compact serialization has
+// five parts, JSON serialization a ciphertext
+func isJWE(data []byte) bool {
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '{' {
+		return bytes.Contains(data, []byte(`"ciphertext"`))
+	}
+	return bytes.Count(data, []byte{'.'}) == 4
+}
+
+// isJWS reports whether a token is in JWS compact serialization
+func isJWS(data []byte) bool {
+	return bytes.Count(bytes.TrimSpace(data), []byte{'.'}) == 2
+}
+
+// decryptToken decrypts a JWE with the key of --decryption-key or the keys of
+// --decryption-jwks and returns its payload
+func (t *token) decryptToken(data []byte, printf shared.FormatFn) ([]byte, error) {
+	msg, err := jwe.Parse(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing jwe token")
+	}
+	if len(msg.Recipients()) == 0 {
+		return nil, fmt.Errorf("jwe token has no recipients")
+	}
+	headers := msg.Recipients()[0].Headers()
+	alg, kid := headers.Algorithm(), headers.KeyID()
+	if protected := msg.ProtectedHeaders(); protected != nil {
+		if alg == "" {
+			alg = protected.Algorithm()
+		}
+		if kid == "" {
+			kid = protected.KeyID()
+		}
+	}
+
+	keys, err := t.decryptionKeys(kid)
+	if err != nil {
+		return nil, err
+	}
+	var errs error
+	for _, key := range keys {
+		payload, err := msg.Decrypt(alg, key)
+		if err == nil {
+			printf("decrypted jwe token (alg: %s, enc: %s)", alg, msg.ProtectedHeaders().ContentEncryption())
+			return payload, nil
+		}
+		errs = multierr.Append(errs, err)
+	}
+	return nil, errors.Wrap(errs, "decrypting jwe token")
+}
+
+// decryptionKeys returns the raw keys to try for a JWE with key ID kid
+func (t *token) decryptionKeys(kid string) ([]interface{}, error) {
+	if t.decryptionKey != "" {
+		key, err := readPrivateKey(t.decryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{key}, nil
+	}
+
+	data, err := ioutil.ReadFile(t.decryptionJWKS)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading jwks %s", t.decryptionJWKS)
+	}
+	set, err := jwk.ParseBytes(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing jwks %s", t.decryptionJWKS)
+	}
+	var keys []interface{}
+	for _, key := range set.Keys {
+		if kid != "" && key.KeyID() != kid {
+			continue
+		}
+		if use := key.KeyUsage(); use != "" && use != string(jwk.ForEncryption) {
+			continue
+		}
+		var raw interface{}
+		if err := key.Raw(&raw); err != nil {
+			return nil, errors.Wrapf(err, "reading key %s of jwks %s", key.KeyID(), t.decryptionJWKS)
+		}
+		keys = append(keys, raw)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no enc key with kid %q in jwks %s", kid, t.decryptionJWKS)
+	}
+	return keys, nil
+}
+
+// readPrivateKey reads a PEM encoded PKCS#1, PKCS#8 or EC private key
+func readPrivateKey(file string) (interface{}, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading key %s", file)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s is not PEM encoded", file)
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("key %s has unsupported PEM type %s", file, block.Type)
+}
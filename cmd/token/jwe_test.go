@@ -0,0 +1,135 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwe"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func TestTokenInspectJWE(t *testing.T) {
+	signingKey, key := generateJWK(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&jwk.Set{Keys: []jwk.Key{key}})
+	}))
+	defer ts.Close()
+
+	encKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "jwe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key.pem")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(encKey)})
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	encJWK, err := jwk.New(encKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encJWK.Set(jwk.KeyUsageKey, string(jwk.ForEncryption)); err != nil {
+		t.Fatal(err)
+	}
+	jwksFile := filepath.Join(dir, "jwks.json")
+	jwksBytes, err := json.Marshal(&jwk.Set{Keys: []jwk.Key{encJWK}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(jwksFile, jwksBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := generateJWT(signingKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypt := func(payload string) string {
+		encrypted, err := jwe.Encrypt([]byte(payload), jwa.RSA_OAEP, &encKey.PublicKey, jwa.A128GCM, jwa.NoCompress)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(encrypted)
+	}
+
+	run := func(in string, args ...string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestTokenInspectJWE")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"token", "inspect", "--runtime", ts.URL}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		rootCmd.SetIn(strings.NewReader(in))
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+
+	// nested signed token
+	for _, flag := range []string{"--decryption-key=" + keyFile, "--decryption-jwks=" + jwksFile} {
+		print, err := run(encrypt(signed), flag)
+		if err != nil {
+			t.Fatalf("%s: want no error: %v", flag, err)
+		}
+		if len(print.Prints) != 4 || print.Prints[0] != "decrypted jwe token (alg: RSA-OAEP, enc: A128GCM)" ||
+			!strings.Contains(print.Prints[1], `"client_id": "/clientid/"`) || print.Prints[3] != "valid token" {
+			t.Errorf("%s: unexpected output %v", flag, print.Prints)
+		}
+	}
+
+	// encrypted claims
+	print, err := run(encrypt(`{"iss":"idp","exp":1}`), "--decryption-key", keyFile)
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if len(print.Prints) != 5 || print.Prints[3] != "token is not signed, skipping signature verification" ||
+		!strings.HasPrefix(print.Prints[4], "invalid token: exp not satisfied") {
+		t.Errorf("unexpected output %v", print.Prints)
+	}
+
+	_, err = run(encrypt(signed))
+	testutil.ErrorContains(t, err, "token is encrypted (JWE), use --decryption-key or --decryption-jwks")
+	_, err = run(encrypt(signed), "--decryption-key", keyFile, "--decryption-jwks", jwksFile)
+	testutil.ErrorContains(t, err, "--decryption-key and --decryption-jwks are exclusive")
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(otherKey)})
+	if err := ioutil.WriteFile(keyFile, otherPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = run(encrypt(signed), "--decryption-key", keyFile)
+	testutil.ErrorContains(t, err, "decrypting jwe token")
+}
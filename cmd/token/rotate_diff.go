@@ -0,0 +1,113 @@
+// Copyright 2018 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+const (
+	keyAdded   = "+"
+	keyKept    = "="
+	keyRemoved = "-"
+)
+
+// keyChange is what a rotation does to a key ID of the served JWKS
+type keyChange struct {
+	kid    string
+	change string // keyAdded, keyKept or keyRemoved
+	age    string
+}
+
+// diffJWKS returns the key IDs added, kept and removed by replacing served
+// with rotated, newest first
+func diffJWKS(served, rotated *jwk.Set, now time.Time) []keyChange {
+	was := map[string]bool{}
+	for _, k := range served.Keys {
+		was[k.KeyID()] = true
+	}
+	is := map[string]bool{}
+	for _, k := range rotated.Keys {
+		is[k.KeyID()] = true
+	}
+
+	var changes []keyChange
+	for kid := range is {
+		change := keyAdded
+		if was[kid] {
+			change = keyKept
+		}
+		changes = append(changes, keyChange{kid: kid, change: change, age: keyAge(kid, now)})
+	}
+	for kid := range was {
+		if !is[kid] {
+			changes = append(changes, keyChange{kid: kid, change: keyRemoved, age: keyAge(kid, now)})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].kid > changes[j].kid })
+	return changes
+}
+
+// keyAge is the age of a key from its RFC3339 key ID, empty if it's not a time
+func keyAge(kid string, now time.Time) string {
+	created, err := time.Parse(time.RFC3339, kid)
+	if err != nil {
+		return ""
+	}
+	age := now.Sub(created)
+	if age < 0 {
+		age = 0
+	}
+	days := int(age / (24 * time.Hour))
+	hours := int(age % (24 * time.Hour) / time.Hour)
+	return fmt.Sprintf("%dd%dh", days, hours)
+}
+
+func printJWKSDiff(changes []keyChange, printf shared.FormatFn) {
+	counts := map[string]int{}
+	printf("jwks changes:")
+	for _, c := range changes {
+		counts[c.change]++
+		age := "age unknown"
+		if c.age != "" {
+			age = "age " + c.age
+		}
+		printf("  %s %s (%s)", c.change, c.kid, age)
+	}
+	printf("%d added, %d kept, %d removed", counts[keyAdded], counts[keyKept], counts[keyRemoved])
+}
+
+// confirm asks whether to proceed, anything but yes declines
+func confirm(in io.Reader, what string, printf shared.FormatFn) bool {
+	if shared.NonInteractive {
+		printf("%s? declined, --ci doesn't prompt (use --yes)", what)
+		return false
+	}
+	printf("%s? [y/N]", what)
+	scanner := bufio.NewScanner(in)
+	if !scanner.Scan() {
+		return false
+	}
+	answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+	return answer == "y" || answer == "yes"
+}
@@ -22,6 +22,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/apigee/apigee-remote-service-cli/shared"
@@ -35,19 +36,30 @@ import (
 )
 
 const (
-	tokenURLFormat         = "%s/token"  // RemoteServiceProxyURL
-	certsURLFormat         = "%s/certs"  // RemoteServiceProxyURL
-	rotateURLFormat        = "%s/rotate" // RemoteServiceProxyURL
+	tokenURLFormat         = "%s/token" // RemoteServiceProxyURL
+	certsURLFormat         = "%s/certs" // RemoteServiceProxyURL
 	clientCredentialsGrant = "client_credentials"
+
+	outputText = "text"
+	outputJSON = "json"
 )
 
 type token struct {
 	*shared.RootArgs
 	clientID            string
 	clientSecret        string
+	formParams          []string
 	file                string
+	decryptionKey       string
+	decryptionJWKS      string
+	batch               bool
 	truncate            int
 	internalJWTDuration time.Duration
+	showDiff            bool
+	yes                 bool
+	output              string
+	format              string // of create
+	targetURL           string // of create --format
 }
 
 // Cmd returns base command
@@ -80,21 +92,35 @@ func cmdCreateToken(t *token, printf shared.FormatFn) *cobra.Command {
 	c := &cobra.Command{
 		Use:   "create",
 		Short: "Create a new OAuth token",
-		Long:  "Create a new OAuth token",
-		Args:  cobra.NoArgs,
+		Long: `Create a new OAuth token. With --format, the token is printed as the next tool
+takes it: raw, an Authorization header, a curl command, a k6 script or a vegeta target
+calling --url, eg. the Envoy in front of the target.`,
+		Args: cobra.NoArgs,
 
 		RunE: func(cmd *cobra.Command, _ []string) error {
+			if err := validateFormat(t.format, t.targetURL); err != nil {
+				return err
+			}
+			defer t.PrintExplain(printf)
 			token, err := t.createToken(printf)
 			if err != nil {
 				return errors.Wrap(err, "creating token")
 			}
-			printf(token)
+			// no token is created with --explain
+			t.ExplainPrintf(printf)("%s", formatToken(t.format, token, t.targetURL))
 			return nil
 		},
 	}
+	t.AddExplainFlag(c)
 
 	c.Flags().StringVarP(&t.clientID, "id", "i", "", "client id")
 	c.Flags().StringVarP(&t.clientSecret, "secret", "s", "", "client secret")
+	c.Flags().StringArrayVarP(&t.formParams, "form", "", nil,
+		"additional token request parameter as key=value, other than client_id, client_secret and grant_type (may be repeated)")
+	c.Flags().StringVarP(&t.format, "format", "", formatRaw,
+		fmt.Sprintf("print the token as %s", strings.Join(tokenFormats, ", ")))
+	c.Flags().StringVarP(&t.targetURL, "url", "", defaultTargetURL,
+		"URL called by the curl, k6 and vegeta formats")
 
 	_ = c.MarkFlagRequired("id")
 	_ = c.MarkFlagRequired("secret")
@@ -106,11 +132,21 @@ func cmdInspectToken(t *token, printf shared.FormatFn) *cobra.Command {
 	c := &cobra.Command{
 		Use:   "inspect",
 		Short: "Inspect a JWT token",
-		Long:  "Inspect a JWT token",
-		Args:  cobra.NoArgs,
+		Long: `Inspect a JWT token. An encrypted token (JWE) is decrypted first with the key
+of --decryption-key or --decryption-jwks, a nested signed token is then verified. With --batch,
+each line is a token and the result of each is printed as a line of JSON with its status
+(valid, expired, bad-signature, invalid-claims or malformed) and claims.`,
+		Args: cobra.NoArgs,
 
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			err := t.inspectToken(cmd.InOrStdin(), printf)
+			if t.decryptionKey != "" && t.decryptionJWKS != "" {
+				return fmt.Errorf("--decryption-key and --decryption-jwks are exclusive")
+			}
+			inspect := t.inspectToken
+			if t.batch {
+				inspect = t.inspectBatch
+			}
+			err := inspect(cmd.InOrStdin(), printf)
 			if err != nil {
 				return errors.Wrap(err, "inspecting token")
 			}
@@ -119,6 +155,12 @@ func cmdInspectToken(t *token, printf shared.FormatFn) *cobra.Command {
 	}
 
 	c.Flags().StringVarP(&t.file, "file", "f", "", "token file (default: use stdin)")
+	c.Flags().StringVarP(&t.decryptionKey, "decryption-key", "", "",
+		"PEM private key file to decrypt an encrypted token (JWE)")
+	c.Flags().StringVarP(&t.decryptionJWKS, "decryption-jwks", "", "",
+		"JWKS file with the enc keys to decrypt an encrypted token (JWE)")
+	c.Flags().BoolVarP(&t.batch, "batch", "", false,
+		"read newline-delimited tokens and print one JSON verification result per line")
 
 	return c
 }
@@ -135,6 +177,12 @@ func cmdRotateCert(t *token, printf shared.FormatFn) *cobra.Command {
 			if t.IsGCPManaged {
 				return fmt.Errorf("only valid for legacy or opdk, use create-secret for hybrid")
 			}
+			if t.yes && !t.showDiff {
+				return fmt.Errorf("--yes only valid with --show-diff")
+			}
+			if t.output != outputText && t.output != outputJSON {
+				return fmt.Errorf("--output must be %s or %s", outputText, outputJSON)
+			}
 
 			if t.ServerConfig != nil {
 				t.clientID = t.ServerConfig.Tenant.Key
@@ -152,14 +200,21 @@ func cmdRotateCert(t *token, printf shared.FormatFn) *cobra.Command {
 				return err
 			}
 
-			if err := t.rotateCert(printf); err != nil {
+			if err := t.rotateCert(cmd.InOrStdin(), printf); err != nil {
 				return err
 			}
 			return nil
 		},
 	}
 
+	t.AddKeyPolicyFlags(c)
 	c.Flags().IntVarP(&t.truncate, "truncate", "", 2, "number of certs to keep in jwks")
+	c.Flags().BoolVarP(&t.showDiff, "show-diff", "", false,
+		"show the key IDs added, kept and removed with their ages and ask for confirmation before rotating")
+	c.Flags().BoolVarP(&t.yes, "yes", "y", false,
+		"with --show-diff, rotate without asking for confirmation")
+	c.Flags().StringVarP(&t.output, "output", "", outputText,
+		"output format: text, or json for the rotated jwks (eg. for archival)")
 	c.Flags().StringVarP(&t.clientID, "key", "k", "", "provision key")
 	c.Flags().StringVarP(&t.clientSecret, "secret", "s", "", "provision secret")
 
@@ -199,10 +254,9 @@ func cmdCreateInternalJWT(t *token, printf shared.FormatFn) *cobra.Command {
 }
 
 func (t *token) createToken(printf shared.FormatFn) (string, error) {
-	tokenReq := &tokenRequest{
-		ClientID:     t.clientID,
-		ClientSecret: t.clientSecret,
-		GrantType:    clientCredentialsGrant,
+	tokenReq, err := t.tokenRequest()
+	if err != nil {
+		return "", err
 	}
 	body := new(bytes.Buffer)
 	if err := json.NewEncoder(body).Encode(tokenReq); err != nil {
@@ -227,6 +281,32 @@ func (t *token) createToken(printf shared.FormatFn) (string, error) {
 	return tokenRes.Token, nil
 }
 
+// tokenRequest returns the token request parameters, including any
+// additional parameters passed via --form, which can't override the
+// credentials and grant
+func (t *token) tokenRequest() (map[string]string, error) {
+	tokenReq := map[string]string{
+		"client_id":     t.clientID,
+		"client_secret": t.clientSecret,
+		"grant_type":    clientCredentialsGrant,
+	}
+	reserved := map[string]bool{}
+	for key := range tokenReq {
+		reserved[key] = true
+	}
+	for _, param := range t.formParams {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid form parameter %q, must be key=value", param)
+		}
+		if reserved[kv[0]] {
+			return nil, fmt.Errorf("invalid form parameter %q, %s is set by the token request", param, kv[0])
+		}
+		tokenReq[kv[0]] = kv[1]
+	}
+	return tokenReq, nil
+}
+
 func (t *token) createInternalJWT(printf shared.FormatFn) (string, error) {
 	if t.ServerConfig == nil {
 		return "", fmt.Errorf("tenant not found. requires a valid config file")
@@ -245,20 +325,41 @@ func (t *token) createInternalJWT(printf shared.FormatFn) (string, error) {
 	return internalJWT, nil
 }
 
+// tokenInput returns the file of --file or in
+func (t *token) tokenInput(in io.Reader) (io.ReadCloser, error) {
+	if t.file == "" {
+		return ioutil.NopCloser(in), nil
+	}
+	file, err := os.Open(t.file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening file %s", t.file)
+	}
+	return file, nil
+}
+
 func (t *token) inspectToken(in io.Reader, printf shared.FormatFn) error {
-	var file = in
-	if t.file != "" {
-		var err error
-		file, err = os.Open(t.file)
-		if err != nil {
-			return errors.Wrapf(err, "opening file %s", t.file)
-		}
+	file, err := t.tokenInput(in)
+	if err != nil {
+		return err
 	}
+	defer file.Close()
 
 	jwtBytes, err := ioutil.ReadAll(file)
 	if err != nil {
 		return errors.Wrap(err, "reading jwt token")
 	}
+	if isJWE(jwtBytes) {
+		if t.decryptionKey == "" && t.decryptionJWKS == "" {
+			return fmt.Errorf("token is encrypted (JWE), use --decryption-key or --decryption-jwks")
+		}
+		if jwtBytes, err = t.decryptToken(jwtBytes, printf); err != nil {
+			return err
+		}
+		if !isJWS(jwtBytes) { // the claims are the payload
+			return t.inspectClaims(jwtBytes, printf)
+		}
+	}
+
 	token, err := jwt.ParseBytes(jwtBytes)
 	if err != nil {
 		return errors.Wrap(err, "parsing jwt token")
@@ -273,7 +374,7 @@ func (t *token) inspectToken(in io.Reader, printf shared.FormatFn) error {
 	printf("\nverifying...")
 
 	url := fmt.Sprintf(certsURLFormat, t.RemoteServiceProxyURL)
-	jwkSet, err := jwk.FetchHTTP(url)
+	jwkSet, err := jwk.FetchHTTP(url, jwk.WithHTTPClient(t.RuntimeClient()))
 	if err != nil {
 		return errors.Wrap(err, "fetching certs")
 	}
@@ -289,11 +390,47 @@ func (t *token) inspectToken(in io.Reader, printf shared.FormatFn) error {
 	return nil
 }
 
+// inspectClaims prints and verifies the claims of a token that is encrypted but not signed
+func (t *token) inspectClaims(claims []byte, printf shared.FormatFn) error {
+	token := jwt.New()
+	if err := json.Unmarshal(claims, token); err != nil {
+		return errors.Wrap(err, "parsing jwt claims")
+	}
+	buf, err := json.MarshalIndent(token, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "printing jwt token")
+	}
+	printf("%s", buf)
+
+	printf("\nverifying...")
+	printf("token is not signed, skipping signature verification")
+	if err := jwt.Verify(token, jwt.WithAcceptableSkew(time.Minute)); err != nil {
+		printf("invalid token: %s", err)
+		return nil
+	}
+
+	printf("valid token")
+	return nil
+}
+
 // rotateCert is called by `token rotate-cert`
-func (t *token) rotateCert(printf shared.FormatFn) error {
-	var verbosef = shared.NoPrintf
-	if t.Verbose {
-		verbosef = shared.Errorf
+func (t *token) rotateCert(in io.Reader, printf shared.FormatFn) error {
+	verbosef := t.Stepf()
+
+	// the diff would corrupt the json on stdout
+	diffPrintf := printf
+	if t.output == outputJSON {
+		diffPrintf = shared.Errorf
+	}
+
+	var served *jwk.Set
+	if t.showDiff {
+		verbosef("retrieving jwks...")
+		var err error
+		certsURL := fmt.Sprintf(certsURLFormat, t.RemoteServiceProxyURL)
+		if served, err = jwk.FetchHTTP(certsURL, jwk.WithHTTPClient(t.RuntimeClient())); err != nil {
+			return errors.Wrapf(err, "retrieving JWKs from: %s", certsURL)
+		}
 	}
 
 	verbosef("generating key and jwks...")
@@ -302,7 +439,18 @@ func (t *token) rotateCert(printf shared.FormatFn) error {
 		return err
 	}
 
-	rotateReq := rotateRequest{
+	if t.showDiff {
+		rotated, err := jwk.ParseBytes(jwksBytes)
+		if err != nil {
+			return err
+		}
+		printJWKSDiff(diffJWKS(served, rotated, time.Now()), diffPrintf)
+		if !t.yes && !confirm(in, "rotate the certificate", diffPrintf) {
+			return fmt.Errorf("rotation cancelled, nothing changed")
+		}
+	}
+
+	rotateReq := shared.RotateRequest{
 		PrivateKey: string(keyBytes),
 		JWKS:       string(jwksBytes),
 		KeyID:      kid,
@@ -310,49 +458,26 @@ func (t *token) rotateCert(printf shared.FormatFn) error {
 
 	verbosef("rotating certificate...")
 
-	body := new(bytes.Buffer)
-	err = json.NewEncoder(body).Encode(rotateReq)
-	if err != nil {
-		return errors.Wrap(err, "encoding")
-	}
-
-	rotateURL := fmt.Sprintf(rotateURLFormat, t.RemoteServiceProxyURL)
-	req, err := http.NewRequest(http.MethodPost, rotateURL, body)
-	if err != nil {
-		return errors.Wrap(err, "creating request")
-	}
-	req.SetBasicAuth(t.clientID, t.clientSecret)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-
-	resp, err := t.ApigeeClient.Do(req, nil)
-	if err != nil {
-		if resp != nil && resp.StatusCode == 401 {
-			return errors.Wrap(err, "authentication failed, check your key and secret")
-		}
-		return errors.Wrap(err, "rotating cert")
+	if err := t.PostRotate(t.clientID, t.clientSecret, rotateReq); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
+	t.Notify(shared.EventCertRotated, map[string]string{"command": "token rotate-cert", "kid": kid}, shared.Errorf)
 
 	verbosef("new private key:\n%s", string(keyBytes))
 	verbosef("new jwks:\n%s", string(jwksBytes))
 
+	if t.output == outputJSON {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, jwksBytes, "", "  "); err != nil {
+			return err
+		}
+		printf(indented.String())
+		return nil
+	}
 	printf("certificate successfully rotated")
 	return nil
 }
 
-type rotateRequest struct {
-	PrivateKey string `json:"private_key"`
-	JWKS       string `json:"jwks"`
-	KeyID      string `json:"kid"`
-}
-
-type tokenRequest struct {
-	ClientID     string `json:"client_id"`
-	ClientSecret string `json:"client_secret"`
-	GrantType    string `json:"grant_type"`
-}
-
 type tokenResponse struct {
 	Token string `json:"token"`
 }
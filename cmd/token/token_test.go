@@ -113,7 +113,19 @@ func badHandler(t *testing.T) http.Handler {
 
 func TestTokenCreate(t *testing.T) {
 
+	var tokenReq map[string]string // of the last request
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonBody := make(map[string]string)
+		if err := json.NewDecoder(r.Body).Decode(&jsonBody); err != nil {
+			t.Fatalf("error in token request %v", err)
+		}
+		tokenReq = jsonBody
+		if jsonBody["client_id"] != "/id/" || jsonBody["client_secret"] != "/secret/" {
+			t.Errorf("bad token request credentials: %v", jsonBody)
+		}
+		if jsonBody["grant_type"] != "client_credentials" {
+			t.Errorf("want grant_type client_credentials, got %s", jsonBody["grant_type"])
+		}
 		resp := tokenResponse{
 			Token: "/token/",
 		}
@@ -139,11 +151,106 @@ func TestTokenCreate(t *testing.T) {
 
 	print.Check(t, want)
 
-	flags = []string{"token", "create", "--runtime", "dummy", "--id", "/id/", "--secret", "/secret/"}
+	// additional form parameters
+	flags = []string{"token", "create", "--runtime", ts.URL, "--id", "/id/", "--secret", "/secret/",
+		"--form", "audience=/aud/", "--form", "resource=/res/"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+
+	print.Check(t, want)
+	if tokenReq["audience"] != "/aud/" || tokenReq["resource"] != "/res/" {
+		t.Errorf("want audience /aud/ and resource /res/, got %v", tokenReq)
+	}
+
+	// reserved form parameter
+	for _, param := range []string{"client_id=other", "client_secret=other", "grant_type=password"} {
+		flags = []string{"token", "create", "--runtime", ts.URL, "--id", "/id/", "--secret", "/secret/",
+			"--form", param}
+		rootCmd = cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+
+		key := strings.SplitN(param, "=", 2)[0]
+		testutil.ErrorContains(t, rootCmd.Execute(), fmt.Sprintf("invalid form parameter %q, %s is set by the token request", param, key))
+	}
+
+	// bad form parameter
+	flags = []string{"token", "create", "--runtime", ts.URL, "--id", "/id/", "--secret", "/secret/",
+		"--form", "audience"}
 	rootCmd = cmd.GetRootCmd(flags, print.Printf)
 	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
 
 	err := rootCmd.Execute()
+	testutil.ErrorContains(t, err, `invalid form parameter "audience", must be key=value`)
+
+	// credentials from env file
+	envFile, err := ioutil.TempFile("", "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(envFile.Name())
+	if _, err := envFile.WriteString("ID=/id/\nSECRET='/secret/'\n"); err != nil {
+		t.Fatal(err)
+	}
+	flags = []string{"token", "create", "--runtime", ts.URL, "--env-file", envFile.Name()}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+
+	print.Check(t, want)
+
+	// formats
+	for format, want := range map[string]string{
+		"raw":    "/token/",
+		"header": "Authorization: Bearer /token/",
+		"curl":   "curl -i -H 'Authorization: Bearer /token/' 'https://envoy.example.com/get?a=b'",
+		"vegeta": "GET https://envoy.example.com/get?a=b\nAuthorization: Bearer /token/\n",
+		"k6": `import http from 'k6/http';
+import { check } from 'k6';
+
+export default function () {
+  const res = http.get("https://envoy.example.com/get?a=b", { headers: { Authorization: "Bearer /token/" } });
+  check(res, { 'authorized': (r) => r.status !== 401 && r.status !== 403 });
+}`,
+	} {
+		flags = []string{"token", "create", "--runtime", ts.URL, "--id", "/id/", "--secret", "/secret/",
+			"--format", format, "--url", "https://envoy.example.com/get?a=b"}
+		rootCmd = cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+		if err := rootCmd.Execute(); err != nil {
+			t.Fatalf("want no error: %v", err)
+		}
+		print.Check(t, []string{want})
+	}
+	flags = []string{"token", "create", "--runtime", ts.URL, "--id", "/id/", "--secret", "/secret/", "--format", "curl"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	if err := rootCmd.Execute(); err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	print.Check(t, []string{"curl -i -H 'Authorization: Bearer /token/' 'http://localhost:8080/headers'"})
+
+	for _, args := range [][]string{{"--format", "bogus"}, {"--format", "curl", "--url", "localhost:8080"}} {
+		flags = append([]string{"token", "create", "--runtime", ts.URL, "--id", "/id/", "--secret", "/secret/"}, args...)
+		rootCmd = cmd.GetRootCmd(flags, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+		err = rootCmd.Execute()
+		if err == nil || !strings.Contains(err.Error(), args[len(args)-2]) {
+			t.Errorf("want %s error, got %v", args[len(args)-2], err)
+		}
+	}
+
+	flags = []string{"token", "create", "--runtime", "dummy", "--id", "/id/", "--secret", "/secret/"}
+	rootCmd = cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+
+	err = rootCmd.Execute()
 	testutil.ErrorContains(t, err, "creating token: Post \"dummy/remote-service/token\": unsupported protocol scheme")
 }
 
@@ -534,3 +641,87 @@ func generateConfig(t *testing.T) []byte {
 
 	return yamlBuffer.Bytes()
 }
+
+func TestTokenRotateCertShowDiff(t *testing.T) {
+	rotations := 0
+	handler := remoteServiceHandler(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/remote-service/rotate" {
+			rotations++
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	run := func(input string, args ...string) (*testutil.TestPrint, error) {
+		rotations = 0
+		print := testutil.Printer("TestTokenRotateCertShowDiff")
+		rootArgs := &shared.RootArgs{}
+		flags := append([]string{"token", "rotate-cert", "-o", "hi", "-e", "test", "--legacy", "-k", "key", "-s", "secret"}, args...)
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		rootCmd.SetIn(strings.NewReader(input))
+		shared.AddCommandWithFlags(rootCmd, rootArgs, testCmd(rootArgs, print.Printf, ts.URL))
+		return print, rootCmd.Execute()
+	}
+
+	// declined
+	print, err := run("n\n", "--show-diff")
+	testutil.ErrorContains(t, err, "rotation cancelled, nothing changed")
+	if rotations != 0 {
+		t.Errorf("want no rotation, got %d", rotations)
+	}
+	if len(print.Prints) != 5 {
+		t.Fatalf("want diff and confirmation, got %v", print.Prints)
+	}
+	if print.Prints[1] != "  = kid (age unknown)" || !strings.HasPrefix(print.Prints[2], "  + ") ||
+		!strings.HasSuffix(print.Prints[2], " (age 0d0h)") || print.Prints[3] != "1 added, 1 kept, 0 removed" ||
+		print.Prints[4] != "rotate the certificate? [y/N]" {
+		t.Errorf("unexpected diff %v", print.Prints)
+	}
+
+	print, err = run("", "--show-diff", "-y", "--truncate", "1")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if rotations != 1 {
+		t.Errorf("want 1 rotation, got %d", rotations)
+	}
+	printed := strings.Join(print.Prints, "\n")
+	for _, want := range []string{"  - kid (age unknown)", "1 added, 0 kept, 1 removed", "certificate successfully rotated"} {
+		if !strings.Contains(printed, want) {
+			t.Errorf("want %q in:\n%s", want, printed)
+		}
+	}
+
+	// only the jwks is printed
+	print, err = run("", "--output", "json")
+	if err != nil {
+		t.Fatalf("want no error: %v", err)
+	}
+	if len(print.Prints) != 1 {
+		t.Fatalf("want jwks only, got %v", print.Prints)
+	}
+	jwks, err := jwk.ParseString(print.Prints[0])
+	if err != nil || len(jwks.Keys) != 2 {
+		t.Errorf("want jwks of 2 keys, got %v, %v", jwks, err)
+	}
+
+	_, err = run("", "-y")
+	testutil.ErrorContains(t, err, "--yes only valid with --show-diff")
+	_, err = run("", "--output", "yaml")
+	testutil.ErrorContains(t, err, "--output must be text or json")
+}
+
+func TestKeyAge(t *testing.T) {
+	now := time.Date(2020, 6, 10, 12, 0, 0, 0, time.UTC)
+	for kid, want := range map[string]string{
+		"2020-06-08T09:30:00Z": "2d2h",
+		"2020-06-10T12:00:00Z": "0d0h",
+		"2020-06-11T12:00:00Z": "0d0h",
+		"kid":                  "",
+	} {
+		if got := keyAge(kid, now); got != want {
+			t.Errorf("keyAge(%s) want %q, got %q", kid, want, got)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// entry is the part of an Envoy access log entry used to trace a request
+type entry struct {
+	Time      time.Time
+	Method    string
+	Path      string
+	Status    int
+	Flags     string // response flags, UAEX if denied by ext_authz
+	Details   string // response code details, Istio only
+	RequestID string
+	Authority string
+	Upstream  string
+}
+
+var (
+	// textEntry matches the start of the default format of Envoy and Istio:
+	// [%START_TIME%] "%REQ(:METHOD)% %REQ(X-ENVOY-ORIGINAL-PATH?:PATH)% %PROTOCOL%" %RESPONSE_CODE% %RESPONSE_FLAGS%
+	textEntry = regexp.MustCompile(`^\[([^\]]+)\] "(\S+) (\S+) [^"]*" (\d+) (\S+)(.*)$`)
+	// codeDetails is the %RESPONSE_CODE_DETAILS% following the flags in Istio
+	codeDetails = regexp.MustCompile(`^ ([a-z_]+) `)
+	quoted      = regexp.MustCompile(`"([^"]*)"`)
+	requestID   = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// jsonEntry is an entry of Istio's JSON access log encoding
+type jsonEntry struct {
+	StartTime    string `json:"start_time"`
+	Method       string `json:"method"`
+	Path         string `json:"path"`
+	ResponseCode int    `json:"response_code"`
+	Flags        string `json:"response_flags"`
+	Details      string `json:"response_code_details"`
+	RequestID    string `json:"request_id"`
+	Authority    string `json:"authority"`
+	UpstreamHost string `json:"upstream_host"`
+}
+
+// parseEntry parses an access log entry of the default text format, where
+// the request ID, authority and upstream host are the quoted fields
+// following the user agent, or of Istio's JSON encoding
+func parseEntry(line string) (entry, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		var j jsonEntry
+		if err := json.Unmarshal([]byte(line), &j); err != nil {
+			return entry{}, fmt.Errorf("invalid JSON access log entry: %v", err)
+		}
+		t, err := time.Parse(time.RFC3339Nano, j.StartTime)
+		if err != nil {
+			return entry{}, fmt.Errorf("invalid start_time %q of access log entry", j.StartTime)
+		}
+		return entry{
+			Time:      t,
+			Method:    j.Method,
+			Path:      j.Path,
+			Status:    j.ResponseCode,
+			Flags:     j.Flags,
+			Details:   j.Details,
+			RequestID: j.RequestID,
+			Authority: j.Authority,
+			Upstream:  j.UpstreamHost,
+		}, nil
+	}
+
+	m := textEntry.FindStringSubmatch(line)
+	if m == nil {
+		return entry{}, fmt.Errorf("not an access log entry of the default format: %q", line)
+	}
+	t, err := time.Parse(time.RFC3339Nano, m[1])
+	if err != nil {
+		return entry{}, fmt.Errorf("invalid start time %q of access log entry", m[1])
+	}
+	status, _ := strconv.Atoi(m[4])
+	e := entry{Time: t, Method: m[2], Path: m[3], Status: status, Flags: m[5]}
+	if d := codeDetails.FindStringSubmatch(m[6]); d != nil {
+		e.Details = d[1]
+	}
+	fields := quoted.FindAllStringSubmatch(m[6], -1)
+	for i, f := range fields {
+		if !requestID.MatchString(f[1]) {
+			continue
+		}
+		e.RequestID = f[1]
+		if i+1 < len(fields) {
+			e.Authority = fields[i+1][1]
+		}
+		if i+2 < len(fields) {
+			e.Upstream = fields[i+2][1]
+		}
+		break
+	}
+	return e, nil
+}
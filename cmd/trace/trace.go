@@ -0,0 +1,331 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/k8s"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	adapterSelector = "app=apigee-remote-service-envoy"
+	metricRequests  = "sum(message_count)"
+)
+
+// adapterDecision matches the debug lines the adapter logs of its decisions
+var adapterDecision = regexp.MustCompile(`(sending denied: \S+|sending ok \(actual: \S+\)|quota exceeded: \S+|quota check: .*|sending internal error: .*)`)
+
+type trace struct {
+	*shared.RootArgs
+	requestID      string
+	target         string
+	kubeconfig     string
+	context        string
+	envoySelector  string
+	envoyNamespace string
+	envoyContainer string
+	since          string
+	window         string
+
+	now func() time.Time
+}
+
+// Cmd returns base command
+func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	t := &trace{RootArgs: rootArgs, now: time.Now}
+
+	c := &cobra.Command{
+		Use:   "trace",
+		Short: "Reconstruct what happened to requests through the adapter",
+		Long:  "Reconstruct what happened to requests through the adapter.",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return rootArgs.Resolve(false, false)
+		},
+	}
+
+	c.PersistentFlags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.PersistentFlags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+	c.PersistentFlags().StringVarP(&rootArgs.ManagementBase, "management", "m",
+		"", "Apigee management base URL (default: hybrid and Apigee X, or the runtime for opdk)")
+	c.PersistentFlags().StringVarP(&rootArgs.Token, "token", "t", "",
+		"Apigee OAuth or SAML token (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.ServiceAccount, "service-account", "", "",
+		"service account key file minting Apigee OAuth tokens if no --token (default: application default credentials) (hybrid only)")
+	c.PersistentFlags().StringVarP(&rootArgs.Username, "username", "u", "",
+		"Apigee username (legacy or OPDK only)")
+	c.PersistentFlags().StringVarP(&rootArgs.Password, "password", "p", "",
+		"Apigee password (legacy or OPDK only)")
+
+	c.AddCommand(cmdRequest(t, printf))
+
+	return c
+}
+
+func cmdRequest(t *trace, printf shared.FormatFn) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "request [access-log-entry]",
+		Short: "Correlate an Envoy access log entry with the adapter logs and Apigee analytics",
+		Long: `Correlate an Envoy access log entry, in Envoy's default text format or Istio's
+JSON, with the logs of the adapter pods at the time of the request and the Apigee
+analytics of its target, path and status, to reconstruct the authorization result,
+the matched API products and apps and the quota decision. Given --request-id
+instead, the entry is looked up in the logs of the Envoy pods of --envoy-selector.
+
+The adapter doesn't log request IDs, so its log lines are those of --window around
+the request (its decisions are logged at --log-level debug only) and analytics are
+matched by target, path, status and minute, not by request.`,
+		Args: cobra.MaximumNArgs(1),
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if t.Org == "" || t.Env == "" {
+				return fmt.Errorf("--organization and --environment are required")
+			}
+			if (len(args) == 0) == (t.requestID == "") {
+				return fmt.Errorf("an access log entry or --request-id is required")
+			}
+			if t.requestID != "" && t.envoySelector == "" {
+				return fmt.Errorf("--request-id requires --envoy-selector to find its access log entry")
+			}
+			window, err := shared.ParseDuration(t.window)
+			if err != nil || window <= 0 {
+				return fmt.Errorf("--window must be a positive duration, got %q", t.window)
+			}
+			since, err := shared.ParseDuration(t.since)
+			if err != nil || since <= 0 {
+				return fmt.Errorf("--since must be a positive duration, got %q", t.since)
+			}
+			cmd.SilenceUsage = true
+			return t.request(args, window, since, printf)
+		},
+	}
+
+	c.Flags().StringVarP(&t.requestID, "request-id", "", "",
+		"x-request-id of the request, to look up its entry in the Envoy logs")
+	c.Flags().StringVarP(&t.target, "target", "", "",
+		"remote service target of the request (default: the authority of the entry, as the adapter's default target header)")
+	c.Flags().StringVarP(&t.kubeconfig, "kubeconfig", "", "",
+		"kubeconfig of the cluster of the adapter (default: $KUBECONFIG or ~/.kube/config)")
+	c.Flags().StringVarP(&t.context, "context", "", "",
+		"kubeconfig context of the cluster of the adapter (default: the current context)")
+	c.Flags().StringVarP(&t.Namespace, "namespace", "n", "apigee",
+		"namespace of the adapter pods")
+	c.Flags().StringVarP(&t.envoySelector, "envoy-selector", "", "",
+		`label selector of the Envoy pods logging the request of --request-id (eg. "app=httpbin")`)
+	c.Flags().StringVarP(&t.envoyNamespace, "envoy-namespace", "", "",
+		"namespace of the Envoy pods (default: the namespace of the context)")
+	c.Flags().StringVarP(&t.envoyContainer, "envoy-container", "", "istio-proxy",
+		"container of the Envoy pods logging the access log")
+	c.Flags().StringVarP(&t.since, "since", "", "1h",
+		"how far back to look up --request-id in the Envoy logs")
+	c.Flags().StringVarP(&t.window, "window", "", "30s",
+		"adapter logs before and after the time of the request to report")
+
+	return c
+}
+
+func (t *trace) request(args []string, window, since time.Duration, printf shared.FormatFn) error {
+	// the adapter logs are skipped without a cluster, but not the analytics
+	cluster, clusterErr := k8s.LoadCluster(t.kubeconfig, t.context)
+
+	var line string
+	if len(args) > 0 {
+		line = args[0]
+	} else {
+		if clusterErr != nil {
+			return errors.Wrap(clusterErr, "--request-id")
+		}
+		var err error
+		if line, err = t.findEntry(cluster, since); err != nil {
+			return err
+		}
+	}
+	e, err := parseEntry(line)
+	if err != nil {
+		return err
+	}
+	target := t.target
+	if target == "" {
+		target = e.Authority
+	}
+
+	printf("request %s: %s %s at %s", orNone(e.RequestID), e.Method, e.Path, e.Time.Format(time.RFC3339Nano))
+	printf("  target: %s", orNone(target))
+	printf("  response: %d, flags: %s%s", e.Status, orNone(e.Flags), prefixed(", details: ", e.Details))
+	if e.Upstream != "" && e.Upstream != "-" {
+		printf("  upstream: %s", e.Upstream)
+	}
+	printf("authorization: %s", authResult(e))
+
+	printf("")
+	t.printAdapterLogs(cluster, clusterErr, e, window, printf)
+
+	printf("")
+	t.printAnalytics(e, target, printf)
+	return nil
+}
+
+// findEntry returns the access log line of --request-id in the Envoy pods
+func (t *trace) findEntry(cluster *k8s.Cluster, since time.Duration) (string, error) {
+	now := t.now()
+	lines, err := cluster.PodLogs(t.envoyNamespace, t.envoySelector, t.envoyContainer, now.Add(-since), now)
+	if err != nil {
+		return "", errors.Wrap(err, "looking up --request-id")
+	}
+	for _, l := range lines {
+		if strings.Contains(l.Text, t.requestID) {
+			return l.Text, nil
+		}
+	}
+	return "", fmt.Errorf("request %s not found in the logs of container %s of pods %s over the last %s",
+		t.requestID, t.envoyContainer, t.envoySelector, t.since)
+}
+
+// authResult tells the decision of the adapter from the response, as the
+// ext_authz filter maps the codes of its Check
+func authResult(e entry) string {
+	switch {
+	case strings.Contains(e.Details, "ext_authz_error"):
+		return "the adapter was unreachable or failed, and the filter denied the request (failure_mode_allow: false)"
+	case !strings.Contains(e.Flags, "UAEX") && !strings.Contains(e.Details, "ext_authz_denied"):
+		return fmt.Sprintf("authorized: the adapter allowed the request (or the route doesn't check authorization), upstream answered %d", e.Status)
+	}
+	switch e.Status {
+	case http.StatusUnauthorized:
+		return "denied: no API key or JWT, or an unknown one (UNAUTHENTICATED)"
+	case http.StatusForbidden:
+		return "denied: the credential is invalid or no API product of it matches the target and path (PERMISSION_DENIED)"
+	case http.StatusTooManyRequests:
+		return "denied: the quota of a matched API product is exceeded (RESOURCE_EXHAUSTED)"
+	case http.StatusInternalServerError:
+		return "denied: the adapter failed, eg. to reach Apigee (INTERNAL)"
+	}
+	return fmt.Sprintf("denied by the adapter with %d", e.Status)
+}
+
+func (t *trace) printAdapterLogs(cluster *k8s.Cluster, clusterErr error, e entry, window time.Duration, printf shared.FormatFn) {
+	from, to := e.Time.Add(-window), e.Time.Add(window)
+	printf("adapter logs (%s in %s) from %s to %s:", adapterSelector, t.Namespace,
+		from.UTC().Format(time.RFC3339), to.UTC().Format(time.RFC3339))
+	if clusterErr != nil {
+		printf("  skipped: %v", clusterErr)
+		return
+	}
+	lines, err := cluster.PodLogs(t.Namespace, adapterSelector, "", from, to)
+	if err != nil {
+		printf("  failed: %v", err)
+		return
+	}
+	var decisions []string
+	for _, l := range lines {
+		printf("  %s %s: %s", l.Time.UTC().Format("15:04:05.000"), l.Pod, l.Text)
+		if d := adapterDecision.FindString(l.Text); d != "" {
+			decisions = append(decisions, d)
+		}
+	}
+	switch {
+	case len(lines) == 0:
+		printf("  none")
+	case len(decisions) == 0:
+		printf("  no decisions logged, the adapter logs them at --log-level debug only")
+	default:
+		printf("  decisions: %s", strings.Join(decisions, "; "))
+	}
+	printf("  the adapter doesn't log request IDs: the lines may be of other requests of the window")
+}
+
+func (t *trace) printAnalytics(e entry, target string, printf shared.FormatFn) {
+	path := strings.SplitN(e.Path, "?", 2)[0] // as request_path
+	start := e.Time.UTC().Truncate(time.Minute)
+	end := start.Add(time.Minute)
+	printf("analytics of %s %s %d from %s to %s (UTC):", orNone(target), path, e.Status,
+		start.Format(shared.StatsTimeRangeFormat), end.Format(shared.StatsTimeRangeFormat))
+	if target == "" {
+		printf("  skipped: the entry has no authority, use --target")
+		return
+	}
+	filter := fmt.Sprintf("(apiproxy eq '%s') and (request_path eq '%s') and (response_status_code eq %d)",
+		target, path, e.Status)
+	found := false
+	for _, dimension := range []string{"api_product", "developer_app"} {
+		totals, err := t.stats(dimension, filter, start, end)
+		if err != nil {
+			printf("  %s: failed: %v", dimension, err)
+			continue
+		}
+		var names []string
+		for name := range totals {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			found = true
+			printf("  %s: %s (%d requests)", dimension, orNone(name), int64(totals[name]))
+		}
+	}
+	if !found {
+		printf("  none recorded, analytics may be delayed by several minutes")
+		return
+	}
+	printf("  matched by target, path, status and minute: the requests may include others than this one")
+}
+
+// stats returns the requests per value of the dimension
+func (t *trace) stats(dimension, filter string, start, end time.Time) (map[string]float64, error) {
+	req, err := t.ApigeeClient.NewRequest(http.MethodGet, "", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating request")
+	}
+	req.URL.Path = fmt.Sprintf(shared.StatsURLFormat, t.Org, t.Env, dimension) // hack: negate client's base URL
+	req.URL.RawQuery = url.Values{
+		"select":    {metricRequests},
+		"timeRange": {start.Format(shared.StatsTimeRangeFormat) + "~" + end.Format(shared.StatsTimeRangeFormat)},
+		"timeUnit":  {"minute"},
+		"filter":    {filter},
+	}.Encode()
+
+	var res shared.StatsResponse
+	resp, err := t.ApigeeClient.Do(req, &res)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return res.Totals(metricRequests), nil
+}
+
+func orNone(s string) string {
+	if s == "" || s == "-" {
+		return "(none)"
+	}
+	return s
+}
+
+func prefixed(prefix, s string) string {
+	if s == "" {
+		return ""
+	}
+	return prefix + s
+}
@@ -0,0 +1,189 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+const (
+	deniedEntry = `[2020-10-14T12:00:05.123Z] "GET /headers?x=1 HTTP/1.1" 429 UAEX ext_authz_denied - "-" 0 0 2 - "10.0.0.1" "curl/7.64.1" ` +
+		`"5d3f7a2c-1b2e-4c3d-9e8f-0a1b2c3d4e5f" "httpbin.default.svc.cluster.local" "-" - - 10.0.0.2:80 10.0.0.1:5432 - default`
+	allowedEntry = `{"start_time":"2020-10-14T12:00:05.123Z","method":"GET","path":"/headers","response_code":200,` +
+		`"response_flags":"-","request_id":"r1","authority":"httpbin","upstream_host":"10.0.0.3:80"}`
+)
+
+func TestTraceRequest(t *testing.T) {
+	m := http.NewServeMux()
+	m.HandleFunc("/api/v1/namespaces/apigee/pods", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"items": [{"metadata": {"name": "adapter-1"}, "spec": {"containers": [{"name": "adapter"}]}}]}`))
+	})
+	m.HandleFunc("/api/v1/namespaces/apigee/pods/adapter-1/log", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("2020-10-14T12:00:05.1Z 2020/10/14 12:00:05 DEBUG quota exceeded: httpbin-product\n" +
+			"2020-10-14T12:00:05.2Z 2020/10/14 12:00:05 DEBUG sending denied: RESOURCE_EXHAUSTED\n"))
+	})
+	m.HandleFunc("/api/v1/namespaces/default/pods", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("labelSelector") != "app=httpbin" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"items": [{"metadata": {"name": "httpbin-1"}, "spec": {"containers": [{"name": "httpbin"}, {"name": "istio-proxy"}]}}]}`))
+	})
+	m.HandleFunc("/api/v1/namespaces/default/pods/httpbin-1/log", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("container") != "istio-proxy" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte("2020-10-14T12:00:05.3Z " + deniedEntry + "\n"))
+	})
+	m.HandleFunc("/v1/organizations/org/environments/test/stats/", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if want := "(apiproxy eq 'httpbin.default.svc.cluster.local') and (request_path eq '/headers') and (response_status_code eq 429)"; q.Get("filter") != want {
+			t.Errorf("want filter %s, got %s", want, q.Get("filter"))
+		}
+		if q.Get("timeRange") != "10/14/2020 12:00~10/14/2020 12:01" {
+			t.Errorf("unexpected timeRange %s", q.Get("timeRange"))
+		}
+		name := "httpbin-product"
+		if filepath.Base(r.URL.Path) == "developer_app" {
+			name = "my-app"
+		}
+		_, _ = w.Write([]byte(`{"environments": [{"name": "test", "dimensions": [
+  {"name": "` + name + `", "metrics": [{"name": "sum(message_count)", "values": [{"timestamp": 1, "value": "3.0"}]}]}
+]}]}`))
+	})
+	ts := httptest.NewServer(testutil.KubeDiscovery(m))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeconfig, []byte(`current-context: dev
+contexts:
+- name: dev
+  context: {cluster: dev, user: dev}
+clusters:
+- name: dev
+  cluster: {server: "`+ts.URL+`"}
+users:
+- name: dev
+  user: {token: t0ken}
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (*testutil.TestPrint, error) {
+		print := testutil.Printer("TestTraceRequest")
+		flags := append([]string{"trace", "request", "--opdk", "--runtime", ts.URL,
+			"-o", "org", "-e", "test", "-u", "/username/", "-p", "password", "--kubeconfig", kubeconfig}, args...)
+		rootArgs := &shared.RootArgs{}
+		rootCmd := cmd.GetRootCmd(flags, print.Printf)
+		traceCmd := Cmd(rootArgs, print.Printf)
+		shared.AddCommandWithFlags(rootCmd, rootArgs, traceCmd)
+		return print, rootCmd.Execute()
+	}
+
+	denied := []string{
+		"request 5d3f7a2c-1b2e-4c3d-9e8f-0a1b2c3d4e5f: GET /headers?x=1 at 2020-10-14T12:00:05.123Z",
+		"  target: httpbin.default.svc.cluster.local",
+		"  response: 429, flags: UAEX, details: ext_authz_denied",
+		"authorization: denied: the quota of a matched API product is exceeded (RESOURCE_EXHAUSTED)",
+		"",
+		"adapter logs (app=apigee-remote-service-envoy in apigee) from 2020-10-14T11:59:35Z to 2020-10-14T12:00:35Z:",
+		"  12:00:05.100 adapter-1: 2020/10/14 12:00:05 DEBUG quota exceeded: httpbin-product",
+		"  12:00:05.200 adapter-1: 2020/10/14 12:00:05 DEBUG sending denied: RESOURCE_EXHAUSTED",
+		"  decisions: quota exceeded: httpbin-product; sending denied: RESOURCE_EXHAUSTED",
+		"  the adapter doesn't log request IDs: the lines may be of other requests of the window",
+		"",
+		"analytics of httpbin.default.svc.cluster.local /headers 429 from 10/14/2020 12:00 to 10/14/2020 12:01 (UTC):",
+		"  api_product: httpbin-product (3 requests)",
+		"  developer_app: my-app (3 requests)",
+		"  matched by target, path, status and minute: the requests may include others than this one",
+	}
+	print, err := run(deniedEntry)
+	if err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	print.Check(t, denied)
+
+	print, err = run("--request-id", "5d3f7a2c-1b2e-4c3d-9e8f-0a1b2c3d4e5f",
+		"--envoy-selector", "app=httpbin", "--envoy-namespace", "default", "--since", "100000h")
+	if err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	print.Check(t, denied)
+
+	_, err = run("--request-id", "unknown", "--envoy-selector", "app=httpbin", "--envoy-namespace", "default", "--since", "100000h")
+	testutil.ErrorContains(t, err, "request unknown not found in the logs of container istio-proxy of pods app=httpbin over the last 100000h")
+	_, err = run()
+	testutil.ErrorContains(t, err, "an access log entry or --request-id is required")
+	_, err = run("--request-id", "r1")
+	testutil.ErrorContains(t, err, "--request-id requires --envoy-selector")
+	_, err = run("--window", "0s", deniedEntry)
+	testutil.ErrorContains(t, err, `--window must be a positive duration, got "0s"`)
+	_, err = run("not an entry")
+	testutil.ErrorContains(t, err, `not an access log entry of the default format: "not an entry"`)
+}
+
+func TestParseEntry(t *testing.T) {
+	e, err := parseEntry(allowedEntry)
+	if err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	want := entry{
+		Time:      time.Date(2020, 10, 14, 12, 0, 5, 123000000, time.UTC),
+		Method:    "GET",
+		Path:      "/headers",
+		Status:    200,
+		Flags:     "-",
+		RequestID: "r1",
+		Authority: "httpbin",
+		Upstream:  "10.0.0.3:80",
+	}
+	if e != want {
+		t.Errorf("want %#v, got %#v", want, e)
+	}
+	if got := authResult(e); got != "authorized: the adapter allowed the request (or the route doesn't check authorization), upstream answered 200" {
+		t.Errorf("unexpected authorization %s", got)
+	}
+
+	// Envoy's default format has no response code details
+	e, err = parseEntry(`[2020-10-14T12:00:05.123Z] "POST /anything HTTP/1.1" 403 UAEX 0 0 1 - "-" "curl/7.64.1" "5d3f7a2c-1b2e-4c3d-9e8f-0a1b2c3d4e5f" "httpbin" "-"`)
+	if err != nil {
+		t.Fatalf("want no error, got: %v", err)
+	}
+	if e.Details != "" || e.Authority != "httpbin" || e.Upstream != "-" {
+		t.Errorf("unexpected entry %#v", e)
+	}
+	if got := authResult(e); got != "denied: the credential is invalid or no API product of it matches the target and path (PERMISSION_DENIED)" {
+		t.Errorf("unexpected authorization %s", got)
+	}
+
+	_, err = parseEntry(`{"start_time": "yesterday"}`)
+	testutil.ErrorContains(t, err, `invalid start_time "yesterday" of access log entry`)
+}
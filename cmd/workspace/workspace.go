@@ -0,0 +1,103 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/spf13/cobra"
+)
+
+type workspace struct {
+	*shared.RootArgs
+	force bool
+}
+
+// Cmd returns the init command
+func Cmd(rootArgs *shared.RootArgs, printf shared.FormatFn) *cobra.Command {
+	w := &workspace{RootArgs: rootArgs}
+
+	c := &cobra.Command{
+		Use:   "init [dir]",
+		Short: "Create a workspace for provisioning artifacts",
+		Long: `Create a workspace directory. Commands run within the workspace take the
+organization, environment, runtime, namespace and platform from it for flags not
+given on the command line, and provision stores its output in <org>/<env>.`,
+		Args:        cobra.MaximumNArgs(1),
+		Annotations: map[string]string{shared.SkipWorkspaceAnnotation: "true"},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			cmd.SilenceUsage = true
+			return w.init(dir, printf)
+		},
+	}
+
+	c.Flags().BoolVarP(&rootArgs.IsLegacySaaS, "legacy", "", false,
+		"Apigee SaaS (sets management and runtime URL)")
+	c.Flags().BoolVarP(&rootArgs.IsOPDK, "opdk", "", false,
+		"Apigee opdk")
+	c.Flags().StringVarP(&rootArgs.Namespace, "namespace", "n", "apigee",
+		"namespace of the workspace's Kubernetes resources")
+	c.Flags().BoolVarP(&w.force, "force", "f", false,
+		"overwrite an existing workspace")
+
+	return c
+}
+
+func (w *workspace) init(dir string, printf shared.FormatFn) error {
+	if w.IsLegacySaaS && w.IsOPDK {
+		return fmt.Errorf("--legacy and --opdk options are exclusive")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, shared.WorkspaceFile)); err == nil && !w.force {
+		return fmt.Errorf("%s is already a workspace, use --force to overwrite", dir)
+	}
+
+	ws := &shared.Workspace{
+		Dir:          dir,
+		Organization: w.Org,
+		Environment:  w.Env,
+		Runtime:      w.RuntimeBase,
+		Namespace:    w.Namespace,
+		Platform:     shared.PlatformGCP,
+	}
+	if w.IsLegacySaaS {
+		ws.Platform = shared.PlatformSaaS
+	}
+	if w.IsOPDK {
+		ws.Platform = shared.PlatformOPDK
+	}
+	if err := ws.Save(); err != nil {
+		return err
+	}
+	if ws.Organization != "" && ws.Environment != "" {
+		if err := os.MkdirAll(ws.ArtifactDir(ws.Organization, ws.Environment), 0755); err != nil {
+			return err
+		}
+	}
+
+	printf("initialized workspace in %s", dir)
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workspace
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/shared"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestInit(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "myproject")
+
+	print := testutil.Printer("TestInit")
+	if err := runInit(print, dir, "-o", "org", "-e", "env", "-r", "https://runtime", "--opdk"); err != nil {
+		t.Fatal(err)
+	}
+	print.Check(t, []string{"initialized workspace in " + dir})
+
+	ws, err := shared.LoadWorkspace(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ws.Organization != "org" || ws.Environment != "env" || ws.Runtime != "https://runtime" ||
+		ws.Namespace != "apigee" || ws.Platform != shared.PlatformOPDK {
+		t.Errorf("unexpected workspace: %#v", ws)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "org", "env")); err != nil {
+		t.Errorf("want org/env directory: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".gitignore")); err != nil {
+		t.Errorf("want .gitignore: %v", err)
+	}
+
+	err = runInit(print, dir)
+	testutil.ErrorContains(t, err, "is already a workspace, use --force to overwrite")
+
+	if err := runInit(print, dir, "-f", "-o", "other"); err != nil {
+		t.Fatal(err)
+	}
+	if ws, _ = shared.LoadWorkspace(dir); ws.Organization != "other" || ws.Platform != shared.PlatformGCP {
+		t.Errorf("want workspace overwritten, got %#v", ws)
+	}
+
+	err = runInit(print, dir, "-f", "--legacy", "--opdk")
+	testutil.ErrorContains(t, err, "--legacy and --opdk options are exclusive")
+}
+
+func runInit(print *testutil.TestPrint, args ...string) error {
+	flags := append([]string{"init"}, args...)
+	rootArgs := &shared.RootArgs{}
+	rootCmd := cmd.GetRootCmd(flags, print.Printf)
+	shared.AddCommandWithFlags(rootCmd, rootArgs, Cmd(rootArgs, print.Printf))
+	return rootCmd.Execute()
+}
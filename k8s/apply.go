@@ -0,0 +1,409 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	runtimeschema "k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	fieldManager = "apigee-remote-service-cli"
+
+	// ApplySetLabel labels the resources of an apply set, as selected by Prune
+	ApplySetLabel = "apigee.cloud.google.com/apply-set"
+	// ApplyIDAnnotation annotates a resource of an apply set with the ID of
+	// the apply that last applied it
+	ApplyIDAnnotation = "apigee.cloud.google.com/apply-id"
+	// ApplySetKindsAnnotation annotates a resource of an apply set with the
+	// kinds of the set, as Kind.group, for Prune to find the resources of
+	// the kinds no longer applied
+	ApplySetKindsAnnotation = "apigee.cloud.google.com/apply-set-kinds"
+	// ApplySetNamespacesAnnotation annotates a resource of an apply set with
+	// the namespaces of the set, listed if listing all namespaces is forbidden
+	ApplySetNamespacesAnnotation = "apigee.cloud.google.com/apply-set-namespaces"
+)
+
+// ApplyOptions are the options of Apply and Prune
+type ApplyOptions struct {
+	Namespace string // of the namespaced resources without one, the one of the context if empty
+	Set       string // apply set labelling the resources, none if empty
+	ID        string // of the apply, annotating the resources of Set
+	Resume    bool   // skip the resources the cluster has with ID
+}
+
+// Apply creates or updates the resources of the YAML documents in data with
+// server-side apply, as kubectl apply --server-side --force-conflicts would.
+// It returns the resources applied and, on Resume, those skipped, as
+// "Kind namespace/name", or "Kind name" if cluster-scoped. The resources of
+// opts.Set are annotated with the kinds and namespaces of the set, those
+// applied and those the cluster has from previous applies.
+func (c *Cluster) Apply(data []byte, opts ApplyOptions) (applied, skipped []string, err error) {
+	var inv inventory
+	if opts.Set != "" {
+		if inv, _, err = c.setInventory(data, opts); err != nil {
+			return nil, nil, err
+		}
+	}
+	err = c.eachResource(data, opts, func(r clusterResource) error {
+		if opts.Resume && opts.ID != "" {
+			current, err := r.client.Get(context.Background(), r.name, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return err
+			}
+			if err == nil && current.GetAnnotations()[ApplyIDAnnotation] == opts.ID {
+				skipped = append(skipped, r.described)
+				return nil
+			}
+		}
+		if err := r.apply(opts, inv); err != nil {
+			return err
+		}
+		applied = append(applied, r.described)
+		return nil
+	})
+	return applied, skipped, err
+}
+
+// Prune deletes the resources of opts.Set not annotated with opts.ID, those
+// of previous applies, among the kinds and namespaces recorded by the set
+// and those of the resources in data. It returns the resources deleted.
+func (c *Cluster) Prune(data []byte, opts ApplyOptions) ([]string, error) {
+	if opts.Set == "" || opts.ID == "" {
+		return nil, fmt.Errorf("pruning requires an apply set and ID")
+	}
+	_, members, err := c.setInventory(data, opts)
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for _, m := range members {
+		if m.annotations[ApplyIDAnnotation] == opts.ID {
+			continue
+		}
+		described := describeResource(m.kind.Kind, m.namespace, m.name)
+		if err := m.client.Delete(context.Background(), m.name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			return pruned, fmt.Errorf("deleting %s: %v", described, err)
+		}
+		pruned = append(pruned, described)
+	}
+	return pruned, nil
+}
+
+// inventory is the kinds and namespaces of the resources of an apply set
+type inventory struct {
+	kinds      map[runtimeschema.GroupKind]bool
+	namespaces map[string]bool
+}
+
+func newInventory() inventory {
+	return inventory{kinds: map[runtimeschema.GroupKind]bool{}, namespaces: map[string]bool{}}
+}
+
+// add adds the kind and, if namespaced, the namespace of a resource
+func (inv inventory) add(kind runtimeschema.GroupKind, namespace string) {
+	inv.kinds[kind] = true
+	if namespace != "" {
+		inv.namespaces[namespace] = true
+	}
+}
+
+// addRecorded adds the kinds and namespaces of the set annotations
+func (inv inventory) addRecorded(annotations map[string]string) {
+	for _, kind := range strings.Split(annotations[ApplySetKindsAnnotation], ",") {
+		if kind != "" {
+			inv.kinds[runtimeschema.ParseGroupKind(kind)] = true
+		}
+	}
+	for _, namespace := range strings.Split(annotations[ApplySetNamespacesAnnotation], ",") {
+		if namespace != "" {
+			inv.namespaces[namespace] = true
+		}
+	}
+}
+
+func (inv inventory) size() int {
+	return len(inv.kinds) + len(inv.namespaces)
+}
+
+func (inv inventory) sortedKinds() []runtimeschema.GroupKind {
+	var kinds []runtimeschema.GroupKind
+	for kind := range inv.kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i].String() < kinds[j].String() })
+	return kinds
+}
+
+func (inv inventory) sortedNamespaces() []string {
+	var namespaces []string
+	for namespace := range inv.namespaces {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
+
+// annotate sets the set annotations of the inventory
+func (inv inventory) annotate(annotations map[string]interface{}) {
+	var kinds []string
+	for _, kind := range inv.sortedKinds() {
+		kinds = append(kinds, kind.String())
+	}
+	annotations[ApplySetKindsAnnotation] = strings.Join(kinds, ",")
+	annotations[ApplySetNamespacesAnnotation] = strings.Join(inv.sortedNamespaces(), ",")
+}
+
+// setMember is a resource of an apply set listed from the cluster
+type setMember struct {
+	kind        runtimeschema.GroupKind
+	namespace   string // "" if cluster-scoped
+	name        string
+	annotations map[string]string
+	client      dynamic.ResourceInterface
+}
+
+// setInventory returns the inventory of opts.Set, the kinds and namespaces
+// of the resources in data and of the resources of the set in the cluster,
+// and the latter. The kinds and namespaces recorded by the resources found
+// are listed in turn until none is new, so the kinds and namespaces no
+// longer applied are found from the resources still applied.
+func (c *Cluster) setInventory(data []byte, opts ApplyOptions) (inventory, []setMember, error) {
+	inv := newInventory()
+	if err := c.eachResource(data, opts, func(r clusterResource) error {
+		inv.add(r.groupKind, r.namespace)
+		return nil
+	}); err != nil {
+		return inventory{}, nil, err
+	}
+	recorded := newInventory()
+	for kind := range inv.kinds {
+		recorded.kinds[kind] = true
+	}
+	for namespace := range inv.namespaces {
+		recorded.namespaces[namespace] = true
+	}
+	lister := &setLister{
+		cluster:      c,
+		selector:     metav1.ListOptions{LabelSelector: ApplySetLabel + "=" + opts.Set},
+		listed:       map[string]bool{},
+		perNamespace: map[runtimeschema.GroupKind]bool{},
+	}
+	var members []setMember
+	for {
+		found, lists, err := lister.list(recorded)
+		if err != nil {
+			return inventory{}, nil, fmt.Errorf("listing apply set %s: %v", opts.Set, err)
+		}
+		if lists == 0 {
+			return inv, members, nil
+		}
+		for _, m := range found {
+			recorded.addRecorded(m.annotations)
+			inv.add(m.kind, m.namespace)
+		}
+		members = append(members, found...)
+	}
+}
+
+// setLister lists the resources of an apply set, each kind once in all
+// namespaces or, if forbidden, once in each namespace
+type setLister struct {
+	cluster      *Cluster
+	selector     metav1.ListOptions
+	listed       map[string]bool                  // kinds, or kinds in a namespace
+	perNamespace map[runtimeschema.GroupKind]bool // listing all namespaces is forbidden
+}
+
+// list lists the resources of the kinds and namespaces of inv not listed
+// yet, returning them and the number of lists. The kinds the cluster no
+// longer serves are skipped.
+func (l *setLister) list(inv inventory) (members []setMember, lists int, err error) {
+	for _, kind := range inv.sortedKinds() {
+		if l.listed[kind.String()] {
+			continue
+		}
+		mapping, err := l.cluster.mapper.RESTMapping(kind)
+		if meta.IsNoMatchError(err) {
+			l.listed[kind.String()] = true
+			continue
+		}
+		if err != nil {
+			return nil, lists, err
+		}
+		resource := l.cluster.dynamic.Resource(mapping.Resource)
+		namespaced := mapping.Scope.Name() == meta.RESTScopeNameNamespace
+		var items []unstructured.Unstructured
+		if !l.perNamespace[kind] {
+			lists++
+			list, err := resource.List(context.Background(), l.selector)
+			switch {
+			case namespaced && apierrors.IsForbidden(err):
+				l.perNamespace[kind] = true
+			case err != nil:
+				return nil, lists, fmt.Errorf("listing %s: %v", mapping.Resource.Resource, err)
+			default:
+				l.listed[kind.String()] = true
+				items = list.Items
+			}
+		}
+		if l.perNamespace[kind] {
+			for _, namespace := range inv.sortedNamespaces() {
+				key := kind.String() + " " + namespace
+				if l.listed[key] {
+					continue
+				}
+				lists++
+				list, err := resource.Namespace(namespace).List(context.Background(), l.selector)
+				if err != nil {
+					return nil, lists, fmt.Errorf("listing %s in %s: %v", mapping.Resource.Resource, namespace, err)
+				}
+				l.listed[key] = true
+				items = append(items, list.Items...)
+			}
+		}
+		for _, item := range items {
+			m := setMember{kind: kind, name: item.GetName(), annotations: item.GetAnnotations(), client: resource}
+			if namespaced {
+				m.namespace = item.GetNamespace()
+				m.client = resource.Namespace(m.namespace)
+			}
+			members = append(members, m)
+		}
+	}
+	return members, lists, nil
+}
+
+// clusterResource is a resource of a YAML document with its client
+type clusterResource struct {
+	doc       map[string]interface{}
+	kind      string
+	name      string
+	namespace string // "" if cluster-scoped
+	described string
+	groupKind runtimeschema.GroupKind
+	client    dynamic.ResourceInterface
+}
+
+// eachResource calls fn with the resources of the YAML documents in data
+func (c *Cluster) eachResource(data []byte, opts ApplyOptions, fn func(clusterResource) error) error {
+	namespace := opts.Namespace
+	if namespace == "" {
+		namespace = c.Namespace
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for i := 1; ; i++ {
+		var doc map[string]interface{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("document %d: %v", i, err)
+		}
+		if doc == nil {
+			continue
+		}
+		r, err := c.lookup(doc, namespace)
+		if err == nil {
+			err = fn(r)
+		}
+		if err != nil {
+			return fmt.Errorf("applying document %d%s: %v", i, describe(doc), err)
+		}
+	}
+}
+
+// lookup returns the resource of doc, its kind discovered from the API
+// server, in namespace if namespaced and without one
+func (c *Cluster) lookup(doc map[string]interface{}, namespace string) (clusterResource, error) {
+	apiVersion, _ := doc["apiVersion"].(string)
+	kind, _ := doc["kind"].(string)
+	metadata, _ := doc["metadata"].(map[string]interface{})
+	name, _ := metadata["name"].(string)
+	if apiVersion == "" || kind == "" || name == "" {
+		return clusterResource{}, fmt.Errorf("apiVersion, kind and metadata.name are required")
+	}
+	gv, err := runtimeschema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return clusterResource{}, err
+	}
+	mapping, err := c.mapper.RESTMapping(gv.WithKind(kind).GroupKind(), gv.Version)
+	if err != nil {
+		return clusterResource{}, err
+	}
+
+	r := clusterResource{doc: doc, kind: kind, name: name, groupKind: mapping.GroupVersionKind.GroupKind()}
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if ns, _ := metadata["namespace"].(string); ns != "" {
+			namespace = ns
+		}
+		metadata["namespace"] = namespace
+		r.namespace = namespace
+		r.client = c.dynamic.Resource(mapping.Resource).Namespace(namespace)
+	} else {
+		delete(metadata, "namespace")
+		r.client = c.dynamic.Resource(mapping.Resource)
+	}
+	r.described = describeResource(kind, r.namespace, name)
+	return r, nil
+}
+
+// apply applies the resource, labelled and annotated as of opts.Set and its
+// inventory
+func (r clusterResource) apply(opts ApplyOptions, inv inventory) error {
+	if opts.Set != "" {
+		metadata := r.doc["metadata"].(map[string]interface{})
+		labels, _ := metadata["labels"].(map[string]interface{})
+		if labels == nil {
+			labels = map[string]interface{}{}
+		}
+		labels[ApplySetLabel] = opts.Set
+		metadata["labels"] = labels
+		annotations, _ := metadata["annotations"].(map[string]interface{})
+		if annotations == nil {
+			annotations = map[string]interface{}{}
+		}
+		annotations[ApplyIDAnnotation] = opts.ID
+		inv.annotate(annotations)
+		metadata["annotations"] = annotations
+	}
+	body, err := yaml.Marshal(r.doc)
+	if err != nil {
+		return err
+	}
+	force := true
+	_, err = r.client.Patch(context.Background(), r.name, types.ApplyPatchType, body,
+		metav1.PatchOptions{FieldManager: fieldManager, Force: &force})
+	return err
+}
+
+func describeResource(kind, namespace, name string) string {
+	if namespace == "" {
+		return kind + " " + name
+	}
+	return fmt.Sprintf("%s %s/%s", kind, namespace, name)
+}
@@ -0,0 +1,311 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// writeTestKubeconfig writes a kubeconfig of the TLS server with a token user
+func writeTestKubeconfig(t *testing.T, dir, server string) string {
+	file := filepath.Join(dir, "kubeconfig")
+	config := `apiVersion: v1
+kind: Config
+current-context: dev
+contexts:
+- name: dev
+  context: {cluster: dev, user: dev, namespace: apigee}
+- name: prod
+  context: {cluster: prod, user: dev}
+clusters:
+- name: dev
+  cluster: {server: "` + server + `/", insecure-skip-tls-verify: true}
+users:
+- name: dev
+  user: {token: t0ken}
+`
+	if err := ioutil.WriteFile(file, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestApply(t *testing.T) {
+	var calls []string
+	ts := httptest.NewTLSServer(testutil.KubeDiscovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.RequestURI())
+		if r.Header.Get("Authorization") != "Bearer t0ken" || r.Header.Get("Content-Type") != string(types.ApplyPatchType) {
+			t.Errorf("want token and apply patch, got %v", r.Header)
+		}
+		doc := map[string]interface{}{}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := yaml.Unmarshal(body, &doc); err != nil {
+			t.Errorf("want YAML body: %v", err)
+		}
+		if strings.Contains(r.URL.Path, "forbidden") {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","code":403,"message":"secrets is forbidden"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(doc)
+	})))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := writeTestKubeconfig(t, dir, ts.URL)
+
+	cluster, err := LoadCluster(file, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cluster.Context != "dev" || cluster.Namespace != "apigee" || cluster.Server != ts.URL {
+		t.Errorf("want current context dev of %s in apigee, got %#v", ts.URL, cluster)
+	}
+
+	applied, _, err := cluster.Apply([]byte(`apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  config.yaml: "tenant: {}"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: keys
+  namespace: apigee
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: apigee
+---
+apiVersion: apigee.cloud.google.com/v1alpha1
+kind: ApigeeRemoteService
+metadata:
+  name: test
+`), ApplyOptions{})
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if want := []string{"ConfigMap apigee/config", "Secret apigee/keys", "Namespace apigee",
+		"ApigeeRemoteService apigee/test"}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("want applied %v, got %v", want, applied)
+	}
+	if want := []string{
+		"PATCH /api/v1/namespaces/apigee/configmaps/config?fieldManager=apigee-remote-service-cli&force=true",
+		"PATCH /api/v1/namespaces/apigee/secrets/keys?fieldManager=apigee-remote-service-cli&force=true",
+		"PATCH /api/v1/namespaces/apigee?fieldManager=apigee-remote-service-cli&force=true",
+		"PATCH /apis/apigee.cloud.google.com/v1alpha1/namespaces/apigee/apigeeremoteservices/test?fieldManager=apigee-remote-service-cli&force=true",
+	}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("want calls %v, got %v", want, calls)
+	}
+
+	_, _, err = cluster.Apply([]byte("apiVersion: v1\nkind: Secret\nmetadata:\n  name: forbidden\n"), ApplyOptions{Namespace: "ns"})
+	testutil.ErrorContains(t, err, "applying document 1 (Secret forbidden): secrets is forbidden")
+	_, _, err = cluster.Apply([]byte("apiVersion: example.com/v1\nkind: Widget\nmetadata:\n  name: w\n"), ApplyOptions{Namespace: "ns"})
+	testutil.ErrorContains(t, err, `applying document 1 (Widget w): no matches for kind "Widget" in version "example.com/v1"`)
+
+	_, err = LoadCluster(file, "prod")
+	testutil.ErrorContains(t, err, "cluster prod of context prod has no server")
+	_, err = LoadCluster(file, "none")
+	testutil.ErrorContains(t, err, "context none not found")
+}
+
+func TestApplySet(t *testing.T) {
+	// the objects by path, listed by the label selector of the apply set in
+	// a namespace or in all unless restricted
+	objects := map[string]map[string]interface{}{}
+	restricted := false
+	namespaced := regexp.MustCompile(`/namespaces/[^/]+/`)
+	ts := httptest.NewTLSServer(testutil.KubeDiscovery(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPatch:
+			doc := map[string]interface{}{}
+			body, _ := ioutil.ReadAll(r.Body)
+			if err := yaml.Unmarshal(body, &doc); err != nil {
+				t.Errorf("want YAML body: %v", err)
+			}
+			if strings.HasSuffix(r.URL.Path, "/fail") {
+				w.WriteHeader(http.StatusInternalServerError)
+				_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","code":500,"message":"etcd unavailable"}`))
+				return
+			}
+			objects[r.URL.Path] = doc
+			_ = json.NewEncoder(w).Encode(doc)
+		case r.Method == http.MethodDelete:
+			delete(objects, r.URL.Path)
+			_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Success"}`))
+		case r.URL.Query().Get("labelSelector") != "":
+			allNamespaces := !strings.Contains(r.URL.Path, "/namespaces/")
+			if restricted && allNamespaces {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","code":403,"reason":"Forbidden"}`))
+				return
+			}
+			var items []map[string]interface{}
+			for path, doc := range objects {
+				labels := doc["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+				dir := filepath.Dir(path)
+				if allNamespaces {
+					dir = namespaced.ReplaceAllString(dir, "/")
+				}
+				if dir == r.URL.Path && ApplySetLabel+"="+labels[ApplySetLabel].(string) == r.URL.Query().Get("labelSelector") {
+					items = append(items, doc)
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"apiVersion": "v1", "kind": "List", "items": items})
+		default:
+			doc, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","code":404,"reason":"NotFound"}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(doc)
+		}
+	})))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cluster, err := LoadCluster(writeTestKubeconfig(t, dir, ts.URL), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resources := func(kind string, names ...string) []byte {
+		var docs []string
+		for _, name := range names {
+			docs = append(docs, "apiVersion: v1\nkind: "+kind+"\nmetadata:\n  name: "+name+"\n")
+		}
+		return []byte(strings.Join(docs, "---\n"))
+	}
+	configMaps := func(names ...string) []byte {
+		return resources("ConfigMap", names...)
+	}
+	annotationsOf := func(path string) interface{} {
+		return objects[path]["metadata"].(map[string]interface{})["annotations"]
+	}
+	first := ApplyOptions{Set: "set", ID: "1"}
+	if _, _, err := cluster.Apply(configMaps("a", "old"), first); err != nil {
+		t.Fatal(err)
+	}
+	annotations := annotationsOf("/api/v1/namespaces/apigee/configmaps/a")
+	if want := map[string]interface{}{ApplyIDAnnotation: "1", ApplySetKindsAnnotation: "ConfigMap",
+		ApplySetNamespacesAnnotation: "apigee"}; !reflect.DeepEqual(annotations, want) {
+		t.Errorf("want annotations %v, got %v", want, annotations)
+	}
+
+	// a failed apply is resumed, skipping the resources it applied
+	second := ApplyOptions{Set: "set", ID: "2"}
+	applied, _, err := cluster.Apply(configMaps("a", "fail", "b"), second)
+	testutil.ErrorContains(t, err, "applying document 2 (ConfigMap fail): etcd unavailable")
+	if want := []string{"ConfigMap apigee/a"}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("want applied %v, got %v", want, applied)
+	}
+	second.Resume = true
+	applied, skipped, err := cluster.Apply(configMaps("a", "b"), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(applied, []string{"ConfigMap apigee/b"}) || !reflect.DeepEqual(skipped, []string{"ConfigMap apigee/a"}) {
+		t.Errorf("want b applied and a skipped, got %v and %v", applied, skipped)
+	}
+
+	pruned, err := cluster.Prune(configMaps("a", "b"), second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ConfigMap apigee/old"}; !reflect.DeepEqual(pruned, want) {
+		t.Errorf("want pruned %v, got %v", want, pruned)
+	}
+	if _, ok := objects["/api/v1/namespaces/apigee/configmaps/old"]; ok || len(objects) != 2 {
+		t.Errorf("want a and b left, got %v", objects)
+	}
+
+	// the kinds shrink: the Secret applied before is found from the kinds
+	// recorded by the ConfigMap, in the namespaces recorded if listing all
+	// namespaces is forbidden
+	third := ApplyOptions{Set: "set", ID: "3"}
+	if _, _, err := cluster.Apply(append(configMaps("a"), "---\n"+string(resources("Secret", "s"))...), third); err != nil {
+		t.Fatal(err)
+	}
+	recorded := annotationsOf("/api/v1/namespaces/apigee/configmaps/a").(map[string]interface{})
+	if recorded[ApplySetKindsAnnotation] != "ConfigMap,Secret" {
+		t.Errorf("want ConfigMap and Secret kinds, got %v", recorded)
+	}
+	restricted = true
+	fourth := ApplyOptions{Set: "set", ID: "4"}
+	if _, _, err := cluster.Apply(configMaps("a"), fourth); err != nil {
+		t.Fatal(err)
+	}
+	pruned, err = cluster.Prune(configMaps("a"), fourth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(pruned)
+	if want := []string{"ConfigMap apigee/b", "Secret apigee/s"}; !reflect.DeepEqual(pruned, want) {
+		t.Errorf("want pruned %v, got %v", want, pruned)
+	}
+
+	// the namespace changes: the resources of the previous one are pruned
+	// and the kinds no longer applied are dropped from the inventory
+	restricted = false
+	fifth := ApplyOptions{Set: "set", ID: "5", Namespace: "other"}
+	if _, _, err := cluster.Apply(configMaps("c"), fifth); err != nil {
+		t.Fatal(err)
+	}
+	if pruned, err = cluster.Prune(configMaps("c"), fifth); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"ConfigMap apigee/a"}; !reflect.DeepEqual(pruned, want) {
+		t.Errorf("want pruned %v, got %v", want, pruned)
+	}
+	if want := map[string]interface{}{ApplyIDAnnotation: "5", ApplySetKindsAnnotation: "ConfigMap",
+		ApplySetNamespacesAnnotation: "apigee,other"}; !reflect.DeepEqual(annotationsOf("/api/v1/namespaces/other/configmaps/c"), want) {
+		t.Errorf("want annotations %v, got %v", want, annotationsOf("/api/v1/namespaces/other/configmaps/c"))
+	}
+	if len(objects) != 1 {
+		t.Errorf("want c left, got %v", objects)
+	}
+
+	_, err = cluster.Prune(configMaps("a"), ApplyOptions{})
+	testutil.ErrorContains(t, err, "pruning requires an apply set and ID")
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// Cluster is a Kubernetes API server reached per a kubeconfig context
+type Cluster struct {
+	Context   string
+	Server    string
+	Namespace string // of the context, "default" if none
+
+	clientset kubernetes.Interface
+	dynamic   dynamic.Interface
+	mapper    meta.RESTMapper // kinds to resources, discovered once needed
+}
+
+// LoadCluster reads the kubeconfig file, the one of kubectl ($KUBECONFIG or
+// ~/.kube/config) if empty, and returns the cluster of the named context,
+// the current context if empty
+func LoadCluster(file, context string) (*Cluster, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	name := rules.GetDefaultFilename()
+	if file != "" {
+		rules.ExplicitPath = file
+		name = file
+	}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: context})
+	raw, err := clientConfig.RawConfig()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading kubeconfig")
+	}
+	if context == "" {
+		context = raw.CurrentContext
+	}
+	if context == "" {
+		return nil, fmt.Errorf("kubeconfig %s has no current context, use --context", name)
+	}
+	ctx, ok := raw.Contexts[context]
+	if !ok {
+		return nil, fmt.Errorf("context %s not found in kubeconfig %s", context, name)
+	}
+	if cluster, ok := raw.Clusters[ctx.Cluster]; !ok || cluster.Server == "" {
+		return nil, fmt.Errorf("cluster %s of context %s has no server in kubeconfig %s", ctx.Cluster, context, name)
+	}
+
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading context %s of kubeconfig %s", context, name)
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, errors.Wrapf(err, "loading context %s of kubeconfig %s", context, name)
+	}
+	// the limits of kubectl, the client-go defaults throttle listing the
+	// kinds of an apply set
+	config.QPS, config.Burst = 50, 300
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating client of context %s", context)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating client of context %s", context)
+	}
+	return &Cluster{
+		Context:   context,
+		Server:    strings.TrimSuffix(config.Host, "/"),
+		Namespace: namespace,
+		clientset: clientset,
+		dynamic:   dynamicClient,
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery())),
+	}, nil
+}
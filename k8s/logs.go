@@ -0,0 +1,90 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LogLine is a line logged by a container, timestamped by Kubernetes
+type LogLine struct {
+	Pod       string
+	Container string
+	Time      time.Time
+	Text      string
+}
+
+// PodLogs returns the lines logged from since to until by the container of
+// the pods of the label selector, all their containers if container is
+// empty, ordered by time. Namespace is the one of the context if empty.
+func (c *Cluster) PodLogs(namespace, selector, container string, since, until time.Time) ([]LogLine, error) {
+	if namespace == "" {
+		namespace = c.Namespace
+	}
+	pods, err := c.clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("listing pods %s in %s: %v", selector, namespace, err)
+	}
+
+	var lines []LogLine
+	for _, pod := range pods.Items {
+		for _, cont := range pod.Spec.Containers {
+			if container != "" && cont.Name != container {
+				continue
+			}
+			logs, err := c.containerLogs(namespace, pod.Name, cont.Name, since, until)
+			if err != nil {
+				return nil, fmt.Errorf("logs of %s/%s: %v", pod.Name, cont.Name, err)
+			}
+			lines = append(lines, logs...)
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].Time.Before(lines[j].Time) })
+	return lines, nil
+}
+
+func (c *Cluster) containerLogs(namespace, pod, container string, since, until time.Time) ([]LogLine, error) {
+	sinceTime := metav1.NewTime(since)
+	stream, err := c.clientset.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{
+		Container:  container,
+		SinceTime:  &sinceTime,
+		Timestamps: true,
+	}).Stream(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	var lines []LogLine
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		split := strings.SplitN(scanner.Text(), " ", 2)
+		t, err := time.Parse(time.RFC3339Nano, split[0])
+		if err != nil || len(split) < 2 || t.After(until) {
+			continue
+		}
+		lines = append(lines, LogLine{Pod: pod, Container: container, Time: t, Text: split[1]})
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestPodLogs(t *testing.T) {
+	m := http.NewServeMux()
+	m.HandleFunc("/api/v1/namespaces/apigee/pods", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("labelSelector") != "app=adapter" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"items": [
+  {"metadata": {"name": "a"}, "spec": {"containers": [{"name": "adapter"}, {"name": "sidecar"}]}},
+  {"metadata": {"name": "b"}, "spec": {"containers": [{"name": "adapter"}]}}
+]}`))
+	})
+	m.HandleFunc("/api/v1/namespaces/apigee/pods/a/log", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("container") != "adapter" || q.Get("timestamps") != "true" || q.Get("sinceTime") != "2020-10-14T12:00:00Z" {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+		}
+		_, _ = w.Write([]byte("2020-10-14T12:00:02.5Z second\n2020-10-14T12:00:09Z too late\n"))
+	})
+	m.HandleFunc("/api/v1/namespaces/apigee/pods/b/log", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("2020-10-14T12:00:01Z first line\nnot timestamped\n"))
+	})
+	m.HandleFunc("/api/v1/namespaces/forbidden/pods", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","code":403,"message":"pods is forbidden"}`))
+	})
+	ts := httptest.NewTLSServer(testutil.KubeDiscovery(m))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cluster, err := LoadCluster(writeTestKubeconfig(t, dir, ts.URL), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	since := time.Date(2020, 10, 14, 12, 0, 0, 0, time.UTC)
+	lines, err := cluster.PodLogs("", "app=adapter", "adapter", since, since.Add(5*time.Second))
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	want := []LogLine{
+		{Pod: "b", Container: "adapter", Time: since.Add(time.Second), Text: "first line"},
+		{Pod: "a", Container: "adapter", Time: since.Add(2500 * time.Millisecond), Text: "second"},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("want %v, got %v", want, lines)
+	}
+
+	_, err = cluster.PodLogs("forbidden", "app=adapter", "", since, since)
+	testutil.ErrorContains(t, err, "listing pods app=adapter in forbidden: pods is forbidden")
+}
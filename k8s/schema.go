@@ -0,0 +1,352 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+// schema is a subset of the Kubernetes OpenAPI schema sufficient to
+// check the resources emitted by this CLI. Subtrees not used by the
+// CLI are declared as anyType and not checked.
+
+type schemaType int
+
+const (
+	anyType schemaType = iota
+	objectType
+	arrayType
+	mapType
+	stringType
+	intType
+	boolType
+	intOrStringType
+	quantityType
+	base64Type
+)
+
+type schema struct {
+	typ      schemaType
+	props    map[string]*schema // objectType
+	items    *schema            // arrayType, mapType
+	required []string           // objectType
+	enum     []string           // stringType
+	since    int                // minor version the field was added, 0 if always present
+	removed  int                // minor version the field was removed, 0 if present
+}
+
+var (
+	anything = &schema{typ: anyType}
+	str      = &schema{typ: stringType}
+	integer  = &schema{typ: intType}
+	boolean  = &schema{typ: boolType}
+	intOrStr = &schema{typ: intOrStringType}
+	quantity = &schema{typ: quantityType}
+	b64      = &schema{typ: base64Type}
+)
+
+func object(props map[string]*schema, required ...string) *schema {
+	return &schema{typ: objectType, props: props, required: required}
+}
+
+func array(items *schema) *schema {
+	return &schema{typ: arrayType, items: items}
+}
+
+func mapOf(items *schema) *schema {
+	return &schema{typ: mapType, items: items}
+}
+
+func enum(values ...string) *schema {
+	return &schema{typ: stringType, enum: values}
+}
+
+// addedIn returns a copy of s that is only valid from minor version v
+func (s *schema) addedIn(v int) *schema {
+	c := *s
+	c.since = v
+	return &c
+}
+
+// removedIn returns a copy of s that is no longer valid from minor version v
+func (s *schema) removedIn(v int) *schema {
+	c := *s
+	c.removed = v
+	return &c
+}
+
+// resource is a kind as served by an apiVersion
+type resource struct {
+	since   int
+	removed int
+	schema  *schema
+}
+
+type resourceKey struct {
+	apiVersion string
+	kind       string
+}
+
+var (
+	objectMeta = object(map[string]*schema{
+		"annotations":                mapOf(str),
+		"creationTimestamp":          anything,
+		"deletionGracePeriodSeconds": integer,
+		"deletionTimestamp":          anything,
+		"finalizers":                 array(str),
+		"generateName":               str,
+		"generation":                 integer,
+		"labels":                     mapOf(str),
+		"managedFields":              anything,
+		"name":                       str,
+		"namespace":                  str,
+		"ownerReferences":            anything,
+		"resourceVersion":            str,
+		"selfLink":                   str,
+		"uid":                        str,
+	})
+
+	labelSelector = object(map[string]*schema{
+		"matchExpressions": anything,
+		"matchLabels":      mapOf(str),
+	})
+
+	protocol = enum("TCP", "UDP", "SCTP")
+
+	envVar = object(map[string]*schema{
+		"name":      str,
+		"value":     str,
+		"valueFrom": anything,
+	}, "name")
+
+	container = object(map[string]*schema{
+		"args":            array(str),
+		"command":         array(str),
+		"env":             array(envVar),
+		"envFrom":         anything,
+		"image":           str,
+		"imagePullPolicy": enum("Always", "IfNotPresent", "Never"),
+		"lifecycle":       anything,
+		"livenessProbe":   anything,
+		"name":            str,
+		"ports": array(object(map[string]*schema{
+			"containerPort": integer,
+			"hostIP":        str,
+			"hostPort":      integer,
+			"name":          str,
+			"protocol":      protocol,
+		}, "containerPort")),
+		"readinessProbe": anything,
+		"resizePolicy":   anything.addedIn(27),
+		"resources": object(map[string]*schema{
+			"claims":   anything.addedIn(26),
+			"limits":   mapOf(quantity),
+			"requests": mapOf(quantity),
+		}),
+		"securityContext":          anything,
+		"startupProbe":             anything,
+		"stdin":                    boolean,
+		"stdinOnce":                boolean,
+		"terminationMessagePath":   str,
+		"terminationMessagePolicy": enum("File", "FallbackToLogsOnError"),
+		"tty":                      boolean,
+		"volumeDevices":            anything,
+		"volumeMounts": array(object(map[string]*schema{
+			"mountPath":        str,
+			"mountPropagation": str,
+			"name":             str,
+			"readOnly":         boolean,
+			"subPath":          str,
+			"subPathExpr":      str,
+		}, "mountPath", "name")),
+		"workingDir": str,
+	}, "name")
+
+	keyToPath = array(object(map[string]*schema{
+		"key":  str,
+		"mode": integer,
+		"path": str,
+	}, "key", "path"))
+
+	volume = object(map[string]*schema{
+		"name": str,
+		"configMap": object(map[string]*schema{
+			"defaultMode": integer,
+			"items":       keyToPath,
+			"name":        str,
+			"optional":    boolean,
+		}),
+		"secret": object(map[string]*schema{
+			"defaultMode": integer,
+			"items":       keyToPath,
+			"optional":    boolean,
+			"secretName":  str,
+		}),
+		"awsElasticBlockStore":  anything,
+		"azureDisk":             anything,
+		"azureFile":             anything,
+		"cephfs":                anything,
+		"cinder":                anything,
+		"csi":                   anything,
+		"downwardAPI":           anything,
+		"emptyDir":              anything,
+		"ephemeral":             anything.addedIn(19),
+		"fc":                    anything,
+		"flexVolume":            anything,
+		"flocker":               anything,
+		"gcePersistentDisk":     anything,
+		"gitRepo":               anything,
+		"glusterfs":             anything,
+		"hostPath":              anything,
+		"iscsi":                 anything,
+		"nfs":                   anything,
+		"persistentVolumeClaim": anything,
+		"photonPersistentDisk":  anything,
+		"portworxVolume":        anything,
+		"projected":             anything,
+		"quobyte":               anything,
+		"rbd":                   anything,
+		"scaleIO":               anything,
+		"storageos":             anything,
+		"vsphereVolume":         anything,
+	}, "name")
+
+	podSpec = object(map[string]*schema{
+		"activeDeadlineSeconds":         integer,
+		"affinity":                      anything,
+		"automountServiceAccountToken":  boolean,
+		"containers":                    array(container),
+		"dnsConfig":                     anything,
+		"dnsPolicy":                     str,
+		"enableServiceLinks":            boolean,
+		"ephemeralContainers":           anything,
+		"hostAliases":                   anything,
+		"hostIPC":                       boolean,
+		"hostNetwork":                   boolean,
+		"hostPID":                       boolean,
+		"hostUsers":                     boolean.addedIn(25),
+		"hostname":                      str,
+		"imagePullSecrets":              array(object(map[string]*schema{"name": str})),
+		"initContainers":                array(container),
+		"nodeName":                      str,
+		"nodeSelector":                  mapOf(str),
+		"os":                            anything.addedIn(23),
+		"overhead":                      mapOf(quantity),
+		"preemptionPolicy":              str,
+		"priority":                      integer,
+		"priorityClassName":             str,
+		"readinessGates":                anything,
+		"restartPolicy":                 enum("Always", "OnFailure", "Never"),
+		"runtimeClassName":              str,
+		"schedulerName":                 str,
+		"securityContext":               anything,
+		"serviceAccount":                str,
+		"serviceAccountName":            str,
+		"setHostnameAsFQDN":             boolean.addedIn(19),
+		"shareProcessNamespace":         boolean,
+		"subdomain":                     str,
+		"terminationGracePeriodSeconds": integer,
+		"tolerations":                   anything,
+		"topologySpreadConstraints":     anything,
+		"volumes":                       array(volume),
+	}, "containers")
+
+	deploymentSpec = object(map[string]*schema{
+		"minReadySeconds":         integer,
+		"paused":                  boolean,
+		"progressDeadlineSeconds": integer,
+		"replicas":                integer,
+		"revisionHistoryLimit":    integer,
+		"selector":                labelSelector,
+		"strategy":                anything,
+		"template": object(map[string]*schema{
+			"metadata": objectMeta,
+			"spec":     podSpec,
+		}),
+	}, "selector", "template")
+
+	serviceSpec = object(map[string]*schema{
+		"allocateLoadBalancerNodePorts": boolean.addedIn(20),
+		"clusterIP":                     str,
+		"clusterIPs":                    array(str).addedIn(20),
+		"externalIPs":                   array(str),
+		"externalName":                  str,
+		"externalTrafficPolicy":         str,
+		"healthCheckNodePort":           integer,
+		"internalTrafficPolicy":         str.addedIn(21),
+		"ipFamilies":                    array(str).addedIn(20),
+		"ipFamilyPolicy":                str.addedIn(20),
+		"loadBalancerClass":             str.addedIn(21),
+		"loadBalancerIP":                str,
+		"loadBalancerSourceRanges":      array(str),
+		"ports": array(object(map[string]*schema{
+			"appProtocol": str.addedIn(19),
+			"name":        str,
+			"nodePort":    integer,
+			"port":        integer,
+			"protocol":    protocol,
+			"targetPort":  intOrStr,
+		}, "port")),
+		"publishNotReadyAddresses": boolean,
+		"selector":                 mapOf(str),
+		"sessionAffinity":          enum("ClientIP", "None"),
+		"sessionAffinityConfig":    anything,
+		"topologyKeys":             array(str).addedIn(17).removedIn(22),
+		"type":                     enum("ClusterIP", "NodePort", "LoadBalancer", "ExternalName"),
+	})
+)
+
+// top returns the schema of a top level resource with the given fields
+func top(props map[string]*schema) *schema {
+	props["apiVersion"] = str
+	props["kind"] = str
+	props["metadata"] = objectMeta
+	return object(props, "apiVersion", "kind", "metadata")
+}
+
+var resources = map[resourceKey]resource{
+	{"v1", "ConfigMap"}: {schema: top(map[string]*schema{
+		"binaryData": mapOf(b64),
+		"data":       mapOf(str),
+		"immutable":  boolean.addedIn(18),
+	})},
+	{"v1", "Secret"}: {schema: top(map[string]*schema{
+		"data":       mapOf(b64),
+		"immutable":  boolean.addedIn(18),
+		"stringData": mapOf(str),
+		"type":       str,
+	})},
+	{"v1", "Service"}: {schema: top(map[string]*schema{
+		"spec":   serviceSpec,
+		"status": anything,
+	})},
+	{"v1", "ServiceAccount"}: {schema: top(map[string]*schema{
+		"automountServiceAccountToken": boolean,
+		"imagePullSecrets":             array(object(map[string]*schema{"name": str})),
+		"secrets":                      anything,
+	})},
+	{"apps/v1", "Deployment"}: {since: 9, schema: top(map[string]*schema{
+		"spec":   deploymentSpec,
+		"status": anything,
+	})},
+	{"apps/v1beta1", "Deployment"}: {removed: 16, schema: top(map[string]*schema{
+		"spec":   deploymentSpec,
+		"status": anything,
+	})},
+	{"apps/v1beta2", "Deployment"}: {removed: 16, schema: top(map[string]*schema{
+		"spec":   deploymentSpec,
+		"status": anything,
+	})},
+	{"extensions/v1beta1", "Deployment"}: {removed: 16, schema: top(map[string]*schema{
+		"spec":   deploymentSpec,
+		"status": anything,
+	})},
+}
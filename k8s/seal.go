@@ -0,0 +1,132 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+)
+
+const sessionKeyBytes = 32
+
+// SealedSecret is a Secret encrypted for the controller of Bitnami Sealed
+// Secrets, which decrypts it into the Secret of its template
+type SealedSecret struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Metadata   SealedMetadata   `yaml:"metadata"`
+	Spec       SealedSecretSpec `yaml:"spec"`
+}
+
+// SealedMetadata is the metadata of a SealedSecret and of its Secret
+type SealedMetadata struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace"`
+}
+
+// SealedSecretSpec holds the encrypted data of a SealedSecret
+type SealedSecretSpec struct {
+	EncryptedData map[string]string `yaml:"encryptedData"`
+	Template      struct {
+		Metadata SealedMetadata `yaml:"metadata"`
+		Type     string         `yaml:"type,omitempty"`
+	} `yaml:"template"`
+}
+
+// Seal encrypts the data of the Secret namespace/name with the public key of
+// the PEM certificate of the controller, as kubeseal prints it with
+// --fetch-cert. The SealedSecret is strict scoped: it can't be renamed or
+// moved to another namespace.
+func Seal(certPEM []byte, namespace, name, secretType string, data map[string][]byte) (*SealedSecret, error) {
+	key, err := parseSealingKey(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if namespace == "" {
+		return nil, fmt.Errorf("sealing %s: namespace is required", name)
+	}
+
+	s := &SealedSecret{
+		APIVersion: "bitnami.com/v1alpha1",
+		Kind:       "SealedSecret",
+		Metadata:   SealedMetadata{Name: name, Namespace: namespace},
+	}
+	s.Spec.EncryptedData = map[string]string{}
+	s.Spec.Template.Metadata = s.Metadata
+	s.Spec.Template.Type = secretType
+	label := []byte(namespace + "/" + name)
+	for k, v := range data {
+		ciphertext, err := hybridEncrypt(rand.Reader, key, v, label)
+		if err != nil {
+			return nil, fmt.Errorf("sealing %s of %s: %v", k, name, err)
+		}
+		s.Spec.EncryptedData[k] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+	return s, nil
+}
+
+func parseSealingKey(certPEM []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("sealed secrets certificate: no PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("sealed secrets certificate: %v", err)
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("sealed secrets certificate: want an RSA public key, got %T", cert.PublicKey)
+	}
+	return key, nil
+}
+
+// hybridEncrypt encrypts plaintext with a random AES-GCM session key, itself
+// encrypted with RSA-OAEP: the length of the encrypted session key (2 bytes,
+// big endian), the encrypted session key and the encrypted plaintext
+func hybridEncrypt(rnd io.Reader, key *rsa.PublicKey, plaintext, label []byte) ([]byte, error) {
+	sessionKey := make([]byte, sessionKeyBytes)
+	if _, err := io.ReadFull(rnd, sessionKey); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rnd, key, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := make([]byte, 2, 2+len(encryptedKey)+len(plaintext)+gcm.Overhead())
+	binary.BigEndian.PutUint16(ciphertext, uint16(len(encryptedKey)))
+	ciphertext = append(ciphertext, encryptedKey...)
+	// the session key is used once, a zero nonce is safe
+	nonce := make([]byte, gcm.NonceSize())
+	return gcm.Seal(ciphertext, nonce, plaintext, nil), nil
+}
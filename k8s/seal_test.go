@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestSeal(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sealed-secret"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	s, err := Seal(certPEM, "apigee", "secret", "Opaque", map[string][]byte{"remote-service.key": []byte("private")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Kind != "SealedSecret" || s.Spec.Template.Metadata.Name != "secret" || s.Spec.Template.Type != "Opaque" {
+		t.Errorf("unexpected sealed secret: %#v", s)
+	}
+
+	// decrypt as the controller does
+	ciphertext, err := base64.StdEncoding.DecodeString(s.Spec.EncryptedData["remote-service.key"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := int(binary.BigEndian.Uint16(ciphertext))
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext[2:2+n], []byte("apigee/secret"))
+	if err != nil {
+		t.Fatalf("want session key strict scoped to apigee/secret: %v", err)
+	}
+	block, _ := aes.NewCipher(sessionKey)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, make([]byte, gcm.NonceSize()), ciphertext[2+n:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "private" {
+		t.Errorf("want private, got %q", plaintext)
+	}
+
+	_, err = Seal([]byte("not a cert"), "apigee", "secret", "Opaque", nil)
+	testutil.ErrorContains(t, err, "sealed secrets certificate: no PEM certificate")
+	_, err = Seal(certPEM, "", "secret", "Opaque", nil)
+	testutil.ErrorContains(t, err, "sealing secret: namespace is required")
+}
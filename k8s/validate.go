@@ -0,0 +1,275 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v3"
+)
+
+// supported Kubernetes minor versions (major is always 1)
+const (
+	minMinorVersion = 16
+	maxMinorVersion = 30
+)
+
+var quantityRE = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+|[KMGTPE]i|[mkMGTPE])?$`)
+
+// ParseVersion parses a Kubernetes version such as "1.21", "v1.21" or "1.21.3"
+// and returns the minor version
+func ParseVersion(version string) (int, error) {
+	v := strings.TrimPrefix(version, "v")
+	parts := strings.Split(v, ".")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "1" {
+		return 0, fmt.Errorf("invalid Kubernetes version %q, want eg. 1.21", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid Kubernetes version %q, want eg. 1.21", version)
+	}
+	if minor < minMinorVersion || minor > maxMinorVersion {
+		return 0, fmt.Errorf("unsupported Kubernetes version %q, want 1.%d to 1.%d",
+			version, minMinorVersion, maxMinorVersion)
+	}
+	return minor, nil
+}
+
+// Validate checks the YAML documents in data against the schemas
+// of the given Kubernetes version
+func Validate(data []byte, version string) error {
+	minor, err := ParseVersion(version)
+	if err != nil {
+		return err
+	}
+
+	var errs error
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for i := 1; ; i++ {
+		var doc interface{}
+		if err := decoder.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("document %d: %v", i, err)
+		}
+		if doc == nil {
+			continue
+		}
+		for _, err := range multierr.Errors(validateResource(doc, minor)) {
+			errs = multierr.Append(errs, fmt.Errorf("document %d%s: %v", i, describe(doc), err))
+		}
+	}
+	return errs
+}
+
+func describe(doc interface{}) string {
+	m, _ := doc.(map[string]interface{})
+	kind, _ := m["kind"].(string)
+	meta, _ := m["metadata"].(map[string]interface{})
+	name, _ := meta["name"].(string)
+	if kind == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (%s %s)", kind, name)
+}
+
+func validateResource(doc interface{}, minor int) error {
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("want object, got %s", typeName(doc))
+	}
+	apiVersion, _ := m["apiVersion"].(string)
+	kind, _ := m["kind"].(string)
+	if apiVersion == "" || kind == "" {
+		return fmt.Errorf("apiVersion and kind are required")
+	}
+
+	res, ok := resources[resourceKey{apiVersion, kind}]
+	if !ok {
+		return fmt.Errorf("unknown kind %s in %s", kind, apiVersion)
+	}
+	if res.removed != 0 && minor >= res.removed {
+		return fmt.Errorf("%s %s is not served by Kubernetes 1.%d (removed in 1.%d)", apiVersion, kind, minor, res.removed)
+	}
+	if minor < res.since {
+		return fmt.Errorf("%s %s is not served by Kubernetes 1.%d (added in 1.%d)", apiVersion, kind, minor, res.since)
+	}
+
+	errs := validate("", m, res.schema, minor)
+	if meta, ok := m["metadata"].(map[string]interface{}); ok && meta["name"] == nil && meta["generateName"] == nil {
+		errs = multierr.Append(errs, fmt.Errorf("metadata.name: required"))
+	}
+	return errs
+}
+
+func validate(path string, v interface{}, s *schema, minor int) error {
+	if v == nil || s.typ == anyType {
+		return nil
+	}
+
+	switch s.typ {
+	case objectType:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return typeError(path, "object", v)
+		}
+		var errs error
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fieldPath := join(path, k)
+			fs, ok := s.props[k]
+			if !ok {
+				errs = multierr.Append(errs, fmt.Errorf("%s: unknown field", fieldPath))
+				continue
+			}
+			if fs.since != 0 && minor < fs.since {
+				errs = multierr.Append(errs, fmt.Errorf("%s: field not available before Kubernetes 1.%d", fieldPath, fs.since))
+				continue
+			}
+			if fs.removed != 0 && minor >= fs.removed {
+				errs = multierr.Append(errs, fmt.Errorf("%s: field removed in Kubernetes 1.%d", fieldPath, fs.removed))
+				continue
+			}
+			errs = multierr.Append(errs, validate(fieldPath, m[k], fs, minor))
+		}
+		for _, r := range s.required {
+			if m[r] == nil {
+				errs = multierr.Append(errs, fmt.Errorf("%s: required", join(path, r)))
+			}
+		}
+		return errs
+
+	case arrayType:
+		a, ok := v.([]interface{})
+		if !ok {
+			return typeError(path, "array", v)
+		}
+		var errs error
+		for i, item := range a {
+			errs = multierr.Append(errs, validate(fmt.Sprintf("%s[%d]", path, i), item, s.items, minor))
+		}
+		return errs
+
+	case mapType:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return typeError(path, "object", v)
+		}
+		var errs error
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			errs = multierr.Append(errs, validate(join(path, k), m[k], s.items, minor))
+		}
+		return errs
+
+	case stringType:
+		str, ok := v.(string)
+		if !ok {
+			return typeError(path, "string", v)
+		}
+		if len(s.enum) > 0 {
+			for _, e := range s.enum {
+				if str == e {
+					return nil
+				}
+			}
+			return fmt.Errorf("%s: %q is not one of %s", path, str, strings.Join(s.enum, ", "))
+		}
+
+	case intType:
+		if _, ok := v.(int); !ok {
+			return typeError(path, "integer", v)
+		}
+
+	case boolType:
+		if _, ok := v.(bool); !ok {
+			return typeError(path, "boolean", v)
+		}
+
+	case intOrStringType:
+		switch v.(type) {
+		case int, string:
+		default:
+			return typeError(path, "integer or string", v)
+		}
+
+	case quantityType:
+		switch q := v.(type) {
+		case int, float64:
+		case string:
+			if !quantityRE.MatchString(q) {
+				return fmt.Errorf("%s: invalid quantity %q", path, q)
+			}
+		default:
+			return typeError(path, "quantity", v)
+		}
+
+	case base64Type:
+		str, ok := v.(string)
+		if !ok {
+			return typeError(path, "base64 string", v)
+		}
+		if _, err := base64.StdEncoding.DecodeString(str); err != nil {
+			return fmt.Errorf("%s: invalid base64 data", path)
+		}
+	}
+
+	return nil
+}
+
+func join(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func typeError(path, want string, v interface{}) error {
+	return fmt.Errorf("%s: want %s, got %s", path, want, typeName(v))
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case int:
+		return "integer"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package k8s
+
+import (
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+const validYAML = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: apigee
+data:
+  config.yaml: |
+    tenant: {}
+---
+apiVersion: v1
+kind: Secret
+type: Opaque
+metadata:
+  name: secret
+data:
+  remote-service.key: aGVsbG8=
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: adapter
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: adapter
+  template:
+    metadata:
+      labels:
+        app: adapter
+    spec:
+      containers:
+      - name: adapter
+        image: google/apigee-envoy-adapter:v1.0.0
+        imagePullPolicy: IfNotPresent
+        ports:
+        - containerPort: 5000
+        resources:
+          limits:
+            cpu: 100m
+            memory: 100Mi
+        volumeMounts:
+        - mountPath: /config
+          name: config
+      volumes:
+      - name: config
+        configMap:
+          name: config
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: adapter
+spec:
+  ports:
+  - port: 5000
+    targetPort: grpc
+  selector:
+    app: adapter
+`
+
+func TestValidate(t *testing.T) {
+	if err := Validate([]byte(validYAML), "1.21"); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+
+	tests := []struct {
+		desc    string
+		yaml    string
+		version string
+		wantErr string
+	}{
+		{"removed apiVersion", "apiVersion: extensions/v1beta1\nkind: Deployment\nmetadata: {name: x}\n", "1.21",
+			"document 1 (Deployment x): extensions/v1beta1 Deployment is not served by Kubernetes 1.21 (removed in 1.16)"},
+		{"unknown kind", "apiVersion: v1\nkind: Pod2\nmetadata: {name: x}\n", "1.21",
+			"unknown kind Pod2 in v1"},
+		{"typo", "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: x}\ndatta: {}\n", "1.21",
+			"datta: unknown field"},
+		{"nested typo", "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: x}\nspec:\n  selector: {}\n  template:\n    spec:\n      containers:\n      - name: a\n        imagePullPolicyy: Always\n", "1.21",
+			"spec.template.spec.containers[0].imagePullPolicyy: unknown field"},
+		{"enum", "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: x}\nspec:\n  selector: {}\n  template:\n    spec:\n      containers:\n      - name: a\n        imagePullPolicy: Sometimes\n", "1.21",
+			`spec.template.spec.containers[0].imagePullPolicy: "Sometimes" is not one of Always, IfNotPresent, Never`},
+		{"required", "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: x}\nspec:\n  template: {}\n", "1.21",
+			"spec.selector: required"},
+		{"missing name", "apiVersion: v1\nkind: ConfigMap\nmetadata: {}\n", "1.21",
+			"metadata.name: required"},
+		{"type", "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: x}\ndata:\n  count: 1\n", "1.21",
+			"data.count: want string, got integer"},
+		{"base64", "apiVersion: v1\nkind: Secret\nmetadata: {name: x}\ndata:\n  key: not base64!\n", "1.21",
+			"data.key: invalid base64 data"},
+		{"quantity", "apiVersion: apps/v1\nkind: Deployment\nmetadata: {name: x}\nspec:\n  selector: {}\n  template:\n    spec:\n      containers:\n      - name: a\n        resources:\n          limits: {cpu: lots}\n", "1.21",
+			`spec.template.spec.containers[0].resources.limits.cpu: invalid quantity "lots"`},
+		{"field too new", "apiVersion: v1\nkind: ConfigMap\nmetadata: {name: x}\nimmutable: true\n", "1.17",
+			"immutable: field not available before Kubernetes 1.18"},
+		{"field removed", "apiVersion: v1\nkind: Service\nmetadata: {name: x}\nspec:\n  topologyKeys: [a]\n", "1.22",
+			"spec.topologyKeys: field removed in Kubernetes 1.22"},
+		{"bad yaml", "a: [", "1.21", "document 1:"},
+		{"no kind", "metadata: {name: x}\n", "1.21", "apiVersion and kind are required"},
+		{"bad version", validYAML, "2.0", `invalid Kubernetes version "2.0"`},
+		{"old version", validYAML, "1.10", `unsupported Kubernetes version "1.10"`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			testutil.ErrorContains(t, Validate([]byte(tc.yaml), tc.version), tc.wantErr)
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	for in, want := range map[string]int{"1.21": 21, "v1.16": 16, "1.30.2": 30} {
+		got, err := ParseVersion(in)
+		if err != nil {
+			t.Errorf("%s: want no error, got %v", in, err)
+		}
+		if got != want {
+			t.Errorf("%s: want %d, got %d", in, want, got)
+		}
+	}
+}
@@ -18,9 +18,19 @@ import (
 	"os"
 
 	"github.com/apigee/apigee-remote-service-cli/cmd"
+	"github.com/apigee/apigee-remote-service-cli/cmd/analytics"
+	"github.com/apigee/apigee-remote-service-cli/cmd/apps"
 	"github.com/apigee/apigee-remote-service-cli/cmd/bindings"
+	"github.com/apigee/apigee-remote-service-cli/cmd/config"
+	"github.com/apigee/apigee-remote-service-cli/cmd/devproxy"
 	"github.com/apigee/apigee-remote-service-cli/cmd/provision"
+	"github.com/apigee/apigee-remote-service-cli/cmd/proxies"
+	"github.com/apigee/apigee-remote-service-cli/cmd/samples"
+	"github.com/apigee/apigee-remote-service-cli/cmd/schema"
+	"github.com/apigee/apigee-remote-service-cli/cmd/serve"
 	"github.com/apigee/apigee-remote-service-cli/cmd/token"
+	"github.com/apigee/apigee-remote-service-cli/cmd/trace"
+	"github.com/apigee/apigee-remote-service-cli/cmd/workspace"
 	"github.com/apigee/apigee-remote-service-cli/shared"
 )
 
@@ -45,10 +55,35 @@ func main() {
 
 	rootArgs := &shared.RootArgs{}
 	shared.AddCommandWithFlags(rootCmd, rootArgs, provision.Cmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, provision.DeprovisionCmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, provision.DoctorCmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, provision.SupportBundleCmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, provision.QuotaCmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, provision.SmokeCmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, provision.UpgradeCmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, provision.StateCmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, provision.VerifyCmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, proxies.Cmd(rootArgs, shared.Printf))
 	shared.AddCommandWithFlags(rootCmd, rootArgs, bindings.Cmd(rootArgs, shared.Printf))
 	shared.AddCommandWithFlags(rootCmd, rootArgs, token.Cmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, serve.Cmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, apps.Cmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, analytics.Cmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, samples.Cmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, config.Cmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, trace.Cmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, workspace.Cmd(rootArgs, shared.Printf))
+	shared.AddCommandWithFlags(rootCmd, rootArgs, devproxy.Cmd(rootArgs, shared.Printf))
+	rootCmd.AddCommand(schema.Cmd(shared.Printf))
 
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(-1)
+	err := rootCmd.Execute()
+	cmd.StopProfileMetrics(rootCmd, shared.Errorf)
+	if err != nil {
+		if rootArgs.CI {
+			shared.PrintErrorJSON(err, shared.Errorf)
+		} else {
+			shared.PrintErrorHint(err, shared.Errorf)
+		}
+		os.Exit(shared.ExitStatus(err))
 	}
 }
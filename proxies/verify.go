@@ -0,0 +1,44 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxies
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Digest returns the pinned SHA-256 digest (hex) of an embedded proxy bundle
+func Digest(name string) (string, bool) {
+	digest, ok := digests[name]
+	return digest, ok
+}
+
+// Verify checks an embedded proxy bundle against its pinned SHA-256 digest
+func Verify(name string) error {
+	want, ok := digests[name]
+	if !ok {
+		return fmt.Errorf("no digest pinned for proxy bundle %s", name)
+	}
+	data, err := Asset(name)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("proxy bundle %s has digest %s, want %s", name, got, want)
+	}
+	return nil
+}
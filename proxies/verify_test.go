@@ -0,0 +1,39 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package proxies
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	for _, name := range AssetNames() {
+		if err := Verify(name); err != nil {
+			t.Errorf("%v (run bin/build_proxies.sh)", err)
+		}
+	}
+
+	if err := Verify("missing.zip"); err == nil || !strings.Contains(err.Error(), "no digest pinned") {
+		t.Errorf("want no digest error, got %v", err)
+	}
+
+	pinned := digests["internal.zip"]
+	digests["internal.zip"] = strings.Repeat("0", 64)
+	defer func() { digests["internal.zip"] = pinned }()
+	if err := Verify("internal.zip"); err == nil || !strings.Contains(err.Error(), "has digest "+pinned) {
+		t.Errorf("want digest mismatch, got %v", err)
+	}
+}
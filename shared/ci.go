@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// NonInteractive is set by --ci, commands must not prompt or render progress
+var NonInteractive bool
+
+// applyCI switches the command to JSON output, if it has any, and leaves
+// errors to PrintErrorJSON
+func (r *RootArgs) applyCI(cmd *cobra.Command) error {
+	NonInteractive = r.CI
+	if !r.CI {
+		return nil
+	}
+	cmd.Root().SilenceErrors = true
+	cmd.SilenceUsage = true
+	if output := cmd.Flags().Lookup("output"); output != nil && !output.Changed {
+		return cmd.Flags().Set("output", "json")
+	}
+	return nil
+}
+
+// errorJSON is an error printed by --ci
+type errorJSON struct {
+	Error       string    `json:"error"`
+	Code        ErrorCode `json:"code,omitempty"`
+	Summary     string    `json:"summary,omitempty"`
+	Remediation string    `json:"remediation,omitempty"`
+	Docs        string    `json:"docs,omitempty"`
+}
+
+// PrintErrorJSON prints err with its code, remediation and docs, if any, as
+// a single line of JSON
+func PrintErrorJSON(err error, printf FormatFn) {
+	e := errorJSON{Error: err.Error()}
+	if code := ErrorCodeOf(err); code != "" {
+		info := ErrorCatalog[code]
+		e.Code = code
+		e.Summary = info.Summary
+		e.Remediation = info.Remediation
+		e.Docs = ErrorDocsURL + "#" + strings.ToLower(string(code))
+	}
+	data, _ := json.Marshal(e)
+	printf("%s", data)
+}
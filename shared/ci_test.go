@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/spf13/cobra"
+)
+
+func TestApplyCI(t *testing.T) {
+	defer func() { NonInteractive = false }()
+
+	var output string
+	rootCmd := &cobra.Command{Use: "root"}
+	c := &cobra.Command{Use: "test", RunE: func(cmd *cobra.Command, args []string) error { return nil }}
+	c.Flags().StringVarP(&output, "output", "", "table", "")
+	rootCmd.AddCommand(c)
+
+	r := &RootArgs{}
+	if err := r.applyCI(c); err != nil || NonInteractive || output != "table" {
+		t.Fatalf("want no change without --ci, got %v, %t, %s", err, NonInteractive, output)
+	}
+
+	r.CI = true
+	if err := r.applyCI(c); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if !NonInteractive || output != "json" || !rootCmd.SilenceErrors || !c.SilenceUsage {
+		t.Errorf("want non-interactive, json output and errors silenced, got %t, %s, %t, %t",
+			NonInteractive, output, rootCmd.SilenceErrors, c.SilenceUsage)
+	}
+
+	output = "yaml"
+	c.Flags().Lookup("output").Changed = true
+	if err := r.applyCI(c); err != nil || output != "yaml" {
+		t.Errorf("want --output kept, got %v, %s", err, output)
+	}
+}
+
+func TestPrintErrorJSON(t *testing.T) {
+	print := testutil.Printer("TestPrintErrorJSON")
+	PrintErrorJSON(WithCode(CodeInvalidFlags, fmt.Errorf("bad \"flag\"")), print.Printf)
+	PrintErrorJSON(fmt.Errorf("plain"), print.Printf)
+	print.Check(t, []string{
+		`{"error":"bad \"flag\"","code":"ARS-1004","summary":"invalid flags","remediation":"check the flags against --help","docs":"` +
+			ErrorDocsURL + `#ars-1004"}`,
+		`{"error":"plain"}`,
+	})
+}
@@ -0,0 +1,334 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jws"
+	"github.com/pkg/errors"
+)
+
+const (
+	// CredentialsEnv names the service account key file used if no token is given (hybrid)
+	CredentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+
+	metadataHostEnv     = "GCE_METADATA_HOST" // overrides the metadata server, as for the Google client libraries
+	defaultMetadataHost = "metadata.google.internal"
+	metadataTokenPath   = "/computeMetadata/v1/instance/service-accounts/default/token"
+	metadataTimeout     = 2 * time.Second // the metadata server answers at once, if at all
+
+	cloudPlatformScope  = "https://www.googleapis.com/auth/cloud-platform"
+	defaultTokenURI     = "https://oauth2.googleapis.com/token"
+	jwtBearerGrantType  = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	serviceAccountType  = "service_account"
+	authorizedUserType  = "authorized_user"
+	assertionLifetime   = time.Hour
+	tokenRefreshLeadway = time.Minute // refresh tokens expiring sooner than this
+)
+
+// productNameFile names Google on GCE, GKE and Cloud Build (var for tests)
+var productNameFile = "/sys/class/dmi/id/product_name"
+
+// metadataClient is the client of the metadata server
+var metadataClient = &http.Client{Timeout: metadataTimeout}
+
+// onGCEOnce probes the metadata server once, as it takes metadataTimeout
+// when there is none
+var (
+	onGCEOnce sync.Once
+	gce       bool
+)
+
+// credentialsFile is the JSON key file of a service account or the
+// application default credentials of a gcloud user
+type credentialsFile struct {
+	Type string `json:"type"`
+
+	// service_account
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+
+	// authorized_user
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// fetchFn fetches a new token and returns it with its lifetime
+type fetchFn func(now time.Time) (string, time.Duration, error)
+
+// cachingTokenSource returns the token fetched last until it is about to expire
+type cachingTokenSource struct {
+	fetch fetchFn
+	now   func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+func newCachingTokenSource(fetch fetchFn) *cachingTokenSource {
+	return &cachingTokenSource{fetch: fetch, now: time.Now}
+}
+
+// Token returns the current token, or a new one if it is about to expire
+func (s *cachingTokenSource) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.token != "" && now.Add(tokenRefreshLeadway).Before(s.expiry) {
+		return s.token, nil
+	}
+	token, lifetime, err := s.fetch(now)
+	if err != nil {
+		return "", WithCode(CodeBadCredentials, err)
+	}
+	s.token = token
+	s.expiry = now.Add(lifetime)
+	return s.token, nil
+}
+
+// Reauthenticate discards the current token, the next is fetched anew
+func (s *cachingTokenSource) Reauthenticate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = ""
+}
+
+// defaultTokenSource looks up credentials as the Google client libraries do:
+// the key file given, $GOOGLE_APPLICATION_CREDENTIALS, the application default
+// credentials of gcloud and the metadata server on GCP. It returns nil if none
+// is found.
+func defaultTokenSource(keyFile string, client *http.Client) (apigee.TokenSource, error) {
+	if keyFile == "" {
+		keyFile = os.Getenv(CredentialsEnv)
+	}
+	if keyFile != "" {
+		return fileTokenSource(keyFile, client)
+	}
+	if file := wellKnownCredentialsFile(); file != "" {
+		if _, err := os.Stat(file); err == nil {
+			return fileTokenSource(file, client)
+		}
+	}
+	if onGCE() {
+		return newCachingTokenSource(fetchMetadataToken), nil
+	}
+	return nil, nil
+}
+
+// wellKnownCredentialsFile is where gcloud auth application-default login stores credentials
+func wellKnownCredentialsFile() string {
+	dir := os.Getenv("CLOUDSDK_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config", "gcloud")
+	}
+	return filepath.Join(dir, "application_default_credentials.json")
+}
+
+// onGCE reports whether a metadata server is available
+func onGCE() bool {
+	if os.Getenv(metadataHostEnv) != "" {
+		return true
+	}
+	onGCEOnce.Do(func() {
+		gce = googleProduct() || probeMetadata(defaultMetadataHost)
+	})
+	return gce
+}
+
+// googleProduct reports whether the product name is the one of GCP machines
+func googleProduct() bool {
+	data, err := ioutil.ReadFile(productNameFile)
+	if err != nil {
+		return false
+	}
+	name := strings.TrimSpace(string(data))
+	return name == "Google" || name == "Google Compute Engine"
+}
+
+// probeMetadata reports whether host answers as a metadata server, eg. to
+// containers without the product name
+func probeMetadata(host string) bool {
+	resp, err := metadataClient.Get("http://" + host + "/")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Metadata-Flavor") == "Google"
+}
+
+// fileTokenSource returns a token source for a service account key or user
+// credentials file, fetching tokens with client
+func fileTokenSource(file string, client *http.Client) (apigee.TokenSource, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading credentials %s", file)
+	}
+	var creds credentialsFile
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, errors.Wrapf(err, "parsing credentials %s", file)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = defaultTokenURI
+	}
+
+	switch creds.Type {
+	case serviceAccountType:
+		return serviceAccountTokenSource(file, creds, client)
+	case authorizedUserType:
+		if creds.ClientID == "" || creds.RefreshToken == "" {
+			return nil, fmt.Errorf("credentials %s have no client_id or refresh_token", file)
+		}
+		return newCachingTokenSource(func(now time.Time) (string, time.Duration, error) {
+			return fetchToken(client, creds.TokenURI, creds.ClientID, url.Values{
+				"grant_type":    {"refresh_token"},
+				"client_id":     {creds.ClientID},
+				"client_secret": {creds.ClientSecret},
+				"refresh_token": {creds.RefreshToken},
+			})
+		}), nil
+	}
+	return nil, fmt.Errorf("credentials %s have unsupported type %q", file, creds.Type)
+}
+
+// serviceAccountTokenSource mints tokens for a service account, exchanging a
+// JWT signed with its key
+func serviceAccountTokenSource(file string, creds credentialsFile, client *http.Client) (apigee.TokenSource, error) {
+	if creds.ClientEmail == "" || creds.PrivateKey == "" {
+		return nil, fmt.Errorf("service account key %s has no client_email or private_key", file)
+	}
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("private_key of service account key %s is not PEM encoded", file)
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, errors.Wrapf(err, "parsing private_key of service account key %s", file)
+		}
+	}
+
+	return newCachingTokenSource(func(now time.Time) (string, time.Duration, error) {
+		assertion, err := serviceAccountAssertion(creds, privateKey, now)
+		if err != nil {
+			return "", 0, err
+		}
+		return fetchToken(client, creds.TokenURI, creds.ClientEmail, url.Values{
+			"grant_type": {jwtBearerGrantType},
+			"assertion":  {assertion},
+		})
+	}), nil
+}
+
+// serviceAccountAssertion returns the JWT exchanged for a token
+func serviceAccountAssertion(creds credentialsFile, privateKey interface{}, now time.Time) (string, error) {
+	// marshaled by hand: aud must be a string, not the list jwt.Token produces
+	payload, err := json.Marshal(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"aud":   creds.TokenURI,
+		"scope": cloudPlatformScope,
+		"iat":   now.Unix(),
+		"exp":   now.Add(assertionLifetime).Unix(),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling assertion")
+	}
+	headers := jws.NewHeaders()
+	if err := headers.Set(jws.TypeKey, "JWT"); err != nil {
+		return "", errors.Wrap(err, "setting typ")
+	}
+	if creds.PrivateKeyID != "" {
+		if err := headers.Set(jws.KeyIDKey, creds.PrivateKeyID); err != nil {
+			return "", errors.Wrap(err, "setting kid")
+		}
+	}
+	signed, err := jws.Sign(payload, jwa.RS256, privateKey, jws.WithHeaders(headers))
+	if err != nil {
+		return "", errors.Wrap(err, "signing assertion")
+	}
+	return string(signed), nil
+}
+
+// fetchToken posts an OAuth token request for principal
+func fetchToken(client *http.Client, tokenURI, principal string, form url.Values) (string, time.Duration, error) {
+	resp, err := client.PostForm(tokenURI, form)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "fetching token for %s", principal)
+	}
+	return readToken(resp, principal)
+}
+
+// fetchMetadataToken gets a token of the default service account from the metadata server
+func fetchMetadataToken(now time.Time) (string, time.Duration, error) {
+	host := os.Getenv(metadataHostEnv)
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	req, err := http.NewRequest(http.MethodGet, "http://"+host+metadataTokenPath, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "fetching token from the metadata server")
+	}
+	return readToken(resp, "the metadata server")
+}
+
+// readToken reads a token response
+func readToken(resp *http.Response, principal string) (string, time.Duration, error) {
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "reading token for %s", principal)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("fetching token for %s: %d %s", principal, resp.StatusCode, body)
+	}
+	var tokenRes struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tokenRes); err != nil {
+		return "", 0, errors.Wrapf(err, "parsing token for %s", principal)
+	}
+	if tokenRes.AccessToken == "" {
+		return "", 0, fmt.Errorf("no access_token for %s", principal)
+	}
+	return tokenRes.AccessToken, time.Duration(tokenRes.ExpiresIn) * time.Second, nil
+}
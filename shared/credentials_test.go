@@ -0,0 +1,218 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jws"
+)
+
+func TestServiceAccountTokenSource(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatal(err)
+		}
+		if r.Form.Get("grant_type") != jwtBearerGrantType {
+			t.Errorf("want grant_type %s, got %s", jwtBearerGrantType, r.Form.Get("grant_type"))
+		}
+		payload, err := jws.Verify([]byte(r.Form.Get("assertion")), jwa.RS256, &privateKey.PublicKey)
+		if err != nil {
+			t.Errorf("assertion: %v", err)
+		}
+		var claims map[string]interface{}
+		_ = json.Unmarshal(payload, &claims)
+		if claims["iss"] != "sa@project.iam.gserviceaccount.com" || claims["scope"] != cloudPlatformScope {
+			t.Errorf("unexpected claims %v", claims)
+		}
+		issued++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("token%d", issued),
+			"expires_in":   3600,
+		})
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "key.json")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+	writeCredentials(t, keyFile, credentialsFile{
+		Type:         serviceAccountType,
+		ClientEmail:  "sa@project.iam.gserviceaccount.com",
+		PrivateKeyID: "kid",
+		PrivateKey:   string(keyPEM),
+		TokenURI:     ts.URL,
+	})
+
+	source, err := fileTokenSource(keyFile, http.DefaultClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	source.(*cachingTokenSource).now = func() time.Time { return now }
+	for i, want := range []string{"token1", "token1"} {
+		if got, err := source.Token(); err != nil || got != want {
+			t.Errorf("%d: want %s, got %s (%v)", i, want, got, err)
+		}
+	}
+	// refreshed before expiring
+	now = now.Add(time.Hour - tokenRefreshLeadway)
+	if got, err := source.Token(); err != nil || got != "token2" {
+		t.Errorf("want token2, got %s (%v)", got, err)
+	}
+	// fetched anew once discarded
+	source.(apigee.Reauthenticator).Reauthenticate()
+	if got, err := source.Token(); err != nil || got != "token3" {
+		t.Errorf("want token3, got %s (%v)", got, err)
+	}
+
+	// found from the environment
+	os.Setenv(CredentialsEnv, keyFile)
+	defer os.Unsetenv(CredentialsEnv)
+	r := &RootArgs{RuntimeBase: "https://runtime", Org: "org", Env: "test"}
+	if err := r.Resolve(false, true); err != nil {
+		t.Fatal(err)
+	}
+	if r.ClientOpts.Auth.TokenSource == nil {
+		t.Errorf("want token source from %s", CredentialsEnv)
+	}
+
+	r = &RootArgs{RuntimeBase: "https://runtime", Org: "org", Env: "test", ServiceAccount: filepath.Join(dir, "missing.json")}
+	testutil.ErrorContains(t, r.Resolve(false, true), "reading credentials")
+	writeCredentials(t, keyFile, credentialsFile{Type: "external_account"})
+	_, err = fileTokenSource(keyFile, http.DefaultClient)
+	testutil.ErrorContains(t, err, `have unsupported type "external_account"`)
+}
+
+func TestApplicationDefaultCredentials(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Metadata-Flavor", "Google")
+		token := ""
+		switch r.URL.Path {
+		case "/token":
+			_ = r.ParseForm()
+			if r.Form.Get("grant_type") == "refresh_token" && r.Form.Get("refresh_token") == "refresh" {
+				token = "user-token"
+			}
+		case metadataTokenPath:
+			if r.Header.Get("Metadata-Flavor") == "Google" {
+				token = "metadata-token"
+			}
+		}
+		if token == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": token, "expires_in": 3600})
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("CLOUDSDK_CONFIG", dir)
+	defer os.Unsetenv("CLOUDSDK_CONFIG")
+	defer func(file string) { productNameFile = file }(productNameFile)
+	productNameFile = filepath.Join(dir, "product_name")
+	// not on GCP, without probing the metadata server
+	defer func() { onGCEOnce, gce = sync.Once{}, false }()
+	onGCEOnce.Do(func() {})
+
+	tokenOf := func() string {
+		source, err := defaultTokenSource("", http.DefaultClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if source == nil {
+			return ""
+		}
+		token, err := source.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	// none found
+	if got := tokenOf(); got != "" {
+		t.Errorf("want no credentials, got %s", got)
+	}
+	r := &RootArgs{RuntimeBase: "https://runtime", Org: "org", Env: "test"}
+	testutil.ErrorContains(t, r.Resolve(false, true), "--token or --service-account is required for hybrid")
+
+	// metadata server
+	os.Setenv(metadataHostEnv, strings.TrimPrefix(ts.URL, "http://"))
+	defer os.Unsetenv(metadataHostEnv)
+	if got := tokenOf(); got != "metadata-token" {
+		t.Errorf("want metadata-token, got %s", got)
+	}
+
+	// probed
+	if !probeMetadata(strings.TrimPrefix(ts.URL, "http://")) {
+		t.Errorf("want metadata server at %s", ts.URL)
+	}
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	if probeMetadata(strings.TrimPrefix(other.URL, "http://")) {
+		t.Errorf("want no metadata server at %s", other.URL)
+	}
+
+	// gcloud user credentials take precedence
+	writeCredentials(t, wellKnownCredentialsFile(), credentialsFile{
+		Type:         authorizedUserType,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RefreshToken: "refresh",
+		TokenURI:     ts.URL + "/token",
+	})
+	if got := tokenOf(); got != "user-token" {
+		t.Errorf("want user-token, got %s", got)
+	}
+}
+
+func writeCredentials(t *testing.T, file string, creds credentialsFile) {
+	data, _ := json.Marshal(creds)
+	if err := ioutil.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
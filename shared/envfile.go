@@ -0,0 +1,230 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/pflag"
+)
+
+var envKeyRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// EnvVarPrefix prefixes the environment variables of flags, eg. ARS_ORGANIZATION
+const EnvVarPrefix = "ARS_"
+
+// fileFlags name files: a file of --flags-dir named after one of them gives
+// its path rather than its content (eg. the key of --service-account)
+var fileFlags = map[string]bool{
+	"service-account":         true,
+	"config":                  true,
+	"env-file":                true,
+	"ca-cert":                 true,
+	"client-cert":             true,
+	"client-key":              true,
+	"runtime-tls-client-cert": true,
+	"runtime-tls-client-key":  true,
+	"sealed-secrets-cert":     true,
+	"kubeconfig":              true,
+	"jwt-from":                true,
+	"notify-config":           true,
+}
+
+// loadEnvVars populates any flags not explicitly set on the command line
+// from environment variables named after them with EnvVarPrefix, the keys of
+// an env file (eg. ARS_ORGANIZATION, ARS_FORCE_PROXY_UPDATE)
+func loadEnvVars(flags *pflag.FlagSet) error {
+	var err error
+	flags.VisitAll(func(flag *pflag.Flag) {
+		key := EnvVarPrefix + strings.ToUpper(strings.ReplaceAll(flag.Name, "-", "_"))
+		value, ok := os.LookupEnv(key)
+		if !ok || flag.Changed || err != nil {
+			return
+		}
+		if flags.Set(flag.Name, value) != nil {
+			// don't include the value, it may be a secret
+			err = fmt.Errorf("invalid value for %s", key)
+		}
+	})
+	return err
+}
+
+// loadFlagsDir populates any flags not set from the files of FlagsDir named
+// after them, as a Kubernetes ConfigMap or Secret is mounted. A file gives
+// its content without the trailing newline, or its path for fileFlags.
+func (r *RootArgs) loadFlagsDir(flags *pflag.FlagSet) error {
+	if r.FlagsDir == "" {
+		return nil
+	}
+	entries, err := ioutil.ReadDir(r.FlagsDir)
+	if err != nil {
+		return errors.Wrapf(err, "reading flags dir %s", r.FlagsDir)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") { // the ..data links of mounted volumes
+			continue
+		}
+		flagName := strings.ReplaceAll(strings.ToLower(entry.Name()), "_", "-")
+		flag := flags.Lookup(flagName)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		file := filepath.Join(r.FlagsDir, entry.Name())
+		value := file
+		if !fileFlags[flagName] {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return errors.Wrapf(err, "reading flags dir %s", r.FlagsDir)
+			}
+			value = strings.TrimRight(string(data), "\r\n")
+		}
+		if err := flags.Set(flagName, value); err != nil {
+			return fmt.Errorf("flags dir %s: invalid value for %s", r.FlagsDir, entry.Name())
+		}
+	}
+	return nil
+}
+
+// loadEnvFile populates any flags not explicitly set on the command line
+// from the dotenv-style file at EnvFile. Keys are flag names in any case with
+// underscores in place of dashes (eg. ORGANIZATION, FORCE_PROXY_INSTALL).
+// Values are never exported to the process environment.
+func (r *RootArgs) loadEnvFile(flags *pflag.FlagSet) error {
+	if r.EnvFile == "" {
+		return nil
+	}
+
+	f, err := os.Open(r.EnvFile)
+	if err != nil {
+		return errors.Wrapf(err, "opening env file %s", r.EnvFile)
+	}
+	defer f.Close()
+
+	if runtime.GOOS != "windows" {
+		if info, err := f.Stat(); err == nil && info.Mode().Perm()&0077 != 0 {
+			Errorf("WARNING: env file %s is accessible by other users", r.EnvFile)
+		}
+	}
+
+	vars, err := ParseEnvFile(f)
+	if err != nil {
+		return errors.Wrapf(err, "parsing env file %s", r.EnvFile)
+	}
+
+	for _, v := range vars {
+		flagName := strings.ReplaceAll(strings.ToLower(v.Key), "_", "-")
+		flag := flags.Lookup(flagName)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		if err := flags.Set(flagName, v.Value); err != nil {
+			// don't include the value, it may be a secret
+			return fmt.Errorf("env file %s: invalid value for %s", r.EnvFile, v.Key)
+		}
+	}
+
+	return nil
+}
+
+// EnvVar is a single entry in an env file
+type EnvVar struct {
+	Key   string
+	Value string
+}
+
+// ParseEnvFile parses dotenv-style KEY=VALUE lines. Blank lines and lines
+// starting with # are ignored, an "export " prefix is allowed, and values may
+// be single quoted (literal) or double quoted (supporting \n, \" and \\).
+func ParseEnvFile(in io.Reader) ([]EnvVar, error) {
+	var vars []EnvVar
+	scanner := bufio.NewScanner(in)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: missing '='", lineNum)
+		}
+		key := strings.TrimSpace(line[:i])
+		if !envKeyRegexp.MatchString(key) {
+			return nil, fmt.Errorf("line %d: invalid key %q", lineNum, key)
+		}
+		value, err := parseEnvValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		vars = append(vars, EnvVar{Key: key, Value: value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+func parseEnvValue(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	switch raw[0] {
+	case '\'':
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", errors.New("unterminated single quote")
+		}
+		return raw[1 : end+1], nil
+	case '"':
+		var sb strings.Builder
+		for i := 1; i < len(raw); i++ {
+			c := raw[i]
+			switch {
+			case c == '"':
+				return sb.String(), nil
+			case c == '\\' && i+1 < len(raw):
+				i++
+				switch raw[i] {
+				case 'n':
+					sb.WriteByte('\n')
+				case 't':
+					sb.WriteByte('\t')
+				default:
+					sb.WriteByte(raw[i])
+				}
+			default:
+				sb.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated double quote")
+	}
+	// unquoted values may have a trailing comment
+	if i := strings.Index(raw, " #"); i >= 0 {
+		raw = raw[:i]
+	}
+	return strings.TrimSpace(raw), nil
+}
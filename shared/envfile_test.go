@@ -0,0 +1,176 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/spf13/pflag"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	in := `
+# a comment
+ORGANIZATION=myorg
+export ENVIRONMENT = test
+TOKEN="multi\nline \"quoted\""
+PASSWORD='lit\eral # not a comment'
+RUNTIME=https://example.com # a comment
+EMPTY=
+`
+	got, err := ParseEnvFile(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	want := []EnvVar{
+		{Key: "ORGANIZATION", Value: "myorg"},
+		{Key: "ENVIRONMENT", Value: "test"},
+		{Key: "TOKEN", Value: "multi\nline \"quoted\""},
+		{Key: "PASSWORD", Value: `lit\eral # not a comment`},
+		{Key: "RUNTIME", Value: "https://example.com"},
+		{Key: "EMPTY", Value: ""},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+
+	errCases := map[string]string{
+		"NOVALUE":       "line 1: missing '='",
+		"BAD KEY=x":     `line 1: invalid key "BAD KEY"`,
+		`KEY="open`:     "line 1: unterminated double quote",
+		"KEY='open":     "line 1: unterminated single quote",
+		"\n\n-KEY=x\n":  `line 3: invalid key "-KEY"`,
+		"OK=1\nKEY=\"x": "line 2: unterminated double quote",
+	}
+	for in, wantErr := range errCases {
+		_, err := ParseEnvFile(strings.NewReader(in))
+		testutil.ErrorContains(t, err, wantErr)
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	tmpFile, err := ioutil.TempFile("", "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.WriteString("ORGANIZATION=fromfile\nENVIRONMENT=fromfile\nUNKNOWN=ignored\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &RootArgs{}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringVarP(&r.Org, "organization", "o", "", "")
+	flags.StringVarP(&r.Env, "environment", "e", "", "")
+	flags.StringVarP(&r.EnvFile, "env-file", "", "", "")
+	if err := flags.Parse([]string{"-e", "fromflag", "--env-file", tmpFile.Name()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.loadEnvFile(flags); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if r.Org != "fromfile" {
+		t.Errorf("want org fromfile, got %s", r.Org)
+	}
+	if r.Env != "fromflag" {
+		t.Errorf("want env fromflag, got %s", r.Env)
+	}
+	if os.Getenv("ORGANIZATION") != "" {
+		t.Errorf("env file values must not be exported")
+	}
+
+	r.EnvFile = "missing"
+	testutil.ErrorContains(t, r.loadEnvFile(flags), "opening env file missing")
+}
+
+func TestLoadEnvVars(t *testing.T) {
+	for k, v := range map[string]string{
+		"ARS_ORGANIZATION":  "fromenv",
+		"ARS_ENVIRONMENT":   "fromenv",
+		"ARS_FORCE_INSTALL": "true",
+	} {
+		os.Setenv(k, v)
+		defer os.Unsetenv(k)
+	}
+
+	r := &RootArgs{}
+	var force bool
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringVarP(&r.Org, "organization", "o", "", "")
+	flags.StringVarP(&r.Env, "environment", "e", "", "")
+	flags.BoolVarP(&force, "force-install", "", false, "")
+	if err := flags.Parse([]string{"-e", "fromflag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := loadEnvVars(flags); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if r.Org != "fromenv" || r.Env != "fromflag" || !force {
+		t.Errorf("want fromenv, fromflag and true, got %s, %s and %t", r.Org, r.Env, force)
+	}
+
+	os.Setenv("ARS_FORCE_INSTALL", "secret")
+	flags.Lookup("force-install").Changed = false
+	testutil.ErrorContains(t, loadEnvVars(flags), "invalid value for ARS_FORCE_INSTALL")
+}
+
+func TestLoadFlagsDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "flags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, content := range map[string]string{
+		"organization":    "fromdir\n",
+		"environment":     "fromdir\n",
+		"service-account": `{"type": "service_account"}`,
+		"..data":          "ignored",
+		"unknown":         "ignored",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &RootArgs{FlagsDir: dir}
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	flags.StringVarP(&r.Org, "organization", "o", "", "")
+	flags.StringVarP(&r.Env, "environment", "e", "", "")
+	flags.StringVarP(&r.ServiceAccount, "service-account", "", "", "")
+	if err := flags.Parse([]string{"-e", "fromflag"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.loadFlagsDir(flags); err != nil {
+		t.Fatalf("want no error, got %v", err)
+	}
+	if r.Org != "fromdir" || r.Env != "fromflag" {
+		t.Errorf("want org fromdir and env fromflag, got %s and %s", r.Org, r.Env)
+	}
+	if want := filepath.Join(dir, "service-account"); r.ServiceAccount != want {
+		t.Errorf("want service account %s, got %s", want, r.ServiceAccount)
+	}
+
+	r.FlagsDir = filepath.Join(dir, "missing")
+	testutil.ErrorContains(t, r.loadFlagsDir(flags), "reading flags dir "+r.FlagsDir)
+}
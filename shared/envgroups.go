@@ -0,0 +1,102 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const envGroupsPath = "envgroups"
+
+// environmentGroup serves the attached environments at its hostnames (hybrid
+// and Apigee X)
+type environmentGroup struct {
+	Name      string   `json:"name"`
+	Hostnames []string `json:"hostnames,omitempty"`
+}
+
+type envGroupAttachment struct {
+	Environment string `json:"environment"`
+}
+
+// ResolveEnvGroupRuntimes points the runtime of each environment to the first
+// hostname of the first environment group it's attached to, warning about
+// environments attached to several. Resolve must have been called.
+func (r *RootArgs) ResolveEnvGroupRuntimes(envs []string, warnf FormatFn) error {
+	groups, err := r.envGroupsByEnv()
+	if err != nil {
+		return err
+	}
+	runtimes := map[string]string{}
+	for _, env := range envs {
+		attached := groups[env]
+		if len(attached) == 0 {
+			return fmt.Errorf("environment %s is not attached to an environment group with a hostname", env)
+		}
+		if len(attached) > 1 {
+			var names []string
+			for _, g := range attached {
+				names = append(names, g.Name)
+			}
+			warnf("WARNING: environment %s is attached to environment groups %s, using hostname %s of %s (set --runtime to choose)",
+				env, strings.Join(names, ","), attached[0].Hostnames[0], attached[0].Name)
+		}
+		runtimes[env] = "https://" + attached[0].Hostnames[0]
+	}
+	r.Runtimes = runtimes
+	r.SetRuntimeBase(runtimes[r.Env])
+	return nil
+}
+
+// envGroupsByEnv returns the environment groups with a hostname each
+// environment is attached to
+func (r *RootArgs) envGroupsByEnv() (map[string][]environmentGroup, error) {
+	req, err := r.ApigeeClient.NewRequestNoEnv(http.MethodGet, envGroupsPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	list := struct {
+		Groups []environmentGroup `json:"environmentGroups"`
+	}{}
+	if _, err := r.ApigeeClient.Do(req, &list); err != nil {
+		return nil, errors.Wrap(err, "listing environment groups")
+	}
+
+	groups := map[string][]environmentGroup{}
+	for _, g := range list.Groups {
+		if len(g.Hostnames) == 0 {
+			continue
+		}
+		req, err := r.ApigeeClient.NewRequestNoEnv(http.MethodGet, path.Join(envGroupsPath, g.Name, "attachments"), nil)
+		if err != nil {
+			return nil, err
+		}
+		attachments := struct {
+			Attachments []envGroupAttachment `json:"environmentGroupAttachments"`
+		}{}
+		if _, err := r.ApigeeClient.Do(req, &attachments); err != nil {
+			return nil, errors.Wrapf(err, "listing attachments of environment group %s", g.Name)
+		}
+		for _, a := range attachments.Attachments {
+			groups[a.Environment] = append(groups[a.Environment], g)
+		}
+	}
+	return groups, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestEnvGroupRuntimes(t *testing.T) {
+	m := http.NewServeMux()
+	m.HandleFunc("/v1/organizations/org/envgroups", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"environmentGroups":[
+			{"name":"empty"},
+			{"name":"public","hostnames":["api.example.com","alt.example.com"]},
+			{"name":"internal","hostnames":["internal.example.com"]}
+		]}`))
+	})
+	m.HandleFunc("/v1/organizations/org/envgroups/public/attachments", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"environmentGroupAttachments":[{"environment":"test"},{"environment":"prod"}]}`))
+	})
+	m.HandleFunc("/v1/organizations/org/envgroups/internal/attachments", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"environmentGroupAttachments":[{"environment":"test"},{"environment":"private"}]}`))
+	})
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	// no --runtime, resolved by Resolve for all environments
+	r := &RootArgs{ManagementBase: ts.URL, Org: "org", Env: "prod", Envs: []string{"prod", " private"}, Token: "token"}
+	if err := r.Resolve(false, true); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"prod": "https://api.example.com", "private": "https://internal.example.com"}
+	if !reflect.DeepEqual(want, r.Runtimes) {
+		t.Errorf("want runtimes %v, got %v", want, r.Runtimes)
+	}
+	if r.RemoteServiceProxyURL != "https://api.example.com/remote-service" {
+		t.Errorf("want runtime of prod, got %s", r.RemoteServiceProxyURL)
+	}
+	if err := r.SwitchEnv("private"); err != nil || r.RuntimeBase != "https://internal.example.com" {
+		t.Errorf("want runtime of private, got %s, %v", r.RuntimeBase, err)
+	}
+
+	print := testutil.Printer("TestEnvGroupRuntimes")
+	if err := r.ResolveEnvGroupRuntimes([]string{"test"}, print.Printf); err != nil {
+		t.Fatal(err)
+	}
+	print.Check(t, []string{"WARNING: environment test is attached to environment groups public,internal, " +
+		"using hostname api.example.com of public (set --runtime to choose)"})
+
+	r = &RootArgs{ManagementBase: ts.URL, Org: "org", Env: "unattached", Token: "token"}
+	testutil.ErrorContains(t, r.Resolve(false, true),
+		"--runtime not given and not resolved: environment unattached is not attached to an environment group with a hostname")
+	if ErrorCodeOf(r.Resolve(false, true)) != CodeNoRuntime {
+		t.Errorf("want %s", CodeNoRuntime)
+	}
+
+	// not resolved without auth
+	r = &RootArgs{ManagementBase: ts.URL, Org: "org", Env: "prod"}
+	if err := r.Resolve(true, true); ErrorCodeOf(err) != CodeNoRuntime {
+		t.Errorf("want %s, got %v", CodeNoRuntime, err)
+	}
+
+	// given --runtime is kept
+	r = &RootArgs{ManagementBase: ts.URL, RuntimeBase: "https://given.example.com", Org: "org", Env: "test", Token: "token"}
+	if err := r.Resolve(false, true); err != nil {
+		t.Fatal(err)
+	}
+	if r.Runtimes != nil || r.RuntimeBase != "https://given.example.com" {
+		t.Errorf("want --runtime kept, got %s, %v", r.RuntimeBase, r.Runtimes)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/spf13/cobra"
+)
+
+func TestRepeatedEnvironmentFlag(t *testing.T) {
+	r := &RootArgs{}
+	root := &cobra.Command{Use: "root"}
+	c := &cobra.Command{
+		Use:  "test",
+		RunE: func(cmd *cobra.Command, args []string) error { return nil },
+	}
+	AddCommandWithFlags(root, r, c)
+	root.SetArgs([]string{"test", "-e", "a", "--environment", "b,c"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Env != "a,b,c" {
+		t.Errorf("want a,b,c, got %s", r.Env)
+	}
+
+	r.Org = "org"
+	r.Username, r.Password = "u", "p"
+	r.IsLegacySaaS = true
+	err := r.Resolve(false, true)
+	testutil.ErrorContains(t, err, "multiple environments are only supported by provision")
+}
+
+func TestSwitchEnv(t *testing.T) {
+	r := &RootArgs{IsLegacySaaS: true, Org: "org", Env: "test", Username: "u", Password: "p"}
+	if err := r.Resolve(false, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SwitchEnv("prod"); err != nil {
+		t.Fatal(err)
+	}
+	if r.Env != "prod" || r.ClientOpts.Env != "prod" {
+		t.Errorf("want env prod, got %s and %s", r.Env, r.ClientOpts.Env)
+	}
+	if r.RemoteServiceProxyURL != "https://org-prod.apigee.net/remote-service" {
+		t.Errorf("want runtime of prod, got %s", r.RemoteServiceProxyURL)
+	}
+	if got := r.ApigeeClient.BaseURLEnv.Path; got != "v1/organizations/org/environments/prod" {
+		t.Errorf("want client for prod, got %s", got)
+	}
+}
+
+func TestRuntimePerEnvironment(t *testing.T) {
+	r := &RootArgs{RuntimeBase: "test=https://test.example.com, prod=https://prod.example.com", Org: "org", Env: "test", Token: "token"}
+	if err := r.Resolve(false, true); err != nil {
+		t.Fatal(err)
+	}
+	if r.RemoteServiceProxyURL != "https://test.example.com/remote-service" {
+		t.Errorf("want runtime of test, got %s", r.RemoteServiceProxyURL)
+	}
+	if err := r.SwitchEnv("prod"); err != nil {
+		t.Fatal(err)
+	}
+	if r.RuntimeBase != "https://prod.example.com" || r.RemoteServiceProxyURL != "https://prod.example.com/remote-service" {
+		t.Errorf("want runtime of prod, got %s and %s", r.RuntimeBase, r.RemoteServiceProxyURL)
+	}
+	testutil.ErrorContains(t, r.SwitchEnv("dev"), "--runtime has no URL for environment dev")
+
+	for runtime, want := range map[string]string{
+		"test=https://a,test=https://b": "--runtime has multiple URLs for environment test",
+		"test=https://a,https://b":      `want a URL or env=URL pairs`,
+		"prod=https://a":                "--runtime has no URL for environment test",
+	} {
+		r := &RootArgs{RuntimeBase: runtime, Org: "org", Env: "test", Token: "token"}
+		testutil.ErrorContains(t, r.Resolve(false, true), want)
+	}
+	r = &RootArgs{RuntimeBase: "test=https://a", Org: "org", Env: "test", IsOPDK: true, Username: "u", Password: "p"}
+	testutil.ErrorContains(t, r.Resolve(false, true), "--runtime per environment only valid for hybrid or Apigee X")
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"go.uber.org/multierr"
+)
+
+// ErrorDocsURL is the page documenting the error codes, anchored by code
+const ErrorDocsURL = "https://github.com/apigee/apigee-remote-service-cli/blob/master/docs/errors.md"
+
+// ErrorCode is a stable identifier of a class of failures, scripts may match on it
+type ErrorCode string
+
+// error codes, never reuse or renumber them
+const (
+	CodeNoCredentials    ErrorCode = "ARS-1001"
+	CodeNoRuntime        ErrorCode = "ARS-1002"
+	CodeNoOrgEnv         ErrorCode = "ARS-1003"
+	CodeInvalidFlags     ErrorCode = "ARS-1004"
+	CodeInvalidConfig    ErrorCode = "ARS-1005"
+	CodeBadCredentials   ErrorCode = "ARS-1006"
+	CodePolicyViolation  ErrorCode = "ARS-1007"
+	CodeUnauthorized     ErrorCode = "ARS-1010"
+	CodeForbidden        ErrorCode = "ARS-1011"
+	CodeNotFound         ErrorCode = "ARS-1012"
+	CodeConflict         ErrorCode = "ARS-1013"
+	CodeUnavailable      ErrorCode = "ARS-1014"
+	CodeVerifyFailed     ErrorCode = "ARS-1020"
+	CodeProvisionPartial ErrorCode = "ARS-1021"
+	CodeStepsFailed      ErrorCode = "ARS-1022"
+)
+
+// ErrorInfo describes an error code
+type ErrorInfo struct {
+	Summary     string
+	Remediation string
+}
+
+// ErrorCatalog holds the description of each error code
+var ErrorCatalog = map[ErrorCode]ErrorInfo{
+	CodeNoCredentials: {
+		Summary:     "no management API credentials",
+		Remediation: "pass --token or --service-account for hybrid (or set GOOGLE_APPLICATION_CREDENTIALS), --username and --password or a ~/.netrc entry otherwise",
+	},
+	CodeNoRuntime: {
+		Summary:     "no runtime URL",
+		Remediation: "pass --runtime with the base URL of the environment's runtime, or --organization and --environment with --legacy (hybrid and Apigee X resolve it from the environment group of the environment)",
+	},
+	CodeNoOrgEnv: {
+		Summary:     "no organization or environment",
+		Remediation: "pass --organization and --environment",
+	},
+	CodeInvalidFlags: {
+		Summary:     "invalid flags",
+		Remediation: "check the flags against --help",
+	},
+	CodeInvalidConfig: {
+		Summary:     "invalid config file",
+		Remediation: "pass a config file emitted by provision with --config, for hybrid including its policy secret",
+	},
+	CodeBadCredentials: {
+		Summary:     "unusable credentials",
+		Remediation: "check the file given by --service-account or GOOGLE_APPLICATION_CREDENTIALS is a service account key or gcloud application default credentials",
+	},
+	CodePolicyViolation: {
+		Summary:     "key policy violation",
+		Remediation: "the keys generated don't meet --min-key-size, --require-alg or --max-cert-validity, eg. set by ARS_ variables, raise --key-size or keep fewer keys when rotating",
+	},
+	CodeUnauthorized: {
+		Summary:     "management API request not authenticated",
+		Remediation: "check the credentials, tokens from gcloud auth print-access-token expire after an hour",
+	},
+	CodeForbidden: {
+		Summary:     "management API request not permitted",
+		Remediation: "grant the user or service account a role with the required permissions on the organization (eg. Apigee Organization Admin)",
+	},
+	CodeNotFound: {
+		Summary:     "management API resource not found",
+		Remediation: "check --organization, --environment and --management name existing resources",
+	},
+	CodeConflict: {
+		Summary:     "management API resource conflict",
+		Remediation: "another change to the resource is in progress or it exists in another state, retry or deprovision first",
+	},
+	CodeUnavailable: {
+		Summary:     "management API unavailable",
+		Remediation: "retry later, or raise --retries and --retry-backoff",
+	},
+	CodeVerifyFailed: {
+		Summary:     "provisioned environment not verified",
+		Remediation: "the remote-service proxy may still be deploying, check its deployment and run provision again or raise the verification timeout",
+	},
+	CodeProvisionPartial: {
+		Summary:     "provisioning failed midway",
+		Remediation: "run provision again, existing resources are reused, or deprovision to clean up (--rollback-on-error does so automatically)",
+	},
+	CodeStepsFailed: {
+		Summary:     "provisioned with failed non-critical steps",
+		Remediation: "the config is usable, fix the failures --continue-on-error reported and run provision again",
+	},
+}
+
+// CodedError attaches an error code to an error
+type CodedError struct {
+	Code ErrorCode
+	Err  error
+}
+
+func (e *CodedError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the coded error
+func (e *CodedError) Unwrap() error {
+	return e.Err
+}
+
+// WithCode attaches code to err, nil stays nil
+func WithCode(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &CodedError{Code: code, Err: err}
+}
+
+// ErrorCodeOf returns the code attached to err, or derived from a failed
+// management API response, empty if none
+func ErrorCodeOf(err error) ErrorCode {
+	for _, err := range multierr.Errors(err) {
+		var coded *CodedError
+		if errors.As(err, &coded) {
+			return coded.Code
+		}
+		var resp *apigee.ErrorResponse
+		if errors.As(err, &resp) && resp.Response != nil {
+			switch status := resp.Response.StatusCode; {
+			case status == http.StatusUnauthorized:
+				return CodeUnauthorized
+			case status == http.StatusForbidden:
+				return CodeForbidden
+			case status == http.StatusNotFound:
+				return CodeNotFound
+			case status == http.StatusConflict:
+				return CodeConflict
+			case status == http.StatusTooManyRequests || status >= 500:
+				return CodeUnavailable
+			}
+		}
+	}
+	return ""
+}
+
+// ExitStatus returns the exit status for err: 0 if nil, 2 if only steps
+// tolerated by --continue-on-error failed, -1 otherwise
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	for _, err := range multierr.Errors(err) {
+		var coded *CodedError
+		if !errors.As(err, &coded) || coded.Code != CodeStepsFailed {
+			return -1
+		}
+	}
+	return 2
+}
+
+// PrintErrorHint prints the code of err with its remediation and docs, if any
+func PrintErrorHint(err error, printf FormatFn) {
+	code := ErrorCodeOf(err)
+	info, ok := ErrorCatalog[code]
+	if !ok {
+		return
+	}
+	printf("%s: %s", code, info.Summary)
+	printf("  hint: %s", info.Remediation)
+	printf("  docs: %s#%s", ErrorDocsURL, strings.ToLower(string(code)))
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+)
+
+func TestErrorCodeOf(t *testing.T) {
+	forbidden := &apigee.ErrorResponse{Response: &http.Response{StatusCode: http.StatusForbidden}}
+	for err, want := range map[error]ErrorCode{
+		fmt.Errorf("plain"): "",
+		errors.Wrap(WithCode(CodeNoRuntime, fmt.Errorf("no runtime")), "resolving"):            CodeNoRuntime,
+		errors.Wrap(forbidden, "creating kvm"):                                                 CodeForbidden,
+		multierr.Append(fmt.Errorf("plain"), WithCode(CodeVerifyFailed, fmt.Errorf("verify"))): CodeVerifyFailed,
+		WithCode(CodeProvisionPartial, errors.Wrap(forbidden, "creating kvm")):                 CodeProvisionPartial,
+	} {
+		if got := ErrorCodeOf(err); got != want {
+			t.Errorf("%v: want code %q, got %q", err, want, got)
+		}
+	}
+	if WithCode(CodeNoRuntime, nil) != nil {
+		t.Errorf("want nil error left nil")
+	}
+
+	r := &RootArgs{RuntimeBase: "https://runtime", Org: "org", Env: "test", IsLegacySaaS: true, IsOPDK: true}
+	err := r.Resolve(false, true)
+	testutil.ErrorContains(t, err, "--legacy and --opdk options are exclusive")
+	if code := ErrorCodeOf(err); code != CodeInvalidFlags {
+		t.Errorf("want %s, got %q", CodeInvalidFlags, code)
+	}
+
+	print := testutil.Printer("TestErrorCodeOf")
+	PrintErrorHint(err, print.Printf)
+	print.Check(t, []string{
+		"ARS-1004: invalid flags",
+		"  hint: check the flags against --help",
+		"  docs: " + ErrorDocsURL + "#ars-1004",
+	})
+	print = testutil.Printer("TestErrorCodeOf")
+	PrintErrorHint(fmt.Errorf("plain"), print.Printf)
+	print.Check(t, nil)
+}
+
+func TestExitStatus(t *testing.T) {
+	steps := WithCode(CodeStepsFailed, fmt.Errorf("1 step(s) failed"))
+	for err, want := range map[error]int{
+		nil:                         0,
+		steps:                       2,
+		multierr.Append(nil, steps): 2,
+		fmt.Errorf("plain"):         -1,
+		multierr.Append(fmt.Errorf("verify"), steps):     -1,
+		WithCode(CodeVerifyFailed, fmt.Errorf("verify")): -1,
+	} {
+		if got := ExitStatus(err); got != want {
+			t.Errorf("%v: want exit status %d, got %d", err, want, got)
+		}
+	}
+}
+
+func TestErrorCatalogDocumented(t *testing.T) {
+	docs, err := ioutil.ReadFile("../docs/errors.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for code := range ErrorCatalog {
+		if !strings.Contains(string(docs), "\n## "+string(code)+"\n") {
+			t.Errorf("%s not documented in docs/errors.md", code)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// managementResource is a collection of the management API by its path
+// segment, named as by the changes and IAM permissions of --explain
+type managementResource struct {
+	kind       string // of the changes
+	permission string // IAM resource of the permissions, "" if none
+}
+
+var managementResources = map[string]managementResource{
+	"organizations":  {"organization", "organizations"},
+	"environments":   {"environment", "environments"},
+	"envgroups":      {"environment group", "envgroups"},
+	"attachments":    {"attachment", "envgroupattachments"},
+	"apis":           {"proxy", "proxies"},
+	"revisions":      {"proxy revision", "proxyrevisions"},
+	"deployments":    {"deployment", "deployments"},
+	"apiproducts":    {"API product", "apiproducts"},
+	"developers":     {"developer", "developers"},
+	"apps":           {"app", "developerapps"},
+	"keys":           {"app key", "developerappkeys"},
+	"appgroups":      {"AppGroup", "appgroups"},
+	"keyvaluemaps":   {"KVM", "keyvaluemaps"},
+	"entries":        {"KVM entry", "keyvaluemapentries"},
+	"resourcefiles":  {"resource file", "resourcefiles"},
+	"caches":         {"cache", "caches"},
+	"stats":          {"stats", "environments"},
+	"attributes":     {"attributes", ""}, // of the parent
+	"sharedflows":    {"shared flow", "sharedflows"},
+	"targetservers":  {"target server", "targetservers"},
+	"virtualhosts":   {"virtual host", ""}, // OPDK and legacy only
+	"queries":        {"analytics query", "queries"},
+	"datacollectors": {"data collector", "datacollectors"},
+}
+
+// explainedCall is a call of --explain, described by its resource
+type explainedCall struct {
+	method     string
+	url        string
+	change     string // if it modifies a resource, eg. "create API product"
+	permission string // IAM permission, "" if unknown or none
+	runtime    bool
+}
+
+// AddExplainFlag adds --explain to c, calling PrintExplain once run
+func (r *RootArgs) AddExplainFlag(c *cobra.Command) {
+	c.Flags().BoolVarP(&r.Explain, "explain", "", false,
+		"print the management and runtime endpoints called, the resources created or modified and the IAM permissions required, changing nothing")
+}
+
+// ExplainPrintf returns printf, or with --explain a FormatFn printing
+// nothing as the command reports changes it doesn't make
+func (r *RootArgs) ExplainPrintf(printf FormatFn) FormatFn {
+	if r.Explain {
+		return NoPrintf
+	}
+	return printf
+}
+
+// PrintExplain prints what --explain recorded of the calls of the command
+func (r *RootArgs) PrintExplain(printf FormatFn) {
+	if !r.Explain || r.Plan == nil {
+		return
+	}
+	managementHost := ""
+	if u, err := url.Parse(r.ManagementBase); err == nil {
+		managementHost = u.Host
+	}
+
+	seen := map[string]bool{}
+	var calls []explainedCall
+	for _, call := range r.Plan.Calls() {
+		u := call.URL
+		u.RawQuery = ""
+		e := explainCall(call.Method, u.Path)
+		e.url = u.String()
+		e.runtime = u.Host != managementHost
+		if key := e.method + " " + e.url; !seen[key] {
+			seen[key] = true
+			calls = append(calls, e)
+		}
+	}
+
+	printf("explain: %s of organization %s", r.platform(), r.Org)
+	if len(calls) == 0 {
+		printf("no management or runtime API calls")
+		return
+	}
+	printf("endpoints called:")
+	var changes []explainedCall
+	permissions := map[string]bool{}
+	for _, c := range calls {
+		target := "management"
+		if c.runtime {
+			target = "runtime"
+		}
+		printf("  %s %s (%s)", c.method, c.url, target)
+		if c.change != "" {
+			changes = append(changes, c)
+		}
+		if c.permission != "" && !c.runtime {
+			permissions[c.permission] = true
+		}
+	}
+	if len(changes) > 0 {
+		printf("resources created or modified, not with --explain:")
+		for _, c := range changes {
+			printf("  %s: %s %s", c.change, c.method, c.url)
+		}
+	} else {
+		printf("no resources created or modified")
+	}
+
+	if !r.IsGCPManaged {
+		printf("permissions: the roles of the user in the organization, IAM permissions are of hybrid and Apigee X only")
+		return
+	}
+	names := make([]string, 0, len(permissions))
+	for p := range permissions {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	printf("IAM permissions required on the project of the organization:")
+	for _, p := range names {
+		printf("  %s", p)
+	}
+	printf("runtime calls are authorized by the remote-service credential, not IAM")
+}
+
+func (r *RootArgs) platform() string {
+	switch {
+	case r.IsOPDK:
+		return "OPDK"
+	case r.IsLegacySaaS:
+		return "legacy SaaS"
+	}
+	return "hybrid or Apigee X"
+}
+
+// explainCall describes a call by the resources of its path below
+// /organizations/, eg. /organizations/o/apiproducts/p/attributes
+func explainCall(method, path string) explainedCall {
+	e := explainedCall{method: method}
+	i := strings.Index(path, "/organizations/")
+	if i < 0 {
+		return e
+	}
+	segments := strings.FieldsFunc(path[i:], func(r rune) bool { return r == '/' })
+
+	// pairs of collection and name, the last possibly without a name
+	var kinds []managementResource
+	var names []string
+	for j := 0; j < len(segments); j += 2 {
+		res, ok := managementResources[segments[j]]
+		if !ok {
+			return e
+		}
+		kinds = append(kinds, res)
+		name := ""
+		if j+1 < len(segments) {
+			name = segments[j+1]
+		}
+		names = append(names, name)
+	}
+	last, name := kinds[len(kinds)-1], names[len(names)-1]
+	named := name != ""
+
+	// attributes are a part of their parent
+	if last.kind == "attributes" && len(kinds) > 1 {
+		parent := kinds[len(kinds)-2]
+		verb := "get"
+		if method != http.MethodGet && method != http.MethodHead {
+			verb = "update"
+			e.change = "update attributes of " + parent.kind + " " + names[len(names)-2]
+		}
+		e.permission = permission(parent, verb)
+		return e
+	}
+
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		verb := "get"
+		if !named && last.kind != "stats" {
+			verb = "list"
+		}
+		if last.kind == "stats" {
+			verb = "getStats"
+		}
+		e.permission = permission(last, verb)
+	case http.MethodPost:
+		switch {
+		case last.kind == "deployment":
+			e.change = "deploy " + described(kinds[:len(kinds)-1], names[:len(names)-1])
+			e.permission = "apigee.proxyrevisions.deploy"
+		case named:
+			e.change = "update " + last.kind + " " + name
+			e.permission = permission(last, "update")
+		default:
+			e.change = "create " + last.kind
+			if parent := described(kinds[:len(kinds)-1], names[:len(names)-1]); parent != "" {
+				e.change += " of " + parent
+			}
+			e.permission = permission(last, "create")
+		}
+	case http.MethodPut, http.MethodPatch:
+		e.change = "update " + described(kinds, names)
+		e.permission = permission(last, "update")
+	case http.MethodDelete:
+		if last.kind == "deployment" {
+			e.change = "undeploy " + described(kinds[:len(kinds)-1], names[:len(names)-1])
+			e.permission = "apigee.proxyrevisions.undeploy"
+		} else {
+			e.change = "delete " + described(kinds, names)
+			e.permission = permission(last, "delete")
+		}
+	}
+	return e
+}
+
+// described names the last resource of a path, eg. "proxy revision 3 of proxy p"
+func described(kinds []managementResource, names []string) string {
+	var parts []string
+	for j := len(kinds) - 1; j >= 1 && len(parts) < 2; j-- {
+		parts = append(parts, strings.TrimSpace(kinds[j].kind+" "+names[j]))
+	}
+	return strings.Join(parts, " of ")
+}
+
+func permission(res managementResource, verb string) string {
+	if res.permission == "" {
+		return ""
+	}
+	return "apigee." + res.permission + "." + verb
+}
@@ -0,0 +1,103 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestExplainCall(t *testing.T) {
+	for _, test := range []struct {
+		method, path       string
+		change, permission string
+	}{
+		{http.MethodGet, "/v1/organizations/o/apiproducts", "", "apigee.apiproducts.list"},
+		{http.MethodGet, "/v1/organizations/o/apiproducts/p", "", "apigee.apiproducts.get"},
+		{http.MethodPost, "/v1/organizations/o/apiproducts", "create API product", "apigee.apiproducts.create"},
+		{http.MethodPost, "/v1/organizations/o/apiproducts/p/attributes", "update attributes of API product p", "apigee.apiproducts.update"},
+		{http.MethodPost, "/v1/organizations/o/environments/e/apis/remote-service/revisions/3/deployments",
+			"deploy proxy revision 3 of proxy remote-service", "apigee.proxyrevisions.deploy"},
+		{http.MethodPost, "/v1/organizations/o/developers/d/apps", "create app of developer d", "apigee.developerapps.create"},
+		{http.MethodDelete, "/v1/organizations/o/developers/d/apps/a",
+			"delete app a of developer d", "apigee.developerapps.delete"},
+		{http.MethodGet, "/v1/organizations/o/environments/e/stats/apiproxy", "", "apigee.environments.getStats"},
+		{http.MethodGet, "/remote-service/certs", "", ""},
+	} {
+		e := explainCall(test.method, test.path)
+		if e.change != test.change || e.permission != test.permission {
+			t.Errorf("%s %s want %q, %q, got %q, %q", test.method, test.path, test.change, test.permission, e.change, e.permission)
+		}
+	}
+}
+
+func TestPrintExplain(t *testing.T) {
+	posted := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			posted = true
+		}
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	r := &RootArgs{Org: "o", ManagementBase: ts.URL, IsGCPManaged: true, Explain: true, Plan: apigee.NewPlan()}
+	client, err := apigee.NewEdgeClient(&apigee.EdgeClientOptions{
+		MgmtURL: ts.URL,
+		Org:     "o",
+		Auth:    &apigee.EdgeAuth{BearerToken: "token"},
+		Plan:    r.Plan,
+		DryRun:  func(string, ...interface{}) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, call := range []struct{ method, path string }{
+		{http.MethodGet, "apiproducts?expand=true"},
+		{http.MethodGet, "apiproducts"},
+		{http.MethodPost, "apiproducts"},
+	} {
+		req, err := client.NewRequestNoEnv(call.method, call.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Do(req, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if posted {
+		t.Errorf("want no change sent with --explain")
+	}
+
+	print := testutil.Printer("TestPrintExplain")
+	r.PrintExplain(print.Printf)
+	products := ts.URL + "/v1/organizations/o/apiproducts"
+	print.Check(t, []string{
+		"explain: hybrid or Apigee X of organization o",
+		"endpoints called:",
+		"  GET " + products + " (management)",
+		"  POST " + products + " (management)",
+		"resources created or modified, not with --explain:",
+		"  create API product: POST " + products,
+		"IAM permissions required on the project of the organization:",
+		"  apigee.apiproducts.create",
+		"  apigee.apiproducts.list",
+		"runtime calls are authorized by the remote-service credential, not IAM",
+	})
+}
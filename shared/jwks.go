@@ -15,12 +15,14 @@
 package shared
 
 import (
+	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"net/http"
 	"sort"
 	"time"
 
@@ -30,15 +32,23 @@ import (
 )
 
 const (
-	certsURLFormat = "%s/certs" // RemoteServiceProxyURL
-	certKeyLength  = 2048
-	pemType        = "RSA PRIVATE KEY"
+	certsURLFormat  = "%s/certs"  // RemoteServiceProxyURL
+	rotateURLFormat = "%s/rotate" // RemoteServiceProxyURL
+	certKeyLength   = 2048
+	pemType         = "RSA PRIVATE KEY"
 )
 
-// CreateNewKey returns keyID, private key, jwks, error
+// CreateNewKey returns keyID, private key, jwks, error. The key meets KeyPolicy.
 func (r *RootArgs) CreateNewKey() (keyID string, privateKey *rsa.PrivateKey, jwks *jwk.Set, err error) {
+	policy := r.KeyPolicy
+	if policy.KeySize == 0 {
+		policy.KeySize = certKeyLength
+	}
+	if err = policy.check(); err != nil {
+		return
+	}
 	keyID = time.Now().Format(time.RFC3339)
-	if privateKey, err = rsa.GenerateKey(rand.Reader, certKeyLength); err != nil {
+	if privateKey, err = rsa.GenerateKey(rand.Reader, policy.KeySize); err != nil {
 		return
 	}
 
@@ -69,7 +79,7 @@ func (r *RootArgs) RotateJKWS(jwks *jwk.Set, truncate int) (*jwk.Set, error) {
 		var oldJWKS *jwk.Set
 		var err error
 		certsURL := fmt.Sprintf(certsURLFormat, r.RemoteServiceProxyURL)
-		if oldJWKS, err = jwk.FetchHTTP(certsURL); err != nil {
+		if oldJWKS, err = jwk.FetchHTTP(certsURL, jwk.WithHTTPClient(r.RuntimeClient())); err != nil {
 			return nil, errors.Wrapf(err, "retrieving JWKs from: %s", certsURL)
 		}
 		keys = append(keys, oldJWKS.Keys...)
@@ -80,7 +90,8 @@ func (r *RootArgs) RotateJKWS(jwks *jwk.Set, truncate int) (*jwk.Set, error) {
 		keys = keys[:truncate]
 	}
 
-	return &jwk.Set{Keys: keys}, nil
+	rotated := &jwk.Set{Keys: keys}
+	return rotated, r.KeyPolicy.CheckJWKS(rotated, time.Now())
 }
 
 // CreateJWKS returns keyID, private key, jwks, error
@@ -105,6 +116,64 @@ func (r *RootArgs) CreateJWKS(truncate int, verbosef FormatFn) (keyID string, pk
 	return
 }
 
+// RetainJWKS returns the keys in jwks sorted newest first by key ID, dropping any
+// key that was superseded by a newer key longer than grace before now.
+// Key IDs are expected to be RFC3339 creation times (see CreateNewKey), keys
+// whose successor has an unparseable key ID are always retained.
+func RetainJWKS(jwks *jwk.Set, grace time.Duration, now time.Time) *jwk.Set {
+	keys := make([]jwk.Key, len(jwks.Keys))
+	copy(keys, jwks.Keys)
+	sort.Sort(sort.Reverse(byKID(keys)))
+
+	retained := keys[:0]
+	for i, key := range keys {
+		if i > 0 {
+			if supersededAt, err := time.Parse(time.RFC3339, keys[i-1].KeyID()); err == nil &&
+				supersededAt.Add(grace).Before(now) {
+				continue
+			}
+		}
+		retained = append(retained, key)
+	}
+
+	return &jwk.Set{Keys: retained}
+}
+
+// RotateRequest is the request to the remote-service proxy to replace its keys
+type RotateRequest struct {
+	PrivateKey string `json:"private_key"`
+	JWKS       string `json:"jwks"`
+	KeyID      string `json:"kid"`
+}
+
+// PostRotate sends new key material to the remote-service proxy (legacy or opdk)
+func (r *RootArgs) PostRotate(clientID, clientSecret string, rotateReq RotateRequest) error {
+	body := new(bytes.Buffer)
+	if err := json.NewEncoder(body).Encode(rotateReq); err != nil {
+		return errors.Wrap(err, "encoding")
+	}
+
+	rotateURL := fmt.Sprintf(rotateURLFormat, r.RemoteServiceProxyURL)
+	req, err := http.NewRequest(http.MethodPost, rotateURL, body)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.SetBasicAuth(clientID, clientSecret)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := r.ApigeeClient.Do(req, nil)
+	if err != nil {
+		if resp != nil && resp.StatusCode == 401 {
+			return errors.Wrap(err, "authentication failed, check your key and secret")
+		}
+		return errors.Wrap(err, "rotating cert")
+	}
+	defer resp.Body.Close()
+
+	return nil
+}
+
 type byKID []jwk.Key
 
 func (a byKID) Len() int           { return len(a) }
@@ -0,0 +1,101 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/lestrrat-go/jwx/jwa"
+	"github.com/lestrrat-go/jwx/jwk"
+	"github.com/spf13/cobra"
+)
+
+// key algorithms of --require-alg, the CLI generates RS256 keys only as the
+// adapter and the remote-service proxy verify no other
+var keyAlgorithms = []string{jwa.RS256.String()}
+
+// KeyPolicy constrains the keys generated, eg. set by a platform team
+// wrapping the CLI with ARS_MIN_KEY_SIZE or a --flags-dir
+type KeyPolicy struct {
+	KeySize     int    // bits of the RSA keys generated
+	MinKeySize  int    // bits
+	RequireAlg  string // RS256, any if empty
+	MaxValidity string // age of the keys kept in a JWKS, by their key IDs, any if empty
+}
+
+// check returns the policy violation of generating a key, if any
+func (p KeyPolicy) check() error {
+	if p.RequireAlg != "" && !contains(keyAlgorithms, p.RequireAlg) {
+		return WithCode(CodeInvalidFlags, fmt.Errorf("--require-alg must be RS256, the algorithm the adapter verifies, got %s", p.RequireAlg))
+	}
+	if _, err := p.maxValidity(); err != nil {
+		return err
+	}
+	if p.KeySize < certKeyLength {
+		return WithCode(CodeInvalidFlags, fmt.Errorf("--key-size must be at least %d, got %d", certKeyLength, p.KeySize))
+	}
+	if p.KeySize < p.MinKeySize {
+		return policyViolation("key size %d is below --min-key-size %d, raise --key-size", p.KeySize, p.MinKeySize)
+	}
+	return nil
+}
+
+// AddKeyPolicyFlags adds the flags of KeyPolicy to c, a command generating keys
+func (r *RootArgs) AddKeyPolicyFlags(c *cobra.Command) {
+	c.Flags().IntVarP(&r.KeyPolicy.KeySize, "key-size", "",
+		certKeyLength, "bits of the RSA keys generated")
+	c.Flags().IntVarP(&r.KeyPolicy.MinKeySize, "min-key-size", "",
+		0, "policy: fail generating keys of fewer bits")
+	c.Flags().StringVarP(&r.KeyPolicy.RequireAlg, "require-alg", "",
+		"", "policy: fail generating keys unless of this algorithm, RS256 (the one of the keys)")
+	c.Flags().StringVarP(&r.KeyPolicy.MaxValidity, "max-cert-validity", "",
+		"", `policy: fail rotating keys keeping one older than this in the JWKS (eg. "180d")`)
+}
+
+func (p KeyPolicy) maxValidity() (time.Duration, error) {
+	if p.MaxValidity == "" {
+		return 0, nil
+	}
+	d, err := ParseDuration(p.MaxValidity)
+	if err != nil || d <= 0 {
+		return 0, WithCode(CodeInvalidFlags, fmt.Errorf("--max-cert-validity must be a positive duration, got %q", p.MaxValidity))
+	}
+	return d, nil
+}
+
+// CheckJWKS returns the policy violation of a key of jwks older than
+// MaxValidity, its key ID being its creation time (see CreateNewKey)
+func (p KeyPolicy) CheckJWKS(jwks *jwk.Set, now time.Time) error {
+	limit, err := p.maxValidity()
+	if err != nil || limit == 0 {
+		return err
+	}
+	for _, key := range jwks.Keys {
+		created, err := time.Parse(time.RFC3339, key.KeyID())
+		if err != nil {
+			continue
+		}
+		if age := now.Sub(created); age > limit {
+			return policyViolation("key %s is %d days old, over --max-cert-validity %s, rotate keeping fewer keys (eg. a lower --truncate)",
+				key.KeyID(), int(age.Hours()/24), p.MaxValidity)
+		}
+	}
+	return nil
+}
+
+func policyViolation(format string, args ...interface{}) error {
+	return WithCode(CodePolicyViolation, fmt.Errorf("key policy violation: "+format, args...))
+}
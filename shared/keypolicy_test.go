@@ -0,0 +1,80 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/lestrrat-go/jwx/jwk"
+)
+
+func TestKeyPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy KeyPolicy
+		code   ErrorCode
+		err    string
+	}{
+		{KeyPolicy{KeySize: 2048}, "", ""},
+		{KeyPolicy{KeySize: 3072, MinKeySize: 3072, RequireAlg: "RS256", MaxValidity: "180d"}, "", ""},
+		{KeyPolicy{KeySize: 2048, MinKeySize: 3072}, CodePolicyViolation,
+			"key policy violation: key size 2048 is below --min-key-size 3072, raise --key-size"},
+		{KeyPolicy{KeySize: 2048, RequireAlg: "ES256"}, CodeInvalidFlags, "--require-alg must be RS256, the algorithm the adapter verifies, got ES256"},
+		{KeyPolicy{KeySize: 2048, RequireAlg: "HS256"}, CodeInvalidFlags, "--require-alg must be RS256, the algorithm the adapter verifies, got HS256"},
+		{KeyPolicy{KeySize: 1024}, CodeInvalidFlags, "--key-size must be at least 2048, got 1024"},
+		{KeyPolicy{KeySize: 2048, MaxValidity: "soon"}, CodeInvalidFlags, `--max-cert-validity must be a positive duration, got "soon"`},
+	} {
+		err := test.policy.check()
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%v: want no error, got %v", test.policy, err)
+			}
+			continue
+		}
+		testutil.ErrorContains(t, err, test.err)
+		if code := ErrorCodeOf(err); code != test.code {
+			t.Errorf("%v: want code %s, got %s", test.policy, test.code, code)
+		}
+	}
+
+	r := &RootArgs{KeyPolicy: KeyPolicy{MinKeySize: 4096}}
+	_, _, _, err := r.CreateNewKey()
+	testutil.ErrorContains(t, err, "key size 2048 is below --min-key-size 4096")
+}
+
+func TestKeyPolicyCheckJWKS(t *testing.T) {
+	now := time.Date(2020, 10, 14, 0, 0, 0, 0, time.UTC)
+	jwks := &jwk.Set{}
+	for _, kid := range []string{"2020-10-01T00:00:00Z", "2020-04-01T00:00:00Z", "not-a-time"} {
+		key, err := jwk.New([]byte("secret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := key.Set(jwk.KeyIDKey, kid); err != nil {
+			t.Fatal(err)
+		}
+		jwks.Keys = append(jwks.Keys, key)
+	}
+
+	if err := (KeyPolicy{}).CheckJWKS(jwks, now); err != nil {
+		t.Errorf("want no error without policy, got %v", err)
+	}
+	if err := (KeyPolicy{MaxValidity: "365d"}).CheckJWKS(jwks, now); err != nil {
+		t.Errorf("want no error, got %v", err)
+	}
+	err := KeyPolicy{MaxValidity: "180d"}.CheckJWKS(jwks, now)
+	testutil.ErrorContains(t, err, "key policy violation: key 2020-04-01T00:00:00Z is 196 days old, over --max-cert-validity 180d")
+}
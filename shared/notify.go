@@ -0,0 +1,289 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/smtp"
+	neturl "net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v3"
+)
+
+// Events notified to the hooks of NotifyConfig
+const (
+	EventCertRotated       = "cert.rotated"
+	EventCredentialCreated = "credential.created"
+	EventProvisionFailed   = "provision.failed"
+)
+
+// SignatureHeader holds the hex HMAC-SHA256 of a notification, keyed by the
+// signing key, as "sha256=<hex>"
+const SignatureHeader = "X-Apigee-Remote-Service-Signature"
+
+var notifyEvents = []string{EventCertRotated, EventCredentialCreated, EventProvisionFailed}
+
+var (
+	notifyTimeout = 10 * time.Second
+	sendMail      = smtp.SendMail
+)
+
+// NotifyConfig configures the hooks notified of events, in the notifications
+// of a workspace or the file of --notify-config. Files are relative to it.
+type NotifyConfig struct {
+	Events         []string     `yaml:"events,omitempty"`         // default: all
+	SigningKeyFile string       `yaml:"signingKeyFile,omitempty"` // key of SignatureHeader
+	Webhooks       []string     `yaml:"webhooks,omitempty"`       // URLs POSTed the notification
+	Slack          []string     `yaml:"slack,omitempty"`          // Slack incoming webhook URLs
+	SMTP           []SMTPNotify `yaml:"smtp,omitempty"`
+
+	dir        string
+	signingKey []byte
+}
+
+// SMTPNotify mails notifications
+type SMTPNotify struct {
+	Server       string   `yaml:"server"` // host:port
+	From         string   `yaml:"from"`
+	To           []string `yaml:"to"`
+	Username     string   `yaml:"username,omitempty"`
+	PasswordFile string   `yaml:"passwordFile,omitempty"`
+}
+
+// Notification is the JSON payload sent to the hooks
+type Notification struct {
+	Event        string            `json:"event"`
+	Time         time.Time         `json:"time"`
+	Organization string            `json:"organization,omitempty"`
+	Environment  string            `json:"environment,omitempty"`
+	User         string            `json:"user,omitempty"` // user running the CLI
+	Host         string            `json:"host,omitempty"`
+	Details      map[string]string `json:"details,omitempty"`
+}
+
+// LoadNotifyConfig loads the hooks of the file
+func LoadNotifyConfig(file string) (*NotifyConfig, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading notify config")
+	}
+	c := &NotifyConfig{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, errors.Wrapf(err, "parsing notify config %s", file)
+	}
+	return c, c.init(filepath.Dir(file))
+}
+
+// init validates the hooks and loads the signing key, files relative to dir
+func (c *NotifyConfig) init(dir string) error {
+	c.dir = dir
+	for _, event := range c.Events {
+		if !contains(notifyEvents, event) {
+			return fmt.Errorf("unknown notification event %s, must be one of: %s", event, strings.Join(notifyEvents, ", "))
+		}
+	}
+	for _, s := range c.SMTP {
+		if s.Server == "" || s.From == "" || len(s.To) == 0 {
+			return fmt.Errorf("smtp notifications require server, from and to")
+		}
+	}
+	if c.SigningKeyFile != "" {
+		key, err := ioutil.ReadFile(c.path(c.SigningKeyFile))
+		if err != nil {
+			return errors.Wrap(err, "reading notification signing key")
+		}
+		c.signingKey = bytes.TrimSpace(key)
+	}
+	return nil
+}
+
+func (c *NotifyConfig) path(file string) string {
+	if filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(c.dir, file)
+}
+
+// loadNotifyConfig loads the hooks of NotifyConfigPath, or of the workspace
+func (r *RootArgs) loadNotifyConfig() (err error) {
+	switch {
+	case r.NotifyConfigPath != "":
+		r.NotifyConfig, err = LoadNotifyConfig(r.NotifyConfigPath)
+	case r.Workspace != nil && r.Workspace.Notifications != nil:
+		r.NotifyConfig = r.Workspace.Notifications
+		err = r.NotifyConfig.init(r.Workspace.Dir)
+	}
+	return err
+}
+
+// Notify sends the event to the hooks of NotifyConfig, if any, warning of the
+// hooks that failed: a failed notification doesn't fail the command
+func (r *RootArgs) Notify(event string, details map[string]string, warnf FormatFn) {
+	c := r.NotifyConfig
+	if c == nil || (len(c.Events) > 0 && !contains(c.Events, event)) {
+		return
+	}
+	n := Notification{
+		Event:        event,
+		Time:         time.Now().UTC(),
+		Organization: r.Org,
+		Environment:  r.Env,
+		Details:      details,
+	}
+	if u, err := user.Current(); err == nil {
+		n.User = u.Username
+	}
+	n.Host, _ = os.Hostname()
+	if err := c.send(n); err != nil {
+		for _, err := range multierr.Errors(err) {
+			warnf("WARNING: notifying %s: %v", event, err)
+		}
+	}
+}
+
+// sign returns the value of SignatureHeader for payload, if there's a key
+func (c *NotifyConfig) sign(payload []byte) string {
+	if len(c.signingKey) == 0 {
+		return ""
+	}
+	mac := hmac.New(sha256.New, c.signingKey)
+	_, _ = mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *NotifyConfig) send(n Notification) error {
+	payload, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	signature := c.sign(payload)
+
+	var errs error
+	for _, url := range c.Webhooks {
+		errs = multierr.Append(errs, postJSON(url, payload, signature))
+	}
+	if len(c.Slack) > 0 {
+		text, err := json.Marshal(map[string]string{"text": n.summary()})
+		if err != nil {
+			return err
+		}
+		for _, url := range c.Slack {
+			errs = multierr.Append(errs, postJSON(url, text, c.sign(text)))
+		}
+	}
+	for _, s := range c.SMTP {
+		errs = multierr.Append(errs, c.mail(s, n, payload, signature))
+	}
+	return errs
+}
+
+// summary is the notification as a line of text
+func (n Notification) summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "apigee-remote-service-cli: %s", n.Event)
+	if n.Organization != "" {
+		fmt.Fprintf(&b, " in %s/%s", n.Organization, n.Environment)
+	}
+	if n.User != "" {
+		fmt.Fprintf(&b, " by %s@%s", n.User, n.Host)
+	}
+	keys := make([]string, 0, len(n.Details))
+	for k := range n.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, ", %s: %s", k, n.Details[k])
+	}
+	return b.String()
+}
+
+func postJSON(url string, payload []byte, signature string) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if signature != "" {
+		req.Header.Set(SignatureHeader, signature)
+	}
+	// only the host is reported, the path of a Slack webhook is its secret
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		if urlErr, ok := err.(*neturl.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("POST to %s: %v", req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("POST to %s: %s", req.URL.Host, resp.Status)
+	}
+	return nil
+}
+
+func (c *NotifyConfig) mail(s SMTPNotify, n Notification, payload []byte, signature string) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		var password []byte
+		if s.PasswordFile != "" {
+			var err error
+			if password, err = ioutil.ReadFile(c.path(s.PasswordFile)); err != nil {
+				return errors.Wrap(err, "reading smtp password")
+			}
+		}
+		host := strings.Split(s.Server, ":")[0]
+		auth = smtp.PlainAuth("", s.Username, string(bytes.TrimSpace(password)), host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.summary())
+	fmt.Fprintf(&msg, "Content-Type: application/json\r\n")
+	if signature != "" {
+		fmt.Fprintf(&msg, "%s: %s\r\n", SignatureHeader, signature)
+	}
+	fmt.Fprintf(&msg, "\r\n%s\r\n", payload)
+	if err := sendMail(s.Server, auth, s.From, s.To, msg.Bytes()); err != nil {
+		return errors.Wrapf(err, "mailing %s", s.Server)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestNotify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "signing.key"), []byte("sekret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var webhook, slack []byte
+	var signature string
+	m := http.NewServeMux()
+	m.HandleFunc("/webhook", func(w http.ResponseWriter, r *http.Request) {
+		webhook, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(SignatureHeader)
+	})
+	m.HandleFunc("/slack/T000/B000/XXXX", func(w http.ResponseWriter, r *http.Request) {
+		slack, _ = ioutil.ReadAll(r.Body)
+	})
+	m.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	ts := httptest.NewServer(m)
+	defer ts.Close()
+
+	var mail string
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		if addr != "smtp.example.com:587" || a == nil || from != "cli@example.com" || len(to) != 1 {
+			t.Errorf("unexpected mail to %s from %s to %v", addr, from, to)
+		}
+		mail = string(msg)
+		return nil
+	}
+	defer func() { sendMail = smtp.SendMail }()
+
+	ws := &Workspace{Dir: dir, Notifications: &NotifyConfig{
+		Events:         []string{EventCertRotated},
+		SigningKeyFile: "signing.key",
+		Webhooks:       []string{ts.URL + "/webhook", ts.URL + "/down"},
+		Slack:          []string{ts.URL + "/slack/T000/B000/XXXX"},
+		SMTP: []SMTPNotify{{
+			Server: "smtp.example.com:587", From: "cli@example.com", To: []string{"security@example.com"},
+			Username: "cli", PasswordFile: "signing.key",
+		}},
+	}}
+	r := &RootArgs{Org: "org", Env: "test", Workspace: ws}
+	if err := r.loadNotifyConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	print := testutil.Printer("TestNotify")
+	r.Notify(EventCredentialCreated, nil, print.Printf)
+	if webhook != nil || slack != nil || mail != "" {
+		t.Errorf("want events not configured ignored")
+	}
+
+	r.Notify(EventCertRotated, map[string]string{"kid": "mykid"}, print.Printf)
+	print.Check(t, []string{"WARNING: notifying cert.rotated: POST to " + strings.TrimPrefix(ts.URL, "http://") + ": 502 Bad Gateway"})
+
+	var n Notification
+	if err := json.Unmarshal(webhook, &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.Event != EventCertRotated || n.Organization != "org" || n.Environment != "test" || n.Details["kid"] != "mykid" || n.Time.IsZero() {
+		t.Errorf("unexpected notification: %+v", n)
+	}
+	mac := hmac.New(sha256.New, []byte("sekret"))
+	_, _ = mac.Write(webhook)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); signature != want {
+		t.Errorf("want signature %s, got %s", want, signature)
+	}
+
+	var text map[string]string
+	if err := json.Unmarshal(slack, &text); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(text["text"], "apigee-remote-service-cli: cert.rotated in org/test") || !strings.HasSuffix(text["text"], ", kid: mykid") {
+		t.Errorf("unexpected slack message: %s", slack)
+	}
+
+	for _, want := range []string{"To: security@example.com\r\n", SignatureHeader + ": " + signature + "\r\n", string(webhook)} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("want %q in mail:\n%s", want, mail)
+		}
+	}
+}
+
+func TestLoadNotifyConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "notify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for content, wantErr := range map[string]string{
+		"events: [cert.rotated, bogus]\n": "unknown notification event bogus, must be one of: cert.rotated, credential.created, provision.failed",
+		"smtp:\n- server: smtp:25\n":      "smtp notifications require server, from and to",
+		"signingKeyFile: missing\n":       "reading notification signing key",
+		"webhooks: {}\n":                  "parsing notify config",
+	} {
+		file := filepath.Join(dir, "notify.yaml")
+		if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadNotifyConfig(file)
+		testutil.ErrorContains(t, err, wantErr)
+	}
+
+	r := &RootArgs{NotifyConfigPath: filepath.Join(dir, "missing.yaml")}
+	testutil.ErrorContains(t, r.loadNotifyConfig(), "reading notify config")
+}
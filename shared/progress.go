@@ -0,0 +1,79 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const progressWidth = 30
+
+// Progress renders a progress bar, it is safe for concurrent use
+type Progress struct {
+	w     io.Writer
+	label string
+	total int
+	done  int
+	mu    sync.Mutex
+}
+
+// NewProgress returns a Progress for total steps writing to w.
+// A nil w returns a Progress that renders nothing.
+func NewProgress(w io.Writer, label string, total int) *Progress {
+	return &Progress{w: w, label: label, total: total}
+}
+
+// NewTerminalProgress returns a Progress writing to stderr if it is a terminal
+// and not NonInteractive
+func NewTerminalProgress(label string, total int) *Progress {
+	if NonInteractive || !IsTerminal(os.Stderr) {
+		return NewProgress(nil, label, total)
+	}
+	return NewProgress(os.Stderr, label, total)
+}
+
+// IsTerminal returns true if f is a character device
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Increment completes a step
+func (p *Progress) Increment() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.render()
+}
+
+// Done ends the bar's line
+func (p *Progress) Done() {
+	if p.w != nil {
+		fmt.Fprintln(p.w)
+	}
+}
+
+func (p *Progress) render() {
+	if p.w == nil || p.total == 0 {
+		return
+	}
+	filled := progressWidth * p.done / p.total
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressWidth-filled)
+	fmt.Fprintf(p.w, "\r%s [%s] %d/%d", p.label, bar, p.done, p.total)
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/apigee/apigee-remote-service-envoy/server"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ConfigMapName is the name of the ConfigMap holding the remote service config
+	ConfigMapName = "apigee-remote-service-envoy"
+
+	// PolicySecretNameFormat is the format of the Secret name holding the policy keys (hybrid)
+	PolicySecretNameFormat = "%s-%s-policy-secret" // org, env
+)
+
+// RemoteService custom resource of `provision --emit-crd`
+const (
+	RemoteServiceAPIVersion = "apigee.cloud.google.com/v1alpha1"
+	RemoteServiceKind       = "ApigeeRemoteService"
+)
+
+// Platforms as reported by provision
+const (
+	PlatformGCP  = "GCP"
+	PlatformSaaS = "SaaS"
+	PlatformOPDK = "OPDK"
+)
+
+// ProvisionResult is the machine readable output of `provision --output json`
+type ProvisionResult struct {
+	Platform     string `json:"platform" yaml:"platform"`
+	Organization string `json:"organization" yaml:"organization"`
+	Environment  string `json:"environment" yaml:"environment"`
+	Runtime      string `json:"runtime" yaml:"runtime"`
+	Namespace    string `json:"namespace" yaml:"namespace"`
+	ConfigMap    string `json:"configMap" yaml:"configMap"`
+	Secret       string `json:"secret,omitempty" yaml:"secret,omitempty"`
+	Verified     bool   `json:"verified" yaml:"verified"`
+	Resources    string `json:"resources,omitempty" yaml:"resources,omitempty"`
+
+	Credential *Credential     `json:"credential,omitempty" yaml:"credential,omitempty"`
+	Endpoints  Endpoints       `json:"endpoints" yaml:"endpoints"`
+	Proxies    []DeployedProxy `json:"proxies,omitempty" yaml:"proxies,omitempty"`
+
+	SecretData map[string][]byte `json:"-" yaml:"-"` // decoded data of the Secret read by ReadProvisionResult
+}
+
+// Credential is the key and secret of the remote-service app
+type Credential struct {
+	Key    string `json:"key" yaml:"key"`
+	Secret string `json:"secret" yaml:"secret"`
+}
+
+// Endpoints are the APIs the remote service adapter calls
+type Endpoints struct {
+	RemoteServiceAPI string `json:"remoteServiceAPI" yaml:"remoteServiceAPI"`
+	InternalAPI      string `json:"internalAPI,omitempty" yaml:"internalAPI,omitempty"`
+	AnalyticsAPI     string `json:"analyticsAPI,omitempty" yaml:"analyticsAPI,omitempty"`
+}
+
+// DeployedProxy is a proxy revision deployed by provision
+type DeployedProxy struct {
+	Name     string `json:"name" yaml:"name"`
+	Revision int    `json:"revision" yaml:"revision"`
+}
+
+// RemoteService is a provisioned environment as a custom resource, referring
+// to the ConfigMap and Secret holding its config and keys
+type RemoteService struct {
+	APIVersion string              `yaml:"apiVersion"`
+	Kind       string              `yaml:"kind"`
+	Metadata   server.Metadata     `yaml:"metadata"`
+	Spec       RemoteServiceSpec   `yaml:"spec"`
+	Status     RemoteServiceStatus `yaml:"status"`
+}
+
+// RemoteServiceSpec describes the provisioned environment
+type RemoteServiceSpec struct {
+	Platform     string          `yaml:"platform"`
+	Organization string          `yaml:"organization"`
+	Environment  string          `yaml:"environment"`
+	Runtime      string          `yaml:"runtime"`
+	ConfigMap    string          `yaml:"configMap"`
+	Secret       string          `yaml:"secret,omitempty"`
+	Endpoints    Endpoints       `yaml:"endpoints"`
+	Proxies      []DeployedProxy `yaml:"proxies,omitempty"`
+}
+
+// RemoteServiceStatus is the state of the provisioned environment
+type RemoteServiceStatus struct {
+	Verified bool `yaml:"verified"`
+}
+
+// RemoteService returns the result as a custom resource named name, the
+// credential is left to the ConfigMap
+func (r *ProvisionResult) RemoteService(name string) RemoteService {
+	return RemoteService{
+		APIVersion: RemoteServiceAPIVersion,
+		Kind:       RemoteServiceKind,
+		Metadata:   server.Metadata{Name: name, Namespace: r.Namespace},
+		Spec: RemoteServiceSpec{
+			Platform:     r.Platform,
+			Organization: r.Organization,
+			Environment:  r.Environment,
+			Runtime:      r.Runtime,
+			ConfigMap:    r.ConfigMap,
+			Secret:       r.Secret,
+			Endpoints:    r.Endpoints,
+			Proxies:      r.Proxies,
+		},
+		Status: RemoteServiceStatus{Verified: r.Verified},
+	}
+}
+
+// ReadProvisionResult reads the output of a provision run, either the
+// JSON (or YAML) result of `provision --output json` or the Kubernetes
+// resources emitted by default, with --emit-crd the custom resource
+func ReadProvisionResult(file string) (*ProvisionResult, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ProvisionResult{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var node yaml.Node
+		if err := decoder.Decode(&node); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", file)
+		}
+
+		var head struct {
+			Kind         string `yaml:"kind"`
+			Organization string `yaml:"organization"`
+		}
+		if err := node.Decode(&head); err != nil {
+			return nil, errors.Wrapf(err, "parsing %s", file)
+		}
+
+		switch {
+		case head.Organization != "":
+			if err := node.Decode(result); err != nil {
+				return nil, errors.Wrapf(err, "parsing %s", file)
+			}
+		case head.Kind == "ConfigMap":
+			if err := result.fromConfigMap(&node); err != nil {
+				return nil, errors.Wrapf(err, "parsing %s", file)
+			}
+		case head.Kind == "Secret":
+			secret := server.SecretCRD{}
+			if err := node.Decode(&secret); err != nil {
+				return nil, errors.Wrapf(err, "parsing %s", file)
+			}
+			result.Secret = secret.Metadata.Name
+			result.SecretData = map[string][]byte{}
+			for k, v := range secret.Data {
+				data, err := base64.StdEncoding.DecodeString(v)
+				if err != nil {
+					return nil, errors.Wrapf(err, "parsing %s: data %s of Secret", file, k)
+				}
+				result.SecretData[k] = data
+			}
+		case head.Kind == RemoteServiceKind:
+			if err := result.fromRemoteService(&node); err != nil {
+				return nil, errors.Wrapf(err, "parsing %s", file)
+			}
+		}
+	}
+
+	if result.Organization == "" {
+		return nil, fmt.Errorf("%s is not a provision result", file)
+	}
+	return result, nil
+}
+
+func (r *ProvisionResult) fromConfigMap(node *yaml.Node) error {
+	configMap := server.ConfigMapCRD{}
+	if err := node.Decode(&configMap); err != nil {
+		return err
+	}
+	config := server.Config{}
+	if err := yaml.Unmarshal([]byte(configMap.Data["config.yaml"]), &config); err != nil {
+		return errors.Wrap(err, "bad config format")
+	}
+
+	r.Organization = config.Tenant.OrgName
+	r.Environment = config.Tenant.EnvName
+	r.Runtime = strings.Split(config.Tenant.RemoteServiceAPI, remoteServicePath)[0]
+	r.Namespace = configMap.Metadata.Namespace
+	r.ConfigMap = configMap.Metadata.Name
+	r.SetConfig(&config)
+	switch {
+	case config.IsGCPManaged():
+		r.Platform = PlatformGCP
+	case config.IsApigeeManaged():
+		r.Platform = PlatformSaaS
+	default:
+		r.Platform = PlatformOPDK
+	}
+	return nil
+}
+
+// fromRemoteService sets the values of the custom resource, the ConfigMap
+// keeps the credential
+func (r *ProvisionResult) fromRemoteService(node *yaml.Node) error {
+	rs := RemoteService{}
+	if err := node.Decode(&rs); err != nil {
+		return err
+	}
+	r.Platform = rs.Spec.Platform
+	r.Organization = rs.Spec.Organization
+	r.Environment = rs.Spec.Environment
+	r.Runtime = rs.Spec.Runtime
+	r.Namespace = rs.Metadata.Namespace
+	r.ConfigMap = rs.Spec.ConfigMap
+	r.Secret = rs.Spec.Secret
+	r.Endpoints = rs.Spec.Endpoints
+	r.Proxies = rs.Spec.Proxies
+	r.Verified = rs.Status.Verified
+	return nil
+}
+
+// SetConfig sets the credential and endpoints of the result from the adapter config
+func (r *ProvisionResult) SetConfig(config *server.Config) {
+	r.Credential = nil
+	if config.Tenant.Key != "" {
+		r.Credential = &Credential{Key: config.Tenant.Key, Secret: config.Tenant.Secret}
+	}
+	r.Endpoints = Endpoints{
+		RemoteServiceAPI: config.Tenant.RemoteServiceAPI,
+		InternalAPI:      config.Tenant.InternalAPI,
+		AnalyticsAPI:     config.Analytics.FluentdEndpoint,
+	}
+}
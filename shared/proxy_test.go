@@ -0,0 +1,72 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestProxyURL(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer proxy.Close()
+
+	r := &RootArgs{
+		ManagementBase: "http://management.example.com",
+		RuntimeBase:    "http://runtime.example.com",
+		Org:            "org",
+		Env:            "test",
+		Token:          "token",
+		ProxyURL:       proxy.URL,
+	}
+	if err := r.Resolve(false, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// management
+	req, err := r.ApigeeClient.NewRequest(http.MethodGet, "caches", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ApigeeClient.Do(req, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// runtime
+	client := &http.Client{Transport: r.Transport(false)}
+	resp, err := client.Get(r.RemoteServiceProxyURL + "/certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	want := []string{
+		"http://management.example.com/v1/organizations/org/environments/test/caches",
+		"http://runtime.example.com/remote-service/certs",
+	}
+	if len(proxied) != 2 || proxied[0] != want[0] || proxied[1] != want[1] {
+		t.Errorf("want %v proxied, got %v", want, proxied)
+	}
+
+	r = &RootArgs{RuntimeBase: "http://runtime.example.com", Token: "token", ProxyURL: "proxy:8080"}
+	testutil.ErrorContains(t, r.Resolve(false, true), `--proxy-url "proxy:8080" is not an absolute URL`)
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"fmt"
+	"time"
+)
+
+// rateLimitWarnConsumed and rateLimitWarnRemaining are the percentages of
+// the management API rate limit a run may consume, or leave remaining,
+// before a warning
+const (
+	rateLimitWarnConsumed  = 50
+	rateLimitWarnRemaining = 10
+)
+
+// PrintRateLimits prints the requests the command sent to the management
+// API and the rate limit remaining as reported by its responses, warning
+// if the run was throttled or consumed much of it, eg. at the end of
+// commands sending many requests
+func (r *RootArgs) PrintRateLimits(printf FormatFn) {
+	if r.RateLimits == nil {
+		return
+	}
+	s := r.RateLimits.Summary()
+	if s.Requests == 0 {
+		return
+	}
+	msg := fmt.Sprintf("management API: %d request(s)", s.Requests)
+	if s.Throttled > 0 {
+		msg += fmt.Sprintf(", %d throttled", s.Throttled)
+	}
+	switch {
+	case s.Remaining < 0:
+		msg += ", no rate limit reported"
+	case s.Limit > 0:
+		msg += fmt.Sprintf(", %d of %d remaining", s.Remaining, s.Limit)
+	default:
+		msg += fmt.Sprintf(", %d remaining", s.Remaining)
+	}
+	if s.Remaining >= 0 && !s.Reset.IsZero() {
+		msg += " until " + s.Reset.Format(time.RFC3339)
+	}
+	printf("%s", msg)
+
+	switch {
+	case s.Throttled > 0:
+		printf("warning: %d management API request(s) were throttled (429), run fewer in parallel or space bulk runs apart", s.Throttled)
+	case s.Limit > 0 && s.Requests*100 >= s.Limit*rateLimitWarnConsumed:
+		printf("warning: the run consumed %d%% of the management API rate limit, space bulk runs apart", s.Requests*100/s.Limit)
+	case s.Limit > 0 && s.Remaining >= 0 && s.Remaining*100 < s.Limit*rateLimitWarnRemaining:
+		printf("warning: %d%% of the management API rate limit remaining, wait for it to reset before bulk runs", s.Remaining*100/s.Limit)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/apigee"
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestPrintRateLimits(t *testing.T) {
+	reset := time.Unix(1900000000, 0).Format(time.RFC3339)
+	for _, test := range []struct {
+		name      string
+		headers   map[string]string
+		requests  int
+		throttled int // of the first requests, retried
+		want      []string
+	}{
+		{"unreported", nil, 2, 0, []string{"management API: 2 request(s), no rate limit reported"}},
+		{"remaining", map[string]string{"X-RateLimit-Limit": "100", "X-RateLimit-Remaining": "60", "X-RateLimit-Reset": "1900000000"}, 3, 0,
+			[]string{"management API: 3 request(s), 60 of 100 remaining until " + reset}},
+		{"throttled", map[string]string{"X-RateLimit-Limit": "100", "X-RateLimit-Remaining": "0"}, 1, 1, []string{
+			"management API: 2 request(s), 1 throttled, 0 of 100 remaining",
+			"warning: 1 management API request(s) were throttled (429), run fewer in parallel or space bulk runs apart",
+		}},
+		{"consumed", map[string]string{"RateLimit-Limit": "10, 10;w=60", "RateLimit-Remaining": "4"}, 6, 0, []string{
+			"management API: 6 request(s), 4 of 10 remaining",
+			"warning: the run consumed 60% of the management API rate limit, space bulk runs apart",
+		}},
+		{"low", map[string]string{"X-RateLimit-Limit": "1000", "X-RateLimit-Remaining": "50"}, 1, 0, []string{
+			"management API: 1 request(s), 50 of 1000 remaining",
+			"warning: 5% of the management API rate limit remaining, wait for it to reset before bulk runs",
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			throttle := test.throttled
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range test.headers {
+					w.Header().Set(k, v)
+				}
+				if throttle > 0 {
+					throttle--
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(http.StatusTooManyRequests)
+					return
+				}
+				_, _ = w.Write([]byte("{}"))
+			}))
+			defer ts.Close()
+
+			r := &RootArgs{RateLimits: apigee.NewRateLimits()}
+			client, err := apigee.NewEdgeClient(&apigee.EdgeClientOptions{
+				MgmtURL:    ts.URL,
+				Org:        "org",
+				Auth:       &apigee.EdgeAuth{BearerToken: "token"},
+				Retries:    1,
+				RateLimits: r.RateLimits,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < test.requests; i++ {
+				req, err := client.NewRequestNoEnv(http.MethodGet, "apiproducts", nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := client.Do(req, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			print := testutil.Printer(test.name)
+			r.PrintRateLimits(print.Printf)
+			print.Check(t, test.want)
+		})
+	}
+
+	print := testutil.Printer("nothing sent")
+	(&RootArgs{RateLimits: apigee.NewRateLimits()}).PrintRateLimits(print.Printf)
+	(&RootArgs{}).PrintRateLimits(print.Printf)
+	print.Check(t, nil)
+}
@@ -0,0 +1,71 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// loadClientCert loads the certificate and key of a pair of flags, nil if neither is given
+func loadClientCert(certFlag, certFile, keyFlag, keyFile string) ([]tls.Certificate, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, WithCode(CodeInvalidFlags, fmt.Errorf("--%s and --%s must be given together", certFlag, keyFlag))
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, WithCode(CodeInvalidFlags, fmt.Errorf("--%s %s: %v", certFlag, certFile, err))
+	}
+	return []tls.Certificate{cert}, nil
+}
+
+// isRuntimeHost reports whether host serves the runtime of an environment and
+// not the management API, as on OPDK by default
+func (r *RootArgs) isRuntimeHost(host string) bool {
+	if u, err := url.Parse(r.ManagementBase); err == nil && u.Host == host {
+		return false
+	}
+	bases := []string{r.RuntimeBase, r.InternalProxyURL}
+	for _, base := range r.Runtimes {
+		bases = append(bases, base)
+	}
+	for _, base := range bases {
+		if u, err := url.Parse(base); err == nil && u.Host != "" && u.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+// runtimeRouter sends requests to the runtime with its own transport, so the
+// token and rotate requests of the management client present the runtime's
+// client certificate
+type runtimeRouter struct {
+	isRuntime  func(host string) bool
+	runtime    http.RoundTripper
+	management http.RoundTripper
+}
+
+func (rr *runtimeRouter) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rr.isRuntime(req.URL.Host) {
+		return rr.runtime.RoundTrip(req)
+	}
+	return rr.management.RoundTrip(req)
+}
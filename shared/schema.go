@@ -0,0 +1,120 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JSONSchemaDraft is the JSON Schema version of the schemas of the CLI's files
+const JSONSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// durationPattern matches the durations of time.ParseDuration, eg. "1h30m"
+const durationPattern = `^-?([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	timeType     = reflect.TypeOf(time.Time{})
+)
+
+// JSONSchema is a JSON Schema document
+type JSONSchema map[string]interface{}
+
+// SchemaOf returns the schema of the files v is marshaled to, fields are named
+// by their tag (json or yaml). Fields without omitempty are required and
+// unknown fields are rejected, to catch typos in hand-edited files.
+func SchemaOf(v interface{}, tag, title string) JSONSchema {
+	s := schemaOfType(reflect.TypeOf(v), tag)
+	s["$schema"] = JSONSchemaDraft
+	s["title"] = title
+	return s
+}
+
+func schemaOfType(t reflect.Type, tag string) JSONSchema {
+	switch t {
+	case durationType:
+		return JSONSchema{"type": "string", "pattern": durationPattern}
+	case timeType:
+		return JSONSchema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOfType(t.Elem(), tag)
+	case reflect.String:
+		return JSONSchema{"type": "string"}
+	case reflect.Bool:
+		return JSONSchema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return JSONSchema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return JSONSchema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return JSONSchema{"type": "array", "items": schemaOfType(t.Elem(), tag)}
+	case reflect.Map:
+		return JSONSchema{"type": "object", "additionalProperties": schemaOfType(t.Elem(), tag)}
+	case reflect.Struct:
+		s := JSONSchema{"type": "object", "additionalProperties": false}
+		props := JSONSchema{}
+		var required []string
+		addFields(t, tag, props, &required)
+		s["properties"] = props
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	return JSONSchema{} // anything
+}
+
+// addFields adds the properties of the fields of struct t, inlined fields included
+func addFields(t reflect.Type, tag string, props JSONSchema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		name, opts := f.Tag.Get(tag), ""
+		if i := strings.Index(name, ","); i >= 0 {
+			name, opts = name[:i], name[i:]
+		}
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(opts, ",inline") || f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				addFields(ft, tag, props, required)
+				continue
+			}
+		}
+		if name == "" {
+			name = f.Name
+			if tag == "yaml" {
+				name = strings.ToLower(name)
+			}
+		}
+		props[name] = schemaOfType(f.Type, tag)
+		if !strings.Contains(opts, ",omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSchemaOf(t *testing.T) {
+	type inner struct {
+		Count int `yaml:"count"`
+	}
+	type file struct {
+		Inline   inner             `yaml:",inline"`
+		Name     string            `yaml:"name"`
+		Interval time.Duration     `yaml:"interval,omitempty"`
+		Created  time.Time         `yaml:"created,omitempty"`
+		Labels   map[string]string `yaml:"labels,omitempty"`
+		Items    []*inner          `yaml:"items,omitempty"`
+		Enabled  bool
+		Skipped  string `yaml:"-"`
+		hidden   string
+	}
+
+	s := SchemaOf(file{}, "yaml", "test file")
+	if s["$schema"] != JSONSchemaDraft || s["title"] != "test file" || s["additionalProperties"] != false {
+		t.Errorf("unexpected schema %v", s)
+	}
+	props := s["properties"].(JSONSchema)
+	want := JSONSchema{
+		"count":    JSONSchema{"type": "integer"},
+		"name":     JSONSchema{"type": "string"},
+		"interval": JSONSchema{"type": "string", "pattern": durationPattern},
+		"created":  JSONSchema{"type": "string", "format": "date-time"},
+		"labels":   JSONSchema{"type": "object", "additionalProperties": JSONSchema{"type": "string"}},
+		"items": JSONSchema{"type": "array", "items": JSONSchema{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties":           JSONSchema{"count": JSONSchema{"type": "integer"}},
+			"required":             []string{"count"},
+		}},
+		"enabled": JSONSchema{"type": "boolean"},
+	}
+	if !reflect.DeepEqual(want, props) {
+		t.Errorf("want properties %v, got %v", want, props)
+	}
+	if required := s["required"]; !reflect.DeepEqual([]string{"count", "name", "enabled"}, required) {
+		t.Errorf("want fields without omitempty required, got %v", required)
+	}
+}
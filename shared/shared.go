@@ -15,18 +15,25 @@
 package shared
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net/http"
 	"net/url"
 	"os"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/apigee/apigee-remote-service-cli/apigee"
 	"github.com/apigee/apigee-remote-service-cli/testutil"
 	"github.com/apigee/apigee-remote-service-envoy/server"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 const (
@@ -42,17 +49,29 @@ const (
 	// RuntimeBaseFormat is a format for base of the organization runtime URL (legacy SaaS and OPDK)
 	RuntimeBaseFormat = "https://%s-%s.apigee.net"
 
+	// DefaultDeveloperEmail is the developer owning the remote-service app (hybrid)
+	DefaultDeveloperEmail = "remote-service@apigee.com"
+
+	// DefaultAppName is the app holding the remote-service credential (hybrid)
+	DefaultAppName = "remote-service"
+
+	// DefaultAppGroupName is the AppGroup owning the remote-service app if AppGroups are used (hybrid)
+	DefaultAppGroupName = "remote-service"
+
 	internalProxyURLFormat      = "%s://istioservices.%s/edgemicro" // runtime scheme, runtime domain (legacy SaaS and OPDK)
 	internalProxyURLFormatOPDK  = "%s/edgemicro"                    // runtimeBase
 	remoteServicePath           = "/remote-service"
 	remoteServiceProxyURLFormat = "%s" + remoteServicePath // runtimeBase
+
+	defaultRetries      = 3
+	defaultRetryBackoff = time.Second
 )
 
 // BuildInfoType holds version information
 type BuildInfoType struct {
-	Version string
-	Commit  string
-	Date    string
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
 }
 
 // BuildInfo is populated by main init()
@@ -62,42 +81,83 @@ var BuildInfo BuildInfoType
 type RootArgs struct {
 	RuntimeBase        string // "https://org-env.apigee.net"
 	ManagementBase     string // "https://api.enterprise.apigee.com"
-	Verbose            bool
+	Verbose            bool   // enables all trace domains
+	TraceHTTP          bool
+	TraceSteps         bool
+	TraceTemplates     bool
+	StrictDecode       bool // fail on management API response fields unknown to the client
 	Org                string
 	Env                string
+	Envs               []string // as given by --environment, Env joins them
 	Username           string
 	Password           string
 	Token              string
+	ServiceAccount     string // path to a service account key file minting tokens (hybrid)
 	NetrcPath          string
 	IsOPDK             bool
 	IsLegacySaaS       bool
 	IsGCPManaged       bool
 	ConfigPath         string
 	InsecureSkipVerify bool
+	ProxyURL           string
+	CACert             string // PEM file of CAs trusted besides the system's
+	ClientCert         string // PEM file of a client certificate for mTLS
+	ClientKey          string // PEM file of the key of ClientCert
+	RuntimeClientCert  string // PEM file of a client certificate for runtime mTLS, ClientCert otherwise
+	RuntimeClientKey   string // PEM file of the key of RuntimeClientCert
 	Namespace          string
-
-	ServerConfig *server.Config // config loaded from ConfigPath
+	EnvFile            string
+	FlagsDir           string // directory of files named after flags holding their values
+	CI                 bool   // non-interactive, JSON output and errors
+	WorkspacePath      string
+	NotifyConfigPath   string        // file of the hooks notified of events, the workspace's otherwise
+	Retries            int           // times failed management API requests are retried
+	RetryBackoff       time.Duration // delay before the first retry, doubled for each further one
+	Reauth             bool          // authenticate again once if a management API request is answered 401
+	KeyPolicy          KeyPolicy     // constrains the keys generated
+	Explain            bool          // record the calls for PrintExplain, sending no changes
+
+	ServerConfig *server.Config    // config loaded from ConfigPath
+	Workspace    *Workspace        // workspace found or given by WorkspacePath
+	NotifyConfig *NotifyConfig     // hooks loaded from NotifyConfigPath or the workspace
+	RoundTripper http.RoundTripper // if set, serves all requests instead of the network
 
 	// the following is derived in Resolve()
 	InternalProxyURL      string
 	RemoteServiceProxyURL string
+	Runtimes              map[string]string // runtime base URLs per environment, if --runtime is env=URL pairs
 	ApigeeClient          *apigee.EdgeClient
 	ClientOpts            *apigee.EdgeClientOptions
+	RateLimits            *apigee.RateLimits // of the requests of all clients, see PrintRateLimits
+	Plan                  *apigee.Plan       // of the requests of all clients with Explain
+	proxyURL              *url.URL           // parsed ProxyURL
+	rootCAs               *x509.CertPool     // system CAs and CACert
+	clientCerts           []tls.Certificate  // loaded ClientCert and ClientKey
+	runtimeClientCerts    []tls.Certificate  // loaded RuntimeClientCert and RuntimeClientKey
 }
 
 // AddCommandWithFlags adds to the root command with standard flags
 func AddCommandWithFlags(c *cobra.Command, rootArgs *RootArgs, cmds ...*cobra.Command) {
 	for _, subC := range cmds {
 		subC.PersistentFlags().StringVarP(&rootArgs.RuntimeBase, "runtime", "r",
-			"", "Apigee runtime base URL (required for hybrid or opdk)")
+			"", "Apigee runtime base URL, or env=URL pairs to give each environment its own (required for opdk, hybrid and Apigee X default to the hostname of the environment group)")
 
 		subC.PersistentFlags().BoolVarP(&rootArgs.Verbose, "verbose", "v",
-			false, "verbose output")
+			false, "verbose output (enables all --trace-* flags)")
+		subC.PersistentFlags().BoolVarP(&rootArgs.TraceHTTP, "trace-http", "",
+			false, "dump HTTP requests and responses to stderr")
+		subC.PersistentFlags().BoolVarP(&rootArgs.TraceSteps, "trace-steps", "",
+			false, "trace the steps of the command to stderr")
+		subC.PersistentFlags().BoolVarP(&rootArgs.TraceTemplates, "trace-templates", "",
+			false, "dump the data used to render output templates to stderr")
+		subC.PersistentFlags().BoolVarP(&rootArgs.StrictDecode, "strict-decode", "",
+			false, "fail if a management API response has fields unknown to the client (for development, --trace-http reports them)")
+		_ = subC.PersistentFlags().MarkHidden("strict-decode")
 
 		subC.PersistentFlags().StringVarP(&rootArgs.Org, "organization", "o",
 			"", "Apigee organization name")
-		subC.PersistentFlags().StringVarP(&rootArgs.Env, "environment", "e",
-			"", "Apigee environment name")
+		subC.PersistentFlags().StringSliceVarP(&rootArgs.Envs, "environment", "e",
+			nil, "Apigee environment name (provision accepts multiple)")
 
 		subC.PersistentFlags().StringVarP(&rootArgs.ConfigPath, "config", "c",
 			"", "Path to Apigee Remote Service config file")
@@ -105,6 +165,69 @@ func AddCommandWithFlags(c *cobra.Command, rootArgs *RootArgs, cmds ...*cobra.Co
 		subC.PersistentFlags().BoolVarP(&rootArgs.InsecureSkipVerify, "insecure", "",
 			false, "Allow insecure server connections when using SSL")
 
+		subC.PersistentFlags().StringVarP(&rootArgs.ProxyURL, "proxy-url", "",
+			"", "URL of a proxy for management and runtime requests (default: from HTTPS_PROXY, HTTP_PROXY and NO_PROXY)")
+		subC.PersistentFlags().StringVarP(&rootArgs.CACert, "ca-cert", "",
+			"", "Path to a PEM file of CA certificates to trust for management and runtime requests besides the system's")
+		subC.PersistentFlags().StringVarP(&rootArgs.ClientCert, "client-cert", "",
+			"", "Path to a PEM file of a client certificate presented to management and runtime endpoints (requires --client-key)")
+		subC.PersistentFlags().StringVarP(&rootArgs.ClientKey, "client-key", "",
+			"", "Path to a PEM file of the private key of --client-cert")
+		subC.PersistentFlags().StringVarP(&rootArgs.RuntimeClientCert, "runtime-tls-client-cert", "",
+			"", "Path to a PEM file of a client certificate presented to the runtime instead of --client-cert (requires --runtime-tls-client-key)")
+		subC.PersistentFlags().StringVarP(&rootArgs.RuntimeClientKey, "runtime-tls-client-key", "",
+			"", "Path to a PEM file of the private key of --runtime-tls-client-cert")
+
+		subC.PersistentFlags().IntVarP(&rootArgs.Retries, "retries", "",
+			defaultRetries, "times to retry management API requests failing with 429, 502, 503 or 504 (POST only on 429)")
+		subC.PersistentFlags().DurationVarP(&rootArgs.RetryBackoff, "retry-backoff", "",
+			defaultRetryBackoff, "delay before the first retry, doubled and jittered for each further one (a Retry-After takes precedence, up to a minute)")
+		subC.PersistentFlags().BoolVarP(&rootArgs.Reauth, "reauth", "",
+			true, "authenticate again and retry once a management API request answered 401 (service account or application default credentials, or ~/.netrc)")
+
+		subC.PersistentFlags().StringVarP(&rootArgs.EnvFile, "env-file", "",
+			"", "Path to a dotenv-style file of flag values (command line flags take precedence)")
+
+		subC.PersistentFlags().StringVarP(&rootArgs.WorkspacePath, "workspace", "",
+			"", "Path to a workspace created by init (default: the workspace containing the working directory)")
+		subC.PersistentFlags().StringVarP(&rootArgs.FlagsDir, "flags-dir", "",
+			"", "Path to a directory of files named after flags holding their values, such as a mounted Kubernetes Secret (flag values are also read from "+EnvVarPrefix+"<FLAG> environment variables)")
+		subC.PersistentFlags().StringVarP(&rootArgs.NotifyConfigPath, "notify-config", "",
+			"", "Path to a file of webhook, Slack and SMTP hooks notified of certificate rotations, credentials created and failed provisioning (default: the notifications of the workspace)")
+		subC.PersistentFlags().BoolVarP(&rootArgs.CI, "ci", "",
+			false, "non-interactive mode for automation: no prompts or progress, JSON output where supported and errors as JSON")
+
+		// populate flags from the environment, flags dir, env file and workspace
+		// and load the hooks before the command resolves its args
+		preRun := subC.PersistentPreRunE
+		subC.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+			if err := loadEnvVars(cmd.Flags()); err != nil {
+				return err
+			}
+			if err := rootArgs.loadFlagsDir(cmd.Flags()); err != nil {
+				return err
+			}
+			if err := rootArgs.loadEnvFile(cmd.Flags()); err != nil {
+				return err
+			}
+			if err := rootArgs.loadWorkspace(cmd); err != nil {
+				return err
+			}
+			if err := rootArgs.loadNotifyConfig(); err != nil {
+				return err
+			}
+			if err := rootArgs.applyCI(cmd); err != nil {
+				return err
+			}
+			if len(rootArgs.Envs) > 0 {
+				rootArgs.Env = strings.Join(rootArgs.Envs, ",")
+			}
+			if preRun != nil {
+				return preRun(cmd, args)
+			}
+			return nil
+		}
+
 		c.AddCommand(subC)
 	}
 }
@@ -113,14 +236,66 @@ func AddCommandWithFlags(c *cobra.Command, rootArgs *RootArgs, cmds ...*cobra.Co
 func (r *RootArgs) Resolve(skipAuth, requireRuntime bool) error {
 
 	if err := r.loadConfig(); err != nil {
-		return err
+		return WithCode(CodeInvalidConfig, err)
+	}
+
+	if r.Verbose {
+		r.TraceHTTP = true
+		r.TraceSteps = true
+		r.TraceTemplates = true
 	}
 
 	if r.IsLegacySaaS && r.IsOPDK {
-		return errors.New("--legacy and --opdk options are exclusive")
+		return WithCode(CodeInvalidFlags, errors.New("--legacy and --opdk options are exclusive"))
+	}
+	if strings.Contains(r.Env, ",") {
+		return WithCode(CodeInvalidFlags, fmt.Errorf("--environment %s: multiple environments are only supported by provision", r.Env))
 	}
 	r.IsGCPManaged = !(r.IsLegacySaaS || r.IsOPDK)
 
+	r.proxyURL = nil
+	if r.ProxyURL != "" {
+		u, err := url.Parse(r.ProxyURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return WithCode(CodeInvalidFlags, fmt.Errorf("--proxy-url %q is not an absolute URL", r.ProxyURL))
+		}
+		r.proxyURL = u
+	}
+
+	r.rootCAs = nil
+	if r.CACert != "" {
+		pool, err := loadCACerts(r.CACert)
+		if err != nil {
+			return WithCode(CodeInvalidFlags, err)
+		}
+		r.rootCAs = pool
+	}
+
+	var err error
+	if r.clientCerts, err = loadClientCert("client-cert", r.ClientCert, "client-key", r.ClientKey); err != nil {
+		return err
+	}
+	if r.runtimeClientCerts, err = loadClientCert("runtime-tls-client-cert", r.RuntimeClientCert,
+		"runtime-tls-client-key", r.RuntimeClientKey); err != nil {
+		return err
+	}
+
+	if strings.Contains(r.RuntimeBase, "=") {
+		if !r.IsGCPManaged {
+			return WithCode(CodeInvalidFlags, errors.New("--runtime per environment only valid for hybrid or Apigee X"))
+		}
+		runtimes, err := parseRuntimes(r.RuntimeBase)
+		if err != nil {
+			return WithCode(CodeInvalidFlags, err)
+		}
+		r.Runtimes = runtimes
+		base, ok := runtimes[r.Env]
+		if !ok {
+			return WithCode(CodeNoRuntime, fmt.Errorf("--runtime has no URL for environment %s", r.Env))
+		}
+		r.RuntimeBase = base
+	}
+
 	if r.ManagementBase == "" {
 		r.ManagementBase = DefaultManagementBase
 	}
@@ -134,15 +309,19 @@ func (r *RootArgs) Resolve(skipAuth, requireRuntime bool) error {
 		}
 	}
 
+	resolveEnvGroups := false // runtime of hybrid and Apigee X from the environment groups, needs auth
 	if requireRuntime {
 		if r.IsLegacySaaS {
 			if r.Org != "" && r.Env != "" {
 				r.RuntimeBase = fmt.Sprintf(RuntimeBaseFormat, r.Org, r.Env)
 			} else if requireRuntime {
-				return fmt.Errorf("--organization and --environment are required")
+				return WithCode(CodeNoOrgEnv, fmt.Errorf("--organization and --environment are required"))
 			}
 		} else if r.RuntimeBase == "" {
-			return errors.New("--runtime is required for hybrid or opdk (or --organization and --environment with --legacy)")
+			if !r.IsGCPManaged || r.Org == "" || r.Env == "" || skipAuth {
+				return WithCode(CodeNoRuntime, errors.New("--runtime is required for hybrid or opdk (or --organization and --environment with --legacy)"))
+			}
+			resolveEnvGroups = true
 		}
 	}
 
@@ -162,10 +341,22 @@ func (r *RootArgs) Resolve(skipAuth, requireRuntime bool) error {
 
 	r.RemoteServiceProxyURL = fmt.Sprintf(remoteServiceProxyURLFormat, r.RuntimeBase)
 
+	var tokenSource apigee.TokenSource
 	if r.IsGCPManaged && !skipAuth && r.Token == "" {
-		return fmt.Errorf("--token is required for hybrid")
+		// fall back to application default credentials
+		var err error
+		client := &http.Client{Transport: r.Transport(false)}
+		if tokenSource, err = defaultTokenSource(r.ServiceAccount, client); err != nil {
+			return WithCode(CodeBadCredentials, err)
+		}
+		if tokenSource == nil {
+			return WithCode(CodeNoCredentials, fmt.Errorf("--token or --service-account is required for hybrid"))
+		}
 	}
 
+	if r.RateLimits == nil {
+		r.RateLimits = apigee.NewRateLimits()
+	}
 	r.ClientOpts = &apigee.EdgeClientOptions{
 		MgmtURL: r.ManagementBase,
 		Org:     r.Org,
@@ -175,14 +366,39 @@ func (r *RootArgs) Resolve(skipAuth, requireRuntime bool) error {
 			Username:    r.Username,
 			Password:    r.Password,
 			BearerToken: r.Token,
+			TokenSource: tokenSource,
 			SkipAuth:    skipAuth,
 		},
 		GCPManaged:         r.IsGCPManaged,
-		Debug:              r.Verbose,
+		Debug:              r.TraceHTTP,
+		StrictDecode:       r.StrictDecode,
 		InsecureSkipVerify: r.InsecureSkipVerify,
+		ProxyURL:           r.proxyURL,
+		RootCAs:            r.rootCAs,
+		ClientCertificates: r.clientCerts,
+		Transport:          r.RoundTripper,
+		Retries:            r.Retries,
+		RetryBackoff:       r.RetryBackoff,
+		Reauthenticate:     r.Reauth,
+		RateLimits:         r.RateLimits,
+	}
+	if r.Explain {
+		if r.Plan == nil {
+			r.Plan = apigee.NewPlan()
+		}
+		r.ClientOpts.Plan = r.Plan
+		// changes are recorded, not sent
+		r.ClientOpts.DryRun = func(format string, args ...interface{}) {}
+	}
+	if r.runtimeClientCerts != nil && r.RoundTripper == nil {
+		// token and rotate requests of the management client go to the runtime
+		r.ClientOpts.Transport = &runtimeRouter{
+			isRuntime:  r.isRuntimeHost,
+			runtime:    r.RuntimeTransport(r.InsecureSkipVerify),
+			management: r.Transport(r.InsecureSkipVerify),
+		}
 	}
 
-	var err error
 	r.ApigeeClient, err = apigee.NewEdgeClient(r.ClientOpts)
 	if err != nil {
 		if strings.Contains(err.Error(), ".netrc") { // no .netrc and no auth
@@ -190,14 +406,159 @@ func (r *RootArgs) Resolve(skipAuth, requireRuntime bool) error {
 			if err != nil {
 				return fmt.Errorf("unable to parse managementBase url %s: %v", r.ManagementBase, err)
 			}
-			return fmt.Errorf("no auth: must have username and password or a ~/.netrc entry for %s", baseURL.Host)
+			return WithCode(CodeNoCredentials, fmt.Errorf("no auth: must have username and password or a ~/.netrc entry for %s", baseURL.Host))
 		}
 		return fmt.Errorf("error initializing Edge client: %v", err)
 	}
 
+	if resolveEnvGroups {
+		var envs []string // all of provision
+		for _, env := range r.Envs {
+			if env = strings.TrimSpace(env); env != "" {
+				envs = append(envs, env)
+			}
+		}
+		if len(envs) <= 1 {
+			envs = []string{r.Env}
+		}
+		if err := r.ResolveEnvGroupRuntimes(envs, Errorf); err != nil {
+			return WithCode(CodeNoRuntime, fmt.Errorf("--runtime not given and not resolved: %v", err))
+		}
+	}
+
 	return nil
 }
 
+// SwitchEnv points the client and the environment's URLs to another
+// environment of the organization, Resolve must have been called
+func (r *RootArgs) SwitchEnv(env string) error {
+	if r.IsLegacySaaS && r.RuntimeBase == fmt.Sprintf(RuntimeBaseFormat, r.Org, r.Env) {
+		r.RuntimeBase = fmt.Sprintf(RuntimeBaseFormat, r.Org, env)
+		r.RemoteServiceProxyURL = fmt.Sprintf(remoteServiceProxyURLFormat, r.RuntimeBase)
+	}
+	if len(r.Runtimes) > 0 {
+		base, ok := r.Runtimes[env]
+		if !ok {
+			return fmt.Errorf("--runtime has no URL for environment %s", env)
+		}
+		r.RuntimeBase = base
+		r.RemoteServiceProxyURL = fmt.Sprintf(remoteServiceProxyURLFormat, r.RuntimeBase)
+	}
+	r.Env = env
+
+	opts := *r.ClientOpts
+	opts.Env = env
+	r.ClientOpts = &opts
+	var err error
+	r.ApigeeClient, err = apigee.NewEdgeClient(r.ClientOpts)
+	return err
+}
+
+// SetRuntimeBase points the runtime URLs to base, eg. once discovered after
+// Resolve
+func (r *RootArgs) SetRuntimeBase(base string) {
+	r.RuntimeBase = base
+	r.RemoteServiceProxyURL = fmt.Sprintf(remoteServiceProxyURLFormat, r.RuntimeBase)
+}
+
+// loadCACerts returns the system's CA pool with the certificates of a PEM file added
+func loadCACerts(file string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("--ca-cert: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("--ca-cert %s has no PEM encoded certificates", file)
+	}
+	return pool, nil
+}
+
+// parseRuntimes parses runtime base URLs given per environment as "env1=URL1,env2=URL2"
+func parseRuntimes(list string) (map[string]string, error) {
+	runtimes := map[string]string{}
+	for _, entry := range strings.Split(list, ",") {
+		parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("--runtime %q: want a URL or env=URL pairs", list)
+		}
+		if _, ok := runtimes[parts[0]]; ok {
+			return nil, fmt.Errorf("--runtime has multiple URLs for environment %s", parts[0])
+		}
+		runtimes[parts[0]] = parts[1]
+	}
+	return runtimes, nil
+}
+
+// Transport returns a transport for runtime requests going through --proxy-url,
+// trusting --ca-cert and presenting --client-cert if set, optionally skipping
+// cert verification, or RoundTripper if set
+func (r *RootArgs) Transport(insecureSkipVerify bool) http.RoundTripper {
+	return r.transport(insecureSkipVerify, r.clientCerts)
+}
+
+// RuntimeTransport returns the RoundTripper of runtime requests, as Transport
+// but presenting the certificate of --runtime-tls-client-cert if given
+func (r *RootArgs) RuntimeTransport(insecureSkipVerify bool) http.RoundTripper {
+	if r.runtimeClientCerts != nil {
+		return r.transport(insecureSkipVerify, r.runtimeClientCerts)
+	}
+	return r.transport(insecureSkipVerify, r.clientCerts)
+}
+
+// RuntimeClient returns a client for runtime requests honoring --insecure
+func (r *RootArgs) RuntimeClient() *http.Client {
+	return &http.Client{Transport: r.RuntimeTransport(r.InsecureSkipVerify)}
+}
+
+func (r *RootArgs) transport(insecureSkipVerify bool, certs []tls.Certificate) http.RoundTripper {
+	if r.RoundTripper != nil {
+		return r.RoundTripper
+	}
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if insecureSkipVerify || r.rootCAs != nil || len(certs) > 0 {
+		tr.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: insecureSkipVerify,
+			RootCAs:            r.rootCAs,
+			Certificates:       certs,
+		}
+	}
+	if r.proxyURL != nil {
+		tr.Proxy = http.ProxyURL(r.proxyURL)
+	}
+	return tr
+}
+
+// Stepf returns a FormatFn tracing the steps of a command to stderr if --trace-steps is set
+func (r *RootArgs) Stepf() FormatFn {
+	if r.TraceSteps {
+		return Errorf
+	}
+	return NoPrintf
+}
+
+// TraceTemplate dumps the data used to render the named template to stderr if --trace-templates is set
+func (r *RootArgs) TraceTemplate(name string, data interface{}) {
+	if !r.TraceTemplates {
+		return
+	}
+	traceTemplate(os.Stderr, name, data)
+}
+
+func traceTemplate(w io.Writer, name string, data interface{}) {
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(data); err != nil {
+		fmt.Fprintf(w, "template %s data: %v\n", name, err)
+		return
+	}
+	fmt.Fprintf(w, "template %s data:\n%s\n", name, buf.String())
+}
+
 // FormatFn formats the supplied arguments according to the format string
 // provided and executes some set of operations with the result.
 type FormatFn func(format string, args ...interface{})
@@ -0,0 +1,86 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// StatsURLFormat is the stats API of a dimension of an environment, as
+	// org, env, dimension (ManagementBase)
+	StatsURLFormat = "/v1/organizations/%s/environments/%s/stats/%s"
+	// StatsTimeRangeFormat is the format of the times of the timeRange
+	StatsTimeRangeFormat = "01/02/2006 15:04"
+)
+
+// StatsResponse is the response of the stats API for a single dimension
+type StatsResponse struct {
+	Environments []struct {
+		Dimensions []struct {
+			Name    string `json:"name"`
+			Metrics []struct {
+				Name   string      `json:"name"`
+				Values []StatValue `json:"values"`
+			} `json:"metrics"`
+		} `json:"dimensions"`
+	} `json:"environments"`
+}
+
+// Totals returns the sum of the values of the metric per dimension
+func (r StatsResponse) Totals(metric string) map[string]float64 {
+	totals := map[string]float64{}
+	for _, env := range r.Environments {
+		for _, dim := range env.Dimensions {
+			for _, m := range dim.Metrics {
+				if m.Name != metric {
+					continue
+				}
+				for _, v := range m.Values {
+					totals[dim.Name] += float64(v)
+				}
+			}
+		}
+	}
+	return totals
+}
+
+// StatValue is a metric value, a string or {timestamp, value} with a time unit
+type StatValue float64
+
+// UnmarshalJSON parses the value of either form
+func (v *StatValue) UnmarshalJSON(data []byte) error {
+	var point struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if len(data) > 0 && data[0] == '{' {
+		if err := json.Unmarshal(data, &point); err != nil {
+			return err
+		}
+		data = point.Value
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("invalid metric value %s", data)
+	}
+	*v = StatValue(f)
+	return nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// TemplateFuncs are the functions of the templates rendered by RenderTemplate,
+// named as in Helm
+var TemplateFuncs = template.FuncMap{
+	"default":  templateDefault,
+	"required": templateRequired,
+	"quote":    strconv.Quote,
+	"indent":   templateIndent,
+	"nindent":  func(n int, s string) string { return "\n" + templateIndent(n, s) },
+	"toYaml":   templateToYAML,
+	"lower":    strings.ToLower,
+	"upper":    strings.ToUpper,
+}
+
+// RenderTemplate renders the template text named name with data
+func RenderTemplate(name, text string, data interface{}) (string, error) {
+	tmp, err := template.New(name).Funcs(TemplateFuncs).Parse(text)
+	if err != nil {
+		return "", errors.Wrapf(err, "parsing template %s", name)
+	}
+	var buf strings.Builder
+	if err := tmp.Execute(&buf, data); err != nil {
+		return "", errors.Wrapf(err, "executing template %s", name)
+	}
+	return buf.String(), nil
+}
+
+// templateDefault returns value, or def if value is empty
+func templateDefault(def, value interface{}) interface{} {
+	if value == nil {
+		return def
+	}
+	if v := reflect.ValueOf(value); v.IsZero() || (v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.Len() == 0 {
+		return def
+	}
+	return value
+}
+
+// templateRequired fails the rendering with msg if value is empty
+func templateRequired(msg string, value interface{}) (interface{}, error) {
+	if value == nil || reflect.ValueOf(value).IsZero() {
+		return nil, fmt.Errorf("%s", msg)
+	}
+	return value, nil
+}
+
+// templateIndent indents each line of s by n spaces
+func templateIndent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.Replace(s, "\n", "\n"+pad, -1)
+}
+
+// templateToYAML marshals value as YAML without a trailing newline
+func templateToYAML(value interface{}) (string, error) {
+	var buf strings.Builder
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(value); err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
+}
@@ -0,0 +1,293 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+)
+
+func TestCACert(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("{}"))
+	}))
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "cacert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(caCert string) (mgmtErr, runtimeErr error) {
+		r := &RootArgs{
+			ManagementBase: ts.URL,
+			RuntimeBase:    ts.URL,
+			Org:            "org",
+			Env:            "test",
+			Token:          "token",
+			CACert:         caCert,
+		}
+		if err := r.Resolve(false, true); err != nil {
+			t.Fatal(err)
+		}
+		req, err := r.ApigeeClient.NewRequest(http.MethodGet, "caches", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, mgmtErr = r.ApigeeClient.Do(req, nil)
+
+		client := &http.Client{Transport: r.Transport(false)}
+		resp, runtimeErr := client.Get(r.RemoteServiceProxyURL + "/certs")
+		if runtimeErr == nil {
+			resp.Body.Close()
+		}
+		return mgmtErr, runtimeErr
+	}
+
+	mgmtErr, runtimeErr := send("")
+	testutil.ErrorContains(t, mgmtErr, "certificate")
+	testutil.ErrorContains(t, runtimeErr, "certificate")
+
+	if mgmtErr, runtimeErr := send(caFile); mgmtErr != nil || runtimeErr != nil {
+		t.Errorf("want CA trusted, got %v, %v", mgmtErr, runtimeErr)
+	}
+
+	r := &RootArgs{RuntimeBase: ts.URL, Token: "token", CACert: filepath.Join(dir, "missing.pem")}
+	testutil.ErrorContains(t, r.Resolve(false, true), "--ca-cert")
+	if err := ioutil.WriteFile(caFile, []byte("not a cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r = &RootArgs{RuntimeBase: ts.URL, Token: "token", CACert: caFile}
+	testutil.ErrorContains(t, r.Resolve(false, true), "has no PEM encoded certificates")
+}
+
+func TestClientCert(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var clients []string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clients = append(clients, r.TLS.PeerCertificates[0].Subject.CommonName)
+		_, _ = w.Write([]byte("{}"))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	defer ts.Close()
+
+	dir, err := ioutil.TempDir("", "clientcert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	for file, block := range map[string]*pem.Block{
+		caFile:   {Type: "CERTIFICATE", Bytes: ts.Certificate().Raw},
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+	} {
+		if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := &RootArgs{
+		ManagementBase: ts.URL,
+		RuntimeBase:    ts.URL,
+		Org:            "org",
+		Env:            "test",
+		Token:          "token",
+		CACert:         caFile,
+		ClientCert:     certFile,
+		ClientKey:      keyFile,
+	}
+	if err := r.Resolve(false, true); err != nil {
+		t.Fatal(err)
+	}
+	req, err := r.ApigeeClient.NewRequest(http.MethodGet, "caches", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ApigeeClient.Do(req, nil); err != nil {
+		t.Errorf("management: want no error, got %v", err)
+	}
+	client := &http.Client{Transport: r.Transport(false)}
+	resp, err := client.Get(r.RemoteServiceProxyURL + "/certs")
+	if err != nil {
+		t.Errorf("runtime: want no error, got %v", err)
+	} else {
+		resp.Body.Close()
+	}
+	if len(clients) != 2 || clients[0] != "client" || clients[1] != "client" {
+		t.Errorf("want client cert presented twice, got %v", clients)
+	}
+
+	r = &RootArgs{RuntimeBase: ts.URL, Token: "token", ClientCert: certFile}
+	testutil.ErrorContains(t, r.Resolve(false, true), "--client-cert and --client-key must be given together")
+	r = &RootArgs{RuntimeBase: ts.URL, Token: "token", ClientCert: certFile, ClientKey: caFile}
+	testutil.ErrorContains(t, r.Resolve(false, true), "--client-cert "+certFile)
+}
+
+func TestRuntimeClientCert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "runtimecert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// key, certificate and CA pool of a client
+	writeClientCert := func(name string) (string, string, *x509.CertPool) {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: name},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certFile := filepath.Join(dir, name+".pem")
+		keyFile := filepath.Join(dir, name+"-key.pem")
+		if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600); err != nil {
+			t.Fatal(err)
+		}
+		pool := x509.NewCertPool()
+		pool.AddCert(cert)
+		return certFile, keyFile, pool
+	}
+	mgmtCert, mgmtKey, mgmtCAs := writeClientCert("management")
+	runtimeCert, runtimeKey, runtimeCAs := writeClientCert("runtime")
+
+	var clients []string
+	newServer := func(clientCAs *x509.CertPool) *httptest.Server {
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clients = append(clients, r.TLS.PeerCertificates[0].Subject.CommonName)
+			_, _ = w.Write([]byte(`{"keys":[]}`))
+		}))
+		ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+		ts.StartTLS()
+		return ts
+	}
+	mgmt := newServer(mgmtCAs)
+	defer mgmt.Close()
+	runtime := newServer(runtimeCAs)
+	defer runtime.Close()
+
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: mgmt.Certificate().Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: runtime.Certificate().Raw})...)
+	if err := ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &RootArgs{
+		ManagementBase:    mgmt.URL,
+		RuntimeBase:       runtime.URL,
+		Org:               "org",
+		Env:               "test",
+		Token:             "token",
+		CACert:            caFile,
+		ClientCert:        mgmtCert,
+		ClientKey:         mgmtKey,
+		RuntimeClientCert: runtimeCert,
+		RuntimeClientKey:  runtimeKey,
+	}
+	if err := r.Resolve(false, true); err != nil {
+		t.Fatal(err)
+	}
+	req, err := r.ApigeeClient.NewRequest(http.MethodGet, "caches", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ApigeeClient.Do(req, nil); err != nil {
+		t.Errorf("management: want no error, got %v", err)
+	}
+	// token and rotate requests of the management client go to the runtime
+	req, err = r.ApigeeClient.NewRequestNoEnv(http.MethodPost, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL, err = req.URL.Parse(r.RemoteServiceProxyURL + "/token"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ApigeeClient.Do(req, nil); err != nil {
+		t.Errorf("runtime via management client: want no error, got %v", err)
+	}
+	resp, err := r.RuntimeClient().Get(r.RemoteServiceProxyURL + "/certs")
+	if err != nil {
+		t.Errorf("runtime: want no error, got %v", err)
+	} else {
+		resp.Body.Close()
+	}
+	if want := []string{"management", "runtime", "runtime"}; len(clients) != 3 || clients[0] != want[0] || clients[1] != want[1] || clients[2] != want[2] {
+		t.Errorf("want client certs %v, got %v", want, clients)
+	}
+
+	r = &RootArgs{RuntimeBase: runtime.URL, Token: "token", RuntimeClientKey: runtimeKey}
+	testutil.ErrorContains(t, r.Resolve(false, true), "--runtime-tls-client-cert and --runtime-tls-client-key must be given together")
+}
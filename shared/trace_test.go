@@ -0,0 +1,63 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestVerboseEnablesTraces(t *testing.T) {
+	r := &RootArgs{Verbose: true, IsLegacySaaS: true, Org: "org", Env: "env", Username: "u", Password: "p"}
+	if err := r.Resolve(false, true); err != nil {
+		t.Fatal(err)
+	}
+	if !r.TraceHTTP || !r.TraceSteps || !r.TraceTemplates {
+		t.Errorf("want all traces enabled, got http: %t, steps: %t, templates: %t",
+			r.TraceHTTP, r.TraceSteps, r.TraceTemplates)
+	}
+	if !r.ClientOpts.Debug {
+		t.Errorf("want client debug")
+	}
+
+	r = &RootArgs{TraceSteps: true, IsLegacySaaS: true, Org: "org", Env: "env", Username: "u", Password: "p"}
+	if err := r.Resolve(false, true); err != nil {
+		t.Fatal(err)
+	}
+	if r.TraceHTTP || r.TraceTemplates || r.ClientOpts.Debug {
+		t.Errorf("want only steps traced")
+	}
+	if reflect.ValueOf(r.Stepf()).Pointer() != reflect.ValueOf(Errorf).Pointer() {
+		t.Errorf("want Stepf to be Errorf")
+	}
+	r.TraceSteps = false
+	if reflect.ValueOf(r.Stepf()).Pointer() != reflect.ValueOf(NoPrintf).Pointer() {
+		t.Errorf("want Stepf to be NoPrintf")
+	}
+}
+
+func TestTraceTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	traceTemplate(&buf, "products", struct {
+		Bound []string `yaml:"bound"`
+	}{
+		Bound: []string{"a", "b"},
+	})
+	want := "template products data:\nbound:\n  - a\n  - b\n\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
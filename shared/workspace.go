@@ -0,0 +1,206 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// WorkspaceFile marks the root of a workspace and holds its context
+	WorkspaceFile = "apigee-rs.yaml"
+
+	// SkipWorkspaceAnnotation on a command disables loading the workspace
+	SkipWorkspaceAnnotation = "skip-workspace"
+
+	workspaceProvisionFile = "provision.json"
+	workspaceConfigFile    = "config.yaml" // contains credentials, ignored by git
+	workspaceSamplesDir    = "samples"
+	workspaceGitignore     = "*/*/" + workspaceConfigFile + "\n"
+)
+
+// Workspace is a directory holding the artifacts of an org/env in <org>/<env>.
+// Commands run within a workspace take their context from it for any flags
+// not given on the command line.
+type Workspace struct {
+	Dir string `yaml:"-"`
+
+	Organization string `yaml:"organization,omitempty"`
+	Environment  string `yaml:"environment,omitempty"`
+	Runtime      string `yaml:"runtime,omitempty"`
+	Namespace    string `yaml:"namespace,omitempty"`
+	Platform     string `yaml:"platform,omitempty"`
+
+	// client certificate for mTLS to the runtime, relative to Dir
+	RuntimeTLSClientCert string `yaml:"runtimeTLSClientCert,omitempty"`
+	RuntimeTLSClientKey  string `yaml:"runtimeTLSClientKey,omitempty"`
+
+	// hooks notified of events, files relative to Dir
+	Notifications *NotifyConfig `yaml:"notifications,omitempty"`
+}
+
+// FindWorkspace returns the workspace containing dir, or nil if there is none
+func FindWorkspace(dir string) (*Workspace, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, WorkspaceFile)); err == nil {
+			return LoadWorkspace(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// LoadWorkspace loads the workspace rooted at dir
+func LoadWorkspace(dir string) (*Workspace, error) {
+	file := filepath.Join(dir, WorkspaceFile)
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading workspace")
+	}
+	ws := &Workspace{}
+	if err := yaml.Unmarshal(data, ws); err != nil {
+		return nil, errors.Wrapf(err, "parsing workspace %s", file)
+	}
+	ws.Dir = dir
+	return ws, nil
+}
+
+// Save writes the workspace file and the workspace .gitignore
+func (w *Workspace) Save() error {
+	if err := os.MkdirAll(w.Dir, 0755); err != nil {
+		return errors.Wrapf(err, "creating workspace %s", w.Dir)
+	}
+	data, err := yaml.Marshal(w)
+	if err != nil {
+		return err
+	}
+	data = append([]byte("# apigee-remote-service-cli workspace\n"), data...)
+	if err := ioutil.WriteFile(filepath.Join(w.Dir, WorkspaceFile), data, 0644); err != nil {
+		return errors.Wrap(err, "writing workspace")
+	}
+	if err := ioutil.WriteFile(filepath.Join(w.Dir, ".gitignore"), []byte(workspaceGitignore), 0644); err != nil {
+		return errors.Wrap(err, "writing workspace")
+	}
+	return nil
+}
+
+// ArtifactDir returns the directory of the artifacts of an org/env
+func (w *Workspace) ArtifactDir(org, env string) string {
+	return filepath.Join(w.Dir, org, env)
+}
+
+// ProvisionFile returns the path of the provision result of an org/env
+func (w *Workspace) ProvisionFile(org, env string) string {
+	return filepath.Join(w.ArtifactDir(org, env), workspaceProvisionFile)
+}
+
+// SamplesDir returns the directory of the samples of an org/env
+func (w *Workspace) SamplesDir(org, env string) string {
+	return filepath.Join(w.ArtifactDir(org, env), workspaceSamplesDir)
+}
+
+// SaveProvisionResult stores the result of a provision run. The
+// Kubernetes resources, which may hold credentials, are written to a
+// separate file that is private to the user and ignored by git.
+func (w *Workspace) SaveProvisionResult(result ProvisionResult) (string, error) {
+	dir := w.ArtifactDir(result.Organization, result.Environment)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "creating %s", dir)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, workspaceConfigFile), []byte(result.Resources), 0600); err != nil {
+		return "", errors.Wrapf(err, "writing %s", workspaceConfigFile)
+	}
+	result.Resources = ""
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, workspaceProvisionFile), data, 0644); err != nil {
+		return "", errors.Wrapf(err, "writing %s", workspaceProvisionFile)
+	}
+	return dir, nil
+}
+
+// loadWorkspace finds the workspace and populates any flags not
+// explicitly set from its context
+func (r *RootArgs) loadWorkspace(cmd *cobra.Command) error {
+	if cmd.Annotations[SkipWorkspaceAnnotation] != "" {
+		return nil
+	}
+
+	var err error
+	if r.WorkspacePath != "" {
+		r.Workspace, err = LoadWorkspace(r.WorkspacePath)
+	} else {
+		r.Workspace, err = FindWorkspace(".")
+	}
+	if err != nil || r.Workspace == nil {
+		return err
+	}
+
+	ws := r.Workspace
+	values := map[string]string{
+		"organization": ws.Organization,
+		"environment":  ws.Environment,
+		"runtime":      ws.Runtime,
+		"namespace":    ws.Namespace,
+
+		"runtime-tls-client-cert": ws.path(ws.RuntimeTLSClientCert),
+		"runtime-tls-client-key":  ws.path(ws.RuntimeTLSClientKey),
+	}
+	flags := cmd.Flags()
+	if !flags.Changed("legacy") && !flags.Changed("opdk") {
+		switch ws.Platform {
+		case PlatformSaaS:
+			values["legacy"] = "true"
+		case PlatformOPDK:
+			values["opdk"] = "true"
+		}
+	}
+
+	for name, value := range values {
+		flag := flags.Lookup(name)
+		if value == "" || flag == nil || flag.Changed {
+			continue
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("workspace %s: invalid %s: %v", ws.Dir, name, err)
+		}
+	}
+	return nil
+}
+
+// path resolves a file of the workspace relative to its directory
+func (ws *Workspace) path(file string) string {
+	if file == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(ws.Dir, file)
+}
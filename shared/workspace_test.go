@@ -0,0 +1,127 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shared
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apigee/apigee-remote-service-cli/testutil"
+	"github.com/spf13/cobra"
+)
+
+func TestWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ws := &Workspace{Dir: dir, Organization: "org", Environment: "env", Platform: PlatformOPDK}
+	if err := ws.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	nested := filepath.Join(dir, "org", "env")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatal(err)
+	}
+	found, err := FindWorkspace(nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil || found.Dir != dir || found.Organization != "org" || found.Platform != PlatformOPDK {
+		t.Errorf("want workspace %v, got %v", ws, found)
+	}
+
+	dirSaved, err := ws.SaveProvisionResult(ProvisionResult{Organization: "org", Environment: "env", Resources: "secret: stuff"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dirSaved != nested {
+		t.Errorf("want %s, got %s", nested, dirSaved)
+	}
+	result, err := ReadProvisionResult(ws.ProvisionFile("org", "env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Resources != "" {
+		t.Errorf("want resources saved separately")
+	}
+	info, err := os.Stat(filepath.Join(nested, workspaceConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("want config private, got %v", info.Mode().Perm())
+	}
+
+	ws.RuntimeTLSClientCert = "certs/runtime.pem"
+	if got := ws.path(ws.RuntimeTLSClientCert); got != filepath.Join(dir, "certs", "runtime.pem") {
+		t.Errorf("want cert relative to the workspace, got %s", got)
+	}
+	if got := ws.path("/etc/runtime.pem"); got != "/etc/runtime.pem" {
+		t.Errorf("want absolute path kept, got %s", got)
+	}
+}
+
+func TestLoadWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "workspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ws := &Workspace{Dir: dir, Organization: "wsorg", Environment: "wsenv", Namespace: "wsns", Platform: PlatformSaaS}
+	if err := ws.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(args ...string) (*RootArgs, error) {
+		r := &RootArgs{}
+		root := &cobra.Command{Use: "root"}
+		c := &cobra.Command{
+			Use:  "test",
+			RunE: func(cmd *cobra.Command, args []string) error { return nil },
+		}
+		c.Flags().BoolVarP(&r.IsLegacySaaS, "legacy", "", false, "")
+		c.Flags().BoolVarP(&r.IsOPDK, "opdk", "", false, "")
+		c.Flags().StringVarP(&r.Namespace, "namespace", "n", "apigee", "")
+		AddCommandWithFlags(root, r, c)
+		root.SetArgs(append([]string{"test"}, args...))
+		return r, root.Execute()
+	}
+
+	r, err := run("--workspace", dir, "-e", "flagenv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Workspace == nil || r.Org != "wsorg" || r.Env != "flagenv" || r.Namespace != "wsns" || !r.IsLegacySaaS {
+		t.Errorf("unexpected args: org %s, env %s, namespace %s, legacy %t", r.Org, r.Env, r.Namespace, r.IsLegacySaaS)
+	}
+
+	r, err = run("--workspace", dir, "--opdk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.IsLegacySaaS || !r.IsOPDK {
+		t.Errorf("want explicit platform to take precedence")
+	}
+
+	_, err = run("--workspace", filepath.Join(dir, "missing"))
+	testutil.ErrorContains(t, err, "reading workspace")
+}
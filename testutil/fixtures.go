@@ -0,0 +1,78 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Fixture is a management API exchange recorded by devproxy. As with an
+// httpmock responder, the request is matched by method and URL and answered
+// with the status and body.
+type Fixture struct {
+	Method      string `json:"method"`
+	URL         string `json:"url"` // path and query
+	RequestBody string `json:"requestBody,omitempty"`
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        string `json:"body"`
+}
+
+// LoadFixtures reads the fixtures of a file written by devproxy
+func LoadFixtures(file string) ([]Fixture, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var fixtures []Fixture
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, err
+	}
+	return fixtures, nil
+}
+
+// FixtureHandler replays fixtures. Requests recorded more than once, such
+// as polled deployments, are answered in order, the last one repeating.
+// Unrecorded requests are answered 404.
+func FixtureHandler(fixtures []Fixture) http.Handler {
+	var mu sync.Mutex
+	recorded := map[string][]Fixture{}
+	for _, f := range fixtures {
+		key := f.Method + " " + f.URL
+		recorded[key] = append(recorded[key], f)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Method + " " + r.URL.RequestURI()
+		mu.Lock()
+		answers := recorded[key]
+		if len(answers) > 1 {
+			recorded[key] = answers[1:]
+		}
+		mu.Unlock()
+		if len(answers) == 0 {
+			http.Error(w, "no fixture for "+key, http.StatusNotFound)
+			return
+		}
+		f := answers[0]
+		if f.ContentType != "" {
+			w.Header().Set("Content-Type", f.ContentType)
+		}
+		w.WriteHeader(f.Status)
+		_, _ = w.Write([]byte(f.Body))
+	})
+}
@@ -0,0 +1,52 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutil
+
+import (
+	"net/http"
+	"strings"
+)
+
+var kubeDiscovery = map[string]string{
+	"/api": `{"kind":"APIVersions","versions":["v1"]}`,
+	"/apis": `{"kind":"APIGroupList","groups":[
+  {"name":"apigee.cloud.google.com","versions":[{"groupVersion":"apigee.cloud.google.com/v1alpha1","version":"v1alpha1"}],
+   "preferredVersion":{"groupVersion":"apigee.cloud.google.com/v1alpha1","version":"v1alpha1"}},
+  {"name":"bitnami.com","versions":[{"groupVersion":"bitnami.com/v1alpha1","version":"v1alpha1"}],
+   "preferredVersion":{"groupVersion":"bitnami.com/v1alpha1","version":"v1alpha1"}}]}`,
+	"/api/v1": `{"kind":"APIResourceList","groupVersion":"v1","resources":[
+  {"name":"configmaps","singularName":"","namespaced":true,"kind":"ConfigMap","verbs":["get","list","patch","delete"]},
+  {"name":"secrets","singularName":"","namespaced":true,"kind":"Secret","verbs":["get","list","patch","delete"]},
+  {"name":"pods","singularName":"","namespaced":true,"kind":"Pod","verbs":["get","list"]},
+  {"name":"namespaces","singularName":"","namespaced":false,"kind":"Namespace","verbs":["get","list","patch","delete"]}]}`,
+	"/apis/apigee.cloud.google.com/v1alpha1": `{"kind":"APIResourceList","groupVersion":"apigee.cloud.google.com/v1alpha1","resources":[
+  {"name":"apigeeremoteservices","singularName":"apigeeremoteservice","namespaced":true,"kind":"ApigeeRemoteService","verbs":["get","list","patch","delete"]}]}`,
+	"/apis/bitnami.com/v1alpha1": `{"kind":"APIResourceList","groupVersion":"bitnami.com/v1alpha1","resources":[
+  {"name":"sealedsecrets","singularName":"sealedsecret","namespaced":true,"kind":"SealedSecret","verbs":["get","list","patch","delete"]}]}`,
+}
+
+// KubeDiscovery wraps the handler of a fake Kubernetes API server to serve
+// the discovery of ConfigMaps, Secrets, Pods, Namespaces, SealedSecrets
+// and ApigeeRemoteServices
+func KubeDiscovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if doc, ok := kubeDiscovery[strings.TrimSuffix(r.URL.Path, "/")]; ok && r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(doc))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}